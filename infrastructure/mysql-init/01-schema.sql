@@ -6,6 +6,9 @@ CREATE TABLE IF NOT EXISTS users (
     username VARCHAR(50) NOT NULL,
     email VARCHAR(255) NOT NULL,
     global_opt_in BOOLEAN NOT NULL DEFAULT TRUE,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, suspended or deleted
+    region VARCHAR(16) NOT NULL DEFAULT '', -- region the user's data lives in, empty for the default region
+    timezone VARCHAR(64) NOT NULL DEFAULT '', -- IANA time zone delivery windows are applied in, empty for unknown
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     UNIQUE KEY unique_username (username),
@@ -50,6 +53,148 @@ CREATE TABLE IF NOT EXISTS user_contact_info (
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 );
 
+-- Entities users follow, such as threads, projects or authors. The entity
+-- index answers who follows an entity, in user order for paging.
+CREATE TABLE IF NOT EXISTS user_subscriptions (
+    user_id VARCHAR(36) NOT NULL,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(191) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, entity_type, entity_id),
+    INDEX idx_user_subscriptions_entity (entity_type, entity_id, user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Snoozes a user set, of one event type or of all notifications when
+-- event_type is empty. Expired rows are ignored and replaced on the next snooze.
+CREATE TABLE IF NOT EXISTS user_snoozes (
+    user_id VARCHAR(36) NOT NULL,
+    event_type VARCHAR(50) NOT NULL DEFAULT '',
+    snoozed_until TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, event_type),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Audit trail of user data lifecycle requests (deletion, export).
+-- Kept without a foreign key so records survive the user's deletion.
+CREATE TABLE IF NOT EXISTS user_data_audit (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    requested_by VARCHAR(255) NOT NULL,
+    requested_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_data_audit_user (user_id)
+);
+
+-- Daily notification volume per API key and tenant, added to by every
+-- rate limiter instance and billed to the teams owning the keys
+CREATE TABLE IF NOT EXISTS usage_rollups (
+    day DATE NOT NULL,
+    api_key_id VARCHAR(64) NOT NULL DEFAULT '',
+    tenant VARCHAR(255) NOT NULL DEFAULT '',
+    accepted BIGINT NOT NULL DEFAULT 0,
+    delivered BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (day, api_key_id, tenant)
+);
+
+-- Batches of counts added to usage_rollups, so a retried batch is added once
+CREATE TABLE IF NOT EXISTS usage_batches (
+    token CHAR(32) PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL,
+    INDEX idx_usage_batches_applied (applied_at)
+);
+
+-- Days whose usage was posted to the billing webhook, claimed by one instance
+CREATE TABLE IF NOT EXISTS usage_exports (
+    day DATE PRIMARY KEY,
+    exported_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Dead letters requeued or purged through the rate limiter's admin API
+CREATE TABLE IF NOT EXISTS dead_letter_audit (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    action VARCHAR(20) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    partition_id INT NOT NULL,
+    offsets TEXT NOT NULL,
+    detail VARCHAR(255) NOT NULL,
+    requested_by VARCHAR(255) NOT NULL,
+    requested_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Notifications the rate limiter delivered under a bypass of rate limits and opt-outs
+CREATE TABLE IF NOT EXISTS bypass_audit (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    notification_id VARCHAR(255) NOT NULL,
+    request_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    event_type VARCHAR(255) NOT NULL,
+    api_key_id VARCHAR(255) NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    channels VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_bypass_audit_user (user_id)
+);
+
+-- Named rate-limit profiles tuned through the rate limiter's admin API
+CREATE TABLE IF NOT EXISTS rate_limit_profiles (
+    name VARCHAR(64) PRIMARY KEY,
+    window_seconds INT NOT NULL DEFAULT 0,
+    limits JSON NOT NULL,
+    event_type_limits JSON NOT NULL,
+    updated_by VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Profiles assigned to tenants per environment, an empty tenant is the environment's default
+CREATE TABLE IF NOT EXISTS rate_limit_profile_assignments (
+    environment VARCHAR(64) NOT NULL,
+    tenant VARCHAR(255) NOT NULL DEFAULT '',
+    profile VARCHAR(64) NOT NULL,
+    updated_by VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (environment, tenant),
+    FOREIGN KEY (profile) REFERENCES rate_limit_profiles(name)
+);
+
+-- Email addresses, phone numbers and users never contacted, an empty tenant is the global list
+CREATE TABLE IF NOT EXISTS suppression_list (
+    tenant VARCHAR(255) NOT NULL DEFAULT '',
+    kind VARCHAR(16) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    reason VARCHAR(64) NOT NULL,
+    source VARCHAR(64) NOT NULL,
+    note VARCHAR(1024) NOT NULL DEFAULT '',
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, kind, value)
+);
+
+-- Operations performed through the admin APIs of the pipeline, only ever appended to
+CREATE TABLE IF NOT EXISTS admin_audit (
+    seq BIGINT AUTO_INCREMENT PRIMARY KEY,
+    id VARCHAR(255) NOT NULL UNIQUE,
+    service VARCHAR(64) NOT NULL,
+    instance_id VARCHAR(255) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    path VARCHAR(1024) NOT NULL,
+    query VARCHAR(2048) NOT NULL,
+    parameters JSON NULL,
+    status INT NOT NULL,
+    performed_at TIMESTAMP NOT NULL,
+    INDEX idx_admin_audit_performed (performed_at),
+    INDEX idx_admin_audit_actor (actor)
+);
+
+CREATE TRIGGER admin_audit_no_update BEFORE UPDATE ON admin_audit
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'admin_audit is append-only';
+
+CREATE TRIGGER admin_audit_no_delete BEFORE DELETE ON admin_audit
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'admin_audit is append-only';
+
 -- Insert sample users with global opt-in status
 INSERT INTO users (id, username, email, global_opt_in) VALUES 
 ('user-001', 'user1', 'user1@example.com', TRUE),
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+)
+
+// HTTP server for health checks and the archiver's progress
+type Server struct {
+	server   *http.Server
+	archived func() int64
+}
+
+// Creates a new HTTP server
+func NewServer(cfg config.ServerConfig, archived func() int64) *Server {
+	mux := http.NewServeMux()
+
+	server := Server{
+		server: &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.Port),
+			Handler:      mux,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		},
+		archived: archived,
+	}
+
+	// Routes
+	mux.HandleFunc("/health", server.handleHealth)
+	mux.HandleFunc("GET /version", server.handleVersion)
+
+	return &server
+}
+
+// Starts the HTTP server
+func (s *Server) Start() error {
+	return s.server.ListenAndServe()
+}
+
+// Gracefully shuts down the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Handles requests for the version of the running code
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
+
+// Handles health check requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":   "ok",
+		"time":     time.Now().Format(time.RFC3339),
+		"archived": s.archived(),
+	})
+}
@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Batch collects the records of a topic partition in one hour into an
+// object, compressing them as they are added
+type Batch struct {
+	Topic       string
+	Partition   int32
+	Hour        time.Time // Start of the hour the records' timestamps fall in
+	FirstOffset int64
+	LastOffset  int64
+
+	records int
+	started time.Time
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	encoder *json.Encoder
+}
+
+// NewBatch creates an empty batch for a topic partition in an hour
+func NewBatch(topic string, partition int32, hour time.Time) *Batch {
+	b := &Batch{
+		Topic:     topic,
+		Partition: partition,
+		Hour:      hour.UTC().Truncate(time.Hour),
+		started:   time.Now(),
+	}
+	b.gz = gzip.NewWriter(&b.buf)
+	b.encoder = json.NewEncoder(b.gz)
+	b.encoder.SetEscapeHTML(false) // Keeps inlined values byte for byte
+	return b
+}
+
+// Add appends a record as a line
+func (b *Batch) Add(record Record) error {
+	if err := b.encoder.Encode(record); err != nil {
+		return fmt.Errorf("failed to encode record at offset %d: %w", record.Offset, err)
+	}
+	if b.records == 0 {
+		b.FirstOffset = record.Offset
+	}
+	b.LastOffset = record.Offset
+	b.records++
+	return nil
+}
+
+// Len returns the number of records added
+func (b *Batch) Len() int {
+	return b.records
+}
+
+// Size returns the compressed size of the records added so far, short of
+// what the compressor still buffers
+func (b *Batch) Size() int {
+	return b.buf.Len()
+}
+
+// Age returns how long ago the batch was created
+func (b *Batch) Age() time.Duration {
+	return time.Since(b.started)
+}
+
+// Key returns the key of the batch's object under prefix
+func (b *Batch) Key(prefix string) string {
+	return ObjectKey(prefix, b.Topic, b.Hour, b.Partition, b.FirstOffset)
+}
+
+// Close finishes the compressed stream and returns the object
+func (b *Batch) Close() ([]byte, error) {
+	if err := b.gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress batch: %w", err)
+	}
+	return b.buf.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of the day and hour partitions in object keys
+const (
+	DayLayout  = "2006-01-02"
+	hourLayout = "15"
+)
+
+// Extension of archived objects
+const extension = ".jsonl.gz"
+
+// TopicPrefix returns the prefix of the objects of a topic
+func TopicPrefix(prefix, topic string) string {
+	return prefix + topic + "/"
+}
+
+// DayPrefix returns the prefix of the objects of a topic on a day
+func DayPrefix(prefix, topic string, day time.Time) string {
+	return TopicPrefix(prefix, topic) + "dt=" + day.UTC().Format(DayLayout) + "/"
+}
+
+// HourPrefix returns the prefix of the objects of a topic in an hour
+func HourPrefix(prefix, topic string, hour time.Time) string {
+	return DayPrefix(prefix, topic, hour) + "hour=" + hour.UTC().Format(hourLayout) + "/"
+}
+
+// ObjectKey returns the key of the object holding the messages of a
+// partition in an hour, from the first offset on. Archiving the same
+// messages again writes the same key.
+func ObjectKey(prefix, topic string, hour time.Time, partition int32, firstOffset int64) string {
+	return fmt.Sprintf("%s%d-%d%s", HourPrefix(prefix, topic, hour), partition, firstOffset, extension)
+}
+
+// ParseObjectKey returns the partition and first offset of the object
+// under a key returned by ObjectKey
+func ParseObjectKey(key string) (int32, int64, bool) {
+	name := key[strings.LastIndex(key, "/")+1:]
+	partition, firstOffset, ok := strings.Cut(strings.TrimSuffix(name, extension), "-")
+	if !ok || !strings.HasSuffix(name, extension) {
+		return 0, 0, false
+	}
+	p, err := strconv.ParseInt(partition, 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	offset, err := strconv.ParseInt(firstOffset, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int32(p), offset, true
+}
+
+// Hours returns the start of every hour overlapping [from, to)
+func Hours(from, to time.Time) []time.Time {
+	var hours []time.Time
+	for hour := from.UTC().Truncate(time.Hour); hour.Before(to); hour = hour.Add(time.Hour) {
+		hours = append(hours, hour)
+	}
+	return hours
+}
+
+// ParseDayPrefix returns the day of a prefix returned by DayPrefix
+func ParseDayPrefix(dayPrefix string) (time.Time, bool) {
+	_, partition, ok := strings.Cut(strings.TrimSuffix(dayPrefix, "/"), "dt=")
+	if !ok {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(DayLayout, partition)
+	return day, err == nil
+}
@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Filter selects the archived records of a topic a restore reads
+type Filter struct {
+	From       time.Time         // Records timestamped from then on
+	To         time.Time         // Records timestamped before then
+	Partitions []int32           // All partitions when empty
+	Keys       []string          // Any key when empty
+	Headers    map[string]string // Headers records must have, with these values
+}
+
+// Match reports whether a record is selected
+func (f Filter) Match(record Record) bool {
+	if record.Timestamp.Before(f.From) || !record.Timestamp.Before(f.To) {
+		return false
+	}
+	if len(f.Partitions) > 0 && !slices.Contains(f.Partitions, record.Partition) {
+		return false
+	}
+	if len(f.Keys) > 0 && !slices.Contains(f.Keys, record.Key) {
+		return false
+	}
+	for name, value := range f.Headers {
+		if record.Headers[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Read calls fn with the records of a topic the filter selects, hour by
+// hour and, within an hour, partition by partition in offset order. Records
+// archived more than once are read once.
+func Read(ctx context.Context, store *Store, prefix, topic string, filter Filter, fn func(Record) error) error {
+	read := make(map[int32]*spans) // Offsets read by partition
+	for _, hour := range Hours(filter.From, filter.To) {
+		type object struct {
+			key         string
+			partition   int32
+			firstOffset int64
+		}
+		var objects []object
+		err := store.List(ctx, HourPrefix(prefix, topic, hour), true, func(key string) error {
+			partition, firstOffset, ok := ParseObjectKey(key)
+			if ok && (len(filter.Partitions) == 0 || slices.Contains(filter.Partitions, partition)) {
+				objects = append(objects, object{key, partition, firstOffset})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(objects, func(i, j int) bool {
+			if objects[i].partition != objects[j].partition {
+				return objects[i].partition < objects[j].partition
+			}
+			return objects[i].firstOffset < objects[j].firstOffset
+		})
+
+		for _, o := range objects {
+			if read[o.partition] == nil {
+				read[o.partition] = &spans{}
+			}
+			if err := readObject(ctx, store, o.key, read[o.partition], filter, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readObject calls fn with the records of an object the filter selects,
+// skipping the offsets already read, then adds the object's to them
+func readObject(ctx context.Context, store *Store, key string, read *spans, filter Filter, fn func(Record) error) error {
+	object, err := store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	gz, err := gzip.NewReader(object)
+	if err != nil {
+		return fmt.Errorf("failed to decompress object %s: %w", key, err)
+	}
+	defer gz.Close()
+
+	first, last := int64(-1), int64(-1)
+	decoder := json.NewDecoder(gz)
+	for {
+		var record Record
+		if err := decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read object %s: %w", key, err)
+		}
+		if first < 0 {
+			first = record.Offset
+		}
+		last = record.Offset
+
+		if read.contains(record.Offset) || !filter.Match(record) {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if first >= 0 {
+		read.add(first, last)
+	}
+	return nil
+}
+
+// spans holds ranges of offsets, sorted and merged
+type spans [][2]int64
+
+// contains reports whether an offset is in one of the ranges
+func (s spans) contains(offset int64) bool {
+	i := sort.Search(len(s), func(i int) bool { return s[i][1] >= offset })
+	return i < len(s) && s[i][0] <= offset
+}
+
+// add adds the range from first to last
+func (s *spans) add(first, last int64) {
+	merged := append(*s, [2]int64{first, last})
+	sort.Slice(merged, func(i, j int) bool { return merged[i][0] < merged[j][0] })
+
+	n := 0
+	for _, span := range merged[1:] {
+		if span[0] <= merged[n][1]+1 {
+			merged[n][1] = max(merged[n][1], span[1])
+			continue
+		}
+		n++
+		merged[n] = span
+	}
+	*s = merged[:n+1]
+}
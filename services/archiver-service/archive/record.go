@@ -0,0 +1,56 @@
+// Package archive keeps the messages of the pipeline's topics in object
+// storage, beyond the retention of Kafka. Messages are archived as records in
+// gzipped JSON Lines objects, partitioned by topic, day and hour:
+//
+//	{prefix}{topic}/dt=2026-10-16/hour=09/{partition}-{first offset}.jsonl.gz
+//
+// Query engines read the dt and hour partitions from the keys, and restores
+// only list the hours they need.
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Record is an archived message, with what is needed to produce it again
+type Record struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"` // With the topic and partition, identifies a message archived more than once
+	Timestamp time.Time         `json:"timestamp"`
+	Key       string            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`   // Signatures included, so restored messages still verify
+	Value     json.RawMessage   `json:"value,omitempty"`     // Messages holding compact JSON, as they are
+	RawValue  []byte            `json:"raw_value,omitempty"` // Other messages, base64 encoded
+}
+
+// NewRecord creates the record of a message
+func NewRecord(topic string, partition int32, offset int64, timestamp time.Time, key, value []byte, headers map[string]string) Record {
+	record := Record{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		Timestamp: timestamp.UTC(),
+		Key:       string(key),
+		Headers:   headers,
+	}
+	// Values are inlined only when encoding leaves their bytes as they are,
+	// so signatures over them still verify
+	var compacted bytes.Buffer
+	if json.Compact(&compacted, value) == nil && bytes.Equal(compacted.Bytes(), value) {
+		record.Value = json.RawMessage(value)
+	} else {
+		record.RawValue = value
+	}
+	return record
+}
+
+// Payload returns the value the message was produced with
+func (r Record) Payload() []byte {
+	if r.Value != nil {
+		return r.Value
+	}
+	return r.RawValue
+}
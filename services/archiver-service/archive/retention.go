@@ -0,0 +1,94 @@
+package archive
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Janitor deletes the days of archived topics older than the retention.
+// Every instance may run one, deleting the same days twice does no harm.
+type Janitor struct {
+	store     *Store
+	prefix    string
+	topics    []string
+	retention time.Duration
+	interval  time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewJanitor creates a janitor keeping the days of the topics for
+// retention, and starts sweeping every interval
+func NewJanitor(store *Store, prefix string, topics []string, retention, interval time.Duration) *Janitor {
+	j := &Janitor{
+		store:     store,
+		prefix:    prefix,
+		topics:    topics,
+		retention: retention,
+		interval:  interval,
+		done:      make(chan struct{}),
+	}
+
+	j.wg.Add(1)
+	go j.run()
+
+	return j
+}
+
+// run sweeps at start and every interval until the janitor is closed
+func (j *Janitor) run() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), j.interval)
+		j.sweep(ctx)
+		cancel()
+
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep deletes the days that ended longer than the retention ago. Failures
+// are logged, the days are tried again on the next sweep.
+func (j *Janitor) sweep(ctx context.Context) {
+	cutoff := time.Now().Add(-j.retention)
+	for _, topic := range j.topics {
+		var expired []string
+		err := j.store.List(ctx, TopicPrefix(j.prefix, topic), false, func(dayPrefix string) error {
+			if day, ok := ParseDayPrefix(dayPrefix); ok && day.Add(24*time.Hour).Before(cutoff) {
+				expired = append(expired, dayPrefix)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to list archived days of %s: %v", topic, err)
+			continue
+		}
+
+		for _, dayPrefix := range expired {
+			removed, err := j.store.Remove(ctx, dayPrefix)
+			if err != nil {
+				log.Printf("Failed to delete archived day %s, %d objects deleted: %v", dayPrefix, removed, err)
+				continue
+			}
+			log.Printf("Deleted %d objects of archived day %s past the retention of %v", removed, dayPrefix, j.retention)
+		}
+	}
+}
+
+// Close stops sweeping
+func (j *Janitor) Close() error {
+	close(j.done)
+	j.wg.Wait()
+	return nil
+}
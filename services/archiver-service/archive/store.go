@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Object storage config. Any S3 compatible storage works: S3 itself, minio,
+// or Google Cloud Storage through its XML API at storage.googleapis.com with
+// HMAC keys.
+type StorageConfig struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string // Optional, the endpoint's default when empty
+	Bucket    string
+	UseSSL    bool
+}
+
+// Store reads and writes archived objects in a bucket
+type Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewStore connects to object storage, creating the bucket if needed
+func NewStore(ctx context.Context, cfg StorageConfig) (*Store, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object storage client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", cfg.Bucket, err)
+		}
+	}
+
+	return &Store{
+		client: client,
+		bucket: cfg.Bucket,
+	}, nil
+}
+
+// Put stores an object, replacing the one under the same key
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:     "application/x-ndjson",
+		ContentEncoding: "gzip",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store object %s: %w", key, err)
+	}
+	return nil
+}
+
+// Open opens an object for reading
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	return object, nil
+}
+
+// List calls fn with the keys under prefix in order. Unless recursive, keys
+// stop at the next / and fn gets the prefixes one level down instead.
+func (s *Store) List(ctx context.Context, prefix string, recursive bool, fn func(key string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Stops the listing when fn fails
+
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: recursive}) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list objects under %s: %w", prefix, object.Err)
+		}
+		if err := fn(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes the objects under prefix, returning how many were deleted
+func (s *Store) Remove(ctx context.Context, prefix string) (int, error) {
+	objects := make(chan minio.ObjectInfo)
+	listed := make(chan error, 1)
+	go func() {
+		defer close(objects)
+		listed <- s.List(ctx, prefix, true, func(key string) error {
+			select {
+			case objects <- minio.ObjectInfo{Key: key}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	removed := 0
+	var failed error
+	for result := range s.client.RemoveObjectsWithResult(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			failed = fmt.Errorf("failed to delete object %s: %w", result.ObjectName, result.Err)
+			continue
+		}
+		removed++
+	}
+	if err := <-listed; err != nil {
+		return removed, err
+	}
+	return removed, failed
+}
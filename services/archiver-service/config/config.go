@@ -0,0 +1,268 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/archive"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/envconfig"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/secrets"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// Formats of archived objects
+const (
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// HTTP server config, serving health checks
+type ServerConfig struct {
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// Kafka config of the archived topics, and of the producer heartbeats are
+// published with
+type KafkaConfig struct {
+	Brokers           []string
+	GroupID           string   // Consumer group the instances share the archived partitions in
+	Topics            []string // Archived topics
+	Topic             string   // Topic the producer writes to, set for each producer created
+	RetryMax          int
+	RequiredAcks      int
+	Partitions        int
+	ReplicationFactor int
+	SendTimeout       time.Duration
+	Signing           signing.Config // Keys produced messages are signed with
+}
+
+// Producer returns the settings the producers of the service share
+func (c KafkaConfig) Producer() kafkaproducer.Config {
+	return kafkaproducer.Config{
+		Brokers:           c.Brokers,
+		RequiredAcks:      c.RequiredAcks,
+		RetryMax:          c.RetryMax,
+		Partitions:        c.Partitions,
+		ReplicationFactor: c.ReplicationFactor,
+		Signing:           c.Signing,
+	}
+}
+
+// Archive config: where messages are archived, in what batches and for how long
+type ArchiveConfig struct {
+	Storage       archive.StorageConfig
+	Prefix        string // Prepended to every object key, e.g. "archive/"
+	Format        string // Only jsonl so far
+	Raw           bool   // Archive the raw topic enqueue produces to as well
+	RawTopic      string
+	FlushRecords  int           // Records per object at most
+	FlushBytes    int           // Compressed bytes per object at most, give or take what the compressor buffers
+	FlushInterval time.Duration // Longest a record waits to be archived
+	Retention     time.Duration // How long archived days are kept, zero keeps them forever
+	SweepInterval time.Duration // How often days past the retention are deleted
+}
+
+// Main config
+type Config struct {
+	Server    ServerConfig
+	Kafka     KafkaConfig
+	Archive   ArchiveConfig
+	Startup   StartupConfig
+	Heartbeat HeartbeatConfig
+	Shutdown  ShutdownConfig
+	Secrets   secrets.Config
+
+	secretStore *secrets.Store // Resolves the secrets Load read, and keeps them current
+}
+
+// Returns the store the configuration's secrets were resolved from
+func (c *Config) SecretStore() *secrets.Store {
+	return c.secretStore
+}
+
+// Returns the topics archived, the raw one included when enabled
+func (c *Config) ArchivedTopics() []string {
+	topics := slices.Clone(c.Kafka.Topics)
+	if c.Archive.Raw && !slices.Contains(topics, c.Archive.RawTopic) {
+		topics = append(topics, c.Archive.RawTopic)
+	}
+	return topics
+}
+
+// Holds how long the service waits for Kafka and object storage at startup
+type StartupConfig struct {
+	Preflight       bool          // Check the dependencies before creating any client
+	RetryTimeout    time.Duration // Give up after this long, zero tries once
+	RetryBackoff    time.Duration // Wait after the first failed attempt, doubled after every further one
+	RetryMaxBackoff time.Duration
+}
+
+// Holds where and how often the instance announces it is alive
+type HeartbeatConfig struct {
+	Topic      string        // Internal ops topic heartbeats are published to
+	Interval   time.Duration // Zero disables heartbeats
+	InstanceID string        // Defaults to the host name, the container ID under Docker
+}
+
+// Holds the timeouts of the shutdown stages, run in this order
+type ShutdownConfig struct {
+	Drain time.Duration // Stop consuming and archive the batches in flight
+	Flush time.Duration // Flush and close the producers
+}
+
+// DefaultConfig
+var DefaultConfig = Config{
+	Server: ServerConfig{
+		Port:         8084,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	},
+	Kafka: KafkaConfig{
+		Brokers:           []string{"localhost:9092"},
+		GroupID:           "notifications-archiver",
+		Topics:            []string{"notifications.delivery"},
+		RetryMax:          3,
+		RequiredAcks:      1,
+		Partitions:        3,
+		ReplicationFactor: 2,
+		SendTimeout:       5 * time.Second,
+	},
+	Archive: ArchiveConfig{
+		Storage: archive.StorageConfig{
+			Endpoint: "localhost:9000",
+			Bucket:   "notification-archive",
+		},
+		Format:        FormatJSONL,
+		RawTopic:      "notifications.raw",
+		FlushRecords:  100000,
+		FlushBytes:    64 << 20,
+		FlushInterval: 5 * time.Minute,
+		SweepInterval: time.Hour,
+	},
+	Startup: StartupConfig{
+		Preflight:       true,
+		RetryTimeout:    2 * time.Minute,
+		RetryBackoff:    time.Second,
+		RetryMaxBackoff: 15 * time.Second,
+	},
+	Heartbeat: HeartbeatConfig{
+		Topic:    "notifications.ops",
+		Interval: 10 * time.Second,
+	},
+	Shutdown: ShutdownConfig{
+		Drain: 30 * time.Second,
+		Flush: 5 * time.Second,
+	},
+	Secrets: secrets.Config{
+		RefreshInterval: 5 * time.Minute,
+	},
+}
+
+// Loads config from environment variables
+func Load() (*Config, error) {
+	cfg := DefaultConfig
+
+	// Secrets config first, any secret below may refer to a provider
+	envconfig.LoadStringEnv("VAULT_ADDR", &cfg.Secrets.Vault.Addr)
+	envconfig.LoadStringEnv("VAULT_TOKEN", &cfg.Secrets.Vault.Token)
+	envconfig.LoadStringEnv("VAULT_NAMESPACE", &cfg.Secrets.Vault.Namespace)
+	envconfig.LoadStringEnv("AWS_REGION", &cfg.Secrets.SSM.Region)
+	envconfig.LoadStringEnv("AWS_ACCESS_KEY_ID", &cfg.Secrets.SSM.AccessKeyID)
+	envconfig.LoadStringEnv("AWS_SECRET_ACCESS_KEY", &cfg.Secrets.SSM.SecretAccessKey)
+	envconfig.LoadStringEnv("AWS_SESSION_TOKEN", &cfg.Secrets.SSM.SessionToken)
+	envconfig.LoadStringEnv("SSM_ENDPOINT", &cfg.Secrets.SSM.Endpoint)
+	envconfig.LoadDurationEnv("SECRETS_REFRESH_INTERVAL", &cfg.Secrets.RefreshInterval)
+	store := secrets.New(cfg.Secrets)
+	cfg.secretStore = store
+
+	// Server config
+	envconfig.LoadIntEnv("SERVER_PORT", &cfg.Server.Port)
+	envconfig.LoadDurationEnv("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	envconfig.LoadDurationEnv("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+
+	// Kafka config
+	envconfig.LoadJSONStringArrayEnv("KAFKA_BROKERS", &cfg.Kafka.Brokers)
+	envconfig.LoadStringEnv("KAFKA_GROUP_ID", &cfg.Kafka.GroupID)
+	envconfig.LoadJSONStringArrayEnv("ARCHIVE_TOPICS", &cfg.Kafka.Topics)
+	envconfig.LoadIntEnv("KAFKA_RETRY_MAX", &cfg.Kafka.RetryMax)
+	envconfig.LoadIntEnv("KAFKA_REQUIRED_ACKS", &cfg.Kafka.RequiredAcks)
+	envconfig.LoadIntEnv("KAFKA_PARTITIONS", &cfg.Kafka.Partitions)
+	envconfig.LoadIntEnv("KAFKA_REPLICATION_FACTOR", &cfg.Kafka.ReplicationFactor)
+	envconfig.LoadDurationEnv("KAFKA_SEND_TIMEOUT", &cfg.Kafka.SendTimeout)
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "KAFKA_SIGNING_KEYS", &cfg.Kafka.Signing.Keys); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("KAFKA_SIGNING_ACTIVE_KEY_ID", &cfg.Kafka.Signing.ActiveKeyID)
+	if _, err := cfg.Kafka.Signing.CreateKeyring(); err != nil {
+		return nil, fmt.Errorf("KAFKA_SIGNING_KEYS are invalid: %w", err)
+	}
+
+	// Object storage config
+	envconfig.LoadStringEnv("OBJECT_STORAGE_ENDPOINT", &cfg.Archive.Storage.Endpoint)
+	if err := store.Load("OBJECT_STORAGE_ACCESS_KEY", &cfg.Archive.Storage.AccessKey); err != nil {
+		return nil, err
+	}
+	if err := store.Load("OBJECT_STORAGE_SECRET_KEY", &cfg.Archive.Storage.SecretKey); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("OBJECT_STORAGE_REGION", &cfg.Archive.Storage.Region)
+	envconfig.LoadStringEnv("OBJECT_STORAGE_BUCKET", &cfg.Archive.Storage.Bucket)
+	envconfig.LoadBoolEnv("OBJECT_STORAGE_USE_SSL", &cfg.Archive.Storage.UseSSL)
+
+	// Archive config
+	envconfig.LoadStringEnv("ARCHIVE_PREFIX", &cfg.Archive.Prefix)
+	envconfig.LoadStringEnv("ARCHIVE_FORMAT", &cfg.Archive.Format)
+	envconfig.LoadBoolEnv("ARCHIVE_RAW", &cfg.Archive.Raw)
+	envconfig.LoadStringEnv("ARCHIVE_RAW_TOPIC", &cfg.Archive.RawTopic)
+	envconfig.LoadIntEnv("ARCHIVE_FLUSH_RECORDS", &cfg.Archive.FlushRecords)
+	envconfig.LoadIntEnv("ARCHIVE_FLUSH_BYTES", &cfg.Archive.FlushBytes)
+	envconfig.LoadDurationEnv("ARCHIVE_FLUSH_INTERVAL", &cfg.Archive.FlushInterval)
+	var retentionDays int
+	envconfig.LoadIntEnv("ARCHIVE_RETENTION_DAYS", &retentionDays)
+	cfg.Archive.Retention = time.Duration(retentionDays) * 24 * time.Hour
+	envconfig.LoadDurationEnv("ARCHIVE_SWEEP_INTERVAL", &cfg.Archive.SweepInterval)
+
+	if len(cfg.ArchivedTopics()) == 0 {
+		return nil, fmt.Errorf("ARCHIVE_TOPICS must name at least one topic unless ARCHIVE_RAW is set")
+	}
+	switch cfg.Archive.Format {
+	case FormatJSONL:
+	case FormatParquet:
+		return nil, fmt.Errorf("ARCHIVE_FORMAT %s is not supported yet, use %s", FormatParquet, FormatJSONL)
+	default:
+		return nil, fmt.Errorf("ARCHIVE_FORMAT must be %s", FormatJSONL)
+	}
+	if cfg.Archive.Prefix != "" && !strings.HasSuffix(cfg.Archive.Prefix, "/") {
+		cfg.Archive.Prefix += "/"
+	}
+	if cfg.Archive.FlushRecords <= 0 || cfg.Archive.FlushBytes <= 0 || cfg.Archive.FlushInterval <= 0 {
+		return nil, fmt.Errorf("ARCHIVE_FLUSH_RECORDS, ARCHIVE_FLUSH_BYTES and ARCHIVE_FLUSH_INTERVAL must be positive")
+	}
+	if retentionDays < 0 || (retentionDays > 0 && cfg.Archive.SweepInterval <= 0) {
+		return nil, fmt.Errorf("ARCHIVE_RETENTION_DAYS must not be negative, and ARCHIVE_SWEEP_INTERVAL positive with a retention")
+	}
+
+	// Load heartbeat config
+	envconfig.LoadStringEnv("OPS_TOPIC", &cfg.Heartbeat.Topic)
+	envconfig.LoadDurationEnv("HEARTBEAT_INTERVAL", &cfg.Heartbeat.Interval)
+	envconfig.LoadStringEnv("INSTANCE_ID", &cfg.Heartbeat.InstanceID)
+	if cfg.Heartbeat.InstanceID == "" {
+		cfg.Heartbeat.InstanceID, _ = os.Hostname()
+	}
+
+	// General config
+	envconfig.LoadBoolEnv("STARTUP_PREFLIGHT", &cfg.Startup.Preflight)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_TIMEOUT", &cfg.Startup.RetryTimeout)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_BACKOFF", &cfg.Startup.RetryBackoff)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_MAX_BACKOFF", &cfg.Startup.RetryMaxBackoff)
+	envconfig.LoadDurationEnv("SHUTDOWN_DRAIN_TIMEOUT", &cfg.Shutdown.Drain)
+	envconfig.LoadDurationEnv("SHUTDOWN_FLUSH_TIMEOUT", &cfg.Shutdown.Flush)
+
+	return &cfg, nil
+}
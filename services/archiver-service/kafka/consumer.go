@@ -0,0 +1,223 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/archive"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/config"
+)
+
+// Uploader stores archived objects
+type Uploader interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// Backoff between attempts to upload a batch while object storage fails
+const (
+	uploadBackoff    = time.Second
+	uploadMaxBackoff = 30 * time.Second
+)
+
+// Consumer archives the messages of the topics. Each partition is archived
+// by one instance of the consumer group, in batches of an hour at most. A
+// batch's offsets are committed once its object is stored, so every message
+// is archived at least once; one archived again after a restart has the
+// topic, partition and offset of its earlier copy.
+type Consumer struct {
+	consumerGroup sarama.ConsumerGroup
+	topics        []string
+	uploader      Uploader
+	cfg           config.ArchiveConfig
+	drain         time.Duration // Longest the batches in flight are uploaded for once consuming stops
+	archived      atomic.Int64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewConsumer creates a consumer archiving the topics. Partitions the group
+// hasn't archived yet are read from the oldest message Kafka still has.
+func NewConsumer(kafkaCfg config.KafkaConfig, topics []string, uploader Uploader, cfg config.ArchiveConfig, drain time.Duration) (*Consumer, error) {
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
+	saramaCfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	consumerGroup, err := sarama.NewConsumerGroup(kafkaCfg.Brokers, kafkaCfg.GroupID, saramaCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	return &Consumer{
+		consumerGroup: consumerGroup,
+		topics:        topics,
+		uploader:      uploader,
+		cfg:           cfg,
+		drain:         drain,
+	}, nil
+}
+
+// Start archives messages in the background until Shutdown
+func (c *Consumer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		log.Printf("Archiving topics %v", c.topics)
+		for {
+			if err := c.consumerGroup.Consume(ctx, c.topics, c); err != nil {
+				log.Printf("Error archiving messages: %v", err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+}
+
+// Archived returns the number of messages archived since start
+func (c *Consumer) Archived() int64 {
+	return c.archived.Load()
+}
+
+// Setup is run at the beginning of a new session
+func (c *Consumer) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session
+func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim archives the messages of a partition, uploading the batch
+// once it is full, old enough or when a message falls in the next hour
+func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ticker := time.NewTicker(c.cfg.FlushInterval / 4)
+	defer ticker.Stop()
+
+	var batch *archive.Batch
+	var last *sarama.ConsumerMessage // Marked once the batch holding it is stored
+	flush := func(ctx context.Context) error {
+		if batch == nil {
+			return nil
+		}
+		if err := c.upload(ctx, batch); err != nil {
+			return err
+		}
+		session.MarkMessage(last, "")
+		c.archived.Add(int64(batch.Len()))
+		batch = nil
+		return nil
+	}
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return c.drainBatch(flush)
+			}
+
+			timestamp := message.Timestamp
+			if timestamp.IsZero() {
+				timestamp = time.Now()
+			}
+			hour := timestamp.UTC().Truncate(time.Hour)
+			if batch != nil && !batch.Hour.Equal(hour) {
+				if err := flush(session.Context()); err != nil {
+					return err
+				}
+			}
+			if batch == nil {
+				batch = archive.NewBatch(message.Topic, message.Partition, hour)
+			}
+
+			headers := make(map[string]string, len(message.Headers))
+			for _, header := range message.Headers {
+				headers[string(header.Key)] = string(header.Value)
+			}
+			record := archive.NewRecord(message.Topic, message.Partition, message.Offset, timestamp, message.Key, message.Value, headers)
+			if err := batch.Add(record); err != nil {
+				return err
+			}
+			last = message
+
+			if batch.Len() >= c.cfg.FlushRecords || batch.Size() >= c.cfg.FlushBytes {
+				if err := flush(session.Context()); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if batch != nil && batch.Age() >= c.cfg.FlushInterval {
+				if err := flush(session.Context()); err != nil {
+					return err
+				}
+			}
+		case <-session.Context().Done():
+			return c.drainBatch(flush)
+		}
+	}
+}
+
+// drainBatch uploads the batch in flight when the partition is revoked or
+// the service stops, so its messages aren't archived again by the next
+// owner of the partition
+func (c *Consumer) drainBatch(flush func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.drain)
+	defer cancel()
+	return flush(ctx)
+}
+
+// upload stores a batch, trying again with backoff while object storage
+// fails. Meanwhile the partition waits, its messages stay in Kafka.
+func (c *Consumer) upload(ctx context.Context, batch *archive.Batch) error {
+	data, err := batch.Close()
+	if err != nil {
+		return err
+	}
+
+	key := batch.Key(c.cfg.Prefix)
+	backoff := uploadBackoff
+	for {
+		err := c.uploader.Put(ctx, key, data)
+		if err == nil {
+			log.Printf("Archived %d messages of %s partition %d, offsets %d to %d, to %s",
+				batch.Len(), batch.Topic, batch.Partition, batch.FirstOffset, batch.LastOffset, key)
+			return nil
+		}
+		log.Printf("Failed to archive %s partition %d, trying again in %v: %v", batch.Topic, batch.Partition, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("gave up archiving %s partition %d from offset %d: %w", batch.Topic, batch.Partition, batch.FirstOffset, ctx.Err())
+		}
+		backoff = min(2*backoff, uploadMaxBackoff)
+	}
+}
+
+// Shutdown stops consuming, archiving the batches in flight first
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return c.consumerGroup.Close()
+}
@@ -0,0 +1,26 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// KafkaProducer sends messages with Sarama, within a timeout
+type KafkaProducer struct {
+	producer    sarama.SyncProducer
+	sendTimeout time.Duration // Upper bound for a single send, zero means no timeout
+}
+
+// Sends a message, giving up once the context is done or the send timeout elapses.
+// An abandoned send may still complete in the background.
+func (p *KafkaProducer) send(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	return kafkaproducer.Send(ctx, p.producer, msg, p.sendTimeout)
+}
+
+// Closes the Kafka producer
+func (p *KafkaProducer) Close() error {
+	return p.producer.Close()
+}
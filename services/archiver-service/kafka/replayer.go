@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/archive"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// RestoredFromHeader names the topic, partition and offset an archived
+// message was restored from
+const RestoredFromHeader = "X-Restored-From"
+
+// Replayer produces archived records to a topic again
+type Replayer struct {
+	producer *KafkaProducer
+	topic    string
+	resign   bool
+}
+
+// NewReplayer creates a replayer producing to a topic, which must exist.
+// Records keep the signature they were archived with unless resign is set.
+func NewReplayer(cfg config.KafkaConfig, topic string, resign bool) (*Replayer, error) {
+	// Sign the messages again only when asked to, a re-signed message is
+	// accepted as genuine however old it is
+	producerCfg := cfg.Producer()
+	if !resign {
+		producerCfg.Signing = signing.Config{}
+	}
+
+	// The topics replayed to exist already
+	producer, err := kafkaproducer.NewSyncProducer(producerCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replayer{
+		producer: &KafkaProducer{
+			producer:    producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		topic:  topic,
+		resign: resign,
+	}, nil
+}
+
+// Replay produces a record with its key, headers and value. Messages with
+// the same key land on the same partition as they did before. Re-signed
+// messages are marked with where they were restored from, the others are
+// left as they were archived, so their signature still holds.
+func (r *Replayer) Replay(ctx context.Context, record archive.Record) error {
+	headers := make([]sarama.RecordHeader, 0, len(record.Headers)+1)
+	for name, value := range record.Headers {
+		if name != RestoredFromHeader || !r.resign {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(value)})
+		}
+	}
+	if r.resign {
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte(RestoredFromHeader),
+			Value: []byte(fmt.Sprintf("%s/%d/%d", record.Topic, record.Partition, record.Offset)),
+		})
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   r.topic,
+		Value:   sarama.ByteEncoder(record.Payload()),
+		Headers: headers,
+	}
+	if record.Key != "" {
+		msg.Key = sarama.StringEncoder(record.Key)
+	}
+
+	if _, _, err := r.producer.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to restore %s partition %d offset %d: %w", record.Topic, record.Partition, record.Offset, err)
+	}
+	return nil
+}
+
+// Audit logs a restore and sends it to the audit topic, which the rate
+// limiter stores in its audit trail
+func (r *Replayer) Audit(ctx context.Context, topic string, action messages.AdminAction) error {
+	log.Printf("ADMIN AUDIT: %s by %s: %s %s", action.Action, action.Actor, action.Path, action.Parameters)
+
+	payload, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("failed to marshal admin action: %w", err)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(action.Service),
+		Value: sarama.ByteEncoder(payload),
+	}
+	if _, _, err := r.producer.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send admin action: %w", err)
+	}
+	return nil
+}
+
+// Close closes the producer
+func (r *Replayer) Close() error {
+	return r.producer.Close()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/api"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/archive"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/heartbeat"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/shutdown"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/startup"
+)
+
+func main() {
+	log.Printf("Starting Archiver Service %s", buildinfo.Get())
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	retry := startup.Config{
+		Timeout:        cfg.Startup.RetryTimeout,
+		InitialBackoff: cfg.Startup.RetryBackoff,
+		MaxBackoff:     cfg.Startup.RetryMaxBackoff,
+	}
+
+	// Check Kafka and the archived topics up front, so a misconfiguration fails with what to fix
+	topics := cfg.ArchivedTopics()
+	if cfg.Startup.Preflight {
+		checks := []startup.Check{
+			{
+				Name: "Kafka brokers",
+				Hint: "check KAFKA_BROKERS, ARCHIVE_TOPICS and that the brokers are up",
+				Run: func(ctx context.Context) error {
+					return kafkaadmin.CheckTopics(ctx, cfg.Kafka.Brokers, topics...)
+				},
+			},
+		}
+		if err := startup.Preflight(context.Background(), retry, checks...); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Fetch the referenced secrets again, so rotated signing keys sign heartbeats
+	if cfg.Secrets.RefreshInterval > 0 {
+		go cfg.SecretStore().Run(context.Background(), cfg.Secrets.RefreshInterval)
+	}
+
+	// Connect to the bucket messages are archived in
+	store, err := startup.Retry(context.Background(), retry, "object storage", func() (*archive.Store, error) {
+		return archive.NewStore(context.Background(), cfg.Archive.Storage)
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to object storage: %v", err)
+	}
+
+	// Archive the topics, the batches in flight are drained on shutdown
+	consumer, err := kafka.NewConsumer(cfg.Kafka, topics, store, cfg.Archive, cfg.Shutdown.Drain)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka consumer: %v", err)
+	}
+	consumer.Start()
+
+	// Delete the days past the retention
+	var flush []func(ctx context.Context) error
+	if cfg.Archive.Retention > 0 {
+		janitor := archive.NewJanitor(store, cfg.Archive.Prefix, topics, cfg.Archive.Retention, cfg.Archive.SweepInterval)
+		flush = append(flush, shutdown.Close(janitor.Close))
+		log.Printf("Keeping archived messages for %v", cfg.Archive.Retention)
+	}
+
+	// Initialize and start HTTP server
+	server := api.NewServer(cfg.Server, consumer.Archived)
+
+	// Announce this instance on the ops topic
+	if cfg.Heartbeat.Interval > 0 {
+		heartbeater, err := heartbeat.New(cfg.Kafka.Producer(), heartbeat.Config{
+			Service:    "archiver-service",
+			InstanceID: cfg.Heartbeat.InstanceID,
+			Topic:      cfg.Heartbeat.Topic,
+			Interval:   cfg.Heartbeat.Interval,
+		}, func(h *heartbeat.Heartbeat) {
+			h.Processed = consumer.Archived()
+		})
+		if err != nil {
+			log.Fatalf("Failed to create heartbeater: %v", err)
+		}
+		flush = append(flush, shutdown.Close(heartbeater.Close))
+		log.Printf("Publishing heartbeats of instance %s every %v", cfg.Heartbeat.InstanceID, cfg.Heartbeat.Interval)
+	}
+
+	go func() {
+		if err := server.Start(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	log.Println("Archiver Service started successfully")
+
+	// Wait for termination signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sigCh
+
+	log.Println("Shutdown signal received")
+
+	// Archive the batches in flight before their offsets' consumer group is left
+	var sequencer shutdown.Sequencer
+	sequencer.Stage("drain", cfg.Shutdown.Drain, consumer.Shutdown, server.Shutdown)
+	sequencer.Stage("flush", cfg.Shutdown.Flush, flush...)
+	sequencer.Run()
+
+	log.Println("Server gracefully stopped")
+}
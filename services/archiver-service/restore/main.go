@@ -0,0 +1,231 @@
+// Command restore replays archived messages into a topic, for disaster
+// recovery and backfills. It reads the archive of a topic for a time range,
+// optionally narrowed to partitions, keys or header values, and produces the
+// selected messages at a controlled rate. Storage and Kafka are configured
+// with the archiver's environment variables:
+//
+//	go run ./restore -topic notifications.delivery -from 2026-10-16T09:00:00Z -to 2026-10-16T12:00:00Z \
+//		-header X-Tenant=acme -target notifications.delivery -rate 200
+//
+// Messages keep the signature they were archived with. -resign signs them
+// again, which consumers then accept however old they are, so it needs
+// -actor and -reason and is recorded in the audit trail first.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/archive"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/archiver-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
+// errLimit stops reading once -limit messages were restored
+var errLimit = errors.New("limit reached")
+
+func main() {
+	topic := flag.String("topic", "notifications.delivery", "archived topic to read")
+	target := flag.String("target", "", "topic to produce to, the archived one when empty")
+	from := flag.String("from", "", "restore messages timestamped from then on, RFC 3339")
+	to := flag.String("to", "", "restore messages timestamped before then, RFC 3339, now when empty")
+	partitions := flag.String("partitions", "", "comma separated archived partitions to restore, all when empty")
+	keys := flag.String("keys", "", "comma separated message keys (user IDs) to restore, all when empty")
+	headers := map[string]string{}
+	flag.Func("header", "restore only messages with this header value, as name=value, may be repeated", func(header string) error {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("want name=value")
+		}
+		headers[name] = value
+		return nil
+	})
+	rate := flag.Float64("rate", 100, "messages produced per second at most, zero for no limit")
+	limit := flag.Int("limit", 0, "stop after restoring this many messages, zero for no limit")
+	dryRun := flag.Bool("dry-run", false, "count the selected messages without producing them")
+	resign := flag.Bool("resign", false, "sign the restored messages again, recorded in the audit trail")
+	actor := flag.String("actor", "", "who restores the messages, required with -resign")
+	reason := flag.String("reason", "", "why the messages are re-signed, required with -resign")
+	auditTopic := flag.String("audit-topic", "notifications.audit", "topic the re-signing is recorded on")
+	flag.Parse()
+
+	filter, err := parseFilter(*from, *to, *partitions, *keys, headers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *target == "" {
+		*target = *topic
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *resign && !*dryRun {
+		if *actor == "" || *reason == "" {
+			log.Fatal("-resign needs -actor and -reason")
+		}
+		if len(cfg.Kafka.Signing.Keys) == 0 {
+			log.Fatal("-resign needs KAFKA_SIGNING_KEYS")
+		}
+	}
+
+	// Stop between messages on interrupt, the last one restored is logged
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	store, err := archive.NewStore(ctx, cfg.Archive.Storage)
+	if err != nil {
+		log.Fatalf("Failed to connect to object storage: %v", err)
+	}
+
+	var replayer *kafka.Replayer
+	if !*dryRun {
+		if err := kafkaadmin.CheckTopics(ctx, cfg.Kafka.Brokers, *target); err != nil {
+			log.Fatalf("Failed to check the target topic: %v", err)
+		}
+		replayer, err = kafka.NewReplayer(cfg.Kafka, *target, *resign)
+		if err != nil {
+			log.Fatalf("Failed to create Kafka producer: %v", err)
+		}
+		defer replayer.Close()
+
+		// Record the re-signing before the first message is produced
+		if *resign {
+			if err := replayer.Audit(ctx, *auditTopic, resignAction(*actor, *topic)); err != nil {
+				log.Fatalf("Failed to record the re-signing: %v", err)
+			}
+		}
+	}
+
+	log.Printf("Restoring %s from %s to %s into %s", *topic,
+		filter.From.Format(time.RFC3339), filter.To.Format(time.RFC3339), *target)
+
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Duration(float64(time.Second) / *rate)
+	}
+	verb := "Restored"
+	if *dryRun {
+		verb = "Selected"
+	}
+	next := time.Now()
+	restored := 0
+	var last archive.Record
+
+	err = archive.Read(ctx, store, cfg.Archive.Prefix, *topic, filter, func(record archive.Record) error {
+		if *limit > 0 && restored >= *limit {
+			return errLimit
+		}
+		if replayer != nil {
+			// Pace the messages, so the pipeline takes the restore alongside live traffic
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if now := time.Now(); now.After(next) {
+				next = now
+			}
+			next = next.Add(interval)
+
+			if err := replayer.Replay(ctx, record); err != nil {
+				return err
+			}
+		}
+		restored++
+		last = record
+		if restored%10000 == 0 {
+			log.Printf("%s %d messages, up to %s", verb, restored, last.Timestamp.Format(time.RFC3339))
+		}
+		return nil
+	})
+
+	if restored > 0 {
+		log.Printf("%s %d messages, the last %s partition %d offset %d timestamped %s", verb, restored,
+			last.Topic, last.Partition, last.Offset, last.Timestamp.Format(time.RFC3339Nano))
+	} else {
+		log.Printf("%s no messages", verb)
+	}
+	if err != nil && !errors.Is(err, errLimit) {
+		log.Printf("Restore stopped: %v", err)
+		os.Exit(1)
+	}
+}
+
+// resignAction records a restore signing the messages again, with the flags it was run with
+func resignAction(actor, topic string) messages.AdminAction {
+	instanceID, _ := os.Hostname()
+	parameters := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		parameters[f.Name] = f.Value.String()
+	})
+	encoded, _ := json.Marshal(parameters)
+
+	now := time.Now()
+	return messages.AdminAction{
+		ID:          fmt.Sprintf("restore-%s-%d", instanceID, now.UnixNano()),
+		Service:     "archiver-service",
+		InstanceID:  instanceID,
+		Actor:       actor,
+		Action:      "restore -resign",
+		Path:        topic,
+		Parameters:  encoded,
+		PerformedAt: now.Unix(),
+	}
+}
+
+// parseFilter builds the filter of the flags
+func parseFilter(from, to, partitions, keys string, headers map[string]string) (archive.Filter, error) {
+	filter := archive.Filter{
+		To:      time.Now(),
+		Headers: headers,
+	}
+
+	var err error
+	if from == "" {
+		return filter, fmt.Errorf("-from is required")
+	}
+	if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+		return filter, fmt.Errorf("-from is invalid: %w", err)
+	}
+	if to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("-to is invalid: %w", err)
+		}
+	}
+	if !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("-from must be before -to")
+	}
+
+	for _, partition := range strings.Split(partitions, ",") {
+		if partition = strings.TrimSpace(partition); partition == "" {
+			continue
+		}
+		p, err := strconv.ParseInt(partition, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("-partitions is invalid: %w", err)
+		}
+		filter.Partitions = append(filter.Partitions, int32(p))
+	}
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			filter.Keys = append(filter.Keys, key)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,129 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventTypeFilter decides which event types are accepted at ingestion. A
+// denied event type is always rejected; when the allow list is non-empty,
+// only the event types on it are accepted.
+type EventTypeFilter struct {
+	file     string
+	interval time.Duration
+	mu       sync.RWMutex
+	allow    map[string]bool
+	deny     map[string]bool
+	modTime  time.Time // Modification time of the loaded file
+}
+
+// Config for the event type filter
+type Config struct {
+	Allow        []string      // Accepted event types, empty accepts all that aren't denied
+	Deny         []string      // Rejected event types
+	File         string        // Optional JSON file replacing Allow and Deny, reloaded when it changes
+	PollInterval time.Duration // How often the file is checked for changes
+}
+
+// Contents of the filter file
+type lists struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Creates a new event type filter, loading the file if one is configured
+func NewEventTypeFilter(cfg Config) (*EventTypeFilter, error) {
+	filter := &EventTypeFilter{
+		file:     cfg.File,
+		interval: cfg.PollInterval,
+	}
+	filter.set(lists{Allow: cfg.Allow, Deny: cfg.Deny})
+
+	if filter.file != "" {
+		if err := filter.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// Allowed reports whether notifications of the event type are accepted
+func (f *EventTypeFilter) Allowed(eventType string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.deny[eventType] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[eventType]
+}
+
+// Watch reloads the file whenever it changes until the context is done.
+// A file that fails to load leaves the previous lists in place.
+func (f *EventTypeFilter) Watch(ctx context.Context) {
+	if f.file == "" || f.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.reload(); err != nil {
+				log.Printf("Keeping previous event type lists: %v", err)
+			}
+		}
+	}
+}
+
+// reload loads the file when it changed since the last load
+func (f *EventTypeFilter) reload() error {
+	info, err := os.Stat(f.file)
+	if err != nil {
+		return fmt.Errorf("failed to stat event type lists: %w", err)
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.file)
+	if err != nil {
+		return fmt.Errorf("failed to read event type lists: %w", err)
+	}
+
+	var loaded lists
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse event type lists: %w", err)
+	}
+
+	f.set(loaded)
+	f.modTime = info.ModTime()
+	log.Printf("Loaded event type lists from %s: %d allowed, %d denied", f.file, len(loaded.Allow), len(loaded.Deny))
+	return nil
+}
+
+// set replaces the lists
+func (f *EventTypeFilter) set(loaded lists) {
+	allow := make(map[string]bool, len(loaded.Allow))
+	for _, eventType := range loaded.Allow {
+		allow[eventType] = true
+	}
+	deny := make(map[string]bool, len(loaded.Deny))
+	for _, eventType := range loaded.Deny {
+		deny[eventType] = true
+	}
+
+	f.mu.Lock()
+	f.allow = allow
+	f.deny = deny
+	f.mu.Unlock()
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/templates"
+)
+
+// Renders the email template a notification names into its email override,
+// then fits the overrides to their channels. It returns the warnings about
+// the content, and an error saying why the content is refused.
+func (s *Server) prepareChannelContent(req *models.NotificationRequest) ([]string, error) {
+	if req.Template != "" {
+		if s.templates == nil {
+			return nil, errors.New("Email templates are not enabled")
+		}
+		if req.ChannelContent != nil && req.ChannelContent.Email != nil {
+			return nil, errors.New("template and channel_content.email are mutually exclusive")
+		}
+		email, err := s.templates.Render(req.Template, templates.Data{EventType: req.EventType, Metadata: req.Metadata})
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template: %v", err)
+		}
+		if req.ChannelContent == nil {
+			req.ChannelContent = &models.ChannelContent{}
+		}
+		req.ChannelContent.Email = email
+	}
+
+	if req.ChannelContent == nil {
+		return nil, nil
+	}
+	warnings, err := models.ProcessChannelContent(req.ChannelContent, s.content)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid channel content: %v", err)
+	}
+	return warnings, nil
+}
+
+// Handles requests to preview the channel content of a notification without
+// sending it. The response holds the content as it would be delivered, how
+// its SMS text is split into segments, and the warnings about it, or an
+// error saying why the notification would be refused.
+func (s *Server) handlePreviewNotification(w http.ResponseWriter, r *http.Request) {
+	var req models.NotificationRequest
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	if err := decodeBody(r.Body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	preview := map[string]any{}
+	warnings, err := s.prepareChannelContent(&req)
+	if err != nil {
+		preview["error"] = err.Error()
+	} else {
+		preview["channel_content"] = req.ChannelContent
+		preview["warnings"] = append([]string{}, warnings...)
+		if req.ChannelContent != nil && req.ChannelContent.SMS != nil {
+			preview["sms"] = models.CountSMSSegments(req.ChannelContent.SMS.Text)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
@@ -2,27 +2,79 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/admission"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/bypass"
 	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/config"
 	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/kafka"
 	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/quota"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/ratelimit"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/templates"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/tracking"
 )
 
+// Longest accepted group_key or thread_id
+const maxGroupingKeyLength = 64
+
+// Header identifying the calling client
+const apiKeyHeader = "X-API-Key"
+
+// Longest accepted X-Request-ID, longer ones are replaced
+const maxRequestIDLength = 128
+
+// Response header listing the metadata keys stripped from a notification
+const metadataStrippedHeader = "X-Metadata-Stripped"
+
 // HTTP server struct
 type Server struct {
-	server *http.Server
-	producer kafka.Producer
+	server         *http.Server
+	producer       kafka.Producer
+	statusProducer kafka.StatusProducer
+	maxBodyBytes   int64
+	metadata       models.MetadataLimits      // Limits on notification metadata
+	content        models.ContentLimits       // Limits on channel content
+	sandboxKeys    map[string]bool            // API keys forced into sandbox mode
+	bypass         *bypass.Signer             // Optional, signs bypasses for the API keys allowed to request them
+	engagementKey  []byte                     // Optional, verifies the tokens of opens and action clicks
+	debugPercent   int                        // Share of notifications sampled for debugging
+	debugUsers     map[string]bool            // Users whose notifications are always sampled
+	eventTypes     *admission.EventTypeFilter // Event types accepted at ingestion
+	quotas         *quota.Enforcer            // Optional, enforces per-API-key quotas
+	rateLimits     *ratelimit.Client          // Optional, reads users' rate-limit quota
+	templates      *templates.Store           // Optional, renders the email templates notifications name
+	handled        atomic.Int64               // Requests handled since start, health and version checks aside
+	failed         atomic.Int64               // Requests of those answered with a server error
 }
 
 // Creates a new HTTP server
-func NewServer(cfg config.ServerConfig, producer kafka.Producer) *Server {
+func NewServer(cfg config.ServerConfig, debug config.DebugConfig, eventTypes *admission.EventTypeFilter, quotas *quota.Enforcer, rateLimits *ratelimit.Client, emailTemplates *templates.Store, producer kafka.Producer, statusProducer kafka.StatusProducer) *Server {
 	mux := http.NewServeMux()
-	
+
+	sandboxKeys := make(map[string]bool, len(cfg.SandboxAPIKeys))
+	for _, key := range cfg.SandboxAPIKeys {
+		sandboxKeys[key] = true
+	}
+
+	debugUsers := make(map[string]bool, len(debug.UserIDs))
+	for _, userID := range debug.UserIDs {
+		debugUsers[userID] = true
+	}
+
 	server := Server{
 		server: &http.Server{
 			Addr:         fmt.Sprintf(":%d", cfg.Port),
@@ -31,12 +83,40 @@ func NewServer(cfg config.ServerConfig, producer kafka.Producer) *Server {
 			WriteTimeout: cfg.WriteTimeout,
 			IdleTimeout:  cfg.IdleTimeout,
 		},
-		producer: producer,
+		producer:       producer,
+		statusProducer: statusProducer,
+		maxBodyBytes:   int64(cfg.MaxBodyBytes),
+		metadata:       cfg.Metadata,
+		content:        cfg.Content,
+		sandboxKeys:    sandboxKeys,
+		bypass:         bypass.NewSigner(cfg.BypassSecret, cfg.BypassAPIKeys),
+		engagementKey:  []byte(cfg.EngagementTokenSecret),
+		debugPercent:   debug.SamplePercent,
+		debugUsers:     debugUsers,
+		eventTypes:     eventTypes,
+		quotas:         quotas,
+		rateLimits:     rateLimits,
+		templates:      emailTemplates,
 	}
 
 	// Routes
 	mux.HandleFunc("/api/v1/notifications", server.handleCreateNotification)
+	mux.HandleFunc("POST /api/v1/notifications/preview", server.handlePreviewNotification)
+	mux.HandleFunc("POST /api/v1/notifications/{notificationID}/actions/{actionID}/clicks", server.handleActionClick)
+	mux.HandleFunc("POST /api/v1/notifications/{notificationID}/opens", server.handleOpen)
+	if rateLimits != nil {
+		mux.HandleFunc("GET /api/v1/users/{userID}/rate-limit", server.handleRateLimit)
+	}
+	if emailTemplates != nil {
+		mux.HandleFunc("GET /api/v1/email-templates", server.handleListTemplates)
+		mux.HandleFunc("GET /api/v1/email-templates/{name}", server.handleGetTemplate)
+		mux.HandleFunc("PUT /api/v1/email-templates/{name}", server.handlePutTemplate)
+		mux.HandleFunc("DELETE /api/v1/email-templates/{name}", server.handleDeleteTemplate)
+		mux.HandleFunc("POST /api/v1/email-templates/{name}/preview", server.handlePreviewTemplate)
+	}
 	mux.HandleFunc("/health", server.handleHealth)
+	mux.HandleFunc("GET /version", server.handleVersion)
+	server.server.Handler = server.counted(mux)
 
 	return &server
 }
@@ -51,6 +131,41 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return s.server.Shutdown(ctx)
 }
 
+// Returns the number of requests handled since start, health and version checks aside
+func (s *Server) Handled() int64 {
+	return s.handled.Load()
+}
+
+// Returns the number of requests handled since start that were answered with a server error
+func (s *Server) Failed() int64 {
+	return s.failed.Load()
+}
+
+// Counts the requests handled by next, and those it failed
+func (s *Server) counted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if r.URL.Path != "/health" && r.URL.Path != "/version" {
+			s.handled.Add(1)
+			if recorder.status >= 500 {
+				s.failed.Add(1)
+			}
+		}
+	})
+}
+
+// Remembers the status code a response was written with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
 // Handles notification creation requests
 func (s *Server) handleCreateNotification(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -59,30 +174,150 @@ func (s *Server) handleCreateNotification(w http.ResponseWriter, r *http.Request
 	}
 
 	var req models.NotificationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	if err := decodeBody(r.Body, &req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Validate request
-	if req.UserID == "" || req.EventType == "" {
+	// Validate request. Events naming recipients or an entity may leave the
+	// user out, they go to the recipients and the entity's subscribers.
+	if (req.UserID == "" && req.Entity == nil && len(req.Recipients) == 0) || req.EventType == "" {
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
 
+	if req.UserID != "" && len(req.Recipients) > 0 {
+		http.Error(w, "user_id and recipients are mutually exclusive", http.StatusBadRequest)
+		return
+	}
+	if err := models.ValidateRecipients(req.Recipients); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid recipients: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Entity != nil {
+		if err := models.ValidateEntity(req.Entity); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid entity: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Strip oversized blobs from metadata rather than carry them through the pipeline
+	stripped, err := models.SanitizeMetadata(req.Metadata, s.metadata)
+	if len(stripped) > 0 {
+		w.Header().Set(metadataStrippedHeader, strings.Join(stripped, ","))
+	}
+	if errors.Is(err, models.ErrMetadataTooLarge) {
+		http.Error(w, fmt.Sprintf("Invalid metadata: %v", err), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid metadata: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if !s.eventTypes.Allowed(req.EventType) {
+		http.Error(w, fmt.Sprintf("Event type %s is not accepted", req.EventType), http.StatusForbidden)
+		return
+	}
+
+	warnings, err := s.prepareChannelContent(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Grouping keys double as push collapse keys, which APNs caps at 64 bytes
+	if len(req.GroupKey) > maxGroupingKeyLength || len(req.ThreadID) > maxGroupingKeyLength {
+		http.Error(w, fmt.Sprintf("group_key and thread_id must be at most %d bytes", maxGroupingKeyLength), http.StatusBadRequest)
+		return
+	}
+
+	if err := models.ValidateActions(req.Actions); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid actions: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.BypassReason != "" {
+		if !s.bypass.Allowed(r.Header.Get(apiKeyHeader)) {
+			http.Error(w, "API key may not bypass rate limits", http.StatusForbidden)
+			return
+		}
+		if len(req.BypassReason) > bypass.MaxReasonLength {
+			http.Error(w, fmt.Sprintf("bypass_reason must be at most %d bytes", bypass.MaxReasonLength), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Create notification event
+	requestID := s.requestID(w, r)
 	event := &models.NotificationEvent{
-		ID:        generateID(),
-		UserID:    req.UserID,
-		EventType: req.EventType,
-		Content:   req.Content,
-		Metadata:  req.Metadata,
-		CreatedAt: time.Now().Unix(),
+		SchemaVersion:  models.SchemaVersion,
+		ID:             generateID(),
+		RequestID:      requestID,
+		UserID:         req.UserID,
+		EventType:      req.EventType,
+		Content:        req.Content,
+		ChannelContent: req.ChannelContent,
+		Metadata:       req.Metadata,
+		GroupKey:       req.GroupKey,
+		ThreadID:       req.ThreadID,
+		Entity:         req.Entity,
+		Recipients:     req.Recipients,
+		PriorityHint:   req.PriorityHint,
+		Actions:        req.Actions,
+		Test:           req.Test || s.sandboxKeys[r.Header.Get(apiKeyHeader)],
+		Debug:          s.sampleForDebug(req.UserID),
+		APIKeyID:       apiKeyID(r.Header.Get(apiKeyHeader)),
+		CreatedAt:      time.Now().Unix(),
+	}
+
+	if len(stripped) > 0 {
+		logging.ForRequest(requestID).Printf("Stripped oversized metadata %v from notification %s of API key %s",
+			stripped, event.ID, event.APIKeyID)
+	}
+
+	if req.BypassReason != "" {
+		s.bypass.Sign(event, req.BypassReason)
+		logging.ForRequest(requestID).Printf("Bypass granted to API key %s for notification %s to user %s: %s",
+			event.APIKeyID, event.ID, event.UserID, req.BypassReason)
+	}
+
+	// Count the notification against its API key's quotas, sandbox notifications aside
+	var taken *quota.Result
+	if s.quotas != nil && event.APIKeyID != "" && !event.Test {
+		result, err := s.quotas.Take(r.Context(), event.APIKeyID)
+		if err != nil {
+			// Quotas are for billing, not for protecting the pipeline, so fail open
+			logging.ForRequest(requestID).Printf("Failed to check quota of API key %s, accepting: %v", event.APIKeyID, err)
+		} else {
+			setQuotaHeaders(w, result)
+			if result.Exceeded {
+				http.Error(w, "API key quota exceeded", http.StatusTooManyRequests)
+				return
+			}
+			taken = &result
+		}
 	}
 
 	// Send to Kafka
-	if err := s.producer.SendMessage(event); err != nil {
-		log.Printf("Failed to send message to Kafka: %v", err)
+	if err := s.producer.SendMessage(r.Context(), event); err != nil {
+		if taken != nil {
+			if err := s.quotas.Refund(context.WithoutCancel(r.Context()), event.APIKeyID, *taken); err != nil {
+				logging.ForRequest(requestID).Printf("Failed to refund quota of API key %s: %v", event.APIKeyID, err)
+			}
+		}
+		if errors.Is(err, kafka.ErrPayloadTooLarge) {
+			http.Error(w, "Notification payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		logging.ForRequest(requestID).Printf("Failed to send message to Kafka: %v", err)
 		http.Error(w, "Failed to process notification", http.StatusInternalServerError)
 		return
 	}
@@ -90,11 +325,140 @@ func (s *Server) handleCreateNotification(w http.ResponseWriter, r *http.Request
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{
+	response := map[string]any{
 		"id":      event.ID,
 		"status":  "accepted",
 		"message": "Notification is being processed",
-	})
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	if rateLimit := s.rateLimitOf(r.Context(), requestID, event); rateLimit != nil {
+		response["rate_limit"] = rateLimit
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// Returns the recipient's rate-limit quota before the notification is
+// counted, nil without a rate limiter or when it can't be reached
+func (s *Server) rateLimitOf(ctx context.Context, requestID string, event *models.NotificationEvent) *models.RateLimitResult {
+	if s.rateLimits == nil || event.UserID == "" {
+		return nil
+	}
+
+	tenant, _ := event.Metadata[models.MetadataTenant].(string)
+	result, err := s.rateLimits.Quota(ctx, event.UserID, event.EventType, event.PriorityHint, tenant)
+	if err != nil {
+		logging.ForRequest(requestID).Printf("Failed to read rate-limit quota of user %s: %v", event.UserID, err)
+		return nil
+	}
+	return result
+}
+
+// Handles requests for a user's rate-limit quota, for notifications of the
+// event type, priority and tenant given as query parameters
+func (s *Server) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	result, err := s.rateLimits.Quota(r.Context(), r.PathValue("userID"), query.Get("event_type"), query.Get("priority"), query.Get("tenant"))
+	if err != nil {
+		logging.ForRequest(s.requestID(w, r)).Printf("Failed to read rate-limit quota of user %s: %v", r.PathValue("userID"), err)
+		http.Error(w, "Failed to read rate-limit quota", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Records a user clicking one of a notification's actions on the status topic
+func (s *Server) handleActionClick(w http.ResponseWriter, r *http.Request) {
+	var req models.ActionClickRequest
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	if err := decodeBody(r.Body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" || req.Channel == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	if !s.authentic(r.PathValue("notificationID"), req.UserID, req.Token) {
+		http.Error(w, "Invalid engagement token", http.StatusForbidden)
+		return
+	}
+
+	requestID := s.requestID(w, r)
+	event := &models.StatusEvent{
+		NotificationID: r.PathValue("notificationID"),
+		RequestID:      requestID,
+		UserID:         req.UserID,
+		Status:         models.StatusActionClicked,
+		ActionID:       r.PathValue("actionID"),
+		Channel:        req.Channel,
+		Token:          req.Token,
+		OccurredAt:     time.Now().Unix(),
+	}
+
+	if err := s.statusProducer.PublishStatus(r.Context(), event); err != nil {
+		logging.ForRequest(requestID).Printf("Failed to record action click for notification %s: %v", event.NotificationID, err)
+		http.Error(w, "Failed to record action click", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Records a user opening a notification on the status topic
+func (s *Server) handleOpen(w http.ResponseWriter, r *http.Request) {
+	var req models.OpenRequest
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	if err := decodeBody(r.Body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" || req.Channel == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	if !s.authentic(r.PathValue("notificationID"), req.UserID, req.Token) {
+		http.Error(w, "Invalid engagement token", http.StatusForbidden)
+		return
+	}
+
+	requestID := s.requestID(w, r)
+	event := &models.StatusEvent{
+		NotificationID: r.PathValue("notificationID"),
+		RequestID:      requestID,
+		UserID:         req.UserID,
+		Status:         models.StatusOpened,
+		Channel:        req.Channel,
+		Token:          req.Token,
+		OccurredAt:     time.Now().Unix(),
+	}
+
+	if err := s.statusProducer.PublishStatus(r.Context(), event); err != nil {
+		logging.ForRequest(requestID).Printf("Failed to record open of notification %s: %v", event.NotificationID, err)
+		http.Error(w, "Failed to record open", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Reports whether an open or action click carries the token the notification
+// was delivered to the user with, always true without a secret to check it
+func (s *Server) authentic(notificationID, userID, token string) bool {
+	return len(s.engagementKey) == 0 || tracking.Valid(s.engagementKey, notificationID, userID, token)
+}
+
+// Handles requests for the version of the running code
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
 }
 
 // Handles health check requests
@@ -106,7 +470,65 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Returns the caller's X-Request-ID, or a new one when it is missing or too
+// long, and echoes it on the response
+func (s *Server) requestID(w http.ResponseWriter, r *http.Request) string {
+	requestID := r.Header.Get(logging.RequestIDHeader)
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		requestID = fmt.Sprintf("req_%d", time.Now().UnixNano())
+	}
+	w.Header().Set(logging.RequestIDHeader, requestID)
+	return requestID
+}
+
+// Decodes a request body holding a single JSON value, rejecting anything after it
+func decodeBody(body io.Reader, v any) error {
+	decoder := json.NewDecoder(body)
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return errors.New("unexpected data after the request body")
+	}
+	return nil
+}
+
+// Decides whether a notification is traced through the pipeline
+func (s *Server) sampleForDebug(userID string) bool {
+	return s.debugUsers[userID] || rand.IntN(100) < s.debugPercent
+}
+
+// Sets the X-Quota-<Period>-Limit, -Remaining and -Reset headers of every
+// limited period, and Retry-After once a quota is used up
+func setQuotaHeaders(w http.ResponseWriter, result quota.Result) {
+	var retryAt time.Time
+	for _, usage := range result.Usage {
+		prefix := "X-Quota-" + strings.ToUpper(usage.Period[:1]) + usage.Period[1:]
+		w.Header().Set(prefix+"-Limit", strconv.Itoa(usage.Limit))
+		w.Header().Set(prefix+"-Remaining", strconv.Itoa(usage.Remaining()))
+		w.Header().Set(prefix+"-Reset", strconv.FormatInt(usage.ResetAt.Unix(), 10))
+		if usage.Exceeded && usage.ResetAt.After(retryAt) {
+			retryAt = usage.ResetAt
+		}
+	}
+	if !retryAt.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(retryAt).Seconds())+1))
+	}
+}
+
+// Returns a fingerprint identifying an API key without revealing it, empty without a key
+func apiKeyID(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key_" + hex.EncodeToString(sum[:6])
+}
+
 // Generates a unique ID for notifications
 func generateID() string {
 	return fmt.Sprintf("notif_%d", time.Now().UnixNano())
-}
\ No newline at end of file
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/admission"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/ratelimit"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/tracking"
+)
+
+// fakeProducer records the events sent through it
+type fakeProducer struct {
+	mu       sync.Mutex
+	events   []*models.NotificationEvent
+	statuses []*models.StatusEvent
+}
+
+func (p *fakeProducer) SendMessage(ctx context.Context, event *models.NotificationEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *fakeProducer) PublishStatus(ctx context.Context, event *models.StatusEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statuses = append(p.statuses, event)
+	return nil
+}
+
+func (p *fakeProducer) Close() error {
+	return nil
+}
+
+// take returns the events sent since the last call
+func (p *fakeProducer) take() []*models.NotificationEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	events := p.events
+	p.events = nil
+	return events
+}
+
+// newTestServer creates a server with the default limits producing to a fake
+func newTestServer(t testing.TB) (*Server, *fakeProducer) {
+	t.Helper()
+	return newRateLimitedTestServer(t, nil)
+}
+
+// newRateLimitedTestServer creates a test server reading rate-limit quotas with the client
+func newRateLimitedTestServer(t testing.TB, rateLimits *ratelimit.Client) (*Server, *fakeProducer) {
+	t.Helper()
+
+	eventTypes, err := admission.NewEventTypeFilter(admission.Config{Deny: []string{"blocked"}})
+	if err != nil {
+		t.Fatalf("creating event type filter: %v", err)
+	}
+
+	cfg := config.DefaultConfig.Server
+	cfg.MaxBodyBytes = 64 << 10
+	cfg.BypassSecret = "secret"
+	cfg.BypassAPIKeys = []string{"bypass-key"}
+	cfg.EngagementTokenSecret = "engagement-secret"
+
+	producer := &fakeProducer{}
+	return NewServer(cfg, config.DebugConfig{}, eventTypes, nil, rateLimits, nil, producer, producer), producer
+}
+
+// createNotification posts a request body to the server
+func createNotification(server *Server, body, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/notifications", strings.NewReader(body))
+	if apiKey != "" {
+		req.Header.Set(apiKeyHeader, apiKey)
+	}
+	recorder := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+var createNotificationSeeds = []string{
+	`{"user_id":"u-1","event_type":"comment","content":"hi"}`,
+	`{"user_id":"u-1","event_type":"like","metadata":{"tenant":"acme","post":{"id":1,"tags":["a"]}},"group_key":"g","thread_id":"t"}`,
+	`{"event_type":"reply","entity":{"type":"thread","id":"t-1"},"recipients":[{"user_id":"u-2","priority":"high"}]}`,
+	`{"user_id":"u-1","event_type":"comment","channel_content":{"sms":{"text":"hello"},"email":{"subject":"s","html":"<p>b</p>"}}}`,
+	`{"user_id":"u-1","event_type":"comment","actions":[{"id":"open","label":"Open","url":"https://example.com"}]}`,
+	`{"user_id":"u-1","event_type":"comment","bypass_reason":"outage"}`,
+	`{"user_id":"u-1","recipients":[{"user_id":"u-2"}],"event_type":"comment"}`,
+	`{"user_id":"u-1","event_type":"blocked"}`,
+	`{"user_id":"u-1","event_type":"comment","metadata":{"a":{"b":{"c":{"d":{"e":{"f":{"g":{"h":{"i":1}}}}}}}}}}`,
+	`{"user_id":"u-1","event_type":"comment"} {"user_id":"u-2"}`,
+	`{"user_id":"u-1","event_type":"comment"}]`,
+	`{"user_id":1,"event_type":"comment"}`,
+	`{"user_id":"u-1"}`,
+	`null`,
+	``,
+}
+
+func TestCreateNotification(t *testing.T) {
+	server, producer := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		apiKey string
+		status int
+	}{
+		{"user", createNotificationSeeds[0], "", http.StatusAccepted},
+		{"entity and recipients", createNotificationSeeds[2], "", http.StatusAccepted},
+		{"bypass", createNotificationSeeds[5], "bypass-key", http.StatusAccepted},
+		{"bypass not allowed", createNotificationSeeds[5], "other-key", http.StatusForbidden},
+		{"user and recipients", createNotificationSeeds[6], "", http.StatusBadRequest},
+		{"denied event type", createNotificationSeeds[7], "", http.StatusForbidden},
+		{"trailing value", createNotificationSeeds[9], "", http.StatusBadRequest},
+		{"trailing garbage", createNotificationSeeds[10], "", http.StatusBadRequest},
+		{"wrong type", createNotificationSeeds[11], "", http.StatusBadRequest},
+		{"missing event type", createNotificationSeeds[12], "", http.StatusBadRequest},
+		{"null", createNotificationSeeds[13], "", http.StatusBadRequest},
+		{"empty", createNotificationSeeds[14], "", http.StatusBadRequest},
+		{"too large", `{"user_id":"u-1","event_type":"comment","content":"` + strings.Repeat("a", 64<<10) + `"}`, "", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := createNotification(server, tt.body, tt.apiKey)
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d (%s), want %d", recorder.Code, strings.TrimSpace(recorder.Body.String()), tt.status)
+			}
+
+			events := producer.take()
+			if tt.status != http.StatusAccepted {
+				if len(events) != 0 {
+					t.Fatalf("rejected request produced %d events", len(events))
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("produced %d events, want 1", len(events))
+			}
+			if event := events[0]; event.SchemaVersion != models.SchemaVersion || event.ID == "" || event.CreatedAt == 0 {
+				t.Errorf("event = %+v, want a versioned event with an ID and creation time", event)
+			}
+			if bypassed := events[0].Bypass != nil; bypassed != (tt.apiKey == "bypass-key") {
+				t.Errorf("bypass = %+v", events[0].Bypass)
+			}
+		})
+	}
+}
+
+func TestRateLimitReported(t *testing.T) {
+	limiter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/quota/u-1" || r.URL.Query().Get("event_type") != "like" || r.URL.Query().Get("tenant") != "acme" {
+			t.Errorf("quota request %s", r.URL)
+		}
+		json.NewEncoder(w).Encode(models.RateLimitResult{Remaining: 12, ResetAt: 1767225600})
+	}))
+	defer limiter.Close()
+
+	server, producer := newRateLimitedTestServer(t, ratelimit.NewClient(limiter.URL, time.Second))
+	recorder := createNotification(server, createNotificationSeeds[1], "")
+	producer.take()
+	var response struct {
+		RateLimit *models.RateLimitResult `json:"rate_limit"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.RateLimit == nil || response.RateLimit.Remaining != 12 {
+		t.Fatalf("response %s doesn't carry the quota", recorder.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/u-1/rate-limit?event_type=like&tenant=acme", nil)
+	status := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(status, req)
+	if status.Code != http.StatusOK || !strings.Contains(status.Body.String(), `"remaining":12`) {
+		t.Errorf("status API = %d (%s)", status.Code, status.Body.String())
+	}
+
+	// An unreachable rate limiter leaves the quota out rather than failing the request
+	limiter.Close()
+	recorder = createNotification(server, createNotificationSeeds[1], "")
+	producer.take()
+	if recorder.Code != http.StatusAccepted || strings.Contains(recorder.Body.String(), "rate_limit") {
+		t.Errorf("status = %d (%s) with the rate limiter down", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestEngagementRequiresToken(t *testing.T) {
+	server, producer := newTestServer(t)
+	token := tracking.Token([]byte("engagement-secret"), "n-1", "u-1")
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"open", "/api/v1/notifications/n-1/opens", `{"user_id":"u-1","channel":"push","token":"` + token + `"}`, http.StatusAccepted},
+		{"click", "/api/v1/notifications/n-1/actions/view/clicks", `{"user_id":"u-1","channel":"email","token":"` + token + `"}`, http.StatusAccepted},
+		{"no token", "/api/v1/notifications/n-1/opens", `{"user_id":"u-1","channel":"push"}`, http.StatusForbidden},
+		{"other user", "/api/v1/notifications/n-1/opens", `{"user_id":"u-2","channel":"push","token":"` + token + `"}`, http.StatusForbidden},
+		{"other notification", "/api/v1/notifications/n-2/actions/view/clicks", `{"user_id":"u-1","channel":"push","token":"` + token + `"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d (%s), want %d", recorder.Code, strings.TrimSpace(recorder.Body.String()), tt.status)
+			}
+		})
+	}
+
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	if len(producer.statuses) != 2 || producer.statuses[0].Token != token {
+		t.Errorf("published %d status events, want the 2 with valid tokens", len(producer.statuses))
+	}
+}
+
+func FuzzCreateNotification(f *testing.F) {
+	for _, seed := range createNotificationSeeds {
+		f.Add(seed)
+	}
+	server, producer := newTestServer(f)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		recorder := createNotification(server, body, "bypass-key")
+		events := producer.take()
+
+		switch recorder.Code {
+		case http.StatusAccepted:
+		case http.StatusBadRequest, http.StatusForbidden, http.StatusRequestEntityTooLarge:
+			if len(events) != 0 {
+				t.Fatalf("rejected request produced %d events", len(events))
+			}
+			return
+		default:
+			t.Fatalf("status = %d (%s)", recorder.Code, recorder.Body.String())
+		}
+
+		// Only a single well-formed request is accepted, never a partial decoding of one
+		var req models.NotificationRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatalf("accepted a malformed request: %v", err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("produced %d events, want 1", len(events))
+		}
+		event := events[0]
+		if event.EventType == "" || (event.UserID == "" && event.Entity == nil && len(event.Recipients) == 0) {
+			t.Fatalf("produced an event without an event type or anyone to send it to: %+v", event)
+		}
+		if event.EventType != req.EventType || event.UserID != req.UserID {
+			t.Fatalf("event %+v doesn't match the request %+v", event, req)
+		}
+
+		var response struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.ID != event.ID {
+			t.Fatalf("response %s doesn't name the event %s", recorder.Body.String(), event.ID)
+		}
+
+		// Consumers must be able to decode what was produced
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatalf("marshaling event: %v", err)
+		}
+		var decoded models.NotificationEvent
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("decoding produced event %s: %v", data, err)
+		}
+	})
+}
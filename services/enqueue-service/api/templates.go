@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/templates"
+)
+
+// Handles requests for the stored email templates
+func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"templates": s.templates.Templates(),
+	})
+}
+
+// Handles requests for an email template
+func (s *Server) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.templates.Get(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// Handles requests to store an email template, replacing the one of that name
+func (s *Server) handlePutTemplate(w http.ResponseWriter, r *http.Request) {
+	var t templates.Template
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	if err := decodeBody(r.Body, &t); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	t.Name = r.PathValue("name")
+
+	if err := s.templates.Put(t); errors.Is(err, templates.ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Failed to store email template %s: %v", t.Name, err)
+		http.Error(w, "Failed to store template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// Handles requests to delete an email template
+func (s *Server) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	removed, err := s.templates.Remove(name)
+	if err != nil {
+		log.Printf("Failed to remove email template %s: %v", name, err)
+		http.Error(w, "Failed to remove template", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles requests to render an email template with sample data, for
+// checking it before notifications use it. The response also says whether
+// the email would be accepted on a notification.
+func (s *Server) handlePreviewTemplate(w http.ResponseWriter, r *http.Request) {
+	var data templates.Data
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	if err := decodeBody(r.Body, &data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email, err := s.templates.Render(r.PathValue("name"), data)
+	if errors.Is(err, templates.ErrNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	preview := map[string]any{"email": email}
+	if warnings, err := models.ProcessChannelContent(&models.ChannelContent{Email: email}, s.content); err != nil {
+		preview["error"] = fmt.Sprintf("Invalid channel content: %v", err)
+	} else if len(warnings) > 0 {
+		preview["warnings"] = warnings
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
@@ -0,0 +1,67 @@
+package bypass
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+)
+
+// Longest accepted bypass reason
+const MaxReasonLength = 256
+
+// Signer grants notifications of restricted API keys a signed bypass of rate
+// limiting and preference suppression, which the rate limiter verifies with
+// the same secret
+type Signer struct {
+	secret []byte
+	keys   map[string]bool // API keys allowed to request a bypass
+}
+
+// NewSigner creates a signer for the API keys allowed to request a bypass,
+// nil when no secret is configured
+func NewSigner(secret string, apiKeys []string) *Signer {
+	if secret == "" {
+		return nil
+	}
+
+	keys := make(map[string]bool, len(apiKeys))
+	for _, key := range apiKeys {
+		keys[key] = true
+	}
+	return &Signer{secret: []byte(secret), keys: keys}
+}
+
+// Allowed reports whether an API key may request a bypass
+func (s *Signer) Allowed(apiKey string) bool {
+	return s != nil && apiKey != "" && s.keys[apiKey]
+}
+
+// Sign grants the event a bypass for the reason
+func (s *Signer) Sign(event *models.NotificationEvent, reason string) {
+	grant := &models.Bypass{
+		Reason:   reason,
+		IssuedAt: time.Now().Unix(),
+	}
+	grant.Signature = Signature(s.secret, event, grant)
+	event.Bypass = grant
+}
+
+// Signature returns the HMAC-SHA256 binding a bypass to the notification's
+// ID, user, event type and API key, so it can't be moved to another one
+func Signature(secret []byte, event *models.NotificationEvent, grant *models.Bypass) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{
+		event.ID,
+		event.UserID,
+		event.EventType,
+		event.APIKeyID,
+		grant.Reason,
+		strconv.FormatInt(grant.IssuedAt, 10),
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
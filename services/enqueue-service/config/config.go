@@ -1,76 +1,456 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
 	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/quota"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/ratelimit"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/storage"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/templates"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/encryption"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/envconfig"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/secrets"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
 )
 
 // HTTP server config
 type ServerConfig struct {
-    Port         int
-    ReadTimeout  time.Duration
-    WriteTimeout time.Duration
-    IdleTimeout  time.Duration
+	Port                  int
+	ReadTimeout           time.Duration
+	WriteTimeout          time.Duration
+	IdleTimeout           time.Duration
+	MaxBodyBytes          int                   // Largest accepted request body
+	SandboxAPIKeys        []string              // API keys whose notifications always run in sandbox mode
+	BypassAPIKeys         []string              // API keys allowed to request a bypass of rate limits and opt-outs
+	BypassSecret          string                // Signs bypasses, shared with the rate limiter; empty disables bypasses
+	EngagementTokenSecret string                // Verifies the tokens of opens and action clicks, shared with the rate limiter; empty accepts any
+	Metadata              models.MetadataLimits // Limits on notification metadata
+	Content               models.ContentLimits  // Limits on channel content
 }
 
 // Kafka Topic config
 type KafkaConfig struct {
-    Brokers          []string
-    Topic            string
-    RetryMax         int
-    RequiredAcks     int
-    DeliveryReport   bool
-    Partitions       int  
-    ReplicationFactor int
+	Brokers           []string
+	Topic             string
+	StatusTopic       string // Topic for action clicks and other status events
+	RetryMax          int
+	RequiredAcks      int
+	DeliveryReport    bool
+	Partitions        int
+	ReplicationFactor int
+	SendTimeout       time.Duration
+	MaxMessageBytes   int            // Largest event accepted by the brokers
+	SecondaryBrokers  []string       // Optional secondary cluster notifications and status events are also written to
+	SecondaryMode     string         // "mirror" writes every message to both clusters, "failover" only what the primary fails to take
+	FailbackAfter     time.Duration  // How long failed over messages skip the primary before it is tried again
+	Signing           signing.Config // Keys produced messages are signed with
+}
+
+// Producer returns the settings the producers of the service share
+func (c KafkaConfig) Producer() kafkaproducer.Config {
+	return kafkaproducer.Config{
+		Brokers:           c.Brokers,
+		RequiredAcks:      c.RequiredAcks,
+		RetryMax:          c.RetryMax,
+		MaxMessageBytes:   c.MaxMessageBytes,
+		Partitions:        c.Partitions,
+		ReplicationFactor: c.ReplicationFactor,
+		Signing:           c.Signing,
+	}
+}
+
+// Field-level encryption config
+type EncryptionConfig struct {
+	Enabled      bool
+	ActiveKeyID  string            // Key used to encrypt new notifications
+	Keys         map[string]string // Base64 encoded 256-bit keys by ID, older keys stay for decryption
+	MetadataKeys []string          // Metadata entries encrypted along with the content
+}
+
+// Claim check config for notification bodies too large to pass through Kafka
+type ClaimCheckConfig struct {
+	Enabled   bool
+	Threshold int // Content larger than this many bytes is offloaded to object storage
+	Storage   storage.Config
+}
+
+// Debug sampling config
+type DebugConfig struct {
+	SamplePercent int      // Share of notifications traced, zero disables sampling
+	UserIDs       []string // Users whose notifications are always traced
+	Topic         string   // Topic traces are sent to
+}
+
+// Event types accepted at ingestion
+type EventTypesConfig struct {
+	Allow        []string      // Accepted event types, empty accepts all that aren't denied
+	Deny         []string      // Rejected event types, checked before the allow list
+	File         string        // Optional JSON file with "allow" and "deny" lists, replaces the env lists
+	PollInterval time.Duration // How often the file is checked for changes
+}
+
+// Email template config, templates are disabled without a directory
+type TemplatesConfig struct {
+	Dir          string        // Directory of the template files, shared by the instances
+	PollInterval time.Duration // How often the directory is checked for templates other instances stored
+}
+
+// Per-API-key quota config, counted in Redis
+type QuotaConfig struct {
+	Enabled        bool
+	RedisAddr      string
+	RedisPassword  string
+	RedisDB        int
+	Default        quota.Limits            // Limits of API keys without their own, zero is unlimited
+	Keys           map[string]quota.Limits // Limits by API key ID (the api_key_id notifications carry)
+	AlertPercents  []int                   // Shares of a quota used at which the webhook is notified
+	WebhookURL     string
+	WebhookTimeout time.Duration
+}
+
+// Where users' rate-limit quota is read from, disabled without a URL
+type RateLimitConfig struct {
+	URL     string        // Base URL of the rate limiter's admin server
+	Timeout time.Duration // How long a quota lookup may hold up a response
 }
 
 // Main config
 type Config struct {
-    Server          ServerConfig
-    Kafka           KafkaConfig
-    ShutdownTimeout time.Duration
+	Server     ServerConfig
+	Kafka      KafkaConfig
+	Encryption EncryptionConfig
+	ClaimCheck ClaimCheckConfig
+	Debug      DebugConfig
+	EventTypes EventTypesConfig
+	Templates  TemplatesConfig
+	Quota      QuotaConfig
+	RateLimit  RateLimitConfig
+	Startup    StartupConfig
+	Heartbeat  HeartbeatConfig
+	Shutdown   ShutdownConfig
+	Secrets    secrets.Config
+
+	secretStore *secrets.Store // Resolves the secrets Load read, and keeps them current
+}
+
+// Returns the store the configuration's secrets were resolved from
+func (c *Config) SecretStore() *secrets.Store {
+	return c.secretStore
+}
+
+// Holds how long the service waits for Kafka at startup
+type StartupConfig struct {
+	Preflight       bool          // Check the dependencies before creating any client
+	RetryTimeout    time.Duration // Give up after this long, zero tries once
+	RetryBackoff    time.Duration // Wait after the first failed attempt, doubled after every further one
+	RetryMaxBackoff time.Duration
+}
+
+// Holds where and how often the instance announces it is alive
+type HeartbeatConfig struct {
+	Topic      string        // Internal ops topic heartbeats are published to
+	Interval   time.Duration // Zero disables heartbeats
+	InstanceID string        // Defaults to the host name, the container ID under Docker
+}
+
+// Holds the timeouts of the shutdown stages, run in this order
+type ShutdownConfig struct {
+	Drain time.Duration // Stop accepting requests and finish the ones in flight
+	Flush time.Duration // Flush and close the producers
+	Close time.Duration // Close the remaining clients
 }
 
 // DefaultConfig
 var DefaultConfig = Config{
-    Server: ServerConfig{
-        Port:         8080,
-        ReadTimeout:  5 * time.Second,
-        WriteTimeout: 10 * time.Second,
-        IdleTimeout:  60 * time.Second,
-    },
-    Kafka: KafkaConfig{
-        Brokers:          []string{"localhost:9092"}, // one for now
-        Topic:            "notifications.raw",
-        RetryMax:         3,
-        RequiredAcks:     1,
-        DeliveryReport:   true,
-        Partitions:       3,
-        ReplicationFactor: 2,
-    },
-    ShutdownTimeout: 10 * time.Second,
+	Server: ServerConfig{
+		Port:         8080,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		MaxBodyBytes: 10 << 20,
+		Metadata: models.MetadataLimits{
+			MaxBytes:      64 << 10,
+			MaxDepth:      8,
+			MaxValueBytes: 16 << 10,
+			KnownKeys:     []string{models.MetadataTenant},
+		},
+		Content: models.ContentLimits{
+			SMSMaxSegments: 1,
+		},
+	},
+	Kafka: KafkaConfig{
+		Brokers:           []string{"localhost:9092"}, // one for now
+		Topic:             "notifications.raw",
+		StatusTopic:       "notifications.status",
+		RetryMax:          3,
+		RequiredAcks:      1,
+		DeliveryReport:    true,
+		Partitions:        3,
+		ReplicationFactor: 2,
+		SendTimeout:       5 * time.Second,
+		MaxMessageBytes:   1000000, // Kafka's default message.max.bytes
+		SecondaryMode:     "failover",
+		FailbackAfter:     30 * time.Second,
+	},
+	Encryption: EncryptionConfig{
+		Enabled:      false,
+		MetadataKeys: []string{"email", "phone", "address"},
+	},
+	ClaimCheck: ClaimCheckConfig{
+		Enabled:   false,
+		Threshold: 256 << 10,
+		Storage: storage.Config{
+			Endpoint: "localhost:9000",
+			Bucket:   "notification-payloads",
+		},
+	},
+	Debug: DebugConfig{
+		Topic: "notifications.debug",
+	},
+	EventTypes: EventTypesConfig{
+		PollInterval: 10 * time.Second,
+	},
+	Templates: TemplatesConfig{
+		PollInterval: 10 * time.Second,
+	},
+	Quota: QuotaConfig{
+		Enabled:        false,
+		RedisAddr:      "localhost:6379",
+		AlertPercents:  []int{80, 100},
+		WebhookTimeout: 5 * time.Second,
+	},
+	RateLimit: RateLimitConfig{
+		Timeout: 200 * time.Millisecond,
+	},
+	Startup: StartupConfig{
+		Preflight:       true,
+		RetryTimeout:    2 * time.Minute,
+		RetryBackoff:    time.Second,
+		RetryMaxBackoff: 15 * time.Second,
+	},
+	Heartbeat: HeartbeatConfig{
+		Topic:    "notifications.ops",
+		Interval: 10 * time.Second,
+	},
+	Shutdown: ShutdownConfig{
+		Drain: 10 * time.Second,
+		Flush: 5 * time.Second,
+		Close: 5 * time.Second,
+	},
+	Secrets: secrets.Config{
+		RefreshInterval: 5 * time.Minute,
+	},
 }
 
 // Loads config from environment variables
 func Load() (*Config, error) {
-    cfg := DefaultConfig
-
-    // Server config
-    LoadIntEnv("SERVER_PORT", &cfg.Server.Port)
-    LoadDurationEnv("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
-    LoadDurationEnv("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
-    LoadDurationEnv("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
-    
-    // Kafka config
-    LoadJSONStringArrayEnv("KAFKA_BROKERS", &cfg.Kafka.Brokers)
-    LoadStringEnv("KAFKA_TOPIC", &cfg.Kafka.Topic)
-    LoadIntEnv("KAFKA_RETRY_MAX", &cfg.Kafka.RetryMax)
-    LoadIntEnv("KAFKA_REQUIRED_ACKS", &cfg.Kafka.RequiredAcks)
-    LoadBoolEnv("KAFKA_DELIVERY_REPORT", &cfg.Kafka.DeliveryReport)
-    LoadIntEnv("KAFKA_PARTITIONS", &cfg.Kafka.Partitions)
-    LoadIntEnv("KAFKA_REPLICATION_FACTOR", &cfg.Kafka.ReplicationFactor)
-    
-    // General config
-    LoadDurationEnv("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
-
-    return &cfg, nil
-}
\ No newline at end of file
+	cfg := DefaultConfig
+
+	// Secrets config first, any secret below may refer to a provider
+	envconfig.LoadStringEnv("VAULT_ADDR", &cfg.Secrets.Vault.Addr)
+	envconfig.LoadStringEnv("VAULT_TOKEN", &cfg.Secrets.Vault.Token)
+	envconfig.LoadStringEnv("VAULT_NAMESPACE", &cfg.Secrets.Vault.Namespace)
+	envconfig.LoadStringEnv("AWS_REGION", &cfg.Secrets.SSM.Region)
+	envconfig.LoadStringEnv("AWS_ACCESS_KEY_ID", &cfg.Secrets.SSM.AccessKeyID)
+	envconfig.LoadStringEnv("AWS_SECRET_ACCESS_KEY", &cfg.Secrets.SSM.SecretAccessKey)
+	envconfig.LoadStringEnv("AWS_SESSION_TOKEN", &cfg.Secrets.SSM.SessionToken)
+	envconfig.LoadStringEnv("SSM_ENDPOINT", &cfg.Secrets.SSM.Endpoint)
+	envconfig.LoadDurationEnv("SECRETS_REFRESH_INTERVAL", &cfg.Secrets.RefreshInterval)
+	store := secrets.New(cfg.Secrets)
+	cfg.secretStore = store
+
+	// Server config
+	envconfig.LoadIntEnv("SERVER_PORT", &cfg.Server.Port)
+	envconfig.LoadDurationEnv("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	envconfig.LoadDurationEnv("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	envconfig.LoadDurationEnv("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	envconfig.LoadIntEnv("SERVER_MAX_BODY_BYTES", &cfg.Server.MaxBodyBytes)
+	envconfig.LoadJSONStringArrayEnv("SANDBOX_API_KEYS", &cfg.Server.SandboxAPIKeys)
+	envconfig.LoadJSONStringArrayEnv("BYPASS_API_KEYS", &cfg.Server.BypassAPIKeys)
+	if err := store.Load("BYPASS_SECRET", &cfg.Server.BypassSecret); err != nil {
+		return nil, err
+	}
+	if err := store.Load("ENGAGEMENT_TOKEN_SECRET", &cfg.Server.EngagementTokenSecret); err != nil {
+		return nil, err
+	}
+	envconfig.LoadIntEnv("METADATA_MAX_BYTES", &cfg.Server.Metadata.MaxBytes)
+	envconfig.LoadIntEnv("METADATA_MAX_DEPTH", &cfg.Server.Metadata.MaxDepth)
+	envconfig.LoadIntEnv("METADATA_MAX_VALUE_BYTES", &cfg.Server.Metadata.MaxValueBytes)
+	envconfig.LoadJSONStringArrayEnv("METADATA_KNOWN_KEYS", &cfg.Server.Metadata.KnownKeys)
+	if cfg.Server.Metadata.MaxBytes < 0 || cfg.Server.Metadata.MaxDepth < 0 || cfg.Server.Metadata.MaxValueBytes < 0 {
+		return nil, fmt.Errorf("METADATA_MAX_BYTES, METADATA_MAX_DEPTH and METADATA_MAX_VALUE_BYTES must not be negative")
+	}
+	envconfig.LoadIntEnv("SMS_MAX_SEGMENTS", &cfg.Server.Content.SMSMaxSegments)
+	if cfg.Server.Content.SMSMaxSegments < 0 {
+		return nil, fmt.Errorf("SMS_MAX_SEGMENTS must not be negative")
+	}
+
+	// Kafka config
+	envconfig.LoadJSONStringArrayEnv("KAFKA_BROKERS", &cfg.Kafka.Brokers)
+	envconfig.LoadStringEnv("KAFKA_TOPIC", &cfg.Kafka.Topic)
+	envconfig.LoadStringEnv("KAFKA_STATUS_TOPIC", &cfg.Kafka.StatusTopic)
+	envconfig.LoadIntEnv("KAFKA_RETRY_MAX", &cfg.Kafka.RetryMax)
+	envconfig.LoadIntEnv("KAFKA_REQUIRED_ACKS", &cfg.Kafka.RequiredAcks)
+	envconfig.LoadBoolEnv("KAFKA_DELIVERY_REPORT", &cfg.Kafka.DeliveryReport)
+	envconfig.LoadIntEnv("KAFKA_PARTITIONS", &cfg.Kafka.Partitions)
+	envconfig.LoadIntEnv("KAFKA_REPLICATION_FACTOR", &cfg.Kafka.ReplicationFactor)
+	envconfig.LoadDurationEnv("KAFKA_SEND_TIMEOUT", &cfg.Kafka.SendTimeout)
+	envconfig.LoadIntEnv("KAFKA_MAX_MESSAGE_BYTES", &cfg.Kafka.MaxMessageBytes)
+	envconfig.LoadJSONStringArrayEnv("KAFKA_SECONDARY_BROKERS", &cfg.Kafka.SecondaryBrokers)
+	envconfig.LoadStringEnv("KAFKA_SECONDARY_MODE", &cfg.Kafka.SecondaryMode)
+	envconfig.LoadDurationEnv("KAFKA_FAILBACK_AFTER", &cfg.Kafka.FailbackAfter)
+	if cfg.Kafka.SecondaryMode != "mirror" && cfg.Kafka.SecondaryMode != "failover" {
+		return nil, fmt.Errorf("KAFKA_SECONDARY_MODE must be mirror or failover")
+	}
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "KAFKA_SIGNING_KEYS", &cfg.Kafka.Signing.Keys); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("KAFKA_SIGNING_ACTIVE_KEY_ID", &cfg.Kafka.Signing.ActiveKeyID)
+	if _, err := cfg.Kafka.Signing.CreateKeyring(); err != nil {
+		return nil, fmt.Errorf("KAFKA_SIGNING_KEYS are invalid: %w", err)
+	}
+
+	// Encryption config
+	envconfig.LoadBoolEnv("ENCRYPTION_ENABLED", &cfg.Encryption.Enabled)
+	envconfig.LoadStringEnv("ENCRYPTION_ACTIVE_KEY_ID", &cfg.Encryption.ActiveKeyID)
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "ENCRYPTION_KEYS", &cfg.Encryption.Keys); err != nil {
+		return nil, err
+	}
+	envconfig.LoadJSONStringArrayEnv("ENCRYPTION_METADATA_KEYS", &cfg.Encryption.MetadataKeys)
+
+	// Claim check config
+	envconfig.LoadBoolEnv("CLAIM_CHECK_ENABLED", &cfg.ClaimCheck.Enabled)
+	envconfig.LoadIntEnv("CLAIM_CHECK_THRESHOLD", &cfg.ClaimCheck.Threshold)
+	envconfig.LoadStringEnv("OBJECT_STORAGE_ENDPOINT", &cfg.ClaimCheck.Storage.Endpoint)
+	if err := store.Load("OBJECT_STORAGE_ACCESS_KEY", &cfg.ClaimCheck.Storage.AccessKey); err != nil {
+		return nil, err
+	}
+	if err := store.Load("OBJECT_STORAGE_SECRET_KEY", &cfg.ClaimCheck.Storage.SecretKey); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("OBJECT_STORAGE_BUCKET", &cfg.ClaimCheck.Storage.Bucket)
+	envconfig.LoadBoolEnv("OBJECT_STORAGE_USE_SSL", &cfg.ClaimCheck.Storage.UseSSL)
+
+	// Debug sampling config
+	envconfig.LoadIntEnv("DEBUG_SAMPLE_PERCENT", &cfg.Debug.SamplePercent)
+	envconfig.LoadJSONStringArrayEnv("DEBUG_USER_IDS", &cfg.Debug.UserIDs)
+	envconfig.LoadStringEnv("DEBUG_TOPIC", &cfg.Debug.Topic)
+	if cfg.Debug.SamplePercent < 0 || cfg.Debug.SamplePercent > 100 {
+		return nil, fmt.Errorf("DEBUG_SAMPLE_PERCENT must be between 0 and 100")
+	}
+
+	// Event type lists
+	envconfig.LoadJSONStringArrayEnv("EVENT_TYPES_ALLOW", &cfg.EventTypes.Allow)
+	envconfig.LoadJSONStringArrayEnv("EVENT_TYPES_DENY", &cfg.EventTypes.Deny)
+	envconfig.LoadStringEnv("EVENT_TYPES_FILE", &cfg.EventTypes.File)
+	envconfig.LoadDurationEnv("EVENT_TYPES_POLL_INTERVAL", &cfg.EventTypes.PollInterval)
+
+	// Email template config
+	envconfig.LoadStringEnv("EMAIL_TEMPLATES_DIR", &cfg.Templates.Dir)
+	envconfig.LoadDurationEnv("EMAIL_TEMPLATES_POLL_INTERVAL", &cfg.Templates.PollInterval)
+
+	// Quota config
+	envconfig.LoadBoolEnv("QUOTA_ENABLED", &cfg.Quota.Enabled)
+	envconfig.LoadStringEnv("QUOTA_REDIS_ADDR", &cfg.Quota.RedisAddr)
+	if err := store.Load("QUOTA_REDIS_PASSWORD", &cfg.Quota.RedisPassword); err != nil {
+		return nil, err
+	}
+	envconfig.LoadIntEnv("QUOTA_REDIS_DB", &cfg.Quota.RedisDB)
+	envconfig.LoadIntEnv("QUOTA_DAILY_LIMIT", &cfg.Quota.Default.Daily)
+	envconfig.LoadIntEnv("QUOTA_MONTHLY_LIMIT", &cfg.Quota.Default.Monthly)
+	if value := os.Getenv("QUOTA_KEY_LIMITS"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Quota.Keys); err != nil {
+			return nil, fmt.Errorf("invalid QUOTA_KEY_LIMITS: %w", err)
+		}
+	}
+	if value := os.Getenv("QUOTA_ALERT_PERCENTS"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Quota.AlertPercents); err != nil {
+			return nil, fmt.Errorf("invalid QUOTA_ALERT_PERCENTS: %w", err)
+		}
+	}
+	envconfig.LoadStringEnv("QUOTA_WEBHOOK_URL", &cfg.Quota.WebhookURL)
+	envconfig.LoadDurationEnv("QUOTA_WEBHOOK_TIMEOUT", &cfg.Quota.WebhookTimeout)
+
+	// Rate-limit quota config
+	envconfig.LoadStringEnv("RATE_LIMITER_ADMIN_URL", &cfg.RateLimit.URL)
+	envconfig.LoadDurationEnv("RATE_LIMITER_QUOTA_TIMEOUT", &cfg.RateLimit.Timeout)
+
+	// Load heartbeat config
+	envconfig.LoadStringEnv("OPS_TOPIC", &cfg.Heartbeat.Topic)
+	envconfig.LoadDurationEnv("HEARTBEAT_INTERVAL", &cfg.Heartbeat.Interval)
+	envconfig.LoadStringEnv("INSTANCE_ID", &cfg.Heartbeat.InstanceID)
+	if cfg.Heartbeat.InstanceID == "" {
+		cfg.Heartbeat.InstanceID, _ = os.Hostname()
+	}
+
+	// General config
+	envconfig.LoadBoolEnv("STARTUP_PREFLIGHT", &cfg.Startup.Preflight)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_TIMEOUT", &cfg.Startup.RetryTimeout)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_BACKOFF", &cfg.Startup.RetryBackoff)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_MAX_BACKOFF", &cfg.Startup.RetryMaxBackoff)
+	envconfig.LoadDurationEnv("SHUTDOWN_TIMEOUT", &cfg.Shutdown.Drain) // Legacy name of the drain timeout
+	envconfig.LoadDurationEnv("SHUTDOWN_DRAIN_TIMEOUT", &cfg.Shutdown.Drain)
+	envconfig.LoadDurationEnv("SHUTDOWN_FLUSH_TIMEOUT", &cfg.Shutdown.Flush)
+	envconfig.LoadDurationEnv("SHUTDOWN_CLOSE_TIMEOUT", &cfg.Shutdown.Close)
+
+	return &cfg, nil
+}
+
+// Creates the field encryptor, nil when encryption is disabled
+func (c *Config) CreateEncryptor() (*encryption.FieldEncryptor, error) {
+	if !c.Encryption.Enabled {
+		return nil, nil
+	}
+
+	keys, err := encryption.NewStaticKeyProvider(c.Encryption.ActiveKeyID, c.Encryption.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption keys: %w", err)
+	}
+
+	return encryption.NewFieldEncryptor(keys, c.Encryption.MetadataKeys), nil
+}
+
+// Creates the email template store, nil when templates are disabled
+func (c *Config) CreateTemplates() (*templates.Store, error) {
+	if c.Templates.Dir == "" {
+		return nil, nil
+	}
+
+	return templates.New(templates.Config{
+		Dir:          c.Templates.Dir,
+		PollInterval: c.Templates.PollInterval,
+	})
+}
+
+// Creates the quota enforcer, nil when quotas are disabled
+func (c *Config) CreateQuotaEnforcer() (*quota.Enforcer, error) {
+	if !c.Quota.Enabled {
+		return nil, nil
+	}
+
+	return quota.NewEnforcer(quota.Config{
+		RedisAddr:       c.Quota.RedisAddr,
+		RedisPassword:   c.Quota.RedisPassword,
+		CurrentPassword: c.secretStore.Current("QUOTA_REDIS_PASSWORD"),
+		RedisDB:         c.Quota.RedisDB,
+		Default:         c.Quota.Default,
+		Keys:            c.Quota.Keys,
+		AlertPercents:   c.Quota.AlertPercents,
+		WebhookURL:      c.Quota.WebhookURL,
+		WebhookTimeout:  c.Quota.WebhookTimeout,
+	})
+}
+
+// Creates the client reading users' rate-limit quota, nil without a rate limiter URL
+func (c *Config) CreateRateLimitClient() *ratelimit.Client {
+	if c.RateLimit.URL == "" {
+		return nil
+	}
+
+	return ratelimit.NewClient(c.RateLimit.URL, c.RateLimit.Timeout)
+}
@@ -0,0 +1,43 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/storage"
+)
+
+// ClaimCheckProducer wraps a Producer so that large notification bodies are
+// stored in the blob store and only a reference to them goes through Kafka
+type ClaimCheckProducer struct {
+	Producer
+	store     storage.BlobStore
+	threshold int // Content larger than this many bytes is offloaded
+}
+
+// Creates a producer that offloads content larger than threshold bytes
+func NewClaimCheckProducer(producer Producer, store storage.BlobStore, threshold int) Producer {
+	return &ClaimCheckProducer{
+		Producer:  producer,
+		store:     store,
+		threshold: threshold,
+	}
+}
+
+// Offloads the content if it is too large and sends the event, the caller's event is left untouched
+func (p *ClaimCheckProducer) SendMessage(ctx context.Context, event *models.NotificationEvent) error {
+	if len(event.Content) <= p.threshold {
+		return p.Producer.SendMessage(ctx, event)
+	}
+
+	ref, err := p.store.Put(ctx, "notifications/"+event.ID, []byte(event.Content))
+	if err != nil {
+		return fmt.Errorf("failed to offload content: %w", err)
+	}
+
+	offloaded := *event
+	offloaded.Content = ""
+	offloaded.ContentRef = ref
+	return p.Producer.SendMessage(ctx, &offloaded)
+}
@@ -0,0 +1,52 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/debugtrace"
+)
+
+// Name this service uses in debug traces
+const traceService = "enqueue-service"
+
+// DebugTracingProducer wraps a Producer so that sampled notifications are
+// traced exactly as they are written to Kafka
+type DebugTracingProducer struct {
+	Producer
+	tracer debugtrace.Tracer
+}
+
+// Creates a producer that traces notifications marked for debugging
+func NewDebugTracingProducer(producer Producer, tracer debugtrace.Tracer) Producer {
+	return &DebugTracingProducer{
+		Producer: producer,
+		tracer:   tracer,
+	}
+}
+
+// Sends the event and traces it when it is marked for debugging
+func (p *DebugTracingProducer) SendMessage(ctx context.Context, event *models.NotificationEvent) error {
+	err := p.Producer.SendMessage(ctx, event)
+	if !event.Debug {
+		return err
+	}
+
+	trace := &models.DebugTrace{
+		NotificationID: event.ID,
+		RequestID:      event.RequestID,
+		UserID:         event.UserID,
+		Service:        traceService,
+		Stage:          "enqueued",
+		Payload:        event,
+		Timestamp:      time.Now().UnixMilli(),
+	}
+	if err != nil {
+		trace.Stage = "enqueue_failed"
+		trace.Details = map[string]any{"error": err.Error()}
+	}
+	p.tracer.Trace(ctx, trace)
+
+	return err
+}
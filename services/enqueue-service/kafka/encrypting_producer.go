@@ -0,0 +1,32 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/encryption"
+)
+
+// EncryptingProducer wraps a Producer so that notification content and
+// sensitive metadata are encrypted before they are written to Kafka
+type EncryptingProducer struct {
+	Producer
+	encryptor *encryption.FieldEncryptor
+}
+
+// Creates a producer that encrypts events before sending them
+func NewEncryptingProducer(producer Producer, encryptor *encryption.FieldEncryptor) Producer {
+	return &EncryptingProducer{
+		Producer:  producer,
+		encryptor: encryptor,
+	}
+}
+
+// Encrypts the event and sends it, the caller's event is left untouched
+func (p *EncryptingProducer) SendMessage(ctx context.Context, event *models.NotificationEvent) error {
+	encrypted, err := p.encryptor.Encrypt(event)
+	if err != nil {
+		return err
+	}
+	return p.Producer.SendMessage(ctx, encrypted)
+}
@@ -0,0 +1,43 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Headers describing a notification, so consumers can skip messages without unmarshalling them
+const (
+	EventTypeHeader = "X-Event-Type"
+	TenantHeader    = "X-Tenant"
+	PriorityHeader  = "X-Priority"
+)
+
+// Returns the Kafka headers of a notification message, leaving out empty values
+func notificationHeaders(requestID, eventType string, metadata map[string]any, priority string) []sarama.RecordHeader {
+	headers := requestIDHeaders(requestID)
+	for _, header := range [][2]string{
+		{EventTypeHeader, eventType},
+		{TenantHeader, tenantOf(metadata)},
+		{PriorityHeader, priority},
+	} {
+		if header[1] != "" {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(header[0]), Value: []byte(header[1])})
+		}
+	}
+	return headers
+}
+
+// Returns the Kafka headers carrying the request ID, if there is one
+func requestIDHeaders(requestID string) []sarama.RecordHeader {
+	if requestID == "" {
+		return nil
+	}
+	return []sarama.RecordHeader{{Key: []byte(logging.RequestIDHeader), Value: []byte(requestID)}}
+}
+
+// Returns the tenant named in a notification's metadata, empty when there is none
+func tenantOf(metadata map[string]any) string {
+	tenant, _ := metadata[models.MetadataTenant].(string)
+	return tenant
+}
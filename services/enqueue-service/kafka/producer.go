@@ -1,92 +1,106 @@
 package kafka
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/config"
 	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/jsonpool"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
 )
 
+// Returned when an event is larger than the brokers accept
+var ErrPayloadTooLarge = errors.New("event payload too large")
+
 // Interface for sending messages to Kafka
 type Producer interface {
-    SendMessage(event *models.NotificationEvent) error
+    SendMessage(ctx context.Context, event *models.NotificationEvent) error
     Close() error
 }
 
 // Main producer Implements the Producer interface using Sarama
 type KafkaProducer struct {
-    producer sarama.SyncProducer
-    topic    string
+    producer    sarama.SyncProducer
+    topic       string
+    sendTimeout time.Duration // Zero means no timeout
+    maxBytes    int           // Largest payload the brokers accept
 }
 
 // Creates a new Kafka producer
 func NewProducer(cfg config.KafkaConfig) (Producer, error) {
 
-    // Configure Sarama
-    config := sarama.NewConfig()
-    config.Producer.RequiredAcks = sarama.RequiredAcks(cfg.RequiredAcks)
-    config.Producer.Retry.Max = cfg.RetryMax
-    config.Producer.Return.Successes = true
-    
-    // Create topic manager and ensure topic exists
-    topicManager, err := NewTopicManager(cfg.Brokers)
-
-    if err != nil {
-        return nil, fmt.Errorf("failed to create topic manager: %w", err)
-    }
-    
-    defer topicManager.Close()
-    
-    if err := topicManager.EnsureTopicExists(cfg); err != nil {
-        return nil, fmt.Errorf("failed to ensure topic exists: %w", err)
-    }
-    
-    // Create the sarama producer
-    sarama_producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+    // Ensure the topic exists and create the sarama producer, on both clusters with a secondary one
+    sarama_producer, err := NewSyncProducer(cfg, cfg.Topic)
     
     if err != nil {
         return nil, err
     }
 
     kafkaProducer := KafkaProducer{
-        producer: sarama_producer,
-        topic:    cfg.Topic,
+        producer:    sarama_producer,
+        topic:       cfg.Topic,
+        sendTimeout: cfg.SendTimeout,
+        maxBytes:    cfg.MaxMessageBytes,
     }
 
     return &kafkaProducer, nil
 }
 
 // Sends a notification event to Kafka
-func (p *KafkaProducer) SendMessage(event *models.NotificationEvent) error {
+func (p *KafkaProducer) SendMessage(ctx context.Context, event *models.NotificationEvent) error {
 
     // Marshal event to JSON
-    payload, err := json.Marshal(event)
+    buf, err := jsonpool.Marshal(event)
 
     if err != nil {
         return fmt.Errorf("failed to marshal event: %w", err)
     }
+    payload := buf.Bytes()
+
+    // Reject oversized events up front instead of failing on the broker limit
+    if len(payload) > p.maxBytes {
+        buf.Release()
+        return fmt.Errorf("%w: %d bytes exceeds %d", ErrPayloadTooLarge, len(payload), p.maxBytes)
+    }
 
     // Create message
     msg := &sarama.ProducerMessage{
         Topic: p.topic,
         Key:   sarama.StringEncoder(event.UserID), // Use user ID as key for partitioning
         Value: sarama.ByteEncoder(payload),
+        Headers: notificationHeaders(event.RequestID, event.EventType, event.Metadata, ""),
     }
 
-    // Send message
-    partition, offset, err := p.producer.SendMessage(msg)
+    // Send message, the buffer is reused unless an abandoned send still holds it
+    partition, offset, err := p.send(ctx, msg)
+    if !abandoned(err) {
+        buf.Release()
+    }
     
     if err != nil {
         return fmt.Errorf("failed to send message: %w", err)
     }
 
-    log.Printf("Message sent to partition %d at offset %d", partition, offset)
+    logging.ForRequest(event.RequestID).Printf("Message sent to partition %d at offset %d", partition, offset)
     return nil
 }
 
+// Sends a message, bounded by the context and the send timeout
+func (p *KafkaProducer) send(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+    return kafkaproducer.Send(ctx, p.producer, msg, p.sendTimeout)
+}
+
+
+// Reports whether a send was abandoned, it may still complete in the background
+func abandoned(err error) bool {
+    return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 // Closes the Kafka producer
 func (p *KafkaProducer) Close() error {
     return p.producer.Close()
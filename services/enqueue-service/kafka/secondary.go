@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// Modes of the secondary cluster
+const (
+	SecondaryMirror   = "mirror"   // Every message is also written to the secondary cluster
+	SecondaryFailover = "failover" // Messages go to the secondary cluster while the primary fails
+)
+
+// NewSyncProducer ensures the topic exists and connects a producer to the
+// brokers, and to the secondary cluster when one is configured. A cluster
+// unreachable at startup is left out until the next restart, so one lost
+// cluster doesn't keep the service from starting. Messages are signed when
+// signing keys are configured.
+func NewSyncProducer(cfg config.KafkaConfig, topic string) (sarama.SyncProducer, error) {
+	primary, err := connectCluster(cfg, cfg.Brokers, topic)
+	if len(cfg.SecondaryBrokers) == 0 {
+		return primary, err
+	}
+
+	secondary, secondaryErr := connectCluster(cfg, cfg.SecondaryBrokers, topic)
+	switch {
+	case err != nil && secondaryErr != nil:
+		return nil, fmt.Errorf("%w, and the secondary cluster: %w", err, secondaryErr)
+	case err != nil:
+		log.Printf("Primary Kafka cluster unreachable, producing %s to the secondary cluster only: %v", topic, err)
+		return secondary, nil
+	case secondaryErr != nil:
+		log.Printf("Secondary Kafka cluster unreachable, producing %s to the primary cluster only: %v", topic, secondaryErr)
+		return primary, nil
+	}
+
+	return &clusterProducer{
+		SyncProducer:  primary,
+		secondary:     secondary,
+		mode:          cfg.SecondaryMode,
+		failbackAfter: cfg.FailbackAfter,
+	}, nil
+}
+
+// connectCluster ensures the topic exists on one cluster and connects a producer to it
+func connectCluster(cfg config.KafkaConfig, brokers []string, topic string) (sarama.SyncProducer, error) {
+	producerCfg := cfg.Producer()
+	producerCfg.Brokers = brokers
+	return kafkaproducer.NewSyncProducer(producerCfg, topic)
+}
+
+// clusterProducer writes to a primary and a secondary cluster. Mirroring,
+// a message is sent to the primary and then copied to the secondary; the
+// copy failing is logged but doesn't fail the send. Failing over, a message
+// the primary fails to take is sent to the secondary, and the following ones
+// go straight to the secondary until the primary is tried again.
+type clusterProducer struct {
+	sarama.SyncProducer // The primary cluster
+
+	secondary     sarama.SyncProducer
+	mode          string
+	failbackAfter time.Duration // How long messages skip the primary once it failed
+	failedAt      atomic.Int64  // When the primary last failed, in unix nanoseconds
+}
+
+// SendMessage sends a message according to the mode
+func (p *clusterProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if p.mode == SecondaryMirror {
+		partition, offset, err := p.SyncProducer.SendMessage(msg)
+		if err != nil {
+			return partition, offset, err
+		}
+		if _, _, err := p.secondary.SendMessage(copyMessage(msg)); err != nil {
+			log.Printf("Failed to mirror message to the secondary Kafka cluster: %v", err)
+		}
+		return partition, offset, nil
+	}
+
+	if time.Since(time.Unix(0, p.failedAt.Load())) < p.failbackAfter {
+		return p.secondary.SendMessage(msg)
+	}
+	partition, offset, err := p.SyncProducer.SendMessage(msg)
+	if err == nil || isPermanent(err) {
+		return partition, offset, err
+	}
+
+	p.failedAt.Store(time.Now().UnixNano())
+	log.Printf("Primary Kafka cluster failed, failing over to the secondary cluster for %v: %v", p.failbackAfter, err)
+	return p.secondary.SendMessage(copyMessage(msg))
+}
+
+// SendMessages sends messages one by one according to the mode
+func (p *clusterProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the producers of both clusters
+func (p *clusterProducer) Close() error {
+	err := p.SyncProducer.Close()
+	if secondaryErr := p.secondary.Close(); err == nil {
+		err = secondaryErr
+	}
+	return err
+}
+
+// copyMessage returns a fresh message with the content of msg, a message
+// isn't handed to a second producer once the first one used it
+func copyMessage(msg *sarama.ProducerMessage) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic:     msg.Topic,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   msg.Headers,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// isPermanent reports whether the secondary cluster would reject the message just the same
+func isPermanent(err error) bool {
+	return errors.Is(err, sarama.ErrMessageSizeTooLarge) || errors.Is(err, sarama.ErrInvalidMessage)
+}
@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/jsonpool"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Interface for publishing status events of delivered notifications
+type StatusProducer interface {
+	PublishStatus(ctx context.Context, event *models.StatusEvent) error
+	Close() error
+}
+
+// Implements the StatusProducer interface on the status topic
+type KafkaStatusProducer struct {
+	producer *KafkaProducer
+}
+
+// Creates a new status producer, ensuring the status topic exists
+func NewStatusProducer(cfg config.KafkaConfig) (StatusProducer, error) {
+	// Ensure the topic exists and create the sarama producer, on both clusters with a secondary one
+	sarama_producer, err := NewSyncProducer(cfg, cfg.StatusTopic)
+	if err != nil {
+		return nil, err
+	}
+
+	statusProducer := KafkaStatusProducer{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			topic:       cfg.StatusTopic,
+			sendTimeout: cfg.SendTimeout,
+			maxBytes:    cfg.MaxMessageBytes,
+		},
+	}
+
+	return &statusProducer, nil
+}
+
+// Publishes a status event
+func (p *KafkaStatusProducer) PublishStatus(ctx context.Context, event *models.StatusEvent) error {
+	// Marshal event to JSON
+	buf, err := jsonpool.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status event: %w", err)
+	}
+
+	// Create message
+	msg := &sarama.ProducerMessage{
+		Topic:   p.producer.topic,
+		Key:     sarama.StringEncoder(event.NotificationID), // Keep a notification's status events in order
+		Value:   sarama.ByteEncoder(buf.Bytes()),
+		Headers: requestIDHeaders(event.RequestID),
+	}
+
+	// Send message
+	partition, offset, err := p.producer.send(ctx, msg)
+	if !abandoned(err) {
+		buf.Release()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send status event: %w", err)
+	}
+
+	logging.ForRequest(event.RequestID).Printf("Status %s of notification %s sent to partition %d at offset %d",
+		event.Status, event.NotificationID, partition, offset)
+	return nil
+}
+
+// Closes the status producer
+func (p *KafkaStatusProducer) Close() error {
+	return p.producer.Close()
+}
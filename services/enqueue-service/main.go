@@ -6,34 +6,187 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/admission"
 	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/api"
 	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/config"
 	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/quota"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/storage"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/debugtrace"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/heartbeat"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/shutdown"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/startup"
 )
 
 func main() {
-	log.Println("Starting Enqueue Service...")
+	log.Printf("Starting Enqueue Service %s", buildinfo.Get())
 
 	// Load configuration
 	cfg, err := config.Load()
-	
+
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	retry := startup.Config{
+		Timeout:        cfg.Startup.RetryTimeout,
+		InitialBackoff: cfg.Startup.RetryBackoff,
+		MaxBackoff:     cfg.Startup.RetryMaxBackoff,
+	}
+
+	// Check Kafka, and Redis when quotas are enforced, up front, so a
+	// misconfiguration fails with what to fix
+	if cfg.Startup.Preflight {
+		checks := []startup.Check{{
+			Name: "Kafka brokers",
+			Hint: "check KAFKA_BROKERS and that the brokers are up",
+			Run: func(ctx context.Context) error {
+				// Either cluster will do when there is a secondary one
+				err := kafkaadmin.CheckTopics(ctx, cfg.Kafka.Brokers)
+				if err != nil && len(cfg.Kafka.SecondaryBrokers) > 0 && kafkaadmin.CheckTopics(ctx, cfg.Kafka.SecondaryBrokers) == nil {
+					return nil
+				}
+				return err
+			},
+		}}
+		if cfg.Quota.Enabled {
+			checks = append(checks, startup.Check{
+				Name: "Redis",
+				Hint: "check QUOTA_REDIS_ADDR and QUOTA_REDIS_PASSWORD, or set QUOTA_ENABLED=false",
+				Run: func(ctx context.Context) error {
+					return quota.CheckRedis(ctx, cfg.Quota.RedisAddr, cfg.Quota.RedisPassword, cfg.Quota.RedisDB)
+				},
+			})
+		}
+		if err := startup.Preflight(context.Background(), retry, checks...); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Initialize Kafka producer
 	producer, err := kafka.NewProducer(cfg.Kafka)
 
 	if err != nil {
 		log.Fatalf("Failed to create Kafka producer: %v", err)
 	}
-	
-	defer producer.Close()
+
+	// Producers are flushed once the server drained, see the shutdown sequence below
+	flush := []func(ctx context.Context) error{shutdown.Close(producer.Close)}
+
+	// Trace notifications sampled for debugging as they are written to Kafka,
+	// after encryption and offloading
+	if cfg.Debug.SamplePercent > 0 || len(cfg.Debug.UserIDs) > 0 {
+		// On both clusters with a secondary one, like the notifications
+		debugProducer, err := kafka.NewSyncProducer(cfg.Kafka, cfg.Debug.Topic)
+		if err != nil {
+			log.Fatalf("Failed to create debug tracer: %v", err)
+		}
+		tracer := debugtrace.NewKafkaTracer(debugProducer, debugtrace.Config{
+			Service:     "enqueue-service",
+			Topic:       cfg.Debug.Topic,
+			SendTimeout: cfg.Kafka.SendTimeout,
+		})
+		flush = append(flush, shutdown.Close(tracer.Close))
+		producer = kafka.NewDebugTracingProducer(producer, tracer)
+		log.Printf("Debug sampling enabled, traces go to %s", cfg.Debug.Topic)
+	}
+
+	// Offload bodies too large for Kafka to object storage
+	if cfg.ClaimCheck.Enabled {
+		blobStore, err := storage.NewS3BlobStore(context.Background(), cfg.ClaimCheck.Storage)
+		if err != nil {
+			log.Fatalf("Failed to create blob store: %v", err)
+		}
+		producer = kafka.NewClaimCheckProducer(producer, blobStore, cfg.ClaimCheck.Threshold)
+		log.Println("Claim check enabled")
+	}
+
+	// Encrypt notification content before it reaches Kafka, and before it is offloaded
+	encryptor, err := cfg.CreateEncryptor()
+	if err != nil {
+		log.Fatalf("Failed to create encryptor: %v", err)
+	}
+	if encryptor != nil {
+		producer = kafka.NewEncryptingProducer(producer, encryptor)
+		log.Println("Field-level encryption enabled")
+	}
+
+	// Action clicks and other status events go to their own topic
+	statusProducer, err := kafka.NewStatusProducer(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Failed to create status producer: %v", err)
+	}
+
+	flush = append(flush, shutdown.Close(statusProducer.Close))
+
+	// Block misbehaving event types at the front door, the lists file is reloaded when it changes
+	eventTypes, err := admission.NewEventTypeFilter(admission.Config{
+		Allow:        cfg.EventTypes.Allow,
+		Deny:         cfg.EventTypes.Deny,
+		File:         cfg.EventTypes.File,
+		PollInterval: cfg.EventTypes.PollInterval,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load event type lists: %v", err)
+	}
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	go eventTypes.Watch(watchCtx)
+
+	// Fetch the referenced secrets again, so a rotated quota Redis password reaches new connections
+	if cfg.Secrets.RefreshInterval > 0 {
+		go cfg.SecretStore().Run(watchCtx, cfg.Secrets.RefreshInterval)
+	}
+
+	// Enforce per-API-key quotas at the front door
+	quotas, err := startup.Retry(context.Background(), retry, "Redis", cfg.CreateQuotaEnforcer)
+	if err != nil {
+		log.Fatalf("Failed to create quota enforcer: %v", err)
+	}
+	if quotas != nil {
+		flush = append(flush, shutdown.Close(quotas.Close))
+		log.Println("Per-API-key quotas enabled")
+	}
+
+	// Render the email templates notifications name, other instances' changes are picked up
+	emailTemplates, err := cfg.CreateTemplates()
+	if err != nil {
+		log.Fatalf("Failed to load email templates: %v", err)
+	}
+	if emailTemplates != nil {
+		go emailTemplates.Watch(watchCtx)
+		log.Printf("Rendering email templates from %s", cfg.Templates.Dir)
+	}
+
+	// Report users' rate-limit quota to callers
+	rateLimits := cfg.CreateRateLimitClient()
+	if rateLimits != nil {
+		log.Printf("Reading rate-limit quotas from %s", cfg.RateLimit.URL)
+	}
 
 	// Initialize and start HTTP server
-	server := api.NewServer(cfg.Server, producer)
+	server := api.NewServer(cfg.Server, cfg.Debug, eventTypes, quotas, rateLimits, emailTemplates, producer, statusProducer)
+
+	// Announce this instance on the ops topic
+	if cfg.Heartbeat.Interval > 0 {
+		// On both clusters with a secondary one, like the notifications
+		opsProducer, err := kafka.NewSyncProducer(cfg.Kafka, cfg.Heartbeat.Topic)
+		if err != nil {
+			log.Fatalf("Failed to create heartbeater: %v", err)
+		}
+		heartbeater := heartbeat.Start(opsProducer, heartbeat.Config{
+			Service:    "enqueue-service",
+			InstanceID: cfg.Heartbeat.InstanceID,
+			Topic:      cfg.Heartbeat.Topic,
+			Interval:   cfg.Heartbeat.Interval,
+		}, func(h *heartbeat.Heartbeat) {
+			h.Processed, h.Errors = server.Handled(), server.Failed()
+		})
+		flush = append(flush, shutdown.Close(heartbeater.Close))
+		log.Printf("Publishing heartbeats of instance %s every %v", cfg.Heartbeat.InstanceID, cfg.Heartbeat.Interval)
+	}
 
 	go func() {
 		if err := server.Start(); err != nil {
@@ -46,18 +199,20 @@ func main() {
 	// Wait for termination signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	
+
 	<-sigCh
 
 	log.Println("Shutdown signal received")
 
-	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server shutdown failed: %v", err)
-	}
-	
+	// Finish the requests in flight before the producers they write to are closed
+	var sequencer shutdown.Sequencer
+	sequencer.Stage("drain", cfg.Shutdown.Drain, server.Shutdown)
+	sequencer.Stage("flush", cfg.Shutdown.Flush, flush...)
+	sequencer.Stage("close", cfg.Shutdown.Close, func(context.Context) error {
+		stopWatching()
+		return nil
+	})
+	sequencer.Run()
+
 	log.Println("Server gracefully stopped")
-}
\ No newline at end of file
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
+// Action types
+const (
+	ActionOpenURL  = messages.ActionOpenURL
+	ActionDeepLink = messages.ActionDeepLink
+	ActionDismiss  = messages.ActionDismiss
+)
+
+const (
+	MaxActions           = 3 // Most push platforms show at most three buttons
+	MaxActionIDLength    = 64
+	MaxActionLabelLength = 40
+)
+
+// Button attached to a notification, rendered as a push action button,
+// an email call to action or an in-app action
+type Action = messages.Action
+
+// Validates a notification's actions
+func ValidateActions(actions []Action) error {
+	if len(actions) > MaxActions {
+		return fmt.Errorf("at most %d actions are allowed", MaxActions)
+	}
+
+	ids := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		if action.ID == "" || len(action.ID) > MaxActionIDLength {
+			return fmt.Errorf("action id must be 1 to %d bytes", MaxActionIDLength)
+		}
+		if ids[action.ID] {
+			return fmt.Errorf("duplicate action id %q", action.ID)
+		}
+		ids[action.ID] = true
+
+		if action.Label == "" || utf8.RuneCountInString(action.Label) > MaxActionLabelLength {
+			return fmt.Errorf("action %s label must be 1 to %d characters", action.ID, MaxActionLabelLength)
+		}
+
+		switch action.Type {
+		case ActionOpenURL:
+			if link, err := url.Parse(action.URL); err != nil || link.Scheme != "https" || link.Host == "" {
+				return fmt.Errorf("action %s url must be an https URL", action.ID)
+			}
+		case ActionDeepLink:
+			if link, err := url.Parse(action.URL); err != nil || link.Scheme == "" {
+				return fmt.Errorf("action %s url must be an absolute URL", action.ID)
+			}
+		case ActionDismiss:
+			if action.URL != "" {
+				return fmt.Errorf("action %s must not have a url", action.ID)
+			}
+		default:
+			return errors.New("action type must be open_url, deep_link or dismiss")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
+// Content limits of the delivery channels
+const (
+	MaxEmailSubjectLength = 255
+	MaxPushTitleLength    = 256
+	MaxPushPayloadBytes   = 4096 // APNs payload limit
+
+	MaxAttachments          = 10
+	MaxAttachmentBytes      = 10 << 20
+	MaxTotalAttachmentBytes = 20 << 20
+)
+
+// Content types accepted for email attachments
+var AllowedAttachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"text/csv":        true,
+	"text/plain":      true,
+	"image/png":       true,
+	"image/jpeg":      true,
+}
+
+// Limits on channel content that depend on the deployment, zero disables a limit
+type ContentLimits struct {
+	SMSMaxSegments int // Most segments an SMS text may be split into
+}
+
+// Marks where a push body was cut short
+const truncationMark = "…"
+
+// Per-channel content overrides
+type ChannelContent = messages.ChannelContent
+
+// Channel specific content
+type (
+	EmailContent = messages.EmailContent
+	Attachment   = messages.Attachment
+	PushContent  = messages.PushContent
+	SMSContent   = messages.SMSContent
+)
+
+// Fits the overrides to their channels and validates them against the
+// limits of each channel. Push bodies over the payload limit are truncated
+// rather than rejected. It returns warnings about what was changed, and
+// about content that costs more to send than it looks, such as SMS texts
+// split into several segments.
+func ProcessChannelContent(c *ChannelContent, limits ContentLimits) ([]string, error) {
+	var warnings []string
+
+	if c.Email != nil {
+		if c.Email.Subject == "" {
+			return nil, errors.New("email subject is required")
+		}
+		if utf8.RuneCountInString(c.Email.Subject) > MaxEmailSubjectLength {
+			return nil, fmt.Errorf("email subject must be at most %d characters", MaxEmailSubjectLength)
+		}
+		if c.Email.HTML == "" && c.Email.Text == "" {
+			return nil, errors.New("email html or text is required")
+		}
+		if err := validateAttachments(c.Email.Attachments); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Push != nil {
+		if c.Push.Title == "" && c.Push.Body == "" {
+			return nil, errors.New("push title or body is required")
+		}
+		if utf8.RuneCountInString(c.Push.Title) > MaxPushTitleLength {
+			return nil, fmt.Errorf("push title must be at most %d characters", MaxPushTitleLength)
+		}
+		if c.Push.DeepLink != "" {
+			if link, err := url.Parse(c.Push.DeepLink); err != nil || link.Scheme == "" {
+				return nil, errors.New("push deep link must be an absolute URL")
+			}
+		}
+		if size := len(c.Push.Title) + len(c.Push.Body) + len(c.Push.DeepLink); size > MaxPushPayloadBytes {
+			room := MaxPushPayloadBytes - len(c.Push.Title) - len(c.Push.DeepLink) - len(truncationMark)
+			if room <= 0 {
+				return nil, fmt.Errorf("push title and deep link must leave room for the body within %d bytes", MaxPushPayloadBytes)
+			}
+			c.Push.Body = truncate(c.Push.Body, room) + truncationMark
+			warnings = append(warnings, fmt.Sprintf("push body truncated from %d to %d bytes to fit the %d byte payload limit",
+				size-len(c.Push.Title)-len(c.Push.DeepLink), len(c.Push.Body), MaxPushPayloadBytes))
+		}
+	}
+
+	if c.SMS != nil {
+		if c.SMS.Text == "" {
+			return nil, errors.New("sms text is required")
+		}
+		segments := CountSMSSegments(c.SMS.Text)
+		if limits.SMSMaxSegments > 0 && segments.Segments > limits.SMSMaxSegments {
+			return nil, fmt.Errorf("sms text needs %d %s segments, at most %d are allowed",
+				segments.Segments, segments.Encoding, limits.SMSMaxSegments)
+		}
+		if segments.Encoding == SMSEncodingUCS2 {
+			warnings = append(warnings, fmt.Sprintf("sms text is encoded as UCS-2, which fits %d instead of %d characters per segment, because of %s",
+				ucs2SegmentUnits, gsm7SegmentUnits, strings.Join(segments.Unicode, " ")))
+		}
+		if segments.Segments > 1 {
+			warnings = append(warnings, fmt.Sprintf("sms text is sent as %d segments, each billed as one message", segments.Segments))
+		}
+	}
+
+	return warnings, nil
+}
+
+// Cuts text to at most size bytes without splitting a character
+func truncate(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	return text[:size]
+}
+
+// Validates attachment references; sizes are enforced when they are fetched
+func validateAttachments(attachments []Attachment) error {
+	if len(attachments) > MaxAttachments {
+		return fmt.Errorf("at most %d attachments are allowed", MaxAttachments)
+	}
+
+	for _, attachment := range attachments {
+		if attachment.Filename == "" || strings.ContainsAny(attachment.Filename, "/\\") {
+			return fmt.Errorf("attachment filename %q is invalid", attachment.Filename)
+		}
+		if !AllowedAttachmentTypes[attachment.ContentType] {
+			return fmt.Errorf("attachment content type %q is not allowed", attachment.ContentType)
+		}
+		if (attachment.URL == "") == (attachment.ObjectKey == "") {
+			return fmt.Errorf("attachment %s needs exactly one of url or object_key", attachment.Filename)
+		}
+		if attachment.URL != "" {
+			if link, err := url.Parse(attachment.URL); err != nil || link.Scheme != "https" || link.Host == "" {
+				return fmt.Errorf("attachment %s url must be an https URL", attachment.Filename)
+			}
+		}
+		if attachment.ObjectKey != "" && !strings.HasPrefix(attachment.ObjectKey, "s3://") {
+			return fmt.Errorf("attachment %s object_key must be an s3:// reference", attachment.Filename)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Errors of metadata exceeding its limits
+var (
+	ErrMetadataTooLarge = errors.New("metadata too large")
+	ErrMetadataTooDeep  = errors.New("metadata nested too deeply")
+)
+
+// Limits on the metadata of a notification, zero disables a limit
+type MetadataLimits struct {
+	MaxBytes      int      // Largest accepted metadata, encoded as JSON
+	MaxDepth      int      // Deepest accepted nesting of objects and arrays, top-level values are at depth 1
+	MaxValueBytes int      // Values larger than this are stripped, unless their key is known
+	KnownKeys     []string // Keys the pipeline reads, whose values are never stripped
+}
+
+// SanitizeMetadata strips the unknown values larger than the limit from
+// metadata, then checks what is left against the depth and size limits.
+// It returns the keys stripped, in order.
+func SanitizeMetadata(metadata map[string]any, limits MetadataLimits) ([]string, error) {
+	var stripped []string
+	if limits.MaxValueBytes > 0 {
+		for key, value := range metadata {
+			if slices.Contains(limits.KnownKeys, key) {
+				continue
+			}
+			if encoded, err := json.Marshal(value); err == nil && len(encoded) <= limits.MaxValueBytes {
+				continue
+			}
+			delete(metadata, key)
+			stripped = append(stripped, key)
+		}
+		slices.Sort(stripped)
+	}
+
+	if limits.MaxDepth > 0 {
+		for key, value := range metadata {
+			if depth(value) > limits.MaxDepth {
+				return stripped, fmt.Errorf("%w: %s is nested deeper than %d levels", ErrMetadataTooDeep, key, limits.MaxDepth)
+			}
+		}
+	}
+
+	if limits.MaxBytes > 0 {
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			return stripped, fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		if len(encoded) > limits.MaxBytes {
+			return stripped, fmt.Errorf("%w: %d bytes, at most %d are accepted", ErrMetadataTooLarge, len(encoded), limits.MaxBytes)
+		}
+	}
+	return stripped, nil
+}
+
+// depth returns how deeply a decoded JSON value nests, 1 for scalars
+func depth(value any) int {
+	deepest := 0
+	switch value := value.(type) {
+	case map[string]any:
+		for _, v := range value {
+			deepest = max(deepest, depth(v))
+		}
+	case []any:
+		for _, v := range value {
+			deepest = max(deepest, depth(v))
+		}
+	default:
+		return 1
+	}
+	return deepest + 1
+}
@@ -1,19 +1,84 @@
 package models
 
+import (
+	"fmt"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
 // Incoming request structure
 type NotificationRequest struct {
-	UserID		string      `json:"user_id"`
-	EventType string      `json:"event_type"`
-	Content   string      `json:"content,omitempty"`
-	Metadata  map[string]any `json:"metadata,omitempty"`
+	UserID         string          `json:"user_id"`
+	EventType      string          `json:"event_type"`
+	Content        string          `json:"content,omitempty"`
+	ChannelContent *ChannelContent `json:"channel_content,omitempty"` // Per-channel overrides of Content
+	Template       string          `json:"template,omitempty"`        // Email template rendered with the metadata into channel_content.email
+	Metadata       map[string]any  `json:"metadata,omitempty"`
+	GroupKey       string          `json:"group_key,omitempty"`     // Related notifications clients may collapse together
+	ThreadID       string          `json:"thread_id,omitempty"`     // Conversation or object the notification belongs to
+	Entity         *EntityRef      `json:"entity,omitempty"`        // Entity the event is about, sent to its subscribers when there is no user
+	Recipients     []Recipient     `json:"recipients,omitempty"`    // Users the event goes to instead of user_id, each with an optional priority
+	PriorityHint   string          `json:"priority_hint,omitempty"` // Priority asked for, honoured up to the prioritizer's limit
+	Actions        []Action        `json:"actions,omitempty"`       // Buttons rendered on every channel that supports them
+	Test           bool            `json:"test,omitempty"`          // Sandbox mode
+	BypassReason   string          `json:"bypass_reason,omitempty"` // Skips rate limits and opt-outs, for allowed API keys only
 }
 
 // Event sent to Kafka
-type NotificationEvent struct {
-	ID        string      `json:"id"`
-	UserID		string      `json:"user_id"`
-	EventType string      `json:"event_type"`
-	Content   string      `json:"content,omitempty"`
-	Metadata  map[string]any `json:"metadata,omitempty"`
-	CreatedAt int64       `json:"created_at"`
-}
\ No newline at end of file
+type NotificationEvent = messages.NotificationEvent
+
+// Entity users can subscribe to, such as a thread, a project or an author
+type EntityRef = messages.EntityRef
+
+// User an event goes to, with the priority asked for them
+type Recipient = messages.Recipient
+
+// Most recipients a single event may name
+const MaxRecipients = 1000
+
+// Checks the recipients of an event name users
+func ValidateRecipients(recipients []Recipient) error {
+	if len(recipients) > MaxRecipients {
+		return fmt.Errorf("at most %d recipients are accepted", MaxRecipients)
+	}
+	for i, recipient := range recipients {
+		if recipient.UserID == "" {
+			return fmt.Errorf("recipient %d: user_id is required", i)
+		}
+	}
+	return nil
+}
+
+// Longest accepted entity type and ID, as stored with subscriptions
+const (
+	MaxEntityTypeLength = 50
+	MaxEntityIDLength   = 191
+)
+
+// Checks an entity reference is complete and fits the subscription store
+func ValidateEntity(entity *EntityRef) error {
+	if entity.Type == "" || entity.ID == "" {
+		return fmt.Errorf("entity type and id are required")
+	}
+	if len(entity.Type) > MaxEntityTypeLength {
+		return fmt.Errorf("entity type must be at most %d bytes", MaxEntityTypeLength)
+	}
+	if len(entity.ID) > MaxEntityIDLength {
+		return fmt.Errorf("entity id must be at most %d bytes", MaxEntityIDLength)
+	}
+	return nil
+}
+
+// Schema version of the events sent to Kafka
+const SchemaVersion = messages.SchemaVersion
+
+// Bypass exempts a legally required or emergency notification from rate
+// limiting and preference suppression. The signature is verified by the rate
+// limiter, which audits every bypass it honours.
+type Bypass = messages.Bypass
+
+// Metadata entry naming the tenant a notification belongs to
+const MetadataTenant = messages.MetadataTenant
+
+// User's rate-limit quota as reported by the rate limiter
+type RateLimitResult = messages.RateLimitResult
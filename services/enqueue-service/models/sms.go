@@ -0,0 +1,86 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+// SMS encodings, picked by the characters of the text
+const (
+	SMSEncodingGSM7 = "GSM-7"
+	SMSEncodingUCS2 = "UCS-2"
+)
+
+// Units an SMS segment holds: septets for GSM-7 and UTF-16 code units for
+// UCS-2. Texts longer than one segment are split into concatenated segments,
+// which lose room to the header joining them back together.
+const (
+	gsm7SegmentUnits             = 160
+	gsm7ConcatenatedSegmentUnits = 153
+	ucs2SegmentUnits             = 70
+	ucs2ConcatenatedSegmentUnits = 67
+)
+
+// Characters of the GSM 03.38 default alphabet, one septet each
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// Characters of the extension table, escaped into two septets
+const gsm7Extended = "\f^{}\\[~]|€"
+
+// SMSSegments describes how an SMS text is sent
+type SMSSegments struct {
+	Encoding string   `json:"encoding"`
+	Units    int      `json:"units"`             // Septets for GSM-7, UTF-16 code units for UCS-2
+	Segments int      `json:"segments"`          // Segments the text is split into
+	Unicode  []string `json:"unicode,omitempty"` // Characters outside GSM-7 that force UCS-2, each once
+}
+
+// CountSMSSegments works out the encoding of an SMS text and the segments
+// it is split into. Characters taking two units are never split across
+// segments, so a text can need more segments than its units alone suggest.
+func CountSMSSegments(text string) SMSSegments {
+	result := SMSSegments{Encoding: SMSEncodingGSM7}
+	for _, r := range text {
+		if !strings.ContainsRune(gsm7Basic, r) && !strings.ContainsRune(gsm7Extended, r) {
+			result.Encoding = SMSEncodingUCS2
+			if c := string(r); !slices.Contains(result.Unicode, c) {
+				result.Unicode = append(result.Unicode, c)
+			}
+		}
+	}
+
+	single, concatenated := gsm7SegmentUnits, gsm7ConcatenatedSegmentUnits
+	if result.Encoding == SMSEncodingUCS2 {
+		single, concatenated = ucs2SegmentUnits, ucs2ConcatenatedSegmentUnits
+	}
+
+	var widths []int
+	for _, r := range text {
+		width := 1
+		switch {
+		case result.Encoding == SMSEncodingGSM7 && strings.ContainsRune(gsm7Extended, r):
+			width = 2 // Escape and character
+		case result.Encoding == SMSEncodingUCS2 && utf16.RuneLen(r) == 2:
+			width = 2 // Surrogate pair
+		}
+		widths = append(widths, width)
+		result.Units += width
+	}
+
+	if result.Units <= single {
+		result.Segments = 1
+		return result
+	}
+	used := 0
+	result.Segments = 1
+	for _, width := range widths {
+		if used+width > concatenated {
+			result.Segments++
+			used = 0
+		}
+		used += width
+	}
+	return result
+}
@@ -0,0 +1,26 @@
+package models
+
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
+// Status event types
+const (
+	StatusActionClicked = messages.StatusActionClicked
+	StatusOpened        = messages.StatusOpened
+)
+
+// Incoming report of a user clicking one of a notification's actions
+type ActionClickRequest struct {
+	UserID  string `json:"user_id"`
+	Channel string `json:"channel"` // Channel the action was rendered on (email, push, in-app)
+	Token   string `json:"token"`   // Engagement token the notification was delivered with
+}
+
+// Incoming report of a user opening a notification
+type OpenRequest struct {
+	UserID  string `json:"user_id"`
+	Channel string `json:"channel"` // Channel the notification was opened on (email, push, in-app)
+	Token   string `json:"token"`   // Engagement token the notification was delivered with
+}
+
+// Event sent to the status topic when something happens to a delivered notification
+type StatusEvent = messages.StatusEvent
@@ -0,0 +1,318 @@
+package quota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Periods a quota applies to
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+// Limits of an API key, zero means unlimited
+type Limits struct {
+	Daily   int `json:"daily"`
+	Monthly int `json:"monthly"`
+}
+
+// Config for quota enforcement
+type Config struct {
+	RedisAddr       string
+	RedisPassword   string
+	CurrentPassword func() string // Optional, read for every new connection so a rotated password is used
+	RedisDB         int
+	Default         Limits            // Limits of keys without their own
+	Keys            map[string]Limits // Limits by API key ID
+	AlertPercents   []int             // Shares of a quota used at which the webhook is notified
+	WebhookURL      string            // Optional endpoint quota alerts are posted to
+	WebhookTimeout  time.Duration
+}
+
+// Enforcer counts notifications per API key and calendar day and month (UTC)
+// in Redis, shared by all enqueue instances
+type Enforcer struct {
+	client        *redis.Client
+	defaults      Limits
+	keys          map[string]Limits
+	alertPercents []int
+	webhookURL    string
+	http          *http.Client
+	alerts        chan Alert
+	mu            sync.RWMutex
+	closed        bool
+	wg            sync.WaitGroup
+}
+
+// Usage of one period's quota after a notification was counted
+type Usage struct {
+	Period   string
+	Limit    int
+	Used     int
+	ResetAt  time.Time // Start of the next period
+	Exceeded bool      // This period's quota is used up
+}
+
+// Remaining returns how many notifications the key may still send in the period
+func (u Usage) Remaining() int {
+	return max(u.Limit-u.Used, 0)
+}
+
+// Result of counting a notification against a key's quotas
+type Result struct {
+	Exceeded bool      // The notification was not counted, a quota is used up
+	Usage    []Usage   // Only the limited periods
+	TakenAt  time.Time // When it was counted, which picks the periods a refund goes to
+}
+
+// Alert is posted to the webhook when a key crosses a share of a quota
+type Alert struct {
+	APIKeyID string `json:"api_key_id"`
+	Period   string `json:"period"`
+	Percent  int    `json:"percent"`
+	Used     int    `json:"used"`
+	Limit    int    `json:"limit"`
+	ResetAt  int64  `json:"reset_at"`
+}
+
+// NewEnforcer connects to Redis and starts the alert sender
+func NewEnforcer(cfg Config) (*Enforcer, error) {
+	options := &redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	}
+	if cfg.CurrentPassword != nil {
+		options.CredentialsProvider = func() (string, string) {
+			return "", cfg.CurrentPassword()
+		}
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	e := &Enforcer{
+		client:        client,
+		defaults:      cfg.Default,
+		keys:          cfg.Keys,
+		alertPercents: cfg.AlertPercents,
+		webhookURL:    cfg.WebhookURL,
+		http:          &http.Client{Timeout: cfg.WebhookTimeout},
+	}
+
+	// Alerts are posted in the background so a slow webhook never blocks ingestion
+	if e.webhookURL != "" {
+		e.alerts = make(chan Alert, 100)
+		e.wg.Add(1)
+		go e.sendAlerts()
+	}
+
+	return e, nil
+}
+
+// Take counts a notification of the key against its quotas. A notification
+// that would exceed a quota is not counted.
+func (e *Enforcer) Take(ctx context.Context, apiKeyID string) (Result, error) {
+	now := time.Now().UTC()
+	periods := e.periodsOf(apiKeyID, now)
+	if len(periods) == 0 {
+		return Result{TakenAt: now}, nil
+	}
+
+	pipe := e.client.TxPipeline()
+	counts := make([]*redis.IntCmd, len(periods))
+	for i, p := range periods {
+		counts[i] = pipe.Incr(ctx, p.key)
+		pipe.ExpireAt(ctx, p.key, p.usage.ResetAt.Add(24*time.Hour))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Result{}, err
+	}
+
+	result := Result{TakenAt: now}
+	for i, p := range periods {
+		p.usage.Used = int(counts[i].Val())
+		if p.usage.Used > p.usage.Limit {
+			p.usage.Exceeded = true
+			result.Exceeded = true
+		}
+		result.Usage = append(result.Usage, p.usage)
+	}
+
+	// Take the notification back from every period when one is used up
+	if result.Exceeded {
+		for i := range result.Usage {
+			result.Usage[i].Used--
+		}
+		if err := e.release(ctx, periods); err != nil {
+			log.Printf("Failed to release quota of API key %s: %v", apiKeyID, err)
+		}
+		return result, nil
+	}
+
+	for i, p := range periods {
+		e.alert(ctx, apiKeyID, p, int(counts[i].Val()))
+	}
+	return result, nil
+}
+
+// Refund takes back a notification that was counted but not accepted, from
+// the periods it was counted in even when a new one has started since
+func (e *Enforcer) Refund(ctx context.Context, apiKeyID string, taken Result) error {
+	return e.release(ctx, e.periodsOf(apiKeyID, taken.TakenAt))
+}
+
+// CheckRedis connects to Redis and pings it, for preflight checks
+func CheckRedis(ctx context.Context, addr, password string, db int) error {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+	defer client.Close()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("Redis at %s not reachable: %w", addr, err)
+	}
+	return nil
+}
+
+// Close flushes pending alerts and closes the Redis connection
+func (e *Enforcer) Close() error {
+	e.mu.Lock()
+	if e.alerts != nil && !e.closed {
+		e.closed = true
+		close(e.alerts)
+	}
+	e.mu.Unlock()
+
+	e.wg.Wait()
+	return e.client.Close()
+}
+
+// period is a quota period of a key with its Redis counter
+type period struct {
+	key   string
+	usage Usage
+}
+
+// periodsOf returns the limited periods of a key at now
+func (e *Enforcer) periodsOf(apiKeyID string, now time.Time) []period {
+	limits, exists := e.keys[apiKeyID]
+	if !exists {
+		limits = e.defaults
+	}
+
+	var periods []period
+	if limits.Daily > 0 {
+		day := now.Truncate(24 * time.Hour)
+		periods = append(periods, period{
+			key:   fmt.Sprintf("quota:%s:%s:%s", apiKeyID, PeriodDaily, day.Format("2006-01-02")),
+			usage: Usage{Period: PeriodDaily, Limit: limits.Daily, ResetAt: day.AddDate(0, 0, 1)},
+		})
+	}
+	if limits.Monthly > 0 {
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		periods = append(periods, period{
+			key:   fmt.Sprintf("quota:%s:%s:%s", apiKeyID, PeriodMonthly, month.Format("2006-01")),
+			usage: Usage{Period: PeriodMonthly, Limit: limits.Monthly, ResetAt: month.AddDate(0, 1, 0)},
+		})
+	}
+	return periods
+}
+
+// release decrements the counters of the periods
+func (e *Enforcer) release(ctx context.Context, periods []period) error {
+	if len(periods) == 0 {
+		return nil
+	}
+
+	pipe := e.client.TxPipeline()
+	for _, p := range periods {
+		pipe.Decr(ctx, p.key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// alert queues an alert for every share of the quota the count just reached.
+// A marker key makes sure only one instance sends it per period.
+func (e *Enforcer) alert(ctx context.Context, apiKeyID string, p period, used int) {
+	if e.alerts == nil {
+		return
+	}
+
+	for _, percent := range e.alertPercents {
+		threshold := (p.usage.Limit*percent + 99) / 100
+		if used != threshold {
+			continue
+		}
+
+		marker := p.key + ":alerted:" + strconv.Itoa(percent)
+		first, err := e.client.SetNX(ctx, marker, 1, time.Until(p.usage.ResetAt.Add(24*time.Hour))).Result()
+		if err != nil || !first {
+			continue
+		}
+
+		e.mu.RLock()
+		if !e.closed {
+			select {
+			case e.alerts <- Alert{
+				APIKeyID: apiKeyID,
+				Period:   p.usage.Period,
+				Percent:  percent,
+				Used:     used,
+				Limit:    p.usage.Limit,
+				ResetAt:  p.usage.ResetAt.Unix(),
+			}:
+			default:
+				log.Printf("Quota alert queue full, dropping %d%% %s alert for API key %s", percent, p.usage.Period, apiKeyID)
+			}
+		}
+		e.mu.RUnlock()
+	}
+}
+
+// sendAlerts posts queued alerts to the webhook until the enforcer is closed
+func (e *Enforcer) sendAlerts() {
+	defer e.wg.Done()
+	for alert := range e.alerts {
+		if err := e.post(alert); err != nil {
+			log.Printf("Failed to send %d%% %s quota alert for API key %s: %v", alert.Percent, alert.Period, alert.APIKeyID, err)
+		}
+	}
+}
+
+// post sends a single alert to the webhook
+func (e *Enforcer) post(alert Alert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	resp, err := e.http.Post(e.webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
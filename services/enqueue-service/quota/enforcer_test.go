@@ -0,0 +1,26 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodsKeyedByPeriodStart(t *testing.T) {
+	e := &Enforcer{defaults: Limits{Daily: 10, Monthly: 100}}
+
+	// Counted just before midnight at the end of a month, refunded just after
+	taken := time.Date(2026, 10, 31, 23, 59, 59, 0, time.UTC)
+	refunded := taken.Add(2 * time.Second)
+
+	before := e.periodsOf("key-1", taken)
+	after := e.periodsOf("key-1", refunded)
+	if len(before) != 2 || len(after) != 2 {
+		t.Fatalf("periods = %v, %v", before, after)
+	}
+	if before[0].key != "quota:key-1:daily:2026-10-31" || before[1].key != "quota:key-1:monthly:2026-10" {
+		t.Errorf("periods counted in = %s, %s", before[0].key, before[1].key)
+	}
+	if after[0].key == before[0].key || after[1].key == before[1].key {
+		t.Errorf("periods after the boundary = %s, %s", after[0].key, after[1].key)
+	}
+}
@@ -0,0 +1,59 @@
+// Package ratelimit reads users' rate-limit quota from the rate limiter
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+)
+
+// Client asks the rate limiter's admin server for quotas, without counting anything
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// Creates a client of the rate limiter's admin server at baseURL
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+// Quota returns the quota a notification of the event type, priority and
+// tenant to the user would be checked against. An empty priority gets the
+// lowest level's limit.
+func (c *Client) Quota(ctx context.Context, userID, eventType, priority, tenant string) (*models.RateLimitResult, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{"event_type": eventType, "priority": priority, "tenant": tenant} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/quota/"+url.PathEscape(userID)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rate limiter returned status %d", resp.StatusCode)
+	}
+	var result models.RateLimitResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode quota: %w", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+)
+
+// Content types a declared attachment type may be detected as
+var detectedAttachmentTypes = map[string]string{
+	"application/pdf": "application/pdf",
+	"text/csv":        "text/plain",
+	"text/plain":      "text/plain",
+	"image/png":       "image/png",
+	"image/jpeg":      "image/jpeg",
+}
+
+// FetchedAttachment is an attachment loaded and checked for sending
+type FetchedAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// FetchAttachments loads the attachments of an email, enforcing the size
+// limits and checking that the data matches the declared content type
+func FetchAttachments(ctx context.Context, store BlobStore, client *http.Client, attachments []models.Attachment) ([]FetchedAttachment, error) {
+	fetched := make([]FetchedAttachment, 0, len(attachments))
+	total := 0
+
+	for _, attachment := range attachments {
+		data, err := fetchAttachment(ctx, store, client, attachment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch attachment %s: %w", attachment.Filename, err)
+		}
+
+		total += len(data)
+		if total > models.MaxTotalAttachmentBytes {
+			return nil, fmt.Errorf("attachments exceed %d bytes in total", models.MaxTotalAttachmentBytes)
+		}
+
+		detected := http.DetectContentType(data)
+		expected, ok := detectedAttachmentTypes[attachment.ContentType]
+		if !ok || !strings.HasPrefix(detected, expected) {
+			return nil, fmt.Errorf("attachment %s is %s, not %s", attachment.Filename, detected, attachment.ContentType)
+		}
+
+		fetched = append(fetched, FetchedAttachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Data:        data,
+		})
+	}
+
+	return fetched, nil
+}
+
+// fetchAttachment loads a single attachment from object storage or its URL
+func fetchAttachment(ctx context.Context, store BlobStore, client *http.Client, attachment models.Attachment) ([]byte, error) {
+	var data []byte
+	if attachment.ObjectKey != "" {
+		var err error
+		if data, err = store.Get(ctx, attachment.ObjectKey); err != nil {
+			return nil, err
+		}
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		// Read one byte past the limit to detect oversized attachments
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, models.MaxAttachmentBytes+1)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) > models.MaxAttachmentBytes {
+		return nil, fmt.Errorf("attachment exceeds %d bytes", models.MaxAttachmentBytes)
+	}
+	return data, nil
+}
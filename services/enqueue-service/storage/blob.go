@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+)
+
+// Scheme of references to objects in the blob store
+const refScheme = "s3://"
+
+// Interface for storing notification bodies outside Kafka
+type BlobStore interface {
+	// Put stores data under key and returns a reference to it
+	Put(ctx context.Context, key string, data []byte) (string, error)
+	// Get loads the data a reference points to
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// Object storage config
+type Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	UseSSL    bool
+}
+
+// S3BlobStore implements BlobStore on S3 compatible object storage (S3, minio)
+type S3BlobStore struct {
+	client *minio.Client
+	bucket string
+}
+
+// Creates a new blob store, creating the bucket if needed
+func NewS3BlobStore(ctx context.Context, cfg Config) (*S3BlobStore, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object storage client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", cfg.Bucket, err)
+		}
+	}
+
+	return &S3BlobStore{
+		client: client,
+		bucket: cfg.Bucket,
+	}, nil
+}
+
+// Put stores data under key in the configured bucket
+func (s *S3BlobStore) Put(ctx context.Context, key string, data []byte) (string, error) {
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store object %s: %w", key, err)
+	}
+	return refScheme + s.bucket + "/" + key, nil
+}
+
+// Get loads the object a reference points to
+func (s *S3BlobStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(ref, refScheme), "/")
+	if !strings.HasPrefix(ref, refScheme) || !ok {
+		return nil, fmt.Errorf("malformed object reference %q", ref)
+	}
+
+	object, err := s.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", ref, err)
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %w", ref, err)
+	}
+	return data, nil
+}
+
+// Hydrate restores the content of an event whose body was offloaded to the blob store
+func Hydrate(ctx context.Context, store BlobStore, event *models.NotificationEvent) error {
+	if event.ContentRef == "" {
+		return nil
+	}
+
+	data, err := store.Get(ctx, event.ContentRef)
+	if err != nil {
+		return err
+	}
+
+	event.Content = string(data)
+	event.ContentRef = ""
+	return nil
+}
@@ -0,0 +1,292 @@
+package templates
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selectors inlined: an optional element name or *, followed by classes and
+// IDs, e.g. td.cell. Rules with other selectors, and @media and other
+// at-rules, can't be inlined and stay in a <style> element in the head.
+var simpleSelector = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*|\*)?((?:[.#][A-Za-z0-9_-]+)*)$`)
+
+// Parts of a simple selector naming a class or an ID
+var selectorPart = regexp.MustCompile(`[.#][A-Za-z0-9_-]+`)
+
+// Comments in stylesheets
+var cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// stylesheet is the parsed css of a template
+type stylesheet struct {
+	rules []rule
+	kept  []string // Rules that can't be inlined, as written
+}
+
+// rule applies declarations to the elements matching a simple selector
+type rule struct {
+	element      string // Empty or * for any element
+	classes      []string
+	ids          []string
+	specificity  int // IDs count 100, classes 10 and elements 1
+	declarations []declaration
+}
+
+// declaration is a property set to a value
+type declaration struct {
+	property string
+	value    string
+}
+
+// parseStylesheet parses css into the rules inlined and the ones kept
+func parseStylesheet(css string) (stylesheet, error) {
+	var sheet stylesheet
+	css = cssComment.ReplaceAllString(css, "")
+
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			return sheet, nil
+		}
+
+		// At-rules are kept whole, statements up to their ; and blocks up to their closing brace
+		if strings.HasPrefix(css, "@") {
+			end := strings.IndexAny(css, ";{")
+			if end < 0 {
+				return sheet, errors.New("unterminated at-rule")
+			}
+			if css[end] == ';' {
+				sheet.kept = append(sheet.kept, css[:end+1])
+				css = css[end+1:]
+				continue
+			}
+			close, err := matchingBrace(css, end)
+			if err != nil {
+				return sheet, err
+			}
+			sheet.kept = append(sheet.kept, css[:close+1])
+			css = css[close+1:]
+			continue
+		}
+
+		open := strings.Index(css, "{")
+		close := strings.Index(css, "}")
+		if open < 0 || close < open {
+			return sheet, errors.New("rule without a declaration block")
+		}
+		selectors, block := css[:open], css[open+1:close]
+		css = css[close+1:]
+
+		declarations := parseDeclarations(block)
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			r, ok := parseSelector(selector)
+			if !ok {
+				sheet.kept = append(sheet.kept, selector+" {"+block+"}")
+				continue
+			}
+			r.declarations = declarations
+			sheet.rules = append(sheet.rules, r)
+		}
+	}
+}
+
+// matchingBrace returns where the block opened at open closes
+func matchingBrace(css string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, errors.New("unbalanced braces")
+}
+
+// parseSelector parses a simple selector, false for any other
+func parseSelector(selector string) (rule, bool) {
+	match := simpleSelector.FindStringSubmatch(selector)
+	if match == nil || selector == "" {
+		return rule{}, false
+	}
+
+	r := rule{element: strings.ToLower(match[1])}
+	if r.element != "" && r.element != "*" {
+		r.specificity++
+	}
+	for _, part := range selectorPart.FindAllString(match[2], -1) {
+		if part[0] == '#' {
+			r.ids = append(r.ids, part[1:])
+			r.specificity += 100
+		} else {
+			r.classes = append(r.classes, part[1:])
+			r.specificity += 10
+		}
+	}
+	return r, true
+}
+
+// parseDeclarations parses the declarations of a block or style attribute
+func parseDeclarations(block string) []declaration {
+	var declarations []declaration
+	for _, part := range strings.Split(block, ";") {
+		property, value, ok := strings.Cut(part, ":")
+		property, value = strings.ToLower(strings.TrimSpace(property)), strings.TrimSpace(value)
+		if !ok || property == "" || value == "" {
+			continue
+		}
+		declarations = append(declarations, declaration{property: property, value: value})
+	}
+	return declarations
+}
+
+// matches reports whether the rule's selector matches the element
+func (r rule) matches(n *html.Node) bool {
+	if r.element != "" && r.element != "*" && r.element != n.Data {
+		return false
+	}
+	classes := strings.Fields(attribute(n, "class"))
+	for _, class := range r.classes {
+		if !slices.Contains(classes, class) {
+			return false
+		}
+	}
+	for _, id := range r.ids {
+		if attribute(n, "id") != id {
+			return false
+		}
+	}
+	return true
+}
+
+// inline moves the styles of the stylesheet and of the document's <style>
+// elements into the style attributes of the elements they match. Rules
+// apply by specificity and then in order, and declarations already in a
+// style attribute win over all of them.
+func inline(document string, sheet stylesheet) (string, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	// Rules of <style> elements follow the template's stylesheet
+	var styleElements []*html.Node
+	for n := range root.Descendants() {
+		if n.Type == html.ElementNode && n.Data == "style" {
+			styleElements = append(styleElements, n)
+		}
+	}
+	for _, n := range styleElements {
+		var css strings.Builder
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			css.WriteString(child.Data)
+		}
+		embedded, err := parseStylesheet(css.String())
+		if err != nil {
+			return "", err
+		}
+		sheet.rules = append(slices.Clip(sheet.rules), embedded.rules...)
+		sheet.kept = append(slices.Clip(sheet.kept), embedded.kept...)
+		n.Parent.RemoveChild(n)
+	}
+
+	// Stable, so rules of equal specificity keep their order
+	rules := slices.Clone(sheet.rules)
+	slices.SortStableFunc(rules, func(a, b rule) int { return a.specificity - b.specificity })
+
+	var head, body *html.Node
+	for n := range root.Descendants() {
+		if n.Type == html.ElementNode && n.Data == "head" && head == nil {
+			head = n
+		}
+		if n.Type == html.ElementNode && n.Data == "body" && body == nil {
+			body = n
+		}
+	}
+	if body != nil {
+		applyRules(body, rules)
+		for n := range body.Descendants() {
+			applyRules(n, rules)
+		}
+	}
+
+	if len(sheet.kept) > 0 && head != nil {
+		element := &html.Node{Type: html.ElementNode, Data: "style"}
+		element.AppendChild(&html.Node{Type: html.TextNode, Data: strings.Join(sheet.kept, "\n")})
+		head.AppendChild(element)
+	}
+
+	var out strings.Builder
+	if err := html.Render(&out, root); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// applyRules sets the declarations of the rules matching an element in its
+// style attribute, ahead of the ones already there
+func applyRules(n *html.Node, rules []rule) {
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	var declarations []declaration
+	for _, r := range rules {
+		if r.matches(n) {
+			declarations = append(declarations, r.declarations...)
+		}
+	}
+	if len(declarations) == 0 {
+		return
+	}
+	declarations = append(declarations, parseDeclarations(attribute(n, "style"))...)
+	setAttribute(n, "style", formatDeclarations(declarations))
+}
+
+// formatDeclarations formats declarations as a style attribute, later ones
+// replacing earlier ones of the same property
+func formatDeclarations(declarations []declaration) string {
+	var properties []string
+	values := make(map[string]string, len(declarations))
+	for _, d := range declarations {
+		if _, ok := values[d.property]; !ok {
+			properties = append(properties, d.property)
+		}
+		values[d.property] = d.value
+	}
+
+	parts := make([]string, 0, len(properties))
+	for _, property := range properties {
+		parts = append(parts, property+": "+values[property])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// attribute returns the value of an element's attribute, empty when it has none
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// setAttribute sets an element's attribute, adding it when it has none
+func setAttribute(n *html.Node, key, value string) {
+	for i, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
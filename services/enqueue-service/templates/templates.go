@@ -0,0 +1,335 @@
+// Package templates renders the emails of notifications from templates
+// stored by name, since hand-written html in content rarely survives email
+// clients. A template is a Go html/template body with a stylesheet, which
+// is inlined into the elements' style attributes because many clients drop
+// <style> elements, and a subject. The plaintext alternative is rendered
+// from its own template or generated from the html.
+package templates
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/enqueue-service/models"
+)
+
+// Extension of template files, named after their template
+const templateExtension = ".json"
+
+var (
+	// ErrInvalid is returned for templates that can't be stored
+	ErrInvalid = errors.New("invalid template")
+	// ErrNotFound is returned for templates that don't exist
+	ErrNotFound = errors.New("template not found")
+	// ErrRender is returned when a template fails on the data it is rendered with
+	ErrRender = errors.New("failed to render template")
+)
+
+// Names templates may have, so a name is a file in the directory and nothing else
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Template of an email
+type Template struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`        // text/template
+	HTML    string `json:"html"`           // html/template
+	CSS     string `json:"css,omitempty"`  // Inlined into the html, along with its <style> elements
+	Text    string `json:"text,omitempty"` // text/template of the plaintext alternative, generated from the html when empty
+}
+
+// Data templates are rendered with, e.g. {{.Metadata.amount}}
+type Data struct {
+	EventType string         `json:"event_type"`
+	Metadata  map[string]any `json:"metadata"`
+}
+
+// compiled is a template along with its parsed parts
+type compiled struct {
+	Template
+	subject  *texttemplate.Template
+	html     *htmltemplate.Template
+	text     *texttemplate.Template // Nil when the plaintext is generated
+	styles   stylesheet
+	modified time.Time // Of the file it was loaded from
+}
+
+// Store keeps the templates in a directory, one file per template, shared
+// by the instances of the service
+type Store struct {
+	dir      string
+	interval time.Duration
+
+	mu        sync.RWMutex
+	templates map[string]*compiled
+}
+
+// Config of the store
+type Config struct {
+	Dir          string        // Directory of the template files, created when missing
+	PollInterval time.Duration // How often the directory is checked for changes
+}
+
+// New creates a store and loads the templates in the directory
+func New(cfg Config) (*Store, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create template directory: %w", err)
+	}
+	s := &Store{
+		dir:       cfg.Dir,
+		interval:  cfg.PollInterval,
+		templates: make(map[string]*compiled),
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Render renders the email of the named template
+func (s *Store) Render(name string, data Data) (*models.EmailContent, error) {
+	s.mu.RLock()
+	t, ok := s.templates[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return t.render(data)
+}
+
+// Get returns the named template
+func (s *Store) Get(name string) (Template, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.templates[name]
+	if !ok {
+		return Template{}, false
+	}
+	return t.Template, true
+}
+
+// Templates returns the stored templates by name
+func (s *Store) Templates() []Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates := make([]Template, 0, len(s.templates))
+	for _, t := range s.templates {
+		templates = append(templates, t.Template)
+	}
+	slices.SortFunc(templates, func(a, b Template) int { return strings.Compare(a.Name, b.Name) })
+	return templates
+}
+
+// Put checks and parses a template, stores it in the directory and uses it
+// from now on instead of the previous one of that name
+func (s *Store) Put(t Template) error {
+	if !namePattern.MatchString(t.Name) {
+		return fmt.Errorf("%w: name must be up to 64 lowercase letters, digits, dashes and underscores", ErrInvalid)
+	}
+	c, err := compile(t)
+	if err != nil {
+		return err
+	}
+
+	// Write it next to the others and rename it, so readers never see half a template
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal template: %w", err)
+	}
+	path := filepath.Join(s.dir, t.Name+templateExtension)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to store template: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to store template: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to store template: %w", err)
+	}
+	c.modified = info.ModTime()
+
+	s.mu.Lock()
+	s.templates[t.Name] = c
+	s.mu.Unlock()
+	return nil
+}
+
+// Remove deletes the named template, reporting false when there was none
+func (s *Store) Remove(name string) (bool, error) {
+	if !namePattern.MatchString(name) {
+		return false, nil
+	}
+	err := os.Remove(filepath.Join(s.dir, name+templateExtension))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to remove template: %w", err)
+	}
+
+	s.mu.Lock()
+	delete(s.templates, name)
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Watch picks up the templates other instances stored, changed or removed
+// until the context is done. A template that fails to load is logged and
+// leaves the previous one in use.
+func (s *Store) Watch(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reload(); err != nil {
+				log.Printf("Failed to reload email templates: %v", err)
+			}
+		}
+	}
+}
+
+// reload loads the template files that changed since the last load
+func (s *Store) reload() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list email templates: %w", err)
+	}
+
+	found := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), templateExtension)
+		if !ok || entry.IsDir() || !namePattern.MatchString(name) {
+			continue
+		}
+		found[name] = true
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		s.mu.RLock()
+		current, loaded := s.templates[name]
+		s.mu.RUnlock()
+		if loaded && current.modified.Equal(info.ModTime()) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			log.Printf("Failed to read email template %s: %v", name, err)
+			continue
+		}
+		var t Template
+		if err := json.Unmarshal(data, &t); err != nil {
+			log.Printf("Ignoring email template %s: %v", name, err)
+			continue
+		}
+		t.Name = name
+		c, err := compile(t)
+		if err != nil {
+			log.Printf("Ignoring email template %s: %v", name, err)
+			continue
+		}
+		c.modified = info.ModTime()
+
+		s.mu.Lock()
+		s.templates[name] = c
+		s.mu.Unlock()
+		log.Printf("Loaded email template %s", name)
+	}
+
+	s.mu.Lock()
+	for name := range s.templates {
+		if !found[name] {
+			delete(s.templates, name)
+			log.Printf("Unloaded email template %s", name)
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// compile parses the parts of a template
+func compile(t Template) (*compiled, error) {
+	if t.Subject == "" || t.HTML == "" {
+		return nil, fmt.Errorf("%w: subject and html are required", ErrInvalid)
+	}
+
+	c := &compiled{Template: t}
+	var err error
+	if c.subject, err = texttemplate.New("subject").Option("missingkey=error").Parse(t.Subject); err != nil {
+		return nil, fmt.Errorf("%w: subject: %v", ErrInvalid, err)
+	}
+	if c.html, err = htmltemplate.New("html").Option("missingkey=error").Parse(t.HTML); err != nil {
+		return nil, fmt.Errorf("%w: html: %v", ErrInvalid, err)
+	}
+	if t.Text != "" {
+		if c.text, err = texttemplate.New("text").Option("missingkey=error").Parse(t.Text); err != nil {
+			return nil, fmt.Errorf("%w: text: %v", ErrInvalid, err)
+		}
+	}
+	if c.styles, err = parseStylesheet(t.CSS); err != nil {
+		return nil, fmt.Errorf("%w: css: %v", ErrInvalid, err)
+	}
+	return c, nil
+}
+
+// render renders the email of the template with the data
+func (c *compiled) render(data Data) (*models.EmailContent, error) {
+	if data.Metadata == nil {
+		data.Metadata = map[string]any{}
+	}
+
+	var subject, body strings.Builder
+	if err := c.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrRender, c.Name, err)
+	}
+	if err := c.html.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrRender, c.Name, err)
+	}
+	html, err := inline(body.String(), c.styles)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrRender, c.Name, err)
+	}
+
+	var text string
+	if c.text != nil {
+		var b strings.Builder
+		if err := c.text.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrRender, c.Name, err)
+		}
+		text = b.String()
+	} else if text, err = plaintext(html); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrRender, c.Name, err)
+	}
+
+	return &models.EmailContent{
+		Subject: strings.Join(strings.Fields(subject.String()), " "), // Headers can't span lines
+		HTML:    html,
+		Text:    text,
+	}, nil
+}
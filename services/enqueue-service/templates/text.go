@@ -0,0 +1,149 @@
+package templates
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements whose content isn't shown
+var hiddenElements = map[string]bool{
+	"head": true, "style": true, "script": true, "title": true, "template": true,
+}
+
+// Elements set apart from the text around them by a blank line
+var blockElements = map[string]bool{
+	"p": true, "div": true, "table": true, "ul": true, "ol": true, "blockquote": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"section": true, "article": true, "header": true, "footer": true,
+}
+
+// Elements starting on a line of their own
+var lineElements = map[string]bool{
+	"tr": true, "li": true, "dt": true, "dd": true,
+}
+
+// textWriter writes the plaintext of an html document, collapsing the
+// whitespace of text and breaking lines at blocks
+type textWriter struct {
+	out      strings.Builder
+	newlines int  // Line breaks owed before the next text
+	space    bool // A space is owed before the next text
+	pre      int  // Depth of <pre> elements, whose whitespace is kept
+}
+
+// plaintext returns the text alternative of an html document: its text
+// with paragraphs, line breaks and list items kept, and the targets of
+// links and the descriptions of images written out
+func plaintext(document string) (string, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	var w textWriter
+	w.node(root)
+	return strings.TrimSpace(w.out.String()) + "\n", nil
+}
+
+// node writes a node and its children
+func (w *textWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	case html.DocumentNode:
+		w.children(n)
+		return
+	default:
+		return
+	}
+	if hiddenElements[n.Data] {
+		return
+	}
+
+	switch {
+	case n.Data == "br":
+		w.breakLines(1)
+	case n.Data == "hr":
+		w.breakLines(2)
+		w.text("----")
+		w.breakLines(2)
+	case n.Data == "img":
+		if alt := attribute(n, "alt"); alt != "" {
+			w.text(alt)
+		}
+	case n.Data == "a":
+		start := w.out.Len()
+		w.children(n)
+		label := strings.TrimSpace(w.out.String()[start:])
+		if href := attribute(n, "href"); href != "" && href != label && !strings.HasPrefix(href, "#") {
+			w.space = true
+			w.text("(" + strings.TrimPrefix(href, "mailto:") + ")")
+		}
+	case n.Data == "li":
+		w.breakLines(1)
+		w.text("- ")
+		w.children(n)
+	case n.Data == "td" || n.Data == "th":
+		w.space = true
+		w.children(n)
+		w.space = true
+	case n.Data == "pre":
+		w.breakLines(2)
+		w.pre++
+		w.children(n)
+		w.pre--
+		w.breakLines(2)
+	case blockElements[n.Data]:
+		w.breakLines(2)
+		w.children(n)
+		w.breakLines(2)
+	case lineElements[n.Data]:
+		w.breakLines(1)
+		w.children(n)
+		w.breakLines(1)
+	default:
+		w.children(n)
+	}
+}
+
+// children writes the children of a node
+func (w *textWriter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.node(child)
+	}
+}
+
+// breakLines owes at least count line breaks before the next text
+func (w *textWriter) breakLines(count int) {
+	w.newlines = max(w.newlines, count)
+}
+
+// text writes text, collapsing its whitespace outside <pre>
+func (w *textWriter) text(text string) {
+	if w.pre == 0 {
+		if strings.TrimSpace(text) == "" {
+			w.space = w.space || text != ""
+			return
+		}
+		leading := strings.TrimLeft(text, " \t\r\n") != text
+		trailing := strings.TrimRight(text, " \t\r\n") != text
+		text = strings.Join(strings.Fields(text), " ")
+		if trailing && !strings.HasSuffix(text, " ") {
+			defer func() { w.space = true }()
+		}
+		w.space = w.space || leading
+	}
+
+	if w.out.Len() > 0 {
+		if w.newlines > 0 {
+			w.out.WriteString(strings.Repeat("\n", w.newlines))
+		} else if w.space && !strings.HasSuffix(w.out.String(), " ") && !strings.HasSuffix(w.out.String(), "\n") {
+			w.out.WriteByte(' ')
+		}
+	}
+	w.newlines, w.space = 0, false
+	w.out.WriteString(text)
+}
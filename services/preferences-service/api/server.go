@@ -0,0 +1,477 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+	_ "time/tzdata" // Validate time zones without relying on the image's zoneinfo
+
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/store"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminauth"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+)
+
+// Longest a user can snooze notifications for
+const maxSnoozeHours = 7 * 24
+
+// Page sizes of listed subscribers
+const (
+	defaultSubscribersPage = 500
+	maxSubscribersPage     = 5000
+)
+
+// HTTP server struct
+type Server struct {
+	server   *http.Server
+	store    store.Store
+	producer kafka.Producer
+	handled  atomic.Int64 // Requests handled since start, health and version checks aside
+}
+
+// Creates a new HTTP server
+func NewServer(cfg config.ServerConfig, store store.Store, producer kafka.Producer) *Server {
+	mux := http.NewServeMux()
+
+	server := Server{
+		server: &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.Port),
+			Handler:      mux,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		},
+		store:    store,
+		producer: producer,
+	}
+
+	// Routes
+	mux.HandleFunc("GET /api/v1/users/{userID}/preferences", server.handleGetPreferences)
+	mux.HandleFunc("PATCH /api/v1/users/{userID}/preferences", server.handleUpdatePreferences)
+	mux.HandleFunc("PUT /api/v1/users/{userID}/snooze", server.handleSnooze)
+	mux.HandleFunc("DELETE /api/v1/users/{userID}/snooze", server.handleUnsnooze)
+	mux.HandleFunc("GET /api/v1/users/{userID}/subscriptions", server.handleListSubscriptions)
+	mux.HandleFunc("PUT /api/v1/users/{userID}/subscriptions/{entityType}/{entityID}", server.handleSubscribe)
+	mux.HandleFunc("DELETE /api/v1/users/{userID}/subscriptions/{entityType}/{entityID}", server.handleUnsubscribe)
+	mux.HandleFunc("GET /api/v1/entities/{entityType}/{entityID}/subscribers", server.handleListSubscribers)
+	mux.HandleFunc("PUT /api/v1/users/{userID}/status", server.handleSetStatus)
+	mux.Handle("DELETE /api/v1/users/{userID}", adminauth.Required(http.HandlerFunc(server.handleDeleteUserData), cfg.Operators))
+	mux.Handle("GET /api/v1/users/{userID}/export", adminauth.Required(http.HandlerFunc(server.handleExportUserData), cfg.Operators))
+	mux.HandleFunc("/health", server.handleHealth)
+	mux.HandleFunc("GET /version", server.handleVersion)
+	server.server.Handler = server.counted(mux)
+
+	return &server
+}
+
+// Starts the HTTP server
+func (s *Server) Start() error {
+	return s.server.ListenAndServe()
+}
+
+// Gracefully shuts down the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Returns the number of requests handled since start, health and version checks aside
+func (s *Server) Handled() int64 {
+	return s.handled.Load()
+}
+
+// Counts the requests handled by next
+func (s *Server) counted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if r.URL.Path != "/health" && r.URL.Path != "/version" {
+			s.handled.Add(1)
+		}
+	})
+}
+
+// Handles requests for a user's stored preferences
+func (s *Server) handleGetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	prefs, err := s.store.GetUserPreferences(r.Context(), userID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get preferences for user %s: %v", userID, err)
+		http.Error(w, "Failed to get preferences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs)
+}
+
+// Handles partial updates of a user's preferences
+func (s *Server) handleUpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	var update models.PreferencesUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if update.Region != nil && !validRegion(*update.Region) {
+		http.Error(w, "Region must be at most 16 lowercase letters, digits and dashes", http.StatusBadRequest)
+		return
+	}
+	if update.Timezone != nil && !validTimezone(*update.Timezone) {
+		http.Error(w, "Timezone must be an IANA time zone name like Europe/Berlin", http.StatusBadRequest)
+		return
+	}
+
+	err := s.store.UpdateUserPreferences(r.Context(), userID, &update)
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update preferences for user %s: %v", userID, err)
+		http.Error(w, "Failed to update preferences", http.StatusInternalServerError)
+		return
+	}
+
+	// Let consumers drop cached preferences right away. The update is already
+	// stored, so a failed publish only delays it until their cache expires.
+	event := &models.PreferenceChangeEvent{
+		UserID:    userID,
+		Type:      models.ChangeUpdated,
+		ChangedAt: time.Now().Unix(),
+	}
+	if err := s.producer.PublishChange(r.Context(), event); err != nil {
+		log.Printf("Failed to publish preference change for user %s: %v", userID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles requests to snooze a user's notifications
+func (s *Server) handleSnooze(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	var req models.SnoozeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Hours <= 0 || req.Hours > maxSnoozeHours {
+		http.Error(w, fmt.Sprintf("Hours must be between 1 and %d", maxSnoozeHours), http.StatusBadRequest)
+		return
+	}
+
+	snooze := models.Snooze{
+		EventType: req.EventType,
+		Until:     time.Now().Add(time.Duration(req.Hours) * time.Hour).UTC().Truncate(time.Second),
+	}
+	err := s.store.SnoozeUser(r.Context(), userID, snooze.EventType, snooze.Until)
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to snooze notifications of user %s: %v", userID, err)
+		http.Error(w, "Failed to snooze notifications", http.StatusInternalServerError)
+		return
+	}
+
+	s.publishUpdate(r.Context(), userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snooze)
+}
+
+// Handles requests to end a snooze early, ?event_type= selects the snooze of one event type
+func (s *Server) handleUnsnooze(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	err := s.store.UnsnoozeUser(r.Context(), userID, r.URL.Query().Get("event_type"))
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to end snooze of user %s: %v", userID, err)
+		http.Error(w, "Failed to end snooze", http.StatusInternalServerError)
+		return
+	}
+
+	s.publishUpdate(r.Context(), userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles requests for the entities a user follows
+func (s *Server) handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	subscriptions, err := s.store.ListSubscriptions(r.Context(), userID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list subscriptions of user %s: %v", userID, err)
+		http.Error(w, "Failed to list subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+// Handles requests to follow an entity
+func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+	entityType, entityID, ok := entityPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.store.Subscribe(r.Context(), userID, entityType, entityID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to subscribe user %s to %s %s: %v", userID, entityType, entityID, err)
+		http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles requests to stop following an entity
+func (s *Server) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+	entityType, entityID, ok := entityPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.store.Unsubscribe(r.Context(), userID, entityType, entityID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to unsubscribe user %s from %s %s: %v", userID, entityType, entityID, err)
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles requests for a page of the users following an entity, ?after=&limit=
+func (s *Server) handleListSubscribers(w http.ResponseWriter, r *http.Request) {
+	entityType, entityID, ok := entityPath(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultSubscribersPage
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxSubscribersPage {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", maxSubscribersPage), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	subscribers, err := s.store.ListSubscribers(r.Context(), entityType, entityID, r.URL.Query().Get("after"), limit)
+	if err != nil {
+		log.Printf("Failed to list subscribers of %s %s: %v", entityType, entityID, err)
+		http.Error(w, "Failed to list subscribers", http.StatusInternalServerError)
+		return
+	}
+
+	// A full page may be followed by more
+	page := models.SubscribersPage{Subscribers: subscribers}
+	if len(subscribers) == limit {
+		page.Next = subscribers[len(subscribers)-1]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// Reads the entity of a subscription request from its path, answering bad
+// requests for entities that can't be stored
+func entityPath(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	entityType, entityID := r.PathValue("entityType"), r.PathValue("entityID")
+	if len(entityType) > models.MaxEntityTypeLength || len(entityID) > models.MaxEntityIDLength {
+		http.Error(w, fmt.Sprintf("Entity type and ID must be at most %d and %d bytes",
+			models.MaxEntityTypeLength, models.MaxEntityIDLength), http.StatusBadRequest)
+		return "", "", false
+	}
+	return entityType, entityID, true
+}
+
+// Lets consumers drop a user's cached preferences, which carry the snoozes.
+// The change is already stored, so a failed publish only delays it until
+// their cache expires.
+func (s *Server) publishUpdate(ctx context.Context, userID string) {
+	event := &models.PreferenceChangeEvent{
+		UserID:    userID,
+		Type:      models.ChangeUpdated,
+		ChangedAt: time.Now().Unix(),
+	}
+	if err := s.producer.PublishChange(ctx, event); err != nil {
+		log.Printf("Failed to publish preference change for user %s: %v", userID, err)
+	}
+}
+
+// Handles changes of a user's account status
+func (s *Server) handleSetStatus(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	var update models.StatusUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	switch update.Status {
+	case models.StatusActive, models.StatusSuspended, models.StatusDeleted:
+	default:
+		http.Error(w, "Status must be active, suspended or deleted", http.StatusBadRequest)
+		return
+	}
+
+	requestedBy := adminauth.Actor(r)
+	err := s.store.SetUserStatus(r.Context(), userID, update.Status, requestedBy)
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to set status of user %s: %v", userID, err)
+		http.Error(w, "Failed to set user status", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Set status of user %s to %s as requested by %s", userID, update.Status, requestedBy)
+
+	// Let consumers drop cached preferences, which carry the status
+	event := &models.PreferenceChangeEvent{
+		UserID:    userID,
+		Type:      models.ChangeUpdated,
+		ChangedAt: time.Now().Unix(),
+	}
+	if err := s.producer.PublishChange(r.Context(), event); err != nil {
+		log.Printf("Failed to publish status change for user %s: %v", userID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles erasure requests for all data stored about a user
+func (s *Server) handleDeleteUserData(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+	requestedBy := adminauth.Actor(r)
+
+	// Deleting an erased user again publishes the event again, so a request
+	// whose publish failed can be retried
+	err := s.store.DeleteUserData(r.Context(), userID, requestedBy)
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, store.ErrUserDeleted):
+		log.Printf("User %s was deleted already, requesting downstream erasure again for %s", userID, requestedBy)
+	case err != nil:
+		log.Printf("Failed to delete data of user %s: %v", userID, err)
+		http.Error(w, "Failed to delete user data", http.StatusInternalServerError)
+		return
+	default:
+		log.Printf("Deleted data of user %s as requested by %s", userID, requestedBy)
+	}
+
+	// Downstream services erase what they hold about the user on this event
+	event := &models.PreferenceChangeEvent{
+		UserID:    userID,
+		Type:      models.ChangeDeleted,
+		ChangedAt: time.Now().Unix(),
+	}
+	if err := s.producer.PublishChange(r.Context(), event); err != nil {
+		log.Printf("Failed to publish deletion of user %s: %v", userID, err)
+		http.Error(w, "User data deleted, but downstream erasure could not be requested, retry the request", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles export requests for the data the preferences database holds about a user
+func (s *Server) handleExportUserData(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	export, err := s.store.ExportUserData(r.Context(), userID, adminauth.Actor(r))
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to export data of user %s: %v", userID, err)
+		http.Error(w, "Failed to export user data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", userID+".json"))
+	json.NewEncoder(w).Encode(export)
+}
+
+// Reports whether a region is a short lowercase name like eu or us-east, empty for the default region
+func validRegion(region string) bool {
+	if len(region) > 16 {
+		return false
+	}
+	for _, c := range region {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether a time zone is a known IANA name, empty for unknown
+func validTimezone(timezone string) bool {
+	if timezone == "" {
+		return true
+	}
+	if len(timezone) > 64 || timezone == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(timezone)
+	return err == nil
+}
+
+// Handles requests for the version of the running code
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
+
+// Handles health check requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+		"time":   time.Now().Format(time.RFC3339),
+	})
+}
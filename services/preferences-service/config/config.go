@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/envconfig"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/secrets"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// HTTP server config
+type ServerConfig struct {
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	Operators    map[string]string // Bearer token of each operator allowed to export and delete user data, by name; empty leaves it open
+}
+
+// Kafka config for preference change events
+type KafkaConfig struct {
+	Brokers           []string
+	Topic             string
+	RetryMax          int
+	RequiredAcks      int
+	Partitions        int
+	ReplicationFactor int
+	SendTimeout       time.Duration
+	Signing           signing.Config // Keys produced messages are signed with
+}
+
+// Producer returns the settings the producers of the service share
+func (c KafkaConfig) Producer() kafkaproducer.Config {
+	return kafkaproducer.Config{
+		Brokers:           c.Brokers,
+		RequiredAcks:      c.RequiredAcks,
+		RetryMax:          c.RetryMax,
+		Partitions:        c.Partitions,
+		ReplicationFactor: c.ReplicationFactor,
+		Signing:           c.Signing,
+	}
+}
+
+// Database config
+type DatabaseConfig struct {
+	Driver       string
+	DSN          string // Or a reference to a secret holding it, read again for every new connection
+	MaxConns     int
+	MaxIdle      int
+	QueryTimeout time.Duration
+}
+
+// Main config
+type Config struct {
+	Server    ServerConfig
+	Kafka     KafkaConfig
+	Database  DatabaseConfig
+	Startup   StartupConfig
+	Heartbeat HeartbeatConfig
+	Shutdown  ShutdownConfig
+	Secrets   secrets.Config
+
+	secretStore *secrets.Store // Resolves the secrets Load read, and keeps them current
+}
+
+// Returns the store the configuration's secrets were resolved from
+func (c *Config) SecretStore() *secrets.Store {
+	return c.secretStore
+}
+
+// Holds how long the service waits for MySQL and Kafka at startup
+type StartupConfig struct {
+	Preflight       bool          // Check the dependencies before creating any client
+	RetryTimeout    time.Duration // Give up after this long, zero tries once
+	RetryBackoff    time.Duration // Wait after the first failed attempt, doubled after every further one
+	RetryMaxBackoff time.Duration
+}
+
+// Holds where and how often the instance announces it is alive
+type HeartbeatConfig struct {
+	Topic      string        // Internal ops topic heartbeats are published to
+	Interval   time.Duration // Zero disables heartbeats
+	InstanceID string        // Defaults to the host name, the container ID under Docker
+}
+
+// Holds the timeouts of the shutdown stages, run in this order
+type ShutdownConfig struct {
+	Drain time.Duration // Stop accepting requests and finish the ones in flight
+	Flush time.Duration // Flush and close the producers
+	Close time.Duration // Close the remaining clients
+}
+
+// DefaultConfig
+var DefaultConfig = Config{
+	Server: ServerConfig{
+		Port:         8082,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	},
+	Kafka: KafkaConfig{
+		Brokers:           []string{"localhost:9092"},
+		Topic:             "notifications.preferences.changes",
+		RetryMax:          3,
+		RequiredAcks:      1,
+		Partitions:        3,
+		ReplicationFactor: 2,
+		SendTimeout:       5 * time.Second,
+	},
+	Database: DatabaseConfig{
+		Driver:       "mysql",
+		DSN:          "",
+		MaxConns:     10,
+		MaxIdle:      5,
+		QueryTimeout: 2 * time.Second,
+	},
+	Startup: StartupConfig{
+		Preflight:       true,
+		RetryTimeout:    2 * time.Minute,
+		RetryBackoff:    time.Second,
+		RetryMaxBackoff: 15 * time.Second,
+	},
+	Heartbeat: HeartbeatConfig{
+		Topic:    "notifications.ops",
+		Interval: 10 * time.Second,
+	},
+	Shutdown: ShutdownConfig{
+		Drain: 10 * time.Second,
+		Flush: 5 * time.Second,
+		Close: 5 * time.Second,
+	},
+	Secrets: secrets.Config{
+		RefreshInterval: 5 * time.Minute,
+	},
+}
+
+// Loads config from environment variables
+func Load() (*Config, error) {
+	cfg := DefaultConfig
+
+	// Secrets config first, any secret below may refer to a provider
+	envconfig.LoadStringEnv("VAULT_ADDR", &cfg.Secrets.Vault.Addr)
+	envconfig.LoadStringEnv("VAULT_TOKEN", &cfg.Secrets.Vault.Token)
+	envconfig.LoadStringEnv("VAULT_NAMESPACE", &cfg.Secrets.Vault.Namespace)
+	envconfig.LoadStringEnv("AWS_REGION", &cfg.Secrets.SSM.Region)
+	envconfig.LoadStringEnv("AWS_ACCESS_KEY_ID", &cfg.Secrets.SSM.AccessKeyID)
+	envconfig.LoadStringEnv("AWS_SECRET_ACCESS_KEY", &cfg.Secrets.SSM.SecretAccessKey)
+	envconfig.LoadStringEnv("AWS_SESSION_TOKEN", &cfg.Secrets.SSM.SessionToken)
+	envconfig.LoadStringEnv("SSM_ENDPOINT", &cfg.Secrets.SSM.Endpoint)
+	envconfig.LoadDurationEnv("SECRETS_REFRESH_INTERVAL", &cfg.Secrets.RefreshInterval)
+	store := secrets.New(cfg.Secrets)
+	cfg.secretStore = store
+
+	// Server config
+	envconfig.LoadIntEnv("SERVER_PORT", &cfg.Server.Port)
+	envconfig.LoadDurationEnv("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	envconfig.LoadDurationEnv("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	envconfig.LoadDurationEnv("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "ADMIN_OPERATORS", &cfg.Server.Operators); err != nil {
+		return nil, err
+	}
+
+	// Kafka config
+	envconfig.LoadJSONStringArrayEnv("KAFKA_BROKERS", &cfg.Kafka.Brokers)
+	envconfig.LoadStringEnv("KAFKA_TOPIC", &cfg.Kafka.Topic)
+	envconfig.LoadIntEnv("KAFKA_RETRY_MAX", &cfg.Kafka.RetryMax)
+	envconfig.LoadIntEnv("KAFKA_REQUIRED_ACKS", &cfg.Kafka.RequiredAcks)
+	envconfig.LoadIntEnv("KAFKA_PARTITIONS", &cfg.Kafka.Partitions)
+	envconfig.LoadIntEnv("KAFKA_REPLICATION_FACTOR", &cfg.Kafka.ReplicationFactor)
+	envconfig.LoadDurationEnv("KAFKA_SEND_TIMEOUT", &cfg.Kafka.SendTimeout)
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "KAFKA_SIGNING_KEYS", &cfg.Kafka.Signing.Keys); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("KAFKA_SIGNING_ACTIVE_KEY_ID", &cfg.Kafka.Signing.ActiveKeyID)
+	if _, err := cfg.Kafka.Signing.CreateKeyring(); err != nil {
+		return nil, fmt.Errorf("KAFKA_SIGNING_KEYS are invalid: %w", err)
+	}
+
+	// Database config
+	envconfig.LoadStringEnv("DB_DRIVER", &cfg.Database.Driver)
+	envconfig.LoadStringEnv("DB_DSN", &cfg.Database.DSN)
+	if store.IsReference(cfg.Database.DSN) {
+		// The DSN stays a reference, resolved by the driver for every new
+		// connection; resolving it now fails fast on a missing secret
+		if _, err := store.Resolve(cfg.Database.DSN); err != nil {
+			return nil, fmt.Errorf("DB_DSN: %w", err)
+		}
+		var err error
+		if cfg.Database.Driver, err = store.Driver(cfg.Database.Driver); err != nil {
+			return nil, fmt.Errorf("DB_DRIVER: %w", err)
+		}
+	}
+	envconfig.LoadIntEnv("DB_MAX_CONNS", &cfg.Database.MaxConns)
+	envconfig.LoadIntEnv("DB_MAX_IDLE", &cfg.Database.MaxIdle)
+	envconfig.LoadDurationEnv("DB_QUERY_TIMEOUT", &cfg.Database.QueryTimeout)
+
+	// Load heartbeat config
+	envconfig.LoadStringEnv("OPS_TOPIC", &cfg.Heartbeat.Topic)
+	envconfig.LoadDurationEnv("HEARTBEAT_INTERVAL", &cfg.Heartbeat.Interval)
+	envconfig.LoadStringEnv("INSTANCE_ID", &cfg.Heartbeat.InstanceID)
+	if cfg.Heartbeat.InstanceID == "" {
+		cfg.Heartbeat.InstanceID, _ = os.Hostname()
+	}
+
+	// General config
+	envconfig.LoadBoolEnv("STARTUP_PREFLIGHT", &cfg.Startup.Preflight)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_TIMEOUT", &cfg.Startup.RetryTimeout)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_BACKOFF", &cfg.Startup.RetryBackoff)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_MAX_BACKOFF", &cfg.Startup.RetryMaxBackoff)
+	envconfig.LoadDurationEnv("SHUTDOWN_TIMEOUT", &cfg.Shutdown.Drain) // Legacy name of the drain timeout
+	envconfig.LoadDurationEnv("SHUTDOWN_DRAIN_TIMEOUT", &cfg.Shutdown.Drain)
+	envconfig.LoadDurationEnv("SHUTDOWN_FLUSH_TIMEOUT", &cfg.Shutdown.Flush)
+	envconfig.LoadDurationEnv("SHUTDOWN_CLOSE_TIMEOUT", &cfg.Shutdown.Close)
+
+	return &cfg, nil
+}
@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// Interface for publishing preference change events
+type Producer interface {
+	PublishChange(ctx context.Context, event *models.PreferenceChangeEvent) error
+	Close() error
+}
+
+// Implements the Producer interface using Sarama
+type KafkaProducer struct {
+	producer    sarama.SyncProducer
+	topic       string
+	sendTimeout time.Duration // Upper bound for a single send, zero means no timeout
+}
+
+// Creates a new Kafka producer
+func NewProducer(cfg config.KafkaConfig) (Producer, error) {
+	// Ensure the topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	kafkaProducer := KafkaProducer{
+		producer:    sarama_producer,
+		topic:       cfg.Topic,
+		sendTimeout: cfg.SendTimeout,
+	}
+
+	return &kafkaProducer, nil
+}
+
+// Publishes a preference change event
+func (p *KafkaProducer) PublishChange(ctx context.Context, event *models.PreferenceChangeEvent) error {
+	// Marshal event to JSON
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	// Create message
+	msg := &sarama.ProducerMessage{
+		Topic: p.topic,
+		Key:   sarama.StringEncoder(event.UserID), // Keep a user's changes in order
+		Value: sarama.ByteEncoder(payload),
+	}
+
+	// Send message
+	partition, offset, err := p.send(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	log.Printf("Preference change for user %s sent to partition %d at offset %d", event.UserID, partition, offset)
+	return nil
+}
+
+// Sends a message, giving up once the context is done or the send timeout elapses.
+// An abandoned send may still complete in the background.
+func (p *KafkaProducer) send(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	return kafkaproducer.Send(ctx, p.producer, msg, p.sendTimeout)
+}
+
+// Closes the Kafka producer
+func (p *KafkaProducer) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/api"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/store"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/heartbeat"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/shutdown"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/startup"
+)
+
+func main() {
+	log.Printf("Starting Preferences Service %s", buildinfo.Get())
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Check every dependency up front, so a misconfiguration fails with what to fix
+	if cfg.Startup.Preflight {
+		retry := startup.Config{
+			Timeout:        cfg.Startup.RetryTimeout,
+			InitialBackoff: cfg.Startup.RetryBackoff,
+			MaxBackoff:     cfg.Startup.RetryMaxBackoff,
+		}
+		checks := []startup.Check{
+			{
+				Name: "Kafka brokers",
+				Hint: "check KAFKA_BROKERS and that the brokers are up",
+				Run: func(ctx context.Context) error {
+					return kafkaadmin.CheckTopics(ctx, cfg.Kafka.Brokers)
+				},
+			},
+			{
+				Name: "MySQL",
+				Hint: "check DB_DRIVER and the address and credentials in DB_DSN",
+				Run: func(ctx context.Context) error {
+					return store.CheckDatabase(ctx, cfg.Database.Driver, cfg.Database.DSN)
+				},
+			},
+		}
+		if err := startup.Preflight(context.Background(), retry, checks...); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Fetch the referenced secrets again, so a rotated DSN reaches new connections
+	if cfg.Secrets.RefreshInterval > 0 {
+		go cfg.SecretStore().Run(context.Background(), cfg.Secrets.RefreshInterval)
+	}
+
+	// Initialize preferences store
+	preferencesStore, err := store.NewSQLStore(store.Config{
+		Driver:       cfg.Database.Driver,
+		DSN:          cfg.Database.DSN,
+		MaxConns:     cfg.Database.MaxConns,
+		MaxIdle:      cfg.Database.MaxIdle,
+		QueryTimeout: cfg.Database.QueryTimeout,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create preferences store: %v", err)
+	}
+
+	// Initialize Kafka producer
+	producer, err := kafka.NewProducer(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka producer: %v", err)
+	}
+
+	// Initialize and start HTTP server
+	server := api.NewServer(cfg.Server, preferencesStore, producer)
+
+	// Announce this instance on the ops topic
+	flush := []func(ctx context.Context) error{shutdown.Close(producer.Close)}
+	if cfg.Heartbeat.Interval > 0 {
+		heartbeater, err := heartbeat.New(cfg.Kafka.Producer(), heartbeat.Config{
+			Service:    "preferences-service",
+			InstanceID: cfg.Heartbeat.InstanceID,
+			Topic:      cfg.Heartbeat.Topic,
+			Interval:   cfg.Heartbeat.Interval,
+		}, func(h *heartbeat.Heartbeat) {
+			h.Processed = server.Handled()
+		})
+		if err != nil {
+			log.Fatalf("Failed to create heartbeater: %v", err)
+		}
+		flush = append(flush, shutdown.Close(heartbeater.Close))
+		log.Printf("Publishing heartbeats of instance %s every %v", cfg.Heartbeat.InstanceID, cfg.Heartbeat.Interval)
+	}
+
+	go func() {
+		if err := server.Start(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	log.Println("Preferences Service started successfully")
+
+	// Wait for termination signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sigCh
+
+	log.Println("Shutdown signal received")
+
+	// Finish the requests in flight before the producer and store they use are closed
+	var sequencer shutdown.Sequencer
+	sequencer.Stage("drain", cfg.Shutdown.Drain, server.Shutdown)
+	sequencer.Stage("flush", cfg.Shutdown.Flush, flush...)
+	sequencer.Stage("close", cfg.Shutdown.Close, shutdown.Close(preferencesStore.Close))
+	sequencer.Run()
+
+	log.Println("Server gracefully stopped")
+}
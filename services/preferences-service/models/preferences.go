@@ -0,0 +1,113 @@
+package models
+
+import "time"
+
+// UserPreferences represents a user's notification preferences
+type UserPreferences struct {
+	UserID      string                     `json:"user_id"`
+	Status      string                     `json:"status"`
+	GlobalOptIn bool                       `json:"global_opt_in"`
+	Channels    map[string]bool            `json:"channels"`
+	EventTypes  map[string]map[string]bool `json:"event_types"`
+	Snoozes     []Snooze                   `json:"snoozes"`            // Snoozes in effect
+	Region      string                     `json:"region,omitempty"`   // Region the user's data lives in, e.g. eu, empty for the default region
+	Timezone    string                     `json:"timezone,omitempty"` // IANA time zone, e.g. Europe/Berlin, empty when unknown
+}
+
+// Partial update of a user's preferences, omitted fields are left unchanged
+type PreferencesUpdate struct {
+	GlobalOptIn *bool                      `json:"global_opt_in,omitempty"`
+	Channels    map[string]bool            `json:"channels,omitempty"`
+	EventTypes  map[string]map[string]bool `json:"event_types,omitempty"`
+	Region      *string                    `json:"region,omitempty"`   // Empty moves the user to the default region
+	Timezone    *string                    `json:"timezone,omitempty"` // Empty clears the time zone
+}
+
+// Account statuses of a user. Suspended and deleted users receive no
+// notifications; deleted users are soft-deleted and kept until erased.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusDeleted   = "deleted"
+)
+
+// Request to change a user's status
+type StatusUpdate struct {
+	Status string `json:"status"`
+}
+
+// Notifications a user snoozed: of one event type, or all of them when
+// EventType is empty. Snoozed notifications aren't sent until Until.
+type Snooze struct {
+	EventType string    `json:"event_type,omitempty"`
+	Until     time.Time `json:"until"`
+}
+
+// Request to snooze notifications for a number of hours, replacing an
+// earlier snooze of the same event type
+type SnoozeRequest struct {
+	EventType string `json:"event_type,omitempty"` // Empty snoozes all notifications
+	Hours     int    `json:"hours"`
+}
+
+// Entity a user follows, such as a thread, a project or an author
+type Subscription struct {
+	EntityType string    `json:"entity_type"`
+	EntityID   string    `json:"entity_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Longest accepted entity type and ID, as stored with subscriptions
+const (
+	MaxEntityTypeLength = 50
+	MaxEntityIDLength   = 191
+)
+
+// A page of the users following an entity, in user ID order. Next is the
+// cursor of the following page, empty on the last one.
+type SubscribersPage struct {
+	Subscribers []string `json:"subscribers"`
+	Next        string   `json:"next,omitempty"`
+}
+
+// Kinds of preference change events
+const (
+	ChangeUpdated = "updated" // Preferences were modified
+	ChangeDeleted = "deleted" // All of the user's data was erased
+)
+
+// Event published whenever a user's preferences change
+type PreferenceChangeEvent struct {
+	UserID    string `json:"user_id"`
+	Type      string `json:"type"`
+	ChangedAt int64  `json:"changed_at"`
+}
+
+// Contact details of a user for one channel
+type ContactInfo struct {
+	Channel  string `json:"channel"`
+	Value    string `json:"value"`
+	Verified bool   `json:"verified"`
+}
+
+// Record of a data lifecycle request made for a user
+type AuditRecord struct {
+	Action      string    `json:"action"`
+	RequestedBy string    `json:"requested_by"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// Everything the preferences database holds about a user, as returned by a
+// data export. What other services keep, such as archived notifications and
+// rate-limit counters, is not part of it.
+type UserDataExport struct {
+	UserID        string           `json:"user_id"`
+	Username      string           `json:"username"`
+	Email         string           `json:"email"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Preferences   *UserPreferences `json:"preferences"`
+	Contacts      []ContactInfo    `json:"contacts"`
+	Subscriptions []Subscription   `json:"subscriptions"`
+	AuditTrail    []AuditRecord    `json:"audit_trail"`
+	ExportedAt    time.Time        `json:"exported_at"`
+}
@@ -0,0 +1,582 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sahilsGit/scalable-notifications-service/services/preferences-service/models"
+)
+
+// Returned when the requested user doesn't exist
+var ErrUserNotFound = errors.New("user not found")
+
+// Returned when the user was erased by an earlier deletion
+var ErrUserDeleted = errors.New("user already deleted")
+
+// Store persists user notification preferences
+type Store interface {
+	GetUserPreferences(ctx context.Context, userID string) (*models.UserPreferences, error)
+	UpdateUserPreferences(ctx context.Context, userID string, update *models.PreferencesUpdate) error
+	// SnoozeUser snoozes notifications of an event type, all of them when it is empty, until the given time
+	SnoozeUser(ctx context.Context, userID, eventType string, until time.Time) error
+	// UnsnoozeUser ends a snooze early
+	UnsnoozeUser(ctx context.Context, userID, eventType string) error
+	// Subscribe makes the user follow an entity, doing nothing when they already do
+	Subscribe(ctx context.Context, userID, entityType, entityID string) error
+	// Unsubscribe makes the user stop following an entity, doing nothing when they don't
+	Unsubscribe(ctx context.Context, userID, entityType, entityID string) error
+	// ListSubscriptions returns the entities the user follows
+	ListSubscriptions(ctx context.Context, userID string) ([]models.Subscription, error)
+	// ListSubscribers returns up to limit users following an entity, in user ID
+	// order after the given one. Deleted users are left out.
+	ListSubscribers(ctx context.Context, entityType, entityID, after string, limit int) ([]string, error)
+	// SetUserStatus changes the user's account status, recording who asked for it
+	SetUserStatus(ctx context.Context, userID, status, requestedBy string) error
+	// DeleteUserData erases the user and everything stored about them,
+	// returning ErrUserDeleted when an earlier request erased them already
+	DeleteUserData(ctx context.Context, userID, requestedBy string) error
+	// ExportUserData returns everything this store holds about the user
+	ExportUserData(ctx context.Context, userID, requestedBy string) (*models.UserDataExport, error)
+	Close() error
+}
+
+// SQLStore implements Store using a SQL database
+type SQLStore struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// Config for the SQL store
+type Config struct {
+	Driver       string
+	DSN          string
+	MaxConns     int
+	MaxIdle      int
+	QueryTimeout time.Duration
+}
+
+// Opens a connection to the database and pings it, for preflight checks
+func CheckDatabase(ctx context.Context, driver, dsn string) error {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return fmt.Errorf("invalid %s DSN: %w", driver, err)
+	}
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s not reachable: %w", driver, err)
+	}
+	return nil
+}
+
+// Creates a new SQL store
+func NewSQLStore(config Config) (Store, error) {
+	db, err := sql.Open(config.Driver, config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Configure connection pool
+	db.SetMaxOpenConns(config.MaxConns)
+	db.SetMaxIdleConns(config.MaxIdle)
+
+	// Check connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &SQLStore{
+		db:           db,
+		queryTimeout: config.QueryTimeout,
+	}, nil
+}
+
+// Retrieves the stored preferences of a user
+func (s *SQLStore) GetUserPreferences(ctx context.Context, userID string) (*models.UserPreferences, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	prefs := &models.UserPreferences{
+		UserID:     userID,
+		Channels:   make(map[string]bool),
+		EventTypes: make(map[string]map[string]bool),
+		Snoozes:    []models.Snooze{},
+	}
+
+	err := s.db.QueryRowContext(ctx, "SELECT status, global_opt_in, region, timezone FROM users WHERE id = ?", userID).Scan(
+		&prefs.Status, &prefs.GlobalOptIn, &prefs.Region, &prefs.Timezone)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying user: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT channel_name, enabled FROM user_channel_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying channel preferences: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var channel string
+		var enabled bool
+		if err := rows.Scan(&channel, &enabled); err != nil {
+			return nil, fmt.Errorf("error scanning channel preferences: %w", err)
+		}
+		prefs.Channels[channel] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying channel preferences: %w", err)
+	}
+
+	rows, err = s.db.QueryContext(ctx,
+		"SELECT event_type, channel_name, enabled FROM user_event_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying event preferences: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventType, channel string
+		var enabled bool
+		if err := rows.Scan(&eventType, &channel, &enabled); err != nil {
+			return nil, fmt.Errorf("error scanning event preferences: %w", err)
+		}
+		if _, ok := prefs.EventTypes[eventType]; !ok {
+			prefs.EventTypes[eventType] = make(map[string]bool)
+		}
+		prefs.EventTypes[eventType][channel] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying event preferences: %w", err)
+	}
+
+	// Times are compared and read by the database so its time zone doesn't matter
+	rows, err = s.db.QueryContext(ctx,
+		`SELECT event_type, UNIX_TIMESTAMP(snoozed_until) FROM user_snoozes
+		 WHERE user_id = ? AND snoozed_until > NOW()`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying snoozes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var snooze models.Snooze
+		var until int64
+		if err := rows.Scan(&snooze.EventType, &until); err != nil {
+			return nil, fmt.Errorf("error scanning snoozes: %w", err)
+		}
+		snooze.Until = time.Unix(until, 0).UTC()
+		prefs.Snoozes = append(prefs.Snoozes, snooze)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying snoozes: %w", err)
+	}
+
+	return prefs, nil
+}
+
+// Snoozes notifications of a user, replacing an earlier snooze of the event type
+func (s *SQLStore) SnoozeUser(ctx context.Context, userID, eventType string, until time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkUser(ctx, userID); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_snoozes (user_id, event_type, snoozed_until) VALUES (?, ?, FROM_UNIXTIME(?))
+		 ON DUPLICATE KEY UPDATE snoozed_until = VALUES(snoozed_until)`,
+		userID, eventType, until.Unix()); err != nil {
+		return fmt.Errorf("error storing snooze: %w", err)
+	}
+	return nil
+}
+
+// Ends a snooze of a user, doing nothing when there is none
+func (s *SQLStore) UnsnoozeUser(ctx context.Context, userID, eventType string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkUser(ctx, userID); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		"DELETE FROM user_snoozes WHERE user_id = ? AND event_type = ?", userID, eventType); err != nil {
+		return fmt.Errorf("error deleting snooze: %w", err)
+	}
+	return nil
+}
+
+// Applies a partial preferences update in a single transaction
+func (s *SQLStore) UpdateUserPreferences(ctx context.Context, userID string, update *models.PreferencesUpdate) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error querying user: %w", err)
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	if update.GlobalOptIn != nil {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET global_opt_in = ? WHERE id = ?", *update.GlobalOptIn, userID); err != nil {
+			return fmt.Errorf("error updating global opt-in: %w", err)
+		}
+	}
+
+	if update.Region != nil {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET region = ? WHERE id = ?", *update.Region, userID); err != nil {
+			return fmt.Errorf("error updating region: %w", err)
+		}
+	}
+
+	if update.Timezone != nil {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET timezone = ? WHERE id = ?", *update.Timezone, userID); err != nil {
+			return fmt.Errorf("error updating timezone: %w", err)
+		}
+	}
+
+	for channel, enabled := range update.Channels {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO user_channel_preferences (user_id, channel_name, enabled) VALUES (?, ?, ?)
+			 ON DUPLICATE KEY UPDATE enabled = VALUES(enabled)`,
+			userID, channel, enabled); err != nil {
+			return fmt.Errorf("error updating channel preference: %w", err)
+		}
+	}
+
+	for eventType, channels := range update.EventTypes {
+		for channel, enabled := range channels {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO user_event_preferences (user_id, event_type, channel_name, enabled) VALUES (?, ?, ?, ?)
+				 ON DUPLICATE KEY UPDATE enabled = VALUES(enabled)`,
+				userID, eventType, channel, enabled); err != nil {
+				return fmt.Errorf("error updating event preference: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit preferences update: %w", err)
+	}
+	return nil
+}
+
+// Makes a user follow an entity
+func (s *SQLStore) Subscribe(ctx context.Context, userID, entityType, entityID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkUser(ctx, userID); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		"INSERT IGNORE INTO user_subscriptions (user_id, entity_type, entity_id) VALUES (?, ?, ?)",
+		userID, entityType, entityID); err != nil {
+		return fmt.Errorf("error storing subscription: %w", err)
+	}
+	return nil
+}
+
+// Makes a user stop following an entity
+func (s *SQLStore) Unsubscribe(ctx context.Context, userID, entityType, entityID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkUser(ctx, userID); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		"DELETE FROM user_subscriptions WHERE user_id = ? AND entity_type = ? AND entity_id = ?",
+		userID, entityType, entityID); err != nil {
+		return fmt.Errorf("error deleting subscription: %w", err)
+	}
+	return nil
+}
+
+// Lists the entities a user follows, oldest subscription first
+func (s *SQLStore) ListSubscriptions(ctx context.Context, userID string) ([]models.Subscription, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.subscriptions(ctx, userID)
+}
+
+// Lists a page of the users following an entity through the entity index
+func (s *SQLStore) ListSubscribers(ctx context.Context, entityType, entityID, after string, limit int) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT s.user_id FROM user_subscriptions s JOIN users u ON u.id = s.user_id
+		 WHERE s.entity_type = ? AND s.entity_id = ? AND s.user_id > ? AND u.status <> ?
+		 ORDER BY s.user_id LIMIT ?`,
+		entityType, entityID, after, models.StatusDeleted, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying subscribers: %w", err)
+	}
+	defer rows.Close()
+
+	subscribers := []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("error scanning subscribers: %w", err)
+		}
+		subscribers = append(subscribers, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying subscribers: %w", err)
+	}
+	return subscribers, nil
+}
+
+// Reads the entities a user follows
+func (s *SQLStore) subscriptions(ctx context.Context, userID string) ([]models.Subscription, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT entity_type, entity_id, created_at FROM user_subscriptions WHERE user_id = ? ORDER BY created_at, entity_type, entity_id",
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		var subscription models.Subscription
+		if err := rows.Scan(&subscription.EntityType, &subscription.EntityID, &subscription.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning subscriptions: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// Returns ErrUserNotFound unless the user exists
+func (s *SQLStore) checkUser(ctx context.Context, userID string) error {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error querying user: %w", err)
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Erases a user, the rows referencing it go through ON DELETE CASCADE
+func (s *SQLStore) DeleteUserData(ctx context.Context, userID, requestedBy string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return s.deletedBefore(ctx, tx, userID)
+	}
+
+	if err := s.recordAudit(ctx, tx, userID, "delete", requestedBy); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user deletion: %w", err)
+	}
+	return nil
+}
+
+// deletedBefore tells a user erased earlier, whose audit records are kept,
+// apart from one that never existed
+func (s *SQLStore) deletedBefore(ctx context.Context, tx *sql.Tx, userID string) error {
+	var deleted bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT TRUE FROM user_data_audit WHERE user_id = ? AND action = 'delete' LIMIT 1", userID,
+	).Scan(&deleted)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error querying audit trail: %w", err)
+	}
+	return ErrUserDeleted
+}
+
+// Changes the account status of a user
+func (s *SQLStore) SetUserStatus(ctx context.Context, userID, status, requestedBy string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT TRUE FROM users WHERE id = ? FOR UPDATE", userID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error querying user: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET status = ? WHERE id = ?", status, userID); err != nil {
+		return fmt.Errorf("error updating user status: %w", err)
+	}
+
+	if err := s.recordAudit(ctx, tx, userID, "status_"+status, requestedBy); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit status change: %w", err)
+	}
+	return nil
+}
+
+// Collects everything the preferences database holds about a user
+func (s *SQLStore) ExportUserData(ctx context.Context, userID, requestedBy string) (*models.UserDataExport, error) {
+	prefs, err := s.GetUserPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	export := &models.UserDataExport{
+		UserID:      userID,
+		Preferences: prefs,
+		Contacts:    []models.ContactInfo{},
+		AuditTrail:  []models.AuditRecord{},
+	}
+
+	err = s.db.QueryRowContext(ctx,
+		"SELECT username, email, created_at FROM users WHERE id = ?", userID,
+	).Scan(&export.Username, &export.Email, &export.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying user: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT channel_name, contact_value, verified FROM user_contact_info WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying contact info: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var contact models.ContactInfo
+		if err := rows.Scan(&contact.Channel, &contact.Value, &contact.Verified); err != nil {
+			return nil, fmt.Errorf("error scanning contact info: %w", err)
+		}
+		export.Contacts = append(export.Contacts, contact)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying contact info: %w", err)
+	}
+
+	export.Subscriptions, err = s.subscriptions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the export before reading the trail so it includes this request
+	if err := s.recordAudit(ctx, s.db, userID, "export", requestedBy); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx,
+		"SELECT action, requested_by, requested_at FROM user_data_audit WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying audit trail: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record models.AuditRecord
+		if err := rows.Scan(&record.Action, &record.RequestedBy, &record.RequestedAt); err != nil {
+			return nil, fmt.Errorf("error scanning audit trail: %w", err)
+		}
+		export.AuditTrail = append(export.AuditTrail, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying audit trail: %w", err)
+	}
+
+	export.ExportedAt = time.Now().UTC()
+	return export, nil
+}
+
+// Executes statements either directly or inside a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Writes a data lifecycle audit record
+func (s *SQLStore) recordAudit(ctx context.Context, db execer, userID, action, requestedBy string) error {
+	if _, err := db.ExecContext(ctx,
+		"INSERT INTO user_data_audit (user_id, action, requested_by) VALUES (?, ?, ?)",
+		userID, action, requestedBy); err != nil {
+		return fmt.Errorf("error recording audit record: %w", err)
+	}
+	return nil
+}
+
+// Closes the database connection
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// Applies the per-call query timeout to a context
+func (s *SQLStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout > 0 {
+		return context.WithTimeout(ctx, s.queryTimeout)
+	}
+	return context.WithCancel(ctx)
+}
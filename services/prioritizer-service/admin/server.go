@@ -0,0 +1,203 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/degraded"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/storm"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminaudit"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminauth"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/profiling"
+)
+
+// Admin HTTP server exposing operational endpoints
+type Server struct {
+	server      *http.Server
+	diagnostics func() any
+	storms      *storm.Detector
+	stages      *degraded.Switches
+	started     time.Time
+}
+
+// Config for the admin server
+type Config struct {
+	Port            int
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	Diagnostics     func() any         // Service specific state included in /debug/runtime, optional
+	Storms          *storm.Detector    // Storm detector whose storms can be listed and released, optional
+	Stages          *degraded.Switches // Stages that can be skipped in degraded mode, optional
+	WASMRules       http.Handler       // Serves the tenants' rule modules, optional
+	ExpressionRules http.Handler       // Serves the expression rules, optional
+	Audit           adminaudit.Auditor // Records every request that may change state, optional
+	Operators       map[string]string  // Bearer token of each operator allowed to change state, by name; empty leaves changes open
+}
+
+// Creates a new admin HTTP server
+func NewServer(cfg Config) *Server {
+	mux := http.NewServeMux()
+
+	server := Server{
+		server: &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.Port),
+			Handler:      mux,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		},
+		diagnostics: cfg.Diagnostics,
+		storms:      cfg.Storms,
+		stages:      cfg.Stages,
+		started:     time.Now(),
+	}
+
+	// Routes
+	mux.HandleFunc("/health", server.handleHealth)
+	mux.HandleFunc("GET /version", server.handleVersion)
+	mux.HandleFunc("/debug/runtime", server.handleRuntime)
+	if server.storms != nil {
+		mux.HandleFunc("GET /storms", server.handleListStorms)
+		mux.HandleFunc("POST /storms/{eventType}/release", server.handleReleaseStorm)
+	}
+	if server.stages != nil {
+		mux.HandleFunc("GET /degraded", server.handleDegraded)
+		mux.HandleFunc("POST /degraded/{stage}/skip", server.handleSkipStage(true))
+		mux.HandleFunc("POST /degraded/{stage}/restore", server.handleSkipStage(false))
+	}
+	if cfg.WASMRules != nil {
+		mux.Handle("/wasm-rules", cfg.WASMRules)
+		mux.Handle("/wasm-rules/", cfg.WASMRules)
+	}
+	if cfg.ExpressionRules != nil {
+		mux.Handle("/expression-rules", cfg.ExpressionRules)
+	}
+
+	// Profiling
+	profiling.Register(mux)
+
+	if cfg.Audit != nil {
+		server.server.Handler = adminaudit.Audited(mux, cfg.Audit)
+	}
+	server.server.Handler = adminauth.Authenticated(server.server.Handler, cfg.Operators)
+	return &server
+}
+
+// Starts the admin HTTP server
+func (s *Server) Start() error {
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Gracefully shuts down the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Handles health check requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+		"time":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// Handles requests for the version of the running code
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
+
+// Handles requests for runtime statistics
+func (s *Server) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := map[string]any{
+		"uptime_seconds":   time.Since(s.started).Seconds(),
+		"goroutines":       runtime.NumGoroutine(),
+		"gomaxprocs":       runtime.GOMAXPROCS(0),
+		"heap_alloc_bytes": mem.HeapAlloc,
+		"heap_objects":     mem.HeapObjects,
+		"num_gc":           mem.NumGC,
+		"gc_pause_total_s": time.Duration(mem.PauseTotalNs).Seconds(),
+	}
+	stats["build"] = buildinfo.Get()
+	if s.diagnostics != nil {
+		stats["service"] = s.diagnostics()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Handles requests for the detected event storms
+func (s *Server) handleListStorms(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"storms": s.storms.Storms(),
+	})
+}
+
+// Handles requests to resume an event type paused by a storm
+func (s *Server) handleReleaseStorm(w http.ResponseWriter, r *http.Request) {
+	eventType := r.PathValue("eventType")
+	found, err := s.storms.Release(eventType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("no storm for event type %s", eventType), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"event_type": eventType,
+		"status":     "released",
+	})
+}
+
+// Handles requests for the stages skipped in degraded mode
+func (s *Server) handleDegraded(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"stages": s.stages.Status(),
+	})
+}
+
+// Returns the handler of requests to skip or restore a stage, audited under
+// the operator who sent it
+func (s *Server) handleSkipStage(skipped bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+			http.Error(w, "a reason must be given", http.StatusBadRequest)
+			return
+		}
+		by := adminauth.Actor(r)
+
+		stage := r.PathValue("stage")
+		set := s.stages.Restore
+		if skipped {
+			set = s.stages.Skip
+		}
+		if err := set(stage, by, req.Reason); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.handleDegraded(w, r)
+	}
+}
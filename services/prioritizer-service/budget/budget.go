@@ -0,0 +1,107 @@
+// Package budget gives notifications a deadline for reaching the delivery
+// topic, from the processing budget of their priority, e.g. 5s for high. The
+// deadline travels with the notification so every stage can tell how much
+// of it is left, skip optional steps when little is and count the
+// notifications that miss it.
+package budget
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Budgets hold the processing budget of each priority
+type Budgets struct {
+	budgets map[string]time.Duration // By priority, priorities left out have no deadline
+	tight   time.Duration            // Optional steps are skipped once less than this is left
+
+	mu       sync.Mutex
+	exceeded map[string]*atomic.Int64 // Notifications that missed their deadline by priority
+	skipped  map[string]*atomic.Int64 // Notifications that skipped an optional step by step
+}
+
+// Status of the budgets since start
+type Status struct {
+	Budgets  map[string]string `json:"budgets"`
+	Exceeded map[string]int64  `json:"exceeded"` // By priority
+	Skipped  map[string]int64  `json:"skipped"`  // By optional step
+}
+
+// New creates the budgets, nil when no priority has one
+func New(budgets map[string]time.Duration, tight time.Duration) *Budgets {
+	if len(budgets) == 0 {
+		return nil
+	}
+	return &Budgets{
+		budgets:  budgets,
+		tight:    tight,
+		exceeded: make(map[string]*atomic.Int64),
+		skipped:  make(map[string]*atomic.Int64),
+	}
+}
+
+// Deadline returns when a notification of the priority enqueued at start
+// must reach the delivery topic, false when the priority has no budget
+func (b *Budgets) Deadline(priority string, start time.Time) (time.Time, bool) {
+	budget, ok := b.budgets[priority]
+	if !ok {
+		return time.Time{}, false
+	}
+	return start.Add(budget), true
+}
+
+// Tight reports whether so little is left until the deadline that optional
+// steps are skipped, counting the notification that skips the step
+func (b *Budgets) Tight(deadline time.Time, step string) bool {
+	if time.Until(deadline) >= b.tight {
+		return false
+	}
+	b.counter(b.skipped, step).Add(1)
+	return true
+}
+
+// Exceeded reports whether the deadline passed, counting the notification
+// that missed it
+func (b *Budgets) Exceeded(priority string, deadline time.Time) bool {
+	if time.Now().Before(deadline) {
+		return false
+	}
+	b.counter(b.exceeded, priority).Add(1)
+	return true
+}
+
+// Status returns the budgets and the notifications that missed them
+func (b *Budgets) Status() Status {
+	status := Status{
+		Budgets:  make(map[string]string, len(b.budgets)),
+		Exceeded: make(map[string]int64),
+		Skipped:  make(map[string]int64),
+	}
+	for priority, budget := range b.budgets {
+		status.Budgets[priority] = budget.String()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for priority, count := range b.exceeded {
+		status.Exceeded[priority] = count.Load()
+	}
+	for step, count := range b.skipped {
+		status.Skipped[step] = count.Load()
+	}
+	return status
+}
+
+// counter returns the counter of a key, creating it on first use
+func (b *Budgets) counter(counters map[string]*atomic.Int64, key string) *atomic.Int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count, ok := counters[key]
+	if !ok {
+		count = &atomic.Int64{}
+		counters[key] = count
+	}
+	return count
+}
@@ -1,7 +1,20 @@
 package config
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/envconfig"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/exprrules"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/secrets"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/wasmrules"
 )
 
 // Holds HTTP server configuration
@@ -10,36 +23,291 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
+	Operators    map[string]string // Bearer token of each operator allowed to change state, by name; empty leaves changes open
 }
 
 // Holds Kafka consumer configuration
 type KafkaConsumerConfig struct {
-	Brokers         []string
-	Topic           string
-	GroupID         string
-	SessionTimeout  time.Duration
-	HeartbeatInterval time.Duration
+	Brokers                  []string
+	Topic                    string
+	GroupID                  string
+	GroupVersion             string        // Appended to GroupID, bumped for deployments that must not share offsets
+	HandoverFrom             string        // Previous group whose offsets the group takes over on first start
+	HandoverTimeout          time.Duration // How long to wait for the previous group to drain
+	SessionTimeout           time.Duration
+	HeartbeatInterval        time.Duration
+	Filter                   HeaderFilterConfig
+	RetryMax                 int            // Retries of a message failing transiently before it is dead-lettered
+	RetryBackoff             time.Duration  // Wait before the first retry, doubled for every further one
+	DeadLetterTopic          string         // Topic failed messages are parked on, empty drops them
+	MetadataPassthroughBytes int            // Metadata values longer than this are passed through undecoded, zero decodes all
+	RawPassthrough           bool           // Decode only what prioritization reads and send the consumed bytes on unchanged
+	Signing                  signing.Config // Keys the signatures of consumed messages are verified with
+	FromOldest               bool           // A new group starts at the beginning of the topic rather than its end
+}
+
+// Holds the filter a consumer applies to message headers before unmarshalling,
+// messages outside a non-empty list are skipped
+type HeaderFilterConfig struct {
+	EventTypes []string
+	Tenants    []string
 }
 
 // Holds Kafka producer configuration
 type KafkaProducerConfig struct {
-	Brokers          []string
-	TopicHigh        string
-	TopicMedium      string
-	TopicLow         string
-	RetryMax         int
-	RequiredAcks     int
-	DeliveryReport   bool
-	Partitions       int
+	Brokers           []string
+	RetryMax          int
+	RequiredAcks      int
+	DeliveryReport    bool
+	Partitions        int
 	ReplicationFactor int
+	SendTimeout       time.Duration
+	Signing           signing.Config // Keys produced messages are signed with
+}
+
+// Producer returns the settings the producers of the service share
+func (c KafkaProducerConfig) Producer() kafkaproducer.Config {
+	return kafkaproducer.Config{
+		Brokers:           c.Brokers,
+		RequiredAcks:      c.RequiredAcks,
+		RetryMax:          c.RetryMax,
+		Partitions:        c.Partitions,
+		ReplicationFactor: c.ReplicationFactor,
+		Signing:           c.Signing,
+	}
+}
+
+// Holds the standby Kafka clusters the service fails over to when its
+// cluster is lost. The service consumes and produces on one cluster at a
+// time, the first reachable of the configured brokers and the standbys.
+type KafkaFailoverConfig struct {
+	Clusters      []string      // Standby clusters in order of preference, comma separated brokers each
+	Rewind        time.Duration // How long before the failover a group without offsets on the new cluster starts, zero starts it as a new group
+	CheckInterval time.Duration // How often the active cluster is probed
+	After         time.Duration // How long the active cluster must be unreachable before failing over
+}
+
+// BrokerLists returns the clusters the service may run on, most preferred first
+func (c KafkaFailoverConfig) BrokerLists(brokers []string) [][]string {
+	clusters := [][]string{brokers}
+	for _, cluster := range c.Clusters {
+		clusters = append(clusters, strings.Split(cluster, ","))
+	}
+	return clusters
+}
+
+// Holds a priority level and its topic
+type PriorityLevelConfig struct {
+	Name  string // Priority name assigned to notifications (e.g. "critical")
+	Topic string // Kafka topic notifications of this priority are produced to
+}
+
+// Routes matching notifications to a dedicated copy of their priority topic,
+// e.g. notifications.priority.high.tenantX, so noisy tenants can get their own
+// consumer capacity
+type RoutingRule struct {
+	EventTypes  []string          `json:"event_types,omitempty"` // Matched event types, empty matches all
+	Metadata    map[string]string `json:"metadata,omitempty"`    // Metadata values that must all match
+	TopicSuffix string            `json:"topic_suffix"`          // Appended to the priority topic
+}
+
+// Selects a prioritization strategy registered with the prioritizers package
+type StrategyConfig struct {
+	Name    string          `json:"name"`
+	Options json.RawMessage `json:"options,omitempty"` // Passed to the strategy's factory
+}
+
+// Holds the sandbox tenants' WebAssembly rule modules run in. Instances
+// sharing Dir pick up each other's uploads every ReloadInterval.
+type WASMRulesConfig struct {
+	Enabled        bool
+	Dir            string        // Holds a module per tenant
+	MemoryLimit    int           // Megabytes of memory a module may grow to
+	Timeout        time.Duration // Longest an evaluation may run
+	MaxModuleBytes int           // Largest module that can be uploaded
+	ReloadInterval time.Duration
+}
+
+// CreateWASMRules creates the engine running the tenants' rule modules, nil
+// when they are disabled
+func (c WASMRulesConfig) CreateWASMRules(ctx context.Context) (*wasmrules.Engine, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	return wasmrules.New(ctx, wasmrules.Config{
+		Dir:            c.Dir,
+		MemoryLimit:    c.MemoryLimit,
+		Timeout:        c.Timeout,
+		MaxModuleBytes: c.MaxModuleBytes,
+	})
+}
+
+// Holds the expression rules changed through the admin API. Instances
+// sharing File pick up each other's changes every ReloadInterval.
+type ExpressionRulesConfig struct {
+	File           string // Holds the rules, empty disables them
+	ReloadInterval time.Duration
+}
+
+// CreateExpressionRules creates the engine evaluating the expression rules,
+// nil when they are disabled
+func (c ExpressionRulesConfig) CreateExpressionRules() (*exprrules.Engine, error) {
+	if c.File == "" {
+		return nil, nil
+	}
+	return exprrules.New(c.File)
+}
+
+// Holds how long notifications of each priority may take from enqueue to
+// the delivery topic. The deadline travels in the X-Deadline header.
+type BudgetConfig struct {
+	Budgets map[string]time.Duration // By priority, priorities left out have no deadline
+	Tight   time.Duration            // Optional steps such as enrichment are skipped once less is left
+}
+
+// Gives tenants their own copy of every priority topic, e.g.
+// notifications.priority.high.tenantX, so a noisy tenant only delays itself
+// and each tenant's rate limiters can be scaled on their own
+type TenantTopicsConfig struct {
+	Enabled bool
+	Tenants []string // Isolated tenants, empty isolates every tenant
+}
+
+// Holds canary configuration. Primaries mirror SamplePercent of their traffic
+// to Topic; an instance with Enabled set consumes it as the canary.
+type CanaryConfig struct {
+	Enabled       bool   // Run as the canary instead of the primary
+	SamplePercent int    // Share of traffic primaries mirror, zero disables mirroring
+	Topic         string // Topic mirrored notifications are sent to
+	GroupID       string // Consumer group of the canary
+}
+
+// Holds enrichment configuration
+type EnrichmentConfig struct {
+	Sources   []EnrichmentSource
+	Timeout   time.Duration // Bound on every lookup
+	CacheTTL  time.Duration // How long looked up values are reused, zero disables caching
+	CacheSize int
+}
+
+// Holds the stages skipped in degraded mode from startup, the admin API
+// skips and restores them at runtime
+type DegradedConfig struct {
+	SkipStages []string // degraded.StageEnrichment
+}
+
+// Holds an HTTP enrichment source
+type EnrichmentSource struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`    // Metadata field holding the lookup key
+	Target string `json:"target"` // Metadata field the value is stored in
+	URL    string `json:"url"`    // Fetched with GET, {key} is replaced by the lookup key
+}
+
+// Holds configuration of the check that notifications are for existing users
+type UserCheckConfig struct {
+	URL       string // Base URL of the preferences service, empty disables the check
+	Action    string // What happens to notifications for unknown users: "dead_letter" or "drop"
+	Timeout   time.Duration
+	CacheTTL  time.Duration // How long answers are reused
+	CacheSize int
+}
+
+// Holds configuration of the expansion of events about an entity to its subscribers
+type SubscriptionsConfig struct {
+	URL           string        // Base URL of the preferences service, empty disables expansion
+	Timeout       time.Duration // Bound on every page lookup
+	PageSize      int           // Subscribers read per lookup
+	MaxRecipients int           // Subscribers beyond this many are left out, zero expands to all
+	FanoutTopic   string        // Events to expand are handed off to this topic, empty expands them in the consume loop
+	FanoutGroupID string        // Consumer group expanding the fan-out topic
+	FanoutRate    int           // Copies produced per second across all events expanded at once, zero doesn't pace them
+}
+
+// Actions for notifications of unknown users
+const (
+	UserCheckDeadLetter = "dead_letter"
+	UserCheckDrop       = "drop"
+)
+
+// Holds the bounds of notification timestamps
+type TimestampConfig struct {
+	ClockSkew time.Duration // Timestamps up to this far in the future are clamped to now, later ones rejected
+	MaxAge    time.Duration // Older notifications are rejected, zero accepts any age
+}
+
+// Holds event-type registry configuration
+type EventRegistryConfig struct {
+	File          string   // Optional JSON registry file shared with the rate limiter, read for metadata schemas
+	EncryptedKeys []string // Metadata entries the enqueue service encrypts, which schemas can't constrain
+}
+
+// Holds event storm detection configuration
+type StormConfig struct {
+	Window         time.Duration // Window distinct users are counted in
+	Threshold      int           // Distinct users of the same event within the window, zero disables detection
+	MetadataKeys   []string      // Metadata fields that, with the event type, identify the same event
+	AutoPause      bool          // Hold an event type's notifications once it storms
+	HoldTopic      string        // Topic held notifications are parked on
+	ControlTopic   string        // Compacted topic instances share pauses and releases on
+	ReplayGroupID  string        // Consumer group replaying held notifications once released
+	ReleaseFor     time.Duration // How long a released event type is not paused again
+	WebhookURL     string        // Optional endpoint notified of every storm
+	WebhookTimeout time.Duration
 }
 
 // Holds all configuration for the service
 type Config struct {
-	Server          ServerConfig
-	KafkaConsumer   KafkaConsumerConfig
-	KafkaProducer   KafkaProducerConfig
-	ShutdownTimeout time.Duration
+	Server             ServerConfig
+	KafkaConsumer      KafkaConsumerConfig
+	KafkaProducer      KafkaProducerConfig
+	KafkaFailover      KafkaFailoverConfig
+	Priorities         []PriorityLevelConfig // Ordered from most to least urgent
+	EventPriorities    map[string]string     // Event type to priority overrides
+	PriorityHintMax    string                // Most urgent level producers' priority hints may ask for, empty ignores hints
+	PriorityStrategies []StrategyConfig      // Registered prioritization strategies, consulted in order before EventPriorities
+	WASMRules          WASMRulesConfig       // Tenants' rule modules, consulted before PriorityStrategies
+	ExpressionRules    ExpressionRulesConfig // Consulted after WASMRules, before PriorityStrategies
+	Budget             BudgetConfig
+	RoutingRules       []RoutingRule      // Checked in order, the first match decides the topic
+	TenantTopics       TenantTopicsConfig // Applies to notifications no routing rule matched
+	Canary             CanaryConfig
+	Storm              StormConfig
+	EventRegistry      EventRegistryConfig
+	Enrichment         EnrichmentConfig
+	Degraded           DegradedConfig
+	UserCheck          UserCheckConfig
+	Subscriptions      SubscriptionsConfig
+	Timestamps         TimestampConfig
+	DebugTopic         string // Topic traces of notifications sampled for debugging are sent to
+	AuditTopic         string // Topic admin actions are sent to for the rate limiter's audit trail, empty only logs them
+	Startup            StartupConfig
+	Heartbeat          HeartbeatConfig
+	Shutdown           ShutdownConfig
+	Secrets            secrets.Config // Only read at startup, nothing here uses a secret per connection
+}
+
+// Holds how long the service waits for Kafka at startup
+type StartupConfig struct {
+	Preflight       bool          // Check the dependencies before creating any client
+	RetryTimeout    time.Duration // Give up after this long, zero tries once
+	RetryBackoff    time.Duration // Wait after the first failed attempt, doubled after every further one
+	RetryMaxBackoff time.Duration
+}
+
+// Holds where and how often the instance announces it is alive
+type HeartbeatConfig struct {
+	Topic      string        // Internal ops topic heartbeats are published to
+	Interval   time.Duration // Zero disables heartbeats
+	InstanceID string        // Defaults to the host name, the container ID under Docker
+}
+
+// Holds the timeouts of the shutdown stages, run in this order
+type ShutdownConfig struct {
+	Intake time.Duration // Stop taking new work
+	Drain  time.Duration // Finish the work already taken
+	Flush  time.Duration // Flush and close the producers
+	Close  time.Duration // Close the remaining clients
 }
 
 // Provides default configuration values
@@ -51,54 +319,406 @@ var DefaultConfig = Config{
 		IdleTimeout:  60 * time.Second,
 	},
 	KafkaConsumer: KafkaConsumerConfig{
-		Brokers:          []string{"localhost:9092"},
-		Topic:            "notifications.raw",
-		GroupID:          "prioritizer-group",
-		SessionTimeout:   30 * time.Second,
+		Brokers:           []string{"localhost:9092"},
+		Topic:             "notifications.raw",
+		GroupID:           "prioritizer-group",
+		HandoverTimeout:   5 * time.Minute,
+		RetryMax:          3,
+		RetryBackoff:      500 * time.Millisecond,
+		DeadLetterTopic:   "notifications.raw.dlq",
+		SessionTimeout:    30 * time.Second,
 		HeartbeatInterval: 10 * time.Second,
 	},
 	KafkaProducer: KafkaProducerConfig{
-		Brokers:          []string{"localhost:9092"},
-		TopicHigh:        "notifications.priority.high",
-		TopicMedium:      "notifications.priority.medium",
-		TopicLow:         "notifications.priority.low",
-		RetryMax:         3,
-		RequiredAcks:     1,
-		DeliveryReport:   true,
-		Partitions:       3,
+		Brokers:           []string{"localhost:9092"},
+		RetryMax:          3,
+		RequiredAcks:      1,
+		DeliveryReport:    true,
+		Partitions:        3,
 		ReplicationFactor: 2,
+		SendTimeout:       5 * time.Second,
+	},
+	KafkaFailover: KafkaFailoverConfig{
+		Rewind:        5 * time.Minute,
+		CheckInterval: 10 * time.Second,
+		After:         time.Minute,
+	},
+	Priorities: []PriorityLevelConfig{
+		{Name: "critical", Topic: "notifications.priority.critical"},
+		{Name: "high", Topic: "notifications.priority.high"},
+		{Name: "medium", Topic: "notifications.priority.medium"},
+		{Name: "low", Topic: "notifications.priority.low"},
+	},
+	Canary: CanaryConfig{
+		Topic:   "notifications.raw.canary",
+		GroupID: "prioritizer-group-canary",
+	},
+	Storm: StormConfig{
+		Window:         time.Minute,
+		Threshold:      0, // Detection is off until a threshold fitting the traffic is configured
+		HoldTopic:      "notifications.raw.held",
+		ControlTopic:   "notifications.storms",
+		ReplayGroupID:  "prioritizer-group-held",
+		ReleaseFor:     time.Hour,
+		WebhookTimeout: 5 * time.Second,
+	},
+	Enrichment: EnrichmentConfig{
+		Timeout:   500 * time.Millisecond,
+		CacheTTL:  5 * time.Minute,
+		CacheSize: 10000,
+	},
+	UserCheck: UserCheckConfig{
+		Action:    UserCheckDeadLetter,
+		Timeout:   time.Second,
+		CacheTTL:  5 * time.Minute,
+		CacheSize: 100000,
+	},
+	Subscriptions: SubscriptionsConfig{
+		Timeout:       2 * time.Second,
+		PageSize:      500,
+		MaxRecipients: 100000,
+		FanoutTopic:   "notifications.fanout",
+		FanoutGroupID: "prioritizer-group-fanout",
+		FanoutRate:    500,
+	},
+	Timestamps: TimestampConfig{
+		ClockSkew: 30 * time.Second,
+	},
+	WASMRules: WASMRulesConfig{
+		Dir:            "wasm-rules",
+		MemoryLimit:    16,
+		Timeout:        50 * time.Millisecond,
+		MaxModuleBytes: 4 << 20,
+		ReloadInterval: 30 * time.Second,
+	},
+	ExpressionRules: ExpressionRulesConfig{
+		ReloadInterval: 30 * time.Second,
+	},
+	Budget: BudgetConfig{
+		Tight: time.Second,
+	},
+	DebugTopic: "notifications.debug",
+	AuditTopic: "notifications.audit",
+	Startup: StartupConfig{
+		Preflight:       true,
+		RetryTimeout:    2 * time.Minute,
+		RetryBackoff:    time.Second,
+		RetryMaxBackoff: 15 * time.Second,
+	},
+	Heartbeat: HeartbeatConfig{
+		Topic:    "notifications.ops",
+		Interval: 10 * time.Second,
+	},
+	Shutdown: ShutdownConfig{
+		Intake: 5 * time.Second,
+		Drain:  10 * time.Second,
+		Flush:  5 * time.Second,
+		Close:  5 * time.Second,
 	},
-	ShutdownTimeout: 10 * time.Second,
 }
 
 // Loads configuration from environment variables
 func Load() (*Config, error) {
 	cfg := DefaultConfig
 
+	// Load secrets config first, any secret below may refer to a provider
+	envconfig.LoadStringEnv("VAULT_ADDR", &cfg.Secrets.Vault.Addr)
+	envconfig.LoadStringEnv("VAULT_TOKEN", &cfg.Secrets.Vault.Token)
+	envconfig.LoadStringEnv("VAULT_NAMESPACE", &cfg.Secrets.Vault.Namespace)
+	envconfig.LoadStringEnv("AWS_REGION", &cfg.Secrets.SSM.Region)
+	envconfig.LoadStringEnv("AWS_ACCESS_KEY_ID", &cfg.Secrets.SSM.AccessKeyID)
+	envconfig.LoadStringEnv("AWS_SECRET_ACCESS_KEY", &cfg.Secrets.SSM.SecretAccessKey)
+	envconfig.LoadStringEnv("AWS_SESSION_TOKEN", &cfg.Secrets.SSM.SessionToken)
+	envconfig.LoadStringEnv("SSM_ENDPOINT", &cfg.Secrets.SSM.Endpoint)
+	store := secrets.New(cfg.Secrets)
+
 	// Load server config
-	LoadIntEnv("SERVER_PORT", &cfg.Server.Port)
-	LoadDurationEnv("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
-	LoadDurationEnv("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
-	LoadDurationEnv("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
-	
+	envconfig.LoadIntEnv("SERVER_PORT", &cfg.Server.Port)
+	envconfig.LoadDurationEnv("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	envconfig.LoadDurationEnv("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	envconfig.LoadDurationEnv("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "ADMIN_OPERATORS", &cfg.Server.Operators); err != nil {
+		return nil, err
+	}
+
 	// Load Kafka consumer config
-	LoadJSONStringArrayEnv("KAFKA_CONSUMER_BROKERS", &cfg.KafkaConsumer.Brokers)
-	LoadStringEnv("KAFKA_CONSUMER_TOPIC", &cfg.KafkaConsumer.Topic)
-	LoadStringEnv("KAFKA_CONSUMER_GROUP_ID", &cfg.KafkaConsumer.GroupID)
-	LoadDurationEnv("KAFKA_CONSUMER_SESSION_TIMEOUT", &cfg.KafkaConsumer.SessionTimeout)
-	LoadDurationEnv("KAFKA_CONSUMER_HEARTBEAT_INTERVAL", &cfg.KafkaConsumer.HeartbeatInterval)
-	
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_BROKERS", &cfg.KafkaConsumer.Brokers)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_TOPIC", &cfg.KafkaConsumer.Topic)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_GROUP_ID", &cfg.KafkaConsumer.GroupID)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_GROUP_VERSION", &cfg.KafkaConsumer.GroupVersion)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_HANDOVER_FROM", &cfg.KafkaConsumer.HandoverFrom)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_HANDOVER_TIMEOUT", &cfg.KafkaConsumer.HandoverTimeout)
+	if cfg.KafkaConsumer.GroupVersion != "" {
+		cfg.KafkaConsumer.GroupID += "-" + cfg.KafkaConsumer.GroupVersion
+	}
+	if cfg.KafkaConsumer.HandoverFrom == cfg.KafkaConsumer.GroupID {
+		return nil, fmt.Errorf("KAFKA_CONSUMER_HANDOVER_FROM must differ from the consumer group %s", cfg.KafkaConsumer.GroupID)
+	}
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_SESSION_TIMEOUT", &cfg.KafkaConsumer.SessionTimeout)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_HEARTBEAT_INTERVAL", &cfg.KafkaConsumer.HeartbeatInterval)
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_FILTER_EVENT_TYPES", &cfg.KafkaConsumer.Filter.EventTypes)
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_FILTER_TENANTS", &cfg.KafkaConsumer.Filter.Tenants)
+	envconfig.LoadIntEnv("KAFKA_CONSUMER_RETRY_MAX", &cfg.KafkaConsumer.RetryMax)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_RETRY_BACKOFF", &cfg.KafkaConsumer.RetryBackoff)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_DEAD_LETTER_TOPIC", &cfg.KafkaConsumer.DeadLetterTopic)
+	envconfig.LoadIntEnv("KAFKA_CONSUMER_METADATA_PASSTHROUGH_BYTES", &cfg.KafkaConsumer.MetadataPassthroughBytes)
+	envconfig.LoadBoolEnv("KAFKA_CONSUMER_RAW_PASSTHROUGH", &cfg.KafkaConsumer.RawPassthrough)
+
 	// Load Kafka producer config
-	LoadJSONStringArrayEnv("KAFKA_PRODUCER_BROKERS", &cfg.KafkaProducer.Brokers)
-	LoadStringEnv("KAFKA_PRODUCER_TOPIC_HIGH", &cfg.KafkaProducer.TopicHigh)
-	LoadStringEnv("KAFKA_PRODUCER_TOPIC_MEDIUM", &cfg.KafkaProducer.TopicMedium)
-	LoadStringEnv("KAFKA_PRODUCER_TOPIC_LOW", &cfg.KafkaProducer.TopicLow)
-	LoadIntEnv("KAFKA_PRODUCER_RETRY_MAX", &cfg.KafkaProducer.RetryMax)
-	LoadIntEnv("KAFKA_PRODUCER_REQUIRED_ACKS", &cfg.KafkaProducer.RequiredAcks)
-	LoadBoolEnv("KAFKA_PRODUCER_DELIVERY_REPORT", &cfg.KafkaProducer.DeliveryReport)
-	
+	envconfig.LoadJSONStringArrayEnv("KAFKA_PRODUCER_BROKERS", &cfg.KafkaProducer.Brokers)
+	envconfig.LoadIntEnv("KAFKA_PRODUCER_RETRY_MAX", &cfg.KafkaProducer.RetryMax)
+	envconfig.LoadIntEnv("KAFKA_PRODUCER_REQUIRED_ACKS", &cfg.KafkaProducer.RequiredAcks)
+	envconfig.LoadBoolEnv("KAFKA_PRODUCER_DELIVERY_REPORT", &cfg.KafkaProducer.DeliveryReport)
+	envconfig.LoadDurationEnv("KAFKA_PRODUCER_SEND_TIMEOUT", &cfg.KafkaProducer.SendTimeout)
+
+	// Load Kafka message signing config, shared by the consumer and producers
+	signingCfg := signing.Config{MaxAge: 24 * time.Hour}
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "KAFKA_SIGNING_KEYS", &signingCfg.Keys); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("KAFKA_SIGNING_ACTIVE_KEY_ID", &signingCfg.ActiveKeyID)
+	envconfig.LoadBoolEnv("KAFKA_SIGNING_REQUIRED", &signingCfg.Required)
+	envconfig.LoadDurationEnv("KAFKA_SIGNING_MAX_AGE", &signingCfg.MaxAge)
+	if _, err := signingCfg.CreateKeyring(); err != nil {
+		return nil, fmt.Errorf("KAFKA_SIGNING_KEYS are invalid: %w", err)
+	}
+	if signingCfg.Required && len(signingCfg.Keys) == 0 {
+		return nil, fmt.Errorf("KAFKA_SIGNING_REQUIRED needs KAFKA_SIGNING_KEYS")
+	}
+	cfg.KafkaConsumer.Signing = signingCfg
+	cfg.KafkaProducer.Signing = signingCfg
+
+	// Load Kafka failover config
+	envconfig.LoadJSONStringArrayEnv("KAFKA_FAILOVER_CLUSTERS", &cfg.KafkaFailover.Clusters)
+	envconfig.LoadDurationEnv("KAFKA_FAILOVER_REWIND", &cfg.KafkaFailover.Rewind)
+	envconfig.LoadDurationEnv("KAFKA_FAILOVER_CHECK_INTERVAL", &cfg.KafkaFailover.CheckInterval)
+	envconfig.LoadDurationEnv("KAFKA_FAILOVER_AFTER", &cfg.KafkaFailover.After)
+	if len(cfg.KafkaFailover.Clusters) > 0 && (cfg.KafkaFailover.CheckInterval <= 0 || cfg.KafkaFailover.After <= 0) {
+		return nil, fmt.Errorf("KAFKA_FAILOVER_CHECK_INTERVAL and KAFKA_FAILOVER_AFTER must be positive")
+	}
+
+	// Load canary config
+	envconfig.LoadBoolEnv("CANARY_ENABLED", &cfg.Canary.Enabled)
+	envconfig.LoadIntEnv("CANARY_SAMPLE_PERCENT", &cfg.Canary.SamplePercent)
+	envconfig.LoadStringEnv("CANARY_TOPIC", &cfg.Canary.Topic)
+	envconfig.LoadStringEnv("CANARY_GROUP_ID", &cfg.Canary.GroupID)
+	if cfg.Canary.SamplePercent < 0 || cfg.Canary.SamplePercent > 100 {
+		return nil, fmt.Errorf("CANARY_SAMPLE_PERCENT must be between 0 and 100")
+	}
+
+	// Load storm detection config
+	envconfig.LoadDurationEnv("STORM_WINDOW", &cfg.Storm.Window)
+	envconfig.LoadIntEnv("STORM_THRESHOLD", &cfg.Storm.Threshold)
+	envconfig.LoadJSONStringArrayEnv("STORM_METADATA_KEYS", &cfg.Storm.MetadataKeys)
+	envconfig.LoadBoolEnv("STORM_AUTO_PAUSE", &cfg.Storm.AutoPause)
+	envconfig.LoadStringEnv("STORM_HOLD_TOPIC", &cfg.Storm.HoldTopic)
+	envconfig.LoadStringEnv("STORM_CONTROL_TOPIC", &cfg.Storm.ControlTopic)
+	envconfig.LoadStringEnv("STORM_REPLAY_GROUP_ID", &cfg.Storm.ReplayGroupID)
+	envconfig.LoadDurationEnv("STORM_RELEASE_FOR", &cfg.Storm.ReleaseFor)
+	envconfig.LoadStringEnv("STORM_WEBHOOK_URL", &cfg.Storm.WebhookURL)
+	envconfig.LoadDurationEnv("STORM_WEBHOOK_TIMEOUT", &cfg.Storm.WebhookTimeout)
+	if cfg.Storm.Threshold > 0 && cfg.Storm.Window <= 0 {
+		return nil, fmt.Errorf("STORM_WINDOW must be positive")
+	}
+
+	// The canary reads the mirrored copies in its own consumer group and
+	// leaves storm handling to the primary
+	if cfg.Canary.Enabled {
+		cfg.KafkaConsumer.Topic = cfg.Canary.Topic
+		cfg.KafkaConsumer.GroupID = cfg.Canary.GroupID
+		cfg.KafkaConsumer.HandoverFrom = ""
+		cfg.KafkaConsumer.DeadLetterTopic = ""
+		cfg.Storm.Threshold = 0
+	}
+
+	// Load event registry config
+	envconfig.LoadStringEnv("EVENT_REGISTRY_FILE", &cfg.EventRegistry.File)
+	envconfig.LoadJSONStringArrayEnv("ENCRYPTION_METADATA_KEYS", &cfg.EventRegistry.EncryptedKeys)
+
+	// Load heartbeat config
+	envconfig.LoadStringEnv("OPS_TOPIC", &cfg.Heartbeat.Topic)
+	envconfig.LoadDurationEnv("HEARTBEAT_INTERVAL", &cfg.Heartbeat.Interval)
+	envconfig.LoadStringEnv("INSTANCE_ID", &cfg.Heartbeat.InstanceID)
+	if cfg.Heartbeat.InstanceID == "" {
+		cfg.Heartbeat.InstanceID, _ = os.Hostname()
+	}
+
 	// Load general config
-	LoadDurationEnv("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	envconfig.LoadStringEnv("DEBUG_TOPIC", &cfg.DebugTopic)
+	envconfig.LoadStringEnv("AUDIT_TOPIC", &cfg.AuditTopic)
+	envconfig.LoadBoolEnv("STARTUP_PREFLIGHT", &cfg.Startup.Preflight)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_TIMEOUT", &cfg.Startup.RetryTimeout)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_BACKOFF", &cfg.Startup.RetryBackoff)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_MAX_BACKOFF", &cfg.Startup.RetryMaxBackoff)
+	envconfig.LoadDurationEnv("SHUTDOWN_TIMEOUT", &cfg.Shutdown.Drain) // Legacy name of the drain timeout
+	envconfig.LoadDurationEnv("SHUTDOWN_INTAKE_TIMEOUT", &cfg.Shutdown.Intake)
+	envconfig.LoadDurationEnv("SHUTDOWN_DRAIN_TIMEOUT", &cfg.Shutdown.Drain)
+	envconfig.LoadDurationEnv("SHUTDOWN_FLUSH_TIMEOUT", &cfg.Shutdown.Flush)
+	envconfig.LoadDurationEnv("SHUTDOWN_CLOSE_TIMEOUT", &cfg.Shutdown.Close)
+
+	// Load priority levels and event type overrides
+	if err := loadPriorities(&cfg); err != nil {
+		return nil, err
+	}
+	envconfig.LoadJSONStringMapEnv("EVENT_PRIORITIES", &cfg.EventPriorities)
+	envconfig.LoadStringEnv("PRIORITY_HINT_MAX", &cfg.PriorityHintMax)
+	if cfg.PriorityHintMax != "" && !slices.ContainsFunc(cfg.Priorities, func(level PriorityLevelConfig) bool {
+		return level.Name == cfg.PriorityHintMax
+	}) {
+		return nil, fmt.Errorf("PRIORITY_HINT_MAX must be empty or one of PRIORITY_LEVELS")
+	}
+	if value := os.Getenv("PRIORITY_STRATEGIES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.PriorityStrategies); err != nil {
+			return nil, fmt.Errorf("invalid PRIORITY_STRATEGIES: %w", err)
+		}
+	}
+	for _, strategy := range cfg.PriorityStrategies {
+		if strategy.Name == "" {
+			return nil, fmt.Errorf("PRIORITY_STRATEGIES needs a name for every strategy")
+		}
+	}
+	envconfig.LoadBoolEnv("WASM_RULES_ENABLED", &cfg.WASMRules.Enabled)
+	envconfig.LoadStringEnv("WASM_RULES_DIR", &cfg.WASMRules.Dir)
+	envconfig.LoadIntEnv("WASM_RULES_MEMORY_LIMIT_MB", &cfg.WASMRules.MemoryLimit)
+	envconfig.LoadDurationEnv("WASM_RULES_TIMEOUT", &cfg.WASMRules.Timeout)
+	envconfig.LoadIntEnv("WASM_RULES_MAX_MODULE_BYTES", &cfg.WASMRules.MaxModuleBytes)
+	envconfig.LoadDurationEnv("WASM_RULES_RELOAD_INTERVAL", &cfg.WASMRules.ReloadInterval)
+	if cfg.WASMRules.Enabled && (cfg.WASMRules.MemoryLimit <= 0 || cfg.WASMRules.Timeout <= 0 ||
+		cfg.WASMRules.MaxModuleBytes <= 0 || cfg.WASMRules.ReloadInterval <= 0) {
+		return nil, fmt.Errorf("WASM_RULES_MEMORY_LIMIT_MB, WASM_RULES_TIMEOUT, WASM_RULES_MAX_MODULE_BYTES and WASM_RULES_RELOAD_INTERVAL must be positive")
+	}
+	envconfig.LoadStringEnv("EXPRESSION_RULES_FILE", &cfg.ExpressionRules.File)
+	envconfig.LoadDurationEnv("EXPRESSION_RULES_RELOAD_INTERVAL", &cfg.ExpressionRules.ReloadInterval)
+	if cfg.ExpressionRules.File != "" && cfg.ExpressionRules.ReloadInterval <= 0 {
+		return nil, fmt.Errorf("EXPRESSION_RULES_RELOAD_INTERVAL must be positive")
+	}
+
+	// Load processing budgets
+	var budgets map[string]string
+	envconfig.LoadJSONStringMapEnv("PROCESSING_BUDGETS", &budgets)
+	for priority, value := range budgets {
+		budget, err := time.ParseDuration(value)
+		if err != nil || budget <= 0 {
+			return nil, fmt.Errorf("PROCESSING_BUDGETS of %s must be a duration like 5s", priority)
+		}
+		if !slices.ContainsFunc(cfg.Priorities, func(level PriorityLevelConfig) bool { return level.Name == priority }) {
+			return nil, fmt.Errorf("PROCESSING_BUDGETS names %s, which is not one of PRIORITY_LEVELS", priority)
+		}
+		if cfg.Budget.Budgets == nil {
+			cfg.Budget.Budgets = make(map[string]time.Duration)
+		}
+		cfg.Budget.Budgets[priority] = budget
+	}
+	envconfig.LoadDurationEnv("PROCESSING_BUDGET_TIGHT", &cfg.Budget.Tight)
+
+	// Load routing rules
+	if value := os.Getenv("ROUTING_RULES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.RoutingRules); err != nil {
+			return nil, fmt.Errorf("invalid ROUTING_RULES: %w", err)
+		}
+	}
+	for i, rule := range cfg.RoutingRules {
+		if rule.TopicSuffix == "" {
+			return nil, fmt.Errorf("routing rule %d has no topic suffix", i)
+		}
+	}
+	envconfig.LoadBoolEnv("TENANT_TOPICS_ENABLED", &cfg.TenantTopics.Enabled)
+	envconfig.LoadJSONStringArrayEnv("TENANT_TOPICS_TENANTS", &cfg.TenantTopics.Tenants)
+
+	// Load enrichment config
+	if value := os.Getenv("ENRICHMENT_SOURCES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Enrichment.Sources); err != nil {
+			return nil, fmt.Errorf("invalid ENRICHMENT_SOURCES: %w", err)
+		}
+	}
+	for i, source := range cfg.Enrichment.Sources {
+		if source.Name == "" || source.Key == "" || source.Target == "" || source.URL == "" {
+			return nil, fmt.Errorf("enrichment source %d needs a name, key, target and url", i)
+		}
+	}
+	envconfig.LoadDurationEnv("ENRICHMENT_TIMEOUT", &cfg.Enrichment.Timeout)
+	envconfig.LoadDurationEnv("ENRICHMENT_CACHE_TTL", &cfg.Enrichment.CacheTTL)
+	envconfig.LoadIntEnv("ENRICHMENT_CACHE_SIZE", &cfg.Enrichment.CacheSize)
+
+	// Load degraded mode config
+	envconfig.LoadJSONStringArrayEnv("DEGRADED_SKIP_STAGES", &cfg.Degraded.SkipStages)
+
+	// Load user check config
+	envconfig.LoadStringEnv("USER_CHECK_URL", &cfg.UserCheck.URL)
+	envconfig.LoadStringEnv("USER_CHECK_ACTION", &cfg.UserCheck.Action)
+	envconfig.LoadDurationEnv("USER_CHECK_TIMEOUT", &cfg.UserCheck.Timeout)
+	envconfig.LoadDurationEnv("USER_CHECK_CACHE_TTL", &cfg.UserCheck.CacheTTL)
+	envconfig.LoadIntEnv("USER_CHECK_CACHE_SIZE", &cfg.UserCheck.CacheSize)
+	if cfg.UserCheck.Action != UserCheckDeadLetter && cfg.UserCheck.Action != UserCheckDrop {
+		return nil, fmt.Errorf("USER_CHECK_ACTION must be %q or %q", UserCheckDeadLetter, UserCheckDrop)
+	}
+
+	// Load subscriptions config
+	envconfig.LoadStringEnv("SUBSCRIPTIONS_URL", &cfg.Subscriptions.URL)
+	envconfig.LoadDurationEnv("SUBSCRIPTIONS_TIMEOUT", &cfg.Subscriptions.Timeout)
+	envconfig.LoadIntEnv("SUBSCRIPTIONS_PAGE_SIZE", &cfg.Subscriptions.PageSize)
+	envconfig.LoadIntEnv("SUBSCRIPTIONS_MAX_RECIPIENTS", &cfg.Subscriptions.MaxRecipients)
+	envconfig.LoadStringEnv("SUBSCRIPTIONS_FANOUT_TOPIC", &cfg.Subscriptions.FanoutTopic)
+	envconfig.LoadStringEnv("SUBSCRIPTIONS_FANOUT_GROUP_ID", &cfg.Subscriptions.FanoutGroupID)
+	envconfig.LoadIntEnv("SUBSCRIPTIONS_FANOUT_RATE", &cfg.Subscriptions.FanoutRate)
+	if cfg.Subscriptions.PageSize <= 0 || cfg.Subscriptions.PageSize > 5000 {
+		return nil, fmt.Errorf("SUBSCRIPTIONS_PAGE_SIZE must be between 1 and 5000")
+	}
+	if cfg.Subscriptions.MaxRecipients < 0 || cfg.Subscriptions.FanoutRate < 0 {
+		return nil, fmt.Errorf("SUBSCRIPTIONS_MAX_RECIPIENTS and SUBSCRIPTIONS_FANOUT_RATE must not be negative")
+	}
+
+	// Load timestamp config
+	envconfig.LoadDurationEnv("VALIDATION_CLOCK_SKEW", &cfg.Timestamps.ClockSkew)
+	envconfig.LoadDurationEnv("VALIDATION_MAX_AGE", &cfg.Timestamps.MaxAge)
+	if cfg.Timestamps.ClockSkew < 0 || cfg.Timestamps.MaxAge < 0 {
+		return nil, fmt.Errorf("VALIDATION_CLOCK_SKEW and VALIDATION_MAX_AGE must not be negative")
+	}
 
 	return &cfg, nil
-}
\ No newline at end of file
+}
+
+// Loads the priority levels and their topics
+func loadPriorities(cfg *Config) error {
+	var names []string
+	envconfig.LoadJSONStringArrayEnv("PRIORITY_LEVELS", &names)
+	if len(names) > 0 {
+		levels := make([]PriorityLevelConfig, 0, len(names))
+		for _, name := range names {
+			levels = append(levels, PriorityLevelConfig{
+				Name:  name,
+				Topic: "notifications.priority." + name,
+			})
+		}
+		cfg.Priorities = levels
+	} else {
+		cfg.Priorities = append([]PriorityLevelConfig(nil), cfg.Priorities...)
+	}
+
+	seen := make(map[string]bool, len(cfg.Priorities))
+	for i := range cfg.Priorities {
+		level := &cfg.Priorities[i]
+		envconfig.LoadStringEnv("KAFKA_PRODUCER_TOPIC_"+envSuffix(level.Name), &level.Topic)
+
+		if level.Name == "" {
+			return fmt.Errorf("priority level name cannot be empty")
+		}
+		if seen[level.Name] {
+			return fmt.Errorf("duplicate priority level: %s", level.Name)
+		}
+		if level.Topic == "" {
+			return fmt.Errorf("priority level %s has no topic", level.Name)
+		}
+		seen[level.Name] = true
+	}
+
+	if len(cfg.Priorities) == 0 {
+		return fmt.Errorf("at least one priority level must be configured")
+	}
+	return nil
+}
+
+// Converts a priority name into an env variable suffix
+func envSuffix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
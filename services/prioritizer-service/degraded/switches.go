@@ -0,0 +1,132 @@
+// Package degraded lets operators skip pipeline stages in an emergency, so
+// one broken dependency doesn't halt every notification. Every change is
+// logged, and the notifications that skipped a stage are counted.
+package degraded
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stages of the prioritizer that can be skipped
+const (
+	StageEnrichment = "enrichment" // Notifications go on without looked up metadata
+)
+
+// Stages lists the stages that can be skipped
+var Stages = []string{StageEnrichment}
+
+// Switches hold which stages are skipped on this instance
+type Switches struct {
+	stages map[string]*stage
+}
+
+// Status of a stage
+type Status struct {
+	Stage         string     `json:"stage"`
+	Skipped       bool       `json:"skipped"`
+	Since         *time.Time `json:"since,omitempty"`  // When the stage was last skipped or restored
+	By            string     `json:"by,omitempty"`     // Who last skipped or restored it
+	Reason        string     `json:"reason,omitempty"` // Why it was last skipped or restored
+	Notifications int64      `json:"notifications"`    // Notifications that skipped the stage since start
+}
+
+type stage struct {
+	skipped       atomic.Bool
+	notifications atomic.Int64
+
+	mu     sync.Mutex
+	since  time.Time
+	by     string
+	reason string
+}
+
+// New creates the switches, skipping the given stages from the start
+func New(skipped []string) (*Switches, error) {
+	s := &Switches{stages: make(map[string]*stage, len(Stages))}
+	for _, name := range Stages {
+		s.stages[name] = &stage{}
+	}
+
+	for _, name := range skipped {
+		if err := s.Skip(name, "startup", "configured at startup"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Skipped reports whether a stage is skipped
+func (s *Switches) Skipped(name string) bool {
+	st, exists := s.stages[name]
+	return exists && st.skipped.Load()
+}
+
+// Record counts a notification that skipped a stage
+func (s *Switches) Record(name string) {
+	if st, exists := s.stages[name]; exists {
+		st.notifications.Add(1)
+	}
+}
+
+// Skip starts skipping a stage
+func (s *Switches) Skip(name, by, reason string) error {
+	return s.set(name, true, by, reason)
+}
+
+// Restore stops skipping a stage
+func (s *Switches) Restore(name, by, reason string) error {
+	return s.set(name, false, by, reason)
+}
+
+// set switches a stage and audits the change
+func (s *Switches) set(name string, skipped bool, by, reason string) error {
+	st, exists := s.stages[name]
+	if !exists {
+		return fmt.Errorf("unknown stage %s, expected one of %v", name, Stages)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.skipped.Load() == skipped {
+		return nil
+	}
+	st.skipped.Store(skipped)
+	st.since = time.Now()
+	st.by = by
+	st.reason = reason
+
+	action := "restored"
+	if skipped {
+		action = "skipped"
+	}
+	log.Printf("DEGRADED MODE: stage %s %s by %s: %s", name, action, by, reason)
+	return nil
+}
+
+// Status returns the status of every stage
+func (s *Switches) Status() []Status {
+	statuses := make([]Status, 0, len(s.stages))
+	for _, name := range Stages {
+		st := s.stages[name]
+		st.mu.Lock()
+		status := Status{
+			Stage:         name,
+			Skipped:       st.skipped.Load(),
+			By:            st.by,
+			Reason:        st.reason,
+			Notifications: st.notifications.Load(),
+		}
+		if !st.since.IsZero() {
+			since := st.since
+			status.Since = &since
+		}
+		st.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
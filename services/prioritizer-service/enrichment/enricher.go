@@ -0,0 +1,139 @@
+package enrichment
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Source looks up a value added to the metadata of notifications
+type Source interface {
+	// Name identifies the source in logs and cache keys
+	Name() string
+	// Target is the metadata field the looked up value is stored in
+	Target() string
+	// Key returns what to look up for the notification, empty when the source doesn't apply
+	Key(notification *models.NotificationEvent) string
+	// Lookup fetches the value for a key
+	Lookup(ctx context.Context, key string) (any, error)
+}
+
+// Enricher adds looked up values to notifications, leaving fields unset when a lookup fails
+type Enricher struct {
+	sources    []Source
+	timeout    time.Duration
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+// Config for the enricher
+type Config struct {
+	Timeout   time.Duration
+	CacheTTL  time.Duration
+	CacheSize int
+}
+
+// Creates a new enricher over the given sources
+func NewEnricher(cfg Config, sources ...Source) *Enricher {
+	return &Enricher{
+		sources:    sources,
+		timeout:    cfg.Timeout,
+		ttl:        cfg.CacheTTL,
+		maxEntries: cfg.CacheSize,
+		entries:    make(map[string]cacheEntry),
+	}
+}
+
+// Enrich fills unset metadata fields from the sources, reporting whether it added any
+func (e *Enricher) Enrich(ctx context.Context, notification *models.NotificationEvent) bool {
+	enriched := false
+	for _, source := range e.sources {
+		if _, exists := notification.Metadata[source.Target()]; exists {
+			continue
+		}
+
+		key := source.Key(notification)
+		if key == "" {
+			continue
+		}
+
+		value, err := e.lookup(ctx, source, key)
+		if err != nil {
+			logging.ForRequest(notification.RequestID).Printf("Failed to enrich notification %s from %s: %v", notification.ID, source.Name(), err)
+			continue
+		}
+
+		if notification.Metadata == nil {
+			notification.Metadata = make(map[string]interface{})
+		}
+		notification.Metadata[source.Target()] = value
+		enriched = true
+	}
+	return enriched
+}
+
+// lookup returns the cached value when fresh, asking the source otherwise
+func (e *Enricher) lookup(ctx context.Context, source Source, key string) (any, error) {
+	cacheKey := source.Name() + ":" + key
+
+	e.mu.Lock()
+	entry, exists := e.entries[cacheKey]
+	e.mu.Unlock()
+
+	if exists && time.Now().Before(entry.expiresAt) {
+		return entry.value, nil
+	}
+
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	value, err := source.Lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	e.store(cacheKey, value)
+	return value, nil
+}
+
+// store caches a looked up value
+func (e *Enricher) store(cacheKey string, value any) {
+	if e.ttl <= 0 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if len(e.entries) >= e.maxEntries {
+		// Make room by dropping expired entries, skip caching if still full
+		for key, entry := range e.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(e.entries, key)
+			}
+		}
+		if len(e.entries) >= e.maxEntries {
+			return
+		}
+	}
+
+	e.entries[cacheKey] = cacheEntry{
+		value:     value,
+		expiresAt: now.Add(e.ttl),
+	}
+}
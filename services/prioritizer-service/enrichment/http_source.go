@@ -0,0 +1,76 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// HTTPSource looks values up from an HTTP endpoint returning JSON
+type HTTPSource struct {
+	name   string
+	key    string // Metadata field holding the lookup key
+	target string
+	url    string
+	client *http.Client
+}
+
+// Creates a new HTTP source
+func NewHTTPSource(name, key, target, url string) *HTTPSource {
+	return &HTTPSource{
+		name:   name,
+		key:    key,
+		target: target,
+		url:    url,
+		client: &http.Client{},
+	}
+}
+
+// Name identifies the source
+func (s *HTTPSource) Name() string {
+	return s.name
+}
+
+// Target is the metadata field the value is stored in
+func (s *HTTPSource) Target() string {
+	return s.target
+}
+
+// Key returns the value of the key metadata field
+func (s *HTTPSource) Key(notification *models.NotificationEvent) string {
+	value, exists := notification.Metadata[s.key]
+	if !exists || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// Lookup fetches the value for a key, the timeout comes from the context
+func (s *HTTPSource) Lookup(ctx context.Context, key string) (any, error) {
+	target := strings.ReplaceAll(s.url, "{key}", url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var value any
+	if err := json.NewDecoder(resp.Body).Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return value, nil
+}
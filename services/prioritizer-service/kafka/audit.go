@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// Name this service records its admin actions under
+const auditService = "prioritizer-service"
+
+// AuditPublisher sends the admin actions of this instance to the audit
+// topic, which the rate limiter stores in its audit trail
+type AuditPublisher struct {
+	producer   *KafkaProducer
+	topic      string
+	instanceID string
+	sequence   atomic.Int64 // Keeps the IDs of this instance's actions unique
+}
+
+// NewAuditPublisher creates an admin action publisher, ensuring the audit topic exists
+func NewAuditPublisher(cfg config.KafkaProducerConfig, topic, instanceID string) (*AuditPublisher, error) {
+	producerCfg := cfg.Producer()
+	producerCfg.RequiredAcks = int(sarama.WaitForAll) // Actions must not be lost from the trail
+
+	// Ensure the audit topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(producerCfg, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditPublisher{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		topic:      topic,
+		instanceID: instanceID,
+	}, nil
+}
+
+// Record logs an action of this instance and sends it to the audit topic
+func (p *AuditPublisher) Record(ctx context.Context, action models.AdminAction) error {
+	action.Service = auditService
+	action.InstanceID = p.instanceID
+	action.ID = fmt.Sprintf("%s-%d-%d", p.instanceID, time.Now().UnixNano(), p.sequence.Add(1))
+	log.Printf("ADMIN AUDIT: %s by %s: %s %s -> %d", action.Action, action.Actor, action.Path, action.Parameters, action.Status)
+
+	payload, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("failed to marshal admin action: %w", err)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: p.topic,
+		Key:   sarama.StringEncoder(action.Service),
+		Value: sarama.ByteEncoder(payload),
+	}
+	if _, _, err := p.producer.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send admin action: %w", err)
+	}
+	return nil
+}
+
+// Close closes the producer
+func (p *AuditPublisher) Close() error {
+	return p.producer.Close()
+}
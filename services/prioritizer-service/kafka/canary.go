@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// CanaryMirrorProducer wraps a Producer so that a sample of the traffic is
+// copied, together with the priority it was given, to the canary topic
+type CanaryMirrorProducer struct {
+	Producer
+	mirror        *KafkaProducer
+	topic         string
+	samplePercent int
+}
+
+// Creates a producer that mirrors samplePercent of notifications to the canary topic
+func NewCanaryMirrorProducer(producer Producer, cfg config.KafkaProducerConfig, canary config.CanaryConfig) (Producer, error) {
+	// Ensure the canary topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), canary.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CanaryMirrorProducer{
+		Producer: producer,
+		mirror: &KafkaProducer{
+			producer:    sarama_producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		topic:         canary.Topic,
+		samplePercent: canary.SamplePercent,
+	}, nil
+}
+
+// Sends the notification and mirrors it when sampled. Mirroring failures are
+// only logged so the canary can never hold up real traffic.
+func (p *CanaryMirrorProducer) SendMessage(ctx context.Context, notification *models.PrioritizedNotification) error {
+	if err := p.Producer.SendMessage(ctx, notification); err != nil {
+		return err
+	}
+
+	if !sampled(notification, p.samplePercent) {
+		return nil
+	}
+
+	logger := logging.ForRequest(notification.RequestID)
+	mirrored := notification.NotificationEvent
+	mirrored.CanaryBaseline = &models.CanaryBaseline{Priority: notification.Priority}
+	if err := hydrate(ctx, &mirrored); err != nil {
+		logger.Printf("Failed to decode canary copy of notification %s: %v", notification.ID, err)
+		return nil
+	}
+
+	payload, err := json.Marshal(mirrored)
+	if err != nil {
+		logger.Printf("Failed to marshal canary copy of notification %s: %v", notification.ID, err)
+		return nil
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   p.topic,
+		Key:     sarama.StringEncoder(notification.UserID),
+		Value:   sarama.ByteEncoder(payload),
+		Headers: notificationHeaders(notification.RequestID, notification.EventType, notification.Metadata, ""),
+	}
+	if _, _, err := p.mirror.send(ctx, msg); err != nil {
+		logger.Printf("Failed to mirror notification %s to the canary: %v", notification.ID, err)
+	}
+	return nil
+}
+
+// Closes both producers
+func (p *CanaryMirrorProducer) Close() error {
+	p.mirror.Close()
+	return p.Producer.Close()
+}
+
+// CanaryProducer replaces the producer of a canary instance: it compares the
+// canary's priority with the primary's and never sends anything
+type CanaryProducer struct{}
+
+// Creates the producer of a canary instance
+func NewCanaryProducer() Producer {
+	return &CanaryProducer{}
+}
+
+// Compares the canary's decision with the primary's
+func (p *CanaryProducer) SendMessage(ctx context.Context, notification *models.PrioritizedNotification) error {
+	logger := logging.ForRequest(notification.RequestID)
+	baseline := notification.CanaryBaseline
+	if baseline == nil {
+		logger.Printf("Canary: notification %s has no baseline, was it mirrored by a primary?", notification.ID)
+		return nil
+	}
+
+	if baseline.Priority != notification.Priority {
+		logger.Printf("Canary mismatch: notification %s (%s) prioritized as %s, primary chose %s",
+			notification.ID, notification.EventType, notification.Priority, baseline.Priority)
+		return nil
+	}
+
+	logger.Printf("Canary match: notification %s prioritized as %s", notification.ID, notification.Priority)
+	return nil
+}
+
+// Nothing to close
+func (p *CanaryProducer) Close() error {
+	return nil
+}
+
+// Reports whether a notification falls into the sampled percentage. The
+// decision only depends on the user, so every instance samples all of a
+// user's notifications or none.
+func sampled(notification *models.PrioritizedNotification, percent int) bool {
+	if percent <= 0 {
+		return false
+	}
+	key := notification.UserID
+	if key == "" {
+		key = notification.ID
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32()%100) < percent
+}
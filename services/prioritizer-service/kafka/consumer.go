@@ -2,43 +2,139 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
+	"maps"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/failures"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
 )
 
 // Interface for consuming messages from Kafka
 type Consumer interface {
-	Start(ctx context.Context, messageHandler func(*models.NotificationEvent) error) error
+	Start(ctx context.Context, messageHandler func(context.Context, *models.NotificationEvent) error) error
+	// StopIntake stops fetching messages, the ones being handled carry on
+	StopIntake(ctx context.Context) error
+	// Drain waits for the messages being handled, interrupting them once ctx is done
+	Drain(ctx context.Context) error
+	// Stats reports how busy the message handlers are
+	Stats() ConsumerStats
 	Close() error
 }
 
+// Snapshot of the consumer for diagnostics
+type ConsumerStats struct {
+	Processed   int64   `json:"processed"`    // Messages handled since creation
+	Filtered    int64   `json:"filtered"`     // Messages skipped by the header filter since creation
+	Rejected    int64   `json:"rejected"`     // Messages dropped for their signature since creation
+	Unsigned    int64   `json:"unsigned"`     // Unsigned messages accepted since creation, while signatures aren't required
+	InFlight    int64   `json:"in_flight"`    // Messages being handled right now, at most one per claimed partition
+	BusySeconds float64 `json:"busy_seconds"` // Time spent in the message handler, summed over partitions
+	Lag         int64   `json:"lag"`          // Messages behind the topic's high watermark, summed over claimed partitions
+}
+
+// Handler activity shared by all partition claims, for diagnostics
+type consumerActivity struct {
+	processed atomic.Int64
+	filtered  atomic.Int64
+	rejected  atomic.Int64
+	unsigned  atomic.Int64
+	inFlight  atomic.Int64
+	busy      atomic.Int64 // Nanoseconds spent in the message handler
+
+	lagMu sync.Mutex
+	lag   map[int32]int64 // Messages behind the high watermark, per claimed partition
+}
+
+// recordLag stores the lag of a partition as of its latest message
+func (a *consumerActivity) recordLag(partition int32, lag int64) {
+	a.lagMu.Lock()
+	defer a.lagMu.Unlock()
+	if a.lag == nil {
+		a.lag = make(map[int32]int64)
+	}
+	a.lag[partition] = lag
+}
+
+// resetLag forgets the partitions of a previous session
+func (a *consumerActivity) resetLag() {
+	a.lagMu.Lock()
+	defer a.lagMu.Unlock()
+	a.lag = nil
+}
+
+// totalLag returns the lag summed over the claimed partitions
+func (a *consumerActivity) totalLag() int64 {
+	a.lagMu.Lock()
+	defer a.lagMu.Unlock()
+	var total int64
+	for _, lag := range a.lag {
+		total += lag
+	}
+	return total
+}
+
 // Implements the Consumer interface using Sarama
 type KafkaConsumer struct {
 	consumerGroup sarama.ConsumerGroup
 	topic         string
+	filter        headerFilter
+	verifier      *signing.Verifier // Nil accepts messages without checking their signature
+	retryMax      int
+	retryBackoff  time.Duration
+	passthrough   int                 // Metadata values longer than this stay undecoded
+	raw           bool                // Decode partially and hand the consumed bytes to the handler
+	deadLetters   *DeadLetterProducer // Nil drops failed messages
 	ready         chan bool
 	mu            sync.Mutex
+	activity      consumerActivity
+
+	// Shutdown, fetching stops before handlers are interrupted
+	intake         context.Context
+	stopIntake     context.CancelFunc
+	processing     context.Context
+	stopProcessing context.CancelFunc
+	stopped        chan struct{} // Closed once Start returned
 }
 
 // Implements sarama.ConsumerGroupHandler
 type consumerHandler struct {
 	ready          chan bool
-	messageHandler func(*models.NotificationEvent) error
+	messageHandler func(context.Context, *models.NotificationEvent) error
+	filter         headerFilter
+	verifier       *signing.Verifier
+	retryMax       int
+	retryBackoff   time.Duration
+	passthrough    int
+	raw            bool
+	deadLetters    *DeadLetterProducer
+	activity       *consumerActivity
+	processing     context.Context // Outlives the session, so shutdown lets handlers finish
 	mu             sync.Mutex
 	isReady        bool
 }
 
-// Creates a new Kafka consumer
-func NewConsumer(cfg config.KafkaConsumerConfig) (Consumer, error) {
+// Creates a new Kafka consumer, failed messages go to deadLetters when it is set
+func NewConsumer(cfg config.KafkaConsumerConfig, deadLetters *DeadLetterProducer) (Consumer, error) {
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
 	config.Consumer.Offsets.Initial = sarama.OffsetNewest
-	
+	if cfg.FromOldest {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+
+	verifier, err := signing.NewVerifier(cfg.Signing)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create the consumer group
 	consumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID, config)
 	if err != nil {
@@ -48,19 +144,45 @@ func NewConsumer(cfg config.KafkaConsumerConfig) (Consumer, error) {
 	kafkaConsumer := KafkaConsumer{
 		consumerGroup: consumerGroup,
 		topic:         cfg.Topic,
+		filter:        newHeaderFilter(cfg.Filter),
+		verifier:      verifier,
+		retryMax:      cfg.RetryMax,
+		retryBackoff:  cfg.RetryBackoff,
+		passthrough:   cfg.MetadataPassthroughBytes,
+		raw:           cfg.RawPassthrough,
+		deadLetters:   deadLetters,
 		ready:         make(chan bool),
-	} 
+		stopped:       make(chan struct{}),
+	}
+	kafkaConsumer.intake, kafkaConsumer.stopIntake = context.WithCancel(context.Background())
+	kafkaConsumer.processing, kafkaConsumer.stopProcessing = context.WithCancel(context.Background())
 
 	// Create and return the consumer
 	return &kafkaConsumer, nil
 }
 
 // Starts consuming messages from Kafka
-func (c *KafkaConsumer) Start(ctx context.Context, messageHandler func(*models.NotificationEvent) error) error {
+func (c *KafkaConsumer) Start(ctx context.Context, messageHandler func(context.Context, *models.NotificationEvent) error) error {
+	defer close(c.stopped)
+
+	// Cancelling ctx stops intake, handlers are only interrupted by Drain
+	stop := context.AfterFunc(ctx, c.stopIntake)
+	defer stop()
+	ctx = c.intake
+
 	// Define the consumer handler
 	handler := consumerHandler{
 		ready:          c.ready,
 		messageHandler: messageHandler,
+		filter:         c.filter,
+		verifier:       c.verifier,
+		retryMax:       c.retryMax,
+		retryBackoff:   c.retryBackoff,
+		passthrough:    c.passthrough,
+		raw:            c.raw,
+		deadLetters:    c.deadLetters,
+		activity:       &c.activity,
+		processing:     c.processing,
 	}
 
 	// Start consuming in a separate goroutine
@@ -83,38 +205,73 @@ func (c *KafkaConsumer) Start(ctx context.Context, messageHandler func(*models.N
 			if ctx.Err() != nil {
 				return
 			}
-			
+
 			// Continue consuming
 			log.Println("Consumer restarting...")
 		}
 	}()
 
 	// Wait until consumer is ready
-	<-c.ready
-	log.Println("Consumer is ready")
+	select {
+	case <-c.ready:
+		log.Println("Consumer is ready")
+	case <-ctx.Done():
+	}
 
 	// Wait for context cancellation
 	<-ctx.Done()
 	log.Println("Consumer context cancelled, shutting down...")
 	wg.Wait()
-	
+
 	return nil
 }
 
+// StopIntake stops fetching messages. The session ends once the messages
+// being handled are done, which Drain waits for.
+func (c *KafkaConsumer) StopIntake(ctx context.Context) error {
+	c.stopIntake()
+	return nil
+}
+
+// Drain waits for the handlers to finish the messages they hold. Once ctx is
+// done they are interrupted, leaving their messages to the next session.
+func (c *KafkaConsumer) Drain(ctx context.Context) error {
+	select {
+	case <-c.stopped:
+		return nil
+	case <-ctx.Done():
+		c.stopProcessing()
+		return fmt.Errorf("%d messages still in flight: %w", c.activity.inFlight.Load(), ctx.Err())
+	}
+}
+
+// Stats reports how busy the message handlers are
+func (c *KafkaConsumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Processed:   c.activity.processed.Load(),
+		Filtered:    c.activity.filtered.Load(),
+		Rejected:    c.activity.rejected.Load(),
+		Unsigned:    c.activity.unsigned.Load(),
+		InFlight:    c.activity.inFlight.Load(),
+		BusySeconds: time.Duration(c.activity.busy.Load()).Seconds(),
+		Lag:         c.activity.totalLag(),
+	}
+}
+
 // Closes the Kafka consumer
 func (c *KafkaConsumer) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
+
 	if c.consumerGroup == nil {
 		return nil
 	}
-	
+
 	err := c.consumerGroup.Close()
 	if err != nil {
 		return err
 	}
-	
+
 	return nil
 }
 
@@ -122,13 +279,16 @@ func (c *KafkaConsumer) Close() error {
 func (h *consumerHandler) Setup(session sarama.ConsumerGroupSession) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	
+
+	// Partitions may have moved to another instance
+	h.activity.resetLag()
+
 	// Mark the consumer as ready
 	if !h.isReady {
 		close(h.ready)
 		h.isReady = true
 	}
-	
+
 	log.Println("Consumer session setup complete")
 	return nil
 }
@@ -143,26 +303,132 @@ func (h *consumerHandler) Cleanup(session sarama.ConsumerGroupSession) error {
 func (h *consumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	// Process messages
 	for message := range claim.Messages() {
+		// Leave prefetched messages to the next session once intake stopped
+		if session.Context().Err() != nil {
+			return nil
+		}
+		h.activity.recordLag(message.Partition, claim.HighWaterMarkOffset()-message.Offset-1)
+
+		// Skip messages this consumer doesn't care about without parsing them
+		if h.filter.skips(message) {
+			h.activity.filtered.Add(1)
+			session.MarkMessage(message, "")
+			continue
+		}
+
+		// Drop messages not signed by the other services. They aren't
+		// dead-lettered, where they would be signed and could be requeued.
+		if unsigned, err := h.verifier.Verify(message); err != nil {
+			log.Printf("Rejecting message from topic %s, partition %d, offset %d: %v",
+				message.Topic, message.Partition, message.Offset, err)
+			h.activity.rejected.Add(1)
+			session.MarkMessage(message, "")
+			continue
+		} else if unsigned {
+			h.activity.unsigned.Add(1)
+		}
+
 		// Parse message payload
 		var event models.NotificationEvent
-		if err := json.Unmarshal(message.Value, &event); err != nil {
-			log.Printf("Error unmarshalling message: %v", err)
+		if err := h.unmarshal(message.Value, &event); err != nil {
+			h.fail(session, message, log.Default(), failures.Validation(fmt.Errorf("failed to unmarshal message: %w", err)))
+			session.MarkMessage(message, "")
+			continue
+		}
+
+		// Messages from older producers carry no headers to filter on
+		if !h.filter.accepts(&event) {
+			h.activity.filtered.Add(1)
 			session.MarkMessage(message, "")
 			continue
 		}
 
-		// Process the message with the handler
-		if err := h.messageHandler(&event); err != nil {
-			log.Printf("Error processing message: %v", err)
-			// We still mark the message as processed to avoid reprocessing invalid messages
+		// Events from older producers only carry the request ID as a header
+		if event.RequestID == "" {
+			event.RequestID = headerValue(message, logging.RequestIDHeader)
+		}
+		logger := logging.ForRequest(event.RequestID)
+
+		// Process the message with the handler, retrying transient failures.
+		// Handlers outlive the session, so stopping intake lets them finish.
+		ctx := withSession(h.processing, session.Context())
+		h.activity.inFlight.Add(1)
+		handleStart := time.Now()
+		err := failures.WithRetries(ctx, h.retryMax, h.retryBackoff, func() error {
+			if !h.raw {
+				return h.messageHandler(ctx, &event)
+			}
+			// Every attempt starts from the event as consumed, so the
+			// consumed bytes still describe it
+			attempt := event
+			attempt.Metadata = maps.Clone(event.Metadata)
+			return h.messageHandler(withRawPayload(ctx, message.Value), &attempt)
+		})
+		h.activity.busy.Add(int64(time.Since(handleStart)))
+		h.activity.inFlight.Add(-1)
+		h.activity.processed.Add(1)
+
+		if err != nil {
+			// Leave a message interrupted by shutdown or a rebalance to the next session
+			if (ctx.Err() != nil || session.Context().Err() != nil) && failures.ActionFor(err) == failures.ActionRetry {
+				return nil
+			}
+			h.fail(session, message, logger, err)
 		}
 
 		// Mark message as processed
 		session.MarkMessage(message, "")
-		
-		log.Printf("Processed message from topic %s, partition %d, offset %d", 
+
+		logger.Printf("Processed message from topic %s, partition %d, offset %d",
 			message.Topic, message.Partition, message.Offset)
 	}
-	
+
 	return nil
-}
\ No newline at end of file
+}
+
+type sessionKey struct{}
+
+// Returns a context carrying the context of the session a message was consumed in
+func withSession(ctx, session context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// Returns the context of the session the message was consumed in, nil outside one
+func sessionOf(ctx context.Context) context.Context {
+	session, _ := ctx.Value(sessionKey{}).(context.Context)
+	return session
+}
+
+// Decodes a message payload, partially in raw passthrough mode
+func (h *consumerHandler) unmarshal(data []byte, event *models.NotificationEvent) error {
+	if h.raw {
+		return unmarshalPartial(data, event, h.passthrough)
+	}
+	return models.UnmarshalEvent(data, event, h.passthrough)
+}
+
+// Returns the value of a message header, empty when it is missing
+func headerValue(message *sarama.ConsumerMessage, key string) string {
+	for _, header := range message.Headers {
+		if header != nil && string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// Settles a message that failed for good: rate-limited messages are dropped,
+// everything else is parked on the dead-letter topic
+func (h *consumerHandler) fail(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage, logger *log.Logger, err error) {
+	if failures.ActionFor(err) == failures.ActionDrop || h.deadLetters == nil {
+		logger.Printf("Dropping message from topic %s, partition %d, offset %d: %v",
+			message.Topic, message.Partition, message.Offset, err)
+		return
+	}
+
+	logger.Printf("Dead-lettering message from topic %s, partition %d, offset %d: %v",
+		message.Topic, message.Partition, message.Offset, err)
+	if dlErr := h.deadLetters.Send(session.Context(), message, err); dlErr != nil {
+		logger.Printf("Failed to dead-letter message, dropping it: %v", dlErr)
+	}
+}
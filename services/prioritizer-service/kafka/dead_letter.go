@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// Headers describing why and where from a message was dead-lettered
+const (
+	ErrorHeader       = "X-Error"
+	SourceTopicHeader = "X-Source-Topic"
+)
+
+// DeadLetterProducer parks messages that failed for good on the dead-letter
+// topic, unchanged apart from headers naming the failure
+type DeadLetterProducer struct {
+	producer *KafkaProducer
+	topic    string
+}
+
+// Creates a new dead-letter producer, ensuring the dead-letter topic exists
+func NewDeadLetterProducer(cfg config.KafkaProducerConfig, topic string) (*DeadLetterProducer, error) {
+	// Ensure the dead-letter topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterProducer{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		topic: topic,
+	}, nil
+}
+
+// Parks a consumed message along with the failure that stopped it
+func (p *DeadLetterProducer) Send(ctx context.Context, message *sarama.ConsumerMessage, cause error) error {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+2)
+	for _, header := range message.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(ErrorHeader), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(SourceTopicHeader), Value: []byte(message.Topic)},
+	)
+
+	msg := &sarama.ProducerMessage{
+		Topic:   p.topic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	}
+	if _, _, err := p.producer.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Closes the dead-letter producer
+func (p *DeadLetterProducer) Close() error {
+	return p.producer.Close()
+}
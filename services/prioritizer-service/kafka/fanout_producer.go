@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// FanoutProducer hands events going to many users off to the fan-out topic,
+// where a consumer group of their own expands them, so a large audience
+// doesn't hold up the raw topic's partition
+type FanoutProducer struct {
+	producer *KafkaProducer
+	topic    string
+}
+
+// Creates a new fan-out producer, ensuring the fan-out topic exists
+func NewFanoutProducer(cfg config.KafkaProducerConfig, topic string) (*FanoutProducer, error) {
+	// Ensure the fan-out topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FanoutProducer{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		topic: topic,
+	}, nil
+}
+
+// Hands the event off as it was consumed, keyed by its ID so large events
+// spread over the partitions
+func (p *FanoutProducer) HandOff(ctx context.Context, notification *models.NotificationEvent) error {
+	payload := rawPayload(ctx)
+	if payload == nil {
+		var err error
+		if payload, err = json.Marshal(notification); err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   p.topic,
+		Key:     sarama.StringEncoder(notification.ID),
+		Value:   sarama.ByteEncoder(payload),
+		Headers: notificationHeaders(notification.RequestID, notification.EventType, notification.Metadata, ""),
+	}
+	if _, _, err := p.producer.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Closes the fan-out producer
+func (p *FanoutProducer) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Headers describing a notification, so consumers can skip messages without unmarshalling them
+const (
+	EventTypeHeader = "X-Event-Type"
+	TenantHeader    = "X-Tenant"
+	PriorityHeader  = "X-Priority"
+	DeadlineHeader  = "X-Deadline" // Unix milliseconds the notification should reach the delivery topic by
+)
+
+// Returns the Kafka headers of a notification message, leaving out empty values
+func notificationHeaders(requestID, eventType string, metadata map[string]any, priority string) []sarama.RecordHeader {
+	headers := requestIDHeaders(requestID)
+	for _, header := range [][2]string{
+		{EventTypeHeader, eventType},
+		{TenantHeader, tenantOf(metadata)},
+		{PriorityHeader, priority},
+	} {
+		if header[1] != "" {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(header[0]), Value: []byte(header[1])})
+		}
+	}
+	return headers
+}
+
+// Returns the Kafka headers carrying the processing deadline, if there is one
+func deadlineHeaders(deadline int64) []sarama.RecordHeader {
+	if deadline == 0 {
+		return nil
+	}
+	return []sarama.RecordHeader{{Key: []byte(DeadlineHeader), Value: []byte(strconv.FormatInt(deadline, 10))}}
+}
+
+// Returns the Kafka headers carrying the request ID, if there is one
+func requestIDHeaders(requestID string) []sarama.RecordHeader {
+	if requestID == "" {
+		return nil
+	}
+	return []sarama.RecordHeader{{Key: []byte(logging.RequestIDHeader), Value: []byte(requestID)}}
+}
+
+// Returns the tenant named in a notification's metadata, empty when there is none
+func tenantOf(metadata map[string]any) string {
+	tenant, _ := metadata[models.MetadataTenant].(string)
+	return tenant
+}
+
+// Decides whether the consumer wants a message, from its headers before
+// unmarshalling and from the payload of messages without them
+type headerFilter struct {
+	eventTypes map[string]bool
+	tenants    map[string]bool
+}
+
+// Creates the filter of a consumer
+func newHeaderFilter(cfg config.HeaderFilterConfig) headerFilter {
+	return headerFilter{
+		eventTypes: toSet(cfg.EventTypes),
+		tenants:    toSet(cfg.Tenants),
+	}
+}
+
+// Reports whether the message's headers rule it out. A missing header rules
+// nothing out, the payload decides once unmarshalled.
+func (f headerFilter) skips(message *sarama.ConsumerMessage) bool {
+	return excludes(f.eventTypes, headerValue(message, EventTypeHeader)) ||
+		excludes(f.tenants, headerValue(message, TenantHeader))
+}
+
+// Reports whether the consumer wants the notification
+func (f headerFilter) accepts(notification *models.NotificationEvent) bool {
+	return includes(f.eventTypes, notification.EventType) && includes(f.tenants, tenantOf(notification.Metadata))
+}
+
+// Reports whether a present value is outside a non-empty set
+func excludes(set map[string]bool, value string) bool {
+	return value != "" && len(set) > 0 && !set[value]
+}
+
+// Reports whether the value is in the set, any value is when it's empty
+func includes(set map[string]bool, value string) bool {
+	return len(set) == 0 || set[value]
+}
+
+// Returns the values as a set, nil when there are none
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
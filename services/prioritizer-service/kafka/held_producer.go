@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// HeldProducer parks notifications of paused event types on the hold topic,
+// from which operators replay or discard them once the storm is understood
+type HeldProducer struct {
+	producer *KafkaProducer
+	topic    string
+}
+
+// Creates a new held producer, ensuring the hold topic exists
+func NewHeldProducer(cfg config.KafkaProducerConfig, topic string) (*HeldProducer, error) {
+	// Ensure the hold topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HeldProducer{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		topic: topic,
+	}, nil
+}
+
+// Parks the notification as it was consumed, so replaying it to the raw topic processes it anew
+func (p *HeldProducer) Hold(ctx context.Context, notification *models.NotificationEvent) error {
+	payload := rawPayload(ctx)
+	if payload == nil {
+		var err error
+		if payload, err = json.Marshal(notification); err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   p.topic,
+		Key:     sarama.StringEncoder(notification.UserID),
+		Value:   sarama.ByteEncoder(payload),
+		Headers: notificationHeaders(notification.RequestID, notification.EventType, notification.Metadata, ""),
+	}
+	if _, _, err := p.producer.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Closes the held producer
+func (p *HeldProducer) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,131 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/storm"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// HeldReplayer moves held notifications back to the raw topic once their
+// event type is released. A hold partition waits at a notification whose
+// event type is still paused, so notifications are replayed in order.
+type HeldReplayer struct {
+	consumerGroup sarama.ConsumerGroup
+	producer      *KafkaProducer
+	storms        *storm.Detector
+	verifier      *signing.Verifier
+	holdTopic     string
+	rawTopic      string
+	retryBackoff  time.Duration
+}
+
+// Creates a new held replayer reading the hold topic in its own consumer group
+func NewHeldReplayer(cfg config.KafkaConsumerConfig, groupID, holdTopic string, held *HeldProducer, storms *storm.Detector) (*HeldReplayer, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	verifier, err := signing.NewVerifier(cfg.Signing)
+	if err != nil {
+		return nil, err
+	}
+
+	consumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, groupID, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HeldReplayer{
+		consumerGroup: consumerGroup,
+		producer:      held.producer,
+		storms:        storms,
+		verifier:      verifier,
+		holdTopic:     holdTopic,
+		rawTopic:      cfg.Topic,
+		retryBackoff:  cfg.RetryBackoff,
+	}, nil
+}
+
+// Start replays held notifications until the context is done
+func (r *HeldReplayer) Start(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := r.consumerGroup.Consume(ctx, []string{r.holdTopic}, r); err != nil {
+			log.Printf("Error from held replayer: %v", err)
+			time.Sleep(r.retryBackoff)
+		}
+	}
+}
+
+// Setup is run at the beginning of a new session
+func (r *HeldReplayer) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session
+func (r *HeldReplayer) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Replays the held notifications of a partition claim as their event types are released
+func (r *HeldReplayer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for message := range claim.Messages() {
+		// Held notifications wait for their release however long it takes
+		if _, err := r.verifier.VerifyDue(message, time.Now()); err != nil {
+			log.Printf("Rejecting held message at partition %d, offset %d: %v", message.Partition, message.Offset, err)
+			session.MarkMessage(message, "")
+			continue
+		}
+
+		eventType := headerValue(message, EventTypeHeader)
+		if eventType == "" {
+			var event struct {
+				EventType string `json:"event_type"`
+			}
+			json.Unmarshal(message.Value, &event)
+			eventType = event.EventType
+		}
+		if err := r.storms.WaitReleased(ctx, eventType); err != nil {
+			return nil
+		}
+
+		headers := make([]sarama.RecordHeader, 0, len(message.Headers))
+		for _, header := range message.Headers {
+			if header != nil {
+				headers = append(headers, *header)
+			}
+		}
+		msg := &sarama.ProducerMessage{
+			Topic:   r.rawTopic,
+			Key:     sarama.ByteEncoder(message.Key),
+			Value:   sarama.ByteEncoder(message.Value),
+			Headers: headers,
+		}
+
+		// The held copy is the only one, keep trying until the session ends
+		for {
+			_, _, err := r.producer.send(ctx, msg)
+			if err == nil {
+				break
+			}
+			log.Printf("Failed to replay held message at partition %d, offset %d: %v", message.Partition, message.Offset, err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(r.retryBackoff):
+			}
+		}
+		session.MarkMessage(message, "")
+	}
+	return nil
+}
+
+// Closes the held replayer
+func (r *HeldReplayer) Close() error {
+	return r.consumerGroup.Close()
+}
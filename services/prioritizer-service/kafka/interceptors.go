@@ -0,0 +1,28 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// Handler processes a notification event, as the processor does
+type Handler func(ctx context.Context, notification *models.NotificationEvent) error
+
+// Interceptor runs around the processing of a notification and calls next to
+// carry on with it, or returns without calling it to stop there. Cross-cutting
+// features such as metrics, tracing, retries, deduplication or auditing plug
+// into the processor as interceptors instead of being woven into its steps.
+type Interceptor func(ctx context.Context, notification *models.NotificationEvent, next Handler) error
+
+// Chain returns a handler running the interceptors around handler, the first
+// one outermost
+func Chain(handler Handler, interceptors ...Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, notification *models.NotificationEvent) error {
+			return interceptor(ctx, notification, next)
+		}
+	}
+	return handler
+}
@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// Raw passthrough: the consumer decodes only the fields prioritization reads
+// and hands the consumed bytes along in the context. The producer sends them
+// on unchanged, the priority travelling in the X-Priority header, unless the
+// processor changed the notification and dropped them.
+
+// Context key of the consumed payload
+type rawPayloadKey struct{}
+
+// Returns a context carrying the consumed payload, nil drops it
+func withRawPayload(ctx context.Context, payload []byte) context.Context {
+	return context.WithValue(ctx, rawPayloadKey{}, payload)
+}
+
+// Returns the consumed payload the notification may be sent on as, nil when there is none
+func rawPayload(ctx context.Context) []byte {
+	payload, _ := ctx.Value(rawPayloadKey{}).([]byte)
+	return payload
+}
+
+// Decodes an event without its content, channel content and actions, which
+// nothing in the prioritizer reads
+func unmarshalPartial(data []byte, event *models.NotificationEvent, passthroughBytes int) error {
+	payload := struct {
+		*models.NotificationEvent
+		Content        json.RawMessage            `json:"content,omitempty"`
+		ChannelContent json.RawMessage            `json:"channel_content,omitempty"`
+		Actions        json.RawMessage            `json:"actions,omitempty"`
+		Metadata       map[string]json.RawMessage `json:"metadata,omitempty"`
+	}{NotificationEvent: event}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+
+	metadata, err := models.DecodeMetadata(payload.Metadata, passthroughBytes)
+	if err != nil {
+		return err
+	}
+	event.Metadata = metadata
+	return nil
+}
+
+// Decodes the fields unmarshalPartial skipped, for a notification about to
+// be encoded again. Notifications without a consumed payload are complete.
+func hydrate(ctx context.Context, notification *models.NotificationEvent) error {
+	payload := rawPayload(ctx)
+	if payload == nil {
+		return nil
+	}
+
+	var body struct {
+		Content        string                 `json:"content"`
+		ChannelContent *models.ChannelContent `json:"channel_content"`
+		Actions        []models.Action        `json:"actions"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return fmt.Errorf("failed to decode notification body: %w", err)
+	}
+	notification.Content = body.Content
+	notification.ChannelContent = body.ChannelContent
+	notification.Actions = body.Actions
+	return nil
+}
@@ -1,49 +1,258 @@
 package kafka
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"time"
 
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/budget"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/degraded"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/enrichment"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/prioritizers"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/storm"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/subscriptions"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/validators"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/debugtrace"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/failures"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
 )
 
 // Handles the business logic of validating and prioritizing notifications
 type Processor struct {
-	validator  *validators.NotificationValidator
-	prioritizer *prioritizers.NotificationPrioritizer
-	producer   Producer
+	validator    *validators.NotificationValidator
+	prioritizer  *prioritizers.NotificationPrioritizer
+	producer     Producer
+	tracer       debugtrace.Tracer       // Optional, traces notifications sampled for debugging
+	storms       *storm.Detector         // Optional, detects event storms
+	held         *HeldProducer           // Parks notifications of event types paused by a storm
+	enricher     *enrichment.Enricher    // Optional, adds looked up values to the metadata
+	expander     *subscriptions.Expander // Optional, sends events without a user to their recipients and entity subscribers
+	fanout       *FanoutProducer         // Optional, hands events to expand off to the fan-out topic
+	stages       *degraded.Switches      // Optional, stages operators skip in degraded mode
+	budgets      *budget.Budgets         // Optional, deadlines notifications should reach delivery by
+	interceptors []Interceptor           // Run around every notification, the first outermost
+	handle       Handler                 // The interceptors chained around dispatch
 }
 
 // Creates a new notification processor
-func NewProcessor(validator *validators.NotificationValidator, prioritizer *prioritizers.NotificationPrioritizer, producer Producer) *Processor {
+func NewProcessor(validator *validators.NotificationValidator, prioritizer *prioritizers.NotificationPrioritizer, producer Producer, tracer debugtrace.Tracer,
+	storms *storm.Detector, held *HeldProducer, enricher *enrichment.Enricher, expander *subscriptions.Expander,
+	fanout *FanoutProducer, stages *degraded.Switches, budgets *budget.Budgets) *Processor {
 	processor := Processor{
-		validator:  validator,
+		validator:   validator,
 		prioritizer: prioritizer,
-		producer:   producer,
+		producer:    producer,
+		tracer:      tracer,
+		storms:      storms,
+		held:        held,
+		enricher:    enricher,
+		expander:    expander,
+		fanout:      fanout,
+		stages:      stages,
+		budgets:     budgets,
 	}
+	processor.handle = processor.dispatch
 
 	return &processor
 }
 
+// Adds interceptors around the processing of every notification, inside the
+// ones added before. Not safe to call while notifications are processed.
+func (p *Processor) Use(interceptors ...Interceptor) {
+	p.interceptors = append(p.interceptors, interceptors...)
+	p.handle = Chain(p.dispatch, p.interceptors...)
+}
+
 // Processes a notification message
-func (p *Processor) ProcessMessage(notification *models.NotificationEvent) error {
+func (p *Processor) ProcessMessage(ctx context.Context, notification *models.NotificationEvent) error {
+	return p.handle(ctx, notification)
+}
+
+// Processes an event without a user for each of its recipients, handing it
+// off to the fan-out topic when there is one, any other for its user
+func (p *Processor) dispatch(ctx context.Context, notification *models.NotificationEvent) error {
+	if p.expander != nil && p.expander.Expands(notification) {
+		if p.fanout == nil {
+			return p.expand(ctx, notification)
+		}
+		if err := p.fanout.HandOff(ctx, notification); err != nil {
+			return failures.Transient(fmt.Errorf("failed to hand off notification for fan-out: %w", err))
+		}
+		p.trace(ctx, notification, "handed_off", nil)
+		return nil
+	}
+	return p.process(ctx, notification, true)
+}
+
+// Expands an event handed off to the fan-out topic, events addressed to a
+// single user are processed as they are
+func (p *Processor) Expand(ctx context.Context, notification *models.NotificationEvent) error {
+	if p.expander == nil || !p.expander.Expands(notification) {
+		return p.process(ctx, notification, true)
+	}
+	return p.expand(ctx, notification)
+}
+
+// Processes an event without a user as one notification per recipient and
+// subscriber of its entity. A recipient whose notification fails for good is
+// skipped, so it doesn't hold back the others. Transient failures fail the
+// whole event, which is then processed again, recipient IDs and all; the rate
+// limiter drops the copies it delivered already.
+func (p *Processor) expand(ctx context.Context, notification *models.NotificationEvent) error {
+	logger := logging.ForRequest(notification.RequestID)
+
+	// Give the partition up on a rebalance rather than hold it for the rest
+	// of a long expansion, the next session expands the event again
+	if session := sessionOf(ctx); session != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(session, cancel)()
+	}
+
+	// Every copy is encoded anew, with the body of the event
+	if err := hydrate(ctx, notification); err != nil {
+		return failures.Validation(err)
+	}
+	ctx = withRawPayload(ctx, nil)
+
+	skipped := 0
+	expanded, err := p.expander.Expand(ctx, notification, func(recipient *models.NotificationEvent) error {
+		// A single event reaching many users is intended here, not a storm
+		err := p.process(ctx, recipient, false)
+		if err != nil && failures.ActionFor(err) != failures.ActionRetry {
+			logger.Printf("Skipping recipient %s of notification %s: %v", recipient.UserID, notification.ID, err)
+			skipped++
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		p.trace(ctx, notification, "expand_failed", map[string]any{"recipients": expanded, "error": err.Error()})
+		if errors.Is(err, failures.ErrTransient) {
+			return err
+		}
+		return failures.Transient(err)
+	}
+
+	logger.Printf("Notification %s expanded to %d recipients, %d skipped", notification.ID, expanded, skipped)
+	p.trace(ctx, notification, "expanded", map[string]any{"recipients": expanded, "skipped": skipped})
+	return nil
+}
+
+// Validates, prioritizes and sends on a notification for a single user
+func (p *Processor) process(ctx context.Context, notification *models.NotificationEvent, observeStorms bool) error {
+	createdAt := notification.CreatedAt
+
 	// Validate the notification
-	if err := p.validator.Validate(notification); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+	if err := p.validator.Validate(ctx, notification); err != nil {
+		p.trace(ctx, notification, "rejected", map[string]any{"error": err.Error()})
+		if errors.Is(err, failures.ErrSkipped) {
+			return err
+		}
+		return failures.Validation(err)
+	}
+
+	// Hold notifications of event types paused by a storm until an operator releases them
+	if observeStorms && p.storms != nil && p.storms.Observe(notification) && p.held != nil {
+		if err := p.held.Hold(ctx, notification); err != nil {
+			return failures.Transient(fmt.Errorf("failed to hold notification: %w", err))
+		}
+		logging.ForRequest(notification.RequestID).Printf("Notification %s held, event type %s is paused", notification.ID, notification.EventType)
+		p.trace(ctx, notification, "held", nil)
+		return nil
 	}
-	
+
+	// Add looked up values, unless operators skip enrichment or too little of
+	// the budget is left. Strategies may act on the values, so the budget is
+	// the one of the priority the event type maps to.
+	enriched := p.enricher != nil && !p.skip(ctx, notification, degraded.StageEnrichment) &&
+		!p.tight(ctx, notification, p.prioritizer.Mapped(notification), degraded.StageEnrichment) && p.enricher.Enrich(ctx, notification)
+
+	// The consumed bytes no longer describe a changed notification, which is encoded anew
+	if enriched || notification.CreatedAt != createdAt {
+		if err := hydrate(ctx, notification); err != nil {
+			return failures.Validation(err)
+		}
+		ctx = withRawPayload(ctx, nil)
+	}
+
 	// Prioritize the notification
 	prioritizedNotification := p.prioritizer.Prioritize(notification)
-	
+
 	// Log the prioritization result
-	log.Printf("Notification %s prioritized as %s", notification.ID, prioritizedNotification.Priority)
-	
+	logging.ForRequest(notification.RequestID).Printf("Notification %s prioritized as %s", notification.ID, prioritizedNotification.Priority)
+
+	// Give the notification the deadline of its priority, for the rate limiter
+	if deadline, ok := p.deadline(notification, prioritizedNotification.Priority); ok {
+		prioritizedNotification.Deadline = deadline.UnixMilli()
+		if p.budgets.Exceeded(prioritizedNotification.Priority, deadline) {
+			logging.ForRequest(notification.RequestID).Printf("Notification %s missed its %s priority deadline by %v",
+				notification.ID, prioritizedNotification.Priority, time.Since(deadline).Round(time.Millisecond))
+			p.trace(ctx, notification, "budget_exceeded", map[string]any{"priority": prioritizedNotification.Priority, "deadline": deadline})
+		}
+	}
+
 	// Send to the appropriate Kafka topic based on priority
-	if err := p.producer.SendMessage(prioritizedNotification); err != nil {
-		return fmt.Errorf("failed to send prioritized notification: %w", err)
+	if err := p.producer.SendMessage(ctx, prioritizedNotification); err != nil {
+		p.trace(ctx, notification, "produce_failed", map[string]any{"priority": prioritizedNotification.Priority, "error": err.Error()})
+		return failures.Transient(fmt.Errorf("failed to send prioritized notification: %w", err))
 	}
-	
+
+	p.trace(ctx, notification, "prioritized", map[string]any{"priority": prioritizedNotification.Priority})
 	return nil
-}
\ No newline at end of file
+}
+
+// Reports whether operators skip a stage in degraded mode, counting the
+// notification that skips it
+func (p *Processor) skip(ctx context.Context, notification *models.NotificationEvent, stage string) bool {
+	if p.stages == nil || !p.stages.Skipped(stage) {
+		return false
+	}
+	p.stages.Record(stage)
+	logging.ForRequest(notification.RequestID).Printf("Degraded mode: notification %s skipped stage %s", notification.ID, stage)
+	p.trace(ctx, notification, "stage_skipped", map[string]any{"stage": stage})
+	return true
+}
+
+// Returns when a notification of the priority should reach the delivery
+// topic, false without a budget for it
+func (p *Processor) deadline(notification *models.NotificationEvent, priority string) (time.Time, bool) {
+	if p.budgets == nil || notification.CreatedAt == 0 {
+		return time.Time{}, false
+	}
+	return p.budgets.Deadline(priority, time.Unix(notification.CreatedAt, 0))
+}
+
+// Reports whether too little of the budget of the priority is left for an
+// optional step, counting the notification that skips it
+func (p *Processor) tight(ctx context.Context, notification *models.NotificationEvent, priority, step string) bool {
+	deadline, ok := p.deadline(notification, priority)
+	if !ok || !p.budgets.Tight(deadline, step) {
+		return false
+	}
+	logging.ForRequest(notification.RequestID).Printf("Notification %s skipped %s, %v of its budget left",
+		notification.ID, step, time.Until(deadline).Round(time.Millisecond))
+	p.trace(ctx, notification, "budget_tight", map[string]any{"step": step, "deadline": deadline})
+	return true
+}
+
+// Publishes a debug trace when the notification was sampled for debugging
+func (p *Processor) trace(ctx context.Context, notification *models.NotificationEvent, stage string, details map[string]any) {
+	if p.tracer == nil || !notification.Debug {
+		return
+	}
+	hydrate(ctx, notification) // Best effort, the trace shows the decoded fields otherwise
+
+	p.tracer.Trace(ctx, &models.DebugTrace{
+		NotificationID: notification.ID,
+		RequestID:      notification.RequestID,
+		UserID:         notification.UserID,
+		Stage:          stage,
+		Details:        details,
+		Payload:        notification,
+		Timestamp:      time.Now().UnixMilli(),
+	})
+}
@@ -1,101 +1,173 @@
 package kafka
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/jsonpool"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
 )
 
 // Interface for sending messages to Kafka
 type Producer interface {
-	SendMessage(notification *models.PrioritizedNotification) error
+	SendMessage(ctx context.Context, notification *models.PrioritizedNotification) error
 	Close() error
 }
 
 // Implements the Producer interface using Sarama
 type KafkaProducer struct {
-	producer sarama.SyncProducer
-	topics   map[string]string
+	producer    sarama.SyncProducer
+	topics      map[string]string
+	rules       []config.RoutingRule // Route matching notifications to dedicated topics
+	sendTimeout time.Duration // Zero means no timeout
+
+	// Tenant topics are created on a tenant's first notification
+	tenants      config.TenantTopicsConfig
+	topicManager *TopicManager // Only kept while tenant topics are enabled
+	topicsMu     sync.Mutex
+	cfg          config.KafkaProducerConfig
+	priorities   []config.PriorityLevelConfig
 }
 
 // Creates a new Kafka producer
-func NewProducer(cfg config.KafkaProducerConfig) (Producer, error) {
-	// Configure Sarama
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.RequiredAcks(cfg.RequiredAcks)
-	config.Producer.Retry.Max = cfg.RetryMax
-	config.Producer.Return.Successes = true
-
+func NewProducer(cfg config.KafkaProducerConfig, priorities []config.PriorityLevelConfig, rules []config.RoutingRule, tenants config.TenantTopicsConfig) (Producer, error) {
 	// Create topic manager and ensure topics exist
 	topicManager, err := NewTopicManager(cfg.Brokers)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create topic manager: %w", err)
 	}
-	defer topicManager.Close()
 	
-	// Ensure all required topics exist
-	if err := topicManager.EnsureTopicsExist(cfg); err != nil {
+	// Ensure all required topics exist, including those of the listed tenants
+	if err := topicManager.EnsureTopicsExist(cfg, priorities, rules); err != nil {
+		topicManager.Close()
 		return nil, fmt.Errorf("failed to ensure topics exist: %w", err)
 	}
+	if tenants.Enabled {
+		for _, tenant := range tenants.Tenants {
+			if err := topicManager.EnsureTenantTopicsExist(cfg, priorities, tenant); err != nil {
+				topicManager.Close()
+				return nil, fmt.Errorf("failed to ensure topics of tenant %s exist: %w", tenant, err)
+			}
+		}
+	} else {
+		topicManager.Close()
+		topicManager = nil
+	}
 
-	// Create the producer
-	sarama_producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+	// Create the signed producer, its topics were ensured above
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer())
 	if err != nil {
+		if topicManager != nil {
+			topicManager.Close()
+		}
 		return nil, err
 	}
 
 	// Map priority levels to topics
-	topics := map[string]string{
-		models.PriorityHigh:   cfg.TopicHigh,
-		models.PriorityMedium: cfg.TopicMedium,
-		models.PriorityLow:    cfg.TopicLow,
+	topics := make(map[string]string, len(priorities))
+	for _, level := range priorities {
+		topics[level.Name] = level.Topic
 	}
 
 	kafkaProducer := KafkaProducer{
-		producer: sarama_producer,
-		topics:   topics,
+		producer:    sarama_producer,
+		topics:      topics,
+		rules:       rules,
+		sendTimeout: cfg.SendTimeout,
+
+		tenants:      tenants,
+		topicManager: topicManager,
+		cfg:          cfg,
+		priorities:   priorities,
 	}
 
 	return &kafkaProducer, nil
 }
 
 // Sends a prioritized notification to the appropriate Kafka topic
-func (p *KafkaProducer) SendMessage(notification *models.PrioritizedNotification) error {
+func (p *KafkaProducer) SendMessage(ctx context.Context, notification *models.PrioritizedNotification) error {
 	// Determine target topic based on priority
 	topic, exists := p.topics[notification.Priority]
 	if !exists {
 		return fmt.Errorf("unknown priority level: %s", notification.Priority)
 	}
+	if suffix := routeSuffix(p.rules, &notification.NotificationEvent); suffix != "" {
+		topic += suffix
+	} else if tenant := isolatedTenant(p.tenants, &notification.NotificationEvent); tenant != "" {
+		if err := p.ensureTenantTopics(tenant); err != nil {
+			return err
+		}
+		topic = TenantTopic(topic, tenant)
+	}
 
-	// Marshal notification to JSON
-	payload, err := json.Marshal(notification)
-	if err != nil {
-		return fmt.Errorf("failed to marshal notification: %w", err)
+	// Send the consumed bytes on when the processor kept them, the priority
+	// travels in the X-Priority header
+	payload := rawPayload(ctx)
+	var buf *jsonpool.Buffer
+	if payload == nil {
+		var err error
+		if buf, err = jsonpool.Marshal(notification); err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+		payload = buf.Bytes()
 	}
 
 	// Create message
 	msg := &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(notification.UserID), // Use user ID as key for partitioning
-		Value: sarama.ByteEncoder(payload),
+		Topic:   topic,
+		Key:     sarama.StringEncoder(notification.UserID), // Use user ID as key for partitioning
+		Value:   sarama.ByteEncoder(payload),
+		Headers: append(
+			notificationHeaders(notification.RequestID, notification.EventType, notification.Metadata, notification.Priority),
+			deadlineHeaders(notification.Deadline)...),
 	}
 
-	// Send message
-	partition, offset, err := p.producer.SendMessage(msg)
+	// Send message, the buffer is reused unless an abandoned send still holds it
+	partition, offset, err := p.send(ctx, msg)
+	if buf != nil && !abandoned(err) {
+		buf.Release()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
-	log.Printf("Message with priority %s sent to topic %s, partition %d at offset %d", 
+	logging.ForRequest(notification.RequestID).Printf("Message with priority %s sent to topic %s, partition %d at offset %d", 
 		notification.Priority, topic, partition, offset)
 	return nil
 }
 
+// Sends a message, bounded by the context and the send timeout
+func (p *KafkaProducer) send(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	return kafkaproducer.Send(ctx, p.producer, msg, p.sendTimeout)
+}
+
+// Reports whether a send was abandoned, it may still complete in the background
+func abandoned(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Creates the tenant's priority topics unless they were created before
+func (p *KafkaProducer) ensureTenantTopics(tenant string) error {
+	p.topicsMu.Lock()
+	defer p.topicsMu.Unlock()
+
+	if err := p.topicManager.EnsureTenantTopicsExist(p.cfg, p.priorities, tenant); err != nil {
+		return fmt.Errorf("failed to ensure topics of tenant %s exist: %w", tenant, err)
+	}
+	return nil
+}
+
 // Closes the Kafka producer
 func (p *KafkaProducer) Close() error {
+	if p.topicManager != nil {
+		p.topicManager.Close()
+	}
 	return p.producer.Close()
 }
\ No newline at end of file
@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// Returns the topic suffix of the first routing rule the notification matches, empty when none does
+func routeSuffix(rules []config.RoutingRule, notification *models.NotificationEvent) string {
+	for _, rule := range rules {
+		if matchesRule(rule, notification) {
+			return rule.TopicSuffix
+		}
+	}
+	return ""
+}
+
+// Reports whether the notification has one of the rule's event types and all of its metadata values
+func matchesRule(rule config.RoutingRule, notification *models.NotificationEvent) bool {
+	if len(rule.EventTypes) > 0 && !slices.Contains(rule.EventTypes, notification.EventType) {
+		return false
+	}
+
+	for key, want := range rule.Metadata {
+		value, exists := notification.Metadata[key]
+		if !exists || fmt.Sprint(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the tenant a notification is isolated as, empty when it stays on the shared topic
+func isolatedTenant(cfg config.TenantTopicsConfig, notification *models.NotificationEvent) string {
+	if !cfg.Enabled {
+		return ""
+	}
+	tenant := tenantOf(notification.Metadata)
+	if tenant == "" || len(cfg.Tenants) > 0 && !slices.Contains(cfg.Tenants, tenant) {
+		return ""
+	}
+	return tenant
+}
+
+// Returns the tenant's copy of a priority topic. Characters Kafka doesn't
+// allow in topic names are replaced by underscores.
+func TenantTopic(topic, tenant string) string {
+	return topic + "." + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, tenant)
+}
@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/storm"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// StormControl shares storm pauses and releases between the instances on a
+// compacted topic keyed by event type. Every instance reads it from the
+// start, so one starting up knows which event types are paused.
+type StormControl struct {
+	producer  *KafkaProducer
+	consumer  sarama.Consumer
+	partition sarama.PartitionConsumer
+	verifier  *signing.Verifier
+	topic     string
+}
+
+// Creates a new storm control, ensuring its topic exists
+func NewStormControl(producerCfg config.KafkaProducerConfig, consumerCfg config.KafkaConsumerConfig, topic string) (*StormControl, error) {
+	topicManager, err := NewTopicManager(producerCfg.Brokers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create topic manager: %w", err)
+	}
+	defer topicManager.Close()
+
+	if err := topicManager.EnsureCompactedTopic(topic, producerCfg.ReplicationFactor); err != nil {
+		return nil, fmt.Errorf("failed to ensure storm control topic exists: %w", err)
+	}
+
+	verifier, err := signing.NewVerifier(consumerCfg.Signing)
+	if err != nil {
+		return nil, err
+	}
+
+	// The compacted topic was ensured above
+	stormCfg := producerCfg.Producer()
+	stormCfg.RequiredAcks = int(sarama.WaitForAll)
+	saramaProducer, err := kafkaproducer.NewSyncProducer(stormCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := sarama.NewConsumer(consumerCfg.Brokers, sarama.NewConfig())
+	if err != nil {
+		saramaProducer.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	partition, err := consumer.ConsumePartition(topic, 0, sarama.OffsetOldest)
+	if err != nil {
+		consumer.Close()
+		saramaProducer.Close()
+		return nil, fmt.Errorf("failed to consume %s: %w", topic, err)
+	}
+
+	return &StormControl{
+		producer: &KafkaProducer{
+			producer:    saramaProducer,
+			sendTimeout: producerCfg.SendTimeout,
+		},
+		consumer:  consumer,
+		partition: partition,
+		verifier:  verifier,
+		topic:     topic,
+	}, nil
+}
+
+// Publish shares a decision with every instance, this one included
+func (c *StormControl) Publish(decision storm.Decision) error {
+	payload, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("failed to marshal decision: %w", err)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: c.topic,
+		Key:   sarama.StringEncoder(decision.EventType),
+		Value: sarama.ByteEncoder(payload),
+	}
+	if _, _, err := c.producer.send(context.Background(), msg); err != nil {
+		return fmt.Errorf("failed to send decision: %w", err)
+	}
+	return nil
+}
+
+// Start calls apply for each decision, the ones made before this instance
+// started first, until the context is done
+func (c *StormControl) Start(ctx context.Context, apply func(storm.Decision)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-c.partition.Messages():
+			if !ok {
+				return
+			}
+			// Decisions stay in force however old they are, only the signature is checked
+			if _, err := c.verifier.VerifyDue(message, time.Now()); err != nil {
+				log.Printf("Rejecting storm decision at offset %d: %v", message.Offset, err)
+				continue
+			}
+
+			var decision storm.Decision
+			if err := json.Unmarshal(message.Value, &decision); err != nil {
+				log.Printf("Error unmarshalling storm decision: %v", err)
+				continue
+			}
+			apply(decision)
+		}
+	}
+}
+
+// Closes the storm control
+func (c *StormControl) Close() error {
+	c.partition.Close()
+	c.consumer.Close()
+	return c.producer.Close()
+}
@@ -5,16 +5,32 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/admin"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/budget"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/degraded"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/enrichment"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/prioritizers"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/storm"
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/subscriptions"
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/validators"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/debugtrace"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/heartbeat"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/shutdown"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/startup"
 )
 
 func main() {
-	log.Println("Starting Prioritizer Service...")
+	log.Printf("Starting Prioritizer Service %s", buildinfo.Get())
 
 	// Load configuration
 	cfg, err := config.Load()
@@ -22,28 +38,267 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Run on the first reachable Kafka cluster, the standbys take over when it is lost
+	clusters := cfg.KafkaFailover.BrokerLists(cfg.KafkaConsumer.Brokers)
+	active := 0
+	if len(clusters) > 1 {
+		if active, err = kafkaadmin.SelectCluster(clusters, cfg.KafkaFailover.CheckInterval); err != nil {
+			log.Printf("%v, waiting for %v", err, clusters[0])
+			active = 0
+		}
+		cfg.KafkaConsumer.Brokers = clusters[active]
+		cfg.KafkaProducer.Brokers = clusters[active]
+		log.Printf("Running on Kafka cluster %v", clusters[active])
+	}
+
+	// Check every dependency up front, so a misconfiguration fails with what to fix
+	if cfg.Startup.Preflight {
+		retry := startup.Config{
+			Timeout:        cfg.Startup.RetryTimeout,
+			InitialBackoff: cfg.Startup.RetryBackoff,
+			MaxBackoff:     cfg.Startup.RetryMaxBackoff,
+		}
+		if err := startup.Preflight(context.Background(), retry, preflightChecks(cfg)...); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Create validator and prioritizer
-	validator := validators.NewValidator()
-	prioritizer := prioritizers.NewPrioritizer()
+	schemas, err := validators.LoadSchemas(cfg.EventRegistry.File, cfg.EventRegistry.EncryptedKeys)
+	if err != nil {
+		log.Fatalf("Failed to load metadata schemas: %v", err)
+	}
+	var users validators.UserStore
+	if cfg.UserCheck.URL != "" {
+		users = validators.NewCachingUserStore(validators.NewHTTPUserStore(cfg.UserCheck.URL, cfg.UserCheck.Timeout),
+			cfg.UserCheck.CacheTTL, cfg.UserCheck.CacheSize)
+		log.Printf("Checking users exist with %s", cfg.UserCheck.URL)
+	}
+	validator := validators.NewValidator(schemas, users, cfg.UserCheck.Action == config.UserCheckDrop, validators.TimestampPolicy{
+		ClockSkew: cfg.Timestamps.ClockSkew,
+		MaxAge:    cfg.Timestamps.MaxAge,
+	})
+	levels := make([]string, 0, len(cfg.Priorities))
+	for _, level := range cfg.Priorities {
+		levels = append(levels, level.Name)
+	}
+	strategies := make([]prioritizers.Strategy, 0, len(cfg.PriorityStrategies)+1)
+	// Tenants' rule modules come first, they are the tenants' own decisions
+	wasmRules, err := cfg.WASMRules.CreateWASMRules(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to load WASM rules: %v", err)
+	}
+	if wasmRules != nil {
+		strategies = append(strategies, prioritizers.NewWASMStrategy(wasmRules))
+		log.Printf("Prioritizing with the tenants' WASM rules in %s", cfg.WASMRules.Dir)
+	}
+	expressionRules, err := cfg.ExpressionRules.CreateExpressionRules()
+	if err != nil {
+		log.Fatalf("Failed to load expression rules: %v", err)
+	}
+	if expressionRules != nil {
+		strategies = append(strategies, prioritizers.NewExpressionStrategy(expressionRules))
+		log.Printf("Prioritizing with the expression rules in %s", cfg.ExpressionRules.File)
+	}
+	for _, selected := range cfg.PriorityStrategies {
+		strategy, err := prioritizers.NewStrategy(selected.Name, selected.Options)
+		if err != nil {
+			log.Fatalf("Failed to create prioritization strategy: %v", err)
+		}
+		strategies = append(strategies, strategy)
+		log.Printf("Prioritizing with strategy %s", selected.Name)
+	}
+	prioritizer := prioritizers.NewPrioritizer(prioritizers.Config{
+		Levels:          levels,
+		EventPriorities: cfg.EventPriorities,
+		MaxHint:         cfg.PriorityHintMax,
+		Strategies:      strategies,
+	})
+
+	// Initialize Kafka producer, the canary compares its decisions instead of producing
+	var producer kafka.Producer
+	if cfg.Canary.Enabled {
+		producer = kafka.NewCanaryProducer()
+		log.Printf("Running as canary on topic %s", cfg.Canary.Topic)
+	} else {
+		producer, err = kafka.NewProducer(cfg.KafkaProducer, cfg.Priorities, cfg.RoutingRules, cfg.TenantTopics)
+		if err != nil {
+			log.Fatalf("Failed to create Kafka producer: %v", err)
+		}
+		if cfg.Canary.SamplePercent > 0 {
+			producer, err = kafka.NewCanaryMirrorProducer(producer, cfg.KafkaProducer, cfg.Canary)
+			if err != nil {
+				log.Fatalf("Failed to create canary mirror: %v", err)
+			}
+			log.Printf("Mirroring %d%% of traffic to canary topic %s", cfg.Canary.SamplePercent, cfg.Canary.Topic)
+		}
+	}
+	// Producers are flushed once the consumer drained, see the shutdown sequence below
+	flush := []func(ctx context.Context) error{shutdown.Close(producer.Close)}
+
+	// Trace notifications enqueue sampled for debugging, the canary leaves that to the primary
+	var tracer debugtrace.Tracer
+	if !cfg.Canary.Enabled {
+		tracer, err = debugtrace.New(cfg.KafkaProducer.Producer(), debugtrace.Config{
+			Service:     "prioritizer-service",
+			Topic:       cfg.DebugTopic,
+			SendTimeout: cfg.KafkaProducer.SendTimeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create debug tracer: %v", err)
+		}
+		flush = append(flush, shutdown.Close(tracer.Close))
+	}
+
+	// Detect event storms, holding paused event types on the hold topic. Every
+	// instance pauses and releases together, replaying what it held once released.
+	var storms *storm.Detector
+	var held *kafka.HeldProducer
+	var stormControl *kafka.StormControl
+	var replayer *kafka.HeldReplayer
+	if cfg.Storm.Threshold > 0 {
+		stormCfg := storm.Config{
+			Window:         cfg.Storm.Window,
+			Threshold:      cfg.Storm.Threshold,
+			MetadataKeys:   cfg.Storm.MetadataKeys,
+			AutoPause:      cfg.Storm.AutoPause,
+			ReleaseFor:     cfg.Storm.ReleaseFor,
+			WebhookURL:     cfg.Storm.WebhookURL,
+			WebhookTimeout: cfg.Storm.WebhookTimeout,
+		}
+		if cfg.Storm.AutoPause {
+			stormControl, err = kafka.NewStormControl(cfg.KafkaProducer, cfg.KafkaConsumer, cfg.Storm.ControlTopic)
+			if err != nil {
+				log.Fatalf("Failed to create storm control: %v", err)
+			}
+			stormCfg.Publish = stormControl.Publish
+		}
+		storms = storm.NewDetector(stormCfg)
+		if cfg.Storm.AutoPause {
+			held, err = kafka.NewHeldProducer(cfg.KafkaProducer, cfg.Storm.HoldTopic)
+			if err != nil {
+				log.Fatalf("Failed to create held producer: %v", err)
+			}
+			flush = append(flush, shutdown.Close(held.Close))
+			replayer, err = kafka.NewHeldReplayer(cfg.KafkaConsumer, cfg.Storm.ReplayGroupID, cfg.Storm.HoldTopic, held, storms)
+			if err != nil {
+				log.Fatalf("Failed to create held replayer: %v", err)
+			}
+		}
+		log.Printf("Detecting storms of %d users within %v", cfg.Storm.Threshold, cfg.Storm.Window)
+	}
+
+	// Enrich notifications from the configured sources before prioritizing them
+	var enricher *enrichment.Enricher
+	if len(cfg.Enrichment.Sources) > 0 {
+		sources := make([]enrichment.Source, 0, len(cfg.Enrichment.Sources))
+		for _, source := range cfg.Enrichment.Sources {
+			sources = append(sources, enrichment.NewHTTPSource(source.Name, source.Key, source.Target, source.URL))
+		}
+		enricher = enrichment.NewEnricher(enrichment.Config{
+			Timeout:   cfg.Enrichment.Timeout,
+			CacheTTL:  cfg.Enrichment.CacheTTL,
+			CacheSize: cfg.Enrichment.CacheSize,
+		}, sources...)
+		log.Printf("Enriching notifications from %d sources", len(sources))
+	}
+
+	// Send events without a user to their recipients and the subscribers of their entity
+	var followers subscriptions.Source
+	if cfg.Subscriptions.URL != "" {
+		followers = subscriptions.NewHTTPSource(cfg.Subscriptions.URL, cfg.Subscriptions.PageSize, cfg.Subscriptions.Timeout)
+		log.Printf("Expanding events about entities to their subscribers from %s", cfg.Subscriptions.URL)
+	}
+	expander := subscriptions.NewExpander(followers, cfg.Subscriptions.MaxRecipients, cfg.Subscriptions.FanoutRate)
+
+	// Expand events going to many users in a consumer group of their own, so
+	// a large audience doesn't hold up the raw topic
+	var fanout *kafka.FanoutProducer
+	if cfg.Subscriptions.FanoutTopic != "" {
+		fanout, err = kafka.NewFanoutProducer(cfg.KafkaProducer, cfg.Subscriptions.FanoutTopic)
+		if err != nil {
+			log.Fatalf("Failed to create fan-out producer: %v", err)
+		}
+		flush = append(flush, shutdown.Close(fanout.Close))
+	}
 
-	// Initialize Kafka producer
-	producer, err := kafka.NewProducer(cfg.KafkaProducer)
+	// Let operators skip broken stages, so one dependency doesn't halt every notification
+	stages, err := degraded.New(cfg.Degraded.SkipStages)
 	if err != nil {
-		log.Fatalf("Failed to create Kafka producer: %v", err)
+		log.Fatalf("Failed to create degraded mode switches: %v", err)
 	}
-	defer producer.Close()
 
 	// Create the processor
-	processor := kafka.NewProcessor(validator, prioritizer, producer)
+	// Give notifications the deadline of their priority
+	budgets := budget.New(cfg.Budget.Budgets, cfg.Budget.Tight)
+	processor := kafka.NewProcessor(validator, prioritizer, producer, tracer, storms, held, enricher, expander, fanout, stages, budgets)
+
+	// Continue where the previous deployment's consumer group stopped
+	if cfg.KafkaConsumer.HandoverFrom != "" {
+		err := kafkaadmin.HandOver(context.Background(), cfg.KafkaConsumer.Brokers, cfg.KafkaConsumer.HandoverFrom,
+			cfg.KafkaConsumer.GroupID, []string{cfg.KafkaConsumer.Topic}, cfg.KafkaConsumer.HandoverTimeout)
+		if err != nil {
+			log.Fatalf("Failed to hand over from consumer group %s: %v", cfg.KafkaConsumer.HandoverFrom, err)
+		}
+	}
+
+	// Offsets don't carry over to a standby cluster, start shortly before the failover
+	if active > 0 && cfg.KafkaFailover.Rewind > 0 {
+		err := kafkaadmin.TranslateOffsets(cfg.KafkaConsumer.Brokers, cfg.KafkaConsumer.GroupID,
+			[]string{cfg.KafkaConsumer.Topic}, time.Now().Add(-cfg.KafkaFailover.Rewind))
+		if err != nil {
+			log.Fatalf("Failed to translate offsets of consumer group %s: %v", cfg.KafkaConsumer.GroupID, err)
+		}
+	}
+
+	// Park messages that fail for good, the canary only logs them
+	var deadLetters *kafka.DeadLetterProducer
+	if cfg.KafkaConsumer.DeadLetterTopic != "" {
+		deadLetters, err = kafka.NewDeadLetterProducer(cfg.KafkaProducer, cfg.KafkaConsumer.DeadLetterTopic)
+		if err != nil {
+			log.Fatalf("Failed to create dead-letter producer: %v", err)
+		}
+		flush = append(flush, shutdown.Close(deadLetters.Close))
+	}
 
 	// Initialize Kafka consumer
-	consumer, err := kafka.NewConsumer(cfg.KafkaConsumer)
+	consumer, err := kafka.NewConsumer(cfg.KafkaConsumer, deadLetters)
 	if err != nil {
 		log.Fatalf("Failed to create Kafka consumer: %v", err)
 	}
-	defer consumer.Close()
 
-	// Create a context that will be canceled on interrupt
+	// A new fan-out group starts at the beginning, so nothing handed off before it first joined is lost
+	var fanoutConsumer kafka.Consumer
+	if fanout != nil {
+		fanoutCfg := cfg.KafkaConsumer
+		fanoutCfg.Topic = cfg.Subscriptions.FanoutTopic
+		fanoutCfg.GroupID = cfg.Subscriptions.FanoutGroupID
+		fanoutCfg.FromOldest = true
+		fanoutConsumer, err = kafka.NewConsumer(fanoutCfg, deadLetters)
+		if err != nil {
+			log.Fatalf("Failed to create fan-out consumer: %v", err)
+		}
+	}
+
+	// Announce this instance on the ops topic
+	if cfg.Heartbeat.Interval > 0 {
+		heartbeater, err := heartbeat.New(cfg.KafkaProducer.Producer(), heartbeat.Config{
+			Service:    "prioritizer-service",
+			InstanceID: cfg.Heartbeat.InstanceID,
+			Topic:      cfg.Heartbeat.Topic,
+			Interval:   cfg.Heartbeat.Interval,
+		}, func(h *heartbeat.Heartbeat) {
+			stats := consumer.Stats()
+			h.Processed, h.Lag = stats.Processed, stats.Lag
+		})
+		if err != nil {
+			log.Fatalf("Failed to create heartbeater: %v", err)
+		}
+		flush = append(flush, shutdown.Close(heartbeater.Close))
+		log.Printf("Publishing heartbeats of instance %s every %v", cfg.Heartbeat.InstanceID, cfg.Heartbeat.Interval)
+	}
+
+	// Create a context that will be canceled on exit
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -51,11 +306,80 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	// Fail over once the active Kafka cluster is lost, by shutting down for a
+	// restart that selects the next reachable one
+	closers := []func(ctx context.Context) error{shutdown.Close(consumer.Close)}
+	if fanoutConsumer != nil {
+		closers = append(closers, shutdown.Close(fanoutConsumer.Close))
+	}
+	var failedOver atomic.Bool
+	if len(clusters) > 1 {
+		watch := kafkaadmin.WatchCluster(clusters, active, cfg.KafkaFailover.CheckInterval, cfg.KafkaFailover.After, func(int) {
+			failedOver.Store(true)
+			select {
+			case sigCh <- syscall.SIGTERM:
+			default:
+			}
+		})
+		closers = append(closers, shutdown.Close(watch.Close))
+	}
+
+	// Send admin actions to the audit trail the rate limiter keeps, logging them without a topic
+	adminCfg := admin.Config{
+		Port:         cfg.Server.Port,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		Operators:    cfg.Server.Operators,
+		Diagnostics: func() any {
+			diagnostics := map[string]any{"consumer": consumer.Stats(), "degraded": stages.Status()}
+			if budgets != nil {
+				diagnostics["budget"] = budgets.Status()
+			}
+			return diagnostics
+		},
+		Storms: storms,
+		Stages: stages,
+		Audit: func(ctx context.Context, action models.AdminAction) error {
+			log.Printf("ADMIN AUDIT: %s by %s: %s %s -> %d", action.Action, action.Actor, action.Path, action.Parameters, action.Status)
+			return nil
+		},
+	}
+	var auditPublisher *kafka.AuditPublisher
+	if cfg.AuditTopic != "" {
+		auditPublisher, err = kafka.NewAuditPublisher(cfg.KafkaProducer, cfg.AuditTopic, cfg.Heartbeat.InstanceID)
+		if err != nil {
+			log.Fatalf("Failed to create audit publisher: %v", err)
+		}
+		adminCfg.Audit = auditPublisher.Record
+	}
+
+	// Pick up the rules other instances sharing them changed
+	if wasmRules != nil {
+		adminCfg.WASMRules = wasmRules.Handler()
+		go wasmRules.Run(ctx, cfg.WASMRules.ReloadInterval)
+		closers = append(closers, wasmRules.Close)
+	}
+	if expressionRules != nil {
+		adminCfg.ExpressionRules = expressionRules.Handler()
+		go expressionRules.Run(ctx, cfg.ExpressionRules.ReloadInterval)
+	}
+
+	// Start the admin server
+	adminServer := admin.NewServer(adminCfg)
 	go func() {
-		sig := <-sigCh
-		log.Printf("Received signal: %v, initiating shutdown", sig)
-		cancel()
+		if err := adminServer.Start(); err != nil {
+			log.Fatal(err)
+		}
 	}()
+	log.Printf("Admin server listening on port %d", cfg.Server.Port)
+
+	// Follow pauses and releases, replaying held notifications once released
+	if stormControl != nil {
+		go stormControl.Start(ctx, storms.Apply)
+		closers = append(closers, shutdown.Close(stormControl.Close))
+		go replayer.Start(ctx)
+	}
 
 	// Start the consumer
 	log.Println("Starting Kafka consumer...")
@@ -65,18 +389,76 @@ func main() {
 		}
 	}()
 
+	if fanoutConsumer != nil {
+		log.Printf("Expanding events from fan-out topic %s", cfg.Subscriptions.FanoutTopic)
+		go func() {
+			if err := fanoutConsumer.Start(ctx, processor.Expand); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	log.Println("Prioritizer Service started successfully")
 
-	// Wait for context cancellation
-	<-ctx.Done()
-	log.Println("Context canceled, shutting down...")
+	// Wait for termination signal
+	sig := <-sigCh
+	log.Printf("Received signal: %v, initiating shutdown", sig)
 
-	// Create a new context with timeout for graceful shutdown
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
-	defer shutdownCancel()
+	// Stop consuming before the producers the handlers send with are closed
+	var sequencer shutdown.Sequencer
+	intake := []func(ctx context.Context) error{consumer.StopIntake}
+	drain := []func(ctx context.Context) error{consumer.Drain}
+	if fanoutConsumer != nil {
+		intake = append(intake, fanoutConsumer.StopIntake)
+		drain = append(drain, fanoutConsumer.Drain)
+	}
+	if replayer != nil {
+		intake = append(intake, shutdown.Close(replayer.Close))
+	}
+	sequencer.Stage("intake", cfg.Shutdown.Intake, intake...)
+	sequencer.Stage("drain", cfg.Shutdown.Drain, drain...)
+	sequencer.Stage("flush", cfg.Shutdown.Flush, flush...)
+	// Admin actions are audited until the admin server stopped
+	closers = append(closers, adminServer.Shutdown)
+	if auditPublisher != nil {
+		closers = append(closers, shutdown.Close(auditPublisher.Close))
+	}
+	sequencer.Stage("close", cfg.Shutdown.Close, closers...)
+	sequencer.Run()
 
-	// Wait for shutdown timeout
-	<-shutdownCtx.Done()
-	
 	log.Println("Prioritizer Service shut down")
-}
\ No newline at end of file
+	if failedOver.Load() {
+		log.Println("Exiting to restart on a standby Kafka cluster")
+		os.Exit(1)
+	}
+}
+
+// preflightChecks lists the dependencies the service can't start without
+func preflightChecks(cfg *config.Config) []startup.Check {
+	checks := []startup.Check{
+		{
+			Name: "Kafka brokers",
+			Hint: "check KAFKA_CONSUMER_BROKERS and that the brokers are up",
+			Run: func(ctx context.Context) error {
+				return kafkaadmin.CheckTopics(ctx, cfg.KafkaConsumer.Brokers)
+			},
+		},
+		{
+			Name: "Kafka topics",
+			Hint: "the topic is created by the enqueue service, start it first or check KAFKA_CONSUMER_TOPIC",
+			Run: func(ctx context.Context) error {
+				return kafkaadmin.CheckTopics(ctx, cfg.KafkaConsumer.Brokers, cfg.KafkaConsumer.Topic)
+			},
+		},
+	}
+	if !slices.Equal(cfg.KafkaProducer.Brokers, cfg.KafkaConsumer.Brokers) {
+		checks = append(checks, startup.Check{
+			Name: "Kafka producer brokers",
+			Hint: "check KAFKA_PRODUCER_BROKERS and that the brokers are up",
+			Run: func(ctx context.Context) error {
+				return kafkaadmin.CheckTopics(ctx, cfg.KafkaProducer.Brokers)
+			},
+		})
+	}
+	return checks
+}
@@ -0,0 +1,6 @@
+package models
+
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
+// Decision the primary pipeline made for a notification mirrored to the canary
+type CanaryBaseline = messages.CanaryBaseline
@@ -0,0 +1,6 @@
+package models
+
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
+// Debug trace of a sampled notification at one stage of the pipeline
+type DebugTrace = messages.DebugTrace
@@ -1,24 +1,44 @@
 package models
 
+import (
+	"encoding/json"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
 // Represents the notification events consumed from Kafka
-type NotificationEvent struct {
-	ID        string                 `json:"id"`
-	UserID    string                 `json:"user_id"`
-	EventType string                 `json:"event_type"`
-	Content   string                 `json:"content,omitempty"`
-	Metadata  map[string]interface{} `json:"metadata,omitempty"`
-	CreatedAt int64                  `json:"created_at"`
-}
+type NotificationEvent = messages.NotificationEvent
+
+// Entity users can subscribe to, such as a thread, a project or an author
+type EntityRef = messages.EntityRef
+
+// User an event goes to, with the priority asked for them
+type Recipient = messages.Recipient
+
+// Signed exemption of an emergency or legally required notification, passed on to the rate limiter
+type Bypass = messages.Bypass
 
 // Extends NotificationEvent with priority information
-type PrioritizedNotification struct {
-	NotificationEvent
-	Priority string `json:"priority"`
-}
+type PrioritizedNotification = messages.PrioritizedNotification
 
-// Priority levels for notifications
+// Priority levels
 const (
-	PriorityHigh   = "high"
-	PriorityMedium = "medium"
-	PriorityLow    = "low"
-)
\ No newline at end of file
+	PriorityCritical = messages.PriorityCritical
+	PriorityHigh     = messages.PriorityHigh
+	PriorityMedium   = messages.PriorityMedium
+	PriorityLow      = messages.PriorityLow
+)
+
+// Metadata entry naming the tenant a notification belongs to
+const MetadataTenant = messages.MetadataTenant
+
+// Decodes a notification event, keeping metadata values longer than
+// passthroughBytes undecoded; zero decodes them all
+func UnmarshalEvent(data []byte, event *NotificationEvent, passthroughBytes int) error {
+	return messages.UnmarshalEvent(data, event, passthroughBytes)
+}
+
+// Decodes metadata values, keeping those longer than passthroughBytes undecoded
+func DecodeMetadata(raw map[string]json.RawMessage, passthroughBytes int) (map[string]any, error) {
+	return messages.DecodeMetadata(raw, passthroughBytes)
+}
@@ -0,0 +1,34 @@
+package prioritizers
+
+import (
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/exprrules"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// ExpressionStrategy gives notifications the priority of the first
+// expression rule setting one that matches them
+type ExpressionStrategy struct {
+	engine *exprrules.Engine
+}
+
+// NewExpressionStrategy creates a strategy evaluating the engine's rules
+func NewExpressionStrategy(engine *exprrules.Engine) *ExpressionStrategy {
+	return &ExpressionStrategy{engine: engine}
+}
+
+// Prioritize returns the priority of the matching rule
+func (s *ExpressionStrategy) Prioritize(notification *models.NotificationEvent) (string, bool) {
+	rule, matched, err := s.engine.Match(notification, func(rule exprrules.Rule) bool {
+		return rule.Priority != ""
+	})
+	if err != nil {
+		logging.ForRequest(notification.RequestID).Printf("Expression rules failed on notification %s: %v",
+			notification.ID, err)
+		return "", false
+	}
+	if !matched {
+		return "", false
+	}
+	return rule.Priority, true
+}
@@ -1,54 +1,146 @@
 package prioritizers
 
 import (
+	"log"
+
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
 )
 
 // Prioritizes notifications based on event type
 type NotificationPrioritizer struct {
 	// Map of event types to priorities
 	eventPriorities map[string]string
+	// Priority assigned to event types without a mapping
+	defaultPriority string
+	// Rank of each configured level, zero being the most urgent
+	ranks map[string]int
+	// Most urgent level a priority hint may ask for, empty ignores hints
+	maxHint string
+	// Registered strategies consulted before the event type mapping
+	strategies []Strategy
+}
+
+// Config for the notification prioritizer
+type Config struct {
+	Levels          []string          // Configured priority levels, most urgent first
+	EventPriorities map[string]string // Overrides merged on top of the built-in mapping
+	MaxHint         string            // Most urgent level priority hints may ask for, empty ignores hints
+	Strategies      []Strategy        // Consulted in order before the event type mapping
+}
+
+// Built-in event type to priority mapping
+var defaultEventPriorities = map[string]string{
+	// Critical (pager-worthy) events
+	"account_compromise":   models.PriorityCritical,
+	"system_outage":        models.PriorityCritical,
+
+	// High priority events
+	"security_alert":       models.PriorityHigh,
+	"payment_failed":       models.PriorityHigh,
+
+	// Medium priority events
+	"message_received":     models.PriorityMedium,
+	"friend_request":       models.PriorityMedium,
+	"comment":              models.PriorityMedium,
+	"subscription_expiring": models.PriorityMedium,
+
+	// Low priority events
+	"like":                 models.PriorityLow,
+	"follow":               models.PriorityLow,
+	"recommendation":       models.PriorityLow,
+	"newsletter":           models.PriorityLow,
 }
 
 // Creates a new notification prioritizer
-func NewPrioritizer() *NotificationPrioritizer {
-	eventPriorities := map[string]string{
-		// High priority events
-		"security_alert":       models.PriorityHigh,
-		"account_compromise":   models.PriorityHigh,
-		"payment_failed":       models.PriorityHigh,
-		"system_outage":        models.PriorityHigh,
-		
-		// Medium priority events
-		"message_received":     models.PriorityMedium,
-		"friend_request":       models.PriorityMedium,
-		"comment":              models.PriorityMedium,
-		"subscription_expiring": models.PriorityMedium,
-		
-		// Low priority events
-		"like":                 models.PriorityLow,
-		"follow":               models.PriorityLow,
-		"recommendation":       models.PriorityLow,
-		"newsletter":           models.PriorityLow,
-	}
-	
+func NewPrioritizer(cfg Config) *NotificationPrioritizer {
+	levels := make(map[string]bool, len(cfg.Levels))
+	ranks := make(map[string]int, len(cfg.Levels))
+	for i, level := range cfg.Levels {
+		levels[level] = true
+		ranks[level] = i
+	}
+
+	// Default to the least urgent configured level
+	defaultPriority := models.PriorityLow
+	if len(cfg.Levels) > 0 {
+		defaultPriority = cfg.Levels[len(cfg.Levels)-1]
+	}
+
+	eventPriorities := make(map[string]string, len(defaultEventPriorities)+len(cfg.EventPriorities))
+	for eventType, priority := range defaultEventPriorities {
+		eventPriorities[eventType] = priority
+	}
+	for eventType, priority := range cfg.EventPriorities {
+		eventPriorities[eventType] = priority
+	}
+
+	// Drop mappings to priority levels that are not configured
+	for eventType, priority := range eventPriorities {
+		if !levels[priority] {
+			log.Printf("Warning: event type %s maps to unconfigured priority %s, using %s",
+				eventType, priority, defaultPriority)
+			delete(eventPriorities, eventType)
+		}
+	}
+
 	return &NotificationPrioritizer{
 		eventPriorities: eventPriorities,
+		defaultPriority: defaultPriority,
+		ranks:           ranks,
+		maxHint:         cfg.MaxHint,
+		strategies:      cfg.Strategies,
 	}
 }
 
+// Mapped returns the priority the notification's event type maps to, before
+// strategies and hints are consulted
+func (p *NotificationPrioritizer) Mapped(notification *models.NotificationEvent) string {
+	if priority, exists := p.eventPriorities[notification.EventType]; exists {
+		return priority
+	}
+	return p.defaultPriority
+}
+
 // Determines the priority of a notification based on its event type
 func (p *NotificationPrioritizer) Prioritize(notification *models.NotificationEvent) *models.PrioritizedNotification {
 	prioritized := &models.PrioritizedNotification{
 		NotificationEvent: *notification,
-		Priority:          models.PriorityLow, // Default to low priority
+		Priority:          p.defaultPriority, // Default to the lowest priority
 	}
-	
+
 	// Check if event type has a defined priority
 	if priority, exists := p.eventPriorities[notification.EventType]; exists {
 		prioritized.Priority = priority
 	}
-	
+
+	// The first strategy that decides overrides the mapping
+	for _, strategy := range p.strategies {
+		priority, decided := strategy.Prioritize(notification)
+		if !decided {
+			continue
+		}
+		if _, exists := p.ranks[priority]; !exists {
+			logging.ForRequest(notification.RequestID).Printf("Ignoring unconfigured priority %s a strategy gave notification %s",
+				priority, notification.ID)
+			continue
+		}
+		prioritized.Priority = priority
+		break
+	}
+
+	// Honour the producer's hint, but no more urgent than allowed
+	if notification.PriorityHint != "" && p.maxHint != "" {
+		if rank, exists := p.ranks[notification.PriorityHint]; !exists {
+			logging.ForRequest(notification.RequestID).Printf("Ignoring unknown priority hint %s of notification %s",
+				notification.PriorityHint, notification.ID)
+		} else if rank < p.ranks[p.maxHint] {
+			prioritized.Priority = p.maxHint
+		} else {
+			prioritized.Priority = notification.PriorityHint
+		}
+	}
+
 	// Additional priority logic could be implemented here:
 	return prioritized
-}
\ No newline at end of file
+}
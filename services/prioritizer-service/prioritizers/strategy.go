@@ -0,0 +1,78 @@
+package prioritizers
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// Strategy is custom prioritization logic teams ship without forking the
+// prioritizer. A package implementing one registers it by name from an init
+// function and is imported for that side effect in main.go:
+//
+//	import _ "example.com/payments/prioritization"
+//
+// PRIORITY_STRATEGIES then selects the registered strategies to consult, in
+// order, along with their options.
+type Strategy interface {
+	// Prioritize returns the priority of the notification, false to leave
+	// the decision to the next strategy and finally the event type mapping
+	Prioritize(notification *models.NotificationEvent) (string, bool)
+}
+
+// StrategyFactory creates a strategy from the options it was configured
+// with, nil when there are none
+type StrategyFactory func(options json.RawMessage) (Strategy, error)
+
+// Registered factories by name
+var (
+	strategiesMu sync.RWMutex
+	strategies   = make(map[string]StrategyFactory)
+)
+
+// Register makes a strategy available under a name. It panics when the name
+// is taken, like registering a database driver twice.
+func Register(name string, factory StrategyFactory) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+
+	if factory == nil {
+		panic("prioritizers: Register of nil factory for strategy " + name)
+	}
+	if _, taken := strategies[name]; taken {
+		panic("prioritizers: Register called twice for strategy " + name)
+	}
+	strategies[name] = factory
+}
+
+// Registered returns the names of the registered strategies, sorted
+func Registered() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewStrategy creates the strategy registered under a name
+func NewStrategy(name string, options json.RawMessage) (Strategy, error) {
+	strategiesMu.RLock()
+	factory, ok := strategies[name]
+	strategiesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown prioritization strategy %q, registered are %v", name, Registered())
+	}
+
+	strategy, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("prioritization strategy %s: %w", name, err)
+	}
+	return strategy, nil
+}
@@ -0,0 +1,40 @@
+package prioritizers
+
+import (
+	"context"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/wasmrules"
+)
+
+// WASMStrategy prioritizes notifications of tenants that uploaded a rule
+// module with that module. A module that fails leaves the decision to the
+// next strategy, so a broken upload can't stop the tenant's notifications.
+type WASMStrategy struct {
+	engine *wasmrules.Engine
+}
+
+// NewWASMStrategy creates a strategy running the engine's modules
+func NewWASMStrategy(engine *wasmrules.Engine) *WASMStrategy {
+	return &WASMStrategy{engine: engine}
+}
+
+// Prioritize returns the priority the tenant's module gives the notification
+func (s *WASMStrategy) Prioritize(notification *models.NotificationEvent) (string, bool) {
+	tenant, _ := notification.Metadata[models.MetadataTenant].(string)
+	if tenant == "" {
+		return "", false
+	}
+
+	decision, ok, err := s.engine.Evaluate(context.Background(), tenant, notification)
+	if err != nil {
+		logging.ForRequest(notification.RequestID).Printf("Rules of tenant %s failed on notification %s: %v",
+			tenant, notification.ID, err)
+		return "", false
+	}
+	if !ok || decision.Priority == "" {
+		return "", false
+	}
+	return decision.Priority, true
+}
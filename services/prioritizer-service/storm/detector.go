@@ -0,0 +1,310 @@
+package storm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// Detector flags events that are produced for an unusual number of users in
+// a short time, such as a bug producing payment_failed for everyone. Users are
+// counted per instance; each instance sees the share of users its partitions
+// hold. Pauses and releases are shared with the other instances through
+// Config.Publish and Apply.
+type Detector struct {
+	cfg      Config
+	client   *http.Client
+	mu       sync.Mutex
+	windows  map[string]*window   // Users seen per fingerprint in the current window
+	storms   map[string]*Storm    // Detected storms by fingerprint, kept until released
+	paused   map[string]bool      // Event types held because of a storm
+	released map[string]time.Time // Event types an operator released, exempt until the given time
+	changed  chan struct{}        // Closed and replaced when an event type is released
+	pruned   time.Time
+}
+
+// Config for the storm detector
+type Config struct {
+	Window         time.Duration // Window distinct users are counted in
+	Threshold      int           // Distinct users within the window that make a storm, zero disables detection
+	MetadataKeys   []string      // Metadata fields that, with the event type, identify the same event
+	AutoPause      bool          // Hold the event type once a storm is detected
+	ReleaseFor     time.Duration // How long a released event type is exempt from pausing
+	WebhookURL     string        // Optional endpoint notified of every storm
+	WebhookTimeout time.Duration
+	Publish        func(Decision) error // Shares pauses and releases with the other instances, optional
+}
+
+// Decision pauses or releases an event type on every instance
+type Decision struct {
+	EventType     string `json:"event_type"`
+	Paused        bool   `json:"paused"`
+	Storm         *Storm `json:"storm,omitempty"`          // Storm that paused the event type
+	ReleasedUntil int64  `json:"released_until,omitempty"` // Unix milliseconds a released event type isn't paused again before
+}
+
+// Storm describes an event produced for too many users
+type Storm struct {
+	EventType  string            `json:"event_type"`
+	Metadata   map[string]string `json:"metadata,omitempty"` // Values of the configured metadata keys
+	Users      int               `json:"users"`
+	WindowMs   int64             `json:"window_ms"`
+	DetectedAt int64             `json:"detected_at"`
+	Paused     bool              `json:"paused"`
+}
+
+// Users seen for a fingerprint since start
+type window struct {
+	start time.Time
+	users map[string]struct{}
+}
+
+// Creates a new storm detector
+func NewDetector(cfg Config) *Detector {
+	return &Detector{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.WebhookTimeout},
+		windows:  make(map[string]*window),
+		storms:   make(map[string]*Storm),
+		paused:   make(map[string]bool),
+		released: make(map[string]time.Time),
+		changed:  make(chan struct{}),
+		pruned:   time.Now(),
+	}
+}
+
+// Observe counts the notification and reports whether its event type is
+// paused, in which case it must be held instead of prioritized
+func (d *Detector) Observe(notification *models.NotificationEvent) bool {
+	if d.cfg.Threshold <= 0 {
+		return false
+	}
+
+	now := time.Now()
+	metadata := d.metadataOf(notification)
+	fingerprint := fingerprintOf(notification.EventType, metadata)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune(now)
+	if d.paused[notification.EventType] {
+		return true
+	}
+
+	// Users of an already detected storm no longer need counting
+	if d.storms[fingerprint] != nil {
+		return false
+	}
+
+	w, exists := d.windows[fingerprint]
+	if !exists || now.Sub(w.start) > d.cfg.Window {
+		w = &window{start: now, users: make(map[string]struct{})}
+		d.windows[fingerprint] = w
+	}
+	w.users[notification.UserID] = struct{}{}
+
+	if len(w.users) < d.cfg.Threshold {
+		return false
+	}
+	delete(d.windows, fingerprint)
+
+	// Pause unless an operator recently decided the event is legitimate
+	until, released := d.released[notification.EventType]
+	released = released && now.Before(until)
+	storm := &Storm{
+		EventType:  notification.EventType,
+		Metadata:   metadata,
+		Users:      len(w.users),
+		WindowMs:   d.cfg.Window.Milliseconds(),
+		DetectedAt: now.UnixMilli(),
+		Paused:     d.cfg.AutoPause && !released,
+	}
+	d.storms[fingerprint] = storm
+	if storm.Paused {
+		d.paused[notification.EventType] = true
+	}
+
+	log.Printf("Storm detected: event type %s %v produced for %d users within %v (paused: %t)",
+		storm.EventType, storm.Metadata, storm.Users, d.cfg.Window, storm.Paused)
+	if d.cfg.WebhookURL != "" {
+		go d.alert(*storm)
+	}
+	if storm.Paused && d.cfg.Publish != nil {
+		go d.publish(Decision{EventType: storm.EventType, Paused: true, Storm: storm})
+	}
+	return storm.Paused
+}
+
+// Storms returns the detected storms that have not been released
+func (d *Detector) Storms() []Storm {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	storms := make([]Storm, 0, len(d.storms))
+	for _, storm := range d.storms {
+		storms = append(storms, *storm)
+	}
+	sort.Slice(storms, func(i, j int) bool { return storms[i].DetectedAt < storms[j].DetectedAt })
+	return storms
+}
+
+// Release resumes an event type on every instance and clears its storms. It
+// is not paused again for ReleaseFor. Reports whether the event type had any
+// storms.
+func (d *Detector) Release(eventType string) (bool, error) {
+	d.mu.Lock()
+	found := d.paused[eventType]
+	for _, storm := range d.storms {
+		found = found || storm.EventType == eventType
+	}
+	d.mu.Unlock()
+	if !found {
+		return false, nil
+	}
+
+	decision := Decision{EventType: eventType, ReleasedUntil: time.Now().Add(d.cfg.ReleaseFor).UnixMilli()}
+	if d.cfg.Publish != nil {
+		if err := d.cfg.Publish(decision); err != nil {
+			return true, fmt.Errorf("failed to share release of %s: %w", eventType, err)
+		}
+	}
+	d.Apply(decision)
+	return true, nil
+}
+
+// Apply takes over a pause or release decided by any instance
+func (d *Detector) Apply(decision Decision) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if decision.Paused {
+		if !d.paused[decision.EventType] {
+			log.Printf("Event type %s paused", decision.EventType)
+		}
+		d.paused[decision.EventType] = true
+		if decision.Storm != nil {
+			d.storms[fingerprintOf(decision.Storm.EventType, decision.Storm.Metadata)] = decision.Storm
+		}
+		return
+	}
+
+	if d.paused[decision.EventType] {
+		log.Printf("Event type %s released", decision.EventType)
+	}
+	delete(d.paused, decision.EventType)
+	for fingerprint, storm := range d.storms {
+		if storm.EventType == decision.EventType {
+			delete(d.storms, fingerprint)
+			delete(d.windows, fingerprint)
+		}
+	}
+	d.released[decision.EventType] = time.UnixMilli(decision.ReleasedUntil)
+
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// WaitReleased blocks while the event type is paused, until the context is done
+func (d *Detector) WaitReleased(ctx context.Context, eventType string) error {
+	for {
+		d.mu.Lock()
+		paused, changed := d.paused[eventType], d.changed
+		d.mu.Unlock()
+		if !paused {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// publish shares a decision, logging when it fails
+func (d *Detector) publish(decision Decision) {
+	if err := d.cfg.Publish(decision); err != nil {
+		log.Printf("Failed to share pause of %s: %v", decision.EventType, err)
+	}
+}
+
+// prune drops expired windows and releases, at most once per window
+func (d *Detector) prune(now time.Time) {
+	if now.Sub(d.pruned) < d.cfg.Window {
+		return
+	}
+	d.pruned = now
+
+	for fingerprint, w := range d.windows {
+		if now.Sub(w.start) > d.cfg.Window {
+			delete(d.windows, fingerprint)
+		}
+	}
+	for eventType, until := range d.released {
+		if now.After(until) {
+			delete(d.released, eventType)
+		}
+	}
+}
+
+// metadataOf returns the values of the configured metadata keys
+func (d *Detector) metadataOf(notification *models.NotificationEvent) map[string]string {
+	if len(d.cfg.MetadataKeys) == 0 {
+		return nil
+	}
+
+	metadata := make(map[string]string, len(d.cfg.MetadataKeys))
+	for _, key := range d.cfg.MetadataKeys {
+		if value, exists := notification.Metadata[key]; exists {
+			metadata[key] = fmt.Sprint(value)
+		}
+	}
+	return metadata
+}
+
+// fingerprintOf identifies an event by its type and metadata values
+func fingerprintOf(eventType string, metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(eventType)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "|%s=%s", key, metadata[key])
+	}
+	return b.String()
+}
+
+// alert posts a storm to the webhook
+func (d *Detector) alert(storm Storm) {
+	payload, err := json.Marshal(storm)
+	if err != nil {
+		log.Printf("Failed to marshal storm alert for %s: %v", storm.EventType, err)
+		return
+	}
+
+	resp, err := d.client.Post(d.cfg.WebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("Failed to send storm alert for %s: %v", storm.EventType, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("Storm alert for %s rejected with status %d", storm.EventType, resp.StatusCode)
+	}
+}
@@ -0,0 +1,119 @@
+package storm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// sharedLog stands in for the control topic, handing every decision to all instances
+type sharedLog struct {
+	mu        sync.Mutex
+	instances []*Detector
+}
+
+func (l *sharedLog) publish(decision Decision) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, instance := range l.instances {
+		instance.Apply(decision)
+	}
+	return nil
+}
+
+func newInstances(n int) []*Detector {
+	shared := &sharedLog{}
+	for i := 0; i < n; i++ {
+		shared.instances = append(shared.instances, NewDetector(Config{
+			Window:     time.Minute,
+			Threshold:  3,
+			AutoPause:  true,
+			ReleaseFor: time.Hour,
+			Publish:    shared.publish,
+		}))
+	}
+	return shared.instances
+}
+
+func event(eventType string, user int) *models.NotificationEvent {
+	return &models.NotificationEvent{EventType: eventType, UserID: fmt.Sprintf("user-%d", user)}
+}
+
+// waitPaused waits for the asynchronously published pause to reach a detector
+func waitPaused(t *testing.T, d *Detector, eventType string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !d.Observe(event(eventType, 0)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not paused", eventType)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPauseAndReleaseReachEveryInstance(t *testing.T) {
+	instances := newInstances(2)
+	detecting, other := instances[0], instances[1]
+
+	for user := 1; user <= 3; user++ {
+		detecting.Observe(event("payment_failed", user))
+	}
+	waitPaused(t, other, "payment_failed")
+	if other.Observe(event("comment", 1)) {
+		t.Error("other event types are paused too")
+	}
+	if storms := other.Storms(); len(storms) != 1 || storms[0].EventType != "payment_failed" {
+		t.Errorf("storms on the other instance = %+v", storms)
+	}
+
+	// A held notification waits until the release made on any instance
+	released := make(chan error, 1)
+	go func() {
+		released <- detecting.WaitReleased(context.Background(), "payment_failed")
+	}()
+	select {
+	case err := <-released:
+		t.Fatalf("wait ended while paused: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if found, err := other.Release("payment_failed"); !found || err != nil {
+		t.Fatalf("Release = %t, %v", found, err)
+	}
+	if err := <-released; err != nil {
+		t.Fatalf("waiting for the release: %v", err)
+	}
+	for i, instance := range instances {
+		if instance.Observe(event("payment_failed", 4)) || len(instance.Storms()) != 0 {
+			t.Errorf("instance %d still holds payment_failed", i)
+		}
+	}
+
+	// Released event types aren't paused again for a while, on any instance
+	for user := 10; user < 20; user++ {
+		if detecting.Observe(event("payment_failed", user)) {
+			t.Fatal("paused again right after the release")
+		}
+	}
+	if found, _ := detecting.Release("unknown"); found {
+		t.Error("released an event type without storms")
+	}
+}
+
+func TestWaitReleasedStopsWithContext(t *testing.T) {
+	d := newInstances(1)[0]
+	d.Apply(Decision{EventType: "payment_failed", Paused: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.WaitReleased(ctx, "payment_failed"); err != context.DeadlineExceeded {
+		t.Errorf("WaitReleased = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := d.WaitReleased(context.Background(), "comment"); err != nil {
+		t.Errorf("WaitReleased of an event type never paused = %v", err)
+	}
+}
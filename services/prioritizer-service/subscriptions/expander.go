@@ -0,0 +1,157 @@
+package subscriptions
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Source pages through the users following an entity
+type Source interface {
+	// Subscribers returns the subscribers after the cursor, empty for the
+	// first page, and the cursor of the next page, empty on the last one
+	Subscribers(ctx context.Context, entity models.EntityRef, after string) ([]string, string, error)
+}
+
+// Expander turns an event without a user into one notification per user it
+// goes to: its recipients first, then the subscribers of its entity
+type Expander struct {
+	source        Source        // Optional, entity subscribers aren't looked up without one
+	maxRecipients int           // Users beyond this many are left out, zero expands to all
+	interval      time.Duration // Least time between two copies, zero doesn't pace them
+
+	mu   sync.Mutex
+	next time.Time // When the next copy of any event may be produced
+}
+
+// Creates an expander looking subscribers up from source, producing at most
+// rate copies per second across all the events it expands at once, any
+// number with a rate of zero
+func NewExpander(source Source, maxRecipients, rate int) *Expander {
+	expander := &Expander{
+		source:        source,
+		maxRecipients: maxRecipients,
+	}
+	if rate > 0 {
+		expander.interval = time.Second / time.Duration(rate)
+	}
+	return expander
+}
+
+// Expands reports whether the event goes to users it names or looks up
+// rather than to a single user
+func (e *Expander) Expands(event *models.NotificationEvent) bool {
+	if event.UserID != "" {
+		return false
+	}
+	return len(event.Recipients) > 0 || (event.Entity != nil && e.source != nil)
+}
+
+// Expand calls fn with a copy of the event for every user it goes to, paced
+// to the expander's rate shared with the events expanded concurrently, and returns how many it called fn for. It stops at
+// the first error or once ctx is done. Recipients get the priority hint asked
+// for them; subscribers of the entity that are also recipients get only the
+// recipient's copy. Copies are addressed to the user and get an ID derived
+// from the event's, so processing the event again yields the same IDs.
+func (e *Expander) Expand(ctx context.Context, event *models.NotificationEvent, fn func(*models.NotificationEvent) error) (int, error) {
+	expanded := 0
+	seen := make(map[string]bool, len(event.Recipients))
+	send := func(userID, priorityHint string) (bool, error) {
+		if e.maxRecipients > 0 && expanded >= e.maxRecipients {
+			logging.ForRequest(event.RequestID).Printf("Notification %s reached the limit of %d recipients, leaving the remaining users out",
+				event.ID, e.maxRecipients)
+			return false, nil
+		}
+		if err := e.pace(ctx); err != nil {
+			return false, err
+		}
+		seen[userID] = true
+		if err := fn(recipientCopy(event, userID, priorityHint)); err != nil {
+			return false, err
+		}
+		expanded++
+		return true, nil
+	}
+
+	for _, recipient := range event.Recipients {
+		if seen[recipient.UserID] {
+			continue
+		}
+		// Recipients without a priority of their own keep the event's hint
+		hint := recipient.Priority
+		if hint == "" {
+			hint = event.PriorityHint
+		}
+		if more, err := send(recipient.UserID, hint); !more {
+			return expanded, err
+		}
+	}
+
+	if event.Entity == nil || e.source == nil {
+		return expanded, nil
+	}
+
+	after := ""
+	for {
+		subscribers, next, err := e.source.Subscribers(ctx, *event.Entity, after)
+		if err != nil {
+			return expanded, fmt.Errorf("failed to look up subscribers of %s %s: %w", event.Entity.Type, event.Entity.ID, err)
+		}
+
+		for _, userID := range subscribers {
+			if seen[userID] {
+				continue
+			}
+			if more, err := send(userID, event.PriorityHint); !more {
+				return expanded, err
+			}
+		}
+
+		if next == "" {
+			return expanded, nil
+		}
+		after = next
+	}
+}
+
+// pace waits for the next free slot of the expander's rate, taking it
+func (e *Expander) pace(ctx context.Context) error {
+	if e.interval <= 0 {
+		return nil
+	}
+
+	e.mu.Lock()
+	slot := time.Now()
+	if e.next.After(slot) {
+		slot = e.next
+	}
+	e.next = slot.Add(e.interval)
+	e.mu.Unlock()
+
+	if wait := time.Until(slot); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return nil
+}
+
+// recipientCopy addresses a copy of the event to a user. The metadata is
+// copied too, since processing adds to it.
+func recipientCopy(event *models.NotificationEvent, userID, priorityHint string) *models.NotificationEvent {
+	recipient := *event
+	recipient.ID = event.ID + ":" + userID
+	recipient.ExpandedFrom = event.ID
+	recipient.UserID = userID
+	recipient.PriorityHint = priorityHint
+	recipient.Recipients = nil
+	recipient.Metadata = maps.Clone(event.Metadata)
+	return &recipient
+}
@@ -0,0 +1,88 @@
+package subscriptions
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// pages serves subscribers a page at a time
+type pages [][]string
+
+func (p pages) Subscribers(ctx context.Context, entity models.EntityRef, after string) ([]string, string, error) {
+	page := 0
+	if after != "" {
+		page = int(after[0] - '0')
+	}
+	next := ""
+	if page+1 < len(p) {
+		next = string(rune('0' + page + 1))
+	}
+	return p[page], next, nil
+}
+
+func TestExpandPacesCopies(t *testing.T) {
+	expander := NewExpander(pages{{"u1", "u2"}, {"u3", "u4", "u5"}}, 0, 1000)
+	event := &models.NotificationEvent{ID: "n1", Entity: &models.EntityRef{Type: "thread", ID: "t1"}}
+
+	var users []string
+	start := time.Now()
+	expanded, err := expander.Expand(context.Background(), event, func(copy *models.NotificationEvent) error {
+		users = append(users, copy.UserID)
+		if copy.ID != "n1:"+copy.UserID || copy.ExpandedFrom != "n1" {
+			t.Errorf("copy for %s has ID %s, expanded from %q", copy.UserID, copy.ID, copy.ExpandedFrom)
+		}
+		return nil
+	})
+	if err != nil || expanded != 5 {
+		t.Fatalf("Expand = %d, %v", expanded, err)
+	}
+	if elapsed := time.Since(start); elapsed < 4*time.Millisecond {
+		t.Errorf("5 copies at 1000 a second took %v", elapsed)
+	}
+	if len(users) != 5 || users[0] != "u1" || users[4] != "u5" {
+		t.Errorf("copies went to %v", users)
+	}
+}
+
+func TestExpandStopsWhenCancelled(t *testing.T) {
+	expander := NewExpander(nil, 0, 1)
+	event := &models.NotificationEvent{ID: "n1", Recipients: []models.Recipient{{UserID: "u1"}, {UserID: "u2"}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	expanded, err := expander.Expand(ctx, event, func(*models.NotificationEvent) error {
+		cancel()
+		return nil
+	})
+	if expanded != 1 || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expand = %d, %v", expanded, err)
+	}
+}
+
+func TestExpandSharesTheRateBetweenEvents(t *testing.T) {
+	expander := NewExpander(nil, 0, 100)
+	recipients := []models.Recipient{{UserID: "u1"}, {UserID: "u2"}, {UserID: "u3"}}
+
+	// Two events expanded at once produce 6 copies at 100 a second together
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, id := range []string{"n1", "n2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			event := &models.NotificationEvent{ID: id, Recipients: recipients}
+			if expanded, err := expander.Expand(context.Background(), event, func(*models.NotificationEvent) error { return nil }); err != nil || expanded != 3 {
+				t.Errorf("Expand(%s) = %d, %v", id, expanded, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("6 copies of two events at 100 a second took %v", elapsed)
+	}
+}
@@ -0,0 +1,64 @@
+package subscriptions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+)
+
+// HTTPSource reads subscribers from the preferences service's entity index
+type HTTPSource struct {
+	baseURL  string
+	pageSize int
+	client   *http.Client
+}
+
+// Creates a source backed by the preferences service at baseURL, reading
+// pageSize subscribers per request
+func NewHTTPSource(baseURL string, pageSize int, timeout time.Duration) *HTTPSource {
+	return &HTTPSource{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		pageSize: pageSize,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Subscribers fetches a page of the entity's subscribers
+func (s *HTTPSource) Subscribers(ctx context.Context, entity models.EntityRef, after string) ([]string, string, error) {
+	query := url.Values{"limit": {strconv.Itoa(s.pageSize)}}
+	if after != "" {
+		query.Set("after", after)
+	}
+	target := fmt.Sprintf("%s/api/v1/entities/%s/%s/subscribers?%s",
+		s.baseURL, url.PathEscape(entity.Type), url.PathEscape(entity.ID), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var page struct {
+		Subscribers []string `json:"subscribers"`
+		Next        string   `json:"next"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	return page.Subscribers, page.Next, nil
+}
@@ -0,0 +1,252 @@
+package validators
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+)
+
+// Schema is the subset of JSON Schema supported for notification metadata:
+// type, enum, required, properties, additionalProperties, items, minLength,
+// maxLength, pattern, minimum and maximum. The $schema, title and
+// description annotations are accepted, any other keyword is refused.
+type Schema struct {
+	Dialect     string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Type                 string             `json:"type,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// The parts of the event-type registry the prioritizer reads
+type registryFile struct {
+	EventTypes map[string]struct {
+		MetadataSchema json.RawMessage `json:"metadata_schema"`
+	} `json:"event_types"`
+}
+
+// LoadSchemas reads the metadata schemas of the event types in the registry
+// file shared with the rate limiter. Without a file no schemas apply. The
+// encrypted keys reach the prioritizer as ciphertext, so a schema may list
+// them among its properties but not constrain their values.
+func LoadSchemas(path string, encryptedKeys []string) (map[string]*Schema, error) {
+	schemas := make(map[string]*Schema)
+	if path == "" {
+		return schemas, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event registry: %w", err)
+	}
+
+	var registry registryFile
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse event registry: %w", err)
+	}
+
+	for eventType, info := range registry.EventTypes {
+		if len(info.MetadataSchema) == 0 || string(info.MetadataSchema) == "null" {
+			continue
+		}
+		schema, err := parseSchema(info.MetadataSchema)
+		if err == nil {
+			err = checkEncrypted(schema, encryptedKeys)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata schema for event type %s: %w", eventType, err)
+		}
+		schemas[eventType] = schema
+	}
+	return schemas, nil
+}
+
+// parseSchema decodes and compiles a schema, refusing keywords that aren't
+// supported rather than silently not enforcing them
+func parseSchema(data []byte) (*Schema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var schema Schema
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, err
+	}
+	if err := schema.compile(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// checkEncrypted refuses schemas constraining metadata entries that are
+// encrypted before they are validated
+func checkEncrypted(schema *Schema, encryptedKeys []string) error {
+	for _, key := range encryptedKeys {
+		if property := schema.Properties[key]; property != nil && property.constrains() {
+			return fmt.Errorf("property %s is encrypted before validation and can't be constrained", key)
+		}
+	}
+	return nil
+}
+
+// constrains reports whether the schema rejects any value
+func (s *Schema) constrains() bool {
+	return s.Type != "" || len(s.Enum) > 0 || len(s.Required) > 0 || len(s.Properties) > 0 ||
+		s.AdditionalProperties != nil || s.Items != nil || s.MinLength != nil || s.MaxLength != nil ||
+		s.Pattern != "" || s.Minimum != nil || s.Maximum != nil
+}
+
+// compile checks the schema and prepares its patterns
+func (s *Schema) compile() error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("unknown type %q", s.Type)
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+
+	for name, property := range s.Properties {
+		if err := property.compile(); err != nil {
+			return fmt.Errorf("property %s: %w", name, err)
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(); err != nil {
+			return fmt.Errorf("items: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a decoded JSON value against the schema, naming the
+// offending field in the error
+func (s *Schema) Validate(value any, path string) error {
+	// Values the consumer passed through undecoded are decoded to be checked
+	if raw, ok := value.(json.RawMessage); ok {
+		var decoded any
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			return fmt.Errorf("%s must be valid JSON", path)
+		}
+		value = decoded
+	}
+
+	if s.Type != "" && !hasType(value, s.Type) {
+		return fmt.Errorf("%s must be of type %s", path, s.Type)
+	}
+
+	if len(s.Enum) > 0 && !contains(s.Enum, value) {
+		return fmt.Errorf("%s must be one of %v", path, s.Enum)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, exists := v[name]; !exists {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+
+		// Check fields in a stable order so the reported error is deterministic
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, known := s.Properties[name]
+			if !known {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s is not allowed", path, name)
+				}
+				continue
+			}
+			if err := property.Validate(v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.Validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s must be at most %d characters", path, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s must match %s", path, s.Pattern)
+		}
+
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s must be at least %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s must be at most %v", path, *s.Maximum)
+		}
+	}
+
+	return nil
+}
+
+// hasType reports whether a decoded JSON value is of the JSON Schema type
+func hasType(value any, schemaType string) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		return schemaType == "object"
+	case []any:
+		return schemaType == "array"
+	case string:
+		return schemaType == "string"
+	case float64:
+		return schemaType == "number" || (schemaType == "integer" && v == math.Trunc(v))
+	case bool:
+		return schemaType == "boolean"
+	case nil:
+		return schemaType == "null"
+	default:
+		return false
+	}
+}
+
+// contains reports whether value equals one of the enum values
+func contains(enum []any, value any) bool {
+	for _, candidate := range enum {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package validators
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["post_id"],
+	"additionalProperties": false,
+	"properties": {
+		"post_id": {"type": "integer", "minimum": 1},
+		"title": {"type": "string", "minLength": 1, "maxLength": 8, "pattern": "^[a-z ]+$"},
+		"kind": {"enum": ["post", "comment", 3]},
+		"tags": {"type": "array", "items": {"type": "string"}},
+		"score": {"type": "number", "maximum": 10},
+		"author": {"type": "object", "properties": {"id": {"type": "string"}}}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := parseSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("compiling: %v", err)
+	}
+
+	tests := []struct {
+		metadata string
+		err      string
+	}{
+		{`{"post_id": 7, "title": "hello", "kind": "post", "tags": ["a"], "score": 2.5, "author": {"id": "u"}}`, ""},
+		{`{"post_id": 7, "kind": 3}`, ""},
+		{`{}`, "metadata.post_id is required"},
+		{`{"post_id": 1.5}`, "metadata.post_id must be of type integer"},
+		{`{"post_id": 0}`, "metadata.post_id must be at least 1"},
+		{`{"post_id": 1, "title": ""}`, "metadata.title must be at least 1 characters"},
+		{`{"post_id": 1, "title": "much too long"}`, "metadata.title must be at most 8 characters"},
+		{`{"post_id": 1, "title": "Hi"}`, "metadata.title must match ^[a-z ]+$"},
+		{`{"post_id": 1, "kind": "like"}`, "metadata.kind must be one of [post comment 3]"},
+		{`{"post_id": 1, "tags": ["a", 2]}`, "metadata.tags[1] must be of type string"},
+		{`{"post_id": 1, "score": 11}`, "metadata.score must be at most 10"},
+		{`{"post_id": 1, "author": {"id": 5}}`, "metadata.author.id must be of type string"},
+		{`{"post_id": 1, "extra": true}`, "metadata.extra is not allowed"},
+		{`[]`, "metadata must be of type object"},
+	}
+
+	for _, tt := range tests {
+		var metadata any
+		if err := json.Unmarshal([]byte(tt.metadata), &metadata); err != nil {
+			t.Fatalf("decoding %s: %v", tt.metadata, err)
+		}
+
+		err := schema.Validate(metadata, "metadata")
+		if got := errorString(err); got != tt.err {
+			t.Errorf("Validate(%s) = %q, want %q", tt.metadata, got, tt.err)
+		}
+
+		// Values passed through undecoded are checked the same
+		if got := errorString(schema.Validate(json.RawMessage(tt.metadata), "metadata")); got != tt.err {
+			t.Errorf("Validate(raw %s) = %q, want %q", tt.metadata, got, tt.err)
+		}
+	}
+}
+
+func TestLoadSchemas(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	schemas, err := LoadSchemas(write("registry.json", `{"event_types": {"comment": {"metadata_schema": `+testSchema+`}, "like": {}}}`), nil)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if len(schemas) != 1 || schemas["comment"] == nil || schemas["comment"].Properties["title"].pattern == nil {
+		t.Errorf("schemas = %v, want the compiled comment schema only", schemas)
+	}
+
+	if _, err := LoadSchemas(write("bad-type.json", `{"event_types": {"x": {"metadata_schema": {"type": "map"}}}}`), nil); err == nil {
+		t.Error("schema of an unknown type was loaded")
+	}
+	if _, err := LoadSchemas(write("bad-pattern.json", `{"event_types": {"x": {"metadata_schema": {"properties": {"a": {"pattern": "("}}}}}}`), nil); err == nil {
+		t.Error("schema with an invalid pattern was loaded")
+	}
+	for _, keyword := range []string{`"oneOf": [{"type": "string"}]`, `"$ref": "#/defs/a"`, `"format": "email"`, `"minItems": 1`} {
+		if _, err := LoadSchemas(write("unsupported.json", `{"event_types": {"x": {"metadata_schema": {"properties": {"a": {`+keyword+`}}}}}}`), nil); err == nil {
+			t.Errorf("schema with the unsupported keyword %s was loaded", keyword)
+		}
+	}
+	if _, err := LoadSchemas(write("annotated.json", `{"event_types": {"x": {"metadata_schema": {"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "X", "properties": {"a": {"description": "A"}}}}}}`), nil); err != nil {
+		t.Errorf("schema with annotations wasn't loaded: %v", err)
+	}
+
+	// Encrypted entries arrive as ciphertext, a schema may only name them
+	encrypted := write("encrypted.json", `{"event_types": {"x": {"metadata_schema": {"additionalProperties": false, "required": ["email"], "properties": {"email": {"description": "Encrypted"}}}}}}`)
+	if _, err := LoadSchemas(encrypted, []string{"email"}); err != nil {
+		t.Errorf("schema naming an encrypted entry wasn't loaded: %v", err)
+	}
+	constrained := write("constrained.json", `{"event_types": {"x": {"metadata_schema": {"properties": {"email": {"type": "string", "pattern": "@"}}}}}}`)
+	if _, err := LoadSchemas(constrained, []string{"email"}); err == nil {
+		t.Error("schema constraining an encrypted entry was loaded")
+	}
+	if schemas, err := LoadSchemas("", nil); err != nil || len(schemas) != 0 {
+		t.Errorf("LoadSchemas without a file = %v, %v, want none", schemas, err)
+	}
+}
+
+func FuzzSchemaValidate(f *testing.F) {
+	f.Add([]byte(testSchema), []byte(`{"post_id": 7, "title": "hello", "tags": ["a"]}`))
+	f.Add([]byte(testSchema), []byte(`{"post_id": "7", "extra": [1, {"a": null}]}`))
+	f.Add([]byte(`{"type": "array", "items": {"enum": [1, "a", null, {"b": [true]}]}}`), []byte(`[1, "a", null, {"b": [true]}]`))
+	f.Add([]byte(`{"type": "string", "pattern": "^\\p{L}+$", "maxLength": 3}`), []byte(`"héé"`))
+	f.Add([]byte(`{"type": "integer", "minimum": -1e308}`), []byte(`1e308`))
+	f.Add([]byte(`{"properties": {"a": {"properties": {"b": {"type": "null"}}}}}`), []byte(`{"a": {"b": null}}`))
+
+	f.Fuzz(func(t *testing.T, schemaJSON, metadataJSON []byte) {
+		schema, err := parseSchema(schemaJSON)
+		if err != nil {
+			return
+		}
+
+		var metadata any
+		decodeErr := json.Unmarshal(metadataJSON, &metadata)
+
+		// Metadata passed through undecoded by the consumer fails validation
+		// when it isn't JSON, and is checked like decoded metadata when it is
+		rawErr := schema.Validate(json.RawMessage(metadataJSON), "metadata")
+		if decodeErr != nil {
+			if rawErr == nil {
+				t.Fatalf("invalid JSON %q passed validation", metadataJSON)
+			}
+			return
+		}
+		if got, want := errorString(rawErr), errorString(schema.Validate(metadata, "metadata")); got != want {
+			t.Fatalf("raw metadata validated to %q, decoded to %q", got, want)
+		}
+	})
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
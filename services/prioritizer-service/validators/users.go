@@ -0,0 +1,135 @@
+package validators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status the preferences service reports for soft-deleted users
+const statusDeleted = "deleted"
+
+// UserStore tells whether a user exists
+type UserStore interface {
+	// Exists reports whether the user exists and has not been deleted
+	Exists(ctx context.Context, userID string) (bool, error)
+}
+
+// HTTPUserStore asks the preferences service, which answers 404 for unknown
+// and erased users and reports soft-deleted users by their status
+type HTTPUserStore struct {
+	baseURL string
+	client  *http.Client
+}
+
+// Creates a user store backed by the preferences service at baseURL
+func NewHTTPUserStore(baseURL string, timeout time.Duration) *HTTPUserStore {
+	return &HTTPUserStore{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Exists looks the user's preferences up
+func (s *HTTPUserStore) Exists(ctx context.Context, userID string) (bool, error) {
+	target := fmt.Sprintf("%s/api/v1/users/%s/preferences", s.baseURL, url.PathEscape(userID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var user struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return false, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return user.Status != statusDeleted, nil
+}
+
+// CachingUserStore remembers recent answers of another store for a limited time
+type CachingUserStore struct {
+	UserStore
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]userEntry
+}
+
+type userEntry struct {
+	exists    bool
+	expiresAt time.Time
+}
+
+// Creates a user store that caches lookups for the given TTL
+func NewCachingUserStore(store UserStore, ttl time.Duration, maxEntries int) *CachingUserStore {
+	return &CachingUserStore{
+		UserStore:  store,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]userEntry),
+	}
+}
+
+// Exists returns the cached answer when fresh, asking the store otherwise
+func (c *CachingUserStore) Exists(ctx context.Context, userID string) (bool, error) {
+	c.mu.Lock()
+	entry, cached := c.entries[userID]
+	c.mu.Unlock()
+
+	if cached && time.Now().Before(entry.expiresAt) {
+		return entry.exists, nil
+	}
+
+	exists, err := c.UserStore.Exists(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	c.store(userID, exists)
+	return exists, nil
+}
+
+// store caches an answer
+func (c *CachingUserStore) store(userID string, exists bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= c.maxEntries {
+		// Make room by dropping expired entries, skip caching if still full
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+
+	c.entries[userID] = userEntry{
+		exists:    exists,
+		expiresAt: now.Add(c.ttl),
+	}
+}
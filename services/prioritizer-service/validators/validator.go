@@ -1,26 +1,47 @@
 package validators
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/sahilsGit/scalable-notifications-service/services/prioritizer-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/failures"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
 )
 
 // NotificationValidator validates notification events
 type NotificationValidator struct {
-	// Could be extended with additional dependencies if needed, such as:
-	// - Database client for checking user existence
-	// - User preferences client
+	// Metadata schemas by event type, event types without one accept any metadata
+	schemas map[string]*Schema
+	// Optional, notifications for users it doesn't know are rejected
+	users UserStore
+	// Drop notifications for unknown users instead of dead-lettering them
+	dropUnknownUsers bool
+	// Bounds of CreatedAt
+	timestamps TimestampPolicy
+}
+
+// TimestampPolicy bounds the CreatedAt of notifications. Producers' clocks
+// drift, so timestamps up to ClockSkew in the future are set to now instead
+// of being rejected.
+type TimestampPolicy struct {
+	ClockSkew time.Duration // How far in the future CreatedAt may be
+	MaxAge    time.Duration // How old CreatedAt may be, zero accepts any age
 }
 
 // Creates a new notification validator
-func NewValidator() *NotificationValidator {
-	return &NotificationValidator{}
+func NewValidator(schemas map[string]*Schema, users UserStore, dropUnknownUsers bool, timestamps TimestampPolicy) *NotificationValidator {
+	return &NotificationValidator{
+		schemas:          schemas,
+		users:            users,
+		dropUnknownUsers: dropUnknownUsers,
+		timestamps:       timestamps,
+	}
 }
 
-// Validates a notification event
-func (v *NotificationValidator) Validate(notification *models.NotificationEvent) error {
+// Validates a notification event, normalizing its timestamp
+func (v *NotificationValidator) Validate(ctx context.Context, notification *models.NotificationEvent) error {
 	// Check for required fields
 	if notification.ID == "" {
 		return fmt.Errorf("notification ID is required")
@@ -34,16 +55,69 @@ func (v *NotificationValidator) Validate(notification *models.NotificationEvent)
 		return fmt.Errorf("event type is required")
 	}
 
-	// Validate created timestamp (not in the future)
-	if notification.CreatedAt > time.Now().Unix() {
-		return fmt.Errorf("notification timestamp is in the future")
+	if err := v.normalizeCreatedAt(notification); err != nil {
+		return err
+	}
+
+	// Validate metadata against the event type's schema, so malformed
+	// payloads don't break template rendering further down
+	if schema, exists := v.schemas[notification.EventType]; exists {
+		metadata := map[string]any(notification.Metadata)
+		if metadata == nil {
+			metadata = map[string]any{}
+		}
+		if err := schema.Validate(metadata, "metadata"); err != nil {
+			return fmt.Errorf("invalid metadata for event type %s: %w", notification.EventType, err)
+		}
+	}
+
+	// Check the user exists, so notifications for deleted users don't flow
+	// all the way to delivery. An unavailable store must not stop processing.
+	if v.users != nil {
+		exists, err := v.users.Exists(ctx, notification.UserID)
+		if err != nil {
+			logging.ForRequest(notification.RequestID).Printf("Skipping user check for notification %s: %v", notification.ID, err)
+		} else if !exists {
+			err := fmt.Errorf("user %s does not exist", notification.UserID)
+			if v.dropUnknownUsers {
+				return failures.Skipped(err)
+			}
+			return err
+		}
 	}
 
 	// Additional validations could be added here:
-	// - Check if user exists
 	// - Check if event type is valid
 	// - Validate content format based on event type
-	// - Check metadata fields
 
 	return nil
-}
\ No newline at end of file
+}
+
+// Fills in a missing CreatedAt and clamps one slightly in the future to now,
+// rejecting timestamps beyond the clock skew or older than the max age
+func (v *NotificationValidator) normalizeCreatedAt(notification *models.NotificationEvent) error {
+	logger := logging.ForRequest(notification.RequestID)
+	now := time.Now()
+
+	if notification.CreatedAt == 0 {
+		logger.Printf("Notification %s has no timestamp, using the current time", notification.ID)
+		notification.CreatedAt = now.Unix()
+		return nil
+	}
+
+	createdAt := time.Unix(notification.CreatedAt, 0)
+	if ahead := createdAt.Sub(now); ahead > 0 {
+		if ahead > v.timestamps.ClockSkew {
+			return fmt.Errorf("notification timestamp is %v in the future, more than the allowed clock skew of %v",
+				ahead.Round(time.Second), v.timestamps.ClockSkew)
+		}
+		logger.Printf("Notification %s timestamp is %v in the future, clamping it to now", notification.ID, ahead.Round(time.Second))
+		notification.CreatedAt = now.Unix()
+		return nil
+	}
+
+	if age := now.Sub(createdAt); v.timestamps.MaxAge > 0 && age > v.timestamps.MaxAge {
+		return fmt.Errorf("notification is %v old, older than the max age of %v", age.Round(time.Second), v.timestamps.MaxAge)
+	}
+	return nil
+}
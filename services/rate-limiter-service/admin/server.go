@@ -0,0 +1,359 @@
+package admin
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metering"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminaudit"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminauth"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/profiling"
+)
+
+// Admin HTTP server exposing operational endpoints
+type Server struct {
+	server      *http.Server
+	diagnostics func() any
+	readiness   map[string]func(ctx context.Context) error
+	quota       func(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error)
+	simulate    func(ctx context.Context, notification *models.PrioritizedNotification) (any, error)
+	instances   func() any
+	usage       func(ctx context.Context, from, to time.Time) ([]metering.Usage, error)
+	stats       func(ctx context.Context, userID string) (any, error)
+	warmUp      func(ctx context.Context) (any, error)
+	started     time.Time
+}
+
+// Config for the admin server
+type Config struct {
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	Diagnostics  func() any // Service specific state included in /debug/runtime, optional
+	// Dependency checks behind /ready by name, the service is ready when all pass
+	Readiness map[string]func(ctx context.Context) error
+	// Reports a user's rate-limit quota without counting, serves /quota when set
+	Quota func(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error)
+	// Reports the decision a notification would get without counting or producing it, serves /simulate when set
+	Simulate func(ctx context.Context, notification *models.PrioritizedNotification) (any, error)
+	// Reports the pipeline instances heard from on the ops topic, serves /instances when set
+	Instances func() any
+	// Reports the daily usage rollups between two days, serves /usage when set
+	Usage func(ctx context.Context, from, to time.Time) ([]metering.Usage, error)
+	// Reports what was delivered to a user, serves /delivery-stats when set
+	DeliveryStats func(ctx context.Context, userID string) (any, error)
+	// Reports where the ramps of the sending identities warming up stand, serves /warm-up when set
+	WarmUp func(ctx context.Context) (any, error)
+	// Serves the pipeline overview under /overview when set
+	Overview http.Handler
+	// Serves the dead-letter API under /dead-letters when set
+	DeadLetters http.Handler
+	// Serves the degraded-mode API under /degraded when set
+	Degraded http.Handler
+	// Serves the rate-limit profile API under /rate-limit-profiles and /rate-limit-assignments when set
+	Profiles http.Handler
+	// Serves the suppression list API under /suppression-list when set
+	SuppressionList http.Handler
+	// Serves the audit trail of admin actions under /audit when set
+	AuditTrail http.Handler
+	// Serves the tenants' rule modules under /wasm-rules when set
+	WASMRules http.Handler
+	// Serves the expression rules under /expression-rules when set
+	ExpressionRules http.Handler
+	// Records every request that may change state when set
+	Audit adminaudit.Auditor
+	// Bearer token of each operator allowed to change state, by name; empty leaves changes open
+	Operators map[string]string
+}
+
+// Creates a new admin HTTP server
+func NewServer(cfg Config) *Server {
+	mux := http.NewServeMux()
+
+	server := Server{
+		server: &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.Port),
+			Handler:      mux,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		},
+		diagnostics: cfg.Diagnostics,
+		readiness:   cfg.Readiness,
+		quota:       cfg.Quota,
+		simulate:    cfg.Simulate,
+		instances:   cfg.Instances,
+		usage:       cfg.Usage,
+		stats:       cfg.DeliveryStats,
+		warmUp:      cfg.WarmUp,
+		started:     time.Now(),
+	}
+
+	// Routes
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/health", server.handleHealth)
+	mux.HandleFunc("GET /version", server.handleVersion)
+	mux.HandleFunc("/ready", server.handleReady)
+	mux.HandleFunc("/debug/runtime", server.handleRuntime)
+	if server.quota != nil {
+		mux.HandleFunc("GET /quota/{userID}", server.handleQuota)
+	}
+	if server.simulate != nil {
+		mux.HandleFunc("GET /simulate/{userID}", server.handleSimulate)
+	}
+	if server.instances != nil {
+		mux.HandleFunc("GET /instances", server.handleInstances)
+	}
+	if server.usage != nil {
+		mux.HandleFunc("GET /usage", server.handleUsage)
+	}
+	if server.stats != nil {
+		mux.HandleFunc("GET /delivery-stats/{userID}", server.handleDeliveryStats)
+	}
+	if server.warmUp != nil {
+		mux.HandleFunc("GET /warm-up", server.handleWarmUp)
+	}
+	if cfg.Overview != nil {
+		mux.Handle("/overview", cfg.Overview)
+		mux.Handle("/overview/", cfg.Overview)
+	}
+	if cfg.DeadLetters != nil {
+		mux.Handle("/dead-letters", cfg.DeadLetters)
+		mux.Handle("/dead-letters/", cfg.DeadLetters)
+		mux.Handle("/dead-letters-audit", cfg.DeadLetters)
+	}
+	if cfg.Degraded != nil {
+		mux.Handle("/degraded", cfg.Degraded)
+		mux.Handle("/degraded/", cfg.Degraded)
+	}
+	if cfg.Profiles != nil {
+		mux.Handle("/rate-limit-profiles", cfg.Profiles)
+		mux.Handle("/rate-limit-profiles/", cfg.Profiles)
+		mux.Handle("/rate-limit-assignments", cfg.Profiles)
+		mux.Handle("/rate-limit-assignments/", cfg.Profiles)
+	}
+	if cfg.SuppressionList != nil {
+		mux.Handle("/suppression-list", cfg.SuppressionList)
+		mux.Handle("/suppression-list/", cfg.SuppressionList)
+	}
+	if cfg.AuditTrail != nil {
+		mux.Handle("/audit", cfg.AuditTrail)
+	}
+	if cfg.WASMRules != nil {
+		mux.Handle("/wasm-rules", cfg.WASMRules)
+		mux.Handle("/wasm-rules/", cfg.WASMRules)
+	}
+	if cfg.ExpressionRules != nil {
+		mux.Handle("/expression-rules", cfg.ExpressionRules)
+	}
+
+	// Profiling
+	profiling.Register(mux)
+
+	if cfg.Audit != nil {
+		server.server.Handler = adminaudit.Audited(mux, cfg.Audit)
+	}
+	server.server.Handler = adminauth.Authenticated(server.server.Handler, cfg.Operators)
+	return &server
+}
+
+// Starts the admin HTTP server
+func (s *Server) Start() error {
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Gracefully shuts down the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Handles health check requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+		"time":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// Handles readiness checks, failing while any dependency is unreachable
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	checks := make(map[string]string, len(s.readiness))
+	for name, check := range s.readiness {
+		if err := check(ctx); err != nil {
+			checks[name] = err.Error()
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		checks[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"ready":  status == http.StatusOK,
+		"checks": checks,
+	})
+}
+
+// Handles quota requests, checking the user's counters for the priority,
+// event type and tenant given as query parameters like a notification would be
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	notification := &models.PrioritizedNotification{
+		NotificationEvent: models.NotificationEvent{
+			UserID:    r.PathValue("userID"),
+			EventType: r.URL.Query().Get("event_type"),
+		},
+		Priority: r.URL.Query().Get("priority"),
+	}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		notification.Metadata = map[string]any{models.MetadataTenant: tenant}
+	}
+
+	quota, err := s.quota(r.Context(), notification)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to check quota: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quota)
+}
+
+// Handles requests for what was delivered to a user
+func (s *Server) handleDeliveryStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.stats(r.Context(), r.PathValue("userID"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read delivery stats: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Handles requests for the ramps of the sending identities warming up
+func (s *Server) handleWarmUp(w http.ResponseWriter, r *http.Request) {
+	status, err := s.warmUp(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read warm-up: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// Handles requests for the decision a notification would get
+func (s *Server) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	notification := &models.PrioritizedNotification{
+		NotificationEvent: models.NotificationEvent{
+			UserID:    r.PathValue("userID"),
+			EventType: r.URL.Query().Get("event_type"),
+		},
+		Priority: r.URL.Query().Get("priority"),
+	}
+	if notification.EventType == "" {
+		http.Error(w, "event_type is required", http.StatusBadRequest)
+		return
+	}
+
+	simulation, err := s.simulate(r.Context(), notification)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to simulate: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(simulation)
+}
+
+// Handles requests for the pipeline instances and whether they are alive
+func (s *Server) handleInstances(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.instances())
+}
+
+// Handles usage requests for the days between the from and to query
+// parameters, today by default, as JSON or with format=csv as CSV
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	today := time.Now().UTC().Format(metering.DayLayout)
+
+	from, err := time.Parse(metering.DayLayout, cmp.Or(query.Get("from"), today))
+	if err != nil {
+		http.Error(w, "from must be a date like 2006-01-02", http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse(metering.DayLayout, cmp.Or(query.Get("to"), query.Get("from"), today))
+	if err != nil || to.Before(from) {
+		http.Error(w, "to must be a date like 2006-01-02, not before from", http.StatusBadRequest)
+		return
+	}
+
+	usage, err := s.usage(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read usage: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	if query.Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=usage-%s-%s.csv", from.Format(metering.DayLayout), to.Format(metering.DayLayout)))
+	out := csv.NewWriter(w)
+	out.Write([]string{"day", "api_key_id", "tenant", "accepted", "delivered"})
+	for _, u := range usage {
+		out.Write([]string{u.Day, u.APIKeyID, u.Tenant, strconv.FormatInt(u.Accepted, 10), strconv.FormatInt(u.Delivered, 10)})
+	}
+	out.Flush()
+}
+
+// Handles requests for the version of the running code
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
+
+// Handles requests for runtime statistics
+func (s *Server) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := map[string]any{
+		"uptime_seconds":   time.Since(s.started).Seconds(),
+		"goroutines":       runtime.NumGoroutine(),
+		"gomaxprocs":       runtime.GOMAXPROCS(0),
+		"heap_alloc_bytes": mem.HeapAlloc,
+		"heap_objects":     mem.HeapObjects,
+		"num_gc":           mem.NumGC,
+		"gc_pause_total_s": time.Duration(mem.PauseTotalNs).Seconds(),
+	}
+	stats["build"] = buildinfo.Get()
+	if s.diagnostics != nil {
+		stats["service"] = s.diagnostics()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,308 @@
+package anomaly
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// Dimensions volumes are tracked by
+const (
+	DimensionEventType = "event_type"
+	DimensionTenant    = "tenant"
+)
+
+// Kinds of anomalies
+const (
+	KindSpike    = "spike"
+	KindDrop     = "drop"
+	KindResolved = "resolved"
+)
+
+// Detector flags unusual notification volumes per event type and tenant.
+// Settled notifications are counted per window, and every window's count is
+// compared to an exponentially weighted average of the windows before it.
+// Counts are those of this instance, so the baselines follow its share of
+// the traffic.
+type Detector struct {
+	window        time.Duration
+	alpha         float64 // Weight of the latest window in the baseline
+	warmup        int
+	minBaseline   float64
+	spikeFactor   float64
+	dropFactor    float64
+	maxKeys       int
+	notifyResolve bool
+	webhookURL    string
+	client        *http.Client
+
+	mu      sync.Mutex
+	current map[volumeKey]int64
+	series  map[volumeKey]*baseline
+
+	alerts chan Anomaly
+	sender sync.WaitGroup
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Config for the detector
+type Config struct {
+	Window          time.Duration // Length of the windows volumes are counted in
+	BaselineWindows int           // Windows the baseline roughly averages over
+	WarmupWindows   int           // Windows a series is observed before it's judged
+	MinBaseline     float64       // Series with a lower baseline are too quiet to judge
+	SpikeFactor     float64       // A window above the baseline times this is a spike
+	DropFactor      float64       // A window below the baseline divided by this is a drop
+	MaxKeys         int           // Most series tracked, new ones are ignored beyond
+	NotifyResolved  bool          // Also alert when a series is back to normal
+	WebhookURL      string        // Optional endpoint notified of every anomaly
+	WebhookTimeout  time.Duration
+}
+
+// Anomaly describes a window whose volume was far off its baseline
+type Anomaly struct {
+	Dimension     string  `json:"dimension"`
+	Value         string  `json:"value"`
+	Kind          string  `json:"kind"`
+	Count         int64   `json:"count"`
+	Baseline      float64 `json:"baseline"`
+	WindowSeconds float64 `json:"window_seconds"`
+	DetectedAt    int64   `json:"detected_at"`
+}
+
+type volumeKey struct {
+	dimension string
+	value     string
+}
+
+type baseline struct {
+	mean    float64
+	windows int
+	state   string // Kind of the ongoing anomaly, empty while normal
+}
+
+// NewDetector creates a detector and starts evaluating windows in the background
+func NewDetector(cfg Config) (*Detector, error) {
+	if cfg.Window <= 0 {
+		return nil, fmt.Errorf("anomaly window must be positive")
+	}
+	if cfg.BaselineWindows < 1 {
+		return nil, fmt.Errorf("anomaly baseline windows must be at least 1")
+	}
+	if cfg.SpikeFactor <= 1 || cfg.DropFactor <= 1 {
+		return nil, fmt.Errorf("anomaly spike and drop factors must be greater than 1")
+	}
+
+	d := &Detector{
+		window:        cfg.Window,
+		alpha:         2 / float64(cfg.BaselineWindows+1),
+		warmup:        cfg.WarmupWindows,
+		minBaseline:   cfg.MinBaseline,
+		spikeFactor:   cfg.SpikeFactor,
+		dropFactor:    cfg.DropFactor,
+		maxKeys:       cfg.MaxKeys,
+		notifyResolve: cfg.NotifyResolved,
+		webhookURL:    cfg.WebhookURL,
+		client:        &http.Client{Timeout: cfg.WebhookTimeout},
+		current:       make(map[volumeKey]int64),
+		series:        make(map[volumeKey]*baseline),
+		done:          make(chan struct{}),
+	}
+
+	// Alerts are posted in the background so a slow webhook never blocks evaluation
+	if d.webhookURL != "" {
+		d.alerts = make(chan Anomaly, 100)
+		d.sender.Add(1)
+		go d.sendAlerts()
+	}
+
+	d.wg.Add(1)
+	go d.run()
+
+	return d, nil
+}
+
+// Record counts a settled notification in the current window. Sandbox
+// notifications are left out.
+func (d *Detector) Record(notification *models.PrioritizedNotification, delivered bool) {
+	if notification.Test {
+		return
+	}
+
+	tenant, _ := notification.Metadata[models.MetadataTenant].(string)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.count(volumeKey{dimension: DimensionEventType, value: notification.EventType})
+	if tenant != "" {
+		d.count(volumeKey{dimension: DimensionTenant, value: tenant})
+	}
+}
+
+// count adds one to a series, unless it's new and the series are capped
+func (d *Detector) count(key volumeKey) {
+	if _, tracked := d.series[key]; !tracked {
+		if _, counted := d.current[key]; !counted && d.maxKeys > 0 && len(d.series)+len(d.current) >= d.maxKeys {
+			return
+		}
+	}
+	d.current[key]++
+}
+
+// run evaluates a window every window length until the detector is closed
+func (d *Detector) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.evaluate(time.Now())
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// evaluate compares the counts of the window just ended to the baselines and
+// folds them in. Tracked series without notifications count as zero, so
+// drops to nothing are caught.
+func (d *Detector) evaluate(now time.Time) {
+	d.mu.Lock()
+	counts := d.current
+	d.current = make(map[volumeKey]int64, len(counts))
+
+	var anomalies []Anomaly
+	for key := range counts {
+		if _, tracked := d.series[key]; !tracked {
+			d.series[key] = &baseline{}
+		}
+	}
+	for key, b := range d.series {
+		count := counts[key]
+		if kind := d.judge(b, count); kind != b.state {
+			if kind != "" || d.notifyResolve {
+				reported := kind
+				if reported == "" {
+					reported = KindResolved
+				}
+				anomalies = append(anomalies, Anomaly{
+					Dimension:     key.dimension,
+					Value:         key.value,
+					Kind:          reported,
+					Count:         count,
+					Baseline:      b.mean,
+					WindowSeconds: d.window.Seconds(),
+					DetectedAt:    now.Unix(),
+				})
+			}
+			b.state = kind
+		}
+
+		// The first window seeds the baseline, later ones are averaged in
+		if b.windows == 0 {
+			b.mean = float64(count)
+		} else {
+			b.mean += d.alpha * (float64(count) - b.mean)
+		}
+		b.windows++
+
+		// Forget series that went quiet for good
+		if b.windows > d.warmup && b.mean < 0.01 {
+			delete(d.series, key)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, anomaly := range anomalies {
+		d.report(anomaly)
+	}
+}
+
+// judge returns the kind of anomaly a window's count is against the
+// baseline, empty when it's normal. A series that can't be judged, still
+// warming up or too quiet, keeps its state.
+func (d *Detector) judge(b *baseline, count int64) string {
+	if b.windows < d.warmup || b.mean < d.minBaseline {
+		return b.state
+	}
+	switch {
+	case float64(count) > b.mean*d.spikeFactor:
+		return KindSpike
+	case float64(count) < b.mean/d.dropFactor:
+		return KindDrop
+	}
+	return ""
+}
+
+// report logs, counts and queues an anomaly for the webhook
+func (d *Detector) report(anomaly Anomaly) {
+	metrics.VolumeAnomalies.WithLabelValues(anomaly.Dimension, anomaly.Kind).Inc()
+	log.Printf("Volume anomaly: %s of %s %s is %d in the last %v (baseline %.1f)",
+		anomaly.Kind, anomaly.Dimension, anomaly.Value, anomaly.Count, d.window, anomaly.Baseline)
+
+	if d.alerts == nil {
+		return
+	}
+	select {
+	case d.alerts <- anomaly:
+	default:
+		metrics.AnomalyWebhookFailures.Inc()
+		log.Printf("Anomaly alert queue full, dropping alert for %s %s", anomaly.Dimension, anomaly.Value)
+	}
+}
+
+// sendAlerts posts queued anomalies to the webhook until the detector is closed
+func (d *Detector) sendAlerts() {
+	defer d.sender.Done()
+	for anomaly := range d.alerts {
+		if err := d.post(anomaly); err != nil {
+			metrics.AnomalyWebhookFailures.Inc()
+			log.Printf("Failed to send anomaly alert for %s %s: %v", anomaly.Dimension, anomaly.Value, err)
+		}
+	}
+}
+
+// post sends a single anomaly to the webhook
+func (d *Detector) post(anomaly Anomaly) error {
+	payload, err := json.Marshal(anomaly)
+	if err != nil {
+		return fmt.Errorf("failed to marshal anomaly: %w", err)
+	}
+
+	resp, err := d.client.Post(d.webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close stops evaluating windows and flushes pending alerts. The window in
+// progress is dropped, a partial window would read as a drop.
+func (d *Detector) Close() error {
+	close(d.done)
+	d.wg.Wait()
+
+	// The evaluation loop is the only one queueing alerts, and it stopped
+	if d.alerts != nil {
+		close(d.alerts)
+		d.sender.Wait()
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Page sizes of the trail
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Handler serves the audit trail, newest first:
+//
+//	GET /audit    ?service=&actor=&action=&since=&until=&limit=
+//
+// since and until are RFC 3339 times, action a prefix of the route such as
+// "POST /dead-letters".
+func (l *Log) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /audit", l.handleTrail)
+	return mux
+}
+
+// Handles requests for the audit trail
+func (l *Log) handleTrail(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := Filter{
+		Service: query.Get("service"),
+		Actor:   query.Get("actor"),
+		Action:  query.Get("action"),
+		Limit:   defaultLimit,
+	}
+
+	var err error
+	if value := query.Get("since"); value != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "until must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 || filter.Limit > maxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	actions, err := l.Trail(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read audit trail: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"actions": actions,
+	})
+}
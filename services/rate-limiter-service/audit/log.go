@@ -0,0 +1,167 @@
+// Package audit keeps the trail of operations performed through the admin
+// APIs of the pipeline: the rate limiter's own and those the other services
+// send to the audit topic. Records are only ever appended.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// Config for the log
+type Config struct {
+	Driver     string // Optional database records are kept in, they are only logged without
+	DSN        string
+	Service    string // Recorded on the actions of this instance
+	InstanceID string
+}
+
+// Log appends admin actions to the audit database
+type Log struct {
+	db         *sql.DB
+	service    string
+	instanceID string
+	sequence   atomic.Int64 // Keeps the IDs of this instance's actions unique
+}
+
+// Filter selects the actions of the trail, empty fields select all
+type Filter struct {
+	Service string
+	Actor   string
+	Action  string // Prefix of the route, e.g. "POST /degraded"
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+// NewLog creates a log, connecting to the audit database when one is configured
+func NewLog(cfg Config) (*Log, error) {
+	l := &Log{service: cfg.Service, instanceID: cfg.InstanceID}
+	if cfg.DSN == "" {
+		return l, nil
+	}
+
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	l.db = db
+	return l, nil
+}
+
+// Record logs an action of this instance and appends it to the trail
+func (l *Log) Record(ctx context.Context, action models.AdminAction) error {
+	action.Service = l.service
+	action.InstanceID = l.instanceID
+	action.ID = fmt.Sprintf("%s-%d-%d", l.instanceID, time.Now().UnixNano(), l.sequence.Add(1))
+	return l.Append(ctx, action)
+}
+
+// Append logs an action and appends it to the trail. An action already in
+// the trail, redelivered by the audit topic, is not appended again.
+func (l *Log) Append(ctx context.Context, action models.AdminAction) error {
+	log.Printf("ADMIN AUDIT: %s on %s/%s by %s: %s %s -> %d",
+		action.Action, action.Service, action.InstanceID, action.Actor, action.Path, action.Parameters, action.Status)
+	metrics.AdminActions.WithLabelValues(action.Service).Inc()
+
+	if l.db == nil {
+		return nil
+	}
+	var parameters any
+	if len(action.Parameters) > 0 {
+		parameters = string(action.Parameters)
+	}
+	_, err := l.db.ExecContext(ctx,
+		`INSERT IGNORE INTO admin_audit (id, service, instance_id, actor, action, path, query, parameters, status, performed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+		action.ID, action.Service, action.InstanceID, action.Actor, action.Action, action.Path,
+		action.Query, parameters, action.Status, action.PerformedAt)
+	if err != nil {
+		metrics.AdminAuditFailures.Inc()
+		return fmt.Errorf("failed to record admin action: %w", err)
+	}
+	return nil
+}
+
+// Trail returns the actions matching the filter, newest first
+func (l *Log) Trail(ctx context.Context, filter Filter) ([]models.AdminAction, error) {
+	if l.db == nil {
+		return nil, errors.New("no audit database configured")
+	}
+
+	var conditions []string
+	var args []any
+	if filter.Service != "" {
+		conditions = append(conditions, "service = ?")
+		args = append(args, filter.Service)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action LIKE ?")
+		args = append(args, strings.NewReplacer("%", `\%`, "_", `\_`).Replace(filter.Action)+"%")
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "performed_at >= FROM_UNIXTIME(?)")
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "performed_at < FROM_UNIXTIME(?)")
+		args = append(args, filter.Until.Unix())
+	}
+
+	query := "SELECT id, service, instance_id, actor, action, path, query, COALESCE(parameters, ''), status, UNIX_TIMESTAMP(performed_at) FROM admin_audit"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY performed_at DESC, seq DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit trail: %w", err)
+	}
+	defer rows.Close()
+
+	actions := []models.AdminAction{}
+	for rows.Next() {
+		var action models.AdminAction
+		var parameters string
+		if err := rows.Scan(&action.ID, &action.Service, &action.InstanceID, &action.Actor, &action.Action,
+			&action.Path, &action.Query, &parameters, &action.Status, &action.PerformedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit trail: %w", err)
+		}
+		if parameters != "" {
+			action.Parameters = []byte(parameters)
+		}
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
+
+// Close closes the audit database
+func (l *Log) Close() error {
+	if l.db == nil {
+		return nil
+	}
+	return l.db.Close()
+}
@@ -0,0 +1,168 @@
+package blocklist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminauth"
+)
+
+// Largest list accepted by an import
+const maxImportSize = 32 << 20
+
+// Handler serves the suppression list API:
+//
+//	GET    /suppression-list                       entries, ?tenant=, ?global=true, ?kind=, ?limit=, ?offset=
+//	GET    /suppression-list/{kind}/{value}        one entry, ?tenant= for a tenant's list
+//	PUT    /suppression-list/{kind}/{value}        {"reason": "legal", "note": "ticket 1234"}, ?tenant=
+//	DELETE /suppression-list/{kind}/{value}        ?tenant=
+//	POST   /suppression-list/import                a provider's list, ?format=ses|sendgrid|csv, ?tenant=, ?reason=
+//
+// Without a tenant, entries are in the global list that applies to every
+// tenant. Changes are audited under the operator who sent them.
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /suppression-list", s.handleEntries)
+	mux.HandleFunc("POST /suppression-list/import", s.handleImport)
+	mux.HandleFunc("GET /suppression-list/{kind}/{value}", s.handleEntry)
+	mux.HandleFunc("PUT /suppression-list/{kind}/{value}", s.handlePutEntry)
+	mux.HandleFunc("DELETE /suppression-list/{kind}/{value}", s.handleDeleteEntry)
+	return mux
+}
+
+// Handles requests for the entries
+func (s *Store) handleEntries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := Filter{
+		Tenant:     query.Get("tenant"),
+		GlobalOnly: query.Get("global") == "true",
+		Kind:       query.Get("kind"),
+		Limit:      100,
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must not be negative", http.StatusBadRequest)
+			return
+		}
+		filter.Offset = n
+	}
+
+	entries, err := s.Entries(r.Context(), filter)
+	if err != nil {
+		writeError(w, "failed to read suppression list", err)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"entries": entries,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+// Handles requests for one entry
+func (s *Store) handleEntry(w http.ResponseWriter, r *http.Request) {
+	entry, err := s.Entry(r.Context(), r.URL.Query().Get("tenant"), r.PathValue("kind"), r.PathValue("value"))
+	if err != nil {
+		writeError(w, "failed to read suppression list entry", err)
+		return
+	}
+	writeJSON(w, entry)
+}
+
+// Handles requests to add an entry
+func (s *Store) handlePutEntry(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid entry: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	entry, err := s.Add(r.Context(), Entry{
+		Tenant:    r.URL.Query().Get("tenant"),
+		Kind:      r.PathValue("kind"),
+		Value:     r.PathValue("value"),
+		Reason:    req.Reason,
+		Source:    SourceAdmin,
+		Note:      req.Note,
+		CreatedBy: adminauth.Actor(r),
+	})
+	if err != nil {
+		writeError(w, "failed to store suppression list entry", err)
+		return
+	}
+	writeJSON(w, entry)
+}
+
+// Handles requests to remove an entry
+func (s *Store) handleDeleteEntry(w http.ResponseWriter, r *http.Request) {
+	err := s.Remove(r.Context(), r.URL.Query().Get("tenant"), r.PathValue("kind"), r.PathValue("value"), adminauth.Actor(r))
+	if err != nil {
+		writeError(w, "failed to remove suppression list entry", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles requests to import a provider's suppression list
+func (s *Store) handleImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	imp := Import{
+		Format: query.Get("format"),
+		Tenant: query.Get("tenant"),
+		Reason: query.Get("reason"),
+		By:     adminauth.Actor(r),
+	}
+
+	entries, skipped, err := s.Parse(http.MaxBytesReader(w, r.Body, maxImportSize), imp)
+	if err != nil {
+		writeError(w, "failed to read suppression list", err)
+		return
+	}
+	imported, err := s.Import(r.Context(), entries)
+	if err != nil {
+		writeError(w, "failed to import suppression list", err)
+		return
+	}
+
+	if skipped == nil {
+		skipped = []string{}
+	}
+	writeJSON(w, map[string]any{
+		"read":     len(entries) + len(skipped),
+		"imported": imported,
+		"skipped":  skipped,
+	})
+}
+
+// writeError writes an error with the status matching its cause
+func writeError(w http.ResponseWriter, message string, err error) {
+	status := http.StatusServiceUnavailable
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalid):
+		status = http.StatusBadRequest
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), status)
+}
+
+// writeJSON writes a value as a JSON response
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,172 @@
+package blocklist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Formats of the suppression lists that can be imported
+const (
+	FormatSES      = "ses"      // Output of aws sesv2 list-suppressed-destinations
+	FormatSendGrid = "sendgrid" // Bounces, spam reports or unsubscribes of the SendGrid v3 suppressions API
+	FormatCSV      = "csv"      // kind,value[,reason[,note]] records, e.g. exported from another provider
+)
+
+var formats = []string{FormatSES, FormatSendGrid, FormatCSV}
+
+// Reasons SES suppresses destinations for
+var sesReasons = map[string]string{
+	"BOUNCE":    ReasonBounce,
+	"COMPLAINT": ReasonComplaint,
+}
+
+// Import describes a list to import
+type Import struct {
+	Format string
+	Tenant string // Empty imports into the global list
+	Reason string // Reason of entries their format doesn't give one for, required for sendgrid
+	By     string
+}
+
+// Parse reads a list in the import's format into entries. Values that don't
+// normalize are skipped and returned, so one bad record doesn't hold up
+// thousands of good ones.
+func (s *Store) Parse(r io.Reader, imp Import) ([]Entry, []string, error) {
+	var records []Entry
+	var err error
+	switch imp.Format {
+	case FormatSES:
+		records, err = parseSES(r)
+	case FormatSendGrid:
+		if imp.Reason == "" {
+			return nil, nil, fmt.Errorf("%w: sendgrid lists need the reason they were exported for", ErrInvalid)
+		}
+		records, err = parseSendGrid(r)
+	case FormatCSV:
+		records, err = parseCSV(r)
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown format %q, expected one of %v", ErrInvalid, imp.Format, formats)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	source := imp.Format
+	if imp.Format == FormatCSV {
+		source = "import"
+	}
+	entries := make([]Entry, 0, len(records))
+	var skipped []string
+	for _, record := range records {
+		record.Tenant = imp.Tenant
+		record.Source = source
+		record.CreatedBy = imp.By
+		if record.Reason == "" {
+			record.Reason = imp.Reason
+		}
+		if record.Reason == "" {
+			record.Reason = ReasonManual
+		}
+		entry, err := s.validate(record)
+		if err != nil {
+			skipped = append(skipped, record.Value)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, skipped, nil
+}
+
+// parseSES reads the JSON aws sesv2 list-suppressed-destinations prints
+func parseSES(r io.Reader) ([]Entry, error) {
+	var list struct {
+		SuppressedDestinationSummaries []struct {
+			EmailAddress string `json:"EmailAddress"`
+			Reason       string `json:"Reason"`
+		} `json:"SuppressedDestinationSummaries"`
+	}
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode SES suppression list: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(list.SuppressedDestinationSummaries))
+	for _, destination := range list.SuppressedDestinationSummaries {
+		entries = append(entries, Entry{
+			Kind:   KindEmail,
+			Value:  destination.EmailAddress,
+			Reason: sesReasons[destination.Reason],
+		})
+	}
+	return entries, nil
+}
+
+// parseSendGrid reads the JSON array a SendGrid suppressions endpoint returns
+func parseSendGrid(r io.Reader) ([]Entry, error) {
+	var list []struct {
+		Email  string `json:"email"`
+		Reason string `json:"reason"` // The bounce's SMTP response, kept as a note
+	}
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode SendGrid suppression list: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(list))
+	for _, suppression := range list {
+		entries = append(entries, Entry{
+			Kind:  KindEmail,
+			Value: suppression.Email,
+			Note:  truncate(suppression.Reason, maxNoteLength),
+		})
+	}
+	return entries, nil
+}
+
+// parseCSV reads kind,value[,reason[,note]] records, after an optional
+// header naming the columns
+func parseCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var entries []Entry
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "kind") {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected kind,value[,reason[,note]]", line)
+		}
+
+		entry := Entry{Kind: strings.ToLower(record[0]), Value: record[1]}
+		if len(record) > 2 {
+			entry.Reason = strings.ToLower(record[2])
+		}
+		if len(record) > 3 {
+			entry.Note = truncate(record[3], maxNoteLength)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// truncate cuts text to at most size bytes without splitting a character
+func truncate(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	return text[:size]
+}
@@ -0,0 +1,433 @@
+// Package blocklist keeps the suppression lists: email addresses, phone
+// numbers and users that must never be contacted, after a complaint or a
+// legal request, across every tenant or for one. The lists live in MySQL
+// and are held in memory, so checking a notification against them doesn't
+// cost a query.
+package blocklist
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/contacts"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// Errors of list changes
+var (
+	ErrNotFound = errors.New("not found")
+	ErrInvalid  = errors.New("invalid entry")
+)
+
+// Kinds of entries
+const (
+	KindEmail = "email"
+	KindPhone = "phone"
+	KindUser  = "user"
+)
+
+// Reasons entries are added for
+const (
+	ReasonComplaint   = "complaint"   // The recipient marked a message as spam
+	ReasonBounce      = "bounce"      // The address or number doesn't exist
+	ReasonUnsubscribe = "unsubscribe" // The recipient unsubscribed at the provider
+	ReasonLegal       = "legal"       // A legal request, such as an erasure or a court order
+	ReasonManual      = "manual"      // Anything else an operator blocked
+)
+
+// Sources of entries
+const (
+	SourceAdmin = "admin" // Added on the admin API
+)
+
+var kinds = []string{KindEmail, KindPhone, KindUser}
+
+var reasons = []string{ReasonComplaint, ReasonBounce, ReasonUnsubscribe, ReasonLegal, ReasonManual}
+
+// Largest values and notes entries may have, the widths of their columns
+const (
+	maxValueLength = 255
+	maxNoteLength  = 1024
+)
+
+// Entry blocks an email address, a phone number or a user
+type Entry struct {
+	Tenant    string    `json:"tenant,omitempty"` // Empty for the global list
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"` // Normalized, see Normalize
+	Reason    string    `json:"reason"`
+	Source    string    `json:"source"`         // admin, or the provider whose list it was imported from
+	Note      string    `json:"note,omitempty"` // Free text, e.g. a ticket reference
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Config for the store
+type Config struct {
+	Driver             string
+	DSN                string
+	DefaultCallingCode string        // Country calling code of phone numbers given without one
+	RefreshInterval    time.Duration // How often changes made on other instances are picked up
+}
+
+// Store keeps the suppression lists, and all their entries in memory for the
+// processor. Changes apply at once on the instance they were made on and
+// within the refresh interval on others.
+type Store struct {
+	db                 *sql.DB
+	defaultCallingCode string
+	interval           time.Duration
+
+	entries atomic.Pointer[map[key]Entry]
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// key identifies an entry in memory
+type key struct {
+	tenant, kind, value string
+}
+
+// NewStore connects to the database, loads the lists and starts refreshing
+// them in the background
+func NewStore(cfg Config) (*Store, error) {
+	if cfg.RefreshInterval <= 0 {
+		return nil, fmt.Errorf("refresh interval must be positive")
+	}
+
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	s := &Store{
+		db:                 db,
+		defaultCallingCode: cfg.DefaultCallingCode,
+		interval:           cfg.RefreshInterval,
+		done:               make(chan struct{}),
+	}
+	if err := s.refresh(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// Size returns the number of entries held in memory
+func (s *Store) Size() int {
+	return len(*s.entries.Load())
+}
+
+// User returns the entry blocking a user, from the global list or the tenant's
+func (s *Store) User(tenant, userID string) (Entry, bool) {
+	return s.lookup(tenant, KindUser, userID)
+}
+
+// Contact returns the entry blocking the contact a user stored for a
+// channel, from the global list or the tenant's. Contacts of channels that
+// aren't delivered to an email address or a phone number are never blocked.
+func (s *Store) Contact(tenant, channel, contact string) (Entry, bool) {
+	var kind string
+	switch channel {
+	case models.ChannelEmail:
+		kind = KindEmail
+	case models.ChannelSMS, models.ChannelWhatsApp:
+		kind = KindPhone
+	default:
+		return Entry{}, false
+	}
+
+	value, err := s.Normalize(kind, contact)
+	if err != nil {
+		return Entry{}, false
+	}
+	return s.lookup(tenant, kind, value)
+}
+
+// lookup returns the entry of a normalized value, global entries first
+func (s *Store) lookup(tenant, kind, value string) (Entry, bool) {
+	entries := *s.entries.Load()
+	if entry, ok := entries[key{"", kind, value}]; ok {
+		return entry, true
+	}
+	if tenant == "" {
+		return Entry{}, false
+	}
+	entry, ok := entries[key{tenant, kind, value}]
+	return entry, ok
+}
+
+// Normalize returns the value an entry of the kind is stored and matched
+// under: email addresses in lowercase, as providers match them, and phone
+// numbers in E.164
+func (s *Store) Normalize(kind, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case KindEmail:
+		address, err := contacts.NormalizeEmail(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return strings.ToLower(address), nil
+	case KindPhone:
+		number, err := contacts.NormalizePhone(value, s.defaultCallingCode)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return number, nil
+	case KindUser:
+		if value == "" || len(value) > maxValueLength {
+			return "", fmt.Errorf("%w: user IDs must be between 1 and %d characters", ErrInvalid, maxValueLength)
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("%w: unknown kind %s, expected one of %v", ErrInvalid, kind, kinds)
+	}
+}
+
+// run refreshes the lists every interval until the store is closed
+func (s *Store) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		if err := s.refresh(ctx); err != nil {
+			log.Printf("Failed to refresh suppression lists, keeping the current ones: %v", err)
+		}
+		cancel()
+	}
+}
+
+// refresh loads every entry
+func (s *Store) refresh(ctx context.Context) error {
+	entries, err := s.query(ctx, Filter{})
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[key]Entry, len(entries))
+	for _, entry := range entries {
+		loaded[key{entry.Tenant, entry.Kind, entry.Value}] = entry
+	}
+	s.entries.Store(&loaded)
+	return nil
+}
+
+// Filter narrows the entries listed, zero fields match every entry
+type Filter struct {
+	Tenant     string
+	GlobalOnly bool // Only the global list, Tenant is ignored
+	Kind       string
+	Limit      int
+	Offset     int
+}
+
+// Entries returns the entries matching the filter, ordered by tenant, kind and value
+func (s *Store) Entries(ctx context.Context, filter Filter) ([]Entry, error) {
+	return s.query(ctx, filter)
+}
+
+// query returns the entries matching the filter
+func (s *Store) query(ctx context.Context, filter Filter) ([]Entry, error) {
+	query := "SELECT tenant, kind, value, reason, source, note, created_by, DATE_FORMAT(created_at, '%Y-%m-%dT%H:%i:%sZ') FROM suppression_list"
+	var conditions []string
+	var args []any
+	switch {
+	case filter.GlobalOnly:
+		conditions = append(conditions, "tenant = ''")
+	case filter.Tenant != "":
+		conditions = append(conditions, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.Kind != "" {
+		conditions = append(conditions, "kind = ?")
+		args = append(args, filter.Kind)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY tenant, kind, value"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query suppression list: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		var createdAt string
+		if err := rows.Scan(&entry.Tenant, &entry.Kind, &entry.Value, &entry.Reason, &entry.Source, &entry.Note, &entry.CreatedBy, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan suppression list entry: %w", err)
+		}
+		entry.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Entry returns the entry of a value in the global list, or the tenant's
+func (s *Store) Entry(ctx context.Context, tenant, kind, value string) (Entry, error) {
+	value, err := s.Normalize(kind, value)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var entry Entry
+	var createdAt string
+	err = s.db.QueryRowContext(ctx,
+		"SELECT tenant, kind, value, reason, source, note, created_by, DATE_FORMAT(created_at, '%Y-%m-%dT%H:%i:%sZ') FROM suppression_list WHERE tenant = ? AND kind = ? AND value = ?",
+		tenant, kind, value).Scan(&entry.Tenant, &entry.Kind, &entry.Value, &entry.Reason, &entry.Source, &entry.Note, &entry.CreatedBy, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, fmt.Errorf("%s %s: %w", kind, value, ErrNotFound)
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to query suppression list: %w", err)
+	}
+	entry.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return entry, nil
+}
+
+// Add adds an entry, replacing the reason and note of the one already there
+func (s *Store) Add(ctx context.Context, entry Entry) (Entry, error) {
+	entry, err := s.validate(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO suppression_list (tenant, kind, value, reason, source, note, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE reason = VALUES(reason), source = VALUES(source), note = VALUES(note), created_by = VALUES(created_by)`,
+		entry.Tenant, entry.Kind, entry.Value, entry.Reason, entry.Source, entry.Note, entry.CreatedBy)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to store suppression list entry: %w", err)
+	}
+
+	log.Printf("%s %s added to the suppression list of %s by %s: %s", entry.Kind, entry.Value, describe(entry.Tenant), entry.CreatedBy, entry.Reason)
+	if err := s.refresh(ctx); err != nil {
+		return Entry{}, err
+	}
+	return s.Entry(ctx, entry.Tenant, entry.Kind, entry.Value)
+}
+
+// Import adds entries in batches, keeping the ones already there as they
+// are. It returns how many entries were new.
+func (s *Store) Import(ctx context.Context, entries []Entry) (int, error) {
+	const batchSize = 500
+
+	imported := 0
+	for batch := range slices.Chunk(entries, batchSize) {
+		placeholders := make([]string, 0, len(batch))
+		args := make([]any, 0, len(batch)*7)
+		for _, entry := range batch {
+			placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?)")
+			args = append(args, entry.Tenant, entry.Kind, entry.Value, entry.Reason, entry.Source, entry.Note, entry.CreatedBy)
+		}
+
+		// Duplicates update nothing, so they count as no affected row
+		result, err := s.db.ExecContext(ctx,
+			"INSERT INTO suppression_list (tenant, kind, value, reason, source, note, created_by) VALUES "+
+				strings.Join(placeholders, ", ")+" ON DUPLICATE KEY UPDATE tenant = tenant",
+			args...)
+		if err != nil {
+			return imported, fmt.Errorf("failed to import suppression list entries: %w", err)
+		}
+		added, _ := result.RowsAffected()
+		imported += int(added)
+	}
+
+	if imported > 0 {
+		if err := s.refresh(ctx); err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+// Remove removes an entry from the global list, or the tenant's
+func (s *Store) Remove(ctx context.Context, tenant, kind, value, by string) error {
+	value, err := s.Normalize(kind, value)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM suppression_list WHERE tenant = ? AND kind = ? AND value = ?", tenant, kind, value)
+	if err != nil {
+		return fmt.Errorf("failed to delete suppression list entry: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return fmt.Errorf("%s %s: %w", kind, value, ErrNotFound)
+	}
+
+	log.Printf("%s %s removed from the suppression list of %s by %s", kind, value, describe(tenant), by)
+	return s.refresh(ctx)
+}
+
+// validate checks an entry and normalizes its value
+func (s *Store) validate(entry Entry) (Entry, error) {
+	value, err := s.Normalize(entry.Kind, entry.Value)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Value = value
+	if !slices.Contains(reasons, entry.Reason) {
+		return Entry{}, fmt.Errorf("%w: unknown reason %q, expected one of %v", ErrInvalid, entry.Reason, reasons)
+	}
+	if len(entry.Tenant) > maxValueLength || len(entry.Note) > maxNoteLength {
+		return Entry{}, fmt.Errorf("%w: tenant or note too long", ErrInvalid)
+	}
+	if entry.Source == "" {
+		entry.Source = SourceAdmin
+	}
+	return entry, nil
+}
+
+// Close stops refreshing and closes the database
+func (s *Store) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.db.Close()
+}
+
+// describe names a list for logs
+func describe(tenant string) string {
+	if tenant == "" {
+		return "every tenant"
+	}
+	return "tenant " + tenant
+}
@@ -0,0 +1,124 @@
+package bypass
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Reasons a bypass isn't honoured
+var (
+	ErrInvalid = errors.New("bypass signature invalid")
+	ErrExpired = errors.New("bypass expired")
+)
+
+// Config for the gate
+type Config struct {
+	Secret   string        // Shared with enqueue, which signs the bypasses
+	TTL      time.Duration // How long after it was issued a bypass is honoured
+	Driver   string        // Database the audit records are kept in
+	DSN      string
+	ReadOnly bool // Only verify, e.g. on the canary whose copies the primary audits
+}
+
+// Gate verifies the bypasses notifications carry and audits those honoured.
+// A notification may only skip rate limiting and opt-outs once its bypass
+// is recorded.
+type Gate struct {
+	secret   []byte
+	ttl      time.Duration
+	db       *sql.DB
+	readOnly bool
+}
+
+// NewGate creates a gate, failing without the audit database
+func NewGate(cfg Config) (*Gate, error) {
+	if cfg.Secret == "" {
+		return nil, fmt.Errorf("bypass secret must be set")
+	}
+	if cfg.TTL <= 0 {
+		return nil, fmt.Errorf("bypass TTL must be positive")
+	}
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("bypasses need the audit database")
+	}
+
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return &Gate{secret: []byte(cfg.Secret), ttl: cfg.TTL, db: db, readOnly: cfg.ReadOnly}, nil
+}
+
+// Verify checks that a notification carries a bypass signed for it and
+// issued within the TTL
+func (g *Gate) Verify(notification *models.PrioritizedNotification) error {
+	if notification.Bypass == nil || notification.Bypass.Reason == "" {
+		return ErrInvalid
+	}
+
+	expected := signature(g.secret, notification)
+	if !hmac.Equal([]byte(expected), []byte(notification.Bypass.Signature)) {
+		return ErrInvalid
+	}
+	if age := time.Since(time.Unix(notification.Bypass.IssuedAt, 0)); age > g.ttl {
+		return fmt.Errorf("%w: issued %v ago", ErrExpired, age.Round(time.Second))
+	}
+	return nil
+}
+
+// Record audits a bypass honoured for delivery to the channels
+func (g *Gate) Record(ctx context.Context, notification *models.PrioritizedNotification, channels []string) error {
+	logging.ForRequest(notification.RequestID).Printf("Bypass of notification %s to user %s by API key %s on %v: %s",
+		notification.ID, notification.UserID, notification.APIKeyID, channels, notification.Bypass.Reason)
+
+	if g.readOnly {
+		return nil
+	}
+	_, err := g.db.ExecContext(ctx,
+		"INSERT INTO bypass_audit (notification_id, request_id, user_id, event_type, api_key_id, reason, channels) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		notification.ID, notification.RequestID, notification.UserID, notification.EventType,
+		notification.APIKeyID, notification.Bypass.Reason, strings.Join(channels, ","))
+	if err != nil {
+		return fmt.Errorf("failed to record bypass: %w", err)
+	}
+	return nil
+}
+
+// Close closes the audit database
+func (g *Gate) Close() error {
+	return g.db.Close()
+}
+
+// signature is the HMAC-SHA256 enqueue signs a bypass with, binding it to
+// the notification's ID, user, event type and API key
+func signature(secret []byte, notification *models.PrioritizedNotification) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{
+		notification.ID,
+		notification.UserID,
+		notification.EventType,
+		notification.APIKeyID,
+		notification.Bypass.Reason,
+		strconv.FormatInt(notification.Bypass.IssuedAt, 10),
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
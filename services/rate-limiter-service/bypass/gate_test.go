@@ -0,0 +1,48 @@
+package bypass
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// signed returns a notification whose bypass was issued the given time ago
+func signed(secret []byte, age time.Duration) *models.PrioritizedNotification {
+	notification := &models.PrioritizedNotification{}
+	notification.ID = "n1"
+	notification.UserID = "user-001"
+	notification.EventType = "security_alert"
+	notification.APIKeyID = "key-1"
+	notification.Bypass = &models.Bypass{Reason: "breach notice", IssuedAt: time.Now().Add(-age).Unix()}
+	notification.Bypass.Signature = signature(secret, notification)
+	return notification
+}
+
+func TestVerify(t *testing.T) {
+	gate := &Gate{secret: []byte("secret"), ttl: time.Hour}
+
+	if err := gate.Verify(signed(gate.secret, time.Minute)); err != nil {
+		t.Errorf("Verify of a fresh bypass = %v", err)
+	}
+	if err := gate.Verify(signed([]byte("other"), time.Minute)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Verify of a bypass signed with another secret = %v", err)
+	}
+
+	moved := signed(gate.secret, time.Minute)
+	moved.UserID = "user-002"
+	if err := gate.Verify(moved); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Verify of a bypass moved to another user = %v", err)
+	}
+
+	if err := gate.Verify(signed(gate.secret, 2*time.Hour)); !errors.Is(err, ErrExpired) {
+		t.Errorf("Verify of a bypass older than the TTL = %v", err)
+	}
+}
+
+func TestNewGateNeedsAuditDatabase(t *testing.T) {
+	if _, err := NewGate(Config{Secret: "secret", TTL: time.Hour}); err == nil {
+		t.Error("NewGate without a database succeeded")
+	}
+}
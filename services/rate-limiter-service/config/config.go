@@ -1,61 +1,488 @@
 package config
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/anomaly"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/audit"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/blocklist"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/bypass"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/contacts"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/cost"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/degraded"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/deliverystats"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/engagement"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metering"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/preferences"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/profiles"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/ratelimiter"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/regions"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/registry"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/sla"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/slo"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/suppression"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/encryption"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/envconfig"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/exprrules"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/secrets"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/wasmrules"
 )
 
 // Holds Kafka consumer configuration
 type KafkaConsumerConfig struct {
-	Brokers          []string
-	GroupID          string
-	TopicHigh        string
-	TopicMedium      string
-	TopicLow         string
-	SessionTimeout   time.Duration
-	HeartbeatInterval time.Duration
+	Brokers                  []string
+	GroupID                  string
+	GroupVersion             string        // Appended to GroupID, bumped for deployments that must not share offsets
+	HandoverFrom             string        // Previous group whose offsets the group takes over on first start
+	HandoverTimeout          time.Duration // How long to wait for the previous group to drain
+	PreferencesTopic         string        // Preference change events used to invalidate cached preferences
+	SessionTimeout           time.Duration
+	HeartbeatInterval        time.Duration
+	Filter                   HeaderFilterConfig
+	Tenants                  []string      // Consume these tenants' copies of the priority topics instead of the shared ones
+	RetryMax                 int           // Retries of a notification failing transiently before it is dead-lettered
+	RetryBackoff             time.Duration // Wait before the first retry, doubled for every further one
+	DeadLetterTopic          string        // Topic failed notifications are parked on, empty drops them
+	RetryDelay               time.Duration // Wait before a notification that exhausted its retries is tried again later, zero disables
+	RetryDelayMax            int           // Times a notification is tried again later before it is dead-lettered
+	SpillDir                 string        // Directory of the files lanes with the spill overflow policy write to
+	SpillMaxBytes            int           // Largest size of a lane's spill file, intake waits while it is full; zero for no limit
+	MetadataPassthroughBytes int           // Metadata values longer than this are passed through undecoded, zero decodes all
+	LagPause                 LagPauseConfig
+	Watchdog                 WatchdogConfig
+	Signing                  signing.Config // Keys the signatures of consumed messages are verified with
+}
+
+// Holds the settings for pausing lower priority lanes while the others lag
+type LagPauseConfig struct {
+	Threshold  int           // Lag of the watched lanes that pauses the others, zero disables pausing
+	ResumeLag  int           // Lag of the watched lanes under which the paused lanes resume
+	Interval   time.Duration // How often the lag is checked
+	Priorities []string      // Priority levels paused under lag, the others are watched
+}
+
+// Holds the settings for restarting the sessions of lanes that stopped moving on
+type WatchdogConfig struct {
+	StallTimeout time.Duration // Time a lagging lane may go without processing a message, zero disables the watchdog
+	Interval     time.Duration // How often the lanes are checked
+}
+
+// Holds the filter a consumer applies to message headers before unmarshalling,
+// messages outside a non-empty list are skipped
+type HeaderFilterConfig struct {
+	EventTypes []string
+	Tenants    []string
+	Priorities []string
 }
 
 // Holds Kafka producer configuration
 type KafkaProducerConfig struct {
-	Brokers          []string
-	Topic            string
-	RetryMax         int
-	RequiredAcks     int
-	DeliveryReport   bool
-	Partitions       int
+	Brokers           []string
+	Topic             string
+	SandboxTopic      string // Sink for test notifications
+	RetryMax          int
+	RequiredAcks      int
+	DeliveryReport    bool
+	Partitions        int
 	ReplicationFactor int
+	SendTimeout       time.Duration
+	Signing           signing.Config // Keys produced messages are signed with
+}
+
+// Producer returns the settings the producers of the service share
+func (c KafkaProducerConfig) Producer() kafkaproducer.Config {
+	return kafkaproducer.Config{
+		Brokers:           c.Brokers,
+		RequiredAcks:      c.RequiredAcks,
+		RetryMax:          c.RetryMax,
+		Partitions:        c.Partitions,
+		ReplicationFactor: c.ReplicationFactor,
+		Signing:           c.Signing,
+	}
 }
 
 // Holds Redis configuration
 type RedisConfig struct {
-	Addr          string
-	Password      string
-	DB            int
-	WindowSeconds int
-	LimitHigh     int
-	LimitMedium   int
-	LimitLow      int
+	Addr            string
+	Password        string
+	DB              int
+	WindowSeconds   int
+	EventTypeModes  map[string]string // Rate-limit mode per event type, overrides the priority's mode
+	EventTypeLimits map[string]int    // Notifications of an event type per window, on top of the priority's limit
+	GlobalLimit     int               // Notifications per second the cluster sends to delivery, zero disables
+	KeyPrefix       string            // Namespace of every key, e.g. "notifications:"
+	HashTags        bool              // Keep all counters of a user in one Redis Cluster slot
+	MigrateKeys     bool              // Move counters from the legacy unprefixed, untagged keys at startup
+	Algorithm       string            // ratelimiter.AlgorithmSlidingLog or ratelimiter.AlgorithmSlidingWindowCounter
+	Buckets         int               // Sub-windows of the sliding window counter
+	LocalSlice      int               // Tokens reserved from Redis at once, zero disables the local cache
+	LocalSync       time.Duration     // Unused tokens go back to Redis after this long
+}
+
+// Policies for messages arriving while a priority lane's buffer is full
+const (
+	// Wait for room, pausing the partition meanwhile
+	OverflowBlock = "block"
+	// Append to a file on disk, fed into the lane once there is room
+	OverflowSpill = "spill"
+	// Drop, parking the message on the dead-letter topic for the record
+	OverflowShed = "shed"
+)
+
+// Holds the standby Kafka clusters the service fails over to when its
+// cluster is lost. The service consumes and produces on one cluster at a
+// time, the first reachable of the configured brokers and the standbys.
+type KafkaFailoverConfig struct {
+	Clusters      []string      // Standby clusters in order of preference, comma separated brokers each
+	Rewind        time.Duration // How long before the failover a lane group without offsets on the new cluster starts, zero starts it as a new group
+	CheckInterval time.Duration // How often the active cluster is probed
+	After         time.Duration // How long the active cluster must be unreachable before failing over
+}
+
+// BrokerLists returns the clusters the service may run on, most preferred first
+func (c KafkaFailoverConfig) BrokerLists(brokers []string) [][]string {
+	clusters := [][]string{brokers}
+	for _, cluster := range c.Clusters {
+		clusters = append(clusters, strings.Split(cluster, ","))
+	}
+	return clusters
+}
+
+// Holds the settings of a priority level
+type PriorityLevelConfig struct {
+	Name          string        // Priority name carried on notifications (e.g. "critical")
+	Topic         string        // Kafka topic consumed for this priority
+	Limit         int           // Per-user rate limit within the window
+	RateLimitMode string        // ratelimiter.ModeCounted or ratelimiter.ModeObserved, counted when empty
+	Weight        int           // Messages served in a row before yielding to lower levels
+	Buffer        int           // Size of the in-memory channel between consumer and scheduler
+	Overflow      string        // What happens to messages while the buffer is full, OverflowBlock when empty
+	SLO           time.Duration // End-to-end latency objective, zero disables violation tracking
+	Urgent        bool          // Delivered through snoozes, cooldowns, spacing and warm-up caps, and on in-app when no channel is enabled
+}
+
+// Holds SLA alerting configuration
+type SLAConfig struct {
+	WebhookURL     string
+	WebhookTimeout time.Duration
+}
+
+// Holds service level objective configuration. Burn rates are computed from
+// the heartbeats of the whole pipeline, zero objectives are not tracked.
+type SLOConfig struct {
+	Enabled             bool
+	EnqueueAvailability float64       // Share of enqueue requests not answered with a server error
+	Latency             float64       // Share of each priority's notifications delivered within its SLO
+	DeliverySuccess     float64       // Share of notifications that don't fail for good
+	EvaluationInterval  time.Duration // How often rates are sampled and burn rates computed
+	PageWindow          time.Duration // Long window of paging alerts, the short window is a twelfth of it
+	PageBurnRate        float64
+	TicketWindow        time.Duration // Long window of ticket alerts
+	TicketBurnRate      float64
+	WebhookURL          string // Optional endpoint notified when a burn-rate alert fires or resolves
+	WebhookTimeout      time.Duration
+}
+
+// Holds usage metering configuration, rollups are kept in the database
+type MeteringConfig struct {
+	Enabled        bool
+	FlushInterval  time.Duration // How often counts are added to the daily rollups
+	WebhookURL     string        // Optional endpoint every finished day's usage is posted to
+	WebhookTimeout time.Duration
+}
+
+// Holds volume anomaly detection configuration
+type AnomalyConfig struct {
+	Enabled         bool
+	Window          time.Duration // Length of the windows volumes are counted in
+	BaselineWindows int           // Windows the baseline roughly averages over
+	WarmupWindows   int           // Windows a series is observed before it's judged
+	MinBaseline     float64       // Series with a lower baseline per window are too quiet to judge
+	SpikeFactor     float64       // A window above the baseline times this is a spike
+	DropFactor      float64       // A window below the baseline divided by this is a drop
+	MaxKeys         int           // Most event types and tenants tracked
+	NotifyResolved  bool          // Also alert when volume is back to normal
+	WebhookURL      string        // Optional endpoint notified of every anomaly
+	WebhookTimeout  time.Duration
+}
+
+// Holds admin HTTP server configuration
+type AdminConfig struct {
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	Operators    map[string]string // Bearer token of each operator allowed to change state, by name; empty leaves changes open
+}
+
+// Holds the delay topics messages wait on until they are due. A message
+// waits in the longest tier not longer than its remaining delay.
+type DelayConfig struct {
+	Enabled      bool            // Run the delay scheduler and allow delayed retries
+	TopicPrefix  string          // Tiers are consumed from <prefix>.<tier>, e.g. notifications.delay.10m
+	Tiers        []time.Duration // Ascending, in whole seconds
+	GroupID      string
+	Tick         time.Duration // Resolution of the timing wheel
+	Slots        int           // Slots of the timing wheel, which holds messages due within Tick*Slots
+	CoalesceKeys []string      // Fields identical delayed notifications are coalesced by, none disables coalescing
+}
+
+// Holds the dead-letter API served on the admin server
+type DeadLetterAdminConfig struct {
+	Enabled bool
+	Topics  []string // Dead-letter topics that may be listed, requeued and purged
+}
+
+// Holds the pipeline overview served on the admin server for dashboards
+type OverviewConfig struct {
+	Enabled            bool
+	RawTopic           string // Topic enqueue produces to, consumed by the prioritizer
+	PrioritizerGroupID string
+	DeliveryGroupID    string   // Optional group consuming the delivery topic
+	DeadLetterTopics   []string // Recent dead letters are listed from these topics
+	RecentDeadLetters  int
+	AllowedOrigins     []string // Origins of dashboards allowed to call the API, "*" allows all
+}
+
+// Holds the audit trail of admin actions. The rate limiter records its own
+// actions and stores those other services send to the topic.
+type AuditConfig struct {
+	Topic   string // Admin actions of the other services, empty only records the rate limiter's own
+	GroupID string // Consumer group of the topic, shared by the instances
+}
+
+// Holds the bypass emergency notifications may carry
+type BypassConfig struct {
+	Secret string        // Shared with enqueue, which signs the bypasses; empty ignores all bypasses
+	TTL    time.Duration // How long after it was issued a bypass is honoured
+}
+
+// Holds the stages skipped in degraded mode from startup, the admin API
+// skips and restores them at runtime
+type DegradedConfig struct {
+	SkipStages []string // degraded.StageRateLimiting and degraded.StagePreferences
+}
+
+// Holds the engagement model that picks the channel of event types any one channel will do
+type EngagementConfig struct {
+	Enabled        bool
+	StatusTopic    string        // Opens and action clicks reported by enqueue
+	GroupID        string        // Consumer group of the status topic, shared by the instances
+	TTL            time.Duration // A user's counts are dropped after this long without activity
+	MinSends       int           // Sends on a channel before its engagement rate is trusted
+	ExplorePercent int           // Share of selections that try a channel at random
+	TokenSecret    string        // Signs the tokens opens and action clicks are reported with, shared with enqueue
+}
+
+// Holds the least intervals between two notifications to a user on a channel
+type SpacingConfig struct {
+	Intervals map[string]time.Duration // By channel, channels left out aren't spaced
+}
+
+// Holds how long delivered copies of expanded events are remembered
+type FanoutDedupeConfig struct {
+	TTL time.Duration // Zero delivers copies produced again after a retried expansion
+}
+
+// Holds the keys notifications' content was encrypted with by the enqueue service
+type EncryptionConfig struct {
+	Enabled     bool
+	ActiveKeyID string            // Key the enqueue service encrypts new notifications with
+	Keys        map[string]string // Base64 AES-256 keys by ID, old ones kept while notifications encrypted with them are in flight
+}
+
+// Holds the projection of what was delivered to each user
+type DeliveryStatsConfig struct {
+	Enabled   bool
+	TTL       time.Duration            // A user's last deliveries are dropped after this long without one
+	Cooldowns map[string]time.Duration // Least time between two deliveries to a user on a channel, by channel
+}
+
+// Holds the checks of users' email addresses and phone numbers before delivery
+type ContactsConfig struct {
+	Enabled               bool
+	DefaultCallingCode    string // Country calling code of numbers stored without one, e.g. 1; empty refuses them
+	DisposableDomainsFile string // Optional file of disposable email domains refused on top of the well-known ones
+	AllowDisposable       bool   // Deliver to disposable email addresses rather than refusing them
+	StatusTopic           string // Topic refused contacts are flagged on
+}
+
+// Holds the suppression lists of email addresses, phone numbers and users
+// never contacted, managed through the admin API
+type SuppressionListConfig struct {
+	Enabled         bool
+	RefreshInterval time.Duration // How often changes made through other instances are picked up
+}
+
+// Holds the warm-up ramps of new sending identities, email domains or SMS
+// numbers, whose daily deliveries are capped until their reputation is built
+type WarmUpConfig struct {
+	Schedules []ratelimiter.WarmUpSchedule // Empty disables warm-up caps
+}
+
+// Holds the cost weights of the channels and how much of them less urgent notifications may use
+type CostConfig struct {
+	Weights            map[string]float64 // Cost of delivering on a channel, empty disables cost-aware selection
+	Budget             float64            // Cost a notification of a non-escalating priority may run up across its channels
+	EscalatePriorities []string           // Priorities delivered on every channel whatever it costs
+}
+
+// Holds where notifications of users in each region are delivered
+type RegionsConfig struct {
+	Routes   map[string]regions.Route // Delivery topic, brokers and providers by region, empty delivers everyone through the shared topic
+	Resident []string                 // Regions whose data must stay in them, their users aren't delivered without a route
+}
+
+// Holds the sandbox tenants' WebAssembly rule modules run in. Instances
+// sharing Dir pick up each other's uploads every ReloadInterval.
+type WASMRulesConfig struct {
+	Enabled        bool
+	Dir            string        // Holds a module per tenant
+	MemoryLimit    int           // Megabytes of memory a module may grow to
+	Timeout        time.Duration // Longest an evaluation may run
+	MaxModuleBytes int           // Largest module that can be uploaded
+	ReloadInterval time.Duration
+}
+
+// Holds how notifications running out of the processing budget the
+// prioritizer gave them are handled
+type BudgetConfig struct {
+	Tight time.Duration // Engagement selection and channel cooldowns are skipped once less is left, zero never skips them
+}
+
+// Holds the expression rules changed through the admin API. Instances
+// sharing File pick up each other's changes every ReloadInterval.
+type ExpressionRulesConfig struct {
+	File           string // Holds the rules, empty disables them
+	ReloadInterval time.Duration
+}
+
+// Holds the rate-limit profiles tuned through the admin API. The limits of
+// the profile assigned to a notification's tenant in this environment take
+// precedence over the REDIS_LIMIT_<NAME> and REDIS_EVENT_TYPE_LIMITS ones.
+type ProfilesConfig struct {
+	Enabled         bool
+	Environment     string        // Environment whose assignments apply, e.g. "production" or "staging"
+	RefreshInterval time.Duration // How often changes made through other instances are picked up
 }
 
 // Holds database configuration
 type DatabaseConfig struct {
-	Driver   string
-	DSN      string
-	MaxConns int
-	MaxIdle  int
+	Driver       string
+	DSN          string        // Or a reference to a secret holding it, read again for every new connection
+	ReadDSN      string        // Optional replica preference lookups go to
+	StaleRead    time.Duration // How long after a change a user is still read from the primary
+	MaxConns     int
+	MaxIdle      int
+	QueryTimeout time.Duration
+	SlowQuery    time.Duration // Queries taking longer are logged, zero disables the log
+	CacheTTL     time.Duration // How long looked up preferences are reused, zero disables caching
+	CacheSize    int
+}
+
+// Holds event-type registry configuration
+type EventRegistryConfig struct {
+	File string // Optional JSON registry file, the built-in registry is used when empty
+}
+
+// Holds canary configuration. Primaries mirror SamplePercent of their traffic
+// to each priority topic's canary copy; an instance with Enabled set consumes
+// those copies as the canary.
+type CanaryConfig struct {
+	Enabled       bool   // Run as the canary instead of the primary
+	SamplePercent int    // Share of traffic primaries mirror, zero disables mirroring
+	TopicSuffix   string // Appended to a priority topic to name its canary copy
+	GroupID       string // Consumer group of the canary
+	KeyPrefix     string // Prefix of the canary's rate-limit counters
+}
+
+// Holds how long the service waits for MySQL, Redis and Kafka at startup
+type StartupConfig struct {
+	Preflight       bool          // Check the dependencies before creating any client
+	RetryTimeout    time.Duration // Give up after this long, zero tries once
+	RetryBackoff    time.Duration // Wait after the first failed attempt, doubled after every further one
+	RetryMaxBackoff time.Duration
 }
 
 // Holds all configuration for the service
 type Config struct {
 	KafkaConsumer   KafkaConsumerConfig
 	KafkaProducer   KafkaProducerConfig
+	KafkaFailover   KafkaFailoverConfig
 	Redis           RedisConfig
+	Profiles        ProfilesConfig
 	Database        DatabaseConfig
-	ShutdownTimeout time.Duration
+	Priorities      []PriorityLevelConfig // Ordered from most to least urgent
+	SLA             SLAConfig
+	SLO             SLOConfig
+	Metering        MeteringConfig
+	Anomaly         AnomalyConfig
+	Admin           AdminConfig
+	Overview        OverviewConfig
+	DeadLetterAdmin DeadLetterAdminConfig
+	Audit           AuditConfig
+	Delay           DelayConfig
+	Bypass          BypassConfig
+	Degraded        DegradedConfig
+	Engagement      EngagementConfig
+	DeliveryStats   DeliveryStatsConfig
+	Spacing         SpacingConfig
+	FanoutDedupe    FanoutDedupeConfig
+	Encryption      EncryptionConfig
+	Contacts        ContactsConfig
+	SuppressionList SuppressionListConfig
+	WarmUp          WarmUpConfig
+	Cost            CostConfig
+	Regions         RegionsConfig
+	Suppression     []suppression.Config  // Registered suppression rules, applied in order
+	WASMRules       WASMRulesConfig       // Tenants' rule modules, applied after the registered rules
+	ExpressionRules ExpressionRulesConfig // Applied after the registered rules, before WASMRules
+	Budget          BudgetConfig
+	EventRegistry   EventRegistryConfig
+	Canary          CanaryConfig
+	Startup         StartupConfig
+	Heartbeat       HeartbeatConfig
+	SelfTest        SelfTestConfig
+	DebugTopic      string // Topic traces of notifications sampled for debugging are sent to
+	Shutdown        ShutdownConfig
+	Secrets         secrets.Config
 	MockMode        bool
+
+	secretStore *secrets.Store // Resolves the secrets Load read, and keeps them current
+}
+
+// Returns the store the configuration's secrets were resolved from
+func (c *Config) SecretStore() *secrets.Store {
+	return c.secretStore
+}
+
+// Holds where and how often the instance announces it is alive
+type HeartbeatConfig struct {
+	Topic      string        // Internal ops topic heartbeats are published to and monitored on
+	Interval   time.Duration // Zero disables heartbeats
+	InstanceID string        // Defaults to the host name, the container ID under Docker
+}
+
+// Holds the self-test run against the instance's own dependencies
+type SelfTestConfig struct {
+	Interval time.Duration // Time between runs, zero disables the self-test
+	Timeout  time.Duration // Bound on every check
+	Failures int           // Consecutive failures of a check that make the instance unready
+	UserID   string        // Synthetic user the checks look up
+}
+
+// Holds the timeouts of the shutdown stages, run in this order
+type ShutdownConfig struct {
+	Intake time.Duration // Stop taking new work
+	Drain  time.Duration // Finish the work already taken
+	Flush  time.Duration // Flush and close the producers
+	Close  time.Duration // Close the remaining clients
 }
 
 // Provides default configuration values
@@ -63,111 +490,1305 @@ var DefaultConfig = Config{
 	KafkaConsumer: KafkaConsumerConfig{
 		Brokers:          []string{"localhost:9092"},
 		GroupID:          "rate-limiter-group",
-		TopicHigh:        "notifications.priority.high",
-		TopicMedium:      "notifications.priority.medium",
-		TopicLow:         "notifications.priority.low",
-		SessionTimeout:   30 * time.Second,
+		HandoverTimeout:  5 * time.Minute,
+		PreferencesTopic: "notifications.preferences.changes",
+		RetryMax:         3,
+		RetryBackoff:     500 * time.Millisecond,
+		DeadLetterTopic:  "notifications.priority.dlq",
+		RetryDelayMax:    3,
+		SpillDir:         "spill",
+		SpillMaxBytes:    1 << 30,
+		LagPause: LagPauseConfig{
+			ResumeLag:  100,
+			Interval:   time.Second,
+			Priorities: []string{"medium", "low"},
+		},
+		Watchdog: WatchdogConfig{
+			StallTimeout: 5 * time.Minute,
+			Interval:     30 * time.Second,
+		},
+		SessionTimeout:    30 * time.Second,
 		HeartbeatInterval: 10 * time.Second,
 	},
 	KafkaProducer: KafkaProducerConfig{
-		Brokers:          []string{"localhost:9092"},
-		Topic:            "notifications.delivery",
-		RetryMax:         3,
-		RequiredAcks:     1,
-		DeliveryReport:   true,
-		Partitions:       3,
+		Brokers:           []string{"localhost:9092"},
+		Topic:             "notifications.delivery",
+		SandboxTopic:      "notifications.delivery.sandbox",
+		RetryMax:          3,
+		RequiredAcks:      1,
+		DeliveryReport:    true,
+		Partitions:        3,
 		ReplicationFactor: 3,
+		SendTimeout:       5 * time.Second,
+	},
+	KafkaFailover: KafkaFailoverConfig{
+		Rewind:        5 * time.Minute,
+		CheckInterval: 10 * time.Second,
+		After:         time.Minute,
 	},
 	Redis: RedisConfig{
 		Addr:          "localhost:6379",
 		Password:      "",
 		DB:            0,
 		WindowSeconds: 3600, // 1 hour window for rate limiting
-		LimitHigh:     100,  // Higher limits for high priority
-		LimitMedium:   50,   // Medium limits for medium priority
-		LimitLow:      20,   // Lower limits for low priority
+		Algorithm:     ratelimiter.AlgorithmSlidingLog,
+		Buckets:       60, // 1 minute sub-windows of the hour
+		LocalSync:     time.Second,
+		// Security alerts must never be suppressed by other traffic
+		EventTypeModes: map[string]string{
+			"security_alert":     ratelimiter.ModeObserved,
+			"account_compromise": ratelimiter.ModeObserved,
+		},
+		EventTypeLimits: map[string]int{
+			"like": 20,
+		},
+	},
+	Profiles: ProfilesConfig{
+		Enabled:         false,
+		Environment:     "production",
+		RefreshInterval: 30 * time.Second,
 	},
 	Database: DatabaseConfig{
-		Driver:   "mysql",
-		DSN:      "",
-		MaxConns: 10,
-		MaxIdle:  5,
+		Driver:       "mysql",
+		DSN:          "",
+		MaxConns:     10,
+		MaxIdle:      5,
+		QueryTimeout: 2 * time.Second,
+		StaleRead:    5 * time.Second,
+		SlowQuery:    200 * time.Millisecond,
+		CacheTTL:     5 * time.Minute,
+		CacheSize:    100000,
+	},
+	Priorities: []PriorityLevelConfig{
+		{Name: "critical", Topic: "notifications.priority.critical", Limit: 200, Weight: 8, Buffer: 1000, SLO: 5 * time.Second, Urgent: true},
+		{Name: "high", Topic: "notifications.priority.high", Limit: 100, Weight: 4, Buffer: 1000, SLO: 30 * time.Second, Urgent: true},
+		{Name: "medium", Topic: "notifications.priority.medium", Limit: 50, Weight: 2, Buffer: 500, SLO: 5 * time.Minute},
+		{Name: "low", Topic: "notifications.priority.low", Limit: 20, Weight: 1, Buffer: 100, SLO: 30 * time.Minute},
+	},
+	SLA: SLAConfig{
+		WebhookURL:     "", // Violations are only counted unless a webhook is configured
+		WebhookTimeout: 5 * time.Second,
+	},
+	SLO: SLOConfig{
+		Enabled:             false,
+		EnqueueAvailability: 0.999,
+		Latency:             0.99,
+		DeliverySuccess:     0.999,
+		EvaluationInterval:  30 * time.Second,
+		PageWindow:          time.Hour,
+		PageBurnRate:        14.4, // 2% of a 30-day budget in an hour
+		TicketWindow:        6 * time.Hour,
+		TicketBurnRate:      6, // 5% of a 30-day budget in six hours
+		WebhookTimeout:      5 * time.Second,
+	},
+	Metering: MeteringConfig{
+		Enabled:        false,
+		FlushInterval:  30 * time.Second,
+		WebhookTimeout: 10 * time.Second,
+	},
+	Anomaly: AnomalyConfig{
+		Enabled:         false,
+		Window:          time.Minute,
+		BaselineWindows: 60,
+		WarmupWindows:   30,
+		MinBaseline:     20,
+		SpikeFactor:     3,
+		DropFactor:      3,
+		MaxKeys:         10000,
+		WebhookTimeout:  5 * time.Second,
 	},
-	ShutdownTimeout: 10 * time.Second,
-	MockMode:        false, // Set to true for testing without external dependencies
+	Admin: AdminConfig{
+		Port:         9090,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	},
+	Overview: OverviewConfig{
+		Enabled:            true,
+		RawTopic:           "notifications.raw",
+		PrioritizerGroupID: "prioritizer-group",
+		DeadLetterTopics:   []string{"notifications.raw.dlq", "notifications.priority.dlq"},
+		RecentDeadLetters:  20,
+	},
+	DeadLetterAdmin: DeadLetterAdminConfig{
+		Enabled: true,
+		Topics:  []string{"notifications.raw.dlq", "notifications.priority.dlq"},
+	},
+	Audit: AuditConfig{
+		Topic:   "notifications.audit",
+		GroupID: "rate-limiter-audit",
+	},
+	Delay: DelayConfig{
+		Enabled:     false,
+		TopicPrefix: "notifications.delay",
+		Tiers:       []time.Duration{5 * time.Second, time.Minute, 10 * time.Minute, time.Hour},
+		GroupID:     "delay-scheduler",
+		Tick:        100 * time.Millisecond,
+		Slots:       600,
+	},
+	Bypass: BypassConfig{
+		TTL: time.Hour,
+	},
+	Engagement: EngagementConfig{
+		StatusTopic:    "notifications.status",
+		GroupID:        "rate-limiter-engagement",
+		TTL:            30 * 24 * time.Hour,
+		MinSends:       5,
+		ExplorePercent: 10,
+	},
+	DeliveryStats: DeliveryStatsConfig{
+		TTL: 7 * 24 * time.Hour,
+	},
+	FanoutDedupe: FanoutDedupeConfig{
+		TTL: 24 * time.Hour,
+	},
+	Contacts: ContactsConfig{
+		StatusTopic: "notifications.status",
+	},
+	SuppressionList: SuppressionListConfig{
+		RefreshInterval: time.Minute,
+	},
+	Cost: CostConfig{
+		EscalatePriorities: []string{"critical", "high"},
+	},
+	WASMRules: WASMRulesConfig{
+		Dir:            "wasm-rules",
+		MemoryLimit:    16,
+		Timeout:        50 * time.Millisecond,
+		MaxModuleBytes: 4 << 20,
+		ReloadInterval: 30 * time.Second,
+	},
+	ExpressionRules: ExpressionRulesConfig{
+		ReloadInterval: 30 * time.Second,
+	},
+	Budget: BudgetConfig{
+		Tight: time.Second,
+	},
+	Canary: CanaryConfig{
+		TopicSuffix: ".canary",
+		GroupID:     "rate-limiter-group-canary",
+		KeyPrefix:   "canary:",
+	},
+	Startup: StartupConfig{
+		Preflight:       true,
+		RetryTimeout:    2 * time.Minute,
+		RetryBackoff:    time.Second,
+		RetryMaxBackoff: 15 * time.Second,
+	},
+	Heartbeat: HeartbeatConfig{
+		Topic:    "notifications.ops",
+		Interval: 10 * time.Second,
+	},
+	SelfTest: SelfTestConfig{
+		Interval: 30 * time.Second,
+		Timeout:  5 * time.Second,
+		Failures: 2,
+		UserID:   "self-test",
+	},
+	DebugTopic: "notifications.debug",
+	Shutdown: ShutdownConfig{
+		Intake: 5 * time.Second,
+		Drain:  10 * time.Second,
+		Flush:  5 * time.Second,
+		Close:  5 * time.Second,
+	},
+	Secrets: secrets.Config{
+		RefreshInterval: 5 * time.Minute,
+	},
+	MockMode: false, // Set to true for testing without external dependencies
 }
 
 // Loads configuration from environment variables
 func Load() (*Config, error) {
 	cfg := DefaultConfig
 
+	// Load secrets config first, any secret below may refer to a provider
+	envconfig.LoadStringEnv("VAULT_ADDR", &cfg.Secrets.Vault.Addr)
+	envconfig.LoadStringEnv("VAULT_TOKEN", &cfg.Secrets.Vault.Token)
+	envconfig.LoadStringEnv("VAULT_NAMESPACE", &cfg.Secrets.Vault.Namespace)
+	envconfig.LoadStringEnv("AWS_REGION", &cfg.Secrets.SSM.Region)
+	envconfig.LoadStringEnv("AWS_ACCESS_KEY_ID", &cfg.Secrets.SSM.AccessKeyID)
+	envconfig.LoadStringEnv("AWS_SECRET_ACCESS_KEY", &cfg.Secrets.SSM.SecretAccessKey)
+	envconfig.LoadStringEnv("AWS_SESSION_TOKEN", &cfg.Secrets.SSM.SessionToken)
+	envconfig.LoadStringEnv("SSM_ENDPOINT", &cfg.Secrets.SSM.Endpoint)
+	envconfig.LoadDurationEnv("SECRETS_REFRESH_INTERVAL", &cfg.Secrets.RefreshInterval)
+	store := secrets.New(cfg.Secrets)
+	cfg.secretStore = store
+
 	// Load Kafka consumer config
-	LoadJSONStringArrayEnv("KAFKA_CONSUMER_BROKERS", &cfg.KafkaConsumer.Brokers)
-	LoadStringEnv("KAFKA_CONSUMER_GROUP_ID", &cfg.KafkaConsumer.GroupID)
-	LoadStringEnv("KAFKA_CONSUMER_TOPIC_HIGH", &cfg.KafkaConsumer.TopicHigh)
-	LoadStringEnv("KAFKA_CONSUMER_TOPIC_MEDIUM", &cfg.KafkaConsumer.TopicMedium)
-	LoadStringEnv("KAFKA_CONSUMER_TOPIC_LOW", &cfg.KafkaConsumer.TopicLow)
-	LoadDurationEnv("KAFKA_CONSUMER_SESSION_TIMEOUT", &cfg.KafkaConsumer.SessionTimeout)
-	LoadDurationEnv("KAFKA_CONSUMER_HEARTBEAT_INTERVAL", &cfg.KafkaConsumer.HeartbeatInterval)
-	
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_BROKERS", &cfg.KafkaConsumer.Brokers)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_GROUP_ID", &cfg.KafkaConsumer.GroupID)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_GROUP_VERSION", &cfg.KafkaConsumer.GroupVersion)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_HANDOVER_FROM", &cfg.KafkaConsumer.HandoverFrom)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_HANDOVER_TIMEOUT", &cfg.KafkaConsumer.HandoverTimeout)
+	if cfg.KafkaConsumer.GroupVersion != "" {
+		cfg.KafkaConsumer.GroupID += "-" + cfg.KafkaConsumer.GroupVersion
+	}
+	if cfg.KafkaConsumer.HandoverFrom == cfg.KafkaConsumer.GroupID {
+		return nil, fmt.Errorf("KAFKA_CONSUMER_HANDOVER_FROM must differ from the consumer group %s", cfg.KafkaConsumer.GroupID)
+	}
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_PREFERENCES_TOPIC", &cfg.KafkaConsumer.PreferencesTopic)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_SESSION_TIMEOUT", &cfg.KafkaConsumer.SessionTimeout)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_HEARTBEAT_INTERVAL", &cfg.KafkaConsumer.HeartbeatInterval)
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_FILTER_EVENT_TYPES", &cfg.KafkaConsumer.Filter.EventTypes)
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_FILTER_TENANTS", &cfg.KafkaConsumer.Filter.Tenants)
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_FILTER_PRIORITIES", &cfg.KafkaConsumer.Filter.Priorities)
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_TENANTS", &cfg.KafkaConsumer.Tenants)
+	envconfig.LoadIntEnv("KAFKA_CONSUMER_RETRY_MAX", &cfg.KafkaConsumer.RetryMax)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_RETRY_BACKOFF", &cfg.KafkaConsumer.RetryBackoff)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_DEAD_LETTER_TOPIC", &cfg.KafkaConsumer.DeadLetterTopic)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_RETRY_DELAY", &cfg.KafkaConsumer.RetryDelay)
+	envconfig.LoadIntEnv("KAFKA_CONSUMER_RETRY_DELAY_MAX", &cfg.KafkaConsumer.RetryDelayMax)
+	envconfig.LoadStringEnv("KAFKA_CONSUMER_SPILL_DIR", &cfg.KafkaConsumer.SpillDir)
+	envconfig.LoadIntEnv("KAFKA_CONSUMER_SPILL_MAX_BYTES", &cfg.KafkaConsumer.SpillMaxBytes)
+	envconfig.LoadIntEnv("KAFKA_CONSUMER_METADATA_PASSTHROUGH_BYTES", &cfg.KafkaConsumer.MetadataPassthroughBytes)
+	envconfig.LoadIntEnv("KAFKA_CONSUMER_PAUSE_LAG", &cfg.KafkaConsumer.LagPause.Threshold)
+	envconfig.LoadIntEnv("KAFKA_CONSUMER_RESUME_LAG", &cfg.KafkaConsumer.LagPause.ResumeLag)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_PAUSE_CHECK_INTERVAL", &cfg.KafkaConsumer.LagPause.Interval)
+	envconfig.LoadJSONStringArrayEnv("KAFKA_CONSUMER_PAUSE_PRIORITIES", &cfg.KafkaConsumer.LagPause.Priorities)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_STALL_TIMEOUT", &cfg.KafkaConsumer.Watchdog.StallTimeout)
+	envconfig.LoadDurationEnv("KAFKA_CONSUMER_STALL_CHECK_INTERVAL", &cfg.KafkaConsumer.Watchdog.Interval)
+	if cfg.KafkaConsumer.Watchdog.StallTimeout > 0 && cfg.KafkaConsumer.Watchdog.Interval <= 0 {
+		return nil, fmt.Errorf("KAFKA_CONSUMER_STALL_CHECK_INTERVAL must be positive when the watchdog is enabled")
+	}
+
 	// Load Kafka producer config
-	LoadJSONStringArrayEnv("KAFKA_PRODUCER_BROKERS", &cfg.KafkaProducer.Brokers)
-	LoadStringEnv("KAFKA_PRODUCER_TOPIC", &cfg.KafkaProducer.Topic)
-	LoadIntEnv("KAFKA_PRODUCER_RETRY_MAX", &cfg.KafkaProducer.RetryMax)
-	LoadIntEnv("KAFKA_PRODUCER_REQUIRED_ACKS", &cfg.KafkaProducer.RequiredAcks)
-	LoadBoolEnv("KAFKA_PRODUCER_DELIVERY_REPORT", &cfg.KafkaProducer.DeliveryReport)
-	LoadIntEnv("KAFKA_PRODUCER_PARTITIONS", &cfg.KafkaProducer.Partitions)
-	LoadIntEnv("KAFKA_PRODUCER_REPLICATION_FACTOR", &cfg.KafkaProducer.ReplicationFactor)
-	
+	envconfig.LoadJSONStringArrayEnv("KAFKA_PRODUCER_BROKERS", &cfg.KafkaProducer.Brokers)
+	envconfig.LoadStringEnv("KAFKA_PRODUCER_TOPIC", &cfg.KafkaProducer.Topic)
+	envconfig.LoadStringEnv("KAFKA_PRODUCER_SANDBOX_TOPIC", &cfg.KafkaProducer.SandboxTopic)
+	envconfig.LoadIntEnv("KAFKA_PRODUCER_RETRY_MAX", &cfg.KafkaProducer.RetryMax)
+	envconfig.LoadIntEnv("KAFKA_PRODUCER_REQUIRED_ACKS", &cfg.KafkaProducer.RequiredAcks)
+	envconfig.LoadBoolEnv("KAFKA_PRODUCER_DELIVERY_REPORT", &cfg.KafkaProducer.DeliveryReport)
+	envconfig.LoadIntEnv("KAFKA_PRODUCER_PARTITIONS", &cfg.KafkaProducer.Partitions)
+	envconfig.LoadIntEnv("KAFKA_PRODUCER_REPLICATION_FACTOR", &cfg.KafkaProducer.ReplicationFactor)
+	envconfig.LoadDurationEnv("KAFKA_PRODUCER_SEND_TIMEOUT", &cfg.KafkaProducer.SendTimeout)
+
+	// Load Kafka message signing config, shared by the consumers and producers
+	signingCfg := signing.Config{MaxAge: 24 * time.Hour}
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "KAFKA_SIGNING_KEYS", &signingCfg.Keys); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("KAFKA_SIGNING_ACTIVE_KEY_ID", &signingCfg.ActiveKeyID)
+	envconfig.LoadBoolEnv("KAFKA_SIGNING_REQUIRED", &signingCfg.Required)
+	envconfig.LoadDurationEnv("KAFKA_SIGNING_MAX_AGE", &signingCfg.MaxAge)
+	if _, err := signingCfg.CreateKeyring(); err != nil {
+		return nil, fmt.Errorf("KAFKA_SIGNING_KEYS are invalid: %w", err)
+	}
+	if signingCfg.Required && len(signingCfg.Keys) == 0 {
+		return nil, fmt.Errorf("KAFKA_SIGNING_REQUIRED needs KAFKA_SIGNING_KEYS")
+	}
+	cfg.KafkaConsumer.Signing = signingCfg
+	cfg.KafkaProducer.Signing = signingCfg
+
+	// Load Kafka failover config
+	envconfig.LoadJSONStringArrayEnv("KAFKA_FAILOVER_CLUSTERS", &cfg.KafkaFailover.Clusters)
+	envconfig.LoadDurationEnv("KAFKA_FAILOVER_REWIND", &cfg.KafkaFailover.Rewind)
+	envconfig.LoadDurationEnv("KAFKA_FAILOVER_CHECK_INTERVAL", &cfg.KafkaFailover.CheckInterval)
+	envconfig.LoadDurationEnv("KAFKA_FAILOVER_AFTER", &cfg.KafkaFailover.After)
+	if len(cfg.KafkaFailover.Clusters) > 0 && (cfg.KafkaFailover.CheckInterval <= 0 || cfg.KafkaFailover.After <= 0) {
+		return nil, fmt.Errorf("KAFKA_FAILOVER_CHECK_INTERVAL and KAFKA_FAILOVER_AFTER must be positive")
+	}
+
 	// Load Redis config
-	LoadStringEnv("REDIS_ADDR", &cfg.Redis.Addr)
-	LoadStringEnv("REDIS_PASSWORD", &cfg.Redis.Password)
-	LoadIntEnv("REDIS_DB", &cfg.Redis.DB)
-	LoadIntEnv("REDIS_WINDOW_SECONDS", &cfg.Redis.WindowSeconds)
-	LoadIntEnv("REDIS_LIMIT_HIGH", &cfg.Redis.LimitHigh)
-	LoadIntEnv("REDIS_LIMIT_MEDIUM", &cfg.Redis.LimitMedium)
-	LoadIntEnv("REDIS_LIMIT_LOW", &cfg.Redis.LimitLow)
-	
+	envconfig.LoadStringEnv("REDIS_ADDR", &cfg.Redis.Addr)
+	if err := store.Load("REDIS_PASSWORD", &cfg.Redis.Password); err != nil {
+		return nil, err
+	}
+	envconfig.LoadIntEnv("REDIS_DB", &cfg.Redis.DB)
+	envconfig.LoadIntEnv("REDIS_WINDOW_SECONDS", &cfg.Redis.WindowSeconds)
+	envconfig.LoadJSONStringMapEnv("REDIS_EVENT_TYPE_MODES", &cfg.Redis.EventTypeModes)
+	envconfig.LoadJSONIntMapEnv("REDIS_EVENT_TYPE_LIMITS", &cfg.Redis.EventTypeLimits)
+	envconfig.LoadIntEnv("REDIS_GLOBAL_LIMIT", &cfg.Redis.GlobalLimit)
+	envconfig.LoadStringEnv("REDIS_KEY_PREFIX", &cfg.Redis.KeyPrefix)
+	envconfig.LoadBoolEnv("REDIS_HASH_TAGS", &cfg.Redis.HashTags)
+	envconfig.LoadBoolEnv("REDIS_MIGRATE_KEYS", &cfg.Redis.MigrateKeys)
+	envconfig.LoadStringEnv("REDIS_ALGORITHM", &cfg.Redis.Algorithm)
+	envconfig.LoadIntEnv("REDIS_BUCKETS", &cfg.Redis.Buckets)
+	envconfig.LoadIntEnv("REDIS_LOCAL_SLICE", &cfg.Redis.LocalSlice)
+	envconfig.LoadDurationEnv("REDIS_LOCAL_SYNC_INTERVAL", &cfg.Redis.LocalSync)
+	if cfg.Redis.LocalSlice > 0 && cfg.Redis.LocalSync <= 0 {
+		return nil, fmt.Errorf("REDIS_LOCAL_SYNC_INTERVAL must be positive when the local cache is enabled")
+	}
+	switch cfg.Redis.Algorithm {
+	case ratelimiter.AlgorithmSlidingLog:
+	case ratelimiter.AlgorithmSlidingWindowCounter:
+		if cfg.Redis.Buckets <= 0 || int64(cfg.Redis.WindowSeconds)*1000 < int64(cfg.Redis.Buckets) {
+			return nil, fmt.Errorf("cannot split a %ds window into %d buckets", cfg.Redis.WindowSeconds, cfg.Redis.Buckets)
+		}
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", cfg.Redis.Algorithm)
+	}
+	for eventType, mode := range cfg.Redis.EventTypeModes {
+		if !validRateLimitMode(mode) {
+			return nil, fmt.Errorf("event type %s has unknown rate limit mode %q", eventType, mode)
+		}
+	}
+	for eventType, limit := range cfg.Redis.EventTypeLimits {
+		if limit < 0 {
+			return nil, fmt.Errorf("REDIS_EVENT_TYPE_LIMITS of %s must not be negative", eventType)
+		}
+	}
+
+	// Load rate-limit profiles config
+	envconfig.LoadBoolEnv("RATE_LIMIT_PROFILES_ENABLED", &cfg.Profiles.Enabled)
+	envconfig.LoadStringEnv("RATE_LIMIT_ENVIRONMENT", &cfg.Profiles.Environment)
+	envconfig.LoadDurationEnv("RATE_LIMIT_PROFILES_REFRESH_INTERVAL", &cfg.Profiles.RefreshInterval)
+	if cfg.Profiles.Enabled && (cfg.Profiles.Environment == "" || cfg.Profiles.RefreshInterval <= 0) {
+		return nil, fmt.Errorf("RATE_LIMIT_ENVIRONMENT must be set and RATE_LIMIT_PROFILES_REFRESH_INTERVAL positive when profiles are enabled")
+	}
+
 	// Load Database config
-	LoadStringEnv("DB_DRIVER", &cfg.Database.Driver)
-	LoadStringEnv("DB_DSN", &cfg.Database.DSN)
-	LoadIntEnv("DB_MAX_CONNS", &cfg.Database.MaxConns)
-	LoadIntEnv("DB_MAX_IDLE", &cfg.Database.MaxIdle)
-	
+	envconfig.LoadStringEnv("DB_DRIVER", &cfg.Database.Driver)
+	envconfig.LoadStringEnv("DB_DSN", &cfg.Database.DSN)
+	envconfig.LoadStringEnv("DB_READ_DSN", &cfg.Database.ReadDSN)
+	if store.IsReference(cfg.Database.DSN) || store.IsReference(cfg.Database.ReadDSN) {
+		// The DSNs stay references, resolved by the driver for every new
+		// connection; resolving them now fails fast on a missing secret
+		for key, dsn := range map[string]string{"DB_DSN": cfg.Database.DSN, "DB_READ_DSN": cfg.Database.ReadDSN} {
+			if _, err := store.Resolve(dsn); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		var err error
+		if cfg.Database.Driver, err = store.Driver(cfg.Database.Driver); err != nil {
+			return nil, fmt.Errorf("DB_DRIVER: %w", err)
+		}
+	}
+	envconfig.LoadDurationEnv("DB_STALE_READ_WINDOW", &cfg.Database.StaleRead)
+	envconfig.LoadIntEnv("DB_MAX_CONNS", &cfg.Database.MaxConns)
+	envconfig.LoadIntEnv("DB_MAX_IDLE", &cfg.Database.MaxIdle)
+	envconfig.LoadDurationEnv("DB_QUERY_TIMEOUT", &cfg.Database.QueryTimeout)
+	envconfig.LoadDurationEnv("DB_SLOW_QUERY_THRESHOLD", &cfg.Database.SlowQuery)
+	envconfig.LoadDurationEnv("PREFERENCES_CACHE_TTL", &cfg.Database.CacheTTL)
+	envconfig.LoadIntEnv("PREFERENCES_CACHE_SIZE", &cfg.Database.CacheSize)
+
+	// Load SLA config
+	envconfig.LoadStringEnv("SLA_WEBHOOK_URL", &cfg.SLA.WebhookURL)
+	envconfig.LoadDurationEnv("SLA_WEBHOOK_TIMEOUT", &cfg.SLA.WebhookTimeout)
+
+	// Load SLO burn-rate config
+	envconfig.LoadBoolEnv("SLO_ENABLED", &cfg.SLO.Enabled)
+	envconfig.LoadFloatEnv("SLO_ENQUEUE_AVAILABILITY", &cfg.SLO.EnqueueAvailability)
+	envconfig.LoadFloatEnv("SLO_LATENCY", &cfg.SLO.Latency)
+	envconfig.LoadFloatEnv("SLO_DELIVERY_SUCCESS", &cfg.SLO.DeliverySuccess)
+	envconfig.LoadDurationEnv("SLO_EVALUATION_INTERVAL", &cfg.SLO.EvaluationInterval)
+	envconfig.LoadDurationEnv("SLO_PAGE_WINDOW", &cfg.SLO.PageWindow)
+	envconfig.LoadFloatEnv("SLO_PAGE_BURN_RATE", &cfg.SLO.PageBurnRate)
+	envconfig.LoadDurationEnv("SLO_TICKET_WINDOW", &cfg.SLO.TicketWindow)
+	envconfig.LoadFloatEnv("SLO_TICKET_BURN_RATE", &cfg.SLO.TicketBurnRate)
+	envconfig.LoadStringEnv("SLO_WEBHOOK_URL", &cfg.SLO.WebhookURL)
+	envconfig.LoadDurationEnv("SLO_WEBHOOK_TIMEOUT", &cfg.SLO.WebhookTimeout)
+
+	// Load metering config
+	envconfig.LoadBoolEnv("METERING_ENABLED", &cfg.Metering.Enabled)
+	envconfig.LoadDurationEnv("METERING_FLUSH_INTERVAL", &cfg.Metering.FlushInterval)
+	envconfig.LoadStringEnv("METERING_WEBHOOK_URL", &cfg.Metering.WebhookURL)
+	envconfig.LoadDurationEnv("METERING_WEBHOOK_TIMEOUT", &cfg.Metering.WebhookTimeout)
+
+	// Load anomaly detection config
+	envconfig.LoadBoolEnv("ANOMALY_ENABLED", &cfg.Anomaly.Enabled)
+	envconfig.LoadDurationEnv("ANOMALY_WINDOW", &cfg.Anomaly.Window)
+	envconfig.LoadIntEnv("ANOMALY_BASELINE_WINDOWS", &cfg.Anomaly.BaselineWindows)
+	envconfig.LoadIntEnv("ANOMALY_WARMUP_WINDOWS", &cfg.Anomaly.WarmupWindows)
+	envconfig.LoadFloatEnv("ANOMALY_MIN_BASELINE", &cfg.Anomaly.MinBaseline)
+	envconfig.LoadFloatEnv("ANOMALY_SPIKE_FACTOR", &cfg.Anomaly.SpikeFactor)
+	envconfig.LoadFloatEnv("ANOMALY_DROP_FACTOR", &cfg.Anomaly.DropFactor)
+	envconfig.LoadIntEnv("ANOMALY_MAX_KEYS", &cfg.Anomaly.MaxKeys)
+	envconfig.LoadBoolEnv("ANOMALY_NOTIFY_RESOLVED", &cfg.Anomaly.NotifyResolved)
+	envconfig.LoadStringEnv("ANOMALY_WEBHOOK_URL", &cfg.Anomaly.WebhookURL)
+	envconfig.LoadDurationEnv("ANOMALY_WEBHOOK_TIMEOUT", &cfg.Anomaly.WebhookTimeout)
+
+	// Load admin server config
+	envconfig.LoadIntEnv("ADMIN_PORT", &cfg.Admin.Port)
+	envconfig.LoadDurationEnv("ADMIN_READ_TIMEOUT", &cfg.Admin.ReadTimeout)
+	envconfig.LoadDurationEnv("ADMIN_WRITE_TIMEOUT", &cfg.Admin.WriteTimeout)
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "ADMIN_OPERATORS", &cfg.Admin.Operators); err != nil {
+		return nil, err
+	}
+
+	// Load pipeline overview config
+	envconfig.LoadBoolEnv("OVERVIEW_ENABLED", &cfg.Overview.Enabled)
+	envconfig.LoadStringEnv("OVERVIEW_RAW_TOPIC", &cfg.Overview.RawTopic)
+	envconfig.LoadStringEnv("OVERVIEW_PRIORITIZER_GROUP_ID", &cfg.Overview.PrioritizerGroupID)
+	envconfig.LoadStringEnv("OVERVIEW_DELIVERY_GROUP_ID", &cfg.Overview.DeliveryGroupID)
+	envconfig.LoadJSONStringArrayEnv("OVERVIEW_DEAD_LETTER_TOPICS", &cfg.Overview.DeadLetterTopics)
+	envconfig.LoadIntEnv("OVERVIEW_RECENT_DEAD_LETTERS", &cfg.Overview.RecentDeadLetters)
+	envconfig.LoadJSONStringArrayEnv("OVERVIEW_ALLOWED_ORIGINS", &cfg.Overview.AllowedOrigins)
+	if cfg.Overview.RecentDeadLetters <= 0 {
+		return nil, fmt.Errorf("OVERVIEW_RECENT_DEAD_LETTERS must be positive")
+	}
+
+	// Load delay config
+	if err := loadDelay(&cfg); err != nil {
+		return nil, err
+	}
+
+	// Load dead-letter admin config
+	envconfig.LoadBoolEnv("DLQ_ADMIN_ENABLED", &cfg.DeadLetterAdmin.Enabled)
+	envconfig.LoadJSONStringArrayEnv("DLQ_ADMIN_TOPICS", &cfg.DeadLetterAdmin.Topics)
+
+	// Load audit config
+	envconfig.LoadStringEnv("AUDIT_TOPIC", &cfg.Audit.Topic)
+	envconfig.LoadStringEnv("AUDIT_GROUP_ID", &cfg.Audit.GroupID)
+
+	// Load bypass config
+	if err := store.Load("BYPASS_SECRET", &cfg.Bypass.Secret); err != nil {
+		return nil, err
+	}
+	envconfig.LoadDurationEnv("BYPASS_TTL", &cfg.Bypass.TTL)
+
+	// Load degraded mode config
+	envconfig.LoadJSONStringArrayEnv("DEGRADED_SKIP_STAGES", &cfg.Degraded.SkipStages)
+
+	// Load engagement config
+	envconfig.LoadBoolEnv("ENGAGEMENT_ENABLED", &cfg.Engagement.Enabled)
+	envconfig.LoadStringEnv("ENGAGEMENT_STATUS_TOPIC", &cfg.Engagement.StatusTopic)
+	envconfig.LoadStringEnv("ENGAGEMENT_GROUP_ID", &cfg.Engagement.GroupID)
+	envconfig.LoadDurationEnv("ENGAGEMENT_TTL", &cfg.Engagement.TTL)
+	envconfig.LoadIntEnv("ENGAGEMENT_MIN_SENDS", &cfg.Engagement.MinSends)
+	envconfig.LoadIntEnv("ENGAGEMENT_EXPLORE_PERCENT", &cfg.Engagement.ExplorePercent)
+	if err := store.Load("ENGAGEMENT_TOKEN_SECRET", &cfg.Engagement.TokenSecret); err != nil {
+		return nil, err
+	}
+	if cfg.Engagement.Enabled && (cfg.Engagement.TTL <= 0 || cfg.Engagement.MinSends < 1) {
+		return nil, fmt.Errorf("ENGAGEMENT_TTL must be positive and ENGAGEMENT_MIN_SENDS at least 1")
+	}
+	if cfg.Engagement.Enabled && (cfg.Engagement.ExplorePercent < 0 || cfg.Engagement.ExplorePercent > 100) {
+		return nil, fmt.Errorf("ENGAGEMENT_EXPLORE_PERCENT must be between 0 and 100")
+	}
+	if cfg.Engagement.Enabled && cfg.Engagement.TokenSecret == "" {
+		return nil, fmt.Errorf("ENGAGEMENT_TOKEN_SECRET is required with ENGAGEMENT_ENABLED")
+	}
+
+	// Load delivery stats config
+	envconfig.LoadBoolEnv("DELIVERY_STATS_ENABLED", &cfg.DeliveryStats.Enabled)
+	envconfig.LoadDurationEnv("DELIVERY_STATS_TTL", &cfg.DeliveryStats.TTL)
+	var cooldowns map[string]string
+	envconfig.LoadJSONStringMapEnv("DELIVERY_STATS_CHANNEL_COOLDOWNS", &cooldowns)
+	for channel, value := range cooldowns {
+		cooldown, err := time.ParseDuration(value)
+		if err != nil || cooldown < 0 {
+			return nil, fmt.Errorf("DELIVERY_STATS_CHANNEL_COOLDOWNS of %s must be a duration like 10m", channel)
+		}
+		if cfg.DeliveryStats.Cooldowns == nil {
+			cfg.DeliveryStats.Cooldowns = make(map[string]time.Duration)
+		}
+		cfg.DeliveryStats.Cooldowns[channel] = cooldown
+	}
+	if cfg.DeliveryStats.Enabled && cfg.DeliveryStats.TTL <= 0 {
+		return nil, fmt.Errorf("DELIVERY_STATS_TTL must be positive")
+	}
+	if len(cfg.DeliveryStats.Cooldowns) > 0 && !cfg.DeliveryStats.Enabled {
+		return nil, fmt.Errorf("DELIVERY_STATS_CHANNEL_COOLDOWNS needs DELIVERY_STATS_ENABLED")
+	}
+
+	// Load spacing config
+	var intervals map[string]string
+	envconfig.LoadJSONStringMapEnv("SPACING_INTERVALS", &intervals)
+	for channel, value := range intervals {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("SPACING_INTERVALS of %s must be a duration like 5m", channel)
+		}
+		if cfg.Spacing.Intervals == nil {
+			cfg.Spacing.Intervals = make(map[string]time.Duration)
+		}
+		cfg.Spacing.Intervals[channel] = interval
+	}
+
+	// Load fan-out dedupe config
+	envconfig.LoadDurationEnv("FANOUT_DEDUPE_TTL", &cfg.FanoutDedupe.TTL)
+
+	// Load encryption config, the same keys as the enqueue service's
+	envconfig.LoadBoolEnv("ENCRYPTION_ENABLED", &cfg.Encryption.Enabled)
+	envconfig.LoadStringEnv("ENCRYPTION_ACTIVE_KEY_ID", &cfg.Encryption.ActiveKeyID)
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "ENCRYPTION_KEYS", &cfg.Encryption.Keys); err != nil {
+		return nil, err
+	}
+
+	// Load contact checks config
+	envconfig.LoadBoolEnv("CONTACTS_CHECK_ENABLED", &cfg.Contacts.Enabled)
+	envconfig.LoadStringEnv("CONTACTS_DEFAULT_CALLING_CODE", &cfg.Contacts.DefaultCallingCode)
+	envconfig.LoadStringEnv("CONTACTS_DISPOSABLE_DOMAINS_FILE", &cfg.Contacts.DisposableDomainsFile)
+	envconfig.LoadBoolEnv("CONTACTS_ALLOW_DISPOSABLE", &cfg.Contacts.AllowDisposable)
+	envconfig.LoadStringEnv("CONTACTS_STATUS_TOPIC", &cfg.Contacts.StatusTopic)
+	cfg.Contacts.DefaultCallingCode = strings.TrimPrefix(cfg.Contacts.DefaultCallingCode, "+")
+	if code := cfg.Contacts.DefaultCallingCode; code != "" && (len(code) > 3 || code[0] == '0' || strings.Trim(code, "0123456789") != "") {
+		return nil, fmt.Errorf("CONTACTS_DEFAULT_CALLING_CODE must be a country calling code like 1 or 44")
+	}
+
+	// Load suppression lists config
+	envconfig.LoadBoolEnv("SUPPRESSION_LIST_ENABLED", &cfg.SuppressionList.Enabled)
+	envconfig.LoadDurationEnv("SUPPRESSION_LIST_REFRESH_INTERVAL", &cfg.SuppressionList.RefreshInterval)
+	if cfg.SuppressionList.Enabled && cfg.SuppressionList.RefreshInterval <= 0 {
+		return nil, fmt.Errorf("SUPPRESSION_LIST_REFRESH_INTERVAL must be positive when suppression lists are enabled")
+	}
+
+	// Load processing budget config
+	envconfig.LoadDurationEnv("PROCESSING_BUDGET_TIGHT", &cfg.Budget.Tight)
+
+	// Load cost config
+	envconfig.LoadJSONFloatMapEnv("CHANNEL_COSTS", &cfg.Cost.Weights)
+	envconfig.LoadFloatEnv("COST_BUDGET", &cfg.Cost.Budget)
+	envconfig.LoadJSONStringArrayEnv("COST_ESCALATE_PRIORITIES", &cfg.Cost.EscalatePriorities)
+	for channel, weight := range cfg.Cost.Weights {
+		if weight < 0 {
+			return nil, fmt.Errorf("CHANNEL_COSTS of %s must not be negative", channel)
+		}
+	}
+	if cfg.Cost.Budget < 0 {
+		return nil, fmt.Errorf("COST_BUDGET must not be negative")
+	}
+
+	// Load region routing config
+	if value := os.Getenv("REGION_ROUTES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Regions.Routes); err != nil {
+			return nil, fmt.Errorf("REGION_ROUTES must be a JSON object of routes by region: %w", err)
+		}
+	}
+	for region, route := range cfg.Regions.Routes {
+		if region == "" || route.Topic == "" {
+			return nil, fmt.Errorf("REGION_ROUTES needs a region and a topic for every route")
+		}
+	}
+	envconfig.LoadJSONStringArrayEnv("REGION_RESIDENCY", &cfg.Regions.Resident)
+
+	// Load warm-up config
+	if value := os.Getenv("WARMUP_SCHEDULES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.WarmUp.Schedules); err != nil {
+			return nil, fmt.Errorf("WARMUP_SCHEDULES must be a JSON array of schedules: %w", err)
+		}
+	}
+
+	// Spaced and warm-up throttled notifications are deferred through the delay topics
+	if !cfg.Delay.Enabled && (len(cfg.Spacing.Intervals) > 0 || len(cfg.WarmUp.Schedules) > 0) {
+		return nil, fmt.Errorf("SPACING_INTERVALS and WARMUP_SCHEDULES need DELAY_ENABLED")
+	}
+
+	// Load the suppression rules teams registered
+	if value := os.Getenv("SUPPRESSION_RULES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Suppression); err != nil {
+			return nil, fmt.Errorf("SUPPRESSION_RULES must be a JSON array of rules: %w", err)
+		}
+	}
+	for _, rule := range cfg.Suppression {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("SUPPRESSION_RULES needs a name for every rule")
+		}
+	}
+	envconfig.LoadBoolEnv("WASM_RULES_ENABLED", &cfg.WASMRules.Enabled)
+	envconfig.LoadStringEnv("WASM_RULES_DIR", &cfg.WASMRules.Dir)
+	envconfig.LoadIntEnv("WASM_RULES_MEMORY_LIMIT_MB", &cfg.WASMRules.MemoryLimit)
+	envconfig.LoadDurationEnv("WASM_RULES_TIMEOUT", &cfg.WASMRules.Timeout)
+	envconfig.LoadIntEnv("WASM_RULES_MAX_MODULE_BYTES", &cfg.WASMRules.MaxModuleBytes)
+	envconfig.LoadDurationEnv("WASM_RULES_RELOAD_INTERVAL", &cfg.WASMRules.ReloadInterval)
+	if cfg.WASMRules.Enabled && (cfg.WASMRules.MemoryLimit <= 0 || cfg.WASMRules.Timeout <= 0 ||
+		cfg.WASMRules.MaxModuleBytes <= 0 || cfg.WASMRules.ReloadInterval <= 0) {
+		return nil, fmt.Errorf("WASM_RULES_MEMORY_LIMIT_MB, WASM_RULES_TIMEOUT, WASM_RULES_MAX_MODULE_BYTES and WASM_RULES_RELOAD_INTERVAL must be positive")
+	}
+	envconfig.LoadStringEnv("EXPRESSION_RULES_FILE", &cfg.ExpressionRules.File)
+	envconfig.LoadDurationEnv("EXPRESSION_RULES_RELOAD_INTERVAL", &cfg.ExpressionRules.ReloadInterval)
+	if cfg.ExpressionRules.File != "" && cfg.ExpressionRules.ReloadInterval <= 0 {
+		return nil, fmt.Errorf("EXPRESSION_RULES_RELOAD_INTERVAL must be positive")
+	}
+
+	// Load event registry config
+	envconfig.LoadStringEnv("EVENT_REGISTRY_FILE", &cfg.EventRegistry.File)
+
+	// Load canary config
+	envconfig.LoadBoolEnv("CANARY_ENABLED", &cfg.Canary.Enabled)
+	envconfig.LoadIntEnv("CANARY_SAMPLE_PERCENT", &cfg.Canary.SamplePercent)
+	envconfig.LoadStringEnv("CANARY_TOPIC_SUFFIX", &cfg.Canary.TopicSuffix)
+	envconfig.LoadStringEnv("CANARY_GROUP_ID", &cfg.Canary.GroupID)
+	envconfig.LoadStringEnv("CANARY_KEY_PREFIX", &cfg.Canary.KeyPrefix)
+	if cfg.Canary.SamplePercent < 0 || cfg.Canary.SamplePercent > 100 {
+		return nil, fmt.Errorf("CANARY_SAMPLE_PERCENT must be between 0 and 100")
+	}
+
 	// Load general config
-	LoadDurationEnv("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
-	LoadBoolEnv("MOCK_MODE", &cfg.MockMode)
+	envconfig.LoadStringEnv("DEBUG_TOPIC", &cfg.DebugTopic)
+	envconfig.LoadDurationEnv("SHUTDOWN_TIMEOUT", &cfg.Shutdown.Drain) // Legacy name of the drain timeout
+	envconfig.LoadDurationEnv("SHUTDOWN_INTAKE_TIMEOUT", &cfg.Shutdown.Intake)
+	envconfig.LoadDurationEnv("SHUTDOWN_DRAIN_TIMEOUT", &cfg.Shutdown.Drain)
+	envconfig.LoadDurationEnv("SHUTDOWN_FLUSH_TIMEOUT", &cfg.Shutdown.Flush)
+	envconfig.LoadDurationEnv("SHUTDOWN_CLOSE_TIMEOUT", &cfg.Shutdown.Close)
+	envconfig.LoadBoolEnv("MOCK_MODE", &cfg.MockMode)
+
+	// Load heartbeat config
+	envconfig.LoadStringEnv("OPS_TOPIC", &cfg.Heartbeat.Topic)
+	envconfig.LoadDurationEnv("HEARTBEAT_INTERVAL", &cfg.Heartbeat.Interval)
+	envconfig.LoadStringEnv("INSTANCE_ID", &cfg.Heartbeat.InstanceID)
+	if cfg.Heartbeat.InstanceID == "" {
+		cfg.Heartbeat.InstanceID, _ = os.Hostname()
+	}
+	if cfg.SLO.Enabled && cfg.Heartbeat.Interval <= 0 {
+		return nil, fmt.Errorf("HEARTBEAT_INTERVAL must be positive when SLO burn rates are enabled")
+	}
+
+	// Load self-test config
+	envconfig.LoadDurationEnv("SELF_TEST_INTERVAL", &cfg.SelfTest.Interval)
+	envconfig.LoadDurationEnv("SELF_TEST_TIMEOUT", &cfg.SelfTest.Timeout)
+	envconfig.LoadIntEnv("SELF_TEST_FAILURES", &cfg.SelfTest.Failures)
+	envconfig.LoadStringEnv("SELF_TEST_USER_ID", &cfg.SelfTest.UserID)
+	if cfg.SelfTest.Interval < 0 {
+		return nil, fmt.Errorf("SELF_TEST_INTERVAL must not be negative")
+	}
+	if cfg.SelfTest.Interval > 0 && (cfg.SelfTest.Timeout <= 0 || cfg.SelfTest.Failures < 1 || cfg.SelfTest.UserID == "") {
+		return nil, fmt.Errorf("SELF_TEST_TIMEOUT must be positive, SELF_TEST_FAILURES at least 1 and SELF_TEST_USER_ID set")
+	}
+
+	// Load startup config
+	envconfig.LoadBoolEnv("STARTUP_PREFLIGHT", &cfg.Startup.Preflight)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_TIMEOUT", &cfg.Startup.RetryTimeout)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_BACKOFF", &cfg.Startup.RetryBackoff)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_MAX_BACKOFF", &cfg.Startup.RetryMaxBackoff)
+
+	// Load priority levels
+	if err := loadPriorities(&cfg); err != nil {
+		return nil, err
+	}
+	if err := validateLagPause(cfg.KafkaConsumer.LagPause, cfg.Priorities); err != nil {
+		return nil, err
+	}
+
+	// The canary reads the mirrored copies in its own consumer group and
+	// must not page anyone about its latency
+	if cfg.Canary.Enabled {
+		for i := range cfg.Priorities {
+			cfg.Priorities[i].Topic += cfg.Canary.TopicSuffix
+		}
+		cfg.KafkaConsumer.GroupID = cfg.Canary.GroupID
+		cfg.KafkaConsumer.HandoverFrom = ""
+		cfg.KafkaConsumer.DeadLetterTopic = ""
+		cfg.KafkaConsumer.Tenants = nil // Mirrored copies are not split by tenant
+		cfg.SLA.WebhookURL = ""
+		cfg.SLO.Enabled = false
+		cfg.Metering.Enabled = false // The canary delivers nothing to bill
+		cfg.Anomaly.Enabled = false  // Mirrored volume says nothing of the primaries
+		cfg.Overview.Enabled = false // The primaries serve the overview
+		cfg.DeadLetterAdmin.Enabled = false
+		cfg.Delay.Enabled = false // The primaries schedule delayed messages
+		cfg.KafkaConsumer.RetryDelay = 0
+	}
 
 	return &cfg, nil
 }
 
-// Creates rate limiter based on configuration
-func (c *Config) CreateRateLimiter() (ratelimiter.RateLimiter, error) {
+// Loads the priority levels and the settings of each
+func loadPriorities(cfg *Config) error {
+	var names []string
+	envconfig.LoadJSONStringArrayEnv("PRIORITY_LEVELS", &names)
+	if len(names) > 0 {
+		cfg.Priorities = buildPriorityLevels(names, cfg.Priorities)
+	} else {
+		cfg.Priorities = append([]PriorityLevelConfig(nil), cfg.Priorities...)
+	}
+
+	for i := range cfg.Priorities {
+		level := &cfg.Priorities[i]
+		suffix := envSuffix(level.Name)
+		envconfig.LoadStringEnv("KAFKA_CONSUMER_TOPIC_"+suffix, &level.Topic)
+		envconfig.LoadIntEnv("REDIS_LIMIT_"+suffix, &level.Limit)
+		envconfig.LoadStringEnv("REDIS_MODE_"+suffix, &level.RateLimitMode)
+		envconfig.LoadIntEnv("PRIORITY_WEIGHT_"+suffix, &level.Weight)
+		envconfig.LoadIntEnv("PRIORITY_BUFFER_"+suffix, &level.Buffer)
+		envconfig.LoadStringEnv("PRIORITY_OVERFLOW_"+suffix, &level.Overflow)
+		envconfig.LoadDurationEnv("PRIORITY_SLO_"+suffix, &level.SLO)
+		envconfig.LoadBoolEnv("PRIORITY_URGENT_"+suffix, &level.Urgent)
+	}
+
+	return validatePriorities(cfg.Priorities)
+}
+
+// Builds priority levels for the given names, reusing known defaults where available
+func buildPriorityLevels(names []string, defaults []PriorityLevelConfig) []PriorityLevelConfig {
+	known := make(map[string]PriorityLevelConfig, len(defaults))
+	for _, level := range defaults {
+		known[level.Name] = level
+	}
+
+	levels := make([]PriorityLevelConfig, 0, len(names))
+	for _, name := range names {
+		level, exists := known[name]
+		if !exists {
+			level = PriorityLevelConfig{
+				Name:   name,
+				Topic:  "notifications.priority." + name,
+				Limit:  20,
+				Weight: 1,
+				Buffer: 100,
+			}
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// Checks that the priority levels are usable by the consumer and limiter
+func validatePriorities(levels []PriorityLevelConfig) error {
+	if len(levels) == 0 {
+		return fmt.Errorf("at least one priority level must be configured")
+	}
+
+	seen := make(map[string]bool, len(levels))
+	for _, level := range levels {
+		if level.Name == "" {
+			return fmt.Errorf("priority level name cannot be empty")
+		}
+		if seen[level.Name] {
+			return fmt.Errorf("duplicate priority level: %s", level.Name)
+		}
+		seen[level.Name] = true
+
+		if level.Topic == "" {
+			return fmt.Errorf("priority level %s has no topic", level.Name)
+		}
+		if level.Weight < 1 {
+			return fmt.Errorf("priority level %s must have a weight of at least 1", level.Name)
+		}
+		if level.Buffer < 0 {
+			return fmt.Errorf("priority level %s has a negative buffer size", level.Name)
+		}
+		if level.RateLimitMode != "" && !validRateLimitMode(level.RateLimitMode) {
+			return fmt.Errorf("priority level %s has unknown rate limit mode %q", level.Name, level.RateLimitMode)
+		}
+		switch level.Overflow {
+		case "", OverflowBlock, OverflowSpill, OverflowShed:
+		default:
+			return fmt.Errorf("priority level %s has unknown overflow policy %q", level.Name, level.Overflow)
+		}
+	}
+	return nil
+}
+
+// Loads the delay tiers and scheduler settings, checking that delayed
+// retries have a scheduler to wait on
+func loadDelay(cfg *Config) error {
+	envconfig.LoadBoolEnv("DELAY_ENABLED", &cfg.Delay.Enabled)
+	envconfig.LoadStringEnv("DELAY_TOPIC_PREFIX", &cfg.Delay.TopicPrefix)
+	envconfig.LoadStringEnv("DELAY_GROUP_ID", &cfg.Delay.GroupID)
+	envconfig.LoadDurationEnv("DELAY_TICK", &cfg.Delay.Tick)
+	envconfig.LoadIntEnv("DELAY_SLOTS", &cfg.Delay.Slots)
+	envconfig.LoadJSONStringArrayEnv("DELAY_COALESCE_KEYS", &cfg.Delay.CoalesceKeys)
+
+	var tiers []string
+	envconfig.LoadJSONStringArrayEnv("DELAY_TIERS", &tiers)
+	if len(tiers) > 0 {
+		cfg.Delay.Tiers = make([]time.Duration, len(tiers))
+		for i, tier := range tiers {
+			d, err := time.ParseDuration(tier)
+			if err != nil {
+				return fmt.Errorf("invalid DELAY_TIERS: %w", err)
+			}
+			cfg.Delay.Tiers[i] = d
+		}
+	}
+
+	if !cfg.Delay.Enabled {
+		if cfg.KafkaConsumer.RetryDelay > 0 {
+			return fmt.Errorf("KAFKA_CONSUMER_RETRY_DELAY needs DELAY_ENABLED")
+		}
+		return nil
+	}
+	if len(cfg.Delay.Tiers) == 0 {
+		return fmt.Errorf("DELAY_TIERS must not be empty")
+	}
+	for i, tier := range cfg.Delay.Tiers {
+		if tier < time.Second || tier%time.Second != 0 {
+			return fmt.Errorf("delay tier %v must be whole seconds", tier)
+		}
+		if i > 0 && tier <= cfg.Delay.Tiers[i-1] {
+			return fmt.Errorf("DELAY_TIERS must be ascending")
+		}
+	}
+	if cfg.Delay.Tick <= 0 || cfg.Delay.Slots <= 0 {
+		return fmt.Errorf("DELAY_TICK and DELAY_SLOTS must be positive")
+	}
+	for _, key := range cfg.Delay.CoalesceKeys {
+		if !coalesceKeys[key] && !strings.HasPrefix(key, "metadata.") {
+			return fmt.Errorf("DELAY_COALESCE_KEYS: unknown field %q", key)
+		}
+	}
+	return nil
+}
+
+// Fields of a notification delayed notifications can be coalesced by,
+// besides metadata.<name>
+var coalesceKeys = map[string]bool{
+	"user_id":    true,
+	"event_type": true,
+	"content":    true, // Hash of the content, channel content and actions
+	"priority":   true,
+	"group_key":  true,
+	"thread_id":  true,
+}
+
+// Checks that lag-based pausing leaves at least one lane watched and pauses known levels
+func validateLagPause(pause LagPauseConfig, levels []PriorityLevelConfig) error {
+	if pause.Threshold <= 0 {
+		return nil
+	}
+	if pause.ResumeLag >= pause.Threshold {
+		return fmt.Errorf("KAFKA_CONSUMER_RESUME_LAG must be below KAFKA_CONSUMER_PAUSE_LAG")
+	}
+	if pause.Interval <= 0 {
+		return fmt.Errorf("KAFKA_CONSUMER_PAUSE_CHECK_INTERVAL must be positive")
+	}
+
+	known := make(map[string]bool, len(levels))
+	for _, level := range levels {
+		known[level.Name] = true
+	}
+	paused := make(map[string]bool, len(pause.Priorities))
+	for _, name := range pause.Priorities {
+		if !known[name] {
+			return fmt.Errorf("cannot pause unknown priority level %s", name)
+		}
+		paused[name] = true
+	}
+	if len(paused) == len(levels) {
+		return fmt.Errorf("at least one priority level must stay unpaused to watch its lag")
+	}
+	return nil
+}
+
+// Reports whether the limiter knows the rate limit mode
+func validRateLimitMode(mode string) bool {
+	return mode == ratelimiter.ModeCounted || mode == ratelimiter.ModeObserved
+}
+
+// Converts a priority name into the suffix used by per-level env variables
+func envSuffix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Creates rate limiter based on configuration, checking notifications
+// against the limits of their tenant's profile when source is set
+func (c *Config) CreateRateLimiter(source ratelimiter.LimitSource) (ratelimiter.RateLimiter, error) {
 	if c.MockMode {
 		return ratelimiter.NewMockRateLimiter(false), nil
 	}
-	
-	return ratelimiter.NewRedisRateLimiter(ratelimiter.Config{
-		Addr:          c.Redis.Addr,
-		Password:      c.Redis.Password,
-		DB:            c.Redis.DB,
-		WindowSeconds: c.Redis.WindowSeconds,
-		LimitHigh:     c.Redis.LimitHigh,
-		LimitMedium:   c.Redis.LimitMedium,
-		LimitLow:      c.Redis.LimitLow,
+
+	limits := make(map[string]int, len(c.Priorities))
+	modes := make(map[string]string, len(c.Priorities))
+	for _, level := range c.Priorities {
+		limits[level.Name] = level.Limit
+		if level.RateLimitMode != "" {
+			modes[level.Name] = level.RateLimitMode
+		}
+	}
+
+	cfg := ratelimiter.Config{
+		Addr:            c.Redis.Addr,
+		Password:        c.Redis.Password,
+		CurrentPassword: c.secretStore.Current("REDIS_PASSWORD"),
+		DB:              c.Redis.DB,
+		WindowSeconds:   c.Redis.WindowSeconds,
+		Limits:          limits,
+		EventTypeLimits: c.Redis.EventTypeLimits,
+		Source:          source,
+		DefaultPriority: c.Priorities[len(c.Priorities)-1].Name,
+		Keys:            c.redisKeys(),
+		Algorithm:       c.Redis.Algorithm,
+		Buckets:         c.Redis.Buckets,
+		PriorityModes:   modes,
+		EventTypeModes:  c.Redis.EventTypeModes,
+	}
+	if c.Redis.LocalSlice > 0 {
+		return ratelimiter.NewLocalLimiter(cfg, ratelimiter.LocalConfig{
+			Slice:        c.Redis.LocalSlice,
+			SyncInterval: c.Redis.LocalSync,
+		})
+	}
+	return ratelimiter.NewRedisRateLimiter(cfg)
+}
+
+// Creates the global limiter, nil when no global limit is configured
+func (c *Config) CreateGlobalLimiter() (*ratelimiter.GlobalLimiter, error) {
+	if c.MockMode || c.Redis.GlobalLimit <= 0 {
+		return nil, nil
+	}
+
+	return ratelimiter.NewGlobalLimiter(ratelimiter.GlobalConfig{
+		Addr:            c.Redis.Addr,
+		Password:        c.Redis.Password,
+		CurrentPassword: c.secretStore.Current("REDIS_PASSWORD"),
+		DB:              c.Redis.DB,
+		Limit:           c.Redis.GlobalLimit,
+		Keys:            c.redisKeys(),
+	})
+}
+
+// Creates the spacer, nil when no channel is spaced or in mock mode
+func (c *Config) CreateSpacer() (*ratelimiter.Spacer, error) {
+	if len(c.Spacing.Intervals) == 0 || c.MockMode {
+		return nil, nil
+	}
+
+	return ratelimiter.NewSpacer(ratelimiter.SpacingConfig{
+		Addr:            c.Redis.Addr,
+		Password:        c.Redis.Password,
+		CurrentPassword: c.secretStore.Current("REDIS_PASSWORD"),
+		DB:              c.Redis.DB,
+		Intervals:       c.Spacing.Intervals,
+		Keys:            c.redisKeys(),
+	})
+}
+
+// Creates the log of delivered copies of expanded events, nil when disabled or in mock mode
+func (c *Config) CreateDeliveryLog() (*ratelimiter.DeliveryLog, error) {
+	if c.FanoutDedupe.TTL <= 0 || c.MockMode {
+		return nil, nil
+	}
+
+	return ratelimiter.NewDeliveryLog(ratelimiter.DeliveryLogConfig{
+		Addr:            c.Redis.Addr,
+		Password:        c.Redis.Password,
+		CurrentPassword: c.secretStore.Current("REDIS_PASSWORD"),
+		DB:              c.Redis.DB,
+		TTL:             c.FanoutDedupe.TTL,
+		Keys:            c.redisKeys(),
+	})
+}
+
+// Creates the decryptor of notifications' content, nil when encryption is disabled
+func (c *Config) CreateDecryptor() (*encryption.FieldEncryptor, error) {
+	if !c.Encryption.Enabled {
+		return nil, nil
+	}
+
+	keys, err := encryption.NewStaticKeyProvider(c.Encryption.ActiveKeyID, c.Encryption.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption keys: %w", err)
+	}
+
+	return encryption.NewFieldEncryptor(keys, nil), nil
+}
+
+// Creates the warm-up limiter, nil without schedules or in mock mode
+func (c *Config) CreateWarmUp() (*ratelimiter.WarmUp, error) {
+	if len(c.WarmUp.Schedules) == 0 || c.MockMode {
+		return nil, nil
+	}
+
+	return ratelimiter.NewWarmUp(ratelimiter.WarmUpConfig{
+		Addr:            c.Redis.Addr,
+		Password:        c.Redis.Password,
+		CurrentPassword: c.secretStore.Current("REDIS_PASSWORD"),
+		DB:              c.Redis.DB,
+		Schedules:       c.WarmUp.Schedules,
+		Keys:            c.redisKeys(),
+	})
+}
+
+// Creates the selected suppression rules, which must have been registered
+func (c *Config) CreateSuppressionRules() (suppression.Rules, error) {
+	return suppression.New(c.Suppression)
+}
+
+// Creates the engine evaluating the expression rules, nil when they are disabled
+func (c *Config) CreateExpressionRules() (*exprrules.Engine, error) {
+	if c.ExpressionRules.File == "" {
+		return nil, nil
+	}
+
+	return exprrules.New(c.ExpressionRules.File)
+}
+
+// Creates the engine running the tenants' rule modules, nil when they are disabled
+func (c *Config) CreateWASMRules(ctx context.Context) (*wasmrules.Engine, error) {
+	if !c.WASMRules.Enabled {
+		return nil, nil
+	}
+
+	return wasmrules.New(ctx, wasmrules.Config{
+		Dir:            c.WASMRules.Dir,
+		MemoryLimit:    c.WASMRules.MemoryLimit,
+		Timeout:        c.WASMRules.Timeout,
+		MaxModuleBytes: c.WASMRules.MaxModuleBytes,
+	})
+}
+
+// Returns the key scheme; a canary's keys carry an extra prefix that keeps
+// its counters apart from the primary's
+func (c *Config) redisKeys() ratelimiter.Keys {
+	keys := ratelimiter.Keys{
+		Prefix:   c.Redis.KeyPrefix,
+		HashTags: c.Redis.HashTags,
+	}
+	if c.Canary.Enabled {
+		keys.Prefix += c.Canary.KeyPrefix
+	}
+	return keys
+}
+
+// Creates the store of rate-limit profiles, nil when profiles are disabled
+// or in mock mode
+func (c *Config) CreateProfileStore() (*profiles.Store, error) {
+	if !c.Profiles.Enabled || c.MockMode {
+		return nil, nil
+	}
+
+	priorities := make([]string, len(c.Priorities))
+	for i, level := range c.Priorities {
+		priorities[i] = level.Name
+	}
+	return profiles.NewStore(profiles.Config{
+		Driver:          c.Database.Driver,
+		DSN:             c.Database.DSN,
+		Environment:     c.Profiles.Environment,
+		Priorities:      priorities,
+		RefreshInterval: c.Profiles.RefreshInterval,
+	})
+}
+
+// Creates the SLA tracker based on configuration
+func (c *Config) CreateSLATracker() *sla.Tracker {
+	thresholds := make(map[string]time.Duration, len(c.Priorities))
+	for _, level := range c.Priorities {
+		thresholds[level.Name] = level.SLO
+	}
+
+	return sla.NewTracker(sla.Config{
+		Thresholds:     thresholds,
+		WebhookURL:     c.SLA.WebhookURL,
+		WebhookTimeout: c.SLA.WebhookTimeout,
 	})
 }
 
+// Creates the switches of the stages skipped in degraded mode
+func (c *Config) CreateStageSwitches() (*degraded.Switches, error) {
+	return degraded.New(c.Degraded.SkipStages)
+}
+
+// Creates the SLO burn-rate evaluator reading rates from source, nil when disabled
+func (c *Config) CreateSLOEvaluator(source slo.Source) (*slo.Evaluator, error) {
+	if !c.SLO.Enabled {
+		return nil, nil
+	}
+
+	objectives := make(map[string]float64)
+	if c.SLO.EnqueueAvailability > 0 {
+		objectives[slo.EnqueueAvailability] = c.SLO.EnqueueAvailability
+	}
+	if c.SLO.DeliverySuccess > 0 {
+		objectives[slo.DeliverySuccess] = c.SLO.DeliverySuccess
+	}
+	if c.SLO.Latency > 0 {
+		for _, level := range c.Priorities {
+			if level.SLO > 0 {
+				objectives[slo.Latency(level.Name)] = c.SLO.Latency
+			}
+		}
+	}
+
+	return slo.NewEvaluator(source, slo.Config{
+		Objectives: objectives,
+		Interval:   c.SLO.EvaluationInterval,
+		Windows: []slo.Window{
+			{Severity: slo.SeverityPage, Long: c.SLO.PageWindow, BurnRate: c.SLO.PageBurnRate},
+			{Severity: slo.SeverityTicket, Long: c.SLO.TicketWindow, BurnRate: c.SLO.TicketBurnRate},
+		},
+		WebhookURL:     c.SLO.WebhookURL,
+		WebhookTimeout: c.SLO.WebhookTimeout,
+	})
+}
+
+// Creates the usage meter, nil when metering is disabled or in mock mode
+func (c *Config) CreateMeter() (*metering.Meter, error) {
+	if !c.Metering.Enabled || c.MockMode {
+		return nil, nil
+	}
+
+	return metering.NewMeter(metering.Config{
+		Driver:         c.Database.Driver,
+		DSN:            c.Database.DSN,
+		FlushInterval:  c.Metering.FlushInterval,
+		WebhookURL:     c.Metering.WebhookURL,
+		WebhookTimeout: c.Metering.WebhookTimeout,
+	})
+}
+
+// Creates the volume anomaly detector, nil when detection is disabled
+func (c *Config) CreateAnomalyDetector() (*anomaly.Detector, error) {
+	if !c.Anomaly.Enabled {
+		return nil, nil
+	}
+
+	return anomaly.NewDetector(anomaly.Config{
+		Window:          c.Anomaly.Window,
+		BaselineWindows: c.Anomaly.BaselineWindows,
+		WarmupWindows:   c.Anomaly.WarmupWindows,
+		MinBaseline:     c.Anomaly.MinBaseline,
+		SpikeFactor:     c.Anomaly.SpikeFactor,
+		DropFactor:      c.Anomaly.DropFactor,
+		MaxKeys:         c.Anomaly.MaxKeys,
+		NotifyResolved:  c.Anomaly.NotifyResolved,
+		WebhookURL:      c.Anomaly.WebhookURL,
+		WebhookTimeout:  c.Anomaly.WebhookTimeout,
+	})
+}
+
+// Creates the audit log of admin actions. Actions are only logged in mock mode.
+func (c *Config) CreateAuditLog() (*audit.Log, error) {
+	cfg := audit.Config{
+		Service:    "rate-limiter-service",
+		InstanceID: c.Heartbeat.InstanceID,
+	}
+	if !c.MockMode {
+		cfg.Driver = c.Database.Driver
+		cfg.DSN = c.Database.DSN
+	}
+	return audit.NewLog(cfg)
+}
+
+// Creates the gate honouring signed bypasses, nil without a secret. Every
+// honoured bypass is audited, so there are none in mock mode. The canary
+// doesn't audit the copies the primary already did.
+func (c *Config) CreateBypassGate() (*bypass.Gate, error) {
+	if c.Bypass.Secret == "" {
+		return nil, nil
+	}
+	if c.MockMode {
+		return nil, fmt.Errorf("bypasses need the audit database, which mock mode doesn't use")
+	}
+
+	return bypass.NewGate(bypass.Config{
+		Secret:   c.Bypass.Secret,
+		TTL:      c.Bypass.TTL,
+		Driver:   c.Database.Driver,
+		DSN:      c.Database.DSN,
+		ReadOnly: c.Canary.Enabled,
+	})
+}
+
+// Creates the engagement model, nil when it is disabled or in mock mode. The
+// canary reads the primary's counts without adding to them.
+func (c *Config) CreateEngagementModel() (*engagement.Model, error) {
+	if !c.Engagement.Enabled || c.MockMode {
+		return nil, nil
+	}
+
+	return engagement.NewModel(engagement.Config{
+		Addr:            c.Redis.Addr,
+		Password:        c.Redis.Password,
+		CurrentPassword: c.secretStore.Current("REDIS_PASSWORD"),
+		DB:              c.Redis.DB,
+		Keys:            ratelimiter.Keys{Prefix: c.Redis.KeyPrefix, HashTags: c.Redis.HashTags},
+		TTL:             c.Engagement.TTL,
+		MinSends:        c.Engagement.MinSends,
+		ExplorePercent:  c.Engagement.ExplorePercent,
+		TokenSecret:     c.Engagement.TokenSecret,
+		ReadOnly:        c.Canary.Enabled,
+	})
+}
+
+// Creates the delivery stats projection, nil when it is disabled or in mock
+// mode. The canary reads the primary's stats without adding to them.
+func (c *Config) CreateDeliveryStats() (*deliverystats.Projection, error) {
+	if !c.DeliveryStats.Enabled || c.MockMode {
+		return nil, nil
+	}
+
+	return deliverystats.NewProjection(deliverystats.Config{
+		Addr:            c.Redis.Addr,
+		Password:        c.Redis.Password,
+		CurrentPassword: c.secretStore.Current("REDIS_PASSWORD"),
+		DB:              c.Redis.DB,
+		Keys:            ratelimiter.Keys{Prefix: c.Redis.KeyPrefix, HashTags: c.Redis.HashTags},
+		TTL:             c.DeliveryStats.TTL,
+		Cooldowns:       c.DeliveryStats.Cooldowns,
+		ReadOnly:        c.Canary.Enabled,
+	})
+}
+
+// Creates the checker of users' contacts, nil when contacts aren't checked
+func (c *Config) CreateContactChecker() (*contacts.Checker, error) {
+	if !c.Contacts.Enabled {
+		return nil, nil
+	}
+
+	return contacts.NewChecker(contacts.Config{
+		DefaultCallingCode:    c.Contacts.DefaultCallingCode,
+		DisposableDomainsFile: c.Contacts.DisposableDomainsFile,
+		AllowDisposable:       c.Contacts.AllowDisposable,
+	})
+}
+
+// Creates the store of suppression lists, nil when they are disabled or in
+// mock mode
+func (c *Config) CreateSuppressionList() (*blocklist.Store, error) {
+	if !c.SuppressionList.Enabled || c.MockMode {
+		return nil, nil
+	}
+
+	return blocklist.NewStore(blocklist.Config{
+		Driver:             c.Database.Driver,
+		DSN:                c.Database.DSN,
+		DefaultCallingCode: c.Contacts.DefaultCallingCode,
+		RefreshInterval:    c.SuppressionList.RefreshInterval,
+	})
+}
+
+// Creates the cost-aware channel selector, nil without channel costs
+func (c *Config) CreateCostSelector() *cost.Selector {
+	if len(c.Cost.Weights) == 0 {
+		return nil
+	}
+
+	return cost.NewSelector(cost.Config{
+		Weights:  c.Cost.Weights,
+		Budget:   c.Cost.Budget,
+		Escalate: c.Cost.EscalatePriorities,
+	})
+}
+
+// Creates the region router, nil without routes or resident regions
+func (c *Config) CreateRegionRouter() *regions.Router {
+	if len(c.Regions.Routes) == 0 && len(c.Regions.Resident) == 0 {
+		return nil
+	}
+	return regions.NewRouter(c.Regions.Routes, c.Regions.Resident)
+}
+
+// Loads the event-type registry based on configuration
+func (c *Config) CreateEventRegistry() (*registry.Registry, error) {
+	eventRegistry, err := registry.Load(c.EventRegistry.File)
+	if err != nil {
+		return nil, err
+	}
+	// Notifications outside their window are deferred through the delay
+	// topics, the canary leaves them to the primaries
+	if eventRegistry.HasDeliveryWindows() && !c.Delay.Enabled && !c.Canary.Enabled {
+		return nil, fmt.Errorf("delivery windows need DELAY_ENABLED")
+	}
+	return eventRegistry, nil
+}
+
 // Creates preferences service based on configuration
 func (c *Config) CreatePreferencesService() (preferences.PreferencesService, error) {
 	if c.MockMode {
 		return preferences.NewMockPreferencesService(), nil
 	}
-	
-	return preferences.NewSQLPreferencesService(preferences.Config{
-		Driver:   c.Database.Driver,
-		DSN:      c.Database.DSN,
-		MaxConns: c.Database.MaxConns,
-		MaxIdle:  c.Database.MaxIdle,
+
+	service, err := preferences.NewSQLPreferencesService(preferences.Config{
+		Driver:          c.Database.Driver,
+		DSN:             c.Database.DSN,
+		ReadDSN:         c.Database.ReadDSN,
+		StaleReadWindow: c.Database.StaleRead,
+		MaxConns:        c.Database.MaxConns,
+		MaxIdle:         c.Database.MaxIdle,
+		QueryTimeout:    c.Database.QueryTimeout,
+		SlowQuery:       c.Database.SlowQuery,
 	})
-}
\ No newline at end of file
+	if err != nil {
+		return nil, err
+	}
+
+	// Coalesce concurrent lookups for the same user into one query
+	service = preferences.NewCoalescingPreferencesService(service)
+
+	if c.Database.CacheTTL <= 0 {
+		return service, nil
+	}
+	return preferences.NewCachingPreferencesService(service, c.Database.CacheTTL, c.Database.CacheSize), nil
+}
@@ -0,0 +1,149 @@
+// Package contacts checks the email addresses and phone numbers of users
+// before notifications are delivered to them. A contact that can't be
+// delivered to fails at the provider anyway, after a paid call and at the
+// cost of the sender's reputation, so it is flagged instead.
+package contacts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// Errors of contacts that can't be delivered to
+var (
+	ErrInvalidEmail    = errors.New("invalid email address")
+	ErrInvalidPhone    = errors.New("invalid phone number")
+	ErrDisposableEmail = errors.New("disposable email address")
+)
+
+// Domains of well-known disposable email services, refused along with the
+// ones configured
+var defaultDisposableDomains = []string{
+	"10minutemail.com",
+	"dispostable.com",
+	"getnada.com",
+	"guerrillamail.com",
+	"maildrop.cc",
+	"mailinator.com",
+	"sharklasers.com",
+	"temp-mail.org",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+// Config of the checker
+type Config struct {
+	DefaultCallingCode    string // Country calling code of numbers stored without one, e.g. 1; empty refuses them
+	DisposableDomainsFile string // Optional file of more disposable domains, one per line
+	AllowDisposable       bool   // Deliver to disposable addresses rather than refusing them
+}
+
+// Checker checks and normalizes the contacts of the channels delivered to
+// an email address or a phone number. Contacts of other channels, such as
+// device tokens, pass unchecked.
+type Checker struct {
+	defaultCallingCode string
+	disposable         map[string]bool // Nil when disposable addresses are allowed
+}
+
+// NewChecker creates a checker, reading the disposable domains file
+func NewChecker(cfg Config) (*Checker, error) {
+	c := &Checker{defaultCallingCode: cfg.DefaultCallingCode}
+	if cfg.AllowDisposable {
+		return c, nil
+	}
+
+	c.disposable = make(map[string]bool, len(defaultDisposableDomains))
+	for _, domain := range defaultDisposableDomains {
+		c.disposable[domain] = true
+	}
+	if cfg.DisposableDomainsFile != "" {
+		domains, err := readDomains(cfg.DisposableDomainsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, domain := range domains {
+			c.disposable[domain] = true
+		}
+	}
+	return c, nil
+}
+
+// Check returns the contact of a channel normalized, or why it can't be
+// delivered to
+func (c *Checker) Check(channel, contact string) (string, error) {
+	switch channel {
+	case models.ChannelEmail:
+		address, err := NormalizeEmail(contact)
+		if err != nil {
+			return "", err
+		}
+		if c.Disposable(address) {
+			return "", fmt.Errorf("%w: %s", ErrDisposableEmail, address[strings.LastIndex(address, "@")+1:])
+		}
+		return address, nil
+	case models.ChannelSMS, models.ChannelWhatsApp:
+		return NormalizePhone(contact, c.defaultCallingCode)
+	default:
+		return contact, nil
+	}
+}
+
+// Disposable reports whether a normalized address is at a disposable
+// email service, or at a subdomain of one
+func (c *Checker) Disposable(address string) bool {
+	domain := address[strings.LastIndex(address, "@")+1:]
+	for domain != "" {
+		if c.disposable[domain] {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+		domain = parent
+	}
+	return false
+}
+
+// Reason returns the short name of why a contact was refused, for metrics
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrDisposableEmail):
+		return "disposable_email"
+	case errors.Is(err, ErrInvalidEmail):
+		return "invalid_email"
+	case errors.Is(err, ErrInvalidPhone):
+		return "invalid_phone"
+	default:
+		return "other"
+	}
+}
+
+// readDomains reads a file of domains, one per line, skipping blank lines
+// and # comments
+func readDomains(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disposable domains: %w", err)
+	}
+	defer file.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if domain := strings.ToLower(strings.TrimSpace(line)); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read disposable domains: %w", err)
+	}
+	return domains, nil
+}
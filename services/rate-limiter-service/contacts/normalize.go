@@ -0,0 +1,111 @@
+package contacts
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Limits of email addresses, RFC 5321 section 4.5.3.1
+const (
+	maxLocalPartLength = 64
+	maxAddressLength   = 254
+	maxLabelLength     = 63
+)
+
+// Digits an E.164 number has, its country calling code included. The
+// shortest numbers in use are 7 digits long after a one digit code.
+const (
+	minPhoneDigits = 8
+	maxPhoneDigits = 15
+)
+
+// Characters people write phone numbers with that aren't part of them
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "")
+
+// NormalizeEmail checks an email address against RFC 5322 and returns it
+// with its domain lowercased. Display names, comments and domain literals
+// are refused, since a stored contact is a bare address.
+func NormalizeEmail(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidEmail, err)
+	}
+	if parsed.Name != "" || strings.ContainsAny(address, "<>()") {
+		return "", fmt.Errorf("%w: not a bare address", ErrInvalidEmail)
+	}
+
+	at := strings.LastIndex(parsed.Address, "@")
+	local, domain := parsed.Address[:at], strings.ToLower(parsed.Address[at+1:])
+	if len(local) > maxLocalPartLength {
+		return "", fmt.Errorf("%w: local part longer than %d characters", ErrInvalidEmail, maxLocalPartLength)
+	}
+	if err := checkDomain(domain); err != nil {
+		return "", err
+	}
+
+	normalized := local + "@" + domain
+	if len(normalized) > maxAddressLength {
+		return "", fmt.Errorf("%w: longer than %d characters", ErrInvalidEmail, maxAddressLength)
+	}
+	return normalized, nil
+}
+
+// checkDomain checks the domain of an address is a host name mail can be
+// routed to: dotted labels of letters, digits and inner hyphens, ending in
+// a top-level domain that isn't all digits
+func checkDomain(domain string) error {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%w: domain %q has no top-level domain", ErrInvalidEmail, domain)
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelLength || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%w: domain %q is malformed", ErrInvalidEmail, domain)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r > 127) {
+				return fmt.Errorf("%w: domain %q is malformed", ErrInvalidEmail, domain)
+			}
+		}
+	}
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return fmt.Errorf("%w: domain %q has a numeric top-level domain", ErrInvalidEmail, domain)
+	}
+	return nil
+}
+
+// NormalizePhone formats a phone number as E.164, e.g. +14155550123.
+// Numbers written with a + or the 00 international prefix carry their
+// country calling code. Others are national numbers of defaultCallingCode,
+// whose trunk prefix is dropped; they are refused without a default code.
+func NormalizePhone(number, defaultCallingCode string) (string, error) {
+	digits := phoneSeparators.Replace(strings.TrimSpace(number))
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits = digits[1:]
+	case strings.HasPrefix(digits, "00"):
+		digits = digits[2:]
+	case defaultCallingCode == "":
+		return "", fmt.Errorf("%w: no country calling code", ErrInvalidPhone)
+	default:
+		national := strings.TrimPrefix(digits, "0")
+		// North American numbers are dialled nationally with a leading 1
+		if defaultCallingCode == "1" && len(national) == 11 && national[0] == '1' {
+			national = national[1:]
+		}
+		digits = defaultCallingCode + national
+	}
+
+	if strings.Trim(digits, "0123456789") != "" {
+		return "", fmt.Errorf("%w: not made of digits", ErrInvalidPhone)
+	}
+	if digits == "" || digits[0] == '0' {
+		return "", fmt.Errorf("%w: country calling codes don't start with 0", ErrInvalidPhone)
+	}
+	if len(digits) < minPhoneDigits || len(digits) > maxPhoneDigits {
+		return "", fmt.Errorf("%w: E.164 numbers have %d to %d digits", ErrInvalidPhone, minPhoneDigits, maxPhoneDigits)
+	}
+	return "+" + digits, nil
+}
@@ -0,0 +1,76 @@
+package cost
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// Config for the selector
+type Config struct {
+	Weights  map[string]float64 // Cost of delivering on a channel, zero for channels missing
+	Budget   float64            // Cost a notification of a non-escalating priority may run up across its channels
+	Escalate []string           // Priorities delivered on every channel whatever it costs
+}
+
+// Selector keeps notifications of the less urgent priorities on cheap
+// channels. Their channels are taken cheapest first while they fit the
+// budget; the others become fallbacks, so paid channels are only used once
+// delivery on the cheaper ones failed.
+type Selector struct {
+	weights  map[string]float64
+	budget   float64
+	escalate map[string]bool
+}
+
+// NewSelector creates a cost-aware channel selector
+func NewSelector(cfg Config) *Selector {
+	escalate := make(map[string]bool, len(cfg.Escalate))
+	for _, priority := range cfg.Escalate {
+		escalate[priority] = true
+	}
+	return &Selector{
+		weights:  cfg.Weights,
+		budget:   cfg.Budget,
+		escalate: escalate,
+	}
+}
+
+// Select splits the channels of a notification of the priority into those
+// within the budget and those deferred to the fallbacks, each cheapest first.
+// At least one channel is always kept.
+func (s *Selector) Select(priority string, channels []string) ([]string, []string) {
+	if s.escalate[priority] || len(channels) == 0 {
+		return channels, nil
+	}
+
+	byCost := slices.Clone(channels)
+	slices.SortStableFunc(byCost, func(a, b string) int {
+		return cmp.Compare(s.weights[a], s.weights[b])
+	})
+
+	kept, spent := 1, s.weights[byCost[0]]
+	for _, channel := range byCost[1:] {
+		if spent+s.weights[channel] > s.budget {
+			break
+		}
+		spent += s.weights[channel]
+		kept++
+	}
+	if kept == len(byCost) {
+		return channels, nil
+	}
+	return byCost[:kept], byCost[kept:]
+}
+
+// Record adds the cost of the channels a notification was delivered on to its tenant's spend
+func (s *Selector) Record(notification *models.PrioritizedNotification, channels []string) {
+	tenant, _ := notification.Metadata[models.MetadataTenant].(string)
+	for _, channel := range channels {
+		if weight := s.weights[channel]; weight > 0 {
+			metrics.ChannelCost.WithLabelValues(tenant, channel).Add(weight)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package deadletters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminauth"
+)
+
+// Page sizes of listed dead letters
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Handler serves the dead-letter API:
+//
+//	GET  /dead-letters                         dead-letter topics and their partitions
+//	GET  /dead-letters/{topic}                 a page of a partition, ?partition=&offset=&limit=
+//	POST /dead-letters/{topic}/requeue         {"partition": 0, "offsets": [12, 15]}
+//	POST /dead-letters/{topic}/purge           {"partition": 0, "before": 100}
+//	GET  /dead-letters-audit                   the latest requeues and purges, ?limit=
+//
+// Requeues and purges are audited under the operator who sent them.
+func (m *Manager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dead-letters", m.handleTopics)
+	mux.HandleFunc("GET /dead-letters/{topic}", m.handleList)
+	mux.HandleFunc("POST /dead-letters/{topic}/requeue", m.handleRequeue)
+	mux.HandleFunc("POST /dead-letters/{topic}/purge", m.handlePurge)
+	if m.db != nil {
+		mux.HandleFunc("GET /dead-letters-audit", m.handleAudit)
+	}
+	return mux
+}
+
+// Handles requests for the dead-letter topics
+func (m *Manager) handleTopics(w http.ResponseWriter, r *http.Request) {
+	topics, err := m.Topics(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read dead-letter topics: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, topics)
+}
+
+// Handles requests for a page of dead letters
+func (m *Manager) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	partition, err := queryInt(query.Get("partition"), 0)
+	if err != nil || partition < 0 {
+		http.Error(w, "partition must be a non-negative number", http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(query.Get("offset"), -1)
+	if err != nil {
+		http.Error(w, "offset must be a number", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(query.Get("limit"), defaultPageSize)
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+		return
+	}
+
+	page, err := m.List(r.Context(), r.PathValue("topic"), int32(partition), offset, int(limit))
+	if err != nil {
+		writeError(w, "failed to list dead letters", err)
+		return
+	}
+	writeJSON(w, page)
+}
+
+// Handles requests to requeue dead letters to their source topics
+func (m *Manager) handleRequeue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Partition int32   `json:"partition"`
+		Offsets   []int64 `json:"offsets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(req.Offsets) == 0 || len(req.Offsets) > maxPageSize {
+		http.Error(w, fmt.Sprintf("between 1 and %d offsets must be given", maxPageSize), http.StatusBadRequest)
+		return
+	}
+
+	results, err := m.Requeue(r.Context(), r.PathValue("topic"), req.Partition, req.Offsets, adminauth.Actor(r))
+	if err != nil {
+		writeError(w, "failed to requeue dead letters", err)
+		return
+	}
+	writeJSON(w, results)
+}
+
+// Handles requests to purge dead letters
+func (m *Manager) handlePurge(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Partition int32 `json:"partition"`
+		Before    int64 `json:"before"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	purged, err := m.Purge(r.Context(), r.PathValue("topic"), req.Partition, req.Before, adminauth.Actor(r))
+	if err != nil {
+		writeError(w, "failed to purge dead letters", err)
+		return
+	}
+	writeJSON(w, map[string]int64{"purged": purged})
+}
+
+// Handles requests for the audit trail
+func (m *Manager) handleAudit(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r.URL.Query().Get("limit"), defaultPageSize)
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+		return
+	}
+
+	records, err := m.AuditTrail(r.Context(), int(limit))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read audit trail: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, records)
+}
+
+// queryInt parses a query parameter, returning the default when it is empty
+func queryInt(value string, def int64) (int64, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// writeError reports a failed request, unknown topics as not found
+func writeError(w http.ResponseWriter, message string, err error) {
+	status := http.StatusServiceUnavailable
+	if errors.Is(err, ErrUnknownTopic) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), status)
+}
+
+// writeJSON writes a value as a JSON response
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,491 @@
+package deadletters
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/kafka"
+)
+
+// Header of a requeued message naming the dead letter it was copied from
+const RequeuedFromHeader = "X-Requeued-From"
+
+// Audit actions
+const (
+	ActionRequeue = "requeue"
+	ActionPurge   = "purge"
+)
+
+// How long reading a page of a partition may take
+const readTimeout = 5 * time.Second
+
+// ErrUnknownTopic is returned for topics that aren't managed dead-letter topics
+var ErrUnknownTopic = errors.New("not a dead-letter topic")
+
+// Config for the manager
+type Config struct {
+	Brokers      []string
+	Topics       []string // Dead-letter topics that may be inspected, requeued and purged
+	RequiredAcks int      // Acks requeued messages wait for
+	RetryMax     int
+	Driver       string // Optional database audit records are kept in, they are only logged without
+	DSN          string
+}
+
+// Manager inspects the dead-letter topics, requeues dead letters to the
+// topic they failed on and purges them
+type Manager struct {
+	client   sarama.Client
+	admin    sarama.ClusterAdmin
+	consumer sarama.Consumer
+	producer sarama.SyncProducer
+	db       *sql.DB
+	topics   []string
+
+	readMu sync.Mutex // A partition can only be consumed once at a time
+}
+
+// Message is a decoded dead letter
+type Message struct {
+	Topic       string            `json:"topic"`
+	Partition   int32             `json:"partition"`
+	Offset      int64             `json:"offset"`
+	Timestamp   time.Time         `json:"timestamp"`
+	SourceTopic string            `json:"source_topic,omitempty"` // Topic the message failed on
+	Error       string            `json:"error,omitempty"`        // Why it failed
+	Key         string            `json:"key,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Value       json.RawMessage   `json:"value"`
+}
+
+// Page of the dead letters of a partition
+type Page struct {
+	Messages   []Message `json:"messages"`
+	NextOffset int64     `json:"next_offset"` // Offset the next page starts at
+	Newest     int64     `json:"newest"`      // Offset the next dead letter will get
+}
+
+// PartitionState is the range of offsets a partition still holds
+type PartitionState struct {
+	Partition int32 `json:"partition"`
+	Oldest    int64 `json:"oldest"`
+	Newest    int64 `json:"newest"`
+}
+
+// TopicState is a dead-letter topic and its partitions
+type TopicState struct {
+	Topic      string           `json:"topic"`
+	Messages   int64            `json:"messages"`
+	Partitions []PartitionState `json:"partitions"`
+}
+
+// RequeueResult of a single dead letter
+type RequeueResult struct {
+	Offset int64  `json:"offset"`
+	Target string `json:"target,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditRecord is a requeue or purge and who requested it
+type AuditRecord struct {
+	Action      string    `json:"action"`
+	Topic       string    `json:"topic"`
+	Partition   int32     `json:"partition"`
+	Offsets     string    `json:"offsets"` // The requeued offsets, or the purged range
+	Detail      string    `json:"detail"`
+	RequestedBy string    `json:"requested_by"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// NewManager connects to Kafka and, when configured, the audit database
+func NewManager(cfg Config) (*Manager, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = false
+	config.Producer.RequiredAcks = sarama.RequiredAcks(cfg.RequiredAcks)
+	config.Producer.Retry.Max = cfg.RetryMax
+	config.Producer.Return.Successes = true
+
+	client, err := sarama.NewClient(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		admin.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		consumer.Close()
+		admin.Close()
+		return nil, fmt.Errorf("failed to create producer: %w", err)
+	}
+
+	m := &Manager{
+		client:   client,
+		admin:    admin,
+		consumer: consumer,
+		producer: producer,
+		topics:   cfg.Topics,
+	}
+
+	if cfg.DSN != "" {
+		m.db, err = sql.Open(cfg.Driver, cfg.DSN)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = m.db.PingContext(ctx)
+			cancel()
+		}
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to connect to audit database: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// Topics returns the state of every dead-letter topic. Topics that don't
+// exist yet are listed without partitions.
+func (m *Manager) Topics(ctx context.Context) ([]TopicState, error) {
+	states := make([]TopicState, 0, len(m.topics))
+	for _, topic := range m.topics {
+		state := TopicState{Topic: topic, Partitions: []PartitionState{}}
+
+		partitions, err := m.client.Partitions(topic)
+		if errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+			states = append(states, state)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+		}
+
+		for _, partition := range partitions {
+			oldest, newest, err := m.offsets(topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			state.Partitions = append(state.Partitions, PartitionState{Partition: partition, Oldest: oldest, Newest: newest})
+			state.Messages += newest - oldest
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// List returns up to limit dead letters of a partition from offset on, from
+// the oldest one still held when offset is negative
+func (m *Manager) List(ctx context.Context, topic string, partition int32, offset int64, limit int) (Page, error) {
+	if !m.manages(topic) {
+		return Page{}, ErrUnknownTopic
+	}
+
+	oldest, newest, err := m.offsets(topic, partition)
+	if err != nil {
+		return Page{}, err
+	}
+
+	from := max(offset, oldest)
+	until := min(from+int64(limit), newest)
+	page := Page{Messages: []Message{}, NextOffset: max(until, from), Newest: newest}
+	if from >= until {
+		return page, nil
+	}
+
+	err = m.read(ctx, topic, partition, from, until, func(message *sarama.ConsumerMessage) {
+		page.Messages = append(page.Messages, Decode(message))
+	})
+	return page, err
+}
+
+// Requeue copies dead letters back to the topics they failed on, where their
+// stage processes them again. The dead letters themselves stay until purged.
+func (m *Manager) Requeue(ctx context.Context, topic string, partition int32, offsets []int64, requestedBy string) ([]RequeueResult, error) {
+	if !m.manages(topic) {
+		return nil, ErrUnknownTopic
+	}
+	if len(offsets) == 0 {
+		return []RequeueResult{}, nil
+	}
+
+	offsets = slices.Clone(offsets)
+	slices.Sort(offsets)
+	offsets = slices.Compact(offsets)
+
+	found := make(map[int64]*sarama.ConsumerMessage, len(offsets))
+	err := m.read(ctx, topic, partition, offsets[0], offsets[len(offsets)-1]+1, func(message *sarama.ConsumerMessage) {
+		if _, wanted := slices.BinarySearch(offsets, message.Offset); wanted {
+			found[message.Offset] = message
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]RequeueResult, 0, len(offsets))
+	requeued := 0
+	for _, offset := range offsets {
+		result := RequeueResult{Offset: offset}
+		message, exists := found[offset]
+		if !exists {
+			result.Error = "no dead letter at this offset"
+			results = append(results, result)
+			continue
+		}
+
+		result.Target, err = m.requeue(ctx, message)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			requeued++
+		}
+		results = append(results, result)
+	}
+
+	m.audit(ctx, AuditRecord{
+		Action:      ActionRequeue,
+		Topic:       topic,
+		Partition:   partition,
+		Offsets:     joinOffsets(offsets),
+		Detail:      fmt.Sprintf("requeued %d of %d", requeued, len(offsets)),
+		RequestedBy: requestedBy,
+	})
+	return results, nil
+}
+
+// requeue sends a copy of a dead letter to its source topic, without the
+// headers describing the failure
+func (m *Manager) requeue(ctx context.Context, message *sarama.ConsumerMessage) (string, error) {
+	letter := Decode(message)
+	if letter.SourceTopic == "" {
+		return "", errors.New("dead letter names no source topic")
+	}
+	if m.manages(letter.SourceTopic) {
+		return "", fmt.Errorf("source topic %s is a dead-letter topic", letter.SourceTopic)
+	}
+
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+1)
+	for _, header := range message.Headers {
+		switch string(header.Key) {
+		case kafka.ErrorHeader, kafka.SourceTopicHeader, RequeuedFromHeader:
+			continue
+		}
+		headers = append(headers, *header)
+	}
+	headers = append(headers, sarama.RecordHeader{
+		Key:   []byte(RequeuedFromHeader),
+		Value: []byte(fmt.Sprintf("%s/%d/%d", message.Topic, message.Partition, message.Offset)),
+	})
+
+	msg := &sarama.ProducerMessage{
+		Topic:   letter.SourceTopic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	}
+	if err := ctx.Err(); err != nil {
+		return letter.SourceTopic, err
+	}
+	if _, _, err := m.producer.SendMessage(msg); err != nil {
+		return letter.SourceTopic, fmt.Errorf("failed to send message: %w", err)
+	}
+	return letter.SourceTopic, nil
+}
+
+// Purge deletes the dead letters of a partition before an offset. Kafka
+// only deletes from the start of a partition, so the range purged is from
+// the oldest dead letter up to before.
+func (m *Manager) Purge(ctx context.Context, topic string, partition int32, before int64, requestedBy string) (int64, error) {
+	if !m.manages(topic) {
+		return 0, ErrUnknownTopic
+	}
+
+	oldest, newest, err := m.offsets(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	if before > newest {
+		return 0, fmt.Errorf("offset %d is past the newest dead letter %d", before, newest-1)
+	}
+	if before <= oldest {
+		return 0, nil
+	}
+
+	if err := m.admin.DeleteRecords(topic, map[int32]int64{partition: before}); err != nil {
+		return 0, fmt.Errorf("failed to delete records: %w", err)
+	}
+
+	purged := before - oldest
+	m.audit(ctx, AuditRecord{
+		Action:      ActionPurge,
+		Topic:       topic,
+		Partition:   partition,
+		Offsets:     fmt.Sprintf("%d-%d", oldest, before-1),
+		Detail:      fmt.Sprintf("purged %d", purged),
+		RequestedBy: requestedBy,
+	})
+	return purged, nil
+}
+
+// AuditTrail returns the latest requeues and purges, newest first
+func (m *Manager) AuditTrail(ctx context.Context, limit int) ([]AuditRecord, error) {
+	if m.db == nil {
+		return nil, errors.New("no audit database configured")
+	}
+
+	rows, err := m.db.QueryContext(ctx,
+		"SELECT action, topic, partition_id, offsets, detail, requested_by, DATE_FORMAT(requested_at, '%Y-%m-%dT%H:%i:%sZ') FROM dead_letter_audit ORDER BY id DESC LIMIT ?",
+		limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit trail: %w", err)
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var record AuditRecord
+		var requestedAt string
+		if err := rows.Scan(&record.Action, &record.Topic, &record.Partition, &record.Offsets, &record.Detail, &record.RequestedBy, &requestedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit trail: %w", err)
+		}
+		record.RequestedAt, _ = time.Parse(time.RFC3339, requestedAt)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// audit logs a requeue or purge and keeps it in the audit database
+func (m *Manager) audit(ctx context.Context, record AuditRecord) {
+	log.Printf("Dead letters %s by %s: %s/%d offsets %s, %s",
+		record.Action, record.RequestedBy, record.Topic, record.Partition, record.Offsets, record.Detail)
+
+	if m.db == nil {
+		return
+	}
+	_, err := m.db.ExecContext(ctx,
+		"INSERT INTO dead_letter_audit (action, topic, partition_id, offsets, detail, requested_by) VALUES (?, ?, ?, ?, ?, ?)",
+		record.Action, record.Topic, record.Partition, record.Offsets, record.Detail, record.RequestedBy)
+	if err != nil {
+		log.Printf("Failed to record dead letters %s by %s: %v", record.Action, record.RequestedBy, err)
+	}
+}
+
+// read consumes the messages of a partition from an offset until another,
+// stopping early when the partition doesn't hold them anymore
+func (m *Manager) read(ctx context.Context, topic string, partition int32, from, until int64, fn func(message *sarama.ConsumerMessage)) error {
+	m.readMu.Lock()
+	defer m.readMu.Unlock()
+
+	partitionConsumer, err := m.consumer.ConsumePartition(topic, partition, from)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s/%d: %w", topic, partition, err)
+	}
+	defer partitionConsumer.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to read %s/%d up to offset %d: %w", topic, partition, until, ctx.Err())
+		case message := <-partitionConsumer.Messages():
+			if message.Offset >= until {
+				return nil
+			}
+			fn(message)
+			if message.Offset >= until-1 {
+				return nil
+			}
+		}
+	}
+}
+
+// offsets returns the oldest offset a partition holds and the one its next message will get
+func (m *Manager) offsets(topic string, partition int32) (int64, int64, error) {
+	oldest, err := m.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+	}
+	newest, err := m.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+	}
+	return oldest, newest, nil
+}
+
+// manages reports whether a topic is a managed dead-letter topic
+func (m *Manager) manages(topic string) bool {
+	return slices.Contains(m.topics, topic)
+}
+
+// Close releases resources
+func (m *Manager) Close() error {
+	m.producer.Close()
+	m.consumer.Close()
+	m.admin.Close()
+	if m.db != nil {
+		m.db.Close()
+	}
+	return nil
+}
+
+// Decode describes a consumed dead letter. Values that aren't JSON, e.g.
+// ones that failed to parse, are passed as a string.
+func Decode(message *sarama.ConsumerMessage) Message {
+	letter := Message{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp,
+		Key:       string(message.Key),
+		Value:     message.Value,
+	}
+	for _, header := range message.Headers {
+		switch key := string(header.Key); key {
+		case kafka.ErrorHeader:
+			letter.Error = string(header.Value)
+		case kafka.SourceTopicHeader:
+			letter.SourceTopic = string(header.Value)
+		default:
+			if letter.Headers == nil {
+				letter.Headers = make(map[string]string)
+			}
+			letter.Headers[key] = string(header.Value)
+		}
+	}
+
+	if !json.Valid(message.Value) {
+		letter.Value, _ = json.Marshal(string(message.Value))
+	}
+	return letter
+}
+
+// joinOffsets lists offsets for the audit trail
+func joinOffsets(offsets []int64) string {
+	values := make([]string, len(offsets))
+	for i, offset := range offsets {
+		values[i] = strconv.FormatInt(offset, 10)
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,196 @@
+// Package degraded lets operators skip pipeline stages in an emergency, so
+// one broken dependency doesn't halt every notification. Skipping a stage
+// trades correctness for availability, so every change and every
+// notification that skipped a stage is audited.
+package degraded
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminauth"
+)
+
+// Stages of the rate limiter that can be skipped
+const (
+	StageRateLimiting = "rate_limiting" // Notifications are not counted against or held to quotas
+	StagePreferences  = "preferences"   // Cached preferences, however old, or the defaults are used instead of MySQL
+)
+
+// Stages lists the stages that can be skipped
+var Stages = []string{StageRateLimiting, StagePreferences}
+
+// Switches hold which stages are skipped on this instance
+type Switches struct {
+	stages map[string]*stage
+}
+
+// Status of a stage
+type Status struct {
+	Stage         string     `json:"stage"`
+	Skipped       bool       `json:"skipped"`
+	Since         *time.Time `json:"since,omitempty"`  // When the stage was last skipped or restored
+	By            string     `json:"by,omitempty"`     // Who last skipped or restored it
+	Reason        string     `json:"reason,omitempty"` // Why it was last skipped or restored
+	Notifications int64      `json:"notifications"`    // Notifications that skipped the stage since start
+}
+
+type stage struct {
+	skipped       atomic.Bool
+	notifications atomic.Int64
+
+	mu     sync.Mutex
+	since  time.Time
+	by     string
+	reason string
+}
+
+// New creates the switches, skipping the given stages from the start
+func New(skipped []string) (*Switches, error) {
+	s := &Switches{stages: make(map[string]*stage, len(Stages))}
+	for _, name := range Stages {
+		s.stages[name] = &stage{}
+		metrics.StageSkipped.WithLabelValues(name).Set(0)
+	}
+
+	for _, name := range skipped {
+		if err := s.Skip(name, "startup", "configured at startup"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Skipped reports whether a stage is skipped
+func (s *Switches) Skipped(name string) bool {
+	st, exists := s.stages[name]
+	return exists && st.skipped.Load()
+}
+
+// Record counts a notification that skipped a stage
+func (s *Switches) Record(name string) {
+	if st, exists := s.stages[name]; exists {
+		st.notifications.Add(1)
+		metrics.StageSkippedNotifications.WithLabelValues(name).Inc()
+	}
+}
+
+// Skip starts skipping a stage
+func (s *Switches) Skip(name, by, reason string) error {
+	return s.set(name, true, by, reason)
+}
+
+// Restore stops skipping a stage
+func (s *Switches) Restore(name, by, reason string) error {
+	return s.set(name, false, by, reason)
+}
+
+// set switches a stage and audits the change
+func (s *Switches) set(name string, skipped bool, by, reason string) error {
+	st, exists := s.stages[name]
+	if !exists {
+		return fmt.Errorf("unknown stage %s, expected one of %v", name, Stages)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.skipped.Load() == skipped {
+		return nil
+	}
+	st.skipped.Store(skipped)
+	st.since = time.Now()
+	st.by = by
+	st.reason = reason
+
+	action := "restored"
+	value := 0.0
+	if skipped {
+		action = "skipped"
+		value = 1
+	}
+	metrics.StageSkipped.WithLabelValues(name).Set(value)
+	metrics.StageSkipChanges.WithLabelValues(name, action).Inc()
+	log.Printf("DEGRADED MODE: stage %s %s by %s: %s", name, action, by, reason)
+	return nil
+}
+
+// Status returns the status of every stage
+func (s *Switches) Status() []Status {
+	statuses := make([]Status, 0, len(s.stages))
+	for _, name := range Stages {
+		st := s.stages[name]
+		st.mu.Lock()
+		status := Status{
+			Stage:         name,
+			Skipped:       st.skipped.Load(),
+			By:            st.by,
+			Reason:        st.reason,
+			Notifications: st.notifications.Load(),
+		}
+		if !st.since.IsZero() {
+			since := st.since
+			status.Since = &since
+		}
+		st.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Handler serves the degraded-mode API:
+//
+//	GET  /degraded                    status of every stage
+//	POST /degraded/{stage}/skip       {"reason": "MySQL primary down"}
+//	POST /degraded/{stage}/restore    {"reason": "MySQL recovered"}
+//
+// Changes are audited under the operator who sent them and only apply to
+// this instance.
+func (s *Switches) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /degraded", s.handleStatus)
+	mux.HandleFunc("POST /degraded/{stage}/skip", s.handleSet(true))
+	mux.HandleFunc("POST /degraded/{stage}/restore", s.handleSet(false))
+	return mux
+}
+
+// Handles requests for the status of the stages
+func (s *Switches) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"stages": s.Status(),
+	})
+}
+
+// Returns the handler of requests to skip or restore a stage
+func (s *Switches) handleSet(skipped bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+			http.Error(w, "a reason must be given", http.StatusBadRequest)
+			return
+		}
+		by := adminauth.Actor(r)
+
+		name := r.PathValue("stage")
+		if !slices.Contains(Stages, name) {
+			http.Error(w, fmt.Sprintf("unknown stage %s", name), http.StatusNotFound)
+			return
+		}
+		if skipped {
+			s.Skip(name, by, req.Reason)
+		} else {
+			s.Restore(name, by, req.Reason)
+		}
+		s.handleStatus(w, r)
+	}
+}
@@ -0,0 +1,193 @@
+package deliverystats
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/ratelimiter"
+)
+
+// Fields of a user's last-delivery hash, followed by the event type or channel
+const (
+	eventField   = "event:"
+	channelField = "channel:"
+)
+
+// How long the counts of a day are kept, long enough to outlive the day anywhere
+const dayTTL = 48 * time.Hour
+
+// Config for the delivery stats projection
+type Config struct {
+	Addr            string
+	Password        string
+	CurrentPassword func() string // Optional, read for every new connection so a rotated password is used
+	DB              int
+	Keys            ratelimiter.Keys
+	TTL             time.Duration            // Last deliveries of a user are dropped after this long without one
+	Cooldowns       map[string]time.Duration // Least time between two deliveries to a user on a channel
+	ReadOnly        bool                     // Only read the stats, e.g. on the canary which delivers nothing
+}
+
+// Projection keeps a read model of what was delivered to each user: how
+// many notifications went to every channel today, in UTC, and when each
+// event type and channel last reached the user
+type Projection struct {
+	client    *redis.Client
+	keys      ratelimiter.Keys
+	ttl       time.Duration
+	cooldowns map[string]time.Duration
+	readOnly  bool
+}
+
+// Stats of what was delivered to a user
+type Stats struct {
+	SentToday       map[string]int64     `json:"sent_today"`         // By channel, since midnight UTC
+	LastByChannel   map[string]time.Time `json:"last_by_channel"`    // Last delivery on each channel
+	LastByEventType map[string]time.Time `json:"last_by_event_type"` // Last delivery of each event type
+}
+
+// NewProjection creates a Redis-backed delivery stats projection
+func NewProjection(config Config) (*Projection, error) {
+	client := redis.NewClient(ratelimiter.RedisOptions(config.Addr, config.Password, config.DB, config.CurrentPassword))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &Projection{
+		client:    client,
+		keys:      config.Keys,
+		ttl:       config.TTL,
+		cooldowns: config.Cooldowns,
+		readOnly:  config.ReadOnly,
+	}, nil
+}
+
+// RecordDelivered projects a notification of the event type delivered to
+// the user on the channels
+func (p *Projection) RecordDelivered(ctx context.Context, userID, eventType string, channels []string) error {
+	if p.readOnly {
+		return nil
+	}
+
+	now := time.Now()
+	at := strconv.FormatInt(now.UnixMilli(), 10)
+	lastKey := p.keys.DeliveryStats(userID)
+	dayKey := p.keys.DeliveryStatsDay(userID, day(now))
+
+	pipe := p.client.TxPipeline()
+	pipe.HSet(ctx, lastKey, eventField+eventType, at)
+	for _, channel := range channels {
+		pipe.HSet(ctx, lastKey, channelField+channel, at)
+		pipe.HIncrBy(ctx, dayKey, channel, 1)
+	}
+	pipe.Expire(ctx, lastKey, p.ttl)
+	pipe.Expire(ctx, dayKey, dayTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record delivery stats: %w", err)
+	}
+	return nil
+}
+
+// Stats returns what was delivered to the user
+func (p *Projection) Stats(ctx context.Context, userID string) (*Stats, error) {
+	pipe := p.client.Pipeline()
+	last := pipe.HGetAll(ctx, p.keys.DeliveryStats(userID))
+	today := pipe.HGetAll(ctx, p.keys.DeliveryStatsDay(userID, day(time.Now())))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read delivery stats: %w", err)
+	}
+
+	stats := &Stats{
+		SentToday:       make(map[string]int64),
+		LastByChannel:   make(map[string]time.Time),
+		LastByEventType: make(map[string]time.Time),
+	}
+	for channel, value := range today.Val() {
+		if count, err := strconv.ParseInt(value, 10, 64); err == nil {
+			stats.SentToday[channel] = count
+		}
+	}
+	for field, value := range last.Val() {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if channel, ok := strings.CutPrefix(field, channelField); ok {
+			stats.LastByChannel[channel] = time.UnixMilli(ms)
+		} else if eventType, ok := strings.CutPrefix(field, eventField); ok {
+			stats.LastByEventType[eventType] = time.UnixMilli(ms)
+		}
+	}
+	return stats, nil
+}
+
+// CoolingDown returns the channels among the given ones that reached the
+// user more recently than their cooldown allows
+func (p *Projection) CoolingDown(ctx context.Context, userID string, channels []string) ([]string, error) {
+	fields := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		if p.cooldowns[channel] > 0 {
+			fields = append(fields, channelField+channel)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	values, err := p.client.HMGet(ctx, p.keys.DeliveryStats(userID), fields...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read last deliveries: %w", err)
+	}
+
+	var cooling []string
+	now := time.Now()
+	for i, value := range values {
+		value, ok := value.(string)
+		if !ok {
+			continue
+		}
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		channel := strings.TrimPrefix(fields[i], channelField)
+		if now.Sub(time.UnixMilli(ms)) < p.cooldowns[channel] {
+			cooling = append(cooling, channel)
+		}
+	}
+	return cooling, nil
+}
+
+// DeleteUserData removes the delivery stats kept for a user
+func (p *Projection) DeleteUserData(ctx context.Context, userID string) error {
+	if p.readOnly {
+		return nil
+	}
+
+	// Day counts outlive their day by at most one more
+	now := time.Now()
+	return p.client.Del(ctx,
+		p.keys.DeliveryStats(userID),
+		p.keys.DeliveryStatsDay(userID, day(now)),
+		p.keys.DeliveryStatsDay(userID, day(now.AddDate(0, 0, -1))),
+	).Err()
+}
+
+// Close closes the Redis connection
+func (p *Projection) Close() error {
+	return p.client.Close()
+}
+
+// day returns the UTC day of a time, the days counts are kept by
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
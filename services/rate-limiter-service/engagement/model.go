@@ -0,0 +1,215 @@
+package engagement
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/ratelimiter"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/tracking"
+)
+
+// Fields of a user's engagement hash, followed by the channel
+const (
+	sentField    = "sent:"
+	engagedField = "engaged:"
+)
+
+// Config for the engagement model
+type Config struct {
+	Addr            string
+	Password        string
+	CurrentPassword func() string // Optional, read for every new connection so a rotated password is used
+	DB              int
+	Keys            ratelimiter.Keys
+	TTL             time.Duration // Counts of a user are dropped after this long without sends or engagement
+	MinSends        int           // Sends on a channel before its engagement rate is trusted
+	ExplorePercent  int           // Share of selections that try a channel at random instead of the most engaged one
+	TokenSecret     string        // Signs the tokens opens and action clicks are reported with
+	ReadOnly        bool          // Only read the counts, e.g. on the canary whose copies the primary counts
+}
+
+// Model tracks per user and channel how many notifications were sent and
+// how many of them the user engaged with, by opening one or clicking one of
+// its actions. A notification counts as engaged once per channel.
+type Model struct {
+	client         *redis.Client
+	keys           ratelimiter.Keys
+	ttl            time.Duration
+	minSends       int
+	explorePercent int
+	tokenSecret    []byte
+	readOnly       bool
+}
+
+// Rate is a user's engagement with a channel
+type Rate struct {
+	Sent    int64 `json:"sent"`
+	Engaged int64 `json:"engaged"`
+}
+
+// NewModel creates a Redis-backed engagement model
+func NewModel(config Config) (*Model, error) {
+	client := redis.NewClient(ratelimiter.RedisOptions(config.Addr, config.Password, config.DB, config.CurrentPassword))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &Model{
+		client:         client,
+		keys:           config.Keys,
+		ttl:            config.TTL,
+		minSends:       config.MinSends,
+		explorePercent: config.ExplorePercent,
+		tokenSecret:    []byte(config.TokenSecret),
+		readOnly:       config.ReadOnly,
+	}, nil
+}
+
+// ReadOnly reports whether the model only reads the counts
+func (m *Model) ReadOnly() bool {
+	return m.readOnly
+}
+
+// Token returns the token a notification carries to the user, reported back
+// with its opens and action clicks
+func (m *Model) Token(notificationID, userID string) string {
+	return tracking.Token(m.tokenSecret, notificationID, userID)
+}
+
+// Authentic reports whether an open or action click was reported with the
+// token of the notification the user received
+func (m *Model) Authentic(notificationID, userID, token string) bool {
+	return tracking.Valid(m.tokenSecret, notificationID, userID, token)
+}
+
+// RecordSent counts a notification sent to the user on each of the channels
+func (m *Model) RecordSent(ctx context.Context, userID string, channels []string) error {
+	if m.readOnly {
+		return nil
+	}
+
+	key := m.keys.Engagement(userID)
+	pipe := m.client.TxPipeline()
+	for _, channel := range channels {
+		pipe.HIncrBy(ctx, key, sentField+channel, 1)
+	}
+	pipe.Expire(ctx, key, m.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to count sent notification: %w", err)
+	}
+	return nil
+}
+
+// RecordEngaged counts the user engaging with a notification on a channel,
+// unless the notification's engagement on the channel was already counted
+func (m *Model) RecordEngaged(ctx context.Context, userID, notificationID, channel string) error {
+	if m.readOnly {
+		return nil
+	}
+
+	first, err := m.client.SetNX(ctx, m.keys.EngagementSeen(notificationID, channel), 1, m.ttl).Result()
+	if err != nil {
+		return fmt.Errorf("failed to mark engagement: %w", err)
+	}
+	if !first {
+		return nil
+	}
+
+	key := m.keys.Engagement(userID)
+	pipe := m.client.TxPipeline()
+	pipe.HIncrBy(ctx, key, engagedField+channel, 1)
+	pipe.Expire(ctx, key, m.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to count engagement: %w", err)
+	}
+	return nil
+}
+
+// Rates returns the user's engagement by channel
+func (m *Model) Rates(ctx context.Context, userID string) (map[string]Rate, error) {
+	fields, err := m.client.HGetAll(ctx, m.keys.Engagement(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read engagement: %w", err)
+	}
+
+	rates := make(map[string]Rate)
+	for field, value := range fields {
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if channel, ok := strings.CutPrefix(field, sentField); ok {
+			rate := rates[channel]
+			rate.Sent = count
+			rates[channel] = rate
+		} else if channel, ok := strings.CutPrefix(field, engagedField); ok {
+			rate := rates[channel]
+			rate.Engaged = count
+			rates[channel] = rate
+		}
+	}
+	return rates, nil
+}
+
+// Preferred returns the channel among the given ones the user engages with
+// most, considering only channels with enough sends to judge, or a channel
+// picked at random in the configured share of selections so the others keep
+// being tried. It returns an empty channel when none has enough sends, so the
+// caller keeps its choice, and whether the channel was picked at random.
+func (m *Model) Preferred(ctx context.Context, userID string, channels []string) (string, bool, error) {
+	rates, err := m.Rates(ctx, userID)
+	if err != nil {
+		return "", false, err
+	}
+	channel, explored := choose(rates, channels, max(m.minSends, 1), m.explorePercent, rand.IntN)
+	return channel, explored, nil
+}
+
+// choose picks among the channels the one with the best engagement rate of
+// those sent at least minSends times, or with explorePercent chance one at
+// random. intn returns a random number in [0, n).
+func choose(rates map[string]Rate, channels []string, minSends, explorePercent int, intn func(n int) int) (string, bool) {
+	// Ties go to the channel first by name, so the choice doesn't depend on the order given
+	candidates := slices.Clone(channels)
+	slices.Sort(candidates)
+
+	if len(candidates) > 0 && intn(100) < explorePercent {
+		return candidates[intn(len(candidates))], true
+	}
+
+	preferred, best := "", -1.0
+	for _, channel := range candidates {
+		rate := rates[channel]
+		if rate.Sent < int64(minSends) {
+			continue
+		}
+		if score := float64(rate.Engaged) / float64(rate.Sent); score > best {
+			preferred, best = channel, score
+		}
+	}
+	return preferred, false
+}
+
+// DeleteUserData removes the engagement counts kept for a user
+func (m *Model) DeleteUserData(ctx context.Context, userID string) error {
+	if m.readOnly {
+		return nil
+	}
+	return m.client.Del(ctx, m.keys.Engagement(userID)).Err()
+}
+
+// Close closes the Redis connection
+func (m *Model) Close() error {
+	return m.client.Close()
+}
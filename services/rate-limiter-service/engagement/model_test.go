@@ -0,0 +1,43 @@
+package engagement
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func TestChoose(t *testing.T) {
+	rates := map[string]Rate{
+		"email": {Sent: 100, Engaged: 10},
+		"push":  {Sent: 100, Engaged: 40},
+		"sms":   {Sent: 2, Engaged: 2}, // Too few sends to judge
+	}
+	channels := []string{"sms", "push", "email"}
+	never := func(n int) int { return n - 1 }
+
+	if channel, explored := choose(rates, channels, 5, 10, never); channel != "push" || explored {
+		t.Errorf("choose = %s, %v, want push", channel, explored)
+	}
+	if channel, _ := choose(rates, []string{"sms"}, 5, 10, never); channel != "" {
+		t.Errorf("choose = %s without a channel to judge", channel)
+	}
+
+	// Exploration tries every channel, including those not sent to enough
+	picked := make(map[string]int)
+	random := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 10000; i++ {
+		channel, explored := choose(rates, channels, 5, 10, random.IntN)
+		if explored {
+			picked[channel]++
+		} else if channel != "push" {
+			t.Fatalf("choose = %s without exploring", channel)
+		}
+	}
+	total := picked["email"] + picked["push"] + picked["sms"]
+	if total < 800 || total > 1200 || picked["email"] == 0 || picked["sms"] == 0 {
+		t.Errorf("explored %v of 10000 selections at 10%%", picked)
+	}
+
+	if _, explored := choose(rates, channels, 5, 0, func(int) int { return 0 }); explored {
+		t.Error("explored with exploration off")
+	}
+}
@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// Wait before storing an admin action again after it failed
+const auditRetryBackoff = 5 * time.Second
+
+// AuditConsumer reads the admin actions other services send to the audit
+// topic. Each action only needs storing once, so the instances share a
+// consumer group, and the group starts at the oldest action so none is lost.
+type AuditConsumer struct {
+	consumerGroup sarama.ConsumerGroup
+	verifier      *signing.Verifier
+	topic         string
+	onAction      func(ctx context.Context, action models.AdminAction) error
+}
+
+// NewAuditConsumer creates a consumer for the audit topic, ensuring the topic
+// exists with the partitions and replication of the producer's
+func NewAuditConsumer(cfg config.KafkaProducerConfig, topic, groupID string) (*AuditConsumer, error) {
+	topicManager, err := NewTopicManager(cfg.Brokers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create topic manager: %w", err)
+	}
+	defer topicManager.Close()
+
+	auditCfg := cfg
+	auditCfg.Topic = topic
+	if err := topicManager.EnsureTopicExists(auditCfg); err != nil {
+		return nil, fmt.Errorf("failed to ensure audit topic exists: %w", err)
+	}
+
+	verifier, err := signing.NewVerifier(cfg.Signing)
+	if err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	consumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, groupID, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	return &AuditConsumer{
+		consumerGroup: consumerGroup,
+		verifier:      verifier,
+		topic:         topic,
+	}, nil
+}
+
+// Start consumes admin actions and calls onAction for each, until the
+// context is done
+func (c *AuditConsumer) Start(ctx context.Context, onAction func(ctx context.Context, action models.AdminAction) error) error {
+	c.onAction = onAction
+
+	log.Printf("Consuming admin actions on %s", c.topic)
+	for {
+		if err := c.consumerGroup.Consume(ctx, []string{c.topic}, c); err != nil {
+			log.Printf("Error consuming admin actions: %v", err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Setup is run at the beginning of a new session
+func (c *AuditConsumer) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session
+func (c *AuditConsumer) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim stores the admin actions of a partition. An action that
+// fails to be stored is retried until it is, rather than missing from the trail.
+func (c *AuditConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for message := range claim.Messages() {
+		if rejects(c.verifier, message) {
+			session.MarkMessage(message, "")
+			continue
+		}
+
+		var action models.AdminAction
+		if err := json.Unmarshal(message.Value, &action); err != nil {
+			log.Printf("Error unmarshalling admin action: %v", err)
+			session.MarkMessage(message, "")
+			continue
+		}
+		for {
+			err := c.onAction(session.Context(), action)
+			if err == nil {
+				break
+			}
+			log.Printf("Failed to store admin action %s, retrying in %v: %v", action.ID, auditRetryBackoff, err)
+			select {
+			case <-time.After(auditRetryBackoff):
+			case <-session.Context().Done():
+				return nil
+			}
+		}
+		session.MarkMessage(message, "")
+	}
+	return nil
+}
+
+// Close releases resources
+func (c *AuditConsumer) Close() error {
+	return c.consumerGroup.Close()
+}
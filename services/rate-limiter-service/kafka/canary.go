@@ -0,0 +1,166 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Receives the outcome of every processed notification
+type DecisionRecorder interface {
+	// Record is called with the channels a notification was delivered to,
+	// empty when it was rate limited or had no channel to go to
+	Record(ctx context.Context, notification *models.PrioritizedNotification, channels []string)
+	Close() error
+}
+
+// CanaryMirror copies a sample of the primary's traffic, together with the
+// primary's decision, to the canary copy of its priority topic
+type CanaryMirror struct {
+	producer      *KafkaProducer
+	topics        map[string]string // Canary topic by priority
+	samplePercent int
+}
+
+// Creates a mirror for samplePercent of the traffic, ensuring the canary topics exist
+func NewCanaryMirror(cfg config.KafkaProducerConfig, priorities []config.PriorityLevelConfig, canary config.CanaryConfig) (*CanaryMirror, error) {
+	topics := make(map[string]string, len(priorities))
+	canaryTopics := make([]string, 0, len(priorities))
+	for _, level := range priorities {
+		topics[level.Name] = level.Topic + canary.TopicSuffix
+		canaryTopics = append(canaryTopics, topics[level.Name])
+	}
+
+	// Ensure the canary topics exist and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), canaryTopics...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CanaryMirror{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		topics:        topics,
+		samplePercent: canary.SamplePercent,
+	}, nil
+}
+
+// Mirrors the notification when it is sampled. Failures are only logged so
+// the canary can never hold up real traffic.
+func (m *CanaryMirror) Record(ctx context.Context, notification *models.PrioritizedNotification, channels []string) {
+	topic, exists := m.topics[notification.Priority]
+	if !exists || !sampled(notification, m.samplePercent) {
+		return
+	}
+
+	logger := logging.ForRequest(notification.RequestID)
+	mirrored := *notification
+	mirrored.CanaryBaseline = &models.CanaryBaseline{Channels: channels}
+
+	payload, err := json.Marshal(&mirrored)
+	if err != nil {
+		logger.Printf("Failed to marshal canary copy of notification %s: %v", notification.ID, err)
+		return
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.StringEncoder(notification.UserID),
+		Value:   sarama.ByteEncoder(payload),
+		Headers: notificationHeaders(notification.RequestID, notification.EventType, notification.Metadata, notification.Priority),
+	}
+	if _, _, err := m.producer.send(ctx, msg); err != nil {
+		logger.Printf("Failed to mirror notification %s to the canary: %v", notification.ID, err)
+	}
+}
+
+// Closes the mirror's producer
+func (m *CanaryMirror) Close() error {
+	return m.producer.Close()
+}
+
+// CanaryComparator compares the decisions of a canary instance with the
+// baseline the primary attached to each mirrored notification
+type CanaryComparator struct{}
+
+// Creates the comparator of a canary instance
+func NewCanaryComparator() *CanaryComparator {
+	return &CanaryComparator{}
+}
+
+// Compares the canary's channels with the primary's
+func (c *CanaryComparator) Record(ctx context.Context, notification *models.PrioritizedNotification, channels []string) {
+	logger := logging.ForRequest(notification.RequestID)
+	baseline := notification.CanaryBaseline
+	if baseline == nil {
+		logger.Printf("Canary: notification %s has no baseline, was it mirrored by a primary?", notification.ID)
+		return
+	}
+
+	if !sameChannels(channels, baseline.Channels) {
+		metrics.CanaryDecisions.WithLabelValues("mismatch").Inc()
+		logger.Printf("Canary mismatch: notification %s (%s) for user %s goes to %v, primary chose %v",
+			notification.ID, notification.EventType, notification.UserID, channels, baseline.Channels)
+		return
+	}
+
+	metrics.CanaryDecisions.WithLabelValues("match").Inc()
+}
+
+// Nothing to close
+func (c *CanaryComparator) Close() error {
+	return nil
+}
+
+// DiscardProducer stands in for the delivery producer of a canary, whose
+// notifications must never be delivered
+type DiscardProducer struct{}
+
+// Creates a producer that drops everything
+func NewDiscardProducer() Producer {
+	return &DiscardProducer{}
+}
+
+// Drops the notification
+func (p *DiscardProducer) SendMessage(ctx context.Context, notification *models.ProcessedNotification) error {
+	return nil
+}
+
+// Nothing to close
+func (p *DiscardProducer) Close() error {
+	return nil
+}
+
+// Reports whether two channel lists hold the same channels, in any order
+func sameChannels(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// Reports whether a notification falls into the sampled percentage. The
+// decision only depends on the user, so every instance samples all of a
+// user's notifications or none.
+func sampled(notification *models.PrioritizedNotification, percent int) bool {
+	if percent <= 0 {
+		return false
+	}
+	key := notification.UserID
+	if key == "" {
+		key = notification.ID
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32()%100) < percent
+}
@@ -0,0 +1,33 @@
+package kafka
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSampledKeepsUsersTogether(t *testing.T) {
+	inSample := 0
+	for user := 0; user < 1000; user++ {
+		first := testNotification("n-0")
+		first.UserID = fmt.Sprintf("user-%d", user)
+		want := sampled(first, 10)
+		if want {
+			inSample++
+		}
+
+		for i := 1; i < 20; i++ {
+			notification := testNotification(fmt.Sprintf("n-%d", i))
+			notification.UserID = first.UserID
+			if sampled(notification, 10) != want {
+				t.Fatalf("notifications of %s split between canary and baseline", first.UserID)
+			}
+		}
+	}
+
+	if inSample < 50 || inSample > 150 {
+		t.Errorf("%d of 1000 users sampled at 10%%", inSample)
+	}
+	if sampled(testNotification("n-1"), 0) || !sampled(testNotification("n-1"), 100) {
+		t.Error("sampling ignores 0% or 100%")
+	}
+}
@@ -0,0 +1,175 @@
+package kafka
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/encryption"
+)
+
+// coalescer folds identical delayed notifications waiting in the scheduler's
+// wheel for the same target into the first of them, which goes out with the
+// count of all. Notifications are identical when they agree on every
+// coalescing key. Folded copies are marked once the one standing for them
+// was sent, so none is lost to a crash or a rebalance. Encrypted content and
+// metadata are compared once decrypted, as every encryption differs.
+type coalescer struct {
+	keys      []string                   // Fields notifications are coalesced by, e.g. user_id, event_type and content
+	decryptor *encryption.FieldEncryptor // Optional, when the enqueue service encrypts notifications
+
+	mu      sync.Mutex
+	pending map[string]*coalesced // By coalescing key of the notification in the wheel
+}
+
+// coalesced is a delayed notification waiting in the wheel, with the
+// identical ones folded into it
+type coalesced struct {
+	ctx       context.Context // Of the session the notification was read in
+	deliverAt time.Time
+	copies    []foldedCopy
+}
+
+// foldedCopy is a delayed notification folded into an identical one
+type foldedCopy struct {
+	ctx       context.Context
+	marks     *offsetMarks
+	message   *sarama.ConsumerMessage
+	deliverAt time.Time
+	target    string
+	count     int // Notifications the copy stands for itself
+}
+
+// newCoalescer creates a coalescer by the keys, nil without keys
+func newCoalescer(keys []string, decryptor *encryption.FieldEncryptor) *coalescer {
+	if len(keys) == 0 {
+		return nil
+	}
+	return &coalescer{keys: keys, decryptor: decryptor, pending: make(map[string]*coalesced)}
+}
+
+// key returns the coalescing key of a delayed message going to its target,
+// false for messages that aren't notifications
+func (c *coalescer) key(message *sarama.ConsumerMessage, target string) (string, int, bool) {
+	var notification models.PrioritizedNotification
+	if err := json.Unmarshal(message.Value, &notification); err != nil || notification.ID == "" {
+		return "", 0, false
+	}
+	// A notification that can't be decrypted is sent on its own
+	if c.decryptor != nil {
+		decrypted, err := c.decryptor.Decrypt(&notification.NotificationEvent)
+		if err != nil {
+			return "", 0, false
+		}
+		notification.NotificationEvent = *decrypted
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(target))
+	for _, key := range c.keys {
+		fmt.Fprintf(hash, "\x00%s=", key)
+		switch {
+		case key == "user_id":
+			hash.Write([]byte(notification.UserID))
+		case key == "event_type":
+			hash.Write([]byte(notification.EventType))
+		case key == "content":
+			hash.Write([]byte(contentHash(&notification)))
+		case key == "priority":
+			hash.Write([]byte(notification.Priority))
+		case key == "group_key":
+			hash.Write([]byte(notification.GroupKey))
+		case key == "thread_id":
+			hash.Write([]byte(notification.ThreadID))
+		case strings.HasPrefix(key, "metadata."):
+			value, _ := json.Marshal(notification.Metadata[strings.TrimPrefix(key, "metadata.")])
+			hash.Write(value)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), max(notification.CoalescedCount, 1), true
+}
+
+// fold folds a copy into an identical notification waiting in the wheel and
+// due no later than the copy. It reports false when there is none, and the
+// copy goes into the wheel itself.
+func (c *coalescer) fold(key string, duplicate foldedCopy) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending, ok := c.pending[key]
+	if !ok || pending.ctx.Err() != nil || pending.deliverAt.After(duplicate.deliverAt) {
+		return false
+	}
+	pending.copies = append(pending.copies, duplicate)
+	return true
+}
+
+// hold records a notification put into the wheel for identical ones to fold
+// into, unless one already waits there
+func (c *coalescer) hold(ctx context.Context, key string, deliverAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pending, ok := c.pending[key]; ok && pending.ctx.Err() == nil {
+		return false
+	}
+	c.pending[key] = &coalesced{ctx: ctx, deliverAt: deliverAt}
+	return true
+}
+
+// take returns the copies folded into a notification leaving the wheel. Later
+// identical notifications don't fold into it anymore.
+func (c *coalescer) take(key string) []foldedCopy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending, ok := c.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(c.pending, key)
+	return pending.copies
+}
+
+// withCopies adds the copies folded into a delayed notification to the count
+// of notifications it stands for, leaving every other field as it was
+func withCopies(value []byte, copies []foldedCopy) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notification: %w", err)
+	}
+
+	count := 1
+	if raw, ok := fields["coalesced_count"]; ok {
+		if err := json.Unmarshal(raw, &count); err != nil || count < 1 {
+			count = 1
+		}
+	}
+	for _, duplicate := range copies {
+		count += duplicate.count
+	}
+	fields["coalesced_count"] = json.RawMessage(strconv.Itoa(count))
+	return json.Marshal(fields)
+}
+
+// contentHash returns a hash of what a notification says, on every channel
+func contentHash(notification *models.PrioritizedNotification) string {
+	hash := sha256.New()
+	hash.Write([]byte(notification.Content))
+	hash.Write([]byte{0})
+	hash.Write([]byte(notification.ContentRef))
+	hash.Write([]byte{0})
+	channelContent, _ := json.Marshal(notification.ChannelContent)
+	hash.Write(channelContent)
+	actions, _ := json.Marshal(notification.Actions)
+	hash.Write(actions)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -2,462 +2,753 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"reflect"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/failures"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
 )
 
 // PriorityConsumer consumes messages from multiple Kafka topics with priority ordering
 type PriorityConsumer interface {
 	Start(ctx context.Context, messageHandler func(*models.PrioritizedNotification) error) error
+	// StopIntake stops fetching from Kafka and waits until the lanes take no more messages
+	StopIntake(ctx context.Context) error
+	// Drain waits until the buffered messages are processed, dropping the rest once ctx is done
+	Drain(ctx context.Context) error
+	// Stats reports the depth of each lane and how busy the processor is
+	Stats() ConsumerStats
 	Close() error
 }
 
+// Snapshot of the priority consumer for diagnostics
+type ConsumerStats struct {
+	Lanes       []LaneStats `json:"lanes"`
+	Processed   int64       `json:"processed"`    // Messages handled since start
+	BusySeconds float64     `json:"busy_seconds"` // Time spent in the message handler since start
+	Utilization float64     `json:"utilization"`  // Share of time since creation the processor was busy
+}
+
+// Snapshot of a single priority lane
+type LaneStats struct {
+	Priority string `json:"priority"`
+	Topic    string `json:"topic"`
+	Buffered int    `json:"buffered"` // Messages waiting in the lane's channel
+	Capacity int    `json:"capacity"`
+	Spilled  int    `json:"spilled"` // Notifications waiting in the lane's spill file
+	Lag      int64  `json:"lag"`     // Messages behind the topic's high watermark, buffered ones included
+	Paused   bool   `json:"paused"`  // Fetching stopped while more urgent lanes lag
+	Failed   int64  `json:"failed"`  // Notifications that failed for good since start, dead-lettered or dropped
+}
+
 // KafkaPriorityConsumer implements the PriorityConsumer interface using Sarama
 type KafkaPriorityConsumer struct {
-	// Separate consumer group for each priority level
-	highConsumerGroup  sarama.ConsumerGroup
-	mediumConsumerGroup sarama.ConsumerGroup
-	lowConsumerGroup    sarama.ConsumerGroup
-
-	topicHigh     string
-	topicMedium   string
-	topicLow      string
-	readyHigh     chan bool
-	readyMedium   chan bool
-	readyLow      chan bool
-	mu            sync.Mutex
-
-	// Channels for controlling consumption rate between different priority levels
-	highPriorityMessages   chan *models.PrioritizedNotification
-	mediumPriorityMessages chan *models.PrioritizedNotification
-	lowPriorityMessages    chan *models.PrioritizedNotification
-}
+	// One lane per priority level, ordered from most to least urgent
+	lanes       []*priorityLane
+	filter      headerFilter
+	verifier    *signing.Verifier // Nil accepts messages without checking their signature
+	passthrough int               // Metadata values longer than this stay undecoded
+	mu          sync.Mutex
+
+	// Failure handling
+	retryMax      int
+	retryBackoff  time.Duration
+	deadLetters   *DeadLetterProducer // Optional, failed notifications are dropped without it
+	delayer       *Delayer            // Optional, defers notifications and retries ones that exhausted their retries later
+	retryDelay    time.Duration       // Zero disables retrying later
+	retryDelayMax int
+	lagPause      config.LagPauseConfig
+	watchdog      config.WatchdogConfig
 
-// Sarama ConsumerGroupHandler implementation for high priority messages
-type highPriorityHandler struct {
-	ready          chan bool
-	messages       chan <- *models.PrioritizedNotification
-	mu             sync.Mutex
-	isReady        bool
+	// Shutdown: intake stops fetching, processing stops the processor
+	intake         context.Context
+	stopIntake     context.CancelFunc
+	processing     context.Context
+	stopProcessing context.CancelFunc
+	intakeDone     chan struct{} // Closed once the lanes take no more messages
+	stopped        chan struct{} // Closed once Start returned
+
+	// Processor activity, for diagnostics
+	started   time.Time
+	processed atomic.Int64
+	busy      atomic.Int64 // Nanoseconds spent in the message handler
 }
 
-// Sarama ConsumerGroupHandler implementation for medium priority messages
-type mediumPriorityHandler struct {
-	ready          chan bool
-	messages       chan<- *models.PrioritizedNotification
-	mu             sync.Mutex
-	isReady        bool
+// priorityLane holds the consumer group and buffered channel of a single priority level
+type priorityLane struct {
+	priority      string
+	topic         string
+	topics        []string // The topic itself or the copies of the consumed tenants
+	weight        int
+	consumerGroup sarama.ConsumerGroup
+	ready         chan bool
+
+	// Channel for controlling consumption rate between different priority levels
+	messages chan *models.PrioritizedNotification
+
+	overflow string      // config.OverflowBlock, OverflowSpill or OverflowShed
+	spill    *spillQueue // Only set for the spill overflow policy
+
+	lag    laneLag
+	paused atomic.Bool  // Fetching stopped while more urgent lanes lag
+	failed atomic.Int64 // Notifications that failed for good since start
+
+	// Stall detection
+	claims    atomic.Int32 // Partitions claimed by the current session
+	progress  atomic.Int64 // Unix nanoseconds the lane last moved on
+	restartMu sync.Mutex
+	restart   context.CancelFunc // Ends the current session
 }
 
-// Sarama ConsumerGroupHandler implementation for low priority messages
-type lowPriorityHandler struct {
-	ready          chan bool
-	messages       chan<- *models.PrioritizedNotification
-	mu             sync.Mutex
-	isReady        bool
+// Sarama ConsumerGroupHandler implementation for a single priority level
+type priorityHandler struct {
+	priority    string
+	ready       chan bool
+	messages    chan<- *models.PrioritizedNotification
+	filter      headerFilter
+	verifier    *signing.Verifier
+	passthrough int
+	deadLetters *DeadLetterProducer
+	lane        *priorityLane
+	lag         *laneLag
+	mu          sync.Mutex
+	isReady     bool
 }
 
 // NewPriorityConsumer creates a new Kafka consumer with priority handling
-func NewPriorityConsumer(cfg config.KafkaConsumerConfig) (PriorityConsumer, error) {
+func NewPriorityConsumer(cfg config.KafkaConsumerConfig, priorities []config.PriorityLevelConfig, deadLetters *DeadLetterProducer, delayer *Delayer) (PriorityConsumer, error) {
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
 	config.Consumer.Offsets.Initial = sarama.OffsetNewest
-	
-	// Create separate consumer groups for each priority level
-	highConsumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID+"-high", config)
-	if err != nil {
-		return nil, err
-	}
-	
-	mediumConsumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID+"-medium", config)
-	if err != nil {
-		// Close the high consumer group if medium fails
-		highConsumerGroup.Close()
-		return nil, err
-	}
-	
-	lowConsumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID+"-low", config)
+
+	verifier, err := signing.NewVerifier(cfg.Signing)
 	if err != nil {
-		// Close the other consumer groups if low fails
-		highConsumerGroup.Close()
-		mediumConsumerGroup.Close()
 		return nil, err
 	}
 
 	consumer := &KafkaPriorityConsumer{
-		highConsumerGroup:   highConsumerGroup,
-		mediumConsumerGroup: mediumConsumerGroup,
-		lowConsumerGroup:    lowConsumerGroup,
-		topicHigh:     cfg.TopicHigh,
-		topicMedium:   cfg.TopicMedium,
-		topicLow:      cfg.TopicLow,
-		readyHigh:     make(chan bool),
-		readyMedium:   make(chan bool),
-		readyLow:      make(chan bool),
-		
-		// Buffered channels for each priority level
-		// Higher priority has larger buffer to ensure it's processed first
-		highPriorityMessages:   make(chan *models.PrioritizedNotification, 1000),
-		mediumPriorityMessages: make(chan *models.PrioritizedNotification, 500),
-		lowPriorityMessages:    make(chan *models.PrioritizedNotification, 100),
+		lanes:       make([]*priorityLane, 0, len(priorities)),
+		filter:      newHeaderFilter(cfg.Filter),
+		verifier:    verifier,
+		passthrough: cfg.MetadataPassthroughBytes,
+		started:     time.Now(),
+
+		retryMax:      cfg.RetryMax,
+		retryBackoff:  cfg.RetryBackoff,
+		deadLetters:   deadLetters,
+		delayer:       delayer,
+		retryDelay:    cfg.RetryDelay,
+		retryDelayMax: cfg.RetryDelayMax,
+		lagPause:      cfg.LagPause,
+		watchdog:      cfg.Watchdog,
+
+		intakeDone: make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	consumer.intake, consumer.stopIntake = context.WithCancel(context.Background())
+	consumer.processing, consumer.stopProcessing = context.WithCancel(context.Background())
+
+	// Create a separate consumer group for each priority level
+	for _, level := range priorities {
+		consumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, LaneGroupID(cfg.GroupID, level.Name), config)
+		if err != nil {
+			// Close the consumer groups created so far
+			consumer.Close()
+			return nil, err
+		}
+
+		lane := &priorityLane{
+			priority:      level.Name,
+			topic:         level.Topic,
+			topics:        LaneTopics(level.Topic, cfg.Tenants),
+			weight:        level.Weight,
+			consumerGroup: consumerGroup,
+			ready:         make(chan bool),
+			messages:      make(chan *models.PrioritizedNotification, level.Buffer),
+			overflow:      level.Overflow,
+		}
+		consumer.lanes = append(consumer.lanes, lane)
+
+		if lane.spills() {
+			if lane.spill, err = newSpillQueue(cfg.SpillDir, level.Name, cfg.MetadataPassthroughBytes, cfg.SpillMaxBytes); err != nil {
+				consumer.Close()
+				return nil, err
+			}
+		}
 	}
 
 	return consumer, nil
 }
 
-// Start consuming messages from Kafka
+// Start consuming messages from Kafka. It returns once intake stopped and
+// the buffered messages are processed, or once Drain gave up on them.
 func (c *KafkaPriorityConsumer) Start(ctx context.Context, messageHandler func(*models.PrioritizedNotification) error) error {
-	// Create context for consumers
-	consumerCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	
-	// Create wait group for all goroutines
+	defer close(c.stopped)
+
+	// Cancelling ctx stops intake, StopIntake can do so before
+	stop := context.AfterFunc(ctx, c.stopIntake)
+	defer stop()
+	consumerCtx := c.intake
+
+	// Create wait group for the goroutines feeding the lanes
 	wg := &sync.WaitGroup{}
-	wg.Add(4) // 3 consumer handlers + 1 processor
-	
-	// Start high priority consumer
-	go func() {
-		defer wg.Done()
-		handler := &highPriorityHandler{
-			ready:    c.readyHigh,
-			messages: c.highPriorityMessages,
-		}
-		
-		for {
-			if consumerCtx.Err() != nil {
-				return
-			}
-			
-			if err := c.highConsumerGroup.Consume(consumerCtx, []string{c.topicHigh}, handler); err != nil {
-				log.Printf("Error consuming from high priority topic: %v", err)
-			}
-			
-			if consumerCtx.Err() != nil {
-				return
-			}
-		}
-	}()
-	
-	// Start medium priority consumer
-	go func() {
-		defer wg.Done()
-		handler := &mediumPriorityHandler{
-			ready:    c.readyMedium,
-			messages: c.mediumPriorityMessages,
+	wg.Add(len(c.lanes)) // consumer handlers
+
+	// Feed notifications spilled to disk back into their lanes
+	for _, lane := range c.lanes {
+		if lane.spill != nil {
+			wg.Add(1)
+			go func(lane *priorityLane) {
+				defer wg.Done()
+				lane.spill.drain(consumerCtx, lane.messages)
+			}(lane)
 		}
-		
-		for {
-			if consumerCtx.Err() != nil {
-				return
-			}
-			
-			if err := c.mediumConsumerGroup.Consume(consumerCtx, []string{c.topicMedium}, handler); err != nil {
-				log.Printf("Error consuming from medium priority topic: %v", err)
+	}
+
+	// Pause the less urgent lanes while the others lag
+	if c.lagPause.Threshold > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.watchLag(consumerCtx, c.lagPause)
+		}()
+	}
+
+	// Restart the sessions of lanes that stopped moving on
+	if c.watchdog.StallTimeout > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.watchStalls(consumerCtx, c.watchdog)
+		}()
+	}
+
+	// Start a consumer for every priority level
+	for _, lane := range c.lanes {
+		go func(lane *priorityLane) {
+			defer wg.Done()
+			handler := &priorityHandler{
+				priority: lane.priority,
+				ready:    lane.ready,
+				messages: lane.messages,
+				filter:   c.filter,
+				verifier: c.verifier,
+				lane:     lane,
+				lag:      &lane.lag,
+
+				passthrough: c.passthrough,
+
+				deadLetters: c.deadLetters,
 			}
-			
-			if consumerCtx.Err() != nil {
-				return
+
+			for {
+				if consumerCtx.Err() != nil {
+					return
+				}
+
+				// The watchdog ends a stalled session early
+				sessionCtx, restart := context.WithCancel(consumerCtx)
+				lane.setRestart(restart)
+				if err := lane.consumerGroup.Consume(sessionCtx, lane.topics, handler); err != nil {
+					log.Printf("Error consuming from %s priority topic: %v", lane.priority, err)
+				}
+				restart()
+
+				if consumerCtx.Err() != nil {
+					return
+				}
 			}
-		}
-	}()
-	
-	// Start low priority consumer
+		}(lane)
+	}
+
+	// The lanes take no more messages once everything feeding them returned
 	go func() {
-		defer wg.Done()
-		handler := &lowPriorityHandler{
-			ready:    c.readyLow,
-			messages: c.lowPriorityMessages,
-		}
-		
-		for {
-			if consumerCtx.Err() != nil {
-				return
-			}
-			
-			if err := c.lowConsumerGroup.Consume(consumerCtx, []string{c.topicLow}, handler); err != nil {
-				log.Printf("Error consuming from low priority topic: %v", err)
-			}
-			
-			if consumerCtx.Err() != nil {
-				return
-			}
-		}
+		wg.Wait()
+		close(c.intakeDone)
 	}()
 
 	log.Println("Waiting for all priority consumers to start")
-	
+
 	// Wait for all consumers to be ready
-	<-c.readyHigh
-	<-c.readyMedium
-	<-c.readyLow
-	
+	for _, lane := range c.lanes {
+		select {
+		case <-lane.ready:
+		case <-c.intakeDone:
+			return nil
+		}
+	}
+
 	log.Println("All priority consumers are ready")
-	
-	// Start priority message processor
-	go func() {
-		defer wg.Done()
-		for {
+
+	// Process messages until the lanes are drained
+	credits := c.newCredits()
+	for {
+		lane, msg, ok := c.nextMessage(c.processing, credits)
+		if !ok {
+			log.Println("Priority processor shutting down...")
+			return nil
+		}
+
+		lane.markProgress()
+
+		// Lane messages are already committed, so retry transient
+		// failures here rather than through redelivery
+		handleStart := time.Now()
+		err := failures.WithRetries(c.processing, c.retryMax, c.retryBackoff, func() error {
+			return messageHandler(msg)
+		})
+		c.busy.Add(int64(time.Since(handleStart)))
+		c.processed.Add(1)
+
+		if err != nil {
+			c.fail(c.processing, lane, msg, err)
+		}
+	}
+}
+
+// StopIntake stops the consumer groups from fetching and waits until the
+// lanes take no more messages. Buffered messages stay for Drain.
+func (c *KafkaPriorityConsumer) StopIntake(ctx context.Context) error {
+	c.stopIntake()
+
+	select {
+	case <-c.intakeDone:
+		return nil
+	case <-c.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("lanes still taking messages: %w", ctx.Err())
+	}
+}
+
+// Drain waits until the processor handled every buffered message. Their
+// offsets are already committed, so messages left once ctx is done are lost.
+func (c *KafkaPriorityConsumer) Drain(ctx context.Context) error {
+	select {
+	case <-c.stopped:
+		return nil
+	case <-ctx.Done():
+	}
+
+	c.stopProcessing()
+	<-c.stopped
+
+	buffered := 0
+	for _, lane := range c.lanes {
+		buffered += len(lane.messages)
+	}
+	return fmt.Errorf("dropped %d buffered messages: %w", buffered, ctx.Err())
+}
+
+// fail settles a notification that failed for good: rate-limited notifications
+// are dropped, deferred ones are sent again once due, everything else is
+// parked on the dead-letter topic
+func (c *KafkaPriorityConsumer) fail(ctx context.Context, lane *priorityLane, notification *models.PrioritizedNotification, err error) {
+	logger := logging.ForRequest(notification.RequestID)
+	if failures.ActionFor(err) == failures.ActionDrop {
+		return
+	}
+	if c.deferUntilDue(ctx, lane, notification, err) {
+		return
+	}
+	if c.retryLater(ctx, lane, notification, err) {
+		return
+	}
+	lane.failed.Add(1)
+	if c.deadLetters == nil {
+		logger.Printf("Dropping %s priority notification %s: %v", lane.priority, notification.ID, err)
+		return
+	}
+
+	logger.Printf("Dead-lettering %s priority notification %s: %v", lane.priority, notification.ID, err)
+
+	// The notification is already committed, so park it even while shutting down
+	if dlErr := c.deadLetters.SendNotification(context.WithoutCancel(ctx), lane.topic, notification, err); dlErr != nil {
+		logger.Printf("Failed to dead-letter notification %s, dropping it: %v", notification.ID, dlErr)
+	}
+}
+
+// retryLater puts a notification that still fails transiently after its
+// retries back on its priority topic through the delay topics, doubling the
+// delay every time. It reports false once the notification was retried later
+// often enough, or couldn't be.
+func (c *KafkaPriorityConsumer) retryLater(ctx context.Context, lane *priorityLane, notification *models.PrioritizedNotification, err error) bool {
+	if c.delayer == nil || c.retryDelay <= 0 || failures.ActionFor(err) != failures.ActionRetry || notification.DelayedRetries >= c.retryDelayMax {
+		return false
+	}
+
+	logger := logging.ForRequest(notification.RequestID)
+	wait := c.retryDelay << notification.DelayedRetries
+	notification.DelayedRetries++
+
+	// The notification is already committed, so delay it even while shutting down
+	if dErr := c.delayer.DelayNotification(context.WithoutCancel(ctx), lane.topic, notification, time.Now().Add(wait)); dErr != nil {
+		logger.Printf("Failed to delay retry of notification %s: %v", notification.ID, dErr)
+		return false
+	}
+	logger.Printf("Retrying %s priority notification %s in %v (%d of %d): %v",
+		lane.priority, notification.ID, wait, notification.DelayedRetries, c.retryDelayMax, err)
+	return true
+}
+
+// deferUntilDue puts a deferred notification back on its priority topic
+// through the delay topics, to be processed again once it is due. It reports
+// false when the notification wasn't deferred, or couldn't be.
+func (c *KafkaPriorityConsumer) deferUntilDue(ctx context.Context, lane *priorityLane, notification *models.PrioritizedNotification, err error) bool {
+	until, deferred := failures.DeferredUntil(err)
+	if c.delayer == nil || !deferred {
+		return false
+	}
+
+	// The notification is already committed, so delay it even while shutting
+	// down. It is held back on purpose, its deadline no longer applies.
+	logger := logging.ForRequest(notification.RequestID)
+	notification.Deadline = 0
+	if dErr := c.delayer.DelayNotification(context.WithoutCancel(ctx), lane.topic, notification, until); dErr != nil {
+		logger.Printf("Failed to defer notification %s: %v", notification.ID, dErr)
+		return false
+	}
+	logger.Printf("Deferred %s priority notification %s until %s: %v",
+		lane.priority, notification.ID, until.Format(time.RFC3339), err)
+	return true
+}
+
+// newCredits returns the per-lane credits for a fresh scheduling round
+func (c *KafkaPriorityConsumer) newCredits() []int {
+	credits := make([]int, len(c.lanes))
+	c.resetCredits(credits)
+	return credits
+}
+
+// resetCredits refills every lane with as many credits as its weight
+func (c *KafkaPriorityConsumer) resetCredits(credits []int) {
+	for i, lane := range c.lanes {
+		credits[i] = lane.weight
+	}
+}
+
+// nextMessage picks the next message from the lanes in priority order, each
+// served at most its weight in a row before lower levels get a turn
+func (c *KafkaPriorityConsumer) nextMessage(ctx context.Context, credits []int) (*priorityLane, *models.PrioritizedNotification, bool) {
+	for {
+		if ctx.Err() != nil {
+			return nil, nil, false
+		}
+
+		exhausted := false
+		for i, lane := range c.lanes {
+			if credits[i] == 0 {
+				exhausted = true
+				continue
+			}
+
 			select {
-			case <-consumerCtx.Done():
-				log.Println("Priority processor shutting down...")
-				return
-				
-			// First check high priority messages
-			case msg := <-c.highPriorityMessages:
-				if err := messageHandler(msg); err != nil {
-					log.Printf("Error processing high priority message: %v", err)
-				}
-				
-			// If no high priority messages, check medium priority
-			case msg := <-c.mediumPriorityMessages:
-				// Before processing medium priority, check if high priority is available
-				select {
-				case highMsg := <-c.highPriorityMessages:
-					// Process high priority first
-					if err := messageHandler(highMsg); err != nil {
-						log.Printf("Error processing high priority message: %v", err)
-					}
-					// Then process medium priority
-					if err := messageHandler(msg); err != nil {
-						log.Printf("Error processing medium priority message: %v", err)
-					}
-				default:
-					// No high priority, process medium
-					if err := messageHandler(msg); err != nil {
-						log.Printf("Error processing medium priority message: %v", err)
-					}
-				}
-				
-			// If no high or medium priority, check low priority
-			case msg := <-c.lowPriorityMessages:
-				// Before processing low priority, check if higher priorities are available
-				select {
-				case highMsg := <-c.highPriorityMessages:
-					// Process high priority first
-					if err := messageHandler(highMsg); err != nil {
-						log.Printf("Error processing high priority message: %v", err)
-					}
-					// Then process low priority
-					if err := messageHandler(msg); err != nil {
-						log.Printf("Error processing low priority message: %v", err)
-					}
-				case medMsg := <-c.mediumPriorityMessages:
-					// Process medium priority first
-					if err := messageHandler(medMsg); err != nil {
-						log.Printf("Error processing medium priority message: %v", err)
-					}
-					// Then process low priority
-					if err := messageHandler(msg); err != nil {
-						log.Printf("Error processing low priority message: %v", err)
-					}
-				default:
-					// No high or medium priority, process low
-					if err := messageHandler(msg); err != nil {
-						log.Printf("Error processing low priority message: %v", err)
-					}
-				}
+			case msg := <-lane.messages:
+				credits[i]--
+				return lane, msg, true
+			default:
 			}
-			
-			// Add a small sleep to prevent CPU thrashing
-			time.Sleep(5 * time.Millisecond)
 		}
-	}()
-	
-	// Wait for context cancellation
-	<-ctx.Done()
-	log.Println("Context cancelled, shutting down consumers...")
-	
-	// Wait for all goroutines to finish
-	wg.Wait()
-	
-	return nil
+
+		// Lanes with credits left are empty, start a new round
+		if exhausted {
+			c.resetCredits(credits)
+			continue
+		}
+
+		// Nothing is pending on any lane, and nothing will be once intake stopped
+		select {
+		case <-c.intakeDone:
+			return nil, nil, false
+		default:
+		}
+
+		// Wait for the next message
+		cases := make([]reflect.SelectCase, 0, len(c.lanes)+2)
+		for _, lane := range c.lanes {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(lane.messages)})
+		}
+		cases = append(cases,
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.intakeDone)},
+		)
+
+		chosen, value, _ := reflect.Select(cases)
+		switch chosen {
+		case len(c.lanes):
+			return nil, nil, false
+		case len(c.lanes) + 1:
+			// Check the lanes once more, they may have been fed before intake stopped
+			continue
+		}
+
+		credits[chosen]--
+		return c.lanes[chosen], value.Interface().(*models.PrioritizedNotification), true
+	}
+}
+
+// Stats reports the depth of each lane and how busy the processor is
+func (c *KafkaPriorityConsumer) Stats() ConsumerStats {
+	stats := ConsumerStats{
+		Lanes:       make([]LaneStats, 0, len(c.lanes)),
+		Processed:   c.processed.Load(),
+		BusySeconds: time.Duration(c.busy.Load()).Seconds(),
+	}
+
+	for _, lane := range c.lanes {
+		spilled := 0
+		if lane.spill != nil {
+			spilled = lane.spill.len()
+		}
+		stats.Lanes = append(stats.Lanes, LaneStats{
+			Priority: lane.priority,
+			Topic:    lane.topic,
+			Buffered: len(lane.messages),
+			Capacity: cap(lane.messages),
+			Spilled:  spilled,
+			Lag:      lane.lagOf(),
+			Paused:   lane.paused.Load(),
+			Failed:   lane.failed.Load(),
+		})
+	}
+
+	if uptime := time.Since(c.started).Seconds(); uptime > 0 {
+		stats.Utilization = stats.BusySeconds / uptime
+	}
+	return stats
 }
 
 // Close the consumer and release resources
 func (c *KafkaPriorityConsumer) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
+
 	var errs []error
-	
-	if c.highConsumerGroup != nil {
-		if err := c.highConsumerGroup.Close(); err != nil {
-			errs = append(errs, err)
-		}
-	}
-	
-	if c.mediumConsumerGroup != nil {
-		if err := c.mediumConsumerGroup.Close(); err != nil {
-			errs = append(errs, err)
+
+	for _, lane := range c.lanes {
+		if lane.consumerGroup != nil {
+			if err := lane.consumerGroup.Close(); err != nil {
+				errs = append(errs, err)
+			}
 		}
-	}
-	
-	if c.lowConsumerGroup != nil {
-		if err := c.lowConsumerGroup.Close(); err != nil {
-			errs = append(errs, err)
+		if lane.spill != nil {
+			if err := lane.spill.Close(); err != nil {
+				errs = append(errs, err)
+			}
 		}
 	}
-	
+
 	if len(errs) > 0 {
 		log.Printf("Errors closing consumer groups: %v", errs)
 		return errs[0] // Return the first error
 	}
-	
+
 	return nil
 }
 
-// Implementation of ConsumerGroupHandler for high priority messages
+// Implementation of ConsumerGroupHandler for a priority level
 // Setup is run at the beginning of a new session
-func (h *highPriorityHandler) Setup(session sarama.ConsumerGroupSession) error {
+func (h *priorityHandler) Setup(session sarama.ConsumerGroupSession) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	
+
+	// Partitions may have moved to another instance
+	h.lag.reset()
+	claims := 0
+	for _, partitions := range session.Claims() {
+		claims += len(partitions)
+	}
+	h.lane.claims.Store(int32(claims))
+	h.lane.markProgress()
+
 	// Mark the consumer as ready
 	if !h.isReady {
 		close(h.ready)
 		h.isReady = true
 	}
-	
-	log.Println("High priority consumer session setup complete")
+
+	log.Printf("%s priority consumer session setup complete", h.priority)
 	return nil
 }
 
 // Cleanup is run at the end of a session
-func (h *highPriorityHandler) Cleanup(session sarama.ConsumerGroupSession) error {
-	log.Println("High priority consumer session cleanup complete")
+func (h *priorityHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	h.lane.claims.Store(0)
+	log.Printf("%s priority consumer session cleanup complete", h.priority)
 	return nil
 }
 
 // ConsumeClaim processes messages from a partition
-func (h *highPriorityHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+func (h *priorityHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	// Process messages
 	for message := range claim.Messages() {
+		h.lag.record(message.Partition, claim.HighWaterMarkOffset()-message.Offset-1)
+
+		// Skip messages this consumer doesn't care about without parsing them
+		if h.filter.skips(message) {
+			metrics.ConsumerFiltered.WithLabelValues(h.priority).Inc()
+			session.MarkMessage(message, "")
+			continue
+		}
+
+		// Drop messages not signed by the other services
+		if rejects(h.verifier, message) {
+			h.lane.failed.Add(1)
+			session.MarkMessage(message, "")
+			continue
+		}
+
 		// Parse message
 		var notification models.PrioritizedNotification
-		if err := json.Unmarshal(message.Value, &notification); err != nil {
-			log.Printf("Error unmarshalling high priority message: %v", err)
+		if err := models.UnmarshalPrioritized(message.Value, &notification, h.passthrough); err != nil {
+			h.fail(session, message, failures.Validation(fmt.Errorf("failed to unmarshal message: %w", err)))
 			session.MarkMessage(message, "")
 			continue
 		}
-		
+
+		// Notifications from older producers only carry the request ID as a
+		// header, and the prioritizer's forwarded ones the deadline
+		if notification.RequestID == "" {
+			notification.RequestID = headerValue(message, logging.RequestIDHeader)
+		}
+		if notification.Deadline == 0 {
+			notification.Deadline, _ = strconv.ParseInt(headerValue(message, DeadlineHeader), 10, 64)
+		}
+
 		// Set priority explicitly (in case it wasn't set in the message)
-		notification.Priority = models.PriorityHigh
-		
+		notification.Priority = h.priority
+
+		// Messages from older producers carry no headers to filter on
+		if !h.filter.accepts(&notification) {
+			metrics.ConsumerFiltered.WithLabelValues(h.priority).Inc()
+			session.MarkMessage(message, "")
+			continue
+		}
+
 		// Send to channel for processing
-		h.messages <- &notification
-		
+		if !h.enqueue(session, message, &notification) {
+			// The session ended first, the unmarked message is redelivered
+			return nil
+		}
+
 		// Mark message as processed
 		session.MarkMessage(message, "")
-		
-		log.Printf("Received high priority message from topic %s, partition %d, offset %d", 
-			message.Topic, message.Partition, message.Offset)
+
+		logging.ForRequest(notification.RequestID).Printf("Received %s priority message from topic %s, partition %d, offset %d",
+			h.priority, message.Topic, message.Partition, message.Offset)
 	}
-	
+
 	return nil
 }
 
-// Similar implementations for medium and low priority handlers
+// spills reports whether the lane spills to disk while its buffer is full
+func (lane *priorityLane) spills() bool {
+	return lane.overflow == config.OverflowSpill
+}
 
-// Setup is run at the beginning of a new session
-func (m *mediumPriorityHandler) Setup(session sarama.ConsumerGroupSession) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	// Mark the consumer as ready
-	if !m.isReady {
-		close(m.ready)
-		m.isReady = true
+// enqueue hands a notification to the lane, applying the lane's overflow
+// policy while its buffer is full. It reports false when the session ended
+// before the notification could be handed over.
+func (h *priorityHandler) enqueue(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage, notification *models.PrioritizedNotification) bool {
+	// Notifications already spilled go first, later ones queue up behind them
+	spill := h.lane.spill
+	if spill == nil || spill.len() == 0 {
+		select {
+		case h.messages <- notification:
+			return true
+		default:
+		}
 	}
-	
-	log.Println("Medium priority consumer session setup complete")
-	return nil
-}
 
-// Cleanup is run at the end of a session
-func (m *mediumPriorityHandler) Cleanup(session sarama.ConsumerGroupSession) error {
-	log.Println("Medium priority consumer session cleanup complete")
-	return nil
-}
+	policy := h.lane.overflow
+	if policy == "" {
+		policy = config.OverflowBlock
+	}
+	metrics.ConsumerOverflow.WithLabelValues(h.priority, policy).Inc()
 
-// ConsumeClaim processes messages from a partition
-func (m *mediumPriorityHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	// Process messages
-	for message := range claim.Messages() {
-		// Parse message
-		var notification models.PrioritizedNotification
-		if err := json.Unmarshal(message.Value, &notification); err != nil {
-			log.Printf("Error unmarshalling medium priority message: %v", err)
-			session.MarkMessage(message, "")
-			continue
+	var spillErr error
+	switch policy {
+	case config.OverflowSpill:
+		spillErr = spill.push(notification)
+		if spillErr == nil {
+			return true
 		}
-		
-		// Set priority explicitly
-		notification.Priority = models.PriorityMedium
-		
-		// Send to channel for processing
-		m.messages <- &notification
-		
-		// Mark message as processed
-		session.MarkMessage(message, "")
-		
-		log.Printf("Received medium priority message from topic %s, partition %d, offset %d", 
-			message.Topic, message.Partition, message.Offset)
+		if !errors.Is(spillErr, errSpillFull) {
+			log.Printf("Failed to spill %s priority notification %s, waiting for room instead: %v", h.priority, notification.ID, spillErr)
+		}
+	case config.OverflowShed:
+		h.fail(session, message, fmt.Errorf("shed: %s priority buffer full", h.priority))
+		return true
 	}
-	
-	return nil
-}
 
-// Setup is run at the beginning of a new session
-func (l *lowPriorityHandler) Setup(session sarama.ConsumerGroupSession) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	
-	// Mark the consumer as ready
-	if !l.isReady {
-		close(l.ready)
-		l.isReady = true
+	// Stop fetching the partition while waiting, so the broker keeps the backlog
+	partitions := map[string][]int32{message.Topic: {message.Partition}}
+	h.lane.consumerGroup.Pause(partitions)
+	defer func() {
+		// Leave the partition to the lag watcher while it pauses the whole lane
+		if !h.lane.paused.Load() {
+			h.lane.consumerGroup.Resume(partitions)
+		}
+	}()
+
+	// Behind a full spill file, wait for the lane to read enough back so the lane stays in order
+	if errors.Is(spillErr, errSpillFull) {
+		err := spill.pushWhenRoom(session.Context(), notification)
+		if err == nil {
+			return true
+		}
+		if session.Context().Err() != nil {
+			return false
+		}
+		log.Printf("Failed to spill %s priority notification %s, waiting for room instead: %v", h.priority, notification.ID, err)
+	}
+
+	select {
+	case h.messages <- notification:
+		return true
+	case <-session.Context().Done():
+		return false
 	}
-	
-	log.Println("Low priority consumer session setup complete")
-	return nil
 }
 
-// Cleanup is run at the end of a session
-func (l *lowPriorityHandler) Cleanup(session sarama.ConsumerGroupSession) error {
-	log.Println("Low priority consumer session cleanup complete")
-	return nil
+// fail parks a message that could not be parsed or was shed on the dead-letter topic
+func (h *priorityHandler) fail(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage, err error) {
+	h.lane.failed.Add(1)
+	if h.deadLetters == nil {
+		log.Printf("Dropping %s priority message from partition %d, offset %d: %v",
+			h.priority, message.Partition, message.Offset, err)
+		return
+	}
+
+	log.Printf("Dead-lettering %s priority message from partition %d, offset %d: %v",
+		h.priority, message.Partition, message.Offset, err)
+	if dlErr := h.deadLetters.Send(session.Context(), message, err); dlErr != nil {
+		log.Printf("Failed to dead-letter message, dropping it: %v", dlErr)
+	}
 }
 
-// ConsumeClaim processes messages from a partition
-func (l *lowPriorityHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	// Process messages
-	for message := range claim.Messages() {
-		// Parse message
-		var notification models.PrioritizedNotification
-		if err := json.Unmarshal(message.Value, &notification); err != nil {
-			log.Printf("Error unmarshalling low priority message: %v", err)
-			session.MarkMessage(message, "")
-			continue
+// headerValue returns the value of a message header, empty when it is missing
+func headerValue(message *sarama.ConsumerMessage, key string) string {
+	for _, header := range message.Headers {
+		if header != nil && string(header.Key) == key {
+			return string(header.Value)
 		}
-		
-		// Set priority explicitly
-		notification.Priority = models.PriorityLow
-		
-		// Send to channel for processing
-		l.messages <- &notification
-		
-		// Mark message as processed
-		session.MarkMessage(message, "")
-		
-		log.Printf("Received low priority message from topic %s, partition %d, offset %d", 
-			message.Topic, message.Partition, message.Offset)
 	}
-	
-	return nil
-}
\ No newline at end of file
+	return ""
+}
+
+// LaneGroupID returns the consumer group of a priority level's lane
+func LaneGroupID(groupID, priority string) string {
+	return groupID + "-" + priority
+}
@@ -0,0 +1,473 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/failures"
+)
+
+// fakeProducer records the messages sent through it
+type fakeProducer struct {
+	sarama.SyncProducer
+	mu   sync.Mutex
+	sent []*sarama.ProducerMessage
+}
+
+func (p *fakeProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent) - 1), nil
+}
+
+func (p *fakeProducer) Close() error {
+	return nil
+}
+
+// messages returns the messages sent so far
+func (p *fakeProducer) messages() []*sarama.ProducerMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*sarama.ProducerMessage(nil), p.sent...)
+}
+
+// fakeSession records the offsets a handler marks
+type fakeSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+	mu     sync.Mutex
+	marked []int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+func (s *fakeSession) MemberID() string           { return "member" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) MarkOffset(string, int32, int64, string) {
+}
+func (s *fakeSession) Commit() {}
+func (s *fakeSession) ResetOffset(string, int32, int64, string) {
+}
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+// offsets returns the offsets marked so far
+func (s *fakeSession) offsets() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+// fakeClaim serves a fixed set of messages of one partition
+type fakeClaim struct {
+	topic    string
+	messages chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(topic string, values ...string) *fakeClaim {
+	claim := &fakeClaim{topic: topic, messages: make(chan *sarama.ConsumerMessage, len(values))}
+	for offset, value := range values {
+		claim.messages <- &sarama.ConsumerMessage{
+			Topic:  topic,
+			Offset: int64(offset),
+			Key:    []byte("user-1"),
+			Value:  []byte(value),
+		}
+	}
+	close(claim.messages)
+	return claim
+}
+
+func (c *fakeClaim) Topic() string                            { return c.topic }
+func (c *fakeClaim) Partition() int32                         { return 0 }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return int64(cap(c.messages)) }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// fakeGroup runs one session over its claims, then waits for the end of intake
+type fakeGroup struct {
+	sarama.ConsumerGroup
+	claims  []*fakeClaim
+	session *fakeSession
+}
+
+func (g *fakeGroup) Consume(ctx context.Context, _ []string, handler sarama.ConsumerGroupHandler) error {
+	g.session = &fakeSession{ctx: ctx, claims: map[string][]int32{}}
+	for _, claim := range g.claims {
+		g.session.claims[claim.topic] = []int32{0}
+	}
+	if err := handler.Setup(g.session); err != nil {
+		return err
+	}
+	for _, claim := range g.claims {
+		if err := handler.ConsumeClaim(g.session, claim); err != nil {
+			return err
+		}
+	}
+	g.claims = nil
+
+	<-ctx.Done()
+	return handler.Cleanup(g.session)
+}
+
+func (g *fakeGroup) Pause(map[string][]int32)  {}
+func (g *fakeGroup) Resume(map[string][]int32) {}
+func (g *fakeGroup) Close() error              { return nil }
+
+// testLane describes a lane of a test consumer
+type testLane struct {
+	priority string
+	weight   int
+	buffer   int
+	overflow string
+	claims   []*fakeClaim
+}
+
+// newTestConsumer creates a consumer over fake consumer groups, dead-lettering
+// and delaying through fake producers
+func newTestConsumer(lanes ...testLane) (*KafkaPriorityConsumer, *fakeProducer, *fakeProducer) {
+	deadLetters, delays := &fakeProducer{}, &fakeProducer{}
+	c := &KafkaPriorityConsumer{
+		started:      time.Now(),
+		retryMax:     2,
+		retryBackoff: time.Millisecond,
+		deadLetters:  &DeadLetterProducer{producer: &KafkaProducer{producer: deadLetters, topic: "notifications.priority.dlq"}},
+		delayer: &Delayer{
+			producer: &KafkaProducer{producer: delays},
+			prefix:   "notifications.delay",
+			tiers:    []time.Duration{time.Minute, time.Hour},
+		},
+		retryDelay:    time.Minute,
+		retryDelayMax: 2,
+		intakeDone:    make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	c.intake, c.stopIntake = context.WithCancel(context.Background())
+	c.processing, c.stopProcessing = context.WithCancel(context.Background())
+
+	for _, l := range lanes {
+		c.lanes = append(c.lanes, &priorityLane{
+			priority:      l.priority,
+			topic:         "notifications." + l.priority,
+			topics:        []string{"notifications." + l.priority},
+			weight:        l.weight,
+			consumerGroup: &fakeGroup{claims: l.claims},
+			ready:         make(chan bool),
+			messages:      make(chan *models.PrioritizedNotification, l.buffer),
+			overflow:      l.overflow,
+		})
+	}
+	return c, deadLetters, delays
+}
+
+// handlerFor returns the handler the consumer runs for a lane's sessions
+func handlerFor(c *KafkaPriorityConsumer, lane *priorityLane) *priorityHandler {
+	return &priorityHandler{
+		priority:    lane.priority,
+		ready:       lane.ready,
+		messages:    lane.messages,
+		lane:        lane,
+		lag:         &lane.lag,
+		deadLetters: c.deadLetters,
+	}
+}
+
+func notificationJSON(id string) string {
+	return fmt.Sprintf(`{"id":%q,"user_id":"user-1","event_type":"comment","content":"hi"}`, id)
+}
+
+func testNotification(id string) *models.PrioritizedNotification {
+	notification := &models.PrioritizedNotification{}
+	notification.ID = id
+	notification.UserID = "user-1"
+	notification.EventType = "comment"
+	return notification
+}
+
+func header(msg *sarama.ProducerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func decodeSent(t *testing.T, msg *sarama.ProducerMessage) models.PrioritizedNotification {
+	t.Helper()
+	value, err := msg.Value.Encode()
+	if err != nil {
+		t.Fatalf("encoding value: %v", err)
+	}
+	var notification models.PrioritizedNotification
+	if err := json.Unmarshal(value, &notification); err != nil {
+		t.Fatalf("decoding %s: %v", value, err)
+	}
+	return notification
+}
+
+func TestNextMessageServesLanesByWeight(t *testing.T) {
+	c, _, _ := newTestConsumer(
+		testLane{priority: "high", weight: 2, buffer: 10},
+		testLane{priority: "low", weight: 1, buffer: 10},
+	)
+	for i := 0; i < 4; i++ {
+		c.lanes[0].messages <- testNotification(fmt.Sprintf("high-%d", i))
+		c.lanes[1].messages <- testNotification(fmt.Sprintf("low-%d", i))
+	}
+
+	credits := c.newCredits()
+	var order []string
+	for i := 0; i < 8; i++ {
+		_, msg, ok := c.nextMessage(context.Background(), credits)
+		if !ok {
+			t.Fatalf("nextMessage stopped after %v", order)
+		}
+		order = append(order, msg.ID)
+	}
+
+	want := []string{"high-0", "high-1", "low-0", "high-2", "high-3", "low-1", "low-2", "low-3"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+func TestNextMessageStopsOnceIntakeStoppedAndLanesAreEmpty(t *testing.T) {
+	c, _, _ := newTestConsumer(testLane{priority: "high", weight: 1, buffer: 1})
+	c.lanes[0].messages <- testNotification("last")
+	close(c.intakeDone)
+
+	credits := c.newCredits()
+	if _, msg, ok := c.nextMessage(context.Background(), credits); !ok || msg.ID != "last" {
+		t.Fatalf("nextMessage = %v, %v, want the buffered message", msg, ok)
+	}
+	if _, msg, ok := c.nextMessage(context.Background(), credits); ok {
+		t.Fatalf("nextMessage = %v, want none once the lanes are drained", msg)
+	}
+}
+
+func TestConsumeClaimHandsNotificationsToLane(t *testing.T) {
+	c, deadLetters, _ := newTestConsumer(testLane{priority: "high", weight: 1, buffer: 10})
+	lane := c.lanes[0]
+	claim := newFakeClaim(lane.topic, notificationJSON("n-1"), "not json", notificationJSON("n-2"))
+	session := &fakeSession{ctx: context.Background()}
+
+	if err := handlerFor(c, lane).ConsumeClaim(session, claim); err != nil {
+		t.Fatalf("ConsumeClaim: %v", err)
+	}
+
+	if got := fmt.Sprint(session.offsets()); got != "[0 1 2]" {
+		t.Errorf("marked offsets = %s, want [0 1 2]", got)
+	}
+	if len(lane.messages) != 2 {
+		t.Fatalf("lane holds %d notifications, want 2", len(lane.messages))
+	}
+	for _, id := range []string{"n-1", "n-2"} {
+		notification := <-lane.messages
+		if notification.ID != id || notification.Priority != "high" {
+			t.Errorf("lane notification = %s with priority %q, want %s with priority high", notification.ID, notification.Priority, id)
+		}
+	}
+
+	sent := deadLetters.messages()
+	if len(sent) != 1 {
+		t.Fatalf("dead-lettered %d messages, want 1", len(sent))
+	}
+	if source := header(sent[0], SourceTopicHeader); source != lane.topic {
+		t.Errorf("source topic = %q, want %q", source, lane.topic)
+	}
+	if cause := header(sent[0], ErrorHeader); cause == "" {
+		t.Error("dead letter has no error header")
+	}
+	if failed := lane.failed.Load(); failed != 1 {
+		t.Errorf("failed = %d, want 1", failed)
+	}
+}
+
+func TestConsumeClaimShedsWhileLaneIsFull(t *testing.T) {
+	c, deadLetters, _ := newTestConsumer(testLane{priority: "low", weight: 1, buffer: 1, overflow: config.OverflowShed})
+	lane := c.lanes[0]
+	claim := newFakeClaim(lane.topic, notificationJSON("n-1"), notificationJSON("n-2"))
+	session := &fakeSession{ctx: context.Background()}
+
+	if err := handlerFor(c, lane).ConsumeClaim(session, claim); err != nil {
+		t.Fatalf("ConsumeClaim: %v", err)
+	}
+
+	if len(lane.messages) != 1 {
+		t.Errorf("lane holds %d notifications, want 1", len(lane.messages))
+	}
+	sent := deadLetters.messages()
+	if len(sent) != 1 {
+		t.Fatalf("dead-lettered %d messages, want the shed one", len(sent))
+	}
+	if got := decodeSent(t, sent[0]); got.ID != "n-2" {
+		t.Errorf("dead-lettered %s, want n-2", got.ID)
+	}
+}
+
+func TestFailRetriesLaterThroughDelayTopics(t *testing.T) {
+	c, deadLetters, delays := newTestConsumer(testLane{priority: "high", weight: 1, buffer: 1})
+	lane := c.lanes[0]
+	notification := testNotification("n-1")
+	cause := failures.Transient(errors.New("redis down"))
+
+	// Each retry waits twice as long, until the retries are used up
+	for retry := 1; retry <= c.retryDelayMax; retry++ {
+		c.fail(context.Background(), lane, notification, cause)
+
+		sent := delays.messages()
+		if len(sent) != retry {
+			t.Fatalf("retry %d: delayed %d messages", retry, len(sent))
+		}
+		msg := sent[retry-1]
+		if target := header(msg, TargetTopicHeader); target != lane.topic {
+			t.Errorf("retry %d: target topic = %q, want %q", retry, target, lane.topic)
+		}
+		if got := decodeSent(t, msg); got.DelayedRetries != retry {
+			t.Errorf("retry %d: delayed retries = %d", retry, got.DelayedRetries)
+		}
+		millis, _ := strconv.ParseInt(header(msg, DeliverAtHeader), 10, 64)
+		wait := time.Until(time.UnixMilli(millis))
+		if want := c.retryDelay << (retry - 1); wait < want-time.Second || wait > want {
+			t.Errorf("retry %d: due in %v, want %v", retry, wait, want)
+		}
+	}
+	if len(deadLetters.messages()) != 0 {
+		t.Fatal("dead-lettered before the retries were used up")
+	}
+
+	c.fail(context.Background(), lane, notification, cause)
+	if len(deadLetters.messages()) != 1 {
+		t.Errorf("dead-lettered %d messages once the retries were used up, want 1", len(deadLetters.messages()))
+	}
+	if len(delays.messages()) != c.retryDelayMax {
+		t.Errorf("delayed %d messages, want %d", len(delays.messages()), c.retryDelayMax)
+	}
+}
+
+func TestFailDeadLettersWithoutRetryDelay(t *testing.T) {
+	c, deadLetters, delays := newTestConsumer(testLane{priority: "high", weight: 1, buffer: 1})
+	c.retryDelay = 0
+
+	c.fail(context.Background(), c.lanes[0], testNotification("n-1"), failures.Transient(errors.New("redis down")))
+
+	if len(delays.messages()) != 0 || len(deadLetters.messages()) != 1 {
+		t.Errorf("delayed %d and dead-lettered %d messages, want 0 and 1", len(delays.messages()), len(deadLetters.messages()))
+	}
+}
+
+func TestFailDefersUntilDue(t *testing.T) {
+	c, deadLetters, delays := newTestConsumer(testLane{priority: "low", weight: 1, buffer: 1})
+	lane := c.lanes[0]
+	notification := testNotification("n-1")
+	notification.Deadline = time.Now().Add(time.Minute).UnixMilli()
+	until := time.Now().Add(2 * time.Hour).Truncate(time.Millisecond)
+
+	c.fail(context.Background(), lane, notification, failures.Deferred(until, errors.New("quiet hours")))
+
+	sent := delays.messages()
+	if len(sent) != 1 || len(deadLetters.messages()) != 0 {
+		t.Fatalf("delayed %d and dead-lettered %d messages, want 1 and 0", len(sent), len(deadLetters.messages()))
+	}
+	if topic := sent[0].Topic; topic != "notifications.delay.1h" {
+		t.Errorf("delay topic = %s, want notifications.delay.1h", topic)
+	}
+	if at := header(sent[0], DeliverAtHeader); at != strconv.FormatInt(until.UnixMilli(), 10) {
+		t.Errorf("deliver at = %s, want %d", at, until.UnixMilli())
+	}
+	if got := decodeSent(t, sent[0]); got.Deadline != 0 || got.DelayedRetries != 0 {
+		t.Errorf("deferred notification has deadline %d and %d delayed retries, want neither", got.Deadline, got.DelayedRetries)
+	}
+}
+
+func TestFailDropsRateLimited(t *testing.T) {
+	c, deadLetters, delays := newTestConsumer(testLane{priority: "low", weight: 1, buffer: 1})
+
+	c.fail(context.Background(), c.lanes[0], testNotification("n-1"), fmt.Errorf("%w: 10 per hour", failures.ErrRateLimited))
+
+	if len(delays.messages()) != 0 || len(deadLetters.messages()) != 0 {
+		t.Errorf("delayed %d and dead-lettered %d messages, want none", len(delays.messages()), len(deadLetters.messages()))
+	}
+	if failed := c.lanes[0].failed.Load(); failed != 0 {
+		t.Errorf("failed = %d, want 0", failed)
+	}
+}
+
+func TestStartRetriesHandlerAndDrains(t *testing.T) {
+	c, deadLetters, _ := newTestConsumer(
+		testLane{priority: "high", weight: 1, buffer: 10},
+		testLane{priority: "low", weight: 1, buffer: 10},
+	)
+	c.lanes[0].consumerGroup = &fakeGroup{claims: []*fakeClaim{newFakeClaim("notifications.high", notificationJSON("flaky"), notificationJSON("broken"))}}
+	c.lanes[1].consumerGroup = &fakeGroup{claims: []*fakeClaim{newFakeClaim("notifications.low", notificationJSON("fine"))}}
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	handled := make(chan string, 10)
+	handler := func(notification *models.PrioritizedNotification) error {
+		mu.Lock()
+		attempts[notification.ID]++
+		attempt := attempts[notification.ID]
+		mu.Unlock()
+
+		switch {
+		case notification.ID == "flaky" && attempt == 1:
+			return failures.Transient(errors.New("timeout"))
+		case notification.ID == "broken":
+			return failures.Permanent(errors.New("unknown channel"))
+		}
+		handled <- notification.ID
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Start(context.Background(), handler) }()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("notifications weren't handled")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.StopIntake(ctx); err != nil {
+		t.Fatalf("StopIntake: %v", err)
+	}
+	if err := c.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if attempts["flaky"] != 2 {
+		t.Errorf("flaky notification handled %d times, want 2", attempts["flaky"])
+	}
+	if attempts["broken"] != 1 {
+		t.Errorf("permanently failing notification handled %d times, want 1", attempts["broken"])
+	}
+	if sent := deadLetters.messages(); len(sent) != 1 || decodeSent(t, sent[0]).ID != "broken" {
+		t.Errorf("dead-lettered %d messages, want only the broken one", len(sent))
+	}
+	if stats := c.Stats(); stats.Processed != 3 {
+		t.Errorf("processed = %d, want 3", stats.Processed)
+	}
+}
@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// Headers describing why and where from a message was dead-lettered
+const (
+	ErrorHeader       = "X-Error"
+	SourceTopicHeader = "X-Source-Topic"
+)
+
+// DeadLetterProducer parks messages that failed for good on the dead-letter
+// topic, unchanged apart from headers naming the failure
+type DeadLetterProducer struct {
+	producer *KafkaProducer
+}
+
+// Creates a new dead-letter producer, ensuring the dead-letter topic exists
+func NewDeadLetterProducer(cfg config.KafkaProducerConfig, topic string) (*DeadLetterProducer, error) {
+	// Ensure the dead-letter topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterProducer{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			topic:       topic,
+			sendTimeout: cfg.SendTimeout,
+		},
+	}, nil
+}
+
+// Parks a consumed message along with the failure that stopped it
+func (p *DeadLetterProducer) Send(ctx context.Context, message *sarama.ConsumerMessage, cause error) error {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers))
+	for _, header := range message.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	return p.send(ctx, message.Topic, message.Key, message.Value, headers, cause)
+}
+
+// Parks a notification that failed after it was consumed from the source topic
+func (p *DeadLetterProducer) SendNotification(ctx context.Context, source string, notification *models.PrioritizedNotification, cause error) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	headers := notificationHeaders(notification.RequestID, notification.EventType, notification.Metadata, notification.Priority)
+	return p.send(ctx, source, []byte(notification.UserID), payload, headers, cause)
+}
+
+// Sends a dead letter, adding the failure and source topic to its headers
+func (p *DeadLetterProducer) send(ctx context.Context, source string, key, payload []byte, headers []sarama.RecordHeader, cause error) error {
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(ErrorHeader), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(SourceTopicHeader), Value: []byte(source)},
+	)
+
+	msg := &sarama.ProducerMessage{
+		Topic:   p.producer.topic,
+		Key:     sarama.ByteEncoder(key),
+		Value:   sarama.ByteEncoder(payload),
+		Headers: headers,
+	}
+	if _, _, err := p.producer.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Closes the dead-letter producer
+func (p *DeadLetterProducer) Close() error {
+	return p.producer.Close()
+}
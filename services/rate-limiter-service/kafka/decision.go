@@ -0,0 +1,237 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/blocklist"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/degraded"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/preferences"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/failures"
+)
+
+// decision is what the pipeline does with a notification and what it was based on
+type decision struct {
+	outcome string         // Outcome the notification is settled with, empty when it goes on to delivery
+	details map[string]any // Traced with the outcome
+	retry   error          // Returned for rate-limited and deferred outcomes
+
+	bypassed    bool
+	quota       models.RateLimitResult
+	preferences *preferences.UserPreferences
+	mandatory   bool
+	channels    []string
+	fallback    []string
+	selection   string                     // How engagement picked the channel, empty when it didn't
+	expensive   []string                   // Channels moved to the fallbacks for their cost
+	cooling     []string                   // Channels dropped for their cooldown
+	invalid     map[string]error           // Channels dropped for a refused contact
+	blocked     map[string]blocklist.Entry // Channels dropped for a blocked contact
+	userEntry   *blocklist.Entry           // Entry blocking the user
+	throttled   []string                   // Channels past their warm-up cap
+
+	// Taken for the notification, given back unless it is delivered
+	counted  bool
+	warmedUp []string
+	spaced   []string
+}
+
+// decide runs a notification through the checks of the pipeline in order.
+// Processing stops at the first outcome and takes the quota, warm-up counts
+// and spacing the notification needs. Simulating only reads, keeps going to
+// show everything the outcome was based on and ignores degraded mode.
+func (p *Processor) decide(ctx context.Context, notification *models.PrioritizedNotification, simulate bool) (*decision, error) {
+	d := &decision{quota: models.RateLimitResult{Exempt: true}}
+	// settled records the first outcome, reporting whether to stop there
+	settled := func(outcome string, details map[string]any, retry error) bool {
+		if d.outcome == "" {
+			d.outcome, d.details, d.retry = outcome, details, retry
+		}
+		return !simulate
+	}
+
+	if simulate {
+		d.bypassed = notification.Bypass != nil && p.bypass != nil && p.bypass.Verify(notification) == nil
+	} else {
+		d.bypassed = p.bypassed(notification)
+	}
+
+	// Users on a suppression list are never contacted, whatever bypass or
+	// event type the notification has, and don't count against any quota
+	tenant := tenantOf(notification.Metadata)
+	if p.blocklist != nil {
+		if entry, blocked := p.blocklist.User(tenant, notification.UserID); blocked {
+			d.userEntry = &entry
+			if settled(OutcomeBlocklisted, map[string]any{"kind": entry.Kind, "reason": entry.Reason}, nil) {
+				return d, nil
+			}
+		}
+	}
+
+	// Step 1: Apply rate limiting, which bypassed notifications are exempt
+	// from and which operators may skip while Redis is broken
+	var err error
+	switch {
+	case d.bypassed:
+	case simulate:
+		if d.quota, err = p.rateLimiter.Quota(ctx, notification); err != nil {
+			return d, fmt.Errorf("rate limiting error: %w", err)
+		}
+	case !p.skip(notification, degraded.StageRateLimiting):
+		if d.quota, err = p.rateLimiter.IsRateLimited(ctx, notification); err != nil {
+			return d, failures.Transient(fmt.Errorf("rate limiting error: %w", err))
+		}
+		d.counted = !d.quota.Limited
+	}
+	if d.quota.Limited {
+		limited := fmt.Errorf("%w: notification %s", failures.ErrRateLimited, notification.ID)
+		if settled(OutcomeRateLimited, map[string]any{"reset_at": d.quota.ResetAt}, limited) {
+			return d, nil
+		}
+	}
+
+	// Step 2: Get user preferences, cached or default ones while operators
+	// skip the lookup
+	if !simulate && p.skip(notification, degraded.StagePreferences) {
+		d.preferences = preferences.Fallback(p.preferencesService, notification.UserID)
+	} else if d.preferences, err = p.preferencesService.GetUserPreferences(ctx, notification.UserID); err != nil {
+		return d, failures.Transient(fmt.Errorf("error getting user preferences: %w", err))
+	}
+
+	// Step 3: Skip users whose account can't receive notifications, keeping
+	// the reason apart from opt-outs
+	switch d.preferences.Status {
+	case preferences.StatusSuspended, preferences.StatusDeleted:
+		if settled("user_"+d.preferences.Status, nil, nil) {
+			return d, nil
+		}
+	}
+
+	// Users whose data must stay in a region without a route can't be delivered to
+	if p.regions != nil && !p.regions.Allowed(d.preferences.Region) {
+		if settled(OutcomeUnroutedRegion, map[string]any{"region": d.preferences.Region}, nil) {
+			return d, nil
+		}
+	}
+
+	// Step 4: Check global opt-out, which mandatory event types ignore
+	d.mandatory = p.eventRegistry.CategoryOf(notification.EventType).Mandatory()
+	if !d.preferences.GlobalOptIn && !d.bypassed && !d.mandatory {
+		if settled(OutcomeOptedOut, nil, nil) {
+			return d, nil
+		}
+	}
+
+	// Step 5: Hold back notifications the user snoozed, which mandatory event
+	// types ignore. Urgent ones are sent again once the snooze ends.
+	now := time.Now()
+	if until, snoozed := d.preferences.SnoozedUntil(notification.EventType, now); snoozed && !d.bypassed && !d.mandatory {
+		outcome, retry := OutcomeSnoozed, error(nil)
+		if p.urgent(notification.Priority) {
+			outcome = OutcomeDeferred
+			retry = failures.Deferred(until, fmt.Errorf("user %s snoozed %s notifications", notification.UserID, notification.EventType))
+		}
+		if settled(outcome, map[string]any{"until": until}, retry) {
+			return d, nil
+		}
+	}
+
+	// Step 6: Hold back notifications that arrive outside their event type's
+	// delivery window, until it opens in the user's time zone
+	if window := p.eventRegistry.DeliveryWindowOf(notification.EventType); window != nil && !d.bypassed {
+		if opens, closed := window.NextOpen(now, d.preferences.Timezone); closed {
+			retry := failures.Deferred(opens, fmt.Errorf("outside the %s delivery window", notification.EventType))
+			if settled(OutcomeOutsideWindow, map[string]any{"until": opens}, retry) {
+				return d, nil
+			}
+		}
+	}
+
+	// Step 7: Determine delivery channels based on preferences
+	if d.bypassed {
+		d.channels = bypassChannels(d.preferences)
+	} else {
+		d.channels, d.fallback = p.determineDeliveryChannels(notification, d.preferences)
+		// Picking by engagement and cooling down channels are optional, and
+		// skipped when little of the notification's budget is left
+		tight := !simulate && p.budgetTight(notification)
+		if !tight {
+			d.channels, d.selection = p.selectChannels(ctx, notification, d.channels)
+		}
+		if p.costs != nil {
+			d.channels, d.expensive = p.costs.Select(notification.Priority, d.channels)
+			d.fallback = append(slices.Clone(d.expensive), d.fallback...)
+		}
+		if !tight {
+			d.channels, d.fallback, d.cooling = p.coolDown(ctx, notification, d.channels, d.fallback)
+		}
+	}
+
+	// Channels whose contact can't be delivered to or is on a suppression
+	// list are dropped before anything is sent to it
+	d.channels, d.fallback, d.invalid = p.checkContacts(notification, d.preferences, d.channels, d.fallback)
+	d.channels, d.fallback, d.blocked = p.dropBlocked(tenant, d.preferences, d.channels, d.fallback)
+	switch {
+	case len(d.channels) > 0:
+	case len(d.blocked) > 0:
+		if settled(OutcomeBlocklisted, map[string]any{"channels": slices.Sorted(maps.Keys(d.blocked))}, nil) {
+			return d, nil
+		}
+	case len(d.invalid) > 0:
+		if settled(OutcomeInvalidContact, map[string]any{"channels": slices.Sorted(maps.Keys(d.invalid))}, nil) {
+			return d, nil
+		}
+	default:
+		if settled(OutcomeNoChannels, nil, nil) {
+			return d, nil
+		}
+	}
+
+	// Keep to the daily caps of sending identities still warming up, moving
+	// on to a fallback channel past them and holding the notification until
+	// the caps reset when none is left. Urgent and bypassed notifications
+	// count without being held back. Simulations don't count anything.
+	if p.warmUp != nil && !simulate {
+		var reset time.Time
+		d.channels, d.fallback, d.throttled, reset, err = p.takeWarmUp(tenant, d.channels, d.fallback, d.bypassed || p.urgent(notification.Priority))
+		if err != nil {
+			return d, failures.Transient(fmt.Errorf("warm-up error: %w", err))
+		}
+		if len(d.channels) == 0 {
+			retry := failures.Deferred(reset, fmt.Errorf("channels %v reached their warm-up caps", d.throttled))
+			settled(OutcomeWarmingUp, map[string]any{"channels": d.throttled, "until": reset}, retry)
+			return d, nil
+		}
+		d.warmedUp = d.channels
+	}
+
+	// Hold back notifications following the previous one to the user on a
+	// spaced channel too closely, until the channel frees up. Urgent ones
+	// aren't held back.
+	if p.spacer != nil && !d.bypassed && !p.urgent(notification.Priority) && len(d.channels) > 0 {
+		var free time.Time
+		reserved := true
+		if simulate {
+			free, err = p.spacer.FreeAt(ctx, notification.UserID, d.channels)
+			reserved = !free.After(time.Now())
+		} else {
+			free, reserved, err = p.spacer.Reserve(ctx, notification.UserID, notification.ID, d.channels)
+		}
+		if err != nil {
+			return d, failures.Transient(fmt.Errorf("spacing error: %w", err))
+		}
+		if !reserved {
+			retry := failures.Deferred(free, fmt.Errorf("user %s was notified too recently", notification.UserID))
+			settled(OutcomeSpaced, map[string]any{"until": free}, retry)
+			return d, nil
+		}
+		if !simulate {
+			d.spaced = d.channels
+		}
+	}
+	return d, nil
+}
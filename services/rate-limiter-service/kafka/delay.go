@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// Headers of a message on a delay topic. Any service can delay a message by
+// producing it to a delay topic with both set; the scheduler sends it on to
+// the target topic once it is due, without these headers.
+const (
+	DeliverAtHeader   = "X-Deliver-At"   // Unix milliseconds the message is due at
+	TargetTopicHeader = "X-Target-Topic" // Topic the message is due on
+)
+
+// Delayer parks messages on the delay topics until they are due. A message
+// goes to the longest tier not longer than its remaining delay, the shortest
+// tier when the delay is shorter than all of them.
+type Delayer struct {
+	producer *KafkaProducer
+	prefix   string
+	tiers    []time.Duration // Ascending
+}
+
+// NewDelayer creates a delayer, ensuring the delay topics exist
+func NewDelayer(cfg config.KafkaProducerConfig, delay config.DelayConfig) (*Delayer, error) {
+	// Ensure the delay topics exist and create the signed producer
+	topics := make([]string, 0, len(delay.Tiers))
+	for _, tier := range delay.Tiers {
+		topics = append(topics, DelayTopic(delay.TopicPrefix, tier))
+	}
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), topics...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Delayer{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		prefix: delay.TopicPrefix,
+		tiers:  delay.Tiers,
+	}, nil
+}
+
+// Delay sends a message to its topic once at is reached. Messages already due are sent right away.
+func (d *Delayer) Delay(ctx context.Context, msg *sarama.ProducerMessage, at time.Time) error {
+	remaining := time.Until(at)
+	if remaining <= 0 {
+		_, _, err := d.producer.send(ctx, msg)
+		return err
+	}
+
+	delayed := *msg
+	delayed.Topic = DelayTopic(d.prefix, d.tierFor(remaining))
+	delayed.Headers = append(withoutDelayHeaders(msg.Headers),
+		sarama.RecordHeader{Key: []byte(DeliverAtHeader), Value: []byte(strconv.FormatInt(at.UnixMilli(), 10))},
+		sarama.RecordHeader{Key: []byte(TargetTopicHeader), Value: []byte(msg.Topic)},
+	)
+	_, _, err := d.producer.send(ctx, &delayed)
+	return err
+}
+
+// DelayNotification sends a notification to a priority topic once at is reached
+func (d *Delayer) DelayNotification(ctx context.Context, topic string, notification *models.PrioritizedNotification, at time.Time) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(notification.UserID),
+		Value: sarama.ByteEncoder(payload),
+		Headers: append(
+			notificationHeaders(notification.RequestID, notification.EventType, notification.Metadata, notification.Priority),
+			deadlineHeaders(notification.Deadline)...),
+	}
+	if err := d.Delay(ctx, msg, at); err != nil {
+		return fmt.Errorf("failed to delay message: %w", err)
+	}
+	return nil
+}
+
+// tierFor returns the tier a message with the remaining delay waits in
+func (d *Delayer) tierFor(remaining time.Duration) time.Duration {
+	tier := d.tiers[0]
+	for _, t := range d.tiers[1:] {
+		if t > remaining {
+			break
+		}
+		tier = t
+	}
+	return tier
+}
+
+// Closes the delayer
+func (d *Delayer) Close() error {
+	return d.producer.Close()
+}
+
+// DelayTopic returns the topic of a delay tier, e.g. notifications.delay.10m
+func DelayTopic(prefix string, tier time.Duration) string {
+	switch {
+	case tier%time.Hour == 0:
+		return fmt.Sprintf("%s.%dh", prefix, tier/time.Hour)
+	case tier%time.Minute == 0:
+		return fmt.Sprintf("%s.%dm", prefix, tier/time.Minute)
+	default:
+		return fmt.Sprintf("%s.%ds", prefix, tier/time.Second)
+	}
+}
+
+// withoutDelayHeaders returns headers without the delay headers
+func withoutDelayHeaders(headers []sarama.RecordHeader) []sarama.RecordHeader {
+	kept := make([]sarama.RecordHeader, 0, len(headers)+2)
+	for _, header := range headers {
+		switch string(header.Key) {
+		case DeliverAtHeader, TargetTopicHeader:
+			continue
+		}
+		kept = append(kept, header)
+	}
+	return kept
+}
@@ -0,0 +1,290 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/timingwheel"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/encryption"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// How long a message the scheduler failed to send waits before the next attempt
+const delayRetryBackoff = time.Second
+
+// DelayScheduler consumes the delay topics and sends every message on once
+// it is due. Messages of a tier arrive in the order they become due at the
+// latest, so each partition is read ahead only as far as the timing wheel
+// reaches; due messages wait in the wheel and are sent from there. Offsets
+// are marked once every earlier message of the partition was sent.
+type DelayScheduler struct {
+	consumerGroup sarama.ConsumerGroup
+	verifier      *signing.Verifier
+	delayer       *Delayer
+	topics        []string
+	tiers         map[string]time.Duration // Tier of each delay topic
+	wheel         *timingwheel.Wheel
+	horizon       time.Duration // How far ahead of now the wheel holds messages
+	coalescer     *coalescer    // Optional, folds identical notifications waiting in the wheel together
+}
+
+// NewDelayScheduler creates the scheduler of the delay topics, sending due
+// messages and moving the others to shorter tiers through the delayer. The
+// decryptor is optional and lets encrypted notifications be coalesced.
+func NewDelayScheduler(cfg config.KafkaConsumerConfig, delay config.DelayConfig, delayer *Delayer, decryptor *encryption.FieldEncryptor) (*DelayScheduler, error) {
+	verifier, err := signing.NewVerifier(cfg.Signing)
+	if err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	consumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, delay.GroupID, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	s := &DelayScheduler{
+		consumerGroup: consumerGroup,
+		verifier:      verifier,
+		delayer:       delayer,
+		tiers:         make(map[string]time.Duration, len(delay.Tiers)),
+		wheel:         timingwheel.New(delay.Tick, delay.Slots),
+		horizon:       delay.Tick * time.Duration(delay.Slots),
+		coalescer:     newCoalescer(delay.CoalesceKeys, decryptor),
+	}
+	for _, tier := range delay.Tiers {
+		topic := DelayTopic(delay.TopicPrefix, tier)
+		s.topics = append(s.topics, topic)
+		s.tiers[topic] = tier
+	}
+	return s, nil
+}
+
+// Start schedules delayed messages until the context is done
+func (s *DelayScheduler) Start(ctx context.Context) error {
+	log.Printf("Scheduling delayed messages of %v", s.topics)
+	for {
+		if err := s.consumerGroup.Consume(ctx, s.topics, s); err != nil {
+			log.Printf("Error consuming delay topics: %v", err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Pending returns the number of messages waiting in the wheel
+func (s *DelayScheduler) Pending() int {
+	return s.wheel.Pending()
+}
+
+// Setup is run at the beginning of a new session
+func (s *DelayScheduler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session. Messages still in the wheel are
+// left unmarked, so their partition's next owner reads them again.
+func (s *DelayScheduler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim hands the messages of a partition to the wheel as they come due
+func (s *DelayScheduler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tier := s.tiers[claim.Topic()]
+	marks := &offsetMarks{session: session, topic: claim.Topic(), partition: claim.Partition()}
+
+	for message := range claim.Messages() {
+		deliverAt, target, err := delayHeaders(message)
+		if err != nil {
+			log.Printf("Dropping delayed message from %s, partition %d, offset %d: %v",
+				message.Topic, message.Partition, message.Offset, err)
+			marks.add(message.Offset)
+			marks.done(message.Offset)
+			continue
+		}
+
+		// A delayed message ages from when it is due, not from when it was signed
+		if rejectsDue(s.verifier, message, deliverAt) {
+			marks.add(message.Offset)
+			marks.done(message.Offset)
+			continue
+		}
+
+		// The message must move on by the end of its tier at the latest
+		due := message.Timestamp.Add(tier)
+		if deliverAt.Before(due) {
+			due = deliverAt
+		}
+
+		// Read no further than the wheel reaches
+		if wait := time.Until(due) - s.horizon; wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-session.Context().Done():
+				return nil
+			}
+		}
+
+		marks.add(message.Offset)
+
+		// Identical notifications due on their target fold into the first of them in the wheel
+		var key string
+		if s.coalescer != nil && due.Equal(deliverAt) {
+			if k, count, ok := s.coalescer.key(message, target); ok {
+				duplicate := foldedCopy{ctx: session.Context(), marks: marks, message: message, deliverAt: deliverAt, target: target, count: count}
+				if s.coalescer.fold(k, duplicate) {
+					metrics.DelayedMessages.WithLabelValues("coalesced").Inc()
+					continue
+				}
+				if s.coalescer.hold(session.Context(), k, deliverAt) {
+					key = k
+				}
+			}
+		}
+		s.schedule(session.Context(), marks, message, due, deliverAt, target, key, nil)
+	}
+	return nil
+}
+
+// schedule sends a message on from the wheel once due: to its target when
+// delivery is due, otherwise to the tier of its remaining delay. A message
+// held under a coalescing key goes out for the copies folded into it too.
+func (s *DelayScheduler) schedule(ctx context.Context, marks *offsetMarks, message *sarama.ConsumerMessage, due, deliverAt time.Time, target, key string, copies []foldedCopy) {
+	s.wheel.AfterFunc(due, func() {
+		if key != "" {
+			copies = append(copies, s.coalescer.take(key)...)
+		}
+
+		// The partition moved to another instance, which reads the message
+		// again. Copies read since go out on their own.
+		if ctx.Err() != nil {
+			for _, duplicate := range copies {
+				if duplicate.ctx.Err() == nil {
+					s.schedule(duplicate.ctx, duplicate.marks, duplicate.message, duplicate.deliverAt, duplicate.deliverAt, duplicate.target, "", nil)
+				}
+			}
+			return
+		}
+
+		value := message.Value
+		if len(copies) > 0 {
+			coalesced, err := withCopies(value, copies)
+			if err != nil {
+				log.Printf("Failed to coalesce delayed message from %s, partition %d, offset %d, sending it alone: %v",
+					message.Topic, message.Partition, message.Offset, err)
+			} else {
+				value = coalesced
+			}
+		}
+
+		msg := &sarama.ProducerMessage{
+			Topic:   target,
+			Key:     sarama.ByteEncoder(message.Key),
+			Value:   sarama.ByteEncoder(value),
+			Headers: consumedHeaders(message),
+		}
+		if err := s.delayer.Delay(ctx, msg, deliverAt); err != nil {
+			log.Printf("Failed to send delayed message from %s, partition %d, offset %d, retrying: %v",
+				message.Topic, message.Partition, message.Offset, err)
+			metrics.DelayedMessages.WithLabelValues("failed").Inc()
+			s.schedule(ctx, marks, message, time.Now().Add(delayRetryBackoff), deliverAt, target, "", copies)
+			return
+		}
+
+		if time.Now().Before(deliverAt) {
+			metrics.DelayedMessages.WithLabelValues("rescheduled").Inc()
+		} else {
+			metrics.DelayedMessages.WithLabelValues("delivered").Inc()
+			metrics.DelayLateness.Observe(time.Since(deliverAt).Seconds())
+		}
+		marks.done(message.Offset)
+		for _, duplicate := range copies {
+			if duplicate.ctx.Err() == nil {
+				duplicate.marks.done(duplicate.message.Offset)
+			}
+		}
+	})
+}
+
+// Closes the scheduler
+func (s *DelayScheduler) Close() error {
+	err := s.consumerGroup.Close()
+	s.wheel.Stop()
+	return err
+}
+
+// delayHeaders returns when and where a delayed message is due
+func delayHeaders(message *sarama.ConsumerMessage) (time.Time, string, error) {
+	target := headerValue(message, TargetTopicHeader)
+	if target == "" {
+		return time.Time{}, "", fmt.Errorf("missing %s header", TargetTopicHeader)
+	}
+
+	millis, err := strconv.ParseInt(headerValue(message, DeliverAtHeader), 10, 64)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid %s header: %w", DeliverAtHeader, err)
+	}
+	return time.UnixMilli(millis), target, nil
+}
+
+// consumedHeaders copies the headers of a consumed message for producing it again
+func consumedHeaders(message *sarama.ConsumerMessage) []sarama.RecordHeader {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers))
+	for _, header := range message.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	return headers
+}
+
+// offsetMarks marks the offsets of a partition in order, each once it and
+// every earlier offset are done
+type offsetMarks struct {
+	session   sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+
+	mu          sync.Mutex
+	pending     []int64 // In the order they were read
+	doneOffsets map[int64]bool
+}
+
+// add tracks an offset until it is done
+func (m *offsetMarks) add(offset int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, offset)
+}
+
+// done records an offset as done and marks the run of done offsets from the oldest pending one
+func (m *offsetMarks) done(offset int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.doneOffsets == nil {
+		m.doneOffsets = make(map[int64]bool)
+	}
+	m.doneOffsets[offset] = true
+
+	marked := int64(-1)
+	for len(m.pending) > 0 && m.doneOffsets[m.pending[0]] {
+		marked = m.pending[0]
+		delete(m.doneOffsets, marked)
+		m.pending = m.pending[1:]
+	}
+	if marked >= 0 {
+		m.session.MarkOffset(m.topic, m.partition, marked+1, "")
+	}
+}
@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Headers describing a notification, so consumers can skip messages without unmarshalling them
+const (
+	EventTypeHeader = "X-Event-Type"
+	TenantHeader    = "X-Tenant"
+	PriorityHeader  = "X-Priority"
+	DeadlineHeader  = "X-Deadline" // Unix milliseconds the notification should reach the delivery topic by
+)
+
+// Headers of delivery messages carrying the user's remaining quota
+const (
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// Returns the Kafka headers of a notification message, leaving out empty values
+func notificationHeaders(requestID, eventType string, metadata map[string]any, priority string) []sarama.RecordHeader {
+	headers := requestIDHeaders(requestID)
+	for _, header := range [][2]string{
+		{EventTypeHeader, eventType},
+		{TenantHeader, tenantOf(metadata)},
+		{PriorityHeader, priority},
+	} {
+		if header[1] != "" {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(header[0]), Value: []byte(header[1])})
+		}
+	}
+	return headers
+}
+
+// Returns the Kafka headers describing the user's quota, none when the notification was exempt
+func rateLimitHeaders(quota *models.RateLimitResult) []sarama.RecordHeader {
+	if quota == nil {
+		return nil
+	}
+	return []sarama.RecordHeader{
+		{Key: []byte(RateLimitRemainingHeader), Value: []byte(strconv.Itoa(quota.Remaining))},
+		{Key: []byte(RateLimitResetHeader), Value: []byte(strconv.FormatInt(quota.ResetAt, 10))},
+	}
+}
+
+// Returns the Kafka headers carrying the processing deadline, if there is one
+func deadlineHeaders(deadline int64) []sarama.RecordHeader {
+	if deadline == 0 {
+		return nil
+	}
+	return []sarama.RecordHeader{{Key: []byte(DeadlineHeader), Value: []byte(strconv.FormatInt(deadline, 10))}}
+}
+
+// Returns the Kafka headers carrying the request ID, if there is one
+func requestIDHeaders(requestID string) []sarama.RecordHeader {
+	if requestID == "" {
+		return nil
+	}
+	return []sarama.RecordHeader{{Key: []byte(logging.RequestIDHeader), Value: []byte(requestID)}}
+}
+
+// Returns the tenant named in a notification's metadata, empty when there is none
+func tenantOf(metadata map[string]any) string {
+	tenant, _ := metadata[models.MetadataTenant].(string)
+	return tenant
+}
+
+// headerFilter decides whether the consumer wants a message, from its headers
+// before unmarshalling and from the payload of messages without them
+type headerFilter struct {
+	eventTypes map[string]bool
+	tenants    map[string]bool
+	priorities map[string]bool
+}
+
+// newHeaderFilter creates the filter of a consumer
+func newHeaderFilter(cfg config.HeaderFilterConfig) headerFilter {
+	return headerFilter{
+		eventTypes: toSet(cfg.EventTypes),
+		tenants:    toSet(cfg.Tenants),
+		priorities: toSet(cfg.Priorities),
+	}
+}
+
+// skips reports whether the message's headers rule it out. A missing header
+// rules nothing out, the payload decides once unmarshalled.
+func (f headerFilter) skips(message *sarama.ConsumerMessage) bool {
+	return excludes(f.eventTypes, headerValue(message, EventTypeHeader)) ||
+		excludes(f.tenants, headerValue(message, TenantHeader)) ||
+		excludes(f.priorities, headerValue(message, PriorityHeader))
+}
+
+// accepts reports whether the consumer wants the notification
+func (f headerFilter) accepts(notification *models.PrioritizedNotification) bool {
+	return includes(f.eventTypes, notification.EventType) &&
+		includes(f.tenants, tenantOf(notification.Metadata)) &&
+		includes(f.priorities, notification.Priority)
+}
+
+// excludes reports whether a present value is outside a non-empty set
+func excludes(set map[string]bool, value string) bool {
+	return value != "" && len(set) > 0 && !set[value]
+}
+
+// includes reports whether the value is in the set, any value is when it's empty
+func includes(set map[string]bool, value string) bool {
+	return len(set) == 0 || set[value]
+}
+
+// toSet returns the values as a set, nil when there are none
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
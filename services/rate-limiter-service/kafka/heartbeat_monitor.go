@@ -0,0 +1,283 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/slo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/heartbeat"
+)
+
+// Heartbeats missed before an instance no longer counts as alive
+const missedHeartbeats = 3
+
+// Instances not heard from for this long are dropped from the view
+const forgetInstanceAfter = time.Hour
+
+// Service name in the heartbeats of the enqueue service
+const enqueueService = "enqueue-service"
+
+// InstanceStatus is the latest heartbeat of an instance
+type InstanceStatus struct {
+	heartbeat.Heartbeat
+	Alive bool `json:"alive"` // Heard from within the last heartbeats and not stopping
+}
+
+// HeartbeatMonitor keeps the latest heartbeat of every pipeline instance.
+// Every partition of the ops topic is consumed directly, so each instance
+// of the monitor sees all heartbeats.
+type HeartbeatMonitor struct {
+	consumer sarama.Consumer
+	topic    string
+
+	mu       sync.Mutex
+	latest   map[string]heartbeat.Heartbeat // Keyed by instance ID
+	previous map[string]heartbeat.Heartbeat // The heartbeat before the latest of the same run, for rates
+}
+
+// PriorityThroughput is the rate notifications of a priority are settled at,
+// summed over the live instances
+type PriorityThroughput struct {
+	PerSecond          float64            `json:"per_second"`
+	DeliveredPerSecond float64            `json:"delivered_per_second"`
+	SuppressionRate    float64            `json:"suppression_rate"` // Share of settled notifications not delivered
+	Outcomes           map[string]float64 `json:"outcomes"`         // Per second by outcome
+}
+
+// Indicators are the rates the service level objectives are computed from,
+// summed over the live instances
+type Indicators struct {
+	Requests      float64            // Enqueue requests per second
+	RequestErrors float64            // Enqueue requests per second answered with a server error
+	Settled       map[string]float64 // Notifications settled per second by priority
+	Delivered     map[string]float64 // Notifications sent for delivery per second by priority
+	Failed        map[string]float64 // Notifications failed for good per second by priority
+	Slow          map[string]float64 // Notifications delivered late per second by priority
+}
+
+// NewHeartbeatMonitor creates a monitor of the ops topic
+func NewHeartbeatMonitor(brokers []string, topic string) (*HeartbeatMonitor, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = false
+
+	consumer, err := sarama.NewConsumer(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	return &HeartbeatMonitor{
+		consumer: consumer,
+		topic:    topic,
+		latest:   make(map[string]heartbeat.Heartbeat),
+		previous: make(map[string]heartbeat.Heartbeat),
+	}, nil
+}
+
+// Start consumes heartbeats from now on until the context is done. The view
+// is complete once every instance sent its next heartbeat.
+func (m *HeartbeatMonitor) Start(ctx context.Context) error {
+	partitions, err := m.consumer.Partitions(m.topic)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %w", m.topic, err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, partition := range partitions {
+		partitionConsumer, err := m.consumer.ConsumePartition(m.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return fmt.Errorf("failed to consume partition %d of %s: %w", partition, m.topic, err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer partitionConsumer.Close()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case message, ok := <-partitionConsumer.Messages():
+					if !ok {
+						return
+					}
+
+					// Self-test probes share the topic
+					if headerValue(message, SelfTestHeader) != "" {
+						continue
+					}
+
+					var heartbeat heartbeat.Heartbeat
+					if err := json.Unmarshal(message.Value, &heartbeat); err != nil {
+						log.Printf("Error unmarshalling heartbeat: %v", err)
+						continue
+					}
+
+					m.mu.Lock()
+					last, exists := m.latest[heartbeat.InstanceID]
+					if exists && last.StartedAt.Equal(heartbeat.StartedAt) {
+						m.previous[heartbeat.InstanceID] = last
+					} else {
+						delete(m.previous, heartbeat.InstanceID)
+					}
+					m.latest[heartbeat.InstanceID] = heartbeat
+					m.mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	log.Printf("Monitoring heartbeats on %s", m.topic)
+	wg.Wait()
+	return nil
+}
+
+// Instances returns the latest heartbeat of every instance grouped by
+// service, forgetting instances not heard from for an hour
+func (m *HeartbeatMonitor) Instances() map[string][]InstanceStatus {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	services := make(map[string][]InstanceStatus)
+	for id, heartbeat := range m.latest {
+		silence := now.Sub(heartbeat.SentAt)
+		if silence > forgetInstanceAfter {
+			delete(m.latest, id)
+			delete(m.previous, id)
+			continue
+		}
+
+		interval := time.Duration(heartbeat.IntervalSeconds * float64(time.Second))
+		services[heartbeat.Service] = append(services[heartbeat.Service], InstanceStatus{
+			Heartbeat: heartbeat,
+			Alive:     !heartbeat.Stopping && silence <= missedHeartbeats*interval,
+		})
+	}
+
+	for _, instances := range services {
+		slices.SortFunc(instances, func(a, b InstanceStatus) int {
+			return strings.Compare(a.InstanceID, b.InstanceID)
+		})
+	}
+	return services
+}
+
+// Throughput returns the rate notifications were settled at per priority,
+// from the last two heartbeats of every live instance reporting outcomes
+func (m *HeartbeatMonitor) Throughput() map[string]*PriorityThroughput {
+	throughput := make(map[string]*PriorityThroughput)
+	m.rated(func(latest, previous heartbeat.Heartbeat, elapsed float64) {
+		for priority, outcomes := range latest.Outcomes {
+			t, exists := throughput[priority]
+			if !exists {
+				t = &PriorityThroughput{Outcomes: make(map[string]float64)}
+				throughput[priority] = t
+			}
+			for outcome, count := range outcomes {
+				rate := float64(count-previous.Outcomes[priority][outcome]) / elapsed
+				t.Outcomes[outcome] += rate
+				t.PerSecond += rate
+				if outcome == OutcomeDelivered {
+					t.DeliveredPerSecond += rate
+				}
+			}
+		}
+	})
+
+	for _, t := range throughput {
+		if t.PerSecond > 0 {
+			t.SuppressionRate = 1 - t.DeliveredPerSecond/t.PerSecond
+		}
+	}
+	return throughput
+}
+
+// Indicators returns the rates the service level objectives are computed
+// from, rated like Throughput
+func (m *HeartbeatMonitor) Indicators() Indicators {
+	indicators := Indicators{
+		Settled:   make(map[string]float64),
+		Delivered: make(map[string]float64),
+		Failed:    make(map[string]float64),
+		Slow:      make(map[string]float64),
+	}
+	m.rated(func(latest, previous heartbeat.Heartbeat, elapsed float64) {
+		if latest.Service == enqueueService {
+			indicators.Requests += float64(latest.Processed-previous.Processed) / elapsed
+			indicators.RequestErrors += float64(latest.Errors-previous.Errors) / elapsed
+			return
+		}
+		for priority, outcomes := range latest.Outcomes {
+			for outcome, count := range outcomes {
+				rate := float64(count-previous.Outcomes[priority][outcome]) / elapsed
+				indicators.Settled[priority] += rate
+				if outcome == OutcomeDelivered {
+					indicators.Delivered[priority] += rate
+				}
+			}
+		}
+		for priority, count := range latest.Failed {
+			indicators.Failed[priority] += float64(count-previous.Failed[priority]) / elapsed
+		}
+		for priority, count := range latest.Slow {
+			indicators.Slow[priority] += float64(count-previous.Slow[priority]) / elapsed
+		}
+	})
+	return indicators
+}
+
+// Rates returns the events of every service level objective per second
+func (i Indicators) Rates() map[string]slo.Rates {
+	rates := map[string]slo.Rates{
+		slo.EnqueueAvailability: {Total: i.Requests, Bad: i.RequestErrors},
+	}
+
+	// Failed notifications never settled, so they add to the total
+	var success slo.Rates
+	for priority, settled := range i.Settled {
+		success.Total += settled
+		rates[slo.Latency(priority)] = slo.Rates{Total: i.Delivered[priority], Bad: i.Slow[priority]}
+	}
+	for _, failed := range i.Failed {
+		success.Total += failed
+		success.Bad += failed
+	}
+	rates[slo.DeliverySuccess] = success
+	return rates
+}
+
+// rated calls fn with the last two heartbeats of every live instance that
+// sent two in the same run, and the seconds between them
+func (m *HeartbeatMonitor) rated(fn func(latest, previous heartbeat.Heartbeat, elapsed float64)) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, latest := range m.latest {
+		previous, exists := m.previous[id]
+		interval := time.Duration(latest.IntervalSeconds * float64(time.Second))
+		if !exists || latest.Stopping || now.Sub(latest.SentAt) > missedHeartbeats*interval {
+			continue
+		}
+		elapsed := latest.SentAt.Sub(previous.SentAt).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		fn(latest, previous, elapsed)
+	}
+}
+
+// Close releases resources
+func (m *HeartbeatMonitor) Close() error {
+	return m.consumer.Close()
+}
@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"errors"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/failures"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Handler processes a prioritized notification as the processor does,
+// returning the channels it was delivered on, none when it wasn't
+type Handler func(notification *models.PrioritizedNotification) ([]string, error)
+
+// Interceptor runs around the processing of a notification and calls next to
+// carry on with it, or returns without calling it to stop there. Cross-cutting
+// features such as metrics, tracing, retries, deduplication or auditing plug
+// into the processor as interceptors instead of being woven into its steps.
+type Interceptor func(notification *models.PrioritizedNotification, next Handler) ([]string, error)
+
+// Chain returns a handler running the interceptors around handler, the first
+// one outermost
+func Chain(handler Handler, interceptors ...Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(notification *models.PrioritizedNotification) ([]string, error) {
+			return interceptor(notification, next)
+		}
+	}
+	return handler
+}
+
+// settled reports whether a notification was settled rather than failed or
+// deferred: delivered, rate limited or not to be delivered at all
+func settled(err error) bool {
+	return err == nil || errors.Is(err, failures.ErrRateLimited)
+}
+
+// recordDecisions reports the channels of every settled notification to the
+// decision recorder
+func (p *Processor) recordDecisions(notification *models.PrioritizedNotification, next Handler) ([]string, error) {
+	channels, err := next(notification)
+	if settled(err) {
+		p.decisions.Record(p.ctx, notification, channels)
+	}
+	return channels, err
+}
+
+// recordUsage counts every settled notification for billing and anomaly detection
+func (p *Processor) recordUsage(notification *models.PrioritizedNotification, next Handler) ([]string, error) {
+	channels, err := next(notification)
+	if settled(err) {
+		p.usage.Record(notification, len(channels) > 0)
+	}
+	return channels, err
+}
+
+// traceFailures traces notifications sampled for debugging that failed,
+// leaving out rate-limited and deferred ones
+func (p *Processor) traceFailures(notification *models.PrioritizedNotification, next Handler) ([]string, error) {
+	channels, err := next(notification)
+	if err != nil && !errors.Is(err, failures.ErrRateLimited) && !errors.Is(err, failures.ErrDeferred) {
+		p.trace(notification, "failed", map[string]any{"error": err.Error()})
+	}
+	return channels, err
+}
+
+// suppress settles notifications a suppression rule suppresses, before
+// anything else happens to them. Bypassed notifications are exempt.
+func (p *Processor) suppress(notification *models.PrioritizedNotification, next Handler) ([]string, error) {
+	if p.bypassed(notification) {
+		return next(notification)
+	}
+
+	rule, reason, err := p.rules.Check(p.ctx, notification)
+	if err != nil {
+		return nil, failures.Transient(err)
+	}
+	if rule == "" {
+		return next(notification)
+	}
+
+	logging.ForRequest(notification.RequestID).Printf("Notification %s suppressed by rule %s: %s", notification.ID, rule, reason)
+	metrics.Suppressed.WithLabelValues(rule).Inc()
+	p.settle(notification, OutcomeSuppressed, map[string]any{"rule": rule, "reason": reason})
+	return nil, nil
+}
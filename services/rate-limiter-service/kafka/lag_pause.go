@@ -0,0 +1,122 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+)
+
+// laneLag tracks how far a lane's consumer group is behind its topic
+type laneLag struct {
+	mu         sync.Mutex
+	partitions map[int32]int64 // Messages behind the high watermark, per claimed partition
+}
+
+// record stores the lag of a partition as of its latest message
+func (l *laneLag) record(partition int32, lag int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.partitions == nil {
+		l.partitions = make(map[int32]int64)
+	}
+	l.partitions[partition] = lag
+}
+
+// reset forgets the partitions of a previous session
+func (l *laneLag) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partitions = nil
+}
+
+// total returns the lag summed over the claimed partitions
+func (l *laneLag) total() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total int64
+	for _, lag := range l.partitions {
+		total += lag
+	}
+	return total
+}
+
+// lagOf returns the messages a lane has yet to process, in Kafka and in its buffer
+func (lane *priorityLane) lagOf() int64 {
+	return lane.lag.total() + int64(len(lane.messages))
+}
+
+// watchLag pauses the consumer groups of the yielding lanes while the watched
+// lanes lag behind, and resumes them once the watched lanes caught up. The
+// yielding lanes' buffered messages are still processed, only fetching stops.
+func (c *KafkaPriorityConsumer) watchLag(ctx context.Context, cfg config.LagPauseConfig) {
+	yielding := make(map[string]bool, len(cfg.Priorities))
+	for _, priority := range cfg.Priorities {
+		yielding[priority] = true
+	}
+
+	var watched, paused []*priorityLane
+	for _, lane := range c.lanes {
+		if yielding[lane.priority] {
+			paused = append(paused, lane)
+		} else {
+			watched = append(watched, lane)
+		}
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	var pausedSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			if !pausedSince.IsZero() {
+				c.resumeLanes(paused, pausedSince)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		var lag int64
+		for _, lane := range watched {
+			lag += lane.lagOf()
+		}
+
+		switch {
+		case pausedSince.IsZero() && lag >= int64(cfg.Threshold):
+			log.Printf("Urgent lanes lag by %d messages, pausing %v", lag, cfg.Priorities)
+			pausedSince = time.Now()
+			c.pauseLanes(paused)
+		case !pausedSince.IsZero() && lag <= int64(cfg.ResumeLag):
+			log.Printf("Urgent lanes caught up to %d messages, resuming %v after %v", lag, cfg.Priorities, time.Since(pausedSince))
+			c.resumeLanes(paused, pausedSince)
+			pausedSince = time.Time{}
+		case !pausedSince.IsZero():
+			// Partitions claimed after a rebalance start unpaused
+			c.pauseLanes(paused)
+		}
+	}
+}
+
+// pauseLanes stops fetching on every partition the lanes' groups claim
+func (c *KafkaPriorityConsumer) pauseLanes(lanes []*priorityLane) {
+	for _, lane := range lanes {
+		lane.consumerGroup.PauseAll()
+		lane.paused.Store(true)
+		metrics.ConsumerPaused.WithLabelValues(lane.priority).Set(1)
+	}
+}
+
+// resumeLanes resumes fetching on the lanes and records how long they were paused
+func (c *KafkaPriorityConsumer) resumeLanes(lanes []*priorityLane, pausedSince time.Time) {
+	for _, lane := range lanes {
+		lane.consumerGroup.ResumeAll()
+		lane.paused.Store(false)
+		metrics.ConsumerPaused.WithLabelValues(lane.priority).Set(0)
+		metrics.ConsumerPauseDuration.WithLabelValues(lane.priority).Observe(time.Since(pausedSince).Seconds())
+	}
+}
@@ -0,0 +1,58 @@
+package kafka
+
+import "sync"
+
+// Outcomes of a settled notification, named like the stages debug traces report
+const (
+	OutcomeDelivered      = "delivered"
+	OutcomeRateLimited    = "rate_limited"
+	OutcomeUserSuspended  = "user_suspended"
+	OutcomeUserDeleted    = "user_deleted"
+	OutcomeOptedOut       = "opted_out"
+	OutcomeSnoozed        = "snoozed"  // Skipped while the user snoozed the event type
+	OutcomeDeferred       = "deferred" // Sent again once the user's snooze ends
+	OutcomeNoChannels     = "no_channels"
+	OutcomeUnroutedRegion = "unrouted_region" // The user's region has no route and its data must stay in it
+	OutcomeOutsideWindow  = "outside_window"  // Sent again once the event type's delivery window opens for the user
+	OutcomeSpaced         = "spaced"          // Sent again once the user's spaced channels free up
+	OutcomeSuppressed     = "suppressed"      // Suppressed by one of the registered suppression rules
+	OutcomeInvalidContact = "invalid_contact" // Every channel left was dropped for a contact that can't be delivered to
+	OutcomeBlocklisted    = "blocklisted"     // The user, or the contact of every channel left, is on a suppression list
+	OutcomeWarmingUp      = "warming_up"      // Sent again once the warm-up caps of its channels reset
+	OutcomeDuplicate      = "duplicate"       // A copy of an expanded event that was delivered already
+)
+
+// outcomeCounts counts settled notifications by priority and outcome since start
+type outcomeCounts struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int64
+}
+
+// record counts a notification of the priority settled with the outcome
+func (c *outcomeCounts) record(priority, outcome string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[string]map[string]int64)
+	}
+	if c.counts[priority] == nil {
+		c.counts[priority] = make(map[string]int64)
+	}
+	c.counts[priority][outcome]++
+}
+
+// snapshot returns a copy of the counts
+func (c *outcomeCounts) snapshot() map[string]map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot := make(map[string]map[string]int64, len(c.counts))
+	for priority, outcomes := range c.counts {
+		snapshot[priority] = make(map[string]int64, len(outcomes))
+		for outcome, count := range outcomes {
+			snapshot[priority][outcome] = count
+		}
+	}
+	return snapshot
+}
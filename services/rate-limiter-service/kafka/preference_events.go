@@ -0,0 +1,129 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// Kinds of preference change events
+const (
+	PreferenceChangeUpdated = "updated"
+	PreferenceChangeDeleted = "deleted" // All data of the user was erased
+)
+
+// PreferenceChangeEvent is published by the preferences service whenever a user's preferences change
+type PreferenceChangeEvent struct {
+	UserID    string `json:"user_id"`
+	Type      string `json:"type"`
+	ChangedAt int64  `json:"changed_at"`
+}
+
+// PreferenceChangeConsumer reads preference change events. Every instance
+// must see every change to keep its cache correct, so partitions are consumed
+// directly instead of through a consumer group.
+type PreferenceChangeConsumer struct {
+	consumer   sarama.Consumer
+	partitions []sarama.PartitionConsumer
+	verifier   *signing.Verifier
+	topic      string
+}
+
+// NewPreferenceChangeConsumer creates a consumer subscribed to every partition
+// of the preference change topic from now on. It fails when the topic doesn't
+// exist, so startup retries instead of running with a cache nothing invalidates.
+func NewPreferenceChangeConsumer(cfg config.KafkaConsumerConfig) (*PreferenceChangeConsumer, error) {
+	verifier, err := signing.NewVerifier(cfg.Signing)
+	if err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = false
+
+	consumer, err := sarama.NewConsumer(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	c := &PreferenceChangeConsumer{
+		consumer: consumer,
+		verifier: verifier,
+		topic:    cfg.PreferencesTopic,
+	}
+	if err := c.subscribe(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// subscribe starts consuming every partition of the topic at its newest offset
+func (c *PreferenceChangeConsumer) subscribe() error {
+	partitions, err := c.consumer.Partitions(c.topic)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %w", c.topic, err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %s has no partitions", c.topic)
+	}
+
+	for _, partition := range partitions {
+		partitionConsumer, err := c.consumer.ConsumePartition(c.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return fmt.Errorf("failed to consume partition %d of %s: %w", partition, c.topic, err)
+		}
+		c.partitions = append(c.partitions, partitionConsumer)
+	}
+	return nil
+}
+
+// Start calls onChange for each change event until the context is done
+func (c *PreferenceChangeConsumer) Start(ctx context.Context, onChange func(event *PreferenceChangeEvent)) {
+	wg := &sync.WaitGroup{}
+	for _, partitionConsumer := range c.partitions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case message, ok := <-partitionConsumer.Messages():
+					if !ok {
+						return
+					}
+					if rejects(c.verifier, message) {
+						continue
+					}
+
+					var event PreferenceChangeEvent
+					if err := json.Unmarshal(message.Value, &event); err != nil {
+						log.Printf("Error unmarshalling preference change: %v", err)
+						continue
+					}
+
+					onChange(&event)
+				}
+			}
+		}()
+	}
+
+	log.Printf("Listening for preference changes on %s", c.topic)
+	wg.Wait()
+}
+
+// Close stops consuming the partitions and releases resources
+func (c *PreferenceChangeConsumer) Close() error {
+	for _, partitionConsumer := range c.partitions {
+		partitionConsumer.Close()
+	}
+	return c.consumer.Close()
+}
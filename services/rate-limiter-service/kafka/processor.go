@@ -2,98 +2,704 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/blocklist"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/contacts"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/degraded"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/preferences"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/ratelimiter"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/registry"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/sla"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/suppression"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/debugtrace"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/failures"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
 )
 
 // Processor handles business logic for processing notifications
 type Processor struct {
-	rateLimiter       ratelimiter.RateLimiter
+	rateLimiter        ratelimiter.RateLimiter
 	preferencesService preferences.PreferencesService
-	producer          Producer
-	ctx               context.Context
+	producer           Producer
+	slaTracker         *sla.Tracker
+	eventRegistry      *registry.Registry
+	decisions          DecisionRecorder   // Optional, sees the outcome of every notification
+	tracer             debugtrace.Tracer  // Optional, traces notifications sampled for debugging
+	usage              UsageRecorder      // Optional, counts settled notifications for billing and anomaly detection
+	bypass             BypassGate         // Optional, honours signed bypasses of rate limits and opt-outs
+	engagement         EngagementModel    // Optional, picks the channel for event types any one channel will do
+	costs              CostSelector       // Optional, keeps less urgent notifications on cheap channels
+	stages             *degraded.Switches // Optional, stages operators skip in degraded mode
+	regions            RegionRouter       // Optional, picks the providers of the user's region and keeps resident data in it
+	stats              DeliveryStats      // Optional, projects deliveries and holds back channels that reached the user too recently
+	spacer             Spacer             // Optional, defers notifications following the previous one on a channel too closely
+	contacts           ContactChecker     // Optional, drops channels whose contact can't be delivered to
+	statuses           StatusProducer     // Optional, flags the dropped contacts on the status topic
+	blocklist          Blocklist          // Optional, users and contacts that must never be contacted
+	warmUp             WarmUpLimiter      // Optional, caps the daily deliveries from new sending identities
+	deliveries         DeliveryLog        // Optional, drops copies of expanded events that were delivered already
+	rules              suppression.Rules  // Optional, registered rules suppressing notifications
+	tight              time.Duration      // Optional steps are skipped once less is left until a notification's deadline
+	urgentLevels       map[string]bool    // Priorities configured as urgent
+	interceptors       []Interceptor      // Run around every notification, the first outermost
+	handle             Handler            // The interceptors chained around process
+	outcomes           outcomeCounts
+	ctx                context.Context
+}
+
+// ProcessorConfig holds what a processor works with. Optional ones may be
+// left nil, which skips the steps they serve.
+type ProcessorConfig struct {
+	RateLimiter   ratelimiter.RateLimiter
+	Preferences   preferences.PreferencesService
+	Producer      Producer
+	SLATracker    *sla.Tracker
+	EventRegistry *registry.Registry
+	Priorities    []config.PriorityLevelConfig // Notifications of the urgent levels are spared snoozes, cooldowns, spacing and warm-up caps
+
+	Decisions  DecisionRecorder   // Optional, sees the outcome of every notification
+	Tracer     debugtrace.Tracer  // Optional, traces notifications sampled for debugging
+	Usage      UsageRecorder      // Optional, counts settled notifications for billing and anomaly detection
+	Bypass     BypassGate         // Optional, honours signed bypasses of rate limits and opt-outs
+	Engagement EngagementModel    // Optional, picks the channel for event types any one channel will do
+	Costs      CostSelector       // Optional, keeps less urgent notifications on cheap channels
+	Stages     *degraded.Switches // Optional, stages operators skip in degraded mode
+	Regions    RegionRouter       // Optional, picks the providers of the user's region and keeps resident data in it
+	Stats      DeliveryStats      // Optional, projects deliveries and holds back channels that reached the user too recently
+	Spacer     Spacer             // Optional, defers notifications following the previous one on a channel too closely
+	Contacts   ContactChecker     // Optional, drops channels whose contact can't be delivered to
+	Statuses   StatusProducer     // Optional, flags the contacts dropped by Contacts on the status topic
+	Blocklist  Blocklist          // Optional, skips users on the suppression lists and drops channels whose contact is on them
+	WarmUp     WarmUpLimiter      // Optional, holds back channels whose sending identity reached its warm-up cap for the day
+	Deliveries DeliveryLog        // Optional, drops copies of an expanded event delivered already, as a retried expansion produces every copy again
+	Rules      suppression.Rules  // Optional, settles suppressed notifications before anything else happens to them, bypassed ones are exempt
+
+	// Optional steps are skipped for notifications with less than this left
+	// until their deadline, zero never skips them
+	TightBudget time.Duration
 }
 
 // NewProcessor creates a new notification processor
-func NewProcessor(ctx context.Context, rateLimiter ratelimiter.RateLimiter, 
-	preferencesService preferences.PreferencesService, producer Producer) *Processor {
-	return &Processor{
-		ctx:               ctx,
-		rateLimiter:       rateLimiter,
-		preferencesService: preferencesService,
-		producer:          producer,
+func NewProcessor(ctx context.Context, cfg ProcessorConfig) *Processor {
+	urgentLevels := make(map[string]bool, len(cfg.Priorities))
+	for _, level := range cfg.Priorities {
+		if level.Urgent {
+			urgentLevels[level.Name] = true
+		}
+	}
+
+	p := &Processor{
+		ctx:                ctx,
+		rateLimiter:        cfg.RateLimiter,
+		preferencesService: cfg.Preferences,
+		producer:           cfg.Producer,
+		slaTracker:         cfg.SLATracker,
+		eventRegistry:      cfg.EventRegistry,
+		decisions:          cfg.Decisions,
+		tracer:             cfg.Tracer,
+		usage:              cfg.Usage,
+		bypass:             cfg.Bypass,
+		engagement:         cfg.Engagement,
+		costs:              cfg.Costs,
+		stages:             cfg.Stages,
+		regions:            cfg.Regions,
+		stats:              cfg.Stats,
+		spacer:             cfg.Spacer,
+		contacts:           cfg.Contacts,
+		statuses:           cfg.Statuses,
+		blocklist:          cfg.Blocklist,
+		warmUp:             cfg.WarmUp,
+		deliveries:         cfg.Deliveries,
+		rules:              cfg.Rules,
+		tight:              cfg.TightBudget,
+		urgentLevels:       urgentLevels,
 	}
+	p.handle = p.process
+
+	// Report the outcome once the notification is settled
+	if cfg.Decisions != nil {
+		p.Use(p.recordDecisions)
+	}
+	if cfg.Usage != nil {
+		p.Use(p.recordUsage)
+	}
+	p.Use(p.traceFailures)
+	if len(cfg.Rules) > 0 {
+		p.Use(p.suppress)
+	}
+	return p
+}
+
+// Use adds interceptors around the processing of every notification, inside
+// the ones added before. Not safe to call while notifications are processed.
+func (p *Processor) Use(interceptors ...Interceptor) {
+	p.interceptors = append(p.interceptors, interceptors...)
+	p.handle = Chain(p.process, p.interceptors...)
+}
+
+// UsageRecorder counts notifications once they are settled, rate limited ones included
+type UsageRecorder interface {
+	Record(notification *models.PrioritizedNotification, delivered bool)
+}
+
+// UsageRecorders records settled notifications with each of its recorders
+type UsageRecorders []UsageRecorder
+
+// Record passes the notification to every recorder
+func (r UsageRecorders) Record(notification *models.PrioritizedNotification, delivered bool) {
+	for _, recorder := range r {
+		recorder.Record(notification, delivered)
+	}
+}
+
+// BypassGate verifies the bypasses of emergency notifications and audits those honoured
+type BypassGate interface {
+	Verify(notification *models.PrioritizedNotification) error
+	Record(ctx context.Context, notification *models.PrioritizedNotification, channels []string) error
+}
+
+// EngagementModel knows which channels users engage with and learns from what is sent to them
+type EngagementModel interface {
+	// Preferred returns the channel the user engages with most, or one picked
+	// at random to keep exploring and true, empty when it can't tell
+	Preferred(ctx context.Context, userID string, channels []string) (string, bool, error)
+	// Token returns the token opens and action clicks of a notification are reported with
+	Token(notificationID, userID string) string
+	RecordSent(ctx context.Context, userID string, channels []string) error
+}
+
+// DeliveryStats projects what was delivered to users and knows which channels reached one too recently
+type DeliveryStats interface {
+	// CoolingDown returns the channels that reached the user more recently than their cooldown allows
+	CoolingDown(ctx context.Context, userID string, channels []string) ([]string, error)
+	RecordDelivered(ctx context.Context, userID, eventType string, channels []string) error
+}
+
+// Spacer keeps a least interval between two notifications to a user on a channel
+type Spacer interface {
+	// Reserve reserves the channels for the notification, or returns when they free up
+	Reserve(ctx context.Context, userID, notificationID string, channels []string) (time.Time, bool, error)
+	// FreeAt returns when the channels free up, without reserving them
+	FreeAt(ctx context.Context, userID string, channels []string) (time.Time, error)
+	// Release gives back the channels the notification reserved
+	Release(ctx context.Context, userID, notificationID string, channels []string) error
+}
+
+// CostSelector moves the channels too expensive for a notification's priority to its fallbacks
+type CostSelector interface {
+	// Select splits channels into those within the priority's budget and those deferred to the fallbacks
+	Select(priority string, channels []string) ([]string, []string)
+	// Record adds the cost of the channels a notification was delivered on to its tenant's spend
+	Record(notification *models.PrioritizedNotification, channels []string)
+}
+
+// RegionRouter knows where users of a region may be delivered to and by which providers
+type RegionRouter interface {
+	// Allowed reports whether notifications of users in the region may be delivered
+	Allowed(region string) bool
+	// Providers returns the providers of the region for the channels it has one for
+	Providers(region string, channels []string) map[string]string
+}
+
+// DeliveryLog remembers the notifications that reached the delivery topic
+type DeliveryLog interface {
+	Delivered(ctx context.Context, notificationID string) (bool, error)
+	Record(ctx context.Context, notificationID string) error
 }
 
 // ProcessMessage processes a notification message
 func (p *Processor) ProcessMessage(notification *models.PrioritizedNotification) error {
+	_, err := p.handle(notification)
+	return err
+}
+
+// process runs a notification through the steps of the pipeline, returning
+// the channels it was delivered on
+func (p *Processor) process(notification *models.PrioritizedNotification) (_ []string, err error) {
 	start := time.Now()
-	
-	log.Printf("Processing notification %s for user %s with priority %s",
+
+	logger := logging.ForRequest(notification.RequestID)
+	logger.Printf("Processing notification %s for user %s with priority %s",
 		notification.ID, notification.UserID, notification.Priority)
-	
-	// Step 1: Apply rate limiting
-	isLimited, err := p.rateLimiter.IsRateLimited(p.ctx, notification)
-	if err != nil {
-		return fmt.Errorf("rate limiting error: %w", err)
+
+	// Don't start work that can't finish during shutdown
+	if err := p.ctx.Err(); err != nil {
+		return nil, failures.Transient(fmt.Errorf("processor stopped: %w", err))
 	}
-	
-	if isLimited {
-		log.Printf("Notification %s rate limited for user %s", notification.ID, notification.UserID)
-		// Notification is rate limited, stop processing
-		return nil
+
+	// Copies of an expanded event come again when the expansion is retried
+	dedupe := p.deliveries != nil && notification.ExpandedFrom != ""
+	if dedupe {
+		delivered, err := p.deliveries.Delivered(p.ctx, notification.ID)
+		if err != nil {
+			return nil, failures.Transient(err)
+		}
+		if delivered {
+			logger.Printf("Notification %s was delivered already, dropping the copy", notification.ID)
+			p.settle(notification, OutcomeDuplicate, nil)
+			return nil, nil
+		}
 	}
-	
-	// Step 2: Get user preferences
-	userPreferences, err := p.preferencesService.GetUserPreferences(notification.UserID)
+
+	d, err := p.decide(p.ctx, notification, false)
+	// A notification that doesn't make it to the delivery topic gives back
+	// what it took
+	var delivered bool
+	defer func() {
+		if !delivered {
+			p.release(notification, d)
+		}
+	}()
+	p.report(notification, d)
 	if err != nil {
-		return fmt.Errorf("error getting user preferences: %w", err)
-	}
-	
-	// Step 3: Check global opt-out
-	if !userPreferences.GlobalOptIn {
-		log.Printf("User %s has opted out of all notifications", notification.UserID)
-		return nil
-	}
-	
-	// Step 4: Determine delivery channels based on preferences
-	channels := p.determineDeliveryChannels(notification, userPreferences)
-	
-	if len(channels) == 0 {
-		log.Printf("No delivery channels enabled for notification %s", notification.ID)
-		return nil
+		return nil, err
+	}
+	if d.outcome != "" {
+		p.settle(notification, d.outcome, d.details)
+		return nil, d.retry
 	}
-	
-	// Step 5: Create processed notification with channels
+	channels := d.channels
+
+	// Step 8: Create processed notification with channels
 	processedNotification := &models.ProcessedNotification{
 		PrioritizedNotification: *notification,
-		Channels:               channels,
+		Channels:                channels,
+		FallbackChannels:        d.fallback,
+		CollapseKey:             collapseKey(notification),
+		Region:                  d.preferences.Region,
 	}
-	
-	// Step 6: Send to delivery topic
-	if err := p.producer.SendMessage(processedNotification); err != nil {
-		return fmt.Errorf("failed to send processed notification: %w", err)
+	if p.regions != nil {
+		processedNotification.Providers = p.regions.Providers(d.preferences.Region, channels)
 	}
-	
+	if !d.quota.Exempt {
+		processedNotification.RateLimit = &d.quota
+	}
+	if p.engagement != nil {
+		processedNotification.EngagementToken = p.engagement.Token(notification.ID, notification.UserID)
+	}
+
+	// A bypass is only honoured once it is on record
+	if d.bypassed {
+		if err := p.bypass.Record(p.ctx, notification, channels); err != nil {
+			return nil, failures.Transient(err)
+		}
+		metrics.Bypasses.WithLabelValues("honoured").Inc()
+	}
+
+	// Step 9: Send to delivery topic
+	if err := p.producer.SendMessage(p.ctx, processedNotification); err != nil {
+		return nil, failures.Transient(fmt.Errorf("failed to send processed notification: %w", err))
+	}
+	delivered = true
+	if dedupe {
+		if err := p.deliveries.Record(p.ctx, notification.ID); err != nil {
+			logger.Printf("Failed to record delivery of notification %s: %v", notification.ID, err)
+		}
+	}
+	p.settle(notification, OutcomeDelivered, map[string]any{"channels": channels, "sandbox": notification.Test})
+	if notification.Deadline != 0 && time.Now().UnixMilli() > notification.Deadline {
+		late := time.Since(time.UnixMilli(notification.Deadline)).Round(time.Millisecond)
+		logger.Printf("Notification %s reached the delivery topic %v after its deadline", notification.ID, late)
+		metrics.BudgetExceeded.WithLabelValues(notification.Priority).Inc()
+	}
+
+	// Nobody engages with test notifications, they would only skew the rates
+	if p.engagement != nil && !notification.Test {
+		if err := p.engagement.RecordSent(p.ctx, notification.UserID, channels); err != nil {
+			logger.Printf("Failed to count notification %s for engagement: %v", notification.ID, err)
+		}
+	}
+	// Test notifications go to the sandbox and cost nothing
+	if p.costs != nil && !notification.Test {
+		p.costs.Record(notification, channels)
+	}
+	if p.stats != nil && !notification.Test {
+		if err := p.stats.RecordDelivered(p.ctx, notification.UserID, notification.EventType, channels); err != nil {
+			logger.Printf("Failed to record delivery stats of notification %s: %v", notification.ID, err)
+		}
+	}
+
+	// Step 10: Record end-to-end latency against the priority's SLO
+	p.slaTracker.Observe(notification, time.Now())
+
 	elapsed := time.Since(start)
-	log.Printf("Processed notification %s in %v, sending to channels: %v", 
+	logger.Printf("Processed notification %s in %v, sending to channels: %v",
 		notification.ID, elapsed, channels)
-	
-	return nil
+
+	return channels, nil
+}
+
+// budgetTight reports whether too little is left until the notification's
+// deadline for optional steps, counting the notification that skips them
+func (p *Processor) budgetTight(notification *models.PrioritizedNotification) bool {
+	if p.tight <= 0 || notification.Deadline == 0 || time.Until(time.UnixMilli(notification.Deadline)) >= p.tight {
+		return false
+	}
+	logging.ForRequest(notification.RequestID).Printf("Notification %s skips optional steps, %v of its budget left",
+		notification.ID, time.Until(time.UnixMilli(notification.Deadline)).Round(time.Millisecond))
+	metrics.BudgetTight.WithLabelValues(notification.Priority).Inc()
+	return true
+}
+
+// coolDown drops the channels and fallback channels that reached the user
+// more recently than their cooldown allows, promoting the first remaining
+// fallback when no channel is left. Urgent notifications ignore cooldowns,
+// and so do all while the stats can't be read.
+func (p *Processor) coolDown(ctx context.Context, notification *models.PrioritizedNotification, channels, fallback []string) ([]string, []string, []string) {
+	if p.stats == nil || p.urgent(notification.Priority) || len(channels)+len(fallback) == 0 {
+		return channels, fallback, nil
+	}
+
+	cooling, err := p.stats.CoolingDown(ctx, notification.UserID, append(slices.Clone(channels), fallback...))
+	if err != nil {
+		logging.ForRequest(notification.RequestID).Printf("Failed to read delivery stats of user %s, ignoring cooldowns: %v",
+			notification.UserID, err)
+		return channels, fallback, nil
+	}
+	if len(cooling) == 0 {
+		return channels, fallback, nil
+	}
+
+	coolingDown := func(channel string) bool { return slices.Contains(cooling, channel) }
+	channels = slices.DeleteFunc(slices.Clone(channels), coolingDown)
+	fallback = slices.DeleteFunc(slices.Clone(fallback), coolingDown)
+	if len(channels) == 0 && len(fallback) > 0 {
+		channels, fallback = fallback[:1], fallback[1:]
+	}
+	return channels, fallback, cooling
+}
+
+// ContactChecker checks the contact a user stored for a channel, returning
+// it normalized or why it can't be delivered to
+type ContactChecker interface {
+	Check(channel, contact string) (string, error)
+}
+
+// checkContacts drops the channels and fallback channels whose stored
+// contact the checker refuses, promoting the first remaining fallback when
+// no channel is left. Channels without a stored contact are kept, their
+// senders look the contact up on their own.
+func (p *Processor) checkContacts(notification *models.PrioritizedNotification, userPreferences *preferences.UserPreferences,
+	channels, fallback []string) ([]string, []string, map[string]error) {
+	if p.contacts == nil || len(userPreferences.Contacts) == 0 {
+		return channels, fallback, nil
+	}
+
+	invalid := make(map[string]error)
+	for _, channel := range append(slices.Clone(channels), fallback...) {
+		contact, ok := userPreferences.Contacts[channel]
+		if !ok {
+			continue
+		}
+		if _, err := p.contacts.Check(channel, contact); err != nil {
+			invalid[channel] = err
+		}
+	}
+	if len(invalid) == 0 {
+		return channels, fallback, nil
+	}
+
+	refused := func(channel string) bool { return invalid[channel] != nil }
+	channels = slices.DeleteFunc(slices.Clone(channels), refused)
+	fallback = slices.DeleteFunc(slices.Clone(fallback), refused)
+	if len(channels) == 0 && len(fallback) > 0 {
+		channels, fallback = fallback[:1], fallback[1:]
+	}
+	return channels, fallback, invalid
+}
+
+// WarmUpLimiter caps the daily deliveries from sending identities still warming up
+type WarmUpLimiter interface {
+	// Take counts the channels against the caps, returning those taken, those throttled and when the caps reset
+	Take(ctx context.Context, tenant string, channels []string, force bool) ([]string, []string, time.Time, error)
+	// Release gives back the counts of channels taken by a notification that wasn't delivered
+	Release(ctx context.Context, tenant string, channels []string) error
+}
+
+// takeWarmUp counts the channels against the warm-up caps. When every
+// channel is throttled, the fallback channels are tried in order until one
+// is taken.
+func (p *Processor) takeWarmUp(tenant string, channels, fallback []string, force bool) ([]string, []string, []string, time.Time, error) {
+	taken, throttled, reset, err := p.warmUp.Take(p.ctx, tenant, channels, force)
+	if err != nil {
+		return nil, nil, nil, time.Time{}, err
+	}
+	for len(taken) == 0 && len(fallback) > 0 {
+		var more []string
+		taken, more, reset, err = p.warmUp.Take(p.ctx, tenant, fallback[:1], force)
+		if err != nil {
+			return nil, nil, nil, time.Time{}, err
+		}
+		throttled = append(throttled, more...)
+		fallback = fallback[1:]
+	}
+	return taken, fallback, throttled, reset, nil
 }
 
-// determineDeliveryChannels determines which channels to deliver the notification to
+// Blocklist holds the users and contacts that must never be contacted, in
+// the global suppression list or a tenant's
+type Blocklist interface {
+	// User returns the entry blocking a user
+	User(tenant, userID string) (blocklist.Entry, bool)
+	// Contact returns the entry blocking the contact a user stored for a channel
+	Contact(tenant, channel, contact string) (blocklist.Entry, bool)
+}
+
+// dropBlocked drops the channels and fallback channels whose stored contact
+// is on a suppression list, promoting the first remaining fallback when no
+// channel is left
+func (p *Processor) dropBlocked(tenant string, userPreferences *preferences.UserPreferences,
+	channels, fallback []string) ([]string, []string, map[string]blocklist.Entry) {
+	if p.blocklist == nil || len(userPreferences.Contacts) == 0 {
+		return channels, fallback, nil
+	}
+
+	blocked := make(map[string]blocklist.Entry)
+	for _, channel := range append(slices.Clone(channels), fallback...) {
+		contact, ok := userPreferences.Contacts[channel]
+		if !ok {
+			continue
+		}
+		if entry, ok := p.blocklist.Contact(tenant, channel, contact); ok {
+			blocked[channel] = entry
+		}
+	}
+	if len(blocked) == 0 {
+		return channels, fallback, nil
+	}
+
+	listed := func(channel string) bool {
+		_, ok := blocked[channel]
+		return ok
+	}
+	channels = slices.DeleteFunc(slices.Clone(channels), listed)
+	fallback = slices.DeleteFunc(slices.Clone(fallback), listed)
+	if len(channels) == 0 && len(fallback) > 0 {
+		channels, fallback = fallback[:1], fallback[1:]
+	}
+	return channels, fallback, blocked
+}
+
+// listOf names the suppression list an entry is on for logs
+func listOf(entry blocklist.Entry) string {
+	if entry.Tenant == "" {
+		return "every tenant"
+	}
+	return "tenant " + entry.Tenant
+}
+
+// flagContact publishes that a notification wasn't delivered on a channel
+// for its contact. A failed publish is logged, the notification goes on.
+func (p *Processor) flagContact(notification *models.PrioritizedNotification, channel string, reason error) {
+	if p.statuses == nil {
+		return
+	}
+
+	event := &models.StatusEvent{
+		NotificationID: notification.ID,
+		RequestID:      notification.RequestID,
+		UserID:         notification.UserID,
+		Status:         models.StatusInvalidContact,
+		Channel:        channel,
+		Reason:         reason.Error(),
+		OccurredAt:     time.Now().Unix(),
+	}
+	if err := p.statuses.PublishStatus(p.ctx, event); err != nil {
+		logging.ForRequest(notification.RequestID).Printf("Failed to flag the %s contact of notification %s: %v",
+			channel, notification.ID, err)
+	}
+}
+
+// bypassed reports whether a notification carries a valid bypass. Invalid
+// ones are ignored, the notification is processed like any other.
+func (p *Processor) bypassed(notification *models.PrioritizedNotification) bool {
+	if notification.Bypass == nil {
+		return false
+	}
+	err := errors.New("bypasses not enabled")
+	if p.bypass != nil {
+		if err = p.bypass.Verify(notification); err == nil {
+			return true
+		}
+	}
+
+	logging.ForRequest(notification.RequestID).Printf("Ignoring bypass of notification %s from API key %s: %v",
+		notification.ID, notification.APIKeyID, err)
+	metrics.Bypasses.WithLabelValues("rejected").Inc()
+	return false
+}
+
+// Outcomes returns the notifications settled since start by priority and outcome
+func (p *Processor) Outcomes() map[string]map[string]int64 {
+	return p.outcomes.snapshot()
+}
+
+// settle counts the outcome of a notification and traces it
+func (p *Processor) settle(notification *models.PrioritizedNotification, outcome string, details map[string]any) {
+	p.outcomes.record(notification.Priority, outcome)
+	p.trace(notification, outcome, details)
+}
+
+// trace publishes a debug trace when the notification was sampled for debugging
+func (p *Processor) trace(notification *models.PrioritizedNotification, stage string, details map[string]any) {
+	if p.tracer == nil || !notification.Debug {
+		return
+	}
+
+	p.tracer.Trace(p.ctx, &models.DebugTrace{
+		NotificationID: notification.ID,
+		RequestID:      notification.RequestID,
+		UserID:         notification.UserID,
+		Stage:          stage,
+		Details:        details,
+		Payload:        notification,
+		Timestamp:      time.Now().UnixMilli(),
+	})
+}
+
+// skip reports whether operators skip a stage in degraded mode, auditing
+// the notification that skips it
+func (p *Processor) skip(notification *models.PrioritizedNotification, stage string) bool {
+	if p.stages == nil || !p.stages.Skipped(stage) {
+		return false
+	}
+	p.stages.Record(stage)
+	logging.ForRequest(notification.RequestID).Printf("Degraded mode: notification %s skipped stage %s", notification.ID, stage)
+	p.trace(notification, "stage_skipped", map[string]any{"stage": stage})
+	return true
+}
+
+// report logs and counts what a decision was based on and the outcome it
+// settled the notification with, flagging refused contacts on the status topic
+func (p *Processor) report(notification *models.PrioritizedNotification, d *decision) {
+	logger := logging.ForRequest(notification.RequestID)
+
+	if d.selection != "" {
+		metrics.EngagementSelections.WithLabelValues(d.selection).Inc()
+	}
+	for _, channel := range d.expensive {
+		metrics.CostDeferred.WithLabelValues(notification.Priority, channel).Inc()
+	}
+	for _, channel := range d.cooling {
+		metrics.ChannelCooldowns.WithLabelValues(channel).Inc()
+	}
+	for channel, err := range d.invalid {
+		logger.Printf("Dropping channel %s of notification %s, the contact of user %s is refused: %v",
+			channel, notification.ID, notification.UserID, err)
+		metrics.InvalidContacts.WithLabelValues(channel, contacts.Reason(err)).Inc()
+		p.flagContact(notification, channel, err)
+	}
+	for channel, entry := range d.blocked {
+		logger.Printf("Dropping channel %s of notification %s, the contact of user %s is on the suppression list of %s for %s",
+			channel, notification.ID, notification.UserID, listOf(entry), entry.Reason)
+		metrics.Blocklisted.WithLabelValues(entry.Kind, entry.Reason).Inc()
+	}
+	for _, channel := range d.throttled {
+		metrics.WarmUpThrottled.WithLabelValues(channel).Inc()
+	}
+
+	until, _ := d.details["until"].(time.Time)
+	switch d.outcome {
+	case "":
+		if !d.preferences.GlobalOptIn && !d.bypassed {
+			logger.Printf("User %s has opted out of all notifications, delivering mandatory %s notification %s",
+				notification.UserID, notification.EventType, notification.ID)
+		}
+	case OutcomeBlocklisted:
+		if d.userEntry != nil {
+			logger.Printf("User %s is on the suppression list of %s for %s, skipping notification %s",
+				notification.UserID, listOf(*d.userEntry), d.userEntry.Reason, notification.ID)
+			metrics.Blocklisted.WithLabelValues(blocklist.KindUser, d.userEntry.Reason).Inc()
+			return
+		}
+		logger.Printf("No channels left for notification %s outside the suppression lists", notification.ID)
+	case OutcomeRateLimited:
+		logger.Printf("Notification %s rate limited for user %s", notification.ID, notification.UserID)
+	case OutcomeUserSuspended, OutcomeUserDeleted:
+		logger.Printf("User %s is %s, skipping notification %s", notification.UserID, d.preferences.Status, notification.ID)
+		metrics.UserStatusSkipped.WithLabelValues(d.preferences.Status).Inc()
+	case OutcomeUnroutedRegion:
+		logger.Printf("User %s is in region %s without a delivery route, skipping notification %s",
+			notification.UserID, d.preferences.Region, notification.ID)
+		metrics.RegionUnrouted.WithLabelValues(d.preferences.Region).Inc()
+	case OutcomeOptedOut:
+		logger.Printf("User %s has opted out of all notifications", notification.UserID)
+	case OutcomeDeferred:
+		logger.Printf("User %s snoozed %s notifications, deferring notification %s until %s",
+			notification.UserID, notification.EventType, notification.ID, until.Format(time.RFC3339))
+		metrics.Snoozed.WithLabelValues(notification.Priority, "deferred").Inc()
+	case OutcomeSnoozed:
+		logger.Printf("User %s snoozed %s notifications, skipping notification %s",
+			notification.UserID, notification.EventType, notification.ID)
+		metrics.Snoozed.WithLabelValues(notification.Priority, "skipped").Inc()
+	case OutcomeOutsideWindow:
+		window := p.eventRegistry.DeliveryWindowOf(notification.EventType)
+		logger.Printf("%s notifications are delivered from %s to %s, deferring notification %s until %s",
+			notification.EventType, window.Start, window.End, notification.ID, until.Format(time.RFC3339))
+		metrics.OutsideWindow.WithLabelValues(notification.Priority).Inc()
+	case OutcomeInvalidContact:
+		logger.Printf("No deliverable channels left for notification %s", notification.ID)
+	case OutcomeNoChannels:
+		logger.Printf("No delivery channels enabled for notification %s", notification.ID)
+	case OutcomeWarmingUp:
+		logger.Printf("Channels %v of notification %s reached their warm-up caps, deferring it until %s",
+			d.throttled, notification.ID, until.Format(time.RFC3339))
+	case OutcomeSpaced:
+		logger.Printf("User %s was notified too recently, deferring notification %s until %s",
+			notification.UserID, notification.ID, until.Format(time.RFC3339))
+		metrics.Spaced.WithLabelValues(notification.Priority).Inc()
+	}
+}
+
+// release gives back the quota, warm-up counts and spacing a notification
+// took when it wasn't delivered
+func (p *Processor) release(notification *models.PrioritizedNotification, d *decision) {
+	logger := logging.ForRequest(notification.RequestID)
+	if d.counted {
+		p.refund(notification)
+	}
+	if len(d.warmedUp) > 0 {
+		if err := p.warmUp.Release(context.WithoutCancel(p.ctx), tenantOf(notification.Metadata), d.warmedUp); err != nil {
+			logger.Printf("Failed to release warm-up counts of notification %s: %v", notification.ID, err)
+		}
+	}
+	if len(d.spaced) > 0 {
+		if err := p.spacer.Release(context.WithoutCancel(p.ctx), notification.UserID, notification.ID, d.spaced); err != nil {
+			logger.Printf("Failed to release spacing of notification %s: %v", notification.ID, err)
+		}
+	}
+}
+
+// refund releases the rate-limit quota of a notification that was not delivered
+func (p *Processor) refund(notification *models.PrioritizedNotification) {
+	// Refunds must still go through while shutting down
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(p.ctx), 2*time.Second)
+	defer cancel()
+
+	logger := logging.ForRequest(notification.RequestID)
+	if err := p.rateLimiter.Refund(ctx, notification); err != nil {
+		logger.Printf("Failed to refund rate limit for notification %s: %v", notification.ID, err)
+		return
+	}
+	logger.Printf("Refunded rate limit for undelivered notification %s", notification.ID)
+}
+
+// determineDeliveryChannels determines which channels to deliver the
+// notification to and, under the preferred_order policy, which to fall back to
 func (p *Processor) determineDeliveryChannels(
-	notification *models.PrioritizedNotification, 
-	userPreferences *preferences.UserPreferences) []string {
-	
+	notification *models.PrioritizedNotification,
+	userPreferences *preferences.UserPreferences) ([]string, []string) {
+
 	var enabledChannels []string
-	
+
 	// Check if event-specific preferences exist
 	if eventPrefs, exists := userPreferences.EventTypes[notification.EventType]; exists {
 		// Use event-specific preferences
@@ -103,20 +709,117 @@ func (p *Processor) determineDeliveryChannels(
 			}
 		}
 	} else {
-		// Fall back to general channel preferences
-		for channel, enabled := range userPreferences.Channels {
-			if enabled {
-				enabledChannels = append(enabledChannels, channel)
+		// Fall back to general channel preferences, as allowed by the event type's category
+		category := p.eventRegistry.CategoryOf(notification.EventType)
+		switch category.UnknownChannelPolicy {
+		case registry.PolicyDefaults:
+			for _, channel := range category.DefaultChannels {
+				if userPreferences.Channels[channel] {
+					enabledChannels = append(enabledChannels, channel)
+				}
+			}
+		case registry.PolicyDeny:
+			logging.ForRequest(notification.RequestID).Printf("No preference for event type %s, default-deny applies", notification.EventType)
+		default:
+			for channel, enabled := range userPreferences.Channels {
+				if enabled {
+					enabledChannels = append(enabledChannels, channel)
+				}
 			}
 		}
 	}
-	
-	// If notification is high priority and no channels are enabled,
-	// force delivery to in-app at minimum
-	if notification.Priority == models.PriorityHigh && len(enabledChannels) == 0 {
-		log.Printf("Forcing in-app channel for high priority notification %s", notification.ID)
+
+	// If the notification is urgent and no channels are enabled, force
+	// delivery to in-app at minimum
+	if p.urgent(notification.Priority) && len(enabledChannels) == 0 {
+		logging.ForRequest(notification.RequestID).Printf("Forcing in-app channel for %s priority notification %s", notification.Priority, notification.ID)
 		enabledChannels = append(enabledChannels, models.ChannelInApp)
 	}
-	
-	return enabledChannels
-}
\ No newline at end of file
+
+	// Narrow the channels down as the event type's delivery policy asks
+	switch p.eventRegistry.DeliveryOf(notification.EventType) {
+	case registry.DeliveryFirstAvailable:
+		ordered := orderChannels(enabledChannels, p.eventRegistry.ChannelOrderOf(notification.EventType))
+		return ordered[:min(len(ordered), 1)], nil
+	case registry.DeliveryPreferredOrder:
+		ordered := orderChannels(enabledChannels, p.eventRegistry.ChannelOrderOf(notification.EventType))
+		if len(ordered) > 1 {
+			return ordered[:1], ordered[1:]
+		}
+		return ordered, nil
+	}
+
+	return enabledChannels, nil
+}
+
+// orderChannels sorts channels by their position in order, channels missing
+// from it come last by name
+func orderChannels(channels []string, order []string) []string {
+	ordered := slices.Clone(channels)
+	slices.SortFunc(ordered, func(a, b string) int {
+		ia, ib := slices.Index(order, a), slices.Index(order, b)
+		switch {
+		case ia == ib:
+			return strings.Compare(a, b)
+		case ia < 0:
+			return 1
+		case ib < 0:
+			return -1
+		}
+		return ia - ib
+	})
+	return ordered
+}
+
+// selectChannels narrows the channels down to the one the user engages with
+// most when the event type's delivery policy lets any one of them do. It
+// also returns how the selection went, empty when there was none to make.
+func (p *Processor) selectChannels(ctx context.Context, notification *models.PrioritizedNotification, channels []string) ([]string, string) {
+	if p.engagement == nil || len(channels) < 2 || p.eventRegistry.DeliveryOf(notification.EventType) != registry.DeliveryAnyOne {
+		return channels, ""
+	}
+
+	logger := logging.ForRequest(notification.RequestID)
+	preferred, explored, err := p.engagement.Preferred(ctx, notification.UserID, channels)
+	if err != nil {
+		logger.Printf("Failed to read engagement of user %s, delivering notification %s to all channels: %v", notification.UserID, notification.ID, err)
+		return channels, "error"
+	}
+	if preferred == "" {
+		return channels, "no_data"
+	}
+	if explored {
+		logger.Printf("Delivering notification %s only to %s, picked at random to learn user %s's engagement", notification.ID, preferred, notification.UserID)
+		return []string{preferred}, "explored"
+	}
+
+	logger.Printf("Delivering notification %s only to %s, the channel user %s engages with most", notification.ID, preferred, notification.UserID)
+	return []string{preferred}, "selected"
+}
+
+// urgent reports whether notifications of the priority are configured as urgent
+func (p *Processor) urgent(priority string) bool {
+	return p.urgentLevels[priority]
+}
+
+// bypassChannels returns every channel the user has set up, whether or not
+// they turned it off, in-app when they have none
+func bypassChannels(userPreferences *preferences.UserPreferences) []string {
+	channels := make([]string, 0, len(userPreferences.Channels))
+	for channel := range userPreferences.Channels {
+		channels = append(channels, channel)
+	}
+	if len(channels) == 0 {
+		channels = append(channels, models.ChannelInApp)
+	}
+	return channels
+}
+
+// collapseKey returns the key push providers use to replace earlier
+// notifications of the same group on the device
+func collapseKey(notification *models.PrioritizedNotification) string {
+	if notification.GroupKey != "" {
+		return notification.GroupKey
+	}
+	return notification.ThreadID
+}
@@ -1,87 +1,91 @@
 package kafka
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/jsonpool"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
 )
 
 // Interface for sending messages to Kafka
 type Producer interface {
-	SendMessage(notification *models.ProcessedNotification) error
+	SendMessage(ctx context.Context, notification *models.ProcessedNotification) error
 	Close() error
 }
 
 // Implements the Producer interface using Sarama
 type KafkaProducer struct {
-	producer sarama.SyncProducer
-	topic    string
+	producer    sarama.SyncProducer
+	topic       string
+	sendTimeout time.Duration // Zero means no timeout
 }
 
 // Creates a new Kafka producer
 func NewProducer(cfg config.KafkaProducerConfig) (Producer, error) {
-	// Configure Sarama
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.RequiredAcks(cfg.RequiredAcks)
-	config.Producer.Retry.Max = cfg.RetryMax
-	config.Producer.Return.Successes = true
-
-	// Create topic manager and ensure topics exist
-	topicManager, err := NewTopicManager(cfg.Brokers)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create topic manager: %w", err)
-	}
-	defer topicManager.Close()
-	
-	// Ensure topic exists
-	if err := topicManager.EnsureTopicExists(cfg); err != nil {
-		return nil, fmt.Errorf("failed to ensure topic exists: %w", err)
-	}
-
-	// Create the producer
-	sarama_producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+	// Ensure the topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), cfg.Topic)
 	if err != nil {
 		return nil, err
 	}
 
 	kafkaProducer := KafkaProducer{
-		producer: sarama_producer,
-		topic:    cfg.Topic,
+		producer:    sarama_producer,
+		topic:       cfg.Topic,
+		sendTimeout: cfg.SendTimeout,
 	}
 
 	return &kafkaProducer, nil
 }
 
 // Sends a processed notification to Kafka
-func (p *KafkaProducer) SendMessage(notification *models.ProcessedNotification) error {
+func (p *KafkaProducer) SendMessage(ctx context.Context, notification *models.ProcessedNotification) error {
 	// Marshal notification to JSON
-	payload, err := json.Marshal(notification)
+	buf, err := jsonpool.Marshal(notification)
 	if err != nil {
 		return fmt.Errorf("failed to marshal notification: %w", err)
 	}
 
 	// Create message
 	msg := &sarama.ProducerMessage{
-		Topic: p.topic,
-		Key:   sarama.StringEncoder(notification.UserID), // Use user ID as key for partitioning
-		Value: sarama.ByteEncoder(payload),
+		Topic:   p.topic,
+		Key:     sarama.StringEncoder(notification.UserID), // Use user ID as key for partitioning
+		Value:   sarama.ByteEncoder(buf.Bytes()),
+		Headers: append(
+			notificationHeaders(notification.RequestID, notification.EventType, notification.Metadata, notification.Priority),
+			append(rateLimitHeaders(notification.RateLimit), deadlineHeaders(notification.Deadline)...)...),
 	}
 
-	// Send message
-	partition, offset, err := p.producer.SendMessage(msg)
+	// Send message, the buffer is reused unless an abandoned send still holds it
+	partition, offset, err := p.send(ctx, msg)
+	if !abandoned(err) {
+		buf.Release()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
-	log.Printf("Processed notification sent to topic %s, partition %d at offset %d", 
+	logging.ForRequest(notification.RequestID).Printf("Processed notification sent to topic %s, partition %d at offset %d", 
 		p.topic, partition, offset)
 	return nil
 }
 
+// Sends a message, bounded by the context and the send timeout
+func (p *KafkaProducer) send(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	return kafkaproducer.Send(ctx, p.producer, msg, p.sendTimeout)
+}
+
+// Reports whether a send was abandoned, it may still complete in the background
+func abandoned(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 // Closes the Kafka producer
 func (p *KafkaProducer) Close() error {
 	return p.producer.Close()
@@ -0,0 +1,71 @@
+package kafka
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// discardProducer encodes every message as sarama would and keeps nothing
+type discardProducer struct {
+	sarama.SyncProducer
+}
+
+func (p discardProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if _, err := msg.Value.Encode(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, nil
+}
+
+func TestSendMessage(t *testing.T) {
+	fake := &fakeProducer{}
+	producer := &KafkaProducer{producer: fake, topic: "notifications.delivery"}
+
+	notification := &models.ProcessedNotification{}
+	notification.ID = "n-1"
+	notification.UserID = "user-1"
+	notification.EventType = "comment"
+	notification.Priority = "high"
+	if err := producer.SendMessage(context.Background(), notification); err != nil {
+		t.Fatalf("sending: %v", err)
+	}
+
+	sent := fake.messages()
+	if len(sent) != 1 || sent[0].Topic != "notifications.delivery" || header(sent[0], PriorityHeader) != "high" {
+		t.Fatalf("sent %+v", sent)
+	}
+	if got := decodeSent(t, sent[0]); got.ID != "n-1" || got.UserID != "user-1" {
+		t.Errorf("sent %+v", got)
+	}
+}
+
+func BenchmarkSendMessage(b *testing.B) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(output)
+
+	producer := &KafkaProducer{producer: discardProducer{}, topic: "notifications.delivery"}
+	notification := &models.ProcessedNotification{}
+	notification.ID = "3f2b9c1e-8d4a-4b7e-9f6a-2c1d0e5f7a8b"
+	notification.UserID = "user-12345"
+	notification.EventType = "comment"
+	notification.Priority = "high"
+	notification.Content = "Alice replied to your post"
+	notification.Metadata = map[string]any{"tenant": "acme", "post_id": 42}
+	notification.Channels = []string{"email", "push"}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		for pb.Next() {
+			if err := producer.SendMessage(ctx, notification); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// RegionProducer wraps a Producer so that notifications of users in a
+// region with a route go to that region's delivery topic, on the region's
+// own brokers when it has them. Notifications of other users go to the
+// shared delivery topic.
+type RegionProducer struct {
+	Producer
+	regions map[string]Producer
+}
+
+// Creates a producer that routes notifications by the user's region
+func NewRegionProducer(producer Producer, regions map[string]Producer) Producer {
+	return &RegionProducer{
+		Producer: producer,
+		regions:  regions,
+	}
+}
+
+// Sends the notification to the delivery topic of the user's region, to the shared one without a route
+func (p *RegionProducer) SendMessage(ctx context.Context, notification *models.ProcessedNotification) error {
+	regional, routed := p.regions[notification.Region]
+	if !routed {
+		return p.Producer.SendMessage(ctx, notification)
+	}
+
+	if err := regional.SendMessage(ctx, notification); err != nil {
+		return err
+	}
+	metrics.RegionDeliveries.WithLabelValues(notification.Region).Inc()
+	return nil
+}
+
+// Closes the shared and every regional producer
+func (p *RegionProducer) Close() error {
+	errs := []error{p.Producer.Close()}
+	for _, regional := range p.regions {
+		errs = append(errs, regional.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,38 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// SandboxProducer sends test notifications to the sandbox topic
+type SandboxProducer struct {
+	Producer
+	sandbox Producer
+}
+
+// Creates a producer that routes test notifications to sandbox
+func NewSandboxProducer(producer Producer, sandbox Producer) Producer {
+	return &SandboxProducer{
+		Producer: producer,
+		sandbox:  sandbox,
+	}
+}
+
+// Sends the notification to the sandbox topic in test mode, to the delivery topic otherwise
+func (p *SandboxProducer) SendMessage(ctx context.Context, notification *models.ProcessedNotification) error {
+	if !notification.Test {
+		return p.Producer.SendMessage(ctx, notification)
+	}
+
+	logging.ForRequest(notification.RequestID).Printf("Routing test notification %s for user %s to the sandbox", notification.ID, notification.UserID)
+	return p.sandbox.SendMessage(ctx, notification)
+}
+
+// Closes both producers
+func (p *SandboxProducer) Close() error {
+	return errors.Join(p.Producer.Close(), p.sandbox.Close())
+}
@@ -0,0 +1,176 @@
+package kafka
+
+import (
+	"context"
+	"flag"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+var schedulerWeights = flag.String("weights", "", "comma separated weights of the levels the scheduler benchmarks run over, most urgent first, e.g. 8,4,2,1")
+
+// schedulerLevels returns the default levels, reweighted by the -weights flag
+func schedulerLevels(b *testing.B) []config.PriorityLevelConfig {
+	levels := append([]config.PriorityLevelConfig(nil), config.DefaultConfig.Priorities...)
+	if *schedulerWeights == "" {
+		return levels
+	}
+
+	values := strings.Split(*schedulerWeights, ",")
+	if len(values) != len(levels) {
+		b.Fatalf("%d weights given for %d levels", len(values), len(levels))
+	}
+	for i, value := range values {
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 1 {
+			b.Fatalf("weight %q must be a positive number", value)
+		}
+		levels[i].Weight = weight
+	}
+	return levels
+}
+
+// laneFeeder supplies a lane with messages stamped with the time they were
+// fed. Messages are reused from a ring larger than the lane's buffer by two,
+// so none is rewritten while the scheduler reads it and feeding doesn't
+// allocate.
+type laneFeeder struct {
+	lane *priorityLane
+	ring []models.PrioritizedNotification
+	pos  int
+}
+
+func newLaneFeeder(lane *priorityLane) *laneFeeder {
+	ring := make([]models.PrioritizedNotification, cap(lane.messages)+2)
+	for i := range ring {
+		ring[i].Priority = lane.priority
+	}
+	return &laneFeeder{lane: lane, ring: ring}
+}
+
+// next returns the next message to feed, stamped now
+func (f *laneFeeder) next() *models.PrioritizedNotification {
+	msg := &f.ring[f.pos]
+	f.pos = (f.pos + 1) % len(f.ring)
+	msg.CreatedAt = time.Now().UnixNano()
+	return msg
+}
+
+// run feeds the lane a message every interval until ctx is done
+func (f *laneFeeder) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case f.lane.messages <- f.next():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// benchmarkScheduler schedules b.N messages from lanes fed every interval of
+// their level, zero keeping a lane full. Full lanes are topped up by the
+// scheduling loop itself, so they stay full however few CPUs there are.
+// Reports the share each lane was served and how long its messages waited.
+func benchmarkScheduler(b *testing.B, interval func(level int) time.Duration) {
+	levels := schedulerLevels(b)
+	c := &KafkaPriorityConsumer{}
+	feeders := make([]*laneFeeder, len(levels))
+	for i, level := range levels {
+		lane := &priorityLane{
+			priority: level.Name,
+			weight:   level.Weight,
+			messages: make(chan *models.PrioritizedNotification, level.Buffer),
+		}
+		c.lanes = append(c.lanes, lane)
+		feeders[i] = newLaneFeeder(lane)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	full := make([]bool, len(levels))
+	for i := range levels {
+		if full[i] = interval(i) == 0; full[i] {
+			for len(c.lanes[i].messages) < cap(c.lanes[i].messages) {
+				c.lanes[i].messages <- feeders[i].next()
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(feeder *laneFeeder, interval time.Duration) {
+			defer wg.Done()
+			feeder.run(ctx, interval)
+		}(feeders[i], interval(i))
+	}
+
+	index := make(map[*priorityLane]int, len(c.lanes))
+	for i, lane := range c.lanes {
+		index[lane] = i
+	}
+	served := make([]int, len(c.lanes))
+	waited := make([]time.Duration, len(c.lanes))
+
+	credits := c.newCredits()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		lane, msg, ok := c.nextMessage(ctx, credits)
+		if !ok {
+			b.Fatal("scheduler stopped")
+		}
+		i := index[lane]
+		served[i]++
+		waited[i] += time.Since(time.Unix(0, msg.CreatedAt))
+
+		// Top up a full lane, there is room for the message just taken
+		if full[i] {
+			lane.messages <- feeders[i].next()
+		}
+	}
+	b.StopTimer()
+
+	for i, lane := range c.lanes {
+		b.ReportMetric(100*float64(served[i])/float64(b.N), lane.priority+"-%")
+		if served[i] > 0 {
+			b.ReportMetric(float64(waited[i].Nanoseconds())/float64(served[i]), lane.priority+"-wait-ns")
+		}
+	}
+}
+
+// Every lane always full, shares follow the weights
+func BenchmarkSchedulerSaturated(b *testing.B) {
+	benchmarkScheduler(b, func(int) time.Duration { return 0 })
+}
+
+// The two most urgent lanes always full, the others fed every 100µs
+func BenchmarkSchedulerStarvation(b *testing.B) {
+	benchmarkScheduler(b, func(level int) time.Duration {
+		if level < 2 {
+			return 0
+		}
+		return 100 * time.Microsecond
+	})
+}
+
+// Every lane fed every 50µs, the scheduler mostly waits
+func BenchmarkSchedulerSparse(b *testing.B) {
+	benchmarkScheduler(b, func(int) time.Duration { return 50 * time.Microsecond })
+}
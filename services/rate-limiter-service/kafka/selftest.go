@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// SelfTestHeader marks the probes of the self-test on the ops topic, which
+// the heartbeat monitor skips
+const SelfTestHeader = "X-Self-Test"
+
+// SelfTestProbe produces probe messages on the ops topic, checking the
+// brokers accept writes from this instance
+type SelfTestProbe struct {
+	producer   *KafkaProducer
+	topic      string
+	instanceID string
+}
+
+// NewSelfTestProbe creates a probe producer, ensuring the ops topic exists
+func NewSelfTestProbe(cfg config.KafkaProducerConfig, heartbeat config.HeartbeatConfig) (*SelfTestProbe, error) {
+	// Ensure the ops topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), heartbeat.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SelfTestProbe{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			sendTimeout: cfg.SendTimeout,
+		},
+		topic:      heartbeat.Topic,
+		instanceID: heartbeat.InstanceID,
+	}, nil
+}
+
+// Produce sends a probe and waits until the brokers acknowledge it
+func (p *SelfTestProbe) Produce(ctx context.Context) error {
+	payload, err := json.Marshal(map[string]any{
+		"instance_id": p.instanceID,
+		"sent_at":     time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal probe: %w", err)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   p.topic,
+		Key:     sarama.StringEncoder(p.instanceID),
+		Value:   sarama.ByteEncoder(payload),
+		Headers: []sarama.RecordHeader{{Key: []byte(SelfTestHeader), Value: []byte("1")}},
+	}
+	if _, _, err := p.producer.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to produce probe: %w", err)
+	}
+	return nil
+}
+
+// Closes the probe producer
+func (p *SelfTestProbe) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,37 @@
+package kafka
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// rejects reports whether a message must be dropped for its signature.
+// Rejected messages aren't dead-lettered, the dead-letter producer would sign
+// them and a requeue pass them on as genuine.
+func rejects(verifier *signing.Verifier, message *sarama.ConsumerMessage) bool {
+	return rejectsDue(verifier, message, time.Time{})
+}
+
+// rejectsDue is rejects for a message held back until it is due
+func rejectsDue(verifier *signing.Verifier, message *sarama.ConsumerMessage, due time.Time) bool {
+	unsigned, err := verifier.VerifyDue(message, due)
+	if unsigned {
+		metrics.UnsignedMessages.WithLabelValues(message.Topic).Inc()
+	}
+	if err != nil {
+		if errors.Is(err, signing.ErrStale) {
+			metrics.StaleMessages.WithLabelValues(message.Topic).Inc()
+		} else {
+			metrics.SignatureRejections.WithLabelValues(message.Topic).Inc()
+		}
+		log.Printf("Rejecting message from topic %s, partition %d, offset %d: %v",
+			message.Topic, message.Partition, message.Offset, err)
+		return true
+	}
+	return false
+}
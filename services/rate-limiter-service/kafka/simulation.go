@@ -0,0 +1,68 @@
+package kafka
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/blocklist"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/preferences"
+)
+
+// Simulation is the decision the processor would make for a notification
+// right now, along with what it was based on
+type Simulation struct {
+	Outcome          string                       `json:"outcome"` // Outcome the notification would be settled with
+	RateLimit        models.RateLimitResult       `json:"rate_limit"`
+	Preferences      *preferences.UserPreferences `json:"preferences"`
+	Mandatory        bool                         `json:"mandatory"`                   // The event type ignores global opt-outs
+	Channels         []string                     `json:"channels"`                    // Channels preferences resolve to, even when the outcome isn't delivered
+	FallbackChannels []string                     `json:"fallback_channels,omitempty"` // Channels tried while delivery fails, under the preferred_order policy
+	InvalidContacts  map[string]string            `json:"invalid_contacts,omitempty"`  // Why the contact of each channel dropped for it was refused
+	Blocklisted      []blocklist.Entry            `json:"blocklisted,omitempty"`       // Suppression list entries of the user or the contacts of dropped channels
+}
+
+// Simulate decides a notification like ProcessMessage does, without
+// counting it against the user's quota or producing anything
+func (p *Processor) Simulate(ctx context.Context, notification *models.PrioritizedNotification) (*Simulation, error) {
+	d, err := p.decide(ctx, notification, true)
+	if err != nil {
+		return nil, err
+	}
+
+	simulation := &Simulation{
+		Outcome:          d.outcome,
+		RateLimit:        d.quota,
+		Preferences:      d.preferences,
+		Mandatory:        d.mandatory,
+		Channels:         d.channels,
+		FallbackChannels: d.fallback,
+	}
+	// Suppression rules run before the pipeline, for every notification but bypassed ones
+	if len(p.rules) > 0 && !d.bypassed {
+		rule, _, err := p.rules.Check(ctx, notification)
+		if err != nil {
+			return nil, err
+		}
+		if rule != "" {
+			simulation.Outcome = OutcomeSuppressed
+		}
+	}
+	if simulation.Outcome == "" {
+		simulation.Outcome = OutcomeDelivered
+	}
+	for channel, err := range d.invalid {
+		if simulation.InvalidContacts == nil {
+			simulation.InvalidContacts = make(map[string]string, len(d.invalid))
+		}
+		simulation.InvalidContacts[channel] = err.Error()
+	}
+	if d.userEntry != nil {
+		simulation.Blocklisted = append(simulation.Blocklisted, *d.userEntry)
+	}
+	for _, channel := range slices.Sorted(maps.Keys(d.blocked)) {
+		simulation.Blocklisted = append(simulation.Blocklisted, d.blocked[channel])
+	}
+	return simulation, nil
+}
@@ -0,0 +1,335 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// Returned by push while the spill file has no room for the notification
+var errSpillFull = errors.New("spill file full")
+
+// spillQueue is an on-disk FIFO of notifications that didn't fit into a
+// lane's buffer. Their offsets are already committed, so the file is their
+// only copy and survives restarts. The file starts with the offset of the
+// oldest record not yet handed to the lane, followed by length-prefixed JSON
+// records.
+type spillQueue struct {
+	priority    string
+	path        string
+	passthrough int // Metadata values longer than this stay undecoded
+	maxBytes    int // Largest size of the file, zero for no limit
+
+	mu      sync.Mutex
+	file    *os.File
+	readAt  int64 // Offset of the oldest record not yet handed to the lane
+	writeAt int64 // Offset the next record is appended at
+	pending int
+	written chan struct{} // Signalled when a record is appended
+	freed   chan struct{} // Signalled when room was made in the file
+}
+
+// Size of the length prefix of a record
+const spillHeaderSize = 4
+
+// Size of the read offset at the start of the file
+const spillCursorSize = 8
+
+// Consumed bytes at the start of the file worth rewriting it for
+const spillCompactBytes = 1 << 20
+
+// newSpillQueue opens the lane's spill file in dir, picking up the records
+// a previous run left unread
+func newSpillQueue(dir, priority string, passthrough, maxBytes int) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+
+	path := filepath.Join(dir, priority+".spill")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+
+	q := &spillQueue{
+		priority:    priority,
+		path:        path,
+		passthrough: passthrough,
+		maxBytes:    maxBytes,
+		file:        file,
+		readAt:      spillCursorSize,
+		written:     make(chan struct{}, 1),
+		freed:       make(chan struct{}, 1),
+	}
+
+	// Resume after the last record handed to the lane, from the start when
+	// the file is new or its cursor is unreadable
+	cursor := make([]byte, spillCursorSize)
+	if _, err := file.ReadAt(cursor, 0); err == nil {
+		if readAt := int64(binary.BigEndian.Uint64(cursor)); readAt > spillCursorSize {
+			q.readAt = readAt
+		}
+	}
+
+	// Count the complete records, dropping a record cut short by a crash
+	q.writeAt = q.readAt
+	for {
+		size, ok := q.recordAt(q.writeAt)
+		if !ok {
+			break
+		}
+		q.writeAt += spillHeaderSize + size
+		q.pending++
+	}
+	if q.pending == 0 {
+		q.readAt, q.writeAt = spillCursorSize, spillCursorSize
+	}
+	if err := file.Truncate(q.writeAt); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to truncate spill file: %w", err)
+	}
+	if err := q.writeCursor(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	metrics.ConsumerSpilled.WithLabelValues(priority).Set(float64(q.pending))
+	return q, nil
+}
+
+// push appends a notification and syncs it to disk, returning errSpillFull
+// while the file has no room for it
+func (q *spillQueue) push(notification *models.PrioritizedNotification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	record := make([]byte, spillHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	copy(record[spillHeaderSize:], payload)
+	if q.maxBytes > 0 && spillCursorSize+len(record) > q.maxBytes {
+		return fmt.Errorf("notification of %d bytes doesn't fit into a spill file of %d bytes", len(record), q.maxBytes)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.maxBytes > 0 && q.writeAt+int64(len(record)) > int64(q.maxBytes) {
+		return errSpillFull
+	}
+	if _, err := q.file.WriteAt(record, q.writeAt); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	// The file holds the only copy once the offset is committed
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spill file: %w", err)
+	}
+	q.writeAt += int64(len(record))
+	q.pending++
+	metrics.ConsumerSpilled.WithLabelValues(q.priority).Set(float64(q.pending))
+
+	select {
+	case q.written <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pushWhenRoom pushes a notification, waiting for the lane to read enough
+// back while the file is full
+func (q *spillQueue) pushWhenRoom(ctx context.Context, notification *models.PrioritizedNotification) error {
+	for {
+		err := q.push(notification)
+		if !errors.Is(err, errSpillFull) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-q.freed:
+		}
+	}
+}
+
+// len returns the number of notifications waiting on disk
+func (q *spillQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending
+}
+
+// drain feeds spilled notifications to the lane in order until the context is
+// done. A notification is only removed from the file once the lane took it.
+func (q *spillQueue) drain(ctx context.Context, messages chan<- *models.PrioritizedNotification) {
+	for {
+		notification, next, ok := q.peek()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.written:
+				continue
+			}
+		}
+
+		if notification != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case messages <- notification:
+			}
+		}
+		q.advance(next)
+	}
+}
+
+// peek returns the oldest notification and the offset after it. A record that
+// can't be decoded is skipped by returning no notification.
+func (q *spillQueue) peek() (*models.PrioritizedNotification, int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.readAt >= q.writeAt {
+		return nil, 0, false
+	}
+	size, ok := q.recordAt(q.readAt)
+	if !ok {
+		return nil, 0, false
+	}
+	next := q.readAt + spillHeaderSize + size
+
+	payload := make([]byte, size)
+	if _, err := q.file.ReadAt(payload, q.readAt+spillHeaderSize); err != nil {
+		return nil, 0, false
+	}
+
+	var notification models.PrioritizedNotification
+	if err := models.UnmarshalPrioritized(payload, &notification, q.passthrough); err != nil {
+		return nil, next, true
+	}
+	return &notification, next, true
+}
+
+// advance removes the records before next and records the new cursor in the
+// file, so a restart doesn't hand them to the lane again. The file is emptied
+// once all records are consumed, and rewritten without the consumed ones once
+// they make up half of it. The cursor isn't synced, a power loss may hand a
+// few notifications to the lane twice.
+func (q *spillQueue) advance(next int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.readAt = next
+	q.pending--
+	metrics.ConsumerSpilled.WithLabelValues(q.priority).Set(float64(q.pending))
+
+	freed := false
+	consumed := q.readAt - spillCursorSize
+	switch {
+	case q.readAt >= q.writeAt:
+		// Emptied before the cursor is reset, so a crash in between never replays records
+		if err := q.file.Truncate(spillCursorSize); err != nil {
+			log.Printf("Failed to empty %s priority spill file: %v", q.priority, err)
+			break
+		}
+		q.readAt, q.writeAt, q.pending = spillCursorSize, spillCursorSize, 0
+		freed = true
+	case consumed >= spillCompactBytes && consumed >= q.writeAt-q.readAt:
+		if err := q.compact(); err != nil {
+			log.Printf("Failed to compact %s priority spill file: %v", q.priority, err)
+			break
+		}
+		freed = true
+	}
+
+	if err := q.writeCursor(); err != nil {
+		log.Printf("Failed to record %s priority spill cursor: %v", q.priority, err)
+	}
+	if freed {
+		select {
+		case q.freed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// compact replaces the file with a copy holding only the unread records.
+// The copy is synced before it is renamed over the file, so a crash leaves
+// either file complete.
+func (q *spillQueue) compact() error {
+	tmpPath := q.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	cursor := make([]byte, spillCursorSize)
+	binary.BigEndian.PutUint64(cursor, spillCursorSize)
+	unread := io.NewSectionReader(q.file, q.readAt, q.writeAt-q.readAt)
+	if _, err := tmp.Write(cursor); err == nil {
+		_, err = io.Copy(tmp, unread)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, q.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	q.file.Close()
+	q.file = tmp
+	q.writeAt -= q.readAt - spillCursorSize
+	q.readAt = spillCursorSize
+	return nil
+}
+
+// writeCursor records the offset of the oldest unread record at the start of the file
+func (q *spillQueue) writeCursor() error {
+	cursor := make([]byte, spillCursorSize)
+	binary.BigEndian.PutUint64(cursor, uint64(q.readAt))
+	if _, err := q.file.WriteAt(cursor, 0); err != nil {
+		return fmt.Errorf("failed to write spill cursor: %w", err)
+	}
+	return nil
+}
+
+// recordAt returns the payload size of the complete record at offset, if there is one
+func (q *spillQueue) recordAt(offset int64) (int64, bool) {
+	header := make([]byte, spillHeaderSize)
+	if _, err := q.file.ReadAt(header, offset); err != nil {
+		return 0, false
+	}
+	size := int64(binary.BigEndian.Uint32(header))
+
+	// The payload must be complete, its last byte readable
+	if size > 0 {
+		if _, err := q.file.ReadAt(make([]byte, 1), offset+spillHeaderSize+size-1); err != nil {
+			return 0, false
+		}
+	}
+	return size, true
+}
+
+// Close closes the spill file, keeping its unread records for the next run
+func (q *spillQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.file.Close()
+}
@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// takeSpilled hands n spilled notifications to a lane and returns their IDs
+func takeSpilled(t *testing.T, q *spillQueue, n int) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := make(chan *models.PrioritizedNotification)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.drain(ctx, messages)
+	}()
+
+	var ids []string
+	for len(ids) < n {
+		select {
+		case notification := <-messages:
+			ids = append(ids, notification.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %v from the spill file, want %d notifications", ids, n)
+		}
+	}
+	cancel()
+	<-done
+	return ids
+}
+
+func pushSpilled(t *testing.T, q *spillQueue, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := q.push(testNotification(fmt.Sprintf("n-%d", i))); err != nil {
+			t.Fatalf("pushing n-%d: %v", i, err)
+		}
+	}
+}
+
+func TestSpillQueueResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newSpillQueue(dir, "low", 0, 0)
+	if err != nil {
+		t.Fatalf("opening: %v", err)
+	}
+	pushSpilled(t, q, 0, 5)
+	if got := fmt.Sprint(takeSpilled(t, q, 2)); got != "[n-0 n-1]" {
+		t.Fatalf("took %s, want [n-0 n-1]", got)
+	}
+	q.Close()
+
+	// A crash while appending leaves a record cut short
+	path := filepath.Join(dir, "low.spill")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 1, 0, '{'})
+	file.Close()
+
+	q, err = newSpillQueue(dir, "low", 0, 0)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer q.Close()
+	if q.len() != 3 {
+		t.Fatalf("%d notifications pending after a restart, want 3", q.len())
+	}
+	if got := fmt.Sprint(takeSpilled(t, q, 3)); got != "[n-2 n-3 n-4]" {
+		t.Errorf("took %s after a restart, want the notifications not taken before", got)
+	}
+	if info, _ := os.Stat(path); info.Size() != spillCursorSize {
+		t.Errorf("spill file of %d bytes once emptied", info.Size())
+	}
+}
+
+func TestSpillQueueWaitsWhileFull(t *testing.T) {
+	q, err := newSpillQueue(t.TempDir(), "low", 0, 600)
+	if err != nil {
+		t.Fatalf("opening: %v", err)
+	}
+	defer q.Close()
+
+	pushed := 0
+	for ; ; pushed++ {
+		err := q.push(testNotification(fmt.Sprintf("n-%d", pushed)))
+		if errors.Is(err, errSpillFull) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("pushing: %v", err)
+		}
+	}
+	if pushed == 0 {
+		t.Fatal("nothing fits into the spill file")
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- q.pushWhenRoom(context.Background(), testNotification(fmt.Sprintf("n-%d", pushed)))
+	}()
+	select {
+	case err := <-waited:
+		t.Fatalf("pushed into a full spill file: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The file empties once the lane took everything, making room
+	takeSpilled(t, q, pushed)
+	if err := <-waited; err != nil {
+		t.Fatalf("pushing once there is room: %v", err)
+	}
+	if got := takeSpilled(t, q, 1); got[0] != fmt.Sprintf("n-%d", pushed) {
+		t.Errorf("took %v, want the notification that waited", got)
+	}
+
+	huge := testNotification("huge")
+	huge.Content = strings.Repeat("a", 1000)
+	if err := q.push(huge); err == nil || errors.Is(err, errSpillFull) {
+		t.Errorf("pushing a notification larger than the file = %v, want an error other than full", err)
+	}
+}
+
+func TestSpillQueueCompacts(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newSpillQueue(dir, "low", 0, 0)
+	if err != nil {
+		t.Fatalf("opening: %v", err)
+	}
+
+	notification := testNotification("")
+	notification.Content = strings.Repeat("a", 1024)
+	total := 3 * spillCompactBytes / 1024
+	for i := 0; i < total; i++ {
+		notification.ID = fmt.Sprintf("n-%d", i)
+		if err := q.push(notification); err != nil {
+			t.Fatalf("pushing: %v", err)
+		}
+	}
+	info, _ := os.Stat(filepath.Join(dir, "low.spill"))
+	full := info.Size()
+
+	// Compacted once the records taken make up half of the file
+	taken := takeSpilled(t, q, total/2+1)
+	info, _ = os.Stat(filepath.Join(dir, "low.spill"))
+	if info.Size() >= full/2+spillCursorSize {
+		t.Errorf("spill file of %d bytes after taking half of %d bytes, want it compacted", info.Size(), full)
+	}
+	q.Close()
+
+	q, err = newSpillQueue(dir, "low", 0, 0)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer q.Close()
+	rest := takeSpilled(t, q, total-len(taken))
+	if rest[0] != fmt.Sprintf("n-%d", len(taken)) || rest[len(rest)-1] != fmt.Sprintf("n-%d", total-1) {
+		t.Errorf("took %s to %s after compacting, want n-%d to n-%d", rest[0], rest[len(rest)-1], len(taken), total-1)
+	}
+	if q.len() != 0 {
+		t.Errorf("%d notifications left", q.len())
+	}
+}
@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// StatusConsumer reads the status events of delivered notifications. Each
+// event only needs handling once, so the instances share a consumer group.
+type StatusConsumer struct {
+	consumerGroup sarama.ConsumerGroup
+	topic         string
+	onStatus      func(ctx context.Context, event *models.StatusEvent) error
+}
+
+// NewStatusConsumer creates a consumer for the status topic
+func NewStatusConsumer(brokers []string, topic, groupID string) (*StatusConsumer, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+
+	consumerGroup, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	return &StatusConsumer{
+		consumerGroup: consumerGroup,
+		topic:         topic,
+	}, nil
+}
+
+// Start consumes status events and calls onStatus for each, until the
+// context is done. Events onStatus fails on are logged and skipped.
+func (c *StatusConsumer) Start(ctx context.Context, onStatus func(ctx context.Context, event *models.StatusEvent) error) error {
+	c.onStatus = onStatus
+
+	log.Printf("Consuming status events on %s", c.topic)
+	for {
+		if err := c.consumerGroup.Consume(ctx, []string{c.topic}, c); err != nil {
+			log.Printf("Error consuming status events: %v", err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Setup is run at the beginning of a new session
+func (c *StatusConsumer) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session
+func (c *StatusConsumer) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim handles the status events of a partition
+func (c *StatusConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for message := range claim.Messages() {
+		var event models.StatusEvent
+		if err := json.Unmarshal(message.Value, &event); err != nil {
+			log.Printf("Error unmarshalling status event: %v", err)
+		} else if err := c.onStatus(session.Context(), &event); err != nil {
+			log.Printf("Failed to handle %s status of notification %s: %v", event.Status, event.NotificationID, err)
+		}
+		session.MarkMessage(message, "")
+	}
+	return nil
+}
+
+// Close releases resources
+func (c *StatusConsumer) Close() error {
+	return c.consumerGroup.Close()
+}
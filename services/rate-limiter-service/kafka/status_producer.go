@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Interface for publishing status events of notifications the rate limiter
+// settled without delivering them
+type StatusProducer interface {
+	PublishStatus(ctx context.Context, event *models.StatusEvent) error
+	Close() error
+}
+
+// Implements the StatusProducer interface using Sarama
+type KafkaStatusProducer struct {
+	producer *KafkaProducer
+}
+
+// Creates a producer for the status topic, with the settings of the delivery producer
+func NewStatusProducer(cfg config.KafkaProducerConfig, topic string) (StatusProducer, error) {
+	// Ensure the status topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaStatusProducer{
+		producer: &KafkaProducer{
+			producer:    sarama_producer,
+			topic:       topic,
+			sendTimeout: cfg.SendTimeout,
+		},
+	}, nil
+}
+
+// Publishes a status event
+func (p *KafkaStatusProducer) PublishStatus(ctx context.Context, event *models.StatusEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status event: %w", err)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: p.producer.topic,
+		Key:   sarama.StringEncoder(event.NotificationID), // Keep a notification's status events in order
+		Value: sarama.ByteEncoder(payload),
+	}
+
+	partition, offset, err := p.producer.send(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("failed to send status event: %w", err)
+	}
+
+	logging.ForRequest(event.RequestID).Printf("Status %s of notification %s sent to partition %d at offset %d",
+		event.Status, event.NotificationID, partition, offset)
+	return nil
+}
+
+// Closes the Kafka producer
+func (p *KafkaStatusProducer) Close() error {
+	return p.producer.Close()
+}
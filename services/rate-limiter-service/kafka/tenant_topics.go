@@ -0,0 +1,28 @@
+package kafka
+
+import "strings"
+
+// Returns the tenant's copy of a priority topic as created by the prioritizer.
+// Characters Kafka doesn't allow in topic names are replaced by underscores.
+func TenantTopic(topic, tenant string) string {
+	return topic + "." + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, tenant)
+}
+
+// Returns the topics a lane consumes: the tenants' copies of its priority
+// topic, or the shared topic itself when no tenants are given
+func LaneTopics(topic string, tenants []string) []string {
+	if len(tenants) == 0 {
+		return []string{topic}
+	}
+
+	topics := make([]string, len(tenants))
+	for i, tenant := range tenants {
+		topics[i] = TenantTopic(topic, tenant)
+	}
+	return topics
+}
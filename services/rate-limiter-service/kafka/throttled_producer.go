@@ -0,0 +1,42 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/ratelimiter"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// ThrottledProducer wraps a Producer so that the cluster as a whole never
+// sends more notifications to delivery than the global limit allows
+type ThrottledProducer struct {
+	Producer
+	limiter *ratelimiter.GlobalLimiter
+}
+
+// Creates a producer that waits for the global limiter before every send
+func NewThrottledProducer(producer Producer, limiter *ratelimiter.GlobalLimiter) Producer {
+	return &ThrottledProducer{
+		Producer: producer,
+		limiter:  limiter,
+	}
+}
+
+// Sends the notification once the global limit leaves room for it
+func (p *ThrottledProducer) SendMessage(ctx context.Context, notification *models.ProcessedNotification) error {
+	if err := p.limiter.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		// An unavailable limiter must not stop delivery
+		logging.ForRequest(notification.RequestID).Printf("Global limiter unavailable, sending notification %s unthrottled: %v", notification.ID, err)
+	}
+	return p.Producer.SendMessage(ctx, notification)
+}
+
+// Closes the producer and the limiter
+func (p *ThrottledProducer) Close() error {
+	return errors.Join(p.Producer.Close(), p.limiter.Close())
+}
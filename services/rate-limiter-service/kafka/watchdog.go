@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+)
+
+// markProgress records that the lane moved on, its session started or the processor took one of its messages
+func (lane *priorityLane) markProgress() {
+	lane.progress.Store(time.Now().UnixNano())
+}
+
+// stalledFor returns how long the lane hasn't moved on
+func (lane *priorityLane) stalledFor() time.Duration {
+	return time.Since(time.Unix(0, lane.progress.Load()))
+}
+
+// setRestart stores the function ending the lane's current session
+func (lane *priorityLane) setRestart(restart context.CancelFunc) {
+	lane.restartMu.Lock()
+	defer lane.restartMu.Unlock()
+	lane.restart = restart
+}
+
+// restartSession ends the lane's current session, the lane's consume loop joins the group again
+func (lane *priorityLane) restartSession() {
+	lane.restartMu.Lock()
+	defer lane.restartMu.Unlock()
+	if lane.restart != nil {
+		lane.restart()
+	}
+}
+
+// watchStalls restarts the session of every lane that claims partitions and
+// lags, but had none of its messages processed within the stall timeout:
+// a claim stuck on a send or a fetcher that died. Paused lanes are left
+// alone, they aren't meant to move on.
+func (c *KafkaPriorityConsumer) watchStalls(ctx context.Context, cfg config.WatchdogConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	stalled := make(map[string]bool, len(c.lanes))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, lane := range c.lanes {
+			lag := lane.lagOf()
+			idle := lane.stalledFor()
+			if lane.claims.Load() == 0 || lag == 0 || lane.paused.Load() || idle < cfg.StallTimeout {
+				if stalled[lane.priority] && idle < cfg.StallTimeout {
+					log.Printf("%s priority lane is moving again", lane.priority)
+					stalled[lane.priority] = false
+					metrics.ConsumerStalled.WithLabelValues(lane.priority).Set(0)
+				}
+				continue
+			}
+
+			log.Printf("%s priority lane processed nothing for %v while %d messages behind, restarting its session",
+				lane.priority, idle.Round(time.Second), lag)
+			stalled[lane.priority] = true
+			metrics.ConsumerStalled.WithLabelValues(lane.priority).Set(1)
+			metrics.ConsumerStallRestarts.WithLabelValues(lane.priority).Inc()
+
+			// Give the new session a full timeout before judging it
+			lane.markProgress()
+			lane.restartSession()
+		}
+	}
+}
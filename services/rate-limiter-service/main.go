@@ -5,14 +5,22 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/overview"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/preferences"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/ratelimiter"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/startup"
 )
 
 func main() {
-	log.Println("Starting Rate Limiter Service...")
+	log.Printf("Starting Rate Limiter Service %s", buildinfo.Get())
 
 	// Load configuration
 	cfg, err := config.Load()
@@ -20,75 +28,184 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Create a context that will be canceled on interrupt
+	// Create a context that will be canceled once the consumer drained
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Initialize rate limiter
-	rateLimiter, err := cfg.CreateRateLimiter()
-	if err != nil {
-		log.Fatalf("Failed to create rate limiter: %v", err)
+	// Fetch the referenced secrets again, so rotated ones reach new connections
+	if cfg.Secrets.RefreshInterval > 0 {
+		go cfg.SecretStore().Run(ctx, cfg.Secrets.RefreshInterval)
 	}
-	defer rateLimiter.Close()
-	log.Println("Rate limiter initialized")
 
-	// Initialize preferences service
-	preferencesService, err := cfg.CreatePreferencesService()
-	if err != nil {
-		log.Fatalf("Failed to create preferences service: %v", err)
+	// Dependencies may still be starting, wait for them with backoff
+	s := &service{
+		cfg: cfg,
+		retry: startup.Config{
+			Timeout:        cfg.Startup.RetryTimeout,
+			InitialBackoff: cfg.Startup.RetryBackoff,
+			MaxBackoff:     cfg.Startup.RetryMaxBackoff,
+		},
 	}
-	defer preferencesService.Close()
-	log.Println("Preferences service initialized")
 
-	// Initialize Kafka producer
-	producer, err := kafka.NewProducer(cfg.KafkaProducer)
-	if err != nil {
-		log.Fatalf("Failed to create Kafka producer: %v", err)
+	// Run on the first reachable Kafka cluster, the standbys take over when it is lost
+	clusters := cfg.KafkaFailover.BrokerLists(cfg.KafkaConsumer.Brokers)
+	active := 0
+	if len(clusters) > 1 {
+		if active, err = kafkaadmin.SelectCluster(clusters, cfg.KafkaFailover.CheckInterval); err != nil {
+			log.Printf("%v, waiting for %v", err, clusters[0])
+			active = 0
+		}
+		cfg.KafkaConsumer.Brokers = clusters[active]
+		cfg.KafkaProducer.Brokers = clusters[active]
+		log.Printf("Running on Kafka cluster %v", clusters[active])
 	}
-	defer producer.Close()
-	log.Println("Kafka producer initialized")
-
-	// Create the processor
-	processor := kafka.NewProcessor(ctx, rateLimiter, preferencesService, producer)
 
-	// Initialize Kafka consumer
-	consumer, err := kafka.NewPriorityConsumer(cfg.KafkaConsumer)
-	if err != nil {
-		log.Fatalf("Failed to create Kafka consumer: %v", err)
+	// Check every dependency up front, so a misconfiguration fails with what to fix
+	if cfg.Startup.Preflight {
+		if err := startup.Preflight(ctx, s.retry, preflightChecks(cfg)...); err != nil {
+			log.Fatal(err)
+		}
 	}
-	defer consumer.Close()
-	log.Println("Kafka priority consumer initialized")
+
+	s.setUpRateLimiting(ctx)
+	s.setUpUserData(ctx)
+	producer, decisions := s.setUpDelivery(ctx)
+	s.setUpProcessor(ctx, producer, decisions)
+	s.countEngagement(ctx)
+	s.setUpConsumer(ctx, active)
+	s.setUpMonitoring(ctx)
 
 	// Setup signal handling
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		sig := <-sigCh
-		log.Printf("Received signal: %v, initiating shutdown", sig)
-		cancel()
-	}()
+	// Fail over once the active Kafka cluster is lost, by shutting down for a
+	// restart that selects the next reachable one
+	var failedOver atomic.Bool
+	if len(clusters) > 1 {
+		s.watch = kafkaadmin.WatchCluster(clusters, active, cfg.KafkaFailover.CheckInterval, cfg.KafkaFailover.After, func(int) {
+			failedOver.Store(true)
+			select {
+			case sigCh <- syscall.SIGTERM:
+			default:
+			}
+		})
+	}
+
+	s.setUpAudit(ctx)
+	s.startAdmin(ctx)
 
 	// Start the consumer
 	log.Println("Starting Kafka priority consumer...")
 	go func() {
-		if err := consumer.Start(ctx, processor.ProcessMessage); err != nil {
+		if err := s.consumer.Start(ctx, s.processor.ProcessMessage); err != nil {
 			log.Fatal(err)
 		}
 	}()
 
 	log.Println("Rate Limiter Service started successfully")
 
-	// Wait for context cancellation
-	<-ctx.Done()
-	log.Println("Context canceled, shutting down...")
+	// Wait for termination signal
+	sig := <-sigCh
+	log.Printf("Received signal: %v, initiating shutdown", sig)
 
-	// Create a new context with timeout for graceful shutdown
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
-	defer shutdownCancel()
+	s.shutDown(cancel)
 
-	// Wait for shutdown timeout
-	<-shutdownCtx.Done()
-	
 	log.Println("Rate Limiter Service shut down")
-}
\ No newline at end of file
+	if failedOver.Load() {
+		log.Println("Exiting to restart on a standby Kafka cluster")
+		os.Exit(1)
+	}
+}
+
+// overviewStages lists the consumer groups of the pipeline in the order
+// notifications pass them: the prioritizer, one rate-limiter lane per
+// priority and, when configured, the delivery consumer
+func overviewStages(cfg *config.Config) []overview.Stage {
+	stages := []overview.Stage{{
+		Name:    "prioritizer",
+		GroupID: cfg.Overview.PrioritizerGroupID,
+		Topics:  []string{cfg.Overview.RawTopic},
+	}}
+	for _, level := range cfg.Priorities {
+		stages = append(stages, overview.Stage{
+			Name:    "rate-limiter:" + level.Name,
+			GroupID: kafka.LaneGroupID(cfg.KafkaConsumer.GroupID, level.Name),
+			Topics:  kafka.LaneTopics(level.Topic, cfg.KafkaConsumer.Tenants),
+		})
+	}
+	if cfg.Overview.DeliveryGroupID != "" {
+		stages = append(stages, overview.Stage{
+			Name:    "delivery",
+			GroupID: cfg.Overview.DeliveryGroupID,
+			Topics:  []string{cfg.KafkaProducer.Topic},
+		})
+	}
+	return stages
+}
+
+// preflightChecks lists the dependencies the service can't start without
+func preflightChecks(cfg *config.Config) []startup.Check {
+	topics := []string{cfg.KafkaConsumer.PreferencesTopic}
+	for _, level := range cfg.Priorities {
+		topics = append(topics, kafka.LaneTopics(level.Topic, cfg.KafkaConsumer.Tenants)...)
+	}
+
+	checks := []startup.Check{
+		{
+			Name: "Kafka brokers",
+			Hint: "check KAFKA_CONSUMER_BROKERS and that the brokers are up",
+			Run: func(ctx context.Context) error {
+				return kafkaadmin.CheckTopics(ctx, cfg.KafkaConsumer.Brokers)
+			},
+		},
+		{
+			Name: "Kafka topics",
+			Hint: "the priority topics are created by the prioritizer service and the preferences topic by the preferences service, start them first or check KAFKA_CONSUMER_TOPIC_* and KAFKA_CONSUMER_PREFERENCES_TOPIC; tenant topics exist once the prioritizer lists the tenant in TENANT_TOPICS_TENANTS or saw its first notification",
+			Run: func(ctx context.Context) error {
+				return kafkaadmin.CheckTopics(ctx, cfg.KafkaConsumer.Brokers, topics...)
+			},
+		},
+	}
+	if !slices.Equal(cfg.KafkaProducer.Brokers, cfg.KafkaConsumer.Brokers) {
+		checks = append(checks, startup.Check{
+			Name: "Kafka producer brokers",
+			Hint: "check KAFKA_PRODUCER_BROKERS and that the brokers are up",
+			Run: func(ctx context.Context) error {
+				return kafkaadmin.CheckTopics(ctx, cfg.KafkaProducer.Brokers)
+			},
+		})
+	}
+
+	// Mock mode needs neither Redis nor MySQL
+	if cfg.MockMode {
+		return checks
+	}
+
+	checks = append(checks,
+		startup.Check{
+			Name: "Redis",
+			Hint: "check REDIS_ADDR, REDIS_PASSWORD and REDIS_DB",
+			Run: func(ctx context.Context) error {
+				return ratelimiter.CheckRedis(ctx, cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
+			},
+		},
+		startup.Check{
+			Name: "MySQL",
+			Hint: "check DB_DRIVER and the address and credentials in DB_DSN",
+			Run: func(ctx context.Context) error {
+				return preferences.CheckDatabase(ctx, cfg.Database.Driver, cfg.Database.DSN)
+			},
+		},
+	)
+	if cfg.Database.ReadDSN != "" {
+		checks = append(checks, startup.Check{
+			Name: "MySQL replica",
+			Hint: "check the address and credentials in DB_READ_DSN",
+			Run: func(ctx context.Context) error {
+				return preferences.CheckDatabase(ctx, cfg.Database.Driver, cfg.Database.ReadDSN)
+			},
+		})
+	}
+	return checks
+}
@@ -0,0 +1,332 @@
+package metering
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// Layout of the days usage is rolled up by, in UTC
+const DayLayout = "2006-01-02"
+
+// How long the tokens of applied batches are kept, a batch retried later may be added twice
+const batchRetention = 7 * 24 * time.Hour
+
+// Meter counts notifications per day, API key and tenant for billing. Counts
+// are kept in memory and added to the rollups in the database every flush
+// interval, so the rollups of all instances add up.
+type Meter struct {
+	db            *sql.DB
+	flushInterval time.Duration
+	webhookURL    string
+	client        *http.Client
+	exportDelay   time.Duration
+
+	mu      sync.Mutex
+	pending map[usageKey]*counts
+	retries []batch // Batches that may or may not have been added, retried as they are
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Config for the meter
+type Config struct {
+	Driver         string
+	DSN            string
+	FlushInterval  time.Duration // How often counts are added to the rollups
+	WebhookURL     string        // Optional endpoint every finished day's usage is posted to
+	WebhookTimeout time.Duration
+}
+
+// Usage is the rollup of a day, API key and tenant
+type Usage struct {
+	Day       string `json:"day"`
+	APIKeyID  string `json:"api_key_id"` // Empty for notifications enqueued without an API key
+	Tenant    string `json:"tenant"`     // Empty for notifications without a tenant
+	Accepted  int64  `json:"accepted"`   // Notifications the pipeline settled, rate limited ones included
+	Delivered int64  `json:"delivered"`  // Notifications sent to the delivery topic
+}
+
+// Export is posted to the webhook once a day is over
+type Export struct {
+	Day   string  `json:"day"`
+	Usage []Usage `json:"usage"`
+}
+
+type usageKey struct {
+	day      string
+	apiKeyID string
+	tenant   string
+}
+
+type counts struct {
+	accepted  int64
+	delivered int64
+}
+
+// batch is the counts of a key added to the rollups at once. Its token is
+// recorded with them, so a batch is added once however often it is retried.
+type batch struct {
+	token string
+	key   usageKey
+	counts
+}
+
+// NewMeter connects to the database and starts flushing counts in the background
+func NewMeter(cfg Config) (*Meter, error) {
+	if cfg.FlushInterval <= 0 {
+		return nil, fmt.Errorf("flush interval must be positive")
+	}
+
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	m := &Meter{
+		db:            db,
+		flushInterval: cfg.FlushInterval,
+		webhookURL:    cfg.WebhookURL,
+		client:        &http.Client{Timeout: cfg.WebhookTimeout},
+		// Other instances flush the last counts of a day up to an interval after it ended
+		exportDelay: 2 * cfg.FlushInterval,
+		pending:     make(map[usageKey]*counts),
+		done:        make(chan struct{}),
+	}
+
+	m.wg.Add(1)
+	go m.run()
+
+	return m, nil
+}
+
+// Record counts a settled notification, and whether it was delivered.
+// Sandbox notifications are never billed.
+func (m *Meter) Record(notification *models.PrioritizedNotification, delivered bool) {
+	if notification.Test {
+		return
+	}
+
+	tenant, _ := notification.Metadata[models.MetadataTenant].(string)
+	key := usageKey{
+		day:      time.Now().UTC().Format(DayLayout),
+		apiKeyID: notification.APIKeyID,
+		tenant:   tenant,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, exists := m.pending[key]
+	if !exists {
+		c = &counts{}
+		m.pending[key] = c
+	}
+	c.accepted++
+	if delivered {
+		c.delivered++
+	}
+}
+
+// Usage returns the rollups of the days from and to, both included. Counts
+// of the last flush interval may be missing.
+func (m *Meter) Usage(ctx context.Context, from, to time.Time) ([]Usage, error) {
+	rows, err := m.db.QueryContext(ctx,
+		"SELECT DATE_FORMAT(day, '%Y-%m-%d'), api_key_id, tenant, accepted, delivered FROM usage_rollups WHERE day BETWEEN ? AND ? ORDER BY day, api_key_id, tenant",
+		from.Format(DayLayout), to.Format(DayLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := []Usage{}
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.Day, &u.APIKeyID, &u.Tenant, &u.Accepted, &u.Delivered); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// run flushes the counts every interval and exports finished days
+func (m *Meter) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.flushInterval)
+		if err := m.flush(ctx); err != nil {
+			log.Printf("Failed to flush usage: %v", err)
+		}
+		if m.webhookURL != "" {
+			if err := m.export(ctx, time.Now()); err != nil {
+				log.Printf("Failed to export usage: %v", err)
+			}
+		}
+		cancel()
+	}
+}
+
+// flush adds the pending counts to the rollups. Batches that could not be
+// written, or timed out without knowing whether they were, are retried with
+// the next flush.
+func (m *Meter) flush(ctx context.Context) error {
+	m.mu.Lock()
+	batches := m.retries
+	for key, c := range m.pending {
+		batches = append(batches, batch{token: newToken(), key: key, counts: *c})
+	}
+	m.pending = make(map[usageKey]*counts)
+	m.retries = nil
+	m.mu.Unlock()
+
+	var failed error
+	for _, b := range batches {
+		if err := m.apply(ctx, b); err != nil {
+			failed = err
+			metrics.UsageFlushFailures.Inc()
+			m.mu.Lock()
+			m.retries = append(m.retries, b)
+			m.mu.Unlock()
+		}
+	}
+
+	if failed == nil {
+		if _, err := m.db.ExecContext(ctx, "DELETE FROM usage_batches WHERE applied_at < ?", time.Now().Add(-batchRetention)); err != nil {
+			log.Printf("Failed to prune usage batches: %v", err)
+		}
+	}
+	return failed
+}
+
+// apply adds a batch to the rollups unless its token shows it already was
+func (m *Meter) apply(ctx context.Context, b batch) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "INSERT IGNORE INTO usage_batches (token, applied_at) VALUES (?, ?)", b.token, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record usage batch: %w", err)
+	}
+	if added, err := result.RowsAffected(); err != nil || added == 0 {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO usage_rollups (day, api_key_id, tenant, accepted, delivered) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE accepted = accepted + VALUES(accepted), delivered = delivered + VALUES(delivered)`,
+		b.key.day, b.key.apiKeyID, b.key.tenant, b.accepted, b.delivered); err != nil {
+		return fmt.Errorf("failed to add usage: %w", err)
+	}
+	return tx.Commit()
+}
+
+// newToken returns a random batch token
+func newToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// export posts yesterday's usage to the webhook, once across all instances:
+// the instance that claims the day in usage_exports posts it
+func (m *Meter) export(ctx context.Context, now time.Time) error {
+	today := now.UTC().Truncate(24 * time.Hour)
+	if now.Sub(today) < m.exportDelay {
+		return nil
+	}
+	yesterday := today.AddDate(0, 0, -1)
+
+	result, err := m.db.ExecContext(ctx, "INSERT IGNORE INTO usage_exports (day) VALUES (?)", yesterday.Format(DayLayout))
+	if err != nil {
+		return fmt.Errorf("failed to claim export: %w", err)
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed == 0 {
+		return err
+	}
+
+	err = m.post(ctx, yesterday)
+	if err != nil {
+		// Give up the claim so the export is retried
+		m.db.ExecContext(ctx, "DELETE FROM usage_exports WHERE day = ?", yesterday.Format(DayLayout))
+		return err
+	}
+	log.Printf("Exported usage of %s", yesterday.Format(DayLayout))
+	return nil
+}
+
+// post sends a day's usage to the webhook
+func (m *Meter) post(ctx context.Context, day time.Time) error {
+	usage, err := m.Usage(ctx, day, day)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(Export{Day: day.Format(DayLayout), Usage: usage})
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close stops the background flush and flushes the remaining counts
+func (m *Meter) Close() error {
+	close(m.done)
+	m.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.flush(ctx)
+	m.db.Close()
+	return err
+}
@@ -0,0 +1,328 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Bucket boundaries (in seconds) for end-to-end notification latency
+var latencyBuckets = []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// EndToEndLatency tracks the time from event creation to delivery produce, per priority
+var EndToEndLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "notification_end_to_end_latency_seconds",
+	Help:    "Time from notification creation to being produced to the delivery topic.",
+	Buckets: latencyBuckets,
+}, []string{"priority"})
+
+// SLOViolations counts notifications that exceeded the latency objective of their priority
+var SLOViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_slo_violations_total",
+	Help: "Notifications delivered later than the latency objective of their priority.",
+}, []string{"priority"})
+
+// CanaryDecisions counts canary decisions by whether they matched the primary's
+var CanaryDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_canary_decisions_total",
+	Help: "Decisions of the canary compared with the primary's, by result (match or mismatch).",
+}, []string{"result"})
+
+// SLOWebhookFailures counts SLO violation alerts that could not be delivered
+var SLOWebhookFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "notification_slo_webhook_failures_total",
+	Help: "SLO violation alerts that failed or were dropped before reaching the webhook.",
+})
+
+// SLOBurnRate reports how many times faster than allowed each objective's error budget burns per window
+var SLOBurnRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "notification_slo_burn_rate",
+	Help: "Error budget burn rate of each service level objective over each alert window.",
+}, []string{"slo", "window"})
+
+// SLOBurnAlerts counts burn-rate alerts fired per objective and severity
+var SLOBurnAlerts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_slo_burn_alerts_total",
+	Help: "Burn-rate alerts fired per service level objective and severity (page, ticket).",
+}, []string{"slo", "severity"})
+
+// SLOBurnWebhookFailures counts burn-rate alerts that could not be delivered
+var SLOBurnWebhookFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "notification_slo_burn_webhook_failures_total",
+	Help: "Burn-rate alerts that failed or were dropped before reaching the webhook.",
+})
+
+// VolumeAnomalies counts windows whose volume of an event type or tenant was far off its baseline
+var VolumeAnomalies = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_volume_anomalies_total",
+	Help: "Volume anomalies detected per dimension and kind (spike, drop, resolved).",
+}, []string{"dimension", "kind"})
+
+// AnomalyWebhookFailures counts anomaly alerts that could not be delivered
+var AnomalyWebhookFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "notification_anomaly_webhook_failures_total",
+	Help: "Volume anomaly alerts that failed or were dropped before reaching the webhook.",
+})
+
+// RateLimitObserved counts notifications exempt from rate limiting, which are observed but not counted
+var RateLimitObserved = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_rate_limit_observed_total",
+	Help: "Notifications that bypassed the per-user rate limit because their priority or event type is observed.",
+}, []string{"priority", "event_type"})
+
+// GlobalThrottled counts how often a notification had to wait for the global throughput limit
+var GlobalThrottled = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "notification_global_throttled_total",
+	Help: "Times a notification was delayed by a second because the cluster-wide throughput limit was reached.",
+})
+
+// RateLimitLocalHits counts rate-limit checks answered from the in-process token cache
+var RateLimitLocalHits = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "notification_rate_limit_local_hits_total",
+	Help: "Notifications allowed from a quota slice cached in-process, without a Redis round trip.",
+})
+
+// ConsumerFiltered counts messages the consumer skipped because of its header filter
+var ConsumerFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_consumer_filtered_total",
+	Help: "Messages skipped by the consumer's header filter, by priority lane.",
+}, []string{"priority"})
+
+// ConsumerOverflow counts messages that found their lane's buffer full, by the policy applied
+var ConsumerOverflow = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_consumer_overflow_total",
+	Help: "Messages that arrived while their priority lane's buffer was full, by overflow policy (block, spill or shed).",
+}, []string{"priority", "policy"})
+
+// ConsumerSpilled reports the notifications waiting in a lane's spill file
+var ConsumerSpilled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "notification_consumer_spilled",
+	Help: "Notifications spilled to disk because their priority lane's buffer was full, not yet processed.",
+}, []string{"priority"})
+
+// ConsumerPaused reports which priority lanes are paused to let more urgent lanes catch up
+var ConsumerPaused = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "notification_consumer_paused",
+	Help: "Whether a priority lane's consumer group is paused because more urgent lanes lag (1) or not (0).",
+}, []string{"priority"})
+
+// ConsumerPauseDuration tracks how long priority lanes stay paused
+var ConsumerPauseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "notification_consumer_pause_duration_seconds",
+	Help:    "Time a priority lane's consumer group was paused while more urgent lanes caught up.",
+	Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800},
+}, []string{"priority"})
+
+// ConsumerStalled reports which priority lanes the watchdog found stalled
+var ConsumerStalled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "notification_consumer_stalled",
+	Help: "Whether a priority lane claims partitions and lags but processed nothing within the stall timeout (1) or not (0).",
+}, []string{"priority"})
+
+// ConsumerStallRestarts counts the sessions the watchdog restarted
+var ConsumerStallRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_consumer_stall_restarts_total",
+	Help: "Sessions of stalled priority lanes restarted by the watchdog.",
+}, []string{"priority"})
+
+// EngagementSelections counts how channel selection by engagement went for event types any one channel will do
+var EngagementSelections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_engagement_selections_total",
+	Help: "Notifications of event types any one channel will do, by whether the most engaged channel was selected, one was explored at random, there was no data or reading it failed.",
+}, []string{"result"})
+
+// ChannelCost sums the cost weights of the channels notifications were delivered on
+var ChannelCost = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_channel_cost_total",
+	Help: "Cost weights of the channels notifications were sent to delivery on, by tenant and channel. Fallback channels are not included.",
+}, []string{"tenant", "channel"})
+
+// CostDeferred counts channels moved to the fallbacks because they didn't fit the cost budget
+var CostDeferred = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_cost_deferred_total",
+	Help: "Channels of non-escalating priorities moved to the fallback channels because they exceed the cost budget, by priority and channel.",
+}, []string{"priority", "channel"})
+
+// Snoozed counts notifications held back because the user snoozed them
+var Snoozed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_snoozed_total",
+	Help: "Notifications held back because the user snoozed them, by priority and whether they were skipped or deferred until the snooze ends.",
+}, []string{"priority", "action"})
+
+// ChannelCooldowns counts channels dropped because they reached the user too recently
+var ChannelCooldowns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_channel_cooldowns_total",
+	Help: "Channels dropped from notifications because they reached the user more recently than the channel's cooldown allows, by channel.",
+}, []string{"channel"})
+
+// InvalidContacts counts channels dropped because the user's contact for them can't be delivered to
+var InvalidContacts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_invalid_contacts_total",
+	Help: "Channels dropped from notifications because the user's email address or phone number can't be delivered to, by channel and reason.",
+}, []string{"channel", "reason"})
+
+// Blocklisted counts users and contacts found on a suppression list
+var Blocklisted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_blocklisted_total",
+	Help: "Notifications skipped for a user, and channels dropped for a contact, on a suppression list, by kind of entry and reason.",
+}, []string{"kind", "reason"})
+
+// WarmUpThrottled counts channels held back because their sending identity reached its warm-up cap for the day
+var WarmUpThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_warmup_throttled_total",
+	Help: "Channels held back from notifications because their sending identity reached its warm-up cap for the day, by channel.",
+}, []string{"channel"})
+
+// Spaced counts notifications deferred because they followed the previous one to the user too closely
+var Spaced = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_spaced_total",
+	Help: "Notifications deferred because they followed the previous one to the user on a spaced channel too closely, by priority.",
+}, []string{"priority"})
+
+// Suppressed counts notifications suppressed by a registered suppression rule
+var Suppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_suppressed_total",
+	Help: "Notifications suppressed by a registered suppression rule, by rule.",
+}, []string{"rule"})
+
+// BudgetTight counts notifications that skipped optional steps because little of their processing budget was left
+var BudgetTight = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_budget_tight_total",
+	Help: "Notifications that skipped engagement selection and channel cooldowns because little of their processing budget was left, by priority.",
+}, []string{"priority"})
+
+// BudgetExceeded counts notifications that reached the delivery topic after their processing deadline
+var BudgetExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_budget_exceeded_total",
+	Help: "Notifications that reached the delivery topic after the deadline of their priority's processing budget, by priority.",
+}, []string{"priority"})
+
+// OutsideWindow counts notifications deferred until their event type's delivery window opens
+var OutsideWindow = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_outside_window_total",
+	Help: "Notifications deferred because they arrived outside their event type's delivery window in the user's time zone, by priority.",
+}, []string{"priority"})
+
+// UserStatusSkipped counts notifications not delivered because the user is suspended or deleted
+var UserStatusSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_user_status_skipped_total",
+	Help: "Notifications skipped because the user's account is suspended or deleted, by status.",
+}, []string{"status"})
+
+// RegionUnrouted counts notifications not delivered because the user's region has no route
+var RegionUnrouted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_region_unrouted_total",
+	Help: "Notifications not delivered because the user's region has no delivery route and data residency is enforced, by region.",
+}, []string{"region"})
+
+// RegionDeliveries counts notifications sent to a region's delivery topic
+var RegionDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_region_deliveries_total",
+	Help: "Notifications sent to the delivery topic of the user's region, by region.",
+}, []string{"region"})
+
+// PreferencesQueryDuration tracks how long preference lookups take, per query
+var PreferencesQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "notification_preferences_query_duration_seconds",
+	Help:    "Time taken by preference queries including reading their rows, by query.",
+	Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+}, []string{"query"})
+
+// UsageFlushFailures counts failed writes of usage counts, which are retried on the next flush
+var UsageFlushFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "notification_usage_flush_failures_total",
+	Help: "Usage counts that could not be added to the daily rollups and were kept for the next flush.",
+})
+
+// DelayedMessages counts the messages the delay scheduler sent on, by result
+var DelayedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_delayed_messages_total",
+	Help: "Messages sent on by the delay scheduler: delivered to their target, rescheduled to a shorter tier, coalesced into an identical one, or failed and retried.",
+}, []string{"result"})
+
+// DelayLateness tracks how late delayed messages reach their target
+var DelayLateness = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "notification_delay_lateness_seconds",
+	Help:    "Time between when a delayed message was due and when the scheduler sent it to its target.",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+})
+
+// Bypasses counts notifications carrying a bypass, by whether it was honoured
+var Bypasses = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_bypasses_total",
+	Help: "Notifications carrying a bypass of rate limits and opt-outs: honoured, or rejected for an invalid signature.",
+}, []string{"result"})
+
+// SelfTestFailures counts failed runs of the self-test checks
+var SelfTestFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_self_test_failures_total",
+	Help: "Failed runs of the synthetic self-test checks, by check.",
+}, []string{"check"})
+
+// SelfTestDegraded reports the self-test checks that keep failing and make the instance unready
+var SelfTestDegraded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "notification_self_test_degraded",
+	Help: "Whether a self-test check failed enough consecutive runs to make the instance unready, by check.",
+}, []string{"check"})
+
+// RegisterDBStats exports the statistics of a connection pool as the
+// go_sql_* metrics, labelled with the pool's name
+func RegisterDBStats(db *sql.DB, name string) {
+	err := prometheus.Register(collectors.NewDBStatsCollector(db, name))
+	var registered prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &registered) {
+		log.Printf("Failed to register %s pool metrics: %v", name, err)
+	}
+}
+
+// StageSkipped reports which pipeline stages operators skip in degraded mode
+var StageSkipped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "notification_stage_skipped",
+	Help: "Whether a pipeline stage is skipped in degraded mode (1) or runs (0).",
+}, []string{"stage"})
+
+// StageSkipChanges counts stages skipped and restored in degraded mode
+var StageSkipChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_stage_skip_changes_total",
+	Help: "Pipeline stages skipped or restored in degraded mode, by action.",
+}, []string{"stage", "action"})
+
+// StageSkippedNotifications counts notifications processed without a skipped stage
+var StageSkippedNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_stage_skipped_notifications_total",
+	Help: "Notifications processed without a stage skipped in degraded mode.",
+}, []string{"stage"})
+
+// AdminActions counts operations performed through the admin APIs
+var AdminActions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_admin_actions_total",
+	Help: "Operations performed through the admin APIs of the pipeline, by service.",
+}, []string{"service"})
+
+// AdminAuditFailures counts admin actions that could not be written to the audit trail
+var AdminAuditFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "notification_admin_audit_failures_total",
+	Help: "Admin actions that could not be written to the audit trail.",
+})
+
+// SignatureRejections counts consumed messages dropped because of their signature
+var SignatureRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_signature_rejections_total",
+	Help: "Consumed messages dropped as unsigned, signed with an unknown key or signed wrongly, by topic.",
+}, []string{"topic"})
+
+// UnsignedMessages counts unsigned messages accepted while signatures aren't required
+var UnsignedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_unsigned_messages_total",
+	Help: "Unsigned messages consumed while signatures aren't required, by topic.",
+}, []string{"topic"})
+
+// StaleMessages counts consumed messages dropped because they were signed too long ago
+var StaleMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "notification_stale_messages_total",
+	Help: "Consumed messages dropped as signed longer ago than the maximum age, by topic.",
+}, []string{"topic"})
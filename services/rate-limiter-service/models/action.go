@@ -0,0 +1,7 @@
+package models
+
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
+// Button attached to a notification, rendered as a push action button,
+// an email call to action or an in-app action
+type Action = messages.Action
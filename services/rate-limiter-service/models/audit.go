@@ -0,0 +1,6 @@
+package models
+
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
+// Record of an operation performed through an admin API
+type AdminAction = messages.AdminAction
@@ -0,0 +1,14 @@
+package models
+
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
+// Per-channel content overrides
+type ChannelContent = messages.ChannelContent
+
+// Channel specific content
+type (
+	EmailContent = messages.EmailContent
+	Attachment   = messages.Attachment
+	PushContent  = messages.PushContent
+	SMSContent   = messages.SMSContent
+)
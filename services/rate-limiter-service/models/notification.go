@@ -1,34 +1,46 @@
 package models
 
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
 // PrioritizedNotification represents a notification with priority
-type PrioritizedNotification struct {
-	ID        string                 `json:"id"`
-	UserID    string                 `json:"user_id"`
-	EventType string                 `json:"event_type"`
-	Content   string                 `json:"content,omitempty"`
-	Metadata  map[string]any 				 `json:"metadata,omitempty"`
-	CreatedAt int64                  `json:"created_at"`
-	Priority  string                 `json:"priority"`
-}
+type PrioritizedNotification = messages.PrioritizedNotification
+
+// NotificationEvent is the enqueued notification a PrioritizedNotification extends
+type NotificationEvent = messages.NotificationEvent
+
+// Bypass exempts an emergency or legally required notification from rate
+// limiting and preference suppression. Enqueue signs it for the API keys
+// allowed to request one.
+type Bypass = messages.Bypass
 
 // ProcessedNotification represents a notification after rate limiting and preference checks
-type ProcessedNotification struct {
-	PrioritizedNotification
-	Channels []string `json:"channels"` // delivery channels (email, in-app, whatsapp, etc.)
-}
+type ProcessedNotification = messages.ProcessedNotification
 
-// Priority levels for notifications
+// RateLimitResult describes a user's quota as seen by a rate-limit check
+type RateLimitResult = messages.RateLimitResult
+
+// Priority levels
 const (
-	PriorityHigh   = "high"
-	PriorityMedium = "medium"
-	PriorityLow    = "low"
+	PriorityCritical = messages.PriorityCritical
+	PriorityHigh     = messages.PriorityHigh
+	PriorityMedium   = messages.PriorityMedium
+	PriorityLow      = messages.PriorityLow
 )
 
 // Delivery channels
 const (
-	ChannelEmail    = "email"
-	ChannelInApp    = "in-app"
-	ChannelPush     = "push"
-	ChannelWhatsApp = "whatsapp"
-	ChannelSMS      = "sms"
-)
\ No newline at end of file
+	ChannelEmail    = messages.ChannelEmail
+	ChannelInApp    = messages.ChannelInApp
+	ChannelPush     = messages.ChannelPush
+	ChannelWhatsApp = messages.ChannelWhatsApp
+	ChannelSMS      = messages.ChannelSMS
+)
+
+// Metadata entry naming the tenant a notification belongs to
+const MetadataTenant = messages.MetadataTenant
+
+// Decodes a prioritized notification, keeping metadata values longer than
+// passthroughBytes undecoded; zero decodes them all
+func UnmarshalPrioritized(data []byte, notification *PrioritizedNotification, passthroughBytes int) error {
+	return messages.UnmarshalPrioritized(data, notification, passthroughBytes)
+}
@@ -0,0 +1,13 @@
+package models
+
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
+// Status event types
+const (
+	StatusActionClicked  = messages.StatusActionClicked
+	StatusOpened         = messages.StatusOpened
+	StatusInvalidContact = messages.StatusInvalidContact
+)
+
+// Event on the status topic when something happened to a delivered notification
+type StatusEvent = messages.StatusEvent
@@ -0,0 +1,332 @@
+package overview
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/deadletters"
+)
+
+// How long reading the recent dead letters of a partition may take
+const readTimeout = 2 * time.Second
+
+// Stage is a consumer group reading topics of the pipeline
+type Stage struct {
+	Name    string
+	GroupID string
+	Topics  []string
+}
+
+// Config for the overview
+type Config struct {
+	Brokers           []string
+	Stages            []Stage
+	DeadLetterTopics  []string
+	RecentDeadLetters int        // Dead letters listed by default
+	AllowedOrigins    []string   // Origins of dashboards allowed to call the API, "*" allows all
+	Instances         func() any // Optional, the pipeline instances heard from on the ops topic
+	Throughput        func() any // Optional, settled notifications per second by priority
+}
+
+// Overview aggregates the state of the whole pipeline for dashboards: consumer
+// lag per stage, recent dead letters, throughput and instances
+type Overview struct {
+	client   sarama.Client
+	admin    sarama.ClusterAdmin
+	consumer sarama.Consumer
+	cfg      Config
+	origins  map[string]bool
+
+	readMu sync.Mutex // A partition can only be consumed once at a time
+}
+
+// StageLag is how far a stage's consumer group is behind its topics
+type StageLag struct {
+	Stage       string   `json:"stage"`
+	GroupID     string   `json:"group_id"`
+	Topics      []string `json:"topics"`
+	Lag         int64    `json:"lag"`
+	Uncommitted int      `json:"uncommitted_partitions,omitempty"` // Partitions the group has no offset for yet, not counted
+}
+
+// New connects to Kafka
+func New(cfg Config) (*Overview, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = false
+
+	client, err := sarama.NewClient(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		admin.Close()
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	origins := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		origins[origin] = true
+	}
+
+	return &Overview{
+		client:   client,
+		admin:    admin,
+		consumer: consumer,
+		cfg:      cfg,
+		origins:  origins,
+	}, nil
+}
+
+// Lag returns the lag of every stage
+func (o *Overview) Lag(ctx context.Context) ([]StageLag, error) {
+	lags := make([]StageLag, 0, len(o.cfg.Stages))
+	for _, stage := range o.cfg.Stages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		lag, err := o.stageLag(stage)
+		if err != nil {
+			return nil, fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		lags = append(lags, lag)
+	}
+	return lags, nil
+}
+
+// stageLag compares the group's committed offsets with the topics' high watermarks
+func (o *Overview) stageLag(stage Stage) (StageLag, error) {
+	lag := StageLag{Stage: stage.Name, GroupID: stage.GroupID, Topics: stage.Topics}
+
+	partitions := make(map[string][]int32, len(stage.Topics))
+	for _, topic := range stage.Topics {
+		ids, err := o.client.Partitions(topic)
+		if err != nil {
+			return lag, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+		}
+		partitions[topic] = ids
+	}
+
+	offsets, err := o.admin.ListConsumerGroupOffsets(stage.GroupID, partitions)
+	if err != nil {
+		return lag, fmt.Errorf("failed to list offsets of group %s: %w", stage.GroupID, err)
+	}
+
+	for topic, ids := range partitions {
+		for _, partition := range ids {
+			newest, err := o.client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return lag, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+			}
+
+			block := offsets.GetBlock(topic, partition)
+			if block == nil || block.Offset < 0 {
+				lag.Uncommitted++
+				continue
+			}
+			lag.Lag += max(newest-block.Offset, 0)
+		}
+	}
+	return lag, nil
+}
+
+// DeadLetters returns the latest dead letters across the dead-letter topics, newest first
+func (o *Overview) DeadLetters(ctx context.Context, limit int) ([]deadletters.Message, error) {
+	o.readMu.Lock()
+	defer o.readMu.Unlock()
+
+	var letters []deadletters.Message
+	for _, topic := range o.cfg.DeadLetterTopics {
+		partitions, err := o.client.Partitions(topic)
+		if errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+			continue // Created with the first dead letter
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+		}
+
+		for _, partition := range partitions {
+			read, err := o.readTail(ctx, topic, partition, limit)
+			if err != nil {
+				return nil, err
+			}
+			letters = append(letters, read...)
+		}
+	}
+
+	slices.SortFunc(letters, func(a, b deadletters.Message) int {
+		return b.Timestamp.Compare(a.Timestamp)
+	})
+	if len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return letters, nil
+}
+
+// readTail reads up to limit of the latest messages of a partition
+func (o *Overview) readTail(ctx context.Context, topic string, partition int32, limit int) ([]deadletters.Message, error) {
+	newest, err := o.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+	}
+	oldest, err := o.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offset of %s/%d: %w", topic, partition, err)
+	}
+
+	start := max(oldest, newest-int64(limit))
+	if start >= newest {
+		return nil, nil
+	}
+
+	partitionConsumer, err := o.consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume %s/%d: %w", topic, partition, err)
+	}
+	defer partitionConsumer.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	var letters []deadletters.Message
+	for {
+		select {
+		case <-ctx.Done():
+			// Messages may have been compacted or deleted meanwhile, return what was read
+			return letters, nil
+		case message := <-partitionConsumer.Messages():
+			letters = append(letters, deadletters.Decode(message))
+			if message.Offset >= newest-1 {
+				return letters, nil
+			}
+		}
+	}
+}
+
+// Handler serves the overview API:
+//
+//	GET /overview                everything below in one response
+//	GET /overview/lag            consumer lag per stage
+//	GET /overview/dead-letters   latest dead letters, ?limit=N
+//	GET /overview/throughput     settled notifications per second by priority
+//	GET /overview/instances      pipeline instances and whether they are alive
+func (o *Overview) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /overview", o.handleOverview)
+	mux.HandleFunc("GET /overview/lag", o.handleLag)
+	mux.HandleFunc("GET /overview/dead-letters", o.handleDeadLetters)
+	if o.cfg.Throughput != nil {
+		mux.HandleFunc("GET /overview/throughput", o.handleThroughput)
+	}
+	if o.cfg.Instances != nil {
+		mux.HandleFunc("GET /overview/instances", o.handleInstances)
+	}
+	return o.allowOrigins(mux)
+}
+
+// Handles requests for the whole overview. A part that can't be read is
+// replaced by its error, so the rest still shows.
+func (o *Overview) handleOverview(w http.ResponseWriter, r *http.Request) {
+	overview := map[string]any{
+		"generated_at": time.Now(),
+	}
+
+	if lag, err := o.Lag(r.Context()); err != nil {
+		overview["lag"] = map[string]string{"error": err.Error()}
+	} else {
+		overview["lag"] = lag
+	}
+	if letters, err := o.DeadLetters(r.Context(), o.cfg.RecentDeadLetters); err != nil {
+		overview["dead_letters"] = map[string]string{"error": err.Error()}
+	} else {
+		overview["dead_letters"] = letters
+	}
+	if o.cfg.Throughput != nil {
+		overview["throughput"] = o.cfg.Throughput()
+	}
+	if o.cfg.Instances != nil {
+		overview["instances"] = o.cfg.Instances()
+	}
+
+	writeJSON(w, overview)
+}
+
+// Handles requests for the lag per stage
+func (o *Overview) handleLag(w http.ResponseWriter, r *http.Request) {
+	lag, err := o.Lag(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read lag: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, lag)
+}
+
+// Handles requests for the latest dead letters
+func (o *Overview) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit := o.cfg.RecentDeadLetters
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	letters, err := o.DeadLetters(r.Context(), limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read dead letters: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, letters)
+}
+
+// Handles requests for the throughput per priority
+func (o *Overview) handleThroughput(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, o.cfg.Throughput())
+}
+
+// Handles requests for the pipeline instances
+func (o *Overview) handleInstances(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, o.cfg.Instances())
+}
+
+// allowOrigins lets dashboards served from the allowed origins call the API
+func (o *Overview) allowOrigins(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && (o.origins["*"] || o.origins[origin]) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes a value as a JSON response
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// Close releases resources
+func (o *Overview) Close() error {
+	o.consumer.Close()
+	o.admin.Close()
+	return nil
+}
@@ -0,0 +1,150 @@
+package preferences
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Invalidator is implemented by preference services that cache lookups
+type Invalidator interface {
+	// Invalidate drops any cached preferences of the user
+	Invalidate(userID string)
+}
+
+// CachingPreferencesService keeps recently used preferences in memory for a limited time
+type CachingPreferencesService struct {
+	PreferencesService
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// Incremented on every invalidation so lookups that started before it
+	// don't put stale preferences back into the cache
+	epoch uint64
+}
+
+type cacheEntry struct {
+	prefs     *UserPreferences
+	expiresAt time.Time
+}
+
+// NewCachingPreferencesService creates a preferences service that caches lookups for the given TTL
+func NewCachingPreferencesService(service PreferencesService, ttl time.Duration, maxEntries int) *CachingPreferencesService {
+	return &CachingPreferencesService{
+		PreferencesService: service,
+		ttl:                ttl,
+		maxEntries:         maxEntries,
+		entries:            make(map[string]cacheEntry),
+	}
+}
+
+// GetUserPreferences returns cached preferences when fresh, loading them otherwise
+func (c *CachingPreferencesService) GetUserPreferences(ctx context.Context, userID string) (*UserPreferences, error) {
+	c.mu.Lock()
+	entry, exists := c.entries[userID]
+	epoch := c.epoch
+	c.mu.Unlock()
+
+	if exists && time.Now().Before(entry.expiresAt) {
+		return entry.prefs, nil
+	}
+
+	prefs, err := c.PreferencesService.GetUserPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(userID, prefs, epoch)
+	return prefs, nil
+}
+
+// GetUsersPreferences returns cached preferences when fresh and loads the rest in one batch
+func (c *CachingPreferencesService) GetUsersPreferences(ctx context.Context, userIDs []string) (map[string]*UserPreferences, error) {
+	result := make(map[string]*UserPreferences, len(userIDs))
+	var missing []string
+
+	now := time.Now()
+	c.mu.Lock()
+	epoch := c.epoch
+	for _, userID := range userIDs {
+		if entry, exists := c.entries[userID]; exists && now.Before(entry.expiresAt) {
+			result[userID] = entry.prefs
+		} else {
+			missing = append(missing, userID)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	loaded, err := c.PreferencesService.GetUsersPreferences(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	for userID, prefs := range loaded {
+		result[userID] = prefs
+		c.store(userID, prefs, epoch)
+	}
+	return result, nil
+}
+
+// Invalidate drops the cached preferences of a user and passes the
+// invalidation on to the wrapped service
+func (c *CachingPreferencesService) Invalidate(userID string) {
+	if invalidator, ok := c.PreferencesService.(Invalidator); ok {
+		invalidator.Invalidate(userID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+	c.epoch++
+}
+
+// Fallback returns the preferences used while lookups are skipped in
+// degraded mode: the user's cached preferences however old, or the defaults
+func Fallback(service PreferencesService, userID string) *UserPreferences {
+	if cache, ok := service.(*CachingPreferencesService); ok {
+		cache.mu.Lock()
+		entry, exists := cache.entries[userID]
+		cache.mu.Unlock()
+		if exists {
+			return entry.prefs
+		}
+	}
+	return defaultPreferences(userID)
+}
+
+// store caches preferences loaded at the given epoch unless an invalidation happened since
+func (c *CachingPreferencesService) store(userID string, prefs *UserPreferences, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if epoch != c.epoch {
+		return
+	}
+
+	now := time.Now()
+	if len(c.entries) >= c.maxEntries {
+		// Make room by dropping expired entries, skip caching if still full
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+
+	c.entries[userID] = cacheEntry{
+		prefs:     prefs,
+		expiresAt: now.Add(c.ttl),
+	}
+}
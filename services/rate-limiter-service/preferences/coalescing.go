@@ -0,0 +1,71 @@
+package preferences
+
+import (
+	"context"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CoalescingPreferencesService wraps a PreferencesService so that concurrent
+// lookups for the same user share a single call to the underlying store
+type CoalescingPreferencesService struct {
+	PreferencesService
+	group singleflight.Group
+}
+
+// NewCoalescingPreferencesService creates a preferences service that coalesces concurrent lookups
+func NewCoalescingPreferencesService(service PreferencesService) PreferencesService {
+	return &CoalescingPreferencesService{
+		PreferencesService: service,
+	}
+}
+
+// GetUserPreferences retrieves a user's preferences, joining any in-flight lookup for the same user.
+// The returned preferences may be shared between callers and must not be modified.
+func (c *CoalescingPreferencesService) GetUserPreferences(ctx context.Context, userID string) (*UserPreferences, error) {
+	// The shared lookup outlives the caller that started it, each stops waiting on its own context
+	lookupCtx := context.WithoutCancel(ctx)
+	resultCh := c.group.DoChan(userID, func() (any, error) {
+		return c.PreferencesService.GetUserPreferences(lookupCtx, userID)
+	})
+
+	select {
+	case result := <-resultCh:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*UserPreferences), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GetUsersPreferences retrieves preferences for several users, deduplicating the requested IDs
+func (c *CoalescingPreferencesService) GetUsersPreferences(ctx context.Context, userIDs []string) (map[string]*UserPreferences, error) {
+	seen := make(map[string]bool, len(userIDs))
+	unique := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if !seen[userID] {
+			seen[userID] = true
+			unique = append(unique, userID)
+		}
+	}
+
+	// A single user is routed through GetUserPreferences so it can join in-flight lookups
+	if len(unique) == 1 {
+		prefs, err := c.GetUserPreferences(ctx, unique[0])
+		if err != nil {
+			return nil, err
+		}
+		return map[string]*UserPreferences{unique[0]: prefs}, nil
+	}
+
+	return c.PreferencesService.GetUsersPreferences(ctx, unique)
+}
+
+// Invalidate passes the invalidation on to the wrapped service
+func (c *CoalescingPreferencesService) Invalidate(userID string) {
+	if invalidator, ok := c.PreferencesService.(Invalidator); ok {
+		invalidator.Invalidate(userID)
+	}
+}
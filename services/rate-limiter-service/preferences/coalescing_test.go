@@ -0,0 +1,126 @@
+package preferences
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingService counts lookups, holding them until released
+type blockingService struct {
+	MockPreferencesService
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (s *blockingService) GetUserPreferences(ctx context.Context, userID string) (*UserPreferences, error) {
+	s.calls.Add(1)
+	<-s.release
+	return defaultPreferences(userID), nil
+}
+
+// joinedContext reports when the caller waits on it, which the coalescing
+// service only does once the caller joined a lookup
+type joinedContext struct {
+	context.Context
+	once   sync.Once
+	joined chan<- struct{}
+}
+
+func (c *joinedContext) Done() <-chan struct{} {
+	c.once.Do(func() { c.joined <- struct{}{} })
+	return c.Context.Done()
+}
+
+func TestCoalescingSharesConcurrentLookups(t *testing.T) {
+	backend := &blockingService{release: make(chan struct{})}
+	service := NewCoalescingPreferencesService(backend)
+
+	const callers = 10
+	var wg sync.WaitGroup
+	joined := make(chan struct{})
+	results := make(chan *UserPreferences, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := &joinedContext{Context: context.Background(), joined: joined}
+			prefs, err := service.GetUserPreferences(ctx, "user-1")
+			if err != nil {
+				t.Errorf("lookup: %v", err)
+			}
+			results <- prefs
+		}()
+	}
+
+	// Let every caller join the lookup in flight before it completes
+	for i := 0; i < callers; i++ {
+		<-joined
+	}
+	close(backend.release)
+	wg.Wait()
+	close(results)
+
+	if calls := backend.calls.Load(); calls != 1 {
+		t.Errorf("%d lookups reached the store, want 1", calls)
+	}
+	for prefs := range results {
+		if prefs == nil || prefs.UserID != "user-1" {
+			t.Errorf("caller got %+v", prefs)
+		}
+	}
+
+	// Other users are looked up on their own
+	if prefs, _ := service.GetUserPreferences(context.Background(), "user-2"); prefs.UserID != "user-2" || backend.calls.Load() != 2 {
+		t.Errorf("lookup of another user got %+v after %d calls", prefs, backend.calls.Load())
+	}
+}
+
+func TestCoalescingCallerStopsWaitingOnItsOwnContext(t *testing.T) {
+	backend := &blockingService{release: make(chan struct{})}
+	defer close(backend.release)
+	service := NewCoalescingPreferencesService(backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := service.GetUserPreferences(ctx, "user-1"); err != context.Canceled {
+		t.Errorf("lookup of a canceled caller = %v, want %v", err, context.Canceled)
+	}
+}
+
+// batchService records the batches it is asked for
+type batchService struct {
+	MockPreferencesService
+	batches [][]string
+}
+
+func (s *batchService) GetUsersPreferences(ctx context.Context, userIDs []string) (map[string]*UserPreferences, error) {
+	s.batches = append(s.batches, userIDs)
+	return s.MockPreferencesService.GetUsersPreferences(ctx, userIDs)
+}
+
+func TestCoalescingBatchesDistinctUsers(t *testing.T) {
+	backend := &batchService{}
+	service := NewCoalescingPreferencesService(NewCachingPreferencesService(backend, time.Minute, 10))
+
+	prefs, err := service.GetUsersPreferences(context.Background(), []string{"user-1", "user-2", "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefs) != 2 || prefs["user-1"].UserID != "user-1" || prefs["user-2"].UserID != "user-2" {
+		t.Errorf("batch lookup = %v", prefs)
+	}
+	if len(backend.batches) != 1 || len(backend.batches[0]) != 2 {
+		t.Errorf("store was asked for %v, want one batch of both users", backend.batches)
+	}
+
+	// Cached users are left out of the next batch
+	if _, err := service.GetUsersPreferences(context.Background(), []string{"user-1", "user-3", "user-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.batches) != 2 || len(backend.batches[1]) != 1 || backend.batches[1][0] != "user-3" {
+		t.Errorf("store was asked for %v, want only the uncached user", backend.batches)
+	}
+}
@@ -1,13 +1,36 @@
 package preferences
 
+import "time"
+
 // UserPreferences represents a user's notification preferences
 type UserPreferences struct {
 	UserID      string                       `json:"user_id"`
+	Status      string                       `json:"status"`        // Account status, see StatusActive
 	GlobalOptIn bool                         `json:"global_opt_in"` // Whether user has opted in to any notifications
 	Channels    map[string]bool              `json:"channels"`      // Which channels are enabled (email, in-app, etc)
 	EventTypes  map[string]map[string]bool   `json:"event_types"`   // Preferences by event type -> channel
+	Snoozes     map[string]time.Time         `json:"snoozes"`       // Snoozed until, by event type; "" snoozes all
+	Region      string                       `json:"region,omitempty"` // Region the user's data lives in, empty for the default region
+	Timezone    string                       `json:"timezone,omitempty"` // IANA time zone delivery windows are applied in, empty when unknown
+	Contacts    map[string]string            `json:"-"`                  // Email address, phone number or device token by channel, as stored; kept out of simulations
+}
+
+// SnoozedUntil returns until when notifications of the event type are snoozed, if they are
+func (p *UserPreferences) SnoozedUntil(eventType string, now time.Time) (time.Time, bool) {
+	until := p.Snoozes[""]
+	if eventUntil := p.Snoozes[eventType]; eventUntil.After(until) {
+		until = eventUntil
+	}
+	return until, until.After(now)
 }
 
+// Account statuses of a user, only active users receive notifications
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusDeleted   = "deleted" // Soft-deleted, kept until the user's data is erased
+)
+
 // ChannelInfo contains information needed to deliver to a channel
 type ChannelInfo struct {
 	Enabled     bool   `json:"enabled"`
@@ -1,35 +1,92 @@
 package preferences
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
 )
 
 // PreferencesService is responsible for retrieving user preferences
 type PreferencesService interface {
-	GetUserPreferences(userID string) (*UserPreferences, error)
+	GetUserPreferences(ctx context.Context, userID string) (*UserPreferences, error)
+	// GetUsersPreferences retrieves preferences for several users at once, keyed by user ID
+	GetUsersPreferences(ctx context.Context, userIDs []string) (map[string]*UserPreferences, error)
+	// Ping checks the backing store is reachable, for readiness
+	Ping(ctx context.Context) error
 	Close() error
 }
 
 // SQLPreferencesService implements PreferencesService using SQL database
 type SQLPreferencesService struct {
-	db *sql.DB
+	db           *sql.DB
+	replica      *sql.DB       // Optional, serves lookups instead of the primary
+	queryTimeout time.Duration // Zero means no timeout
+	slowQuery    time.Duration // Queries taking longer are logged, zero disables the log
+
+	// Users whose preferences changed recently are read from the primary
+	// until the replica has caught up
+	staleWindow time.Duration
+	mu          sync.Mutex
+	changed     map[string]time.Time
 }
 
+// Number of recently changed users above which expired ones are pruned
+const maxTrackedChanges = 10000
+
 // Config for preferences service
 type Config struct {
 	Driver   string
 	DSN      string
-	MaxConns int
-	MaxIdle  int
+	ReadDSN  string        // Optional replica preferences are looked up from
+	StaleReadWindow time.Duration // How long after a change a user is read from the primary
+	MaxConns     int
+	MaxIdle      int
+	QueryTimeout time.Duration
+	SlowQuery    time.Duration
 }
 
 // NewSQLPreferencesService creates a new preferences service
 func NewSQLPreferencesService(config Config) (PreferencesService, error) {
-	db, err := sql.Open(config.Driver, config.DSN)
+	db, err := openDB(config, config.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	var replica *sql.DB
+	if config.ReadDSN != "" {
+		replica, err = openDB(config, config.ReadDSN)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("replica: %w", err)
+		}
+	}
+
+	// Export the pools' in-use, idle and wait statistics
+	metrics.RegisterDBStats(db, "preferences")
+	if replica != nil {
+		metrics.RegisterDBStats(replica, "preferences_replica")
+	}
+
+	return &SQLPreferencesService{
+		db:           db,
+		replica:      replica,
+		queryTimeout: config.QueryTimeout,
+		slowQuery:    config.SlowQuery,
+		staleWindow:  config.StaleReadWindow,
+		changed:      make(map[string]time.Time),
+	}, nil
+}
+
+// openDB opens a connection pool and checks it can connect
+func openDB(config Config, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(config.Driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -39,48 +96,93 @@ func NewSQLPreferencesService(config Config) (PreferencesService, error) {
 	db.SetMaxIdleConns(config.MaxIdle)
 
 	// Check connection
-	if err := db.Ping(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
-
-	return &SQLPreferencesService{
-		db: db,
-	}, nil
+	return db, nil
 }
 
 // GetUserPreferences retrieves a user's notification preferences
-func (s *SQLPreferencesService) GetUserPreferences(userID string) (*UserPreferences, error) {
+func (s *SQLPreferencesService) GetUserPreferences(ctx context.Context, userID string) (*UserPreferences, error) {
+	prefs, err := s.GetUsersPreferences(ctx, []string{userID})
+	if err != nil {
+		return nil, err
+	}
+	return prefs[userID], nil
+}
+
+// GetUsersPreferences retrieves the preferences of several users with one
+// query per table. Rows of users that weren't asked for, such as IDs matched
+// by a case-insensitive collation, are ignored.
+func (s *SQLPreferencesService) GetUsersPreferences(ctx context.Context, userIDs []string) (map[string]*UserPreferences, error) {
+	result := make(map[string]*UserPreferences, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	if s.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.queryTimeout)
+		defer cancel()
+	}
+
 	// Start with default preferences
-	prefs := &UserPreferences{
-		UserID:      userID,
-		GlobalOptIn: true,
-		Channels: map[string]bool{
-			"email":    true,
-			"in-app":   true,
-			"push":     false,
-			"whatsapp": false,
-			"sms":      false,
-		},
-		EventTypes: make(map[string]map[string]bool),
+	for _, userID := range userIDs {
+		result[userID] = defaultPreferences(userID)
 	}
 
+	placeholders, args := inClause(userIDs)
+	db := s.readDB(userIDs)
+
 	// Query for basic preferences from users table directly
-	var globalOptIn bool
-	err := s.db.QueryRow("SELECT global_opt_in FROM users WHERE id = ?", userID).Scan(&globalOptIn)
+	start := time.Now()
+	rows, err := db.QueryContext(ctx, "SELECT id, status, global_opt_in, region, timezone FROM users WHERE id IN ("+placeholders+")", args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// No preferences found, use defaults
-			log.Print("No user found", userID)
-			return prefs, nil
+		return nil, fmt.Errorf("error querying user preferences: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool, len(userIDs))
+	for rows.Next() {
+		var userID, status, region, timezone string
+		var globalOptIn bool
+		if err := rows.Scan(&userID, &status, &globalOptIn, &region, &timezone); err != nil {
+			return nil, fmt.Errorf("error scanning user preferences: %w", err)
 		}
+		prefs, requested := result[userID]
+		if !requested {
+			continue
+		}
+		prefs.Status = status
+		prefs.GlobalOptIn = globalOptIn
+		prefs.Region = region
+		prefs.Timezone = timezone
+		found[userID] = true
+	}
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error querying user preferences: %w", err)
 	}
-	prefs.GlobalOptIn = globalOptIn
+	s.observe(db, "users", start, len(userIDs))
+
+	for _, userID := range userIDs {
+		if !found[userID] {
+			// No preferences found, use defaults
+			log.Print("No user found ", userID)
+		}
+	}
+	if len(found) == 0 {
+		return result, nil
+	}
 
 	// Query for channel preferences
-	rows, err := s.db.Query(
-		"SELECT channel_name, enabled FROM user_channel_preferences WHERE user_id = ?", 
-		userID,
+	start = time.Now()
+	rows, err = db.QueryContext(ctx,
+		"SELECT user_id, channel_name, enabled FROM user_channel_preferences WHERE user_id IN ("+placeholders+")",
+		args...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error querying channel preferences: %w", err)
@@ -88,18 +190,25 @@ func (s *SQLPreferencesService) GetUserPreferences(userID string) (*UserPreferen
 	defer rows.Close()
 
 	for rows.Next() {
-		var channelName string
+		var userID, channelName string
 		var enabled bool
-		if err := rows.Scan(&channelName, &enabled); err != nil {
+		if err := rows.Scan(&userID, &channelName, &enabled); err != nil {
 			return nil, fmt.Errorf("error scanning channel preferences: %w", err)
 		}
-		prefs.Channels[channelName] = enabled
+		if prefs, requested := result[userID]; requested {
+			prefs.Channels[channelName] = enabled
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying channel preferences: %w", err)
 	}
+	s.observe(db, "channel_preferences", start, len(userIDs))
 
 	// Query for event type preferences
-	rows, err = s.db.Query(
-		"SELECT event_type, channel_name, enabled FROM user_event_preferences WHERE user_id = ?", 
-		userID,
+	start = time.Now()
+	rows, err = db.QueryContext(ctx,
+		"SELECT user_id, event_type, channel_name, enabled FROM user_event_preferences WHERE user_id IN ("+placeholders+")",
+		args...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error querying event preferences: %w", err)
@@ -107,25 +216,211 @@ func (s *SQLPreferencesService) GetUserPreferences(userID string) (*UserPreferen
 	defer rows.Close()
 
 	for rows.Next() {
-		var eventType, channelName string
+		var userID, eventType, channelName string
 		var enabled bool
-		if err := rows.Scan(&eventType, &channelName, &enabled); err != nil {
+		if err := rows.Scan(&userID, &eventType, &channelName, &enabled); err != nil {
 			return nil, fmt.Errorf("error scanning event preferences: %w", err)
 		}
-		
+
+		prefs, requested := result[userID]
+		if !requested {
+			continue
+		}
+
 		// Initialize the event type map if it doesn't exist
 		if _, ok := prefs.EventTypes[eventType]; !ok {
 			prefs.EventTypes[eventType] = make(map[string]bool)
 		}
-		
+
 		prefs.EventTypes[eventType][channelName] = enabled
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying event preferences: %w", err)
+	}
+	s.observe(db, "event_preferences", start, len(userIDs))
 
-	return prefs, nil
+	// Query for snoozes in effect; the database compares and converts the
+	// times, so its time zone doesn't matter
+	start = time.Now()
+	rows, err = db.QueryContext(ctx,
+		"SELECT user_id, event_type, UNIX_TIMESTAMP(snoozed_until) FROM user_snoozes WHERE user_id IN ("+placeholders+") AND snoozed_until > NOW()",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying snoozes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID, eventType string
+		var until int64
+		if err := rows.Scan(&userID, &eventType, &until); err != nil {
+			return nil, fmt.Errorf("error scanning snoozes: %w", err)
+		}
+
+		prefs, requested := result[userID]
+		if !requested {
+			continue
+		}
+		if prefs.Snoozes == nil {
+			prefs.Snoozes = make(map[string]time.Time)
+		}
+		prefs.Snoozes[eventType] = time.Unix(until, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying snoozes: %w", err)
+	}
+	s.observe(db, "snoozes", start, len(userIDs))
+
+	// Query for contact info
+	start = time.Now()
+	rows, err = db.QueryContext(ctx,
+		"SELECT user_id, channel_name, contact_value FROM user_contact_info WHERE user_id IN ("+placeholders+")",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying contact info: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID, channelName, contact string
+		if err := rows.Scan(&userID, &channelName, &contact); err != nil {
+			return nil, fmt.Errorf("error scanning contact info: %w", err)
+		}
+
+		prefs, requested := result[userID]
+		if !requested {
+			continue
+		}
+		if prefs.Contacts == nil {
+			prefs.Contacts = make(map[string]string)
+		}
+		prefs.Contacts[channelName] = contact
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying contact info: %w", err)
+	}
+	s.observe(db, "contact_info", start, len(userIDs))
+
+	return result, nil
+}
+
+// readDB returns the pool to look the users up from: the replica, unless one
+// of them changed too recently for the replica to be trusted
+func (s *SQLPreferencesService) readDB(userIDs []string) *sql.DB {
+	if s.replica == nil {
+		return s.db
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, userID := range userIDs {
+		if changedAt, exists := s.changed[userID]; exists {
+			if now.Sub(changedAt) < s.staleWindow {
+				return s.db
+			}
+			delete(s.changed, userID)
+		}
+	}
+	return s.replica
+}
+
+// Invalidate notes that the user's preferences changed, so they are read
+// from the primary for the stale-read window
+func (s *SQLPreferencesService) Invalidate(userID string) {
+	if s.replica == nil || s.staleWindow <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Users that aren't looked up again are forgotten once the window passed
+	now := time.Now()
+	if len(s.changed) >= maxTrackedChanges {
+		for id, changedAt := range s.changed {
+			if now.Sub(changedAt) >= s.staleWindow {
+				delete(s.changed, id)
+			}
+		}
+	}
+	s.changed[userID] = now
+}
+
+// observe records how long a named query took, including reading its rows,
+// and logs it when slow
+func (s *SQLPreferencesService) observe(db *sql.DB, name string, start time.Time, users int) {
+	elapsed := time.Since(start)
+	metrics.PreferencesQueryDuration.WithLabelValues(name).Observe(elapsed.Seconds())
+
+	if s.slowQuery > 0 && elapsed >= s.slowQuery {
+		stats := db.Stats()
+		log.Printf("Slow preferences query %s took %v for %d users (pool: %d in use, %d idle, %d waits)",
+			name, elapsed, users, stats.InUse, stats.Idle, stats.WaitCount)
+	}
+}
+
+// defaultPreferences returns the preferences applied to users without stored settings
+func defaultPreferences(userID string) *UserPreferences {
+	return &UserPreferences{
+		UserID:      userID,
+		Status:      StatusActive,
+		GlobalOptIn: true,
+		Channels: map[string]bool{
+			"email":    true,
+			"in-app":   true,
+			"push":     false,
+			"whatsapp": false,
+			"sms":      false,
+		},
+		EventTypes: make(map[string]map[string]bool),
+	}
+}
+
+// inClause builds the placeholder list and arguments for an IN (...) query
+func inClause(values []string) (string, []any) {
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(values)), ","), args
 }
 
-// Close closes the database connection
+// CheckDatabase opens a connection to the database and pings it, for preflight checks
+func CheckDatabase(ctx context.Context, driver, dsn string) error {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return fmt.Errorf("invalid %s DSN: %w", driver, err)
+	}
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s not reachable: %w", driver, err)
+	}
+	return nil
+}
+
+// Ping checks the databases are reachable, the pools reconnect on their own
+func (s *SQLPreferencesService) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+	if s.replica != nil {
+		if err := s.replica.PingContext(ctx); err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the database connections
 func (s *SQLPreferencesService) Close() error {
+	if s.replica != nil {
+		s.replica.Close()
+	}
 	return s.db.Close()
 }
 
@@ -133,10 +428,11 @@ func (s *SQLPreferencesService) Close() error {
 type MockPreferencesService struct{}
 
 // GetUserPreferences retrieves mock user preferences
-func (m *MockPreferencesService) GetUserPreferences(userID string) (*UserPreferences, error) {
+func (m *MockPreferencesService) GetUserPreferences(ctx context.Context, userID string) (*UserPreferences, error) {
 	// Return mock preferences that are the same for all users
 	return &UserPreferences{
 		UserID:      userID,
+		Status:      StatusActive,
 		GlobalOptIn: true,
 		Channels: map[string]bool{
 			"email":    true,
@@ -171,6 +467,24 @@ func (m *MockPreferencesService) GetUserPreferences(userID string) (*UserPrefere
 	}, nil
 }
 
+// GetUsersPreferences retrieves mock preferences for several users
+func (m *MockPreferencesService) GetUsersPreferences(ctx context.Context, userIDs []string) (map[string]*UserPreferences, error) {
+	result := make(map[string]*UserPreferences, len(userIDs))
+	for _, userID := range userIDs {
+		prefs, err := m.GetUserPreferences(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		result[userID] = prefs
+	}
+	return result, nil
+}
+
+// Ping for mock implementation
+func (m *MockPreferencesService) Ping(ctx context.Context) error {
+	return nil
+}
+
 // Close for mock implementation
 func (m *MockPreferencesService) Close() error {
 	return nil
@@ -0,0 +1,150 @@
+package profiles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminauth"
+)
+
+// Handler serves the rate-limit profile API:
+//
+//	GET    /rate-limit-profiles                            every profile
+//	GET    /rate-limit-profiles/{name}                     one profile
+//	PUT    /rate-limit-profiles/{name}                     {"window_seconds": 3600, "limits": {"high": 50}, "event_type_limits": {"like": 20}}
+//	DELETE /rate-limit-profiles/{name}                     a profile that isn't assigned
+//	GET    /rate-limit-assignments                         assignments, ?environment=
+//	PUT    /rate-limit-assignments/{environment}           {"profile": "standard"}, the environment's default
+//	PUT    /rate-limit-assignments/{environment}/{tenant}  {"profile": "strict"}
+//	DELETE /rate-limit-assignments/{environment}[/{tenant}]
+//
+// Changes are audited under the operator who sent them.
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rate-limit-profiles", s.handleProfiles)
+	mux.HandleFunc("GET /rate-limit-profiles/{name}", s.handleProfile)
+	mux.HandleFunc("PUT /rate-limit-profiles/{name}", s.handlePutProfile)
+	mux.HandleFunc("DELETE /rate-limit-profiles/{name}", s.handleDeleteProfile)
+	mux.HandleFunc("GET /rate-limit-assignments", s.handleAssignments)
+	mux.HandleFunc("PUT /rate-limit-assignments/{environment}", s.handleAssign)
+	mux.HandleFunc("PUT /rate-limit-assignments/{environment}/{tenant}", s.handleAssign)
+	mux.HandleFunc("DELETE /rate-limit-assignments/{environment}", s.handleUnassign)
+	mux.HandleFunc("DELETE /rate-limit-assignments/{environment}/{tenant}", s.handleUnassign)
+	return mux
+}
+
+// Handles requests for every profile
+func (s *Store) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := s.Profiles(r.Context())
+	if err != nil {
+		writeError(w, "failed to read profiles", err)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"environment": s.environment,
+		"profiles":    profiles,
+	})
+}
+
+// Handles requests for one profile
+func (s *Store) handleProfile(w http.ResponseWriter, r *http.Request) {
+	profile, err := s.Profile(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeError(w, "failed to read profile", err)
+		return
+	}
+	writeJSON(w, profile)
+}
+
+// Handles requests to create or replace a profile
+func (s *Store) handlePutProfile(w http.ResponseWriter, r *http.Request) {
+	var profile Profile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		http.Error(w, fmt.Sprintf("invalid profile: %v", err), http.StatusBadRequest)
+		return
+	}
+	profile.Name = r.PathValue("name")
+
+	if err := s.PutProfile(r.Context(), profile, adminauth.Actor(r)); err != nil {
+		writeError(w, "failed to store profile", err)
+		return
+	}
+	s.handleProfile(w, r)
+}
+
+// Handles requests to delete a profile
+func (s *Store) handleDeleteProfile(w http.ResponseWriter, r *http.Request) {
+	if err := s.DeleteProfile(r.Context(), r.PathValue("name"), adminauth.Actor(r)); err != nil {
+		writeError(w, "failed to delete profile", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles requests for the assignments
+func (s *Store) handleAssignments(w http.ResponseWriter, r *http.Request) {
+	assignments, err := s.Assignments(r.Context(), r.URL.Query().Get("environment"))
+	if err != nil {
+		writeError(w, "failed to read assignments", err)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"assignments": assignments,
+	})
+}
+
+// Handles requests to assign a profile
+func (s *Store) handleAssign(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Profile string `json:"profile"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Profile == "" {
+		http.Error(w, "a profile must be given", http.StatusBadRequest)
+		return
+	}
+
+	environment := r.PathValue("environment")
+	if err := s.Assign(r.Context(), environment, r.PathValue("tenant"), req.Profile, adminauth.Actor(r)); err != nil {
+		writeError(w, "failed to assign profile", err)
+		return
+	}
+	assignments, err := s.Assignments(r.Context(), environment)
+	if err != nil {
+		writeError(w, "failed to read assignments", err)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"assignments": assignments,
+	})
+}
+
+// Handles requests to remove an assignment
+func (s *Store) handleUnassign(w http.ResponseWriter, r *http.Request) {
+	if err := s.Unassign(r.Context(), r.PathValue("environment"), r.PathValue("tenant"), adminauth.Actor(r)); err != nil {
+		writeError(w, "failed to remove assignment", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError writes an error with the status matching its cause
+func writeError(w http.ResponseWriter, message string, err error) {
+	status := http.StatusServiceUnavailable
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrAssigned):
+		status = http.StatusConflict
+	case errors.Is(err, ErrInvalid):
+		status = http.StatusBadRequest
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), status)
+}
+
+// writeJSON writes a value as a JSON response
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,415 @@
+// Package profiles keeps named sets of rate limits in MySQL and assigns them
+// to tenants per environment, so limits are tuned through the admin API
+// instead of redeploying with new environment variables.
+package profiles
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/ratelimiter"
+)
+
+// Errors of profile and assignment changes
+var (
+	ErrNotFound = errors.New("not found")
+	ErrAssigned = errors.New("profile is assigned")
+	ErrInvalid  = errors.New("invalid profile")
+)
+
+// Profile is a named set of rate limits
+type Profile struct {
+	Name            string         `json:"name"`
+	WindowSeconds   int            `json:"window_seconds,omitempty"`    // Zero keeps the configured window
+	Limits          map[string]int `json:"limits,omitempty"`            // Notifications per window by priority level
+	EventTypeLimits map[string]int `json:"event_type_limits,omitempty"` // Notifications of an event type per window
+	UpdatedBy       string         `json:"updated_by,omitempty"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+// Assignment of a profile to a tenant of an environment, or to all of its
+// tenants without an assignment of their own
+type Assignment struct {
+	Environment string    `json:"environment"`
+	Tenant      string    `json:"tenant,omitempty"` // Empty for the environment's default
+	Profile     string    `json:"profile"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Config for the store
+type Config struct {
+	Driver          string
+	DSN             string
+	Environment     string        // Environment whose assignments apply to this instance
+	Priorities      []string      // Priority levels profiles may set limits for
+	RefreshInterval time.Duration // How often changes made on other instances are picked up
+}
+
+// Store keeps the profiles and assignments, and the limits assigned in this
+// instance's environment in memory for the limiter. Changes apply at once on
+// the instance they were made on and within the refresh interval on others.
+type Store struct {
+	db          *sql.DB
+	environment string
+	priorities  []string
+	interval    time.Duration
+
+	limits atomic.Pointer[assigned]
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Limits assigned in the store's environment
+type assigned struct {
+	tenants  map[string]*ratelimiter.Limits
+	fallback *ratelimiter.Limits // The environment's default, nil for the configured limits
+}
+
+// NewStore connects to the database, loads the limits of the environment and
+// starts refreshing them in the background
+func NewStore(cfg Config) (*Store, error) {
+	if cfg.Environment == "" || cfg.RefreshInterval <= 0 {
+		return nil, fmt.Errorf("environment and refresh interval must be set")
+	}
+
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	s := &Store{
+		db:          db,
+		environment: cfg.Environment,
+		priorities:  cfg.Priorities,
+		interval:    cfg.RefreshInterval,
+		done:        make(chan struct{}),
+	}
+	if err := s.refresh(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// LimitsFor returns the limits assigned to a tenant in the store's
+// environment, the environment's default for tenants without an assignment
+func (s *Store) LimitsFor(tenant string) *ratelimiter.Limits {
+	limits := s.limits.Load()
+	if tenantLimits, exists := limits.tenants[tenant]; exists {
+		return tenantLimits
+	}
+	return limits.fallback
+}
+
+// Environment returns the environment whose assignments apply to this instance
+func (s *Store) Environment() string {
+	return s.environment
+}
+
+// run refreshes the limits every interval until the store is closed
+func (s *Store) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		if err := s.refresh(ctx); err != nil {
+			log.Printf("Failed to refresh rate-limit profiles, keeping the current ones: %v", err)
+		}
+		cancel()
+	}
+}
+
+// refresh loads the limits assigned in the environment
+func (s *Store) refresh(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT a.tenant, p.window_seconds, p.limits, p.event_type_limits FROM rate_limit_profile_assignments a JOIN rate_limit_profiles p ON p.name = a.profile WHERE a.environment = ?",
+		s.environment)
+	if err != nil {
+		return fmt.Errorf("failed to query assigned profiles: %w", err)
+	}
+	defer rows.Close()
+
+	limits := &assigned{tenants: make(map[string]*ratelimiter.Limits)}
+	for rows.Next() {
+		var tenant string
+		var profile Profile
+		var priorityLimits, eventTypeLimits []byte
+		if err := rows.Scan(&tenant, &profile.WindowSeconds, &priorityLimits, &eventTypeLimits); err != nil {
+			return fmt.Errorf("failed to scan assigned profile: %w", err)
+		}
+		if err := unmarshalLimits(&profile, priorityLimits, eventTypeLimits); err != nil {
+			return err
+		}
+
+		tenantLimits := &ratelimiter.Limits{
+			Window:     time.Duration(profile.WindowSeconds) * time.Second,
+			Priorities: profile.Limits,
+			EventTypes: profile.EventTypeLimits,
+		}
+		if tenant == "" {
+			limits.fallback = tenantLimits
+		} else {
+			limits.tenants[tenant] = tenantLimits
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read assigned profiles: %w", err)
+	}
+
+	s.limits.Store(limits)
+	return nil
+}
+
+// Profiles returns every profile by name
+func (s *Store) Profiles(ctx context.Context) ([]Profile, error) {
+	return s.queryProfiles(ctx, "")
+}
+
+// Profile returns a profile by name
+func (s *Store) Profile(ctx context.Context, name string) (Profile, error) {
+	profiles, err := s.queryProfiles(ctx, name)
+	if err != nil {
+		return Profile{}, err
+	}
+	if len(profiles) == 0 {
+		return Profile{}, fmt.Errorf("profile %s: %w", name, ErrNotFound)
+	}
+	return profiles[0], nil
+}
+
+// queryProfiles returns the profile of a name, every profile without one
+func (s *Store) queryProfiles(ctx context.Context, name string) ([]Profile, error) {
+	query := "SELECT name, window_seconds, limits, event_type_limits, updated_by, DATE_FORMAT(updated_at, '%Y-%m-%dT%H:%i:%sZ') FROM rate_limit_profiles"
+	var args []any
+	if name != "" {
+		query += " WHERE name = ?"
+		args = append(args, name)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY name", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query profiles: %w", err)
+	}
+	defer rows.Close()
+
+	profiles := []Profile{}
+	for rows.Next() {
+		var profile Profile
+		var priorityLimits, eventTypeLimits []byte
+		var updatedAt string
+		if err := rows.Scan(&profile.Name, &profile.WindowSeconds, &priorityLimits, &eventTypeLimits, &profile.UpdatedBy, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan profile: %w", err)
+		}
+		if err := unmarshalLimits(&profile, priorityLimits, eventTypeLimits); err != nil {
+			return nil, err
+		}
+		profile.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+// PutProfile creates or replaces a profile
+func (s *Store) PutProfile(ctx context.Context, profile Profile, by string) error {
+	if err := s.validate(profile); err != nil {
+		return err
+	}
+
+	priorityLimits, err := json.Marshal(nonNil(profile.Limits))
+	if err != nil {
+		return fmt.Errorf("failed to marshal limits: %w", err)
+	}
+	eventTypeLimits, err := json.Marshal(nonNil(profile.EventTypeLimits))
+	if err != nil {
+		return fmt.Errorf("failed to marshal event type limits: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO rate_limit_profiles (name, window_seconds, limits, event_type_limits, updated_by) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE window_seconds = VALUES(window_seconds), limits = VALUES(limits),
+		event_type_limits = VALUES(event_type_limits), updated_by = VALUES(updated_by)`,
+		profile.Name, profile.WindowSeconds, priorityLimits, eventTypeLimits, by)
+	if err != nil {
+		return fmt.Errorf("failed to store profile: %w", err)
+	}
+
+	log.Printf("Rate-limit profile %s set by %s: window %ds, limits %v, event type limits %v",
+		profile.Name, by, profile.WindowSeconds, profile.Limits, profile.EventTypeLimits)
+	return s.refresh(ctx)
+}
+
+// DeleteProfile deletes a profile no tenant or environment is assigned
+func (s *Store) DeleteProfile(ctx context.Context, name, by string) error {
+	var assignments int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM rate_limit_profile_assignments WHERE profile = ?", name).Scan(&assignments)
+	if err != nil {
+		return fmt.Errorf("failed to count assignments: %w", err)
+	}
+	if assignments > 0 {
+		return fmt.Errorf("profile %s has %d assignments: %w", name, assignments, ErrAssigned)
+	}
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM rate_limit_profiles WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete profile: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return fmt.Errorf("profile %s: %w", name, ErrNotFound)
+	}
+
+	log.Printf("Rate-limit profile %s deleted by %s", name, by)
+	return nil
+}
+
+// Assignments returns the assignments of an environment, of every environment without one
+func (s *Store) Assignments(ctx context.Context, environment string) ([]Assignment, error) {
+	query := "SELECT environment, tenant, profile, updated_by, DATE_FORMAT(updated_at, '%Y-%m-%dT%H:%i:%sZ') FROM rate_limit_profile_assignments"
+	var args []any
+	if environment != "" {
+		query += " WHERE environment = ?"
+		args = append(args, environment)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY environment, tenant", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query assignments: %w", err)
+	}
+	defer rows.Close()
+
+	assignments := []Assignment{}
+	for rows.Next() {
+		var assignment Assignment
+		var updatedAt string
+		if err := rows.Scan(&assignment.Environment, &assignment.Tenant, &assignment.Profile, &assignment.UpdatedBy, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan assignment: %w", err)
+		}
+		assignment.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
+
+// Assign assigns a profile to a tenant of an environment, to the
+// environment's default with an empty tenant
+func (s *Store) Assign(ctx context.Context, environment, tenant, profile, by string) error {
+	if _, err := s.Profile(ctx, profile); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO rate_limit_profile_assignments (environment, tenant, profile, updated_by) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE profile = VALUES(profile), updated_by = VALUES(updated_by)`,
+		environment, tenant, profile, by)
+	if err != nil {
+		return fmt.Errorf("failed to store assignment: %w", err)
+	}
+
+	log.Printf("Rate-limit profile %s assigned to %s by %s", profile, describe(environment, tenant), by)
+	return s.refresh(ctx)
+}
+
+// Unassign removes the assignment of a tenant of an environment, of the
+// environment's default with an empty tenant
+func (s *Store) Unassign(ctx context.Context, environment, tenant, by string) error {
+	result, err := s.db.ExecContext(ctx,
+		"DELETE FROM rate_limit_profile_assignments WHERE environment = ? AND tenant = ?",
+		environment, tenant)
+	if err != nil {
+		return fmt.Errorf("failed to delete assignment: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return fmt.Errorf("assignment of %s: %w", describe(environment, tenant), ErrNotFound)
+	}
+
+	log.Printf("Rate-limit profile assignment of %s removed by %s", describe(environment, tenant), by)
+	return s.refresh(ctx)
+}
+
+// validate checks a profile only sets non-negative limits for known priority levels
+func (s *Store) validate(profile Profile) error {
+	if profile.Name == "" || len(profile.Name) > 64 {
+		return fmt.Errorf("%w: name must be between 1 and 64 characters", ErrInvalid)
+	}
+	if profile.WindowSeconds < 0 {
+		return fmt.Errorf("%w: window_seconds must not be negative", ErrInvalid)
+	}
+	for priority, limit := range profile.Limits {
+		if !slices.Contains(s.priorities, priority) {
+			return fmt.Errorf("%w: unknown priority level %s, expected one of %v", ErrInvalid, priority, s.priorities)
+		}
+		if limit < 0 {
+			return fmt.Errorf("%w: limit of %s must not be negative", ErrInvalid, priority)
+		}
+	}
+	for eventType, limit := range profile.EventTypeLimits {
+		if eventType == "" || limit < 0 {
+			return fmt.Errorf("%w: event type limits need an event type and a non-negative limit", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// Close stops refreshing and closes the database
+func (s *Store) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.db.Close()
+}
+
+// unmarshalLimits decodes the JSON limit columns into a profile
+func unmarshalLimits(profile *Profile, priorityLimits, eventTypeLimits []byte) error {
+	if err := json.Unmarshal(priorityLimits, &profile.Limits); err != nil {
+		return fmt.Errorf("failed to unmarshal limits: %w", err)
+	}
+	if err := json.Unmarshal(eventTypeLimits, &profile.EventTypeLimits); err != nil {
+		return fmt.Errorf("failed to unmarshal event type limits: %w", err)
+	}
+	return nil
+}
+
+// nonNil returns the limits, an empty map for nil so the column holds an object
+func nonNil(limits map[string]int) map[string]int {
+	if limits == nil {
+		return map[string]int{}
+	}
+	return limits
+}
+
+// describe names the target of an assignment for logs
+func describe(environment, tenant string) string {
+	if tenant == "" {
+		return "environment " + environment
+	}
+	return fmt.Sprintf("tenant %s in environment %s", tenant, environment)
+}
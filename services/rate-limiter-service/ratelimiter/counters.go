@@ -0,0 +1,183 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Algorithms the limiter counts notifications with
+const (
+	// One sorted set member per notification, exact but memory hungry
+	AlgorithmSlidingLog = "sliding_log"
+	// A hash of per-sub-window counts, the oldest sub-window weighted by how
+	// much of it is still inside the window; approximate but tiny
+	AlgorithmSlidingWindowCounter = "sliding_window_counter"
+)
+
+// Suffix of the keys holding sliding window counters, keeping them apart
+// from sorted sets of the same counter while switching algorithms
+const bucketsSuffix = ":buckets"
+
+// counter stores how many notifications were counted under a key within the window
+type counter interface {
+	// count returns the notifications counted within the window ending now and
+	// when the oldest of them leaves the window, now when there are none
+	count(ctx context.Context, key string, now time.Time, window time.Duration) (int, time.Time, error)
+	// add counts one notification per member
+	add(ctx context.Context, key string, members []string, now time.Time, window time.Duration) error
+	// remove takes back members counted by add
+	remove(ctx context.Context, key string, members []string, now time.Time, window time.Duration) error
+}
+
+// slidingLog keeps every notification in a sorted set scored by its time
+type slidingLog struct {
+	client *redis.Client
+}
+
+func (l *slidingLog) count(ctx context.Context, key string, now time.Time, window time.Duration) (int, time.Time, error) {
+	// Remove counts outside the window (using ZREMRANGEBYSCORE)
+	windowStart := now.Add(-window).Unix()
+	pipe := l.client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(windowStart, 10))
+	count := pipe.ZCard(ctx, key)
+	oldest := pipe.ZRangeWithScores(ctx, key, 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	resetAt := now
+	if entries := oldest.Val(); len(entries) > 0 {
+		resetAt = time.Unix(int64(entries[0].Score), 0).Add(window)
+	}
+	return int(count.Val()), resetAt, nil
+}
+
+func (l *slidingLog) add(ctx context.Context, key string, members []string, now time.Time, window time.Duration) error {
+	// Members are notification IDs so entries are unique and can be refunded
+	entries := make([]redis.Z, len(members))
+	for i, member := range members {
+		entries[i] = redis.Z{Score: float64(now.Unix()), Member: member}
+	}
+
+	pipe := l.client.TxPipeline()
+	pipe.ZAdd(ctx, key, entries...)
+	// Set expiration on the key to auto-cleanup
+	pipe.Expire(ctx, key, 2*window)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (l *slidingLog) remove(ctx context.Context, key string, members []string, now time.Time, window time.Duration) error {
+	args := make([]any, len(members))
+	for i, member := range members {
+		args[i] = member
+	}
+	return l.client.ZRem(ctx, key, args...).Err()
+}
+
+// slidingWindowCounter splits the window into buckets and keeps one count per
+// bucket in a hash, so memory no longer grows with the number of notifications.
+// Buckets are sized after the configured window, so a key keeps its buckets
+// when it is counted over a longer or shorter window.
+type slidingWindowCounter struct {
+	client  *redis.Client
+	window  time.Duration
+	buckets int
+}
+
+// bucketSize returns the length of a bucket in milliseconds
+func (c *slidingWindowCounter) bucketSize() int64 {
+	return c.window.Milliseconds() / int64(c.buckets)
+}
+
+// bucketsIn returns how many buckets make up a window, at least one
+func (c *slidingWindowCounter) bucketsIn(window time.Duration) int64 {
+	return max(window.Milliseconds()/c.bucketSize(), 1)
+}
+
+// ttl returns how long a key counted over a window is kept: until the bucket
+// counted last has left that window, however the buckets are sized
+func (c *slidingWindowCounter) ttl(window time.Duration) time.Duration {
+	return max(2*window, time.Duration((c.bucketsIn(window)+2)*c.bucketSize())*time.Millisecond)
+}
+
+// bucketOf returns the index of the bucket a time falls in and how far into it the time is
+func (c *slidingWindowCounter) bucketOf(now time.Time) (int64, float64) {
+	size := c.bucketSize()
+	ms := now.UnixMilli()
+	return ms / size, float64(ms%size) / float64(size)
+}
+
+func (c *slidingWindowCounter) count(ctx context.Context, key string, now time.Time, window time.Duration) (int, time.Time, error) {
+	counts, err := c.client.HGetAll(ctx, key+bucketsSuffix).Result()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	current, elapsed := c.bucketOf(now)
+	oldest := current - c.bucketsIn(window)
+
+	var total float64
+	var expired []string
+	first := current + 1 // Oldest bucket still holding counts
+	for field, value := range counts {
+		bucket, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case bucket > oldest:
+			total += n
+		case bucket == oldest:
+			// Only the part of the oldest bucket that is still inside the window counts
+			total += n * (1 - elapsed)
+		default:
+			expired = append(expired, field)
+			continue
+		}
+		if n > 0 && bucket < first {
+			first = bucket
+		}
+	}
+
+	if len(expired) > 0 {
+		if err := c.client.HDel(ctx, key+bucketsSuffix, expired...).Err(); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+
+	// A bucket has left the window once the window starts after its end
+	resetAt := now
+	if first <= current {
+		resetAt = time.UnixMilli((first + 1) * c.bucketSize()).Add(window)
+	}
+	return int(total), resetAt, nil
+}
+
+func (c *slidingWindowCounter) add(ctx context.Context, key string, members []string, now time.Time, window time.Duration) error {
+	return c.increment(ctx, key, now, window, int64(len(members)))
+}
+
+// remove takes the members back from the current bucket; the window's
+// total is the same as long as the bucket they were added to is still inside it
+func (c *slidingWindowCounter) remove(ctx context.Context, key string, members []string, now time.Time, window time.Duration) error {
+	return c.increment(ctx, key, now, window, -int64(len(members)))
+}
+
+func (c *slidingWindowCounter) increment(ctx context.Context, key string, now time.Time, window time.Duration, by int64) error {
+	current, _ := c.bucketOf(now)
+
+	pipe := c.client.TxPipeline()
+	pipe.HIncrBy(ctx, key+bucketsSuffix, strconv.FormatInt(current, 10), by)
+	pipe.Expire(ctx, key+bucketsSuffix, c.ttl(window))
+	_, err := pipe.Exec(ctx)
+	return err
+}
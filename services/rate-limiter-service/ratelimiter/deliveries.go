@@ -0,0 +1,69 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DeliveryLog remembers the notifications that reached the delivery topic, so
+// copies of an expanded event produced again after a retried expansion are
+// only delivered once
+type DeliveryLog struct {
+	client *redis.Client
+	ttl    time.Duration
+	keys   Keys
+}
+
+// DeliveryLogConfig for the delivery log
+type DeliveryLogConfig struct {
+	Addr            string
+	Password        string
+	CurrentPassword func() string // Optional, read for every new connection so a rotated password is used
+	DB              int
+	TTL             time.Duration // How long a delivery is remembered
+	Keys            Keys
+}
+
+// NewDeliveryLog creates a Redis-backed delivery log
+func NewDeliveryLog(config DeliveryLogConfig) (*DeliveryLog, error) {
+	client := redis.NewClient(RedisOptions(config.Addr, config.Password, config.DB, config.CurrentPassword))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &DeliveryLog{
+		client: client,
+		ttl:    config.TTL,
+		keys:   config.Keys,
+	}, nil
+}
+
+// Delivered reports whether the notification reached the delivery topic before
+func (l *DeliveryLog) Delivered(ctx context.Context, notificationID string) (bool, error) {
+	n, err := l.client.Exists(ctx, l.keys.Delivered(notificationID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to look up delivery: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Record remembers that the notification reached the delivery topic
+func (l *DeliveryLog) Record(ctx context.Context, notificationID string) error {
+	if err := l.client.Set(ctx, l.keys.Delivered(notificationID), 1, l.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to record delivery: %w", err)
+	}
+	return nil
+}
+
+// Close closes the Redis connection
+func (l *DeliveryLog) Close() error {
+	return l.client.Close()
+}
@@ -0,0 +1,85 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+)
+
+// GlobalLimiter caps the notifications all instances together send to
+// delivery per second, protecting downstream providers during storms
+type GlobalLimiter struct {
+	client *redis.Client
+	limit  int  // Notifications per second across the cluster
+	keys   Keys // Names the counter keys
+}
+
+// GlobalConfig for the global limiter
+type GlobalConfig struct {
+	Addr            string
+	Password        string
+	CurrentPassword func() string // Optional, read for every new connection so a rotated password is used
+	DB              int
+	Limit           int // Notifications per second across the cluster
+	Keys            Keys
+}
+
+// NewGlobalLimiter creates a Redis-backed global limiter
+func NewGlobalLimiter(config GlobalConfig) (*GlobalLimiter, error) {
+	if config.Limit <= 0 {
+		return nil, fmt.Errorf("global limit must be positive")
+	}
+
+	client := redis.NewClient(RedisOptions(config.Addr, config.Password, config.DB, config.CurrentPassword))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &GlobalLimiter{
+		client: client,
+		limit:  config.Limit,
+		keys:   config.Keys,
+	}, nil
+}
+
+// Wait blocks until the cluster has room for one more notification in the
+// current second. Notifications are delayed, never dropped.
+func (g *GlobalLimiter) Wait(ctx context.Context) error {
+	for {
+		second := time.Now().Unix()
+		key := g.keys.Global(second)
+
+		// One counter per second, kept just long enough for every instance to see it
+		pipe := g.client.TxPipeline()
+		count := pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, 2*time.Second)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to increment global counter: %w", err)
+		}
+
+		if count.Val() <= int64(g.limit) {
+			return nil
+		}
+
+		// Budget spent, retry in the next second
+		metrics.GlobalThrottled.Inc()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(time.Unix(second+1, 0))):
+		}
+	}
+}
+
+// Close closes the Redis connection
+func (g *GlobalLimiter) Close() error {
+	return g.client.Close()
+}
@@ -0,0 +1,209 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Keys names the Redis keys of the limiters
+type Keys struct {
+	Prefix   string // Namespace kept apart from other applications sharing the Redis, e.g. "notifications:"
+	HashTags bool   // Wrap user IDs in {} so all counters of a user share a Redis Cluster slot
+}
+
+// User returns the key of a user's counter
+func (k Keys) User(userID string) string {
+	return k.Prefix + "rate:user:" + k.tag(userID)
+}
+
+// UserEvent returns the key of a user's counter for one event type
+func (k Keys) UserEvent(userID, eventType string) string {
+	return k.User(userID) + ":event:" + eventType
+}
+
+// userEvents returns the pattern matching all of a user's event type counters
+func (k Keys) userEvents(userID string) string {
+	return k.User(userID) + ":event:*"
+}
+
+// Global returns the key of the cluster-wide counter of a second
+func (k Keys) Global(second int64) string {
+	return fmt.Sprintf("%srate:global:%d", k.Prefix, second)
+}
+
+// Engagement returns the key of a user's per-channel send and engagement counts
+func (k Keys) Engagement(userID string) string {
+	return k.Prefix + "engagement:user:" + k.tag(userID)
+}
+
+// EngagementSeen returns the key marking that a notification's engagement on a channel was counted
+func (k Keys) EngagementSeen(notificationID, channel string) string {
+	return k.Prefix + "engagement:seen:" + notificationID + ":" + channel
+}
+
+// Spacing returns the key of the reservation of a channel to a user
+func (k Keys) Spacing(userID, channel string) string {
+	return k.Prefix + "spacing:user:" + k.tag(userID) + ":" + channel
+}
+
+// WarmUp returns the key of the deliveries counted for a warming up sending identity on a day
+func (k Keys) WarmUp(identity, day string) string {
+	return k.Prefix + "warmup:" + identity + ":" + day
+}
+
+// DeliveryStats returns the key of a user's last deliveries by event type and channel
+func (k Keys) DeliveryStats(userID string) string {
+	return k.Prefix + "stats:user:" + k.tag(userID)
+}
+
+// DeliveryStatsDay returns the key of a user's per-channel delivery counts of a day
+func (k Keys) DeliveryStatsDay(userID, day string) string {
+	return k.DeliveryStats(userID) + ":sent:" + day
+}
+
+// Delivered returns the key marking that a notification reached the delivery topic
+func (k Keys) Delivered(notificationID string) string {
+	return k.Prefix + "delivered:" + notificationID
+}
+
+// tag wraps a user ID in a hash tag when enabled
+func (k Keys) tag(userID string) string {
+	if k.HashTags {
+		return "{" + userID + "}"
+	}
+	return userID
+}
+
+// parse extracts the user and event type from a per-user counter key
+func (k Keys) parse(key string) (userID, eventType string, ok bool) {
+	rest, found := strings.CutPrefix(key, k.Prefix+"rate:user:")
+	if !found {
+		return "", "", false
+	}
+	if k.HashTags {
+		if !strings.HasPrefix(rest, "{") {
+			return "", "", false
+		}
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return "", "", false
+		}
+		userID, rest = rest[1:end], rest[end+1:]
+		if rest == "" {
+			return userID, "", true
+		}
+		eventType, found = strings.CutPrefix(rest, ":event:")
+		return userID, eventType, found
+	}
+
+	userID, eventType, _ = strings.Cut(rest, ":event:")
+	return userID, eventType, true
+}
+
+// KeyMigrator is implemented by the limiters whose counters can move between key schemes
+type KeyMigrator interface {
+	MigrateKeys(ctx context.Context, from Keys) (int, error)
+}
+
+// MigrateKeys moves the per-user counters named by another key scheme to the
+// limiter's, keeping their entries and expiry. Counters are copied rather
+// than renamed, because in Redis Cluster the new key may live in another slot.
+// Returns the number of counters moved.
+func (r *RedisRateLimiter) MigrateKeys(ctx context.Context, from Keys) (int, error) {
+	if from == r.keys {
+		return 0, nil
+	}
+
+	moved := 0
+	iter := r.client.Scan(ctx, 0, from.Prefix+"rate:user:*", 1000).Iterator()
+	for iter.Next(ctx) {
+		oldKey := iter.Val()
+
+		// Sliding window counters live next to the sorted sets under a suffix
+		counterKey, buckets := strings.CutSuffix(oldKey, bucketsSuffix)
+
+		// The old pattern also matches keys already migrated when the schemes share a prefix
+		if _, _, migrated := r.keys.parse(counterKey); migrated {
+			continue
+		}
+		userID, eventType, ok := from.parse(counterKey)
+		if !ok {
+			continue
+		}
+
+		newKey := r.keys.User(userID)
+		if eventType != "" {
+			newKey = r.keys.UserEvent(userID, eventType)
+		}
+		if newKey == counterKey {
+			continue
+		}
+
+		move := r.moveCounter
+		if buckets {
+			newKey += bucketsSuffix
+			move = r.moveBuckets
+		}
+		if err := move(ctx, oldKey, newKey); err != nil {
+			return moved, fmt.Errorf("failed to migrate %s: %w", oldKey, err)
+		}
+		moved++
+	}
+	if err := iter.Err(); err != nil {
+		return moved, fmt.Errorf("failed to scan counters: %w", err)
+	}
+
+	return moved, nil
+}
+
+// moveCounter copies a counter's entries and expiry to a new key and deletes the old one
+func (r *RedisRateLimiter) moveCounter(ctx context.Context, oldKey, newKey string) error {
+	entries, err := r.client.ZRangeWithScores(ctx, oldKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	ttl, err := r.client.PTTL(ctx, oldKey).Result()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		if err := r.client.ZAdd(ctx, newKey, entries...).Err(); err != nil {
+			return err
+		}
+		// Without an expiry the next count sets one from the window it is counted over
+		if ttl > 0 {
+			if err := r.client.PExpire(ctx, newKey, ttl).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return r.client.Del(ctx, oldKey).Err()
+}
+
+// moveBuckets copies a sliding window counter's buckets and expiry to a new key and deletes the old one
+func (r *RedisRateLimiter) moveBuckets(ctx context.Context, oldKey, newKey string) error {
+	buckets, err := r.client.HGetAll(ctx, oldKey).Result()
+	if err != nil {
+		return err
+	}
+	ttl, err := r.client.PTTL(ctx, oldKey).Result()
+	if err != nil {
+		return err
+	}
+
+	if len(buckets) > 0 {
+		if err := r.client.HSet(ctx, newKey, buckets).Err(); err != nil {
+			return err
+		}
+		if ttl > 0 {
+			if err := r.client.PExpire(ctx, newKey, ttl).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return r.client.Del(ctx, oldKey).Err()
+}
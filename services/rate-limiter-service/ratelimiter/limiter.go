@@ -3,151 +3,328 @@ package ratelimiter
 import (
 	"context"
 	"fmt"
-	"log"
-	"strconv"
+	"hash/fnv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
 	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// How notifications of a priority or event type are treated by the limiter
+const (
+	// Checked against the user's limits and counted toward the quota
+	ModeCounted = "counted"
+	// Never limited and not counted toward the quota, only observed in metrics
+	ModeObserved = "observed"
 )
 
 // RateLimiter for controlling notification rate
 type RateLimiter interface {
-	IsRateLimited(ctx context.Context, notification *models.PrioritizedNotification) (bool, error)
+	// IsRateLimited checks the notification against the user's quota and counts it when allowed
+	IsRateLimited(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error)
+	// Quota reports the user's quota for notifications like this one without counting it
+	Quota(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error)
+	// Refund releases the quota consumed by a notification that was counted but never delivered
+	Refund(ctx context.Context, notification *models.PrioritizedNotification) error
+	// DeleteUserData removes all counters kept for a user
+	DeleteUserData(ctx context.Context, userID string) error
+	// Ping checks the backing store is reachable, for readiness
+	Ping(ctx context.Context) error
 	Close() error
 }
 
+// Limits is a set of rate limits notifications can be checked against
+type Limits struct {
+	Window     time.Duration  // Zero keeps the configured window
+	Priorities map[string]int // Notifications per window by priority level
+	EventTypes map[string]int // Notifications of an event type per window, on top of the priority's limit
+}
+
+// LimitSource supplies limits by tenant that take precedence over the
+// configured ones, so they can be tuned without a redeploy
+type LimitSource interface {
+	// LimitsFor returns the limits of a tenant, nil when the configured limits apply
+	LimitsFor(tenant string) *Limits
+}
+
 // RedisRateLimiter implements rate limiting using Redis
 type RedisRateLimiter struct {
-	client        *redis.Client
-	windowSeconds int           // Time window for rate limiting in seconds
-	limits        map[string]int // Limits per priority level
+	client          *redis.Client
+	windowSeconds   int               // Time window for rate limiting in seconds
+	limits          map[string]int    // Limits per priority level
+	eventTypeLimits map[string]int    // Limits per event type, on top of the priority's
+	source          LimitSource       // Optional limits by tenant overriding the configured ones
+	defaultPriority string            // Priority whose limit applies to unknown levels
+	keys            Keys              // Names the counter keys
+	counter         counter           // Stores the counts, depending on the algorithm
+	priorityModes   map[string]string // Modes per priority level, counted when missing
+	eventTypeModes  map[string]string // Modes per event type, take precedence over the priority's
+	now             func() time.Time  // Clock the windows are measured with
+	reserving       userLocks         // Serializes the reservations of a user within the instance
+}
+
+// userLocks is a fixed set of locks users are spread over by their counter key
+type userLocks [64]sync.Mutex
+
+// lock locks the key's lock and returns its unlock
+func (l *userLocks) lock(key string) func() {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	mu := &l[hash.Sum32()%uint32(len(l))]
+	mu.Lock()
+	return mu.Unlock
 }
 
 // Config for Redis rate limiter
 type Config struct {
-	Addr          string
-	Password      string
-	DB            int
-	WindowSeconds int
-	LimitHigh     int
-	LimitMedium   int
-	LimitLow      int
+	Addr            string
+	Password        string
+	CurrentPassword func() string // Optional, read for every new connection so a rotated password is used
+	DB              int
+	WindowSeconds   int
+	Limits          map[string]int    // Limits keyed by priority level
+	EventTypeLimits map[string]int    // Limits keyed by event type, event types without one are only limited by priority
+	Source          LimitSource       // Optional limits by tenant, taking precedence over Limits and EventTypeLimits
+	DefaultPriority string            // Used when a notification carries an unknown priority
+	Keys            Keys              // Keeps counters apart from other limiters sharing the Redis
+	Algorithm       string            // AlgorithmSlidingLog or AlgorithmSlidingWindowCounter
+	Buckets         int               // Sub-windows of the sliding window counter
+	PriorityModes   map[string]string // ModeCounted or ModeObserved keyed by priority level
+	EventTypeModes  map[string]string // ModeCounted or ModeObserved keyed by event type
 }
 
 // NewRedisRateLimiter creates a new Redis-based rate limiter
 func NewRedisRateLimiter(config Config) (RateLimiter, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     config.Addr,
-		Password: config.Password,
-		DB:       config.DB,
-	})
+	client := redis.NewClient(RedisOptions(config.Addr, config.Password, config.DB, config.CurrentPassword))
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
+	window := time.Duration(config.WindowSeconds) * time.Second
+	var store counter = &slidingLog{client: client}
+	if config.Algorithm == AlgorithmSlidingWindowCounter {
+		store = &slidingWindowCounter{client: client, window: window, buckets: config.Buckets}
+	}
+
 	return &RedisRateLimiter{
-		client:        client,
-		windowSeconds: config.WindowSeconds,
-		limits: map[string]int{
-			models.PriorityHigh:   config.LimitHigh,
-			models.PriorityMedium: config.LimitMedium,
-			models.PriorityLow:    config.LimitLow,
-		},
+		client:          client,
+		windowSeconds:   config.WindowSeconds,
+		limits:          config.Limits,
+		eventTypeLimits: config.EventTypeLimits,
+		source:          config.Source,
+		defaultPriority: config.DefaultPriority,
+		keys:            config.Keys,
+		counter:         store,
+		priorityModes:   config.PriorityModes,
+		eventTypeModes:  config.EventTypeModes,
+		now:             time.Now,
 	}, nil
 }
 
-// IsRateLimited checks if the notification exceeds rate limits
-func (r *RedisRateLimiter) IsRateLimited(ctx context.Context, notification *models.PrioritizedNotification) (bool, error) {
+// IsRateLimited checks if the notification exceeds rate limits, counting it when it doesn't
+func (r *RedisRateLimiter) IsRateLimited(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error) {
+	// Exempt notifications neither wait for nor use up the user's quota
+	if r.modeOf(notification) == ModeObserved {
+		metrics.RateLimitObserved.WithLabelValues(notification.Priority, notification.EventType).Inc()
+		return models.RateLimitResult{Exempt: true}, nil
+	}
+
+	_, result, err := r.reserve(ctx, notification, []string{notification.ID})
+	return result, err
+}
+
+// Quota returns the quota a notification would be checked against, without counting it
+func (r *RedisRateLimiter) Quota(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error) {
+	if r.modeOf(notification) == ModeObserved {
+		return models.RateLimitResult{Exempt: true}, nil
+	}
+
+	_, result, err := r.reserve(ctx, notification, nil)
+	return result, err
+}
+
+// reserve counts as many of the members as the notification's limits allow,
+// in order, and returns how many were counted and the quota left after them.
+// Without members it only reports the quota.
+//
+// Counts are read and added in separate round trips. Reservations of a user
+// are serialized within the instance, so its lanes never overshoot a limit
+// together. Instances don't wait for each other: with N instances reserving
+// for a user at once, each may miss the members the N-1 others are adding,
+// so a window holds at most the limit plus N-1 reservations, i.e. N-1
+// notifications, or N-1 slices with the local limiter.
+func (r *RedisRateLimiter) reserve(ctx context.Context, notification *models.PrioritizedNotification, members []string) (int, models.RateLimitResult, error) {
 	// Define keys for different granularities
-	userKey := fmt.Sprintf("rate:user:%s", notification.UserID)
-	eventTypeKey := fmt.Sprintf("rate:user:%s:event:%s", notification.UserID, notification.EventType)
-	
-	// Current time for window calculation
-	now := time.Now().Unix()
-	windowStart := now - int64(r.windowSeconds)
-	
-	// Remove counts outside the window (using ZREMRANGEBYSCORE)
-	if err := r.cleanupOldEntries(ctx, userKey, windowStart); err != nil {
-		return false, fmt.Errorf("failed to clean up old entries: %w", err)
-	}
-	
-	if err := r.cleanupOldEntries(ctx, eventTypeKey, windowStart); err != nil {
-		return false, fmt.Errorf("failed to clean up old entries: %w", err)
-	}
-	
+	userKey, eventTypeKey := r.counterKeys(notification)
+	if len(members) > 0 {
+		defer r.reserving.lock(userKey)()
+	}
+	window, limit, eventTypeLimit := r.limitsOf(notification)
+
+	now := r.now()
+
 	// Get current count for user
-	userCount, err := r.getCurrentCount(ctx, userKey)
+	userCount, userReset, err := r.counter.count(ctx, userKey, now, window)
 	if err != nil {
-		return false, fmt.Errorf("failed to get user count: %w", err)
+		return 0, models.RateLimitResult{}, fmt.Errorf("failed to get user count: %w", err)
 	}
 
 	// Get current count for event type
-	eventTypeCount, err := r.getCurrentCount(ctx, eventTypeKey)
+	eventTypeCount, eventTypeReset, err := r.counter.count(ctx, eventTypeKey, now, window)
 	if err != nil {
-		return false, fmt.Errorf("failed to get event type count: %w", err)
+		return 0, models.RateLimitResult{}, fmt.Errorf("failed to get event type count: %w", err)
 	}
-	
+
 	// Check if user has exceeded their limit
-	limit := r.getLimitForPriority(notification.Priority)
-	
+	result := models.RateLimitResult{
+		Remaining: max(limit-userCount, 0),
+		ResetAt:   userReset.Unix(),
+	}
+
 	if userCount >= limit {
-		log.Printf("User %s rate limited (count: %d, limit: %d)", 
-			notification.UserID, userCount, limit)
-		return true, nil
-	}
-	
-	// Additional check for specific event types (e.g., limit "like" notifications)
-	if eventTypeCount >= 20 && notification.EventType == "like" {
-		log.Printf("User %s rate limited for event type %s (count: %d, limit: 20)", 
-			notification.UserID, notification.EventType, eventTypeCount)
-		return true, nil
-	}
-	
+		if len(members) > 0 {
+			logging.ForRequest(notification.RequestID).Printf("User %s rate limited (count: %d, limit: %d)",
+				notification.UserID, userCount, limit)
+		}
+		result.Limited = true
+		return 0, result, nil
+	}
+
+	// Additional check for event types with a limit of their own (e.g., limit "like" notifications)
+	if eventTypeLimit >= 0 && eventTypeLimit-eventTypeCount < result.Remaining {
+		result.Remaining = max(eventTypeLimit-eventTypeCount, 0)
+		result.ResetAt = eventTypeReset.Unix()
+		if eventTypeCount >= eventTypeLimit {
+			if len(members) > 0 {
+				logging.ForRequest(notification.RequestID).Printf("User %s rate limited for event type %s (count: %d, limit: %d)",
+					notification.UserID, notification.EventType, eventTypeCount, eventTypeLimit)
+			}
+			result.Limited = true
+			return 0, result, nil
+		}
+	}
+	if len(members) == 0 {
+		return 0, result, nil
+	}
+	granted := min(len(members), result.Remaining)
+	members = members[:granted]
+
 	// Increment counters
-	if err := r.incrementCounter(ctx, userKey, now); err != nil {
-		return false, fmt.Errorf("failed to increment user counter: %w", err)
+	if err := r.counter.add(ctx, userKey, members, now, window); err != nil {
+		return 0, models.RateLimitResult{}, fmt.Errorf("failed to increment user counter: %w", err)
 	}
-	
-	if err := r.incrementCounter(ctx, eventTypeKey, now); err != nil {
-		return false, fmt.Errorf("failed to increment event type counter: %w", err)
+
+	if err := r.counter.add(ctx, eventTypeKey, members, now, window); err != nil {
+		return 0, models.RateLimitResult{}, fmt.Errorf("failed to increment event type counter: %w", err)
 	}
-	
-	return false, nil
+
+	// A window that was empty resets one window after the first notification
+	result.Remaining -= granted
+	if result.ResetAt <= now.Unix() {
+		result.ResetAt = now.Add(window).Unix()
+	}
+	return granted, result, nil
 }
 
-// cleanupOldEntries removes entries outside the current time window
-func (r *RedisRateLimiter) cleanupOldEntries(ctx context.Context, key string, windowStart int64) error {
-	_, err := r.client.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(windowStart, 10)).Result()
-	return err
+// Refund takes the notification back from the counters it was added to
+func (r *RedisRateLimiter) Refund(ctx context.Context, notification *models.PrioritizedNotification) error {
+	return r.release(ctx, notification, []string{notification.ID})
 }
 
-// getCurrentCount gets the current count of items in the sorted set
-func (r *RedisRateLimiter) getCurrentCount(ctx context.Context, key string) (int, error) {
-	count, err := r.client.ZCard(ctx, key).Result()
-	if err != nil {
-		return 0, err
+// release takes members back from the counters of a notification
+func (r *RedisRateLimiter) release(ctx context.Context, notification *models.PrioritizedNotification, members []string) error {
+	userKey, eventTypeKey := r.counterKeys(notification)
+	window, _, _ := r.limitsOf(notification)
+	now := r.now()
+
+	if err := r.counter.remove(ctx, userKey, members, now, window); err != nil {
+		return fmt.Errorf("failed to refund user counter: %w", err)
 	}
-	return int(count), nil
+
+	if err := r.counter.remove(ctx, eventTypeKey, members, now, window); err != nil {
+		return fmt.Errorf("failed to refund event type counter: %w", err)
+	}
+
+	return nil
 }
 
-// incrementCounter adds a new entry to the sorted set with the timestamp as score
-func (r *RedisRateLimiter) incrementCounter(ctx context.Context, key string, timestamp int64) error {
-	// Use timestamp as both score and member to ensure uniqueness
-	member := fmt.Sprintf("%d", timestamp)
-	_, err := r.client.ZAdd(ctx, key, redis.Z{
-		Score:  float64(timestamp),
-		Member: member,
-	}).Result()
-	
-	// Set expiration on the key to auto-cleanup
-	_, err = r.client.Expire(ctx, key, time.Duration(r.windowSeconds*2)*time.Second).Result()
-	return err
+// DeleteUserData removes the user's counter and all of its per-event-type counters
+func (r *RedisRateLimiter) DeleteUserData(ctx context.Context, userID string) error {
+	keys := []string{r.keys.User(userID), r.keys.User(userID) + bucketsSuffix}
+
+	iter := r.client.Scan(ctx, 0, r.keys.userEvents(userID), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan user counters: %w", err)
+	}
+
+	if err := r.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete user counters: %w", err)
+	}
+
+	return nil
+}
+
+// modeOf returns the mode of a notification, its event type's if set and its priority's otherwise
+func (r *RedisRateLimiter) modeOf(notification *models.PrioritizedNotification) string {
+	if mode, exists := r.eventTypeModes[notification.EventType]; exists {
+		return mode
+	}
+	if mode, exists := r.priorityModes[notification.Priority]; exists {
+		return mode
+	}
+	return ModeCounted
+}
+
+// counterKeys returns the per-user and per-user-event-type counter keys of a notification
+func (r *RedisRateLimiter) counterKeys(notification *models.PrioritizedNotification) (string, string) {
+	return r.keys.User(notification.UserID), r.keys.UserEvent(notification.UserID, notification.EventType)
+}
+
+// limitsOf returns the window and the limits a notification is checked
+// against: its tenant's where the limit source sets them, the configured ones
+// otherwise. The event-type limit is negative when the event type has none.
+func (r *RedisRateLimiter) limitsOf(notification *models.PrioritizedNotification) (time.Duration, int, int) {
+	window := time.Duration(r.windowSeconds) * time.Second
+	limit := r.getLimitForPriority(notification.Priority)
+	eventTypeLimit, exists := r.eventTypeLimits[notification.EventType]
+	if !exists {
+		eventTypeLimit = -1
+	}
+	if r.source == nil {
+		return window, limit, eventTypeLimit
+	}
+
+	tenant, _ := notification.Metadata[models.MetadataTenant].(string)
+	tenantLimits := r.source.LimitsFor(tenant)
+	if tenantLimits == nil {
+		return window, limit, eventTypeLimit
+	}
+	if tenantLimits.Window > 0 {
+		window = tenantLimits.Window
+	}
+	priority := notification.Priority
+	if _, known := r.limits[priority]; !known {
+		priority = r.defaultPriority
+	}
+	if tenantLimit, exists := tenantLimits.Priorities[priority]; exists {
+		limit = tenantLimit
+	}
+	if tenantLimit, exists := tenantLimits.EventTypes[notification.EventType]; exists {
+		eventTypeLimit = tenantLimit
+	}
+	return window, limit, eventTypeLimit
 }
 
 // getLimitForPriority returns the rate limit based on notification priority
@@ -155,8 +332,44 @@ func (r *RedisRateLimiter) getLimitForPriority(priority string) int {
 	if limit, exists := r.limits[priority]; exists {
 		return limit
 	}
-	// Default to lowest limit if priority not recognized
-	return r.limits[models.PriorityLow]
+	// Default to the configured fallback level if priority not recognized
+	return r.limits[r.defaultPriority]
+}
+
+// RedisOptions returns the options of a Redis client. With currentPassword
+// set, every new connection authenticates with the password it returns.
+func RedisOptions(addr, password string, db int, currentPassword func() string) *redis.Options {
+	options := &redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	}
+	if currentPassword != nil {
+		options.CredentialsProvider = func() (string, string) {
+			return "", currentPassword()
+		}
+	}
+	return options
+}
+
+// CheckRedis connects to Redis once and pings it, for preflight checks
+func CheckRedis(ctx context.Context, addr, password string, db int) error {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+	defer client.Close()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("Redis at %s not reachable: %w", addr, err)
+	}
+	return nil
+}
+
+// Ping checks Redis is reachable, the client reconnects on its own
+func (r *RedisRateLimiter) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
 }
 
 // Close closes the Redis connection
@@ -170,8 +383,28 @@ type MockRateLimiter struct {
 }
 
 // IsRateLimited checks if notification is rate limited (mock)
-func (m *MockRateLimiter) IsRateLimited(ctx context.Context, notification *models.PrioritizedNotification) (bool, error) {
-	return m.ShouldLimit, nil
+func (m *MockRateLimiter) IsRateLimited(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error) {
+	return models.RateLimitResult{Limited: m.ShouldLimit}, nil
+}
+
+// Quota for mock implementation
+func (m *MockRateLimiter) Quota(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error) {
+	return models.RateLimitResult{Limited: m.ShouldLimit}, nil
+}
+
+// Refund for mock implementation
+func (m *MockRateLimiter) Refund(ctx context.Context, notification *models.PrioritizedNotification) error {
+	return nil
+}
+
+// DeleteUserData for mock implementation
+func (m *MockRateLimiter) DeleteUserData(ctx context.Context, userID string) error {
+	return nil
+}
+
+// Ping for mock implementation
+func (m *MockRateLimiter) Ping(ctx context.Context) error {
+	return nil
 }
 
 // Close for mock implementation
@@ -184,4 +417,4 @@ func NewMockRateLimiter(shouldLimit bool) RateLimiter {
 	return &MockRateLimiter{
 		ShouldLimit: shouldLimit,
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,515 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+const (
+	testWindow  = time.Minute
+	testBuckets = 6
+)
+
+var (
+	testLimits          = map[string]int{models.PriorityHigh: 5, models.PriorityLow: 3}
+	testEventTypeLimits = map[string]int{"like": 2}
+)
+
+// fakeClock is the clock of a limiter under test, moved by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// newTestLimiter creates a limiter over a fake Redis, measuring windows with a fake clock
+func newTestLimiter(t *testing.T, algorithm string) (*RedisRateLimiter, *fakeClock, *fakeRedis) {
+	t.Helper()
+
+	redis := startFakeRedis(t)
+	limiter, err := NewRedisRateLimiter(Config{
+		Addr:            redis.Addr(),
+		WindowSeconds:   int(testWindow.Seconds()),
+		Limits:          testLimits,
+		EventTypeLimits: testEventTypeLimits,
+		DefaultPriority: models.PriorityLow,
+		Keys:            Keys{Prefix: "test:"},
+		Algorithm:       algorithm,
+		Buckets:         testBuckets,
+	})
+	if err != nil {
+		t.Fatalf("creating limiter: %v", err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	r := limiter.(*RedisRateLimiter)
+	r.now = clock.Now
+	return r, clock, redis
+}
+
+func notificationFor(id, userID, priority, eventType string) *models.PrioritizedNotification {
+	notification := &models.PrioritizedNotification{Priority: priority}
+	notification.ID = id
+	notification.UserID = userID
+	notification.EventType = eventType
+	return notification
+}
+
+// grant is a notification the limiter let through, as the tests remember it
+type grant struct {
+	userID    string
+	eventType string
+	at        time.Time
+}
+
+// grantLog is the model the limiter is checked against: every notification
+// let through and not refunded
+type grantLog struct {
+	grants map[string]grant // Keyed by notification ID
+	// inWindow reports whether a grant still counts toward the window ending now
+	inWindow func(at, now time.Time) bool
+}
+
+// count returns the grants of a user, of one event type when set, still in the window
+func (g *grantLog) count(userID, eventType string, now time.Time) int {
+	n := 0
+	for _, grant := range g.grants {
+		if grant.userID == userID && (eventType == "" || grant.eventType == eventType) && g.inWindow(grant.at, now) {
+			n++
+		}
+	}
+	return n
+}
+
+// windowOf returns when a grant counts toward the window ending now, depending
+// on the algorithm: the sliding log keeps the seconds after the window's
+// start, the sliding window counter the buckets entirely inside the window
+func windowOf(algorithm string) func(at, now time.Time) bool {
+	if algorithm == AlgorithmSlidingWindowCounter {
+		size := testWindow.Milliseconds() / testBuckets
+		return func(at, now time.Time) bool {
+			return at.UnixMilli()/size > now.UnixMilli()/size-testBuckets
+		}
+	}
+	return func(at, now time.Time) bool {
+		return at.Unix() > now.Add(-testWindow).Unix()
+	}
+}
+
+func TestLimiterNeverExceedsLimits(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmSlidingLog, AlgorithmSlidingWindowCounter} {
+		for seed := int64(1); seed <= 20; seed++ {
+			t.Run(fmt.Sprintf("%s/seed=%d", algorithm, seed), func(t *testing.T) {
+				checkAgainstModel(t, algorithm, 300, rand.New(rand.NewSource(seed)).Intn)
+			})
+		}
+	}
+}
+
+func FuzzLimiterNeverExceedsLimits(f *testing.F) {
+	f.Add(false, []byte{0, 1, 2, 3, 4, 5, 6, 7})
+	f.Add(true, []byte{9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9})
+	f.Add(false, []byte{255, 0, 255, 0, 17, 34, 51, 68, 85, 102})
+
+	f.Fuzz(func(t *testing.T, counter bool, choices []byte) {
+		algorithm := AlgorithmSlidingLog
+		if counter {
+			algorithm = AlgorithmSlidingWindowCounter
+		}
+		// Every step makes five choices, each taken from the next byte
+		choose := func(n int) int {
+			if len(choices) == 0 {
+				return 0
+			}
+			choice := int(choices[0]) % n
+			choices = choices[1:]
+			return choice
+		}
+		checkAgainstModel(t, algorithm, (len(choices)+4)/5, choose)
+	})
+}
+
+// grantConcurrently sends notifications of a user through the limiters from
+// several goroutines each and returns how many were let through
+func grantConcurrently(t *testing.T, limiters []RateLimiter, perLimiter int, eventType string) int {
+	t.Helper()
+
+	var granted atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i, limiter := range limiters {
+		for j := 0; j < perLimiter; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				notification := notificationFor(fmt.Sprintf("n-%d-%d", i, j), "user-1", models.PriorityHigh, eventType)
+				result, err := limiter.IsRateLimited(context.Background(), notification)
+				if err != nil {
+					t.Errorf("notification %s: %v", notification.ID, err)
+				}
+				if !result.Limited {
+					granted.Add(1)
+				}
+			}()
+		}
+	}
+	close(start)
+	wg.Wait()
+	return int(granted.Load())
+}
+
+func TestLimiterNeverExceedsLimitsConcurrently(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmSlidingLog, AlgorithmSlidingWindowCounter} {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter, _, _ := newTestLimiter(t, algorithm)
+			if granted := grantConcurrently(t, []RateLimiter{limiter}, 50, "comment"); granted != testLimits[models.PriorityHigh] {
+				t.Errorf("let through %d comments from concurrent lanes, want the limit of %d", granted, testLimits[models.PriorityHigh])
+			}
+
+			limiter, _, _ = newTestLimiter(t, algorithm)
+			if granted := grantConcurrently(t, []RateLimiter{limiter}, 50, "like"); granted != testEventTypeLimits["like"] {
+				t.Errorf("let through %d likes from concurrent lanes, want the limit of %d", granted, testEventTypeLimits["like"])
+			}
+		})
+	}
+}
+
+func TestLimitersOvershootByAtMostOneReservationPerOtherInstance(t *testing.T) {
+	const instances = 3
+	for _, algorithm := range []string{AlgorithmSlidingLog, AlgorithmSlidingWindowCounter} {
+		t.Run(algorithm, func(t *testing.T) {
+			first, clock, redis := newTestLimiter(t, algorithm)
+			limiters := []RateLimiter{first}
+			for len(limiters) < instances {
+				other, err := NewRedisRateLimiter(Config{
+					Addr:            redis.Addr(),
+					WindowSeconds:   int(testWindow.Seconds()),
+					Limits:          testLimits,
+					EventTypeLimits: testEventTypeLimits,
+					DefaultPriority: models.PriorityLow,
+					Keys:            Keys{Prefix: "test:"},
+					Algorithm:       algorithm,
+					Buckets:         testBuckets,
+				})
+				if err != nil {
+					t.Fatalf("creating limiter: %v", err)
+				}
+				t.Cleanup(func() { other.Close() })
+				other.(*RedisRateLimiter).now = clock.Now
+				limiters = append(limiters, other)
+			}
+
+			limit := testLimits[models.PriorityHigh]
+			if granted := grantConcurrently(t, limiters, 20, "comment"); granted < limit || granted > limit+instances-1 {
+				t.Errorf("%d instances let through %d notifications, want between %d and %d", instances, granted, limit, limit+instances-1)
+			}
+		})
+	}
+}
+
+// checkAgainstModel runs notifications of random users, priorities and event
+// types through a limiter, refunding some and moving the clock on, and checks
+// every decision against the grants the model remembers
+func checkAgainstModel(t *testing.T, algorithm string, steps int, choose func(n int) int) {
+	t.Helper()
+
+	users := []string{"user-1", "user-2"}
+	priorities := []string{models.PriorityHigh, models.PriorityLow, "unknown"}
+	eventTypes := []string{"like", "comment"}
+
+	limiter, clock, _ := newTestLimiter(t, algorithm)
+	ctx := context.Background()
+	model := &grantLog{grants: make(map[string]grant), inWindow: windowOf(algorithm)}
+
+	for step := 0; step < steps; step++ {
+		userID := users[choose(len(users))]
+		priority := priorities[choose(len(priorities))]
+		eventType := eventTypes[choose(len(eventTypes))]
+		notification := notificationFor(fmt.Sprintf("n-%d", step), userID, priority, eventType)
+
+		userBefore := model.count(userID, "", clock.Now())
+		eventTypeBefore := model.count(userID, eventType, clock.Now())
+
+		result, err := limiter.IsRateLimited(ctx, notification)
+		if err != nil {
+			t.Fatalf("step %d: %v", step, err)
+		}
+		// A notification let through must fit in the limits of its priority and event type
+		limit := limiter.getLimitForPriority(priority)
+		if !result.Limited {
+			model.grants[notification.ID] = grant{userID: userID, eventType: eventType, at: clock.Now()}
+
+			if n := model.count(userID, "", clock.Now()); n > limit {
+				t.Fatalf("step %d: %s let through with %d notifications in the window, %s limit %d", step, notification.ID, n, priority, limit)
+			}
+			if n := model.count(userID, eventType, clock.Now()); eventType == "like" && n > testEventTypeLimits["like"] {
+				t.Fatalf("step %d: %s let through with %d likes in the window, limit %d", step, notification.ID, n, testEventTypeLimits["like"])
+			}
+		}
+
+		// The sliding log is exact, it lets through whatever fits and reports the quota left
+		if algorithm == AlgorithmSlidingLog {
+			remaining := limit - userBefore
+			if eventType == "like" {
+				remaining = min(remaining, testEventTypeLimits["like"]-eventTypeBefore)
+			}
+			if fits := remaining > 0; fits == result.Limited {
+				t.Fatalf("step %d: limited = %v with %d of %s's quota left", step, result.Limited, remaining, userID)
+			}
+			if want := max(remaining-1, 0); result.Remaining != want {
+				t.Fatalf("step %d: remaining = %d, want %d", step, result.Remaining, want)
+			}
+		}
+
+		// Deliveries fail now and then and are refunded right away
+		if !result.Limited && choose(4) == 0 {
+			if err := limiter.Refund(ctx, notification); err != nil {
+				t.Fatalf("step %d: refunding: %v", step, err)
+			}
+			delete(model.grants, notification.ID)
+		}
+
+		clock.Advance(time.Duration(choose(8000)) * time.Millisecond)
+	}
+}
+
+func TestLimiterRefundFreesQuota(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmSlidingLog, AlgorithmSlidingWindowCounter} {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter, _, _ := newTestLimiter(t, algorithm)
+			ctx := context.Background()
+
+			var last *models.PrioritizedNotification
+			for i := 0; i < testLimits[models.PriorityLow]; i++ {
+				last = notificationFor(fmt.Sprintf("n-%d", i), "user-1", models.PriorityLow, "comment")
+				if result, err := limiter.IsRateLimited(ctx, last); err != nil || result.Limited {
+					t.Fatalf("notification %d: limited = %v, %v", i, result.Limited, err)
+				}
+			}
+			over := notificationFor("over", "user-1", models.PriorityLow, "comment")
+			if result, _ := limiter.IsRateLimited(ctx, over); !result.Limited {
+				t.Fatal("notification over the limit was let through")
+			}
+
+			if err := limiter.Refund(ctx, last); err != nil {
+				t.Fatalf("refunding: %v", err)
+			}
+			quota, err := limiter.Quota(ctx, over)
+			if err != nil || quota.Limited || quota.Remaining != 1 {
+				t.Fatalf("quota after refund = %+v, %v, want one left", quota, err)
+			}
+			if result, _ := limiter.IsRateLimited(ctx, over); result.Limited {
+				t.Error("refunded quota wasn't given out again")
+			}
+		})
+	}
+}
+
+func TestLimiterWindowRollsOver(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmSlidingLog, AlgorithmSlidingWindowCounter} {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter, clock, _ := newTestLimiter(t, algorithm)
+			ctx := context.Background()
+			limit := testLimits[models.PriorityHigh]
+			first := clock.Now()
+
+			for i := 0; i < limit; i++ {
+				notification := notificationFor(fmt.Sprintf("n-%d", i), "user-1", models.PriorityHigh, "comment")
+				if result, _ := limiter.IsRateLimited(ctx, notification); result.Limited {
+					t.Fatalf("notification %d was limited", i)
+				}
+			}
+
+			probe := notificationFor("probe", "user-1", models.PriorityHigh, "comment")
+			quota, err := limiter.Quota(ctx, probe)
+			if err != nil || !quota.Limited {
+				t.Fatalf("quota at the limit = %+v, %v, want limited", quota, err)
+			}
+			resetAt := time.Unix(quota.ResetAt, 0)
+			if resetAt.Before(first.Add(testWindow)) {
+				t.Errorf("reset at %v, before the window of the first notification ends at %v", resetAt, first.Add(testWindow))
+			}
+
+			// Still limited until the window of the first notification ends
+			clock.now = first.Add(testWindow - time.Second)
+			if quota, _ := limiter.Quota(ctx, probe); !quota.Limited {
+				t.Errorf("quota a second before the window ends = %+v, want limited", quota)
+			}
+
+			clock.now = resetAt.Add(time.Second)
+			quota, err = limiter.Quota(ctx, probe)
+			if err != nil || quota.Limited || quota.Remaining != limit {
+				t.Errorf("quota after the reset = %+v, %v, want all %d left", quota, err, limit)
+			}
+		})
+	}
+}
+
+func TestLimiterCountsPrioritiesTogether(t *testing.T) {
+	limiter, _, _ := newTestLimiter(t, AlgorithmSlidingLog)
+	ctx := context.Background()
+
+	// Low priority notifications use up the low limit, high priority ones may
+	// go on up to the high limit
+	for i := 0; i < testLimits[models.PriorityLow]; i++ {
+		limiter.IsRateLimited(ctx, notificationFor(fmt.Sprintf("low-%d", i), "user-1", models.PriorityLow, "comment"))
+	}
+	if result, _ := limiter.IsRateLimited(ctx, notificationFor("low", "user-1", models.PriorityLow, "comment")); !result.Limited {
+		t.Error("low priority notification over the low limit was let through")
+	}
+	result, _ := limiter.IsRateLimited(ctx, notificationFor("high", "user-1", models.PriorityHigh, "comment"))
+	if result.Limited || result.Remaining != testLimits[models.PriorityHigh]-testLimits[models.PriorityLow]-1 {
+		t.Errorf("high priority result = %+v", result)
+	}
+}
+
+func TestLimiterExemptsObservedNotifications(t *testing.T) {
+	limiter, _, redis := newTestLimiter(t, AlgorithmSlidingLog)
+	limiter.eventTypeModes = map[string]string{"security": ModeObserved}
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		result, err := limiter.IsRateLimited(ctx, notificationFor(fmt.Sprintf("n-%d", i), "user-1", models.PriorityLow, "security"))
+		if err != nil || result.Limited || !result.Exempt {
+			t.Fatalf("observed notification %d: %+v, %v", i, result, err)
+		}
+	}
+	if keys := redis.keys(); keys != 0 {
+		t.Errorf("observed notifications left %d counters", keys)
+	}
+}
+
+func TestLimiterDeletesUserData(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmSlidingLog, AlgorithmSlidingWindowCounter} {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter, _, redis := newTestLimiter(t, algorithm)
+			ctx := context.Background()
+
+			limiter.IsRateLimited(ctx, notificationFor("n-1", "user-1", models.PriorityLow, "like"))
+			limiter.IsRateLimited(ctx, notificationFor("n-2", "user-1", models.PriorityLow, "comment"))
+			limiter.IsRateLimited(ctx, notificationFor("n-3", "user-2", models.PriorityLow, "like"))
+
+			if err := limiter.DeleteUserData(ctx, "user-1"); err != nil {
+				t.Fatalf("deleting: %v", err)
+			}
+			// Only user-2's counters are left, one per user and one per event type
+			if keys := redis.keys(); keys != 2 {
+				t.Errorf("%d counters left, want user-2's 2", keys)
+			}
+			quota, _ := limiter.Quota(ctx, notificationFor("probe", "user-1", models.PriorityLow, "like"))
+			if quota.Remaining != testEventTypeLimits["like"] {
+				t.Errorf("quota after deletion = %+v, want a fresh one", quota)
+			}
+		})
+	}
+}
+
+func TestLocalLimiterNeverExceedsLimits(t *testing.T) {
+	redis := startFakeRedis(t)
+	limiter, err := NewLocalLimiter(Config{
+		Addr:            redis.Addr(),
+		WindowSeconds:   int(testWindow.Seconds()),
+		Limits:          testLimits,
+		EventTypeLimits: testEventTypeLimits,
+		DefaultPriority: models.PriorityLow,
+	}, LocalConfig{Slice: 2, SyncInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("creating limiter: %v", err)
+	}
+	defer limiter.Close()
+	ctx := context.Background()
+
+	granted := 0
+	var refunded bool
+	for i := 0; i < 3*testLimits[models.PriorityHigh]; i++ {
+		notification := notificationFor(fmt.Sprintf("n-%d", i), "user-1", models.PriorityHigh, "comment")
+		result, err := limiter.IsRateLimited(ctx, notification)
+		if err != nil {
+			t.Fatalf("notification %d: %v", i, err)
+		}
+		if result.Limited {
+			continue
+		}
+		granted++
+
+		// A refunded token goes back to the cache and is handed out again
+		if !refunded {
+			if err := limiter.Refund(ctx, notification); err != nil {
+				t.Fatalf("refunding: %v", err)
+			}
+			refunded = true
+			granted--
+		}
+	}
+
+	if granted != testLimits[models.PriorityHigh] {
+		t.Errorf("let through %d notifications, want the limit of %d", granted, testLimits[models.PriorityHigh])
+	}
+}
+
+func TestLocalLimiterRefundsAfterTheSliceRotates(t *testing.T) {
+	redis := startFakeRedis(t)
+	limiter, err := NewLocalLimiter(Config{
+		Addr:            redis.Addr(),
+		WindowSeconds:   int(testWindow.Seconds()),
+		Limits:          testLimits,
+		DefaultPriority: models.PriorityLow,
+	}, LocalConfig{Slice: 1, SyncInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("creating limiter: %v", err)
+	}
+	defer limiter.Close()
+	ctx := context.Background()
+
+	// Every notification reserves a slice of its own, replacing the previous one
+	first := notificationFor("n-0", "user-1", models.PriorityHigh, "comment")
+	second := notificationFor("n-1", "user-1", models.PriorityHigh, "comment")
+	for _, notification := range []*models.PrioritizedNotification{first, second} {
+		if result, err := limiter.IsRateLimited(ctx, notification); err != nil || result.Limited {
+			t.Fatalf("notification %s: %+v, %v", notification.ID, result, err)
+		}
+	}
+
+	if err := limiter.Refund(ctx, first); err != nil {
+		t.Fatalf("refunding: %v", err)
+	}
+	quota, err := limiter.Quota(ctx, first)
+	if err != nil {
+		t.Fatalf("reading quota: %v", err)
+	}
+	if want := testLimits[models.PriorityHigh] - 1; quota.Remaining != want {
+		t.Errorf("remaining after the refund = %d, want %d", quota.Remaining, want)
+	}
+
+	// A second refund of the same notification takes nothing more back
+	if err := limiter.Refund(ctx, first); err != nil {
+		t.Fatalf("refunding again: %v", err)
+	}
+	if quota, _ := limiter.Quota(ctx, first); quota.Remaining != testLimits[models.PriorityHigh]-1 {
+		t.Errorf("remaining after refunding twice = %d, want %d", quota.Remaining, testLimits[models.PriorityHigh]-1)
+	}
+}
+
+func TestSlidingWindowCounterKeepsKeysForTheirWindow(t *testing.T) {
+	// Hour-long buckets of a day-long configured window
+	c := &slidingWindowCounter{window: 24 * time.Hour, buckets: 24}
+
+	// A tenant's 10 minute window still counts the bucket for up to two hours
+	if ttl := c.ttl(10 * time.Minute); ttl < 2*time.Hour {
+		t.Errorf("ttl of a 10m window = %v, shorter than its buckets are counted", ttl)
+	}
+	if ttl := c.ttl(48 * time.Hour); ttl < 96*time.Hour {
+		t.Errorf("ttl of a 48h window = %v", ttl)
+	}
+}
@@ -0,0 +1,282 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// LocalConfig for the in-process token cache
+type LocalConfig struct {
+	Slice        int           // Tokens reserved from Redis at once
+	SyncInterval time.Duration // Unused tokens are returned to Redis after this long
+}
+
+// LocalLimiter hands out slices of users' quotas reserved from Redis in process
+type LocalLimiter struct {
+	*RedisRateLimiter
+	slice        int
+	syncInterval time.Duration
+
+	mu     sync.Mutex
+	slices map[string]*tokenSlice // Keyed by priority and event type counter key
+	handed map[string]handout     // Tokens handed out, keyed by notification ID for refunds
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// tokenSlice is a part of a user's quota reserved by this instance
+type tokenSlice struct {
+	notification *models.PrioritizedNotification // Names the slice's counters
+	free         []string                        // Counted members not handed out yet
+	quota        models.RateLimitResult          // Quota left in Redis after the slice was reserved
+	expiresAt    time.Time
+}
+
+// handout is a token handed out to a notification, kept until it leaves the
+// window so it can be refunded after its slice is gone
+type handout struct {
+	slice     *tokenSlice
+	member    string
+	expiresAt time.Time
+}
+
+// NewLocalLimiter creates a Redis limiter with an in-process token cache
+func NewLocalLimiter(config Config, local LocalConfig) (RateLimiter, error) {
+	if local.Slice <= 0 || local.SyncInterval <= 0 {
+		return nil, fmt.Errorf("local slice and sync interval must be positive")
+	}
+
+	limiter, err := NewRedisRateLimiter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &LocalLimiter{
+		RedisRateLimiter: limiter.(*RedisRateLimiter),
+		slice:            local.Slice,
+		syncInterval:     local.SyncInterval,
+		slices:           make(map[string]*tokenSlice),
+		handed:           make(map[string]handout),
+		done:             make(chan struct{}),
+	}
+
+	l.wg.Add(1)
+	go l.sync()
+
+	return l, nil
+}
+
+// IsRateLimited hands out a cached token, reserving a new slice from Redis when none is left
+func (l *LocalLimiter) IsRateLimited(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error) {
+	if l.modeOf(notification) == ModeObserved {
+		return l.RedisRateLimiter.IsRateLimited(ctx, notification)
+	}
+
+	key := l.sliceKey(notification)
+	if result, ok := l.take(key, notification); ok {
+		metrics.RateLimitLocalHits.Inc()
+		return result, nil
+	}
+
+	// Name the members after the notification that reserved them, keeping them unique
+	members := make([]string, l.slice)
+	for i := range members {
+		members[i] = fmt.Sprintf("%s:%d", notification.ID, i)
+	}
+
+	granted, quota, err := l.reserve(ctx, notification, members)
+	if err != nil || quota.Limited {
+		return quota, err
+	}
+
+	slice := &tokenSlice{
+		notification: notification,
+		free:         members[1:granted],
+		quota:        quota,
+		expiresAt:    time.Now().Add(l.syncInterval),
+	}
+	result := slice.remaining()
+
+	l.mu.Lock()
+	previous := l.slices[key]
+	l.slices[key] = slice
+	l.handed[notification.ID] = l.handout(slice, members[0], notification)
+	l.mu.Unlock()
+
+	// Another notification of the user may have reserved a slice concurrently
+	if previous != nil {
+		l.giveBack(ctx, previous)
+	}
+
+	return result, nil
+}
+
+// Quota adds the tokens this instance holds for the user to the quota left in Redis
+func (l *LocalLimiter) Quota(ctx context.Context, notification *models.PrioritizedNotification) (models.RateLimitResult, error) {
+	result, err := l.RedisRateLimiter.Quota(ctx, notification)
+	if err != nil || result.Exempt {
+		return result, err
+	}
+
+	l.mu.Lock()
+	if slice, exists := l.slices[l.sliceKey(notification)]; exists && len(slice.free) > 0 {
+		result.Remaining += len(slice.free)
+		result.Limited = false
+	}
+	l.mu.Unlock()
+
+	return result, nil
+}
+
+// Refund returns the notification's token to its slice while it is cached,
+// and takes the token back in Redis once the slice is gone
+func (l *LocalLimiter) Refund(ctx context.Context, notification *models.PrioritizedNotification) error {
+	l.mu.Lock()
+	token, handed := l.handed[notification.ID]
+	delete(l.handed, notification.ID)
+	if handed && l.slices[l.sliceKey(notification)] == token.slice {
+		token.slice.free = append(token.slice.free, token.member)
+		l.mu.Unlock()
+		return nil
+	}
+	l.mu.Unlock()
+
+	if !handed {
+		return l.RedisRateLimiter.Refund(ctx, notification)
+	}
+	return l.release(ctx, notification, []string{token.member})
+}
+
+// DeleteUserData drops the user's cached tokens along with their counters
+func (l *LocalLimiter) DeleteUserData(ctx context.Context, userID string) error {
+	l.mu.Lock()
+	for key, slice := range l.slices {
+		if slice.notification.UserID == userID {
+			delete(l.slices, key)
+		}
+	}
+	for id, token := range l.handed {
+		if token.slice.notification.UserID == userID {
+			delete(l.handed, id)
+		}
+	}
+	l.mu.Unlock()
+
+	return l.RedisRateLimiter.DeleteUserData(ctx, userID)
+}
+
+// Close returns every unused token to Redis before closing the connection
+func (l *LocalLimiter) Close() error {
+	close(l.done)
+	l.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	l.mu.Lock()
+	slices := l.slices
+	l.slices = make(map[string]*tokenSlice)
+	l.mu.Unlock()
+
+	for _, slice := range slices {
+		l.giveBack(ctx, slice)
+	}
+
+	return l.RedisRateLimiter.Close()
+}
+
+// take hands out a token of a fresh slice, reporting whether one was left
+func (l *LocalLimiter) take(key string, notification *models.PrioritizedNotification) (models.RateLimitResult, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slice, exists := l.slices[key]
+	if !exists || len(slice.free) == 0 || !time.Now().Before(slice.expiresAt) {
+		return models.RateLimitResult{}, false
+	}
+
+	member := slice.free[len(slice.free)-1]
+	slice.free = slice.free[:len(slice.free)-1]
+	l.handed[notification.ID] = l.handout(slice, member, notification)
+	return slice.remaining(), true
+}
+
+// handout records a token handed out to a notification until it leaves the window
+func (l *LocalLimiter) handout(slice *tokenSlice, member string, notification *models.PrioritizedNotification) handout {
+	window, _, _ := l.limitsOf(notification)
+	return handout{slice: slice, member: member, expiresAt: l.now().Add(window)}
+}
+
+// remaining returns the user's quota as seen by this instance, counting the slice's unused tokens
+func (s *tokenSlice) remaining() models.RateLimitResult {
+	result := s.quota
+	result.Remaining += len(s.free)
+	return result
+}
+
+// sync periodically returns the unused tokens of expired slices to Redis
+func (l *LocalLimiter) sync() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var expired []*tokenSlice
+
+		l.mu.Lock()
+		for key, slice := range l.slices {
+			if !now.Before(slice.expiresAt) {
+				expired = append(expired, slice)
+				delete(l.slices, key)
+			}
+		}
+		// Tokens out of the window no longer count, there is nothing to refund
+		for id, token := range l.handed {
+			if !l.now().Before(token.expiresAt) {
+				delete(l.handed, id)
+			}
+		}
+		l.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.syncInterval)
+		for _, slice := range expired {
+			l.giveBack(ctx, slice)
+		}
+		cancel()
+	}
+}
+
+// giveBack releases a slice's unused tokens in Redis
+func (l *LocalLimiter) giveBack(ctx context.Context, slice *tokenSlice) {
+	l.mu.Lock()
+	free := slice.free
+	slice.free = nil
+	l.mu.Unlock()
+
+	if len(free) == 0 {
+		return
+	}
+	if err := l.release(ctx, slice.notification, free); err != nil {
+		log.Printf("Failed to return %d unused tokens of user %s: %v", len(free), slice.notification.UserID, err)
+	}
+}
+
+// sliceKey identifies the counters and limit a token is valid for
+func (l *LocalLimiter) sliceKey(notification *models.PrioritizedNotification) string {
+	_, eventTypeKey := l.counterKeys(notification)
+	return notification.Priority + "|" + eventTypeKey
+}
@@ -0,0 +1,319 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is an in-memory Redis speaking RESP2, with the commands the
+// limiters use. Expiry is not modelled, the counters age out entries themselves.
+type fakeRedis struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	zsets  map[string]map[string]float64
+	hashes map[string]map[string]string
+}
+
+// startFakeRedis serves a fake Redis on a local port until the test ends
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	r := &fakeRedis{
+		listener: listener,
+		zsets:    make(map[string]map[string]float64),
+		hashes:   make(map[string]map[string]string),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+// Addr returns the address the fake listens on
+func (r *fakeRedis) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// serve answers the commands of a connection, queueing them between MULTI and EXEC
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		switch name := strings.ToUpper(args[0]); {
+		case name == "MULTI":
+			inMulti, queued = true, nil
+			writer.WriteString("+OK\r\n")
+		case name == "EXEC":
+			inMulti = false
+			r.mu.Lock()
+			fmt.Fprintf(writer, "*%d\r\n", len(queued))
+			for _, cmd := range queued {
+				writer.WriteString(r.execute(cmd))
+			}
+			r.mu.Unlock()
+		case inMulti:
+			queued = append(queued, args)
+			writer.WriteString("+QUEUED\r\n")
+		default:
+			r.mu.Lock()
+			writer.WriteString(r.execute(args))
+			r.mu.Unlock()
+		}
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+// execute runs a command and returns its encoded reply, with the lock held
+func (r *fakeRedis) execute(args []string) string {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "EXPIRE":
+		return ":1\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := r.zsets[key]; ok {
+				deleted++
+			}
+			if _, ok := r.hashes[key]; ok {
+				deleted++
+			}
+			delete(r.zsets, key)
+			delete(r.hashes, key)
+		}
+		return integer(deleted)
+	case "SCAN":
+		pattern := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i], "MATCH") {
+				pattern = args[i+1]
+			}
+		}
+		var keys []string
+		for key := range r.zsets {
+			if ok, _ := path.Match(pattern, key); ok {
+				keys = append(keys, key)
+			}
+		}
+		for key := range r.hashes {
+			if ok, _ := path.Match(pattern, key); ok {
+				keys = append(keys, key)
+			}
+		}
+		return "*2\r\n" + bulk("0") + array(keys)
+	case "ZADD":
+		zset := r.zsets[key]
+		if zset == nil {
+			zset = make(map[string]float64)
+			r.zsets[key] = zset
+		}
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return "-ERR value is not a valid float\r\n"
+			}
+			if _, exists := zset[args[i+1]]; !exists {
+				added++
+			}
+			zset[args[i+1]] = score
+		}
+		return integer(added)
+	case "ZREM":
+		removed := 0
+		for _, member := range args[2:] {
+			if _, exists := r.zsets[key][member]; exists {
+				delete(r.zsets[key], member)
+				removed++
+			}
+		}
+		return integer(removed)
+	case "ZCARD":
+		return integer(len(r.zsets[key]))
+	case "ZREMRANGEBYSCORE":
+		min, errMin := strconv.ParseFloat(args[2], 64)
+		max, errMax := strconv.ParseFloat(args[3], 64)
+		if errMin != nil || errMax != nil {
+			return "-ERR min or max is not a float\r\n"
+		}
+		removed := 0
+		for member, score := range r.zsets[key] {
+			if score >= min && score <= max {
+				delete(r.zsets[key], member)
+				removed++
+			}
+		}
+		return integer(removed)
+	case "ZRANGE":
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		members := r.sortedMembers(key)
+		if stop < 0 {
+			stop += len(members)
+		}
+		stop = min(stop, len(members)-1)
+		var reply []string
+		for i := start; i <= stop; i++ {
+			reply = append(reply, members[i])
+			if len(args) > 4 && strings.EqualFold(args[4], "WITHSCORES") {
+				reply = append(reply, strconv.FormatFloat(r.zsets[key][members[i]], 'f', -1, 64))
+			}
+		}
+		return array(reply)
+	case "HGETALL":
+		fields := make([]string, 0, 2*len(r.hashes[key]))
+		for field, value := range r.hashes[key] {
+			fields = append(fields, field, value)
+		}
+		return array(fields)
+	case "HDEL":
+		removed := 0
+		for _, field := range args[2:] {
+			if _, exists := r.hashes[key][field]; exists {
+				delete(r.hashes[key], field)
+				removed++
+			}
+		}
+		return integer(removed)
+	case "HINCRBY":
+		by, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer\r\n"
+		}
+		hash := r.hashes[key]
+		if hash == nil {
+			hash = make(map[string]string)
+			r.hashes[key] = hash
+		}
+		value, _ := strconv.ParseInt(hash[args[2]], 10, 64)
+		hash[args[2]] = strconv.FormatInt(value+by, 10)
+		return integer(int(value + by))
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+// sortedMembers returns the members of a sorted set by score, then member
+func (r *fakeRedis) sortedMembers(key string) []string {
+	zset := r.zsets[key]
+	members := make([]string, 0, len(zset))
+	for member := range zset {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if zset[members[i]] != zset[members[j]] {
+			return zset[members[i]] < zset[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
+
+// keys returns the number of keys holding entries, like Redis which drops empty keys
+func (r *fakeRedis) keys() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, zset := range r.zsets {
+		if len(zset) > 0 {
+			n++
+		}
+	}
+	for _, hash := range r.hashes {
+		if len(hash) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func integer(n int) string {
+	return ":" + strconv.Itoa(n) + "\r\n"
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func array(items []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(items))
+	for _, item := range items {
+		b.WriteString(bulk(item))
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Spacer keeps a least interval between two notifications to a user on a
+// channel, whatever their event types. A notification reserves each of its
+// spaced channels for the interval, and waits for channels another one holds.
+type Spacer struct {
+	client    *redis.Client
+	intervals map[string]time.Duration
+	keys      Keys
+}
+
+// SpacingConfig for the spacer
+type SpacingConfig struct {
+	Addr            string
+	Password        string
+	CurrentPassword func() string // Optional, read for every new connection so a rotated password is used
+	DB              int
+	Intervals       map[string]time.Duration // Least time between two notifications to a user, by channel
+	Keys            Keys
+}
+
+// NewSpacer creates a Redis-backed spacer
+func NewSpacer(config SpacingConfig) (*Spacer, error) {
+	client := redis.NewClient(RedisOptions(config.Addr, config.Password, config.DB, config.CurrentPassword))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &Spacer{
+		client:    client,
+		intervals: config.Intervals,
+		keys:      config.Keys,
+	}, nil
+}
+
+// Reserve reserves the spaced channels among the given ones for a
+// notification to the user. When another notification still holds one of
+// them, nothing is reserved and Reserve returns when the last of them frees up.
+func (s *Spacer) Reserve(ctx context.Context, userID, notificationID string, channels []string) (time.Time, bool, error) {
+	var reserved []string
+	for _, channel := range channels {
+		interval := s.intervals[channel]
+		if interval <= 0 {
+			continue
+		}
+
+		key := s.keys.Spacing(userID, channel)
+		ok, err := s.client.SetNX(ctx, key, notificationID, interval).Result()
+		if err != nil {
+			s.Release(ctx, userID, notificationID, reserved)
+			return time.Time{}, false, fmt.Errorf("failed to reserve %s: %w", channel, err)
+		}
+		if ok {
+			reserved = append(reserved, channel)
+			continue
+		}
+
+		// Retried notifications find their own reservation
+		holder, err := s.client.Get(ctx, key).Result()
+		if err == nil && holder == notificationID {
+			continue
+		}
+		s.Release(ctx, userID, notificationID, reserved)
+		free, err := s.FreeAt(ctx, userID, channels)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		return free, false, nil
+	}
+	return time.Time{}, true, nil
+}
+
+// FreeAt returns when the last of the spaced channels among the given ones
+// frees up, a time not after now when all of them are free
+func (s *Spacer) FreeAt(ctx context.Context, userID string, channels []string) (time.Time, error) {
+	now := time.Now()
+	free := now
+	for _, channel := range channels {
+		if s.intervals[channel] <= 0 {
+			continue
+		}
+		ttl, err := s.client.PTTL(ctx, s.keys.Spacing(userID, channel)).Result()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read spacing of %s: %w", channel, err)
+		}
+		if at := now.Add(ttl); ttl > 0 && at.After(free) {
+			free = at
+		}
+	}
+	return free, nil
+}
+
+// Release gives back the channels a notification reserved, e.g. when it
+// couldn't be delivered after all
+func (s *Spacer) Release(ctx context.Context, userID, notificationID string, channels []string) error {
+	var errs []error
+	for _, channel := range channels {
+		if s.intervals[channel] <= 0 {
+			continue
+		}
+		key := s.keys.Spacing(userID, channel)
+		holder, err := s.client.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) || (err == nil && holder != notificationID) {
+			continue
+		}
+		if err == nil {
+			err = s.client.Del(ctx, key).Err()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to release %s: %w", channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteUserData removes the reservations held for a user
+func (s *Spacer) DeleteUserData(ctx context.Context, userID string) error {
+	keys := make([]string, 0, len(s.intervals))
+	for channel := range s.intervals {
+		keys = append(keys, s.keys.Spacing(userID, channel))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+// Close closes the Redis connection
+func (s *Spacer) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,237 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WarmUpDayLayout formats the first day of a warm-up schedule
+const WarmUpDayLayout = "2006-01-02"
+
+// WarmUpSchedule ramps up the daily deliveries from a new sending identity,
+// an email domain or an SMS number, so providers and mailbox operators see
+// its volume grow instead of a sudden full-volume cutover. Schedules sharing
+// an identity share its count.
+type WarmUpSchedule struct {
+	Identity string `json:"identity"`         // Sending domain or number, e.g. mail.example.com or +14155550123
+	Channel  string `json:"channel"`          // Channel sent from the identity
+	Tenant   string `json:"tenant,omitempty"` // Tenant sending from it, empty for every tenant without its own
+	Start    string `json:"start"`            // First day of the ramp in UTC, e.g. 2026-10-20
+	Caps     []int  `json:"caps"`             // Deliveries allowed on each day from the start, unlimited after the last
+
+	start time.Time
+}
+
+// Day returns the day of the ramp a time falls on, the first being 0.
+// Times before the start fall on the first day.
+func (s WarmUpSchedule) Day(at time.Time) int {
+	if at.Before(s.start) {
+		return 0
+	}
+	return int(at.Sub(s.start) / (24 * time.Hour))
+}
+
+// Cap returns the deliveries allowed on the day a time falls on, false once
+// the ramp is over
+func (s WarmUpSchedule) Cap(at time.Time) (int, bool) {
+	day := s.Day(at)
+	if day >= len(s.Caps) {
+		return 0, false
+	}
+	return s.Caps[day], true
+}
+
+// WarmUpStatus is where the ramp of an identity stands today
+type WarmUpStatus struct {
+	Identity string `json:"identity"`
+	Channel  string `json:"channel"`
+	Tenant   string `json:"tenant,omitempty"`
+	Day      int    `json:"day"`  // Day of the ramp, the first being 0
+	Cap      int    `json:"cap"`  // Deliveries allowed today, while warming up
+	Sent     int64  `json:"sent"` // Deliveries counted today
+	Done     bool   `json:"done"` // The ramp is over and the identity unlimited
+}
+
+// WarmUpConfig for the warm-up limiter
+type WarmUpConfig struct {
+	Addr            string
+	Password        string
+	CurrentPassword func() string // Optional, read for every new connection so a rotated password is used
+	DB              int
+	Schedules       []WarmUpSchedule
+	Keys            Keys
+}
+
+// WarmUp caps the deliveries of each day from the sending identities still
+// warming up. A notification counts against the identity of each of its
+// channels, the tenant's own or the shared one.
+type WarmUp struct {
+	client    *redis.Client
+	schedules map[string]WarmUpSchedule // By channel and tenant
+	keys      Keys
+}
+
+// Counts a delivery unless the day's cap is reached, a negative cap counts it anyway
+var takeScript = redis.NewScript(`
+local sent = redis.call('INCR', KEYS[1])
+if sent == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+local cap = tonumber(ARGV[1])
+if cap >= 0 and sent > cap then
+	redis.call('DECR', KEYS[1])
+	return 0
+end
+return 1
+`)
+
+// A day's count outlives the day, so a late release still finds it
+const warmUpCountTTL = 48 * time.Hour
+
+// NewWarmUp checks the schedules and creates a Redis-backed warm-up limiter
+func NewWarmUp(config WarmUpConfig) (*WarmUp, error) {
+	schedules := make(map[string]WarmUpSchedule, len(config.Schedules))
+	for _, schedule := range config.Schedules {
+		if schedule.Identity == "" || schedule.Channel == "" {
+			return nil, fmt.Errorf("warm-up schedules need an identity and a channel")
+		}
+		start, err := time.Parse(WarmUpDayLayout, schedule.Start)
+		if err != nil {
+			return nil, fmt.Errorf("warm-up schedule of %s must start on a day like 2006-01-02", schedule.Identity)
+		}
+		schedule.start = start
+		if len(schedule.Caps) == 0 || slices.ContainsFunc(schedule.Caps, func(n int) bool { return n < 0 }) {
+			return nil, fmt.Errorf("warm-up schedule of %s needs caps that aren't negative", schedule.Identity)
+		}
+		key := warmUpKey(schedule.Channel, schedule.Tenant)
+		if _, ok := schedules[key]; ok {
+			return nil, fmt.Errorf("more than one warm-up schedule for %s of %q", schedule.Channel, schedule.Tenant)
+		}
+		schedules[key] = schedule
+	}
+
+	client := redis.NewClient(RedisOptions(config.Addr, config.Password, config.DB, config.CurrentPassword))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &WarmUp{
+		client:    client,
+		schedules: schedules,
+		keys:      config.Keys,
+	}, nil
+}
+
+// Take counts a notification of a tenant against the caps of the
+// identities its channels are sent from. Channels whose identity reached
+// today's cap aren't counted and are returned as throttled, along with when
+// the caps reset. Forced notifications are counted even over the caps.
+func (w *WarmUp) Take(ctx context.Context, tenant string, channels []string, force bool) ([]string, []string, time.Time, error) {
+	now := time.Now().UTC()
+	var taken, throttled []string
+	for _, channel := range channels {
+		schedule, ok := w.schedule(tenant, channel)
+		if !ok {
+			taken = append(taken, channel)
+			continue
+		}
+		limit, ok := schedule.Cap(now)
+		if !ok {
+			taken = append(taken, channel)
+			continue
+		}
+		if force {
+			limit = -1
+		}
+
+		key := w.keys.WarmUp(schedule.Identity, now.Format(WarmUpDayLayout))
+		ok, err := takeScript.Run(ctx, w.client, []string{key}, limit, int(warmUpCountTTL.Seconds())).Bool()
+		if err != nil {
+			w.Release(ctx, tenant, taken)
+			return nil, nil, time.Time{}, fmt.Errorf("failed to count %s for warm-up of %s: %w", channel, schedule.Identity, err)
+		}
+		if ok {
+			taken = append(taken, channel)
+		} else {
+			throttled = append(throttled, channel)
+		}
+	}
+	return taken, throttled, now.Truncate(24 * time.Hour).Add(24 * time.Hour), nil
+}
+
+// Release gives back the counts of channels taken by a notification that
+// wasn't delivered after all
+func (w *WarmUp) Release(ctx context.Context, tenant string, channels []string) error {
+	now := time.Now().UTC()
+	var errs []error
+	for _, channel := range channels {
+		schedule, ok := w.schedule(tenant, channel)
+		if !ok {
+			continue
+		}
+		if _, ok := schedule.Cap(now); !ok {
+			continue
+		}
+		if err := w.client.Decr(ctx, w.keys.WarmUp(schedule.Identity, now.Format(WarmUpDayLayout))).Err(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to release %s: %w", channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status reports where the ramp of every identity stands today
+func (w *WarmUp) Status(ctx context.Context) ([]WarmUpStatus, error) {
+	now := time.Now().UTC()
+	statuses := make([]WarmUpStatus, 0, len(w.schedules))
+	for _, key := range slices.Sorted(maps.Keys(w.schedules)) {
+		schedule := w.schedules[key]
+		limit, warming := schedule.Cap(now)
+		status := WarmUpStatus{
+			Identity: schedule.Identity,
+			Channel:  schedule.Channel,
+			Tenant:   schedule.Tenant,
+			Day:      schedule.Day(now),
+			Cap:      limit,
+			Done:     !warming,
+		}
+
+		sent, err := w.client.Get(ctx, w.keys.WarmUp(schedule.Identity, now.Format(WarmUpDayLayout))).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("failed to read warm-up of %s: %w", schedule.Identity, err)
+		}
+		status.Sent = sent
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// schedule returns the schedule of the identity a tenant sends on a
+// channel from, its own or the shared one
+func (w *WarmUp) schedule(tenant, channel string) (WarmUpSchedule, bool) {
+	if schedule, ok := w.schedules[warmUpKey(channel, tenant)]; ok {
+		return schedule, true
+	}
+	schedule, ok := w.schedules[warmUpKey(channel, "")]
+	return schedule, ok
+}
+
+// Close closes the Redis connection
+func (w *WarmUp) Close() error {
+	return w.client.Close()
+}
+
+// warmUpKey keys a schedule by channel and tenant
+func warmUpKey(channel, tenant string) string {
+	return channel + "/" + tenant
+}
@@ -0,0 +1,59 @@
+// Package regions routes the delivery of notifications by the region users
+// live in, so they are sent by providers in their region and, for regions
+// with data residency rules, their payloads stay on the region's
+// infrastructure.
+package regions
+
+// Route is where the notifications of users in a region are delivered
+type Route struct {
+	Topic     string            `json:"topic"`               // Delivery topic of the region
+	Brokers   []string          `json:"brokers,omitempty"`   // Kafka cluster in the region, empty uses the shared one
+	Providers map[string]string `json:"providers,omitempty"` // Provider per channel, e.g. {"sms": "eu-sms", "email": "ses-eu-west-1"}
+}
+
+// Router knows the routes of the regions. Users without a region or in a
+// region without a route are delivered through the shared delivery topic,
+// unless their region's data must stay in it.
+type Router struct {
+	routes   map[string]Route
+	resident map[string]bool // Regions whose data must not leave them
+}
+
+// NewRouter creates a router over the routes by region. Users in a resident
+// region without a route aren't delivered at all.
+func NewRouter(routes map[string]Route, resident []string) *Router {
+	r := &Router{routes: routes, resident: make(map[string]bool, len(resident))}
+	for _, region := range resident {
+		r.resident[region] = true
+	}
+	return r
+}
+
+// Allowed reports whether notifications of users in the region may be delivered
+func (r *Router) Allowed(region string) bool {
+	if !r.resident[region] {
+		return true
+	}
+	_, routed := r.routes[region]
+	return routed
+}
+
+// Providers returns the providers of the region for the channels it has one
+// for, nil when there are none
+func (r *Router) Providers(region string, channels []string) map[string]string {
+	route, routed := r.routes[region]
+	if !routed {
+		return nil
+	}
+
+	var providers map[string]string
+	for _, channel := range channels {
+		if provider, exists := route.Providers[channel]; exists {
+			if providers == nil {
+				providers = make(map[string]string, len(channels))
+			}
+			providers[channel] = provider
+		}
+	}
+	return providers
+}
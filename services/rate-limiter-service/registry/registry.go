@@ -0,0 +1,266 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Policies applied when a user has no preference for an event type
+const (
+	// Deliver to every channel the user has generally enabled
+	PolicyAllow = "allow"
+	// Deliver only to the category's default channels the user has generally enabled
+	PolicyDefaults = "defaults"
+	// Don't deliver unless the user opted in to the event type explicitly
+	PolicyDeny = "deny"
+)
+
+// Classes of categories
+const (
+	// Users can opt out of the category's notifications
+	ClassStandard = "standard"
+	// Transactional notifications users can't opt out of globally, e.g.
+	// password resets and legal notices. Channel preferences still apply.
+	ClassMandatory = "mandatory"
+)
+
+// Delivery policies, deciding how many of the resolved channels a notification goes to
+const (
+	// Deliver to every channel
+	DeliveryAll = "all"
+	// Any one channel will do: the one the user engages with most when the
+	// engagement model knows, every channel otherwise
+	DeliveryAnyOne = "any_one"
+	// Deliver to the first channel in the channel order
+	DeliveryFirstAvailable = "first_available"
+	// Deliver to the first channel in the channel order, falling back to the
+	// next ones while delivery fails
+	DeliveryPreferredOrder = "preferred_order"
+)
+
+// Channel order of event types and categories that set none, cheapest first
+var DefaultChannelOrder = []string{"in-app", "push", "email", "whatsapp", "sms"}
+
+// Category groups event types that share delivery behavior
+type Category struct {
+	UnknownChannelPolicy string   `json:"unknown_channel_policy"`
+	DefaultChannels      []string `json:"default_channels,omitempty"`
+	Class                string   `json:"class,omitempty"`    // ClassStandard when empty
+	Delivery             string   `json:"delivery,omitempty"` // DeliveryAll when empty
+	// Order the first_available and preferred_order policies pick channels in
+	ChannelOrder []string `json:"channel_order,omitempty"`
+	// Hours of the recipient's day notifications are delivered in, any time when nil
+	DeliveryWindow *DeliveryWindow `json:"delivery_window,omitempty"`
+}
+
+// Mandatory reports whether the category's notifications ignore global opt-outs
+func (c Category) Mandatory() bool {
+	return c.Class == ClassMandatory
+}
+
+// EventType holds the registered settings of a single event type
+type EventType struct {
+	Category string `json:"category"`
+	// JSON Schema the prioritizer validates the event type's metadata against
+	MetadataSchema json.RawMessage `json:"metadata_schema,omitempty"`
+	// Override the category's delivery policy and channel order
+	Delivery     string   `json:"delivery,omitempty"`
+	ChannelOrder []string `json:"channel_order,omitempty"`
+	// Override the category's delivery window
+	DeliveryWindow *DeliveryWindow `json:"delivery_window,omitempty"`
+}
+
+// Registry describes the known event types and the categories they belong to
+type Registry struct {
+	Categories      map[string]Category  `json:"categories"`
+	EventTypes      map[string]EventType `json:"event_types"`
+	DefaultCategory string               `json:"default_category"` // Category of unregistered event types
+}
+
+// Default returns the built-in registry
+func Default() *Registry {
+	return &Registry{
+		Categories: map[string]Category{
+			"security": {UnknownChannelPolicy: PolicyAllow},
+			"transactional": {
+				UnknownChannelPolicy: PolicyAllow,
+				Class:                ClassMandatory,
+			},
+			"account": {UnknownChannelPolicy: PolicyAllow},
+			"social": {
+				UnknownChannelPolicy: PolicyDefaults,
+				DefaultChannels:      []string{"in-app", "push"},
+				Delivery:             DeliveryAnyOne,
+			},
+			"marketing": {
+				UnknownChannelPolicy: PolicyDefaults,
+				DefaultChannels:      []string{"in-app"},
+				Delivery:             DeliveryAnyOne,
+			},
+			"general": {UnknownChannelPolicy: PolicyAllow},
+		},
+		EventTypes: map[string]EventType{
+			"security_alert":        {Category: "security"},
+			"account_compromise":    {Category: "security"},
+			"password_reset":        {Category: "transactional"},
+			"email_verification":    {Category: "transactional"},
+			"legal_notice":          {Category: "transactional"},
+			"system_outage":         {Category: "account"},
+			"payment_failed":        {Category: "account"},
+			"subscription_expiring": {Category: "account"},
+			"message_received":      {Category: "social"},
+			"friend_request":        {Category: "social"},
+			"comment":               {Category: "social"},
+			"like":                  {Category: "social"},
+			"follow":                {Category: "social"},
+			"recommendation":        {Category: "marketing"},
+			"newsletter":            {Category: "marketing"},
+		},
+		DefaultCategory: "general",
+	}
+}
+
+// Load reads the registry from a JSON file, falling back to the built-in registry without one
+func Load(path string) (*Registry, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event registry: %w", err)
+	}
+
+	var registry Registry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse event registry: %w", err)
+	}
+
+	if err := registry.validate(); err != nil {
+		return nil, fmt.Errorf("invalid event registry: %w", err)
+	}
+
+	return &registry, nil
+}
+
+// validate checks that every reference in the registry resolves
+func (r *Registry) validate() error {
+	for name, category := range r.Categories {
+		switch category.UnknownChannelPolicy {
+		case PolicyAllow, PolicyDeny:
+		case PolicyDefaults:
+			if len(category.DefaultChannels) == 0 {
+				return fmt.Errorf("category %s uses the %s policy without default channels", name, PolicyDefaults)
+			}
+		default:
+			return fmt.Errorf("category %s has unknown policy %q", name, category.UnknownChannelPolicy)
+		}
+		switch category.Class {
+		case "", ClassStandard, ClassMandatory:
+		default:
+			return fmt.Errorf("category %s has unknown class %q", name, category.Class)
+		}
+		if !validDelivery(category.Delivery) {
+			return fmt.Errorf("category %s has unknown delivery policy %q", name, category.Delivery)
+		}
+		if category.DeliveryWindow != nil {
+			if err := category.DeliveryWindow.parse(); err != nil {
+				return fmt.Errorf("category %s has an invalid delivery window: %w", name, err)
+			}
+		}
+	}
+
+	for eventType, info := range r.EventTypes {
+		if _, exists := r.Categories[info.Category]; !exists {
+			return fmt.Errorf("event type %s references unknown category %s", eventType, info.Category)
+		}
+		if !validDelivery(info.Delivery) {
+			return fmt.Errorf("event type %s has unknown delivery policy %q", eventType, info.Delivery)
+		}
+		if info.DeliveryWindow != nil {
+			if err := info.DeliveryWindow.parse(); err != nil {
+				return fmt.Errorf("event type %s has an invalid delivery window: %w", eventType, err)
+			}
+		}
+	}
+
+	if r.DefaultCategory != "" {
+		if _, exists := r.Categories[r.DefaultCategory]; !exists {
+			return fmt.Errorf("default category %s is not defined", r.DefaultCategory)
+		}
+	}
+
+	return nil
+}
+
+// CategoryOf returns the category an event type belongs to
+func (r *Registry) CategoryOf(eventType string) Category {
+	name := r.DefaultCategory
+	if info, exists := r.EventTypes[eventType]; exists {
+		name = info.Category
+	}
+
+	if category, exists := r.Categories[name]; exists {
+		return category
+	}
+
+	// Unregistered event types without a default category keep the legacy behavior
+	return Category{UnknownChannelPolicy: PolicyAllow}
+}
+
+// DeliveryOf returns the delivery policy of an event type, its own or else its category's
+func (r *Registry) DeliveryOf(eventType string) string {
+	if info, exists := r.EventTypes[eventType]; exists && info.Delivery != "" {
+		return info.Delivery
+	}
+	if delivery := r.CategoryOf(eventType).Delivery; delivery != "" {
+		return delivery
+	}
+	return DeliveryAll
+}
+
+// ChannelOrderOf returns the order channels of an event type are picked in:
+// its own, else its category's, else DefaultChannelOrder
+func (r *Registry) ChannelOrderOf(eventType string) []string {
+	if info, exists := r.EventTypes[eventType]; exists && len(info.ChannelOrder) > 0 {
+		return info.ChannelOrder
+	}
+	if order := r.CategoryOf(eventType).ChannelOrder; len(order) > 0 {
+		return order
+	}
+	return DefaultChannelOrder
+}
+
+// DeliveryWindowOf returns the delivery window of an event type, its own or
+// else its category's, nil when it may be delivered at any time
+func (r *Registry) DeliveryWindowOf(eventType string) *DeliveryWindow {
+	if info, exists := r.EventTypes[eventType]; exists && info.DeliveryWindow != nil {
+		return info.DeliveryWindow
+	}
+	return r.CategoryOf(eventType).DeliveryWindow
+}
+
+// HasDeliveryWindows reports whether any event type or category has a delivery window
+func (r *Registry) HasDeliveryWindows() bool {
+	for _, info := range r.EventTypes {
+		if info.DeliveryWindow != nil {
+			return true
+		}
+	}
+	for _, category := range r.Categories {
+		if category.DeliveryWindow != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validDelivery reports whether a delivery policy is known, empty meaning the default
+func validDelivery(delivery string) bool {
+	switch delivery {
+	case "", DeliveryAll, DeliveryAnyOne, DeliveryFirstAvailable, DeliveryPreferredOrder:
+		return true
+	}
+	return false
+}
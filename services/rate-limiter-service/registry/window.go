@@ -0,0 +1,97 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	_ "time/tzdata" // Recipients' time zones don't depend on the image's zoneinfo
+)
+
+// DeliveryWindow limits delivery to hours of the recipient's day, e.g.
+// marketing only from 09:00 to 20:00 local time
+type DeliveryWindow struct {
+	Start    string `json:"start"`              // Local time the window opens, e.g. "09:00"
+	End      string `json:"end"`                // Local time it closes, before Start for windows spanning midnight
+	Timezone string `json:"timezone,omitempty"` // Of recipients without a known time zone, UTC when empty
+
+	start, end int // Minutes into the day, set by parse
+	location   *time.Location
+}
+
+// Time zones of recipients by name, nil for unknown names, loaded once each
+var locations sync.Map
+
+// parse checks the window and prepares it for use
+func (w *DeliveryWindow) parse() error {
+	var err error
+	if w.start, err = minuteOfDay(w.Start); err != nil {
+		return err
+	}
+	if w.end, err = minuteOfDay(w.End); err != nil {
+		return err
+	}
+	if w.start == w.end {
+		return fmt.Errorf("window from %s to %s is empty", w.Start, w.End)
+	}
+
+	w.location = time.UTC
+	if w.Timezone != "" {
+		if w.location, err = time.LoadLocation(w.Timezone); err != nil {
+			return fmt.Errorf("unknown time zone %q", w.Timezone)
+		}
+	}
+	return nil
+}
+
+// NextOpen returns when the window next opens for a recipient in the time
+// zone, falling back to the window's own for an empty or unknown one. It
+// reports false while the window is open.
+func (w *DeliveryWindow) NextOpen(now time.Time, timezone string) (time.Time, bool) {
+	local := now.In(w.locationOf(timezone))
+	minute := local.Hour()*60 + local.Minute()
+
+	open := minute >= w.start && minute < w.end
+	if w.start > w.end {
+		open = minute >= w.start || minute < w.end
+	}
+	if open {
+		return time.Time{}, false
+	}
+
+	// Dates are normalized, so the day after the last of a month is fine
+	year, month, day := local.Date()
+	opens := time.Date(year, month, day, w.start/60, w.start%60, 0, 0, local.Location())
+	if !opens.After(local) {
+		opens = time.Date(year, month, day+1, w.start/60, w.start%60, 0, 0, local.Location())
+	}
+	return opens, true
+}
+
+// locationOf returns the location of a recipient's time zone
+func (w *DeliveryWindow) locationOf(timezone string) *time.Location {
+	if timezone == "" {
+		return w.location
+	}
+
+	cached, ok := locations.Load(timezone)
+	if !ok {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			location = nil
+		}
+		cached, _ = locations.LoadOrStore(timezone, location)
+	}
+	if location := cached.(*time.Location); location != nil {
+		return location
+	}
+	return w.location
+}
+
+// minuteOfDay parses a time of day like "09:00" into minutes after midnight
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day like 09:00", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,140 @@
+package selftest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+)
+
+// Check exercises one component the way processing a notification does
+type Check func(ctx context.Context) error
+
+// Config for the runner
+type Config struct {
+	Interval time.Duration // Time between runs
+	Timeout  time.Duration // Bound on every check
+	Failures int           // Consecutive failures after which a check is degraded
+	Checks   map[string]Check
+}
+
+// Runner runs synthetic checks of the processing path in the background.
+// Dependency pings only prove a connection is up, the checks catch
+// components that are reachable but wedged. A check that failed its last
+// Failures runs makes the instance unready until it passes again.
+type Runner struct {
+	interval time.Duration
+	timeout  time.Duration
+	failures int
+	names    []string
+	checks   map[string]Check
+
+	mu       sync.Mutex
+	failing  map[string]int // Consecutive failures by check
+	lastErrs map[string]error
+}
+
+// New creates a runner, Run starts it
+func New(cfg Config) *Runner {
+	names := make([]string, 0, len(cfg.Checks))
+	for name := range cfg.Checks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return &Runner{
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+		failures: max(cfg.Failures, 1),
+		names:    names,
+		checks:   cfg.Checks,
+		failing:  make(map[string]int),
+		lastErrs: make(map[string]error),
+	}
+}
+
+// Run runs the checks right away and then every interval until the context is done
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range r.names {
+			r.run(ctx, name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run runs a single check and records its result
+func (r *Runner) run(ctx context.Context, name string) {
+	checkCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	err := r.checks[name](checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.failing[name]++
+		r.lastErrs[name] = err
+		metrics.SelfTestFailures.WithLabelValues(name).Inc()
+		if r.failing[name] == r.failures {
+			log.Printf("Self-test %s degraded after %d failures: %v", name, r.failures, err)
+			metrics.SelfTestDegraded.WithLabelValues(name).Set(1)
+		}
+		return
+	}
+
+	if r.failing[name] >= r.failures {
+		log.Printf("Self-test %s recovered", name)
+	}
+	r.failing[name] = 0
+	delete(r.lastErrs, name)
+	metrics.SelfTestDegraded.WithLabelValues(name).Set(0)
+}
+
+// Ready fails while any check is degraded, for the readiness endpoint
+func (r *Runner) Ready(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var degraded []string
+	for _, name := range r.names {
+		if r.failing[name] >= r.failures {
+			degraded = append(degraded, fmt.Sprintf("%s: %v", name, r.lastErrs[name]))
+		}
+	}
+	if len(degraded) > 0 {
+		return fmt.Errorf("degraded %s", strings.Join(degraded, "; "))
+	}
+	return nil
+}
+
+// Progress returns a check failing when messages are buffered but none were
+// processed since the previous run, which catches a wedged processor
+func Progress(stats func() (processed int64, buffered int)) Check {
+	last := int64(-1)
+	return func(ctx context.Context) error {
+		processed, buffered := stats()
+		stalled := buffered > 0 && processed == last
+		last = processed
+		if stalled {
+			return fmt.Errorf("%d messages buffered but none processed since the last run", buffered)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,889 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/admin"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/audit"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/blocklist"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/deadletters"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/degraded"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/deliverystats"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/engagement"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metering"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/overview"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/preferences"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/profiles"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/ratelimiter"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/selftest"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/sla"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/slo"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/suppression"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/debugtrace"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/exprrules"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/heartbeat"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/shutdown"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/startup"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/wasmrules"
+)
+
+// service holds what main wires together. Each feature is set up by its own
+// method, in the order main calls them, and closed by the shutdown sequence.
+type service struct {
+	cfg   *config.Config
+	retry startup.Config // Dependencies may still be starting, they are waited for with backoff
+
+	// Producers are flushed once the consumer drained, see shutDown
+	flush []func(ctx context.Context) error
+
+	profileStore    *profiles.Store
+	rateLimiter     ratelimiter.RateLimiter
+	preferences     preferences.PreferencesService
+	invalidator     preferences.Invalidator // The preferences cache, nil without one
+	engagementModel *engagement.Model
+	deliveryStats   *deliverystats.Projection
+	spacer          *ratelimiter.Spacer
+	changeConsumer  *kafka.PreferenceChangeConsumer
+	slaTracker      *sla.Tracker
+	meter           *metering.Meter
+	stages          *degraded.Switches
+	suppressionList *blocklist.Store
+	warmUp          *ratelimiter.WarmUp
+	deliveryLog     *ratelimiter.DeliveryLog
+	expressionRules *exprrules.Engine
+	wasmRules       *wasmrules.Engine
+	processor       *kafka.Processor
+	statusConsumer  *kafka.StatusConsumer
+	scheduler       *kafka.DelayScheduler
+	consumer        kafka.PriorityConsumer
+	monitor         *kafka.HeartbeatMonitor
+	selfTest        *selftest.Runner
+	watch           *kafkaadmin.ClusterWatch
+	auditLog        *audit.Log
+	auditConsumer   *kafka.AuditConsumer
+	pipeline        *overview.Overview
+	deadLetters     *deadletters.Manager
+	adminServer     *admin.Server
+}
+
+// setUpRateLimiting creates the rate limiter, with the rate-limit profiles
+// assigned to tenants when they are enabled
+func (s *service) setUpRateLimiting(ctx context.Context) {
+	cfg := s.cfg
+
+	// Load the rate-limit profiles assigned to tenants, tuned at runtime through the admin API
+	profileStore, err := startup.Retry(ctx, s.retry, "MySQL", cfg.CreateProfileStore)
+	if err != nil {
+		log.Fatalf("Failed to create rate-limit profile store: %v", err)
+	}
+	var limitSource ratelimiter.LimitSource
+	if profileStore != nil {
+		s.profileStore = profileStore
+		limitSource = profileStore
+		log.Printf("Rate-limit profiles of environment %s enabled", profileStore.Environment())
+	}
+
+	// Initialize rate limiter
+	s.rateLimiter, err = startup.Retry(ctx, s.retry, "Redis", func() (ratelimiter.RateLimiter, error) {
+		return cfg.CreateRateLimiter(limitSource)
+	})
+	if err != nil {
+		log.Fatalf("Failed to create rate limiter: %v", err)
+	}
+	log.Println("Rate limiter initialized")
+
+	// Move counters written under the legacy key names, so switching the
+	// key scheme doesn't hand every user a fresh quota
+	if migrator, ok := s.rateLimiter.(ratelimiter.KeyMigrator); ok && cfg.Redis.MigrateKeys && !cfg.Canary.Enabled {
+		moved, err := migrator.MigrateKeys(ctx, ratelimiter.Keys{})
+		if err != nil {
+			log.Fatalf("Failed to migrate rate-limit keys: %v", err)
+		}
+		log.Printf("Migrated %d rate-limit counters", moved)
+	}
+}
+
+// setUpUserData creates the stores of what is known about users, and erases
+// it for users who are deleted
+func (s *service) setUpUserData(ctx context.Context) {
+	cfg := s.cfg
+
+	// Initialize preferences service
+	var err error
+	s.preferences, err = startup.Retry(ctx, s.retry, "MySQL", cfg.CreatePreferencesService)
+	if err != nil {
+		log.Fatalf("Failed to create preferences service: %v", err)
+	}
+	s.invalidator, _ = s.preferences.(preferences.Invalidator)
+	log.Println("Preferences service initialized")
+
+	// Learn which channels users engage with, for event types any one channel will do
+	s.engagementModel, err = startup.Retry(ctx, s.retry, "Redis", cfg.CreateEngagementModel)
+	if err != nil {
+		log.Fatalf("Failed to create engagement model: %v", err)
+	}
+	if s.engagementModel != nil {
+		log.Println("Engagement-based channel selection enabled")
+	}
+
+	// Project what is delivered to each user, for the admin API and channel cooldowns
+	s.deliveryStats, err = startup.Retry(ctx, s.retry, "Redis", cfg.CreateDeliveryStats)
+	if err != nil {
+		log.Fatalf("Failed to create delivery stats projection: %v", err)
+	}
+	if s.deliveryStats != nil {
+		log.Printf("Delivery stats projection enabled, channel cooldowns: %v", cfg.DeliveryStats.Cooldowns)
+	}
+
+	// Space notifications to a user on the channels that need it
+	s.spacer, err = startup.Retry(ctx, s.retry, "Redis", cfg.CreateSpacer)
+	if err != nil {
+		log.Fatalf("Failed to create spacer: %v", err)
+	}
+	if s.spacer != nil {
+		log.Printf("Notifications spaced by channel: %v", cfg.Spacing.Intervals)
+	}
+
+	// Drop cached preferences as they change and erase what is kept about deleted users
+	s.changeConsumer, err = startup.Retry(ctx, s.retry, "Kafka", func() (*kafka.PreferenceChangeConsumer, error) {
+		return kafka.NewPreferenceChangeConsumer(cfg.KafkaConsumer)
+	})
+	if err != nil {
+		log.Fatalf("Failed to create preference change consumer: %v", err)
+	}
+
+	go s.changeConsumer.Start(ctx, func(event *kafka.PreferenceChangeEvent) {
+		if s.invalidator != nil {
+			s.invalidator.Invalidate(event.UserID)
+		}
+
+		if event.Type == kafka.PreferenceChangeDeleted {
+			if err := s.rateLimiter.DeleteUserData(ctx, event.UserID); err != nil {
+				log.Printf("Failed to delete rate-limit data of user %s: %v", event.UserID, err)
+			}
+			if s.engagementModel != nil {
+				if err := s.engagementModel.DeleteUserData(ctx, event.UserID); err != nil {
+					log.Printf("Failed to delete engagement data of user %s: %v", event.UserID, err)
+				}
+			}
+			if s.deliveryStats != nil {
+				if err := s.deliveryStats.DeleteUserData(ctx, event.UserID); err != nil {
+					log.Printf("Failed to delete delivery stats of user %s: %v", event.UserID, err)
+				}
+			}
+			if s.spacer != nil {
+				if err := s.spacer.DeleteUserData(ctx, event.UserID); err != nil {
+					log.Printf("Failed to delete spacing of user %s: %v", event.UserID, err)
+				}
+			}
+		}
+	})
+}
+
+// setUpDelivery creates the producer notifications are delivered with. The
+// canary compares its decisions with the primary's instead of delivering,
+// primaries may mirror traffic to it.
+func (s *service) setUpDelivery(ctx context.Context) (kafka.Producer, kafka.DecisionRecorder) {
+	cfg := s.cfg
+
+	var producer kafka.Producer
+	var decisions kafka.DecisionRecorder
+	if cfg.Canary.Enabled {
+		producer = kafka.NewDiscardProducer()
+		decisions = kafka.NewCanaryComparator()
+		log.Println("Running as canary, notifications will not be delivered")
+	} else {
+		var err error
+		producer, err = kafka.NewProducer(cfg.KafkaProducer)
+		if err != nil {
+			log.Fatalf("Failed to create Kafka producer: %v", err)
+		}
+
+		// Cap the cluster's total delivery throughput, after per-user limits
+		globalLimiter, err := startup.Retry(ctx, s.retry, "Redis", cfg.CreateGlobalLimiter)
+		if err != nil {
+			log.Fatalf("Failed to create global limiter: %v", err)
+		}
+		if globalLimiter != nil {
+			producer = kafka.NewThrottledProducer(producer, globalLimiter)
+			log.Printf("Delivery capped at %d notifications per second", cfg.Redis.GlobalLimit)
+		}
+
+		// Notifications of users in a region with a route go to its own delivery topic
+		if len(cfg.Regions.Routes) > 0 {
+			regional := make(map[string]kafka.Producer, len(cfg.Regions.Routes))
+			for region, route := range cfg.Regions.Routes {
+				regionCfg := cfg.KafkaProducer
+				regionCfg.Topic = route.Topic
+				if len(route.Brokers) > 0 {
+					regionCfg.Brokers = route.Brokers
+				}
+				if regional[region], err = kafka.NewProducer(regionCfg); err != nil {
+					log.Fatalf("Failed to create producer of region %s: %v", region, err)
+				}
+			}
+			producer = kafka.NewRegionProducer(producer, regional)
+			log.Printf("Routing delivery by region to %d regional topics", len(regional))
+		}
+
+		// Test notifications go to the sandbox topic instead of the delivery topic
+		sandboxCfg := cfg.KafkaProducer
+		sandboxCfg.Topic = cfg.KafkaProducer.SandboxTopic
+		sandboxProducer, err := kafka.NewProducer(sandboxCfg)
+		if err != nil {
+			log.Fatalf("Failed to create sandbox producer: %v", err)
+		}
+		producer = kafka.NewSandboxProducer(producer, sandboxProducer)
+
+		if cfg.Canary.SamplePercent > 0 {
+			mirror, err := kafka.NewCanaryMirror(cfg.KafkaProducer, cfg.Priorities, cfg.Canary)
+			if err != nil {
+				log.Fatalf("Failed to create canary mirror: %v", err)
+			}
+			decisions = mirror
+			log.Printf("Mirroring %d%% of traffic to the canary", cfg.Canary.SamplePercent)
+		}
+	}
+	s.flush = append(s.flush, shutdown.Close(producer.Close))
+	if decisions != nil {
+		s.flush = append(s.flush, shutdown.Close(decisions.Close))
+	}
+	log.Println("Kafka producer initialized")
+	return producer, decisions
+}
+
+// setUpProcessor creates the processor deciding what happens to every
+// notification, with the optional steps that are enabled
+func (s *service) setUpProcessor(ctx context.Context, producer kafka.Producer, decisions kafka.DecisionRecorder) {
+	cfg := s.cfg
+
+	// Initialize SLA tracker
+	s.slaTracker = cfg.CreateSLATracker()
+	s.flush = append(s.flush, shutdown.Close(s.slaTracker.Close))
+	log.Println("SLA tracker initialized")
+
+	// Load event-type registry
+	eventRegistry, err := cfg.CreateEventRegistry()
+	if err != nil {
+		log.Fatalf("Failed to load event registry: %v", err)
+	}
+	log.Println("Event registry loaded")
+
+	processorCfg := kafka.ProcessorConfig{
+		RateLimiter:   s.rateLimiter,
+		Preferences:   s.preferences,
+		Producer:      producer,
+		SLATracker:    s.slaTracker,
+		EventRegistry: eventRegistry,
+		Priorities:    cfg.Priorities,
+		Decisions:     decisions,
+		// Skip optional steps for notifications running out of their processing budget
+		TightBudget: cfg.Budget.Tight,
+	}
+	if s.engagementModel != nil {
+		processorCfg.Engagement = s.engagementModel
+	}
+	if s.deliveryStats != nil {
+		processorCfg.Stats = s.deliveryStats
+	}
+	if s.spacer != nil {
+		processorCfg.Spacer = s.spacer
+	}
+
+	// Trace notifications enqueue sampled for debugging, the canary leaves that to the primary
+	if !cfg.Canary.Enabled {
+		tracer, err := debugtrace.New(cfg.KafkaProducer.Producer(), debugtrace.Config{
+			Service:     "rate-limiter-service",
+			Topic:       cfg.DebugTopic,
+			SendTimeout: cfg.KafkaProducer.SendTimeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create debug tracer: %v", err)
+		}
+		processorCfg.Tracer = tracer
+		s.flush = append(s.flush, shutdown.Close(tracer.Close))
+	}
+	processorCfg.Usage = s.usageRecorder(ctx)
+
+	// Honour signed bypasses of emergency notifications, audited in MySQL and
+	// closed after the processor stopped
+	bypassGate, err := startup.Retry(ctx, s.retry, "MySQL", cfg.CreateBypassGate)
+	if err != nil {
+		log.Fatalf("Failed to create bypass gate: %v", err)
+	}
+	if bypassGate != nil {
+		processorCfg.Bypass = bypassGate
+		s.flush = append(s.flush, shutdown.Close(bypassGate.Close))
+		log.Println("Bypasses of emergency notifications enabled")
+	}
+
+	// Keep less urgent notifications on cheap channels
+	if router := cfg.CreateRegionRouter(); router != nil {
+		processorCfg.Regions = router
+	}
+
+	if selector := cfg.CreateCostSelector(); selector != nil {
+		processorCfg.Costs = selector
+		log.Printf("Cost-aware channel selection enabled with a budget of %g, escalating %v", cfg.Cost.Budget, cfg.Cost.EscalatePriorities)
+	}
+
+	// Let operators skip broken stages, so one dependency doesn't halt every notification
+	s.stages, err = cfg.CreateStageSwitches()
+	if err != nil {
+		log.Fatalf("Failed to create degraded mode switches: %v", err)
+	}
+	processorCfg.Stages = s.stages
+
+	// Drop channels whose contact can't be delivered to, flagged on the status
+	// topic by all but the canary, which doesn't deliver
+	contactChecker, err := cfg.CreateContactChecker()
+	if err != nil {
+		log.Fatalf("Failed to create contact checker: %v", err)
+	}
+	if contactChecker != nil {
+		processorCfg.Contacts = contactChecker
+		if !cfg.Canary.Enabled {
+			statuses, err := kafka.NewStatusProducer(cfg.KafkaProducer, cfg.Contacts.StatusTopic)
+			if err != nil {
+				log.Fatalf("Failed to create status producer: %v", err)
+			}
+			processorCfg.Statuses = statuses
+			s.flush = append(s.flush, shutdown.Close(statuses.Close))
+		}
+		log.Printf("Checking contacts before delivery, flagging refused ones on %s", cfg.Contacts.StatusTopic)
+	}
+
+	// Never contact the users, email addresses and phone numbers on the
+	// suppression lists, managed through the admin API
+	s.suppressionList, err = startup.Retry(ctx, s.retry, "MySQL", cfg.CreateSuppressionList)
+	if err != nil {
+		log.Fatalf("Failed to create suppression list store: %v", err)
+	}
+	if s.suppressionList != nil {
+		processorCfg.Blocklist = s.suppressionList
+		log.Printf("Checking %d suppression list entries before delivery", s.suppressionList.Size())
+	}
+
+	// Cap the daily deliveries from sending identities still warming up
+	s.warmUp, err = startup.Retry(ctx, s.retry, "Redis", cfg.CreateWarmUp)
+	if err != nil {
+		log.Fatalf("Failed to create warm-up limiter: %v", err)
+	}
+	if s.warmUp != nil {
+		processorCfg.WarmUp = s.warmUp
+		log.Printf("Warming up %d sending identities", len(cfg.WarmUp.Schedules))
+	}
+
+	// Deliver each copy of an expanded event once, however often it is expanded
+	s.deliveryLog, err = startup.Retry(ctx, s.retry, "Redis", cfg.CreateDeliveryLog)
+	if err != nil {
+		log.Fatalf("Failed to create delivery log: %v", err)
+	}
+	if s.deliveryLog != nil {
+		processorCfg.Deliveries = s.deliveryLog
+	}
+	processorCfg.Rules = s.suppressionRules(ctx)
+
+	s.processor = kafka.NewProcessor(ctx, processorCfg)
+}
+
+// usageRecorder meters usage for billing and watches the volume of event
+// types and tenants, nil when neither is enabled
+func (s *service) usageRecorder(ctx context.Context) kafka.UsageRecorder {
+	cfg := s.cfg
+
+	// Meter usage for billing, rollups are flushed after the processor stopped
+	var err error
+	s.meter, err = startup.Retry(ctx, s.retry, "MySQL", cfg.CreateMeter)
+	if err != nil {
+		log.Fatalf("Failed to create usage meter: %v", err)
+	}
+	var recorders kafka.UsageRecorders
+	if s.meter != nil {
+		recorders = append(recorders, s.meter)
+		s.flush = append(s.flush, shutdown.Close(s.meter.Close))
+		log.Printf("Usage metering enabled, flushing every %v", cfg.Metering.FlushInterval)
+	}
+
+	// Watch the volume of event types and tenants for spikes and drops
+	detector, err := cfg.CreateAnomalyDetector()
+	if err != nil {
+		log.Fatalf("Failed to create anomaly detector: %v", err)
+	}
+	if detector != nil {
+		recorders = append(recorders, detector)
+		s.flush = append(s.flush, shutdown.Close(detector.Close))
+		log.Printf("Volume anomaly detection enabled over %v windows", cfg.Anomaly.Window)
+	}
+
+	switch len(recorders) {
+	case 0:
+		return nil
+	case 1:
+		return recorders[0]
+	default:
+		return recorders
+	}
+}
+
+// suppressionRules loads the suppression rules teams registered, see the
+// suppression package, then the expression rules and the tenants' own,
+// uploaded as WASM modules
+func (s *service) suppressionRules(ctx context.Context) suppression.Rules {
+	cfg := s.cfg
+
+	rules, err := cfg.CreateSuppressionRules()
+	if err != nil {
+		log.Fatalf("Failed to create suppression rules: %v", err)
+	}
+	s.expressionRules, err = cfg.CreateExpressionRules()
+	if err != nil {
+		log.Fatalf("Failed to load expression rules: %v", err)
+	}
+	if s.expressionRules != nil {
+		rules = rules.With("expression", suppression.NewExpressionRule(s.expressionRules))
+		log.Printf("Suppressing with the expression rules in %s", cfg.ExpressionRules.File)
+	}
+	s.wasmRules, err = cfg.CreateWASMRules(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load WASM rules: %v", err)
+	}
+	if s.wasmRules != nil {
+		rules = rules.With("wasm", suppression.NewWASMRule(s.wasmRules))
+		log.Printf("Suppressing with the tenants' WASM rules in %s", cfg.WASMRules.Dir)
+	}
+	if len(rules) > 0 {
+		log.Printf("Suppressing notifications by %d registered rules", len(rules))
+	}
+	return rules
+}
+
+// countEngagement counts opens and action clicks for the engagement model
+func (s *service) countEngagement(ctx context.Context) {
+	if s.engagementModel == nil || s.engagementModel.ReadOnly() {
+		return
+	}
+
+	var err error
+	s.statusConsumer, err = kafka.NewStatusConsumer(s.cfg.KafkaConsumer.Brokers, s.cfg.Engagement.StatusTopic, s.cfg.Engagement.GroupID)
+	if err != nil {
+		log.Fatalf("Failed to create status consumer: %v", err)
+	}
+	go s.statusConsumer.Start(ctx, func(ctx context.Context, event *models.StatusEvent) error {
+		switch event.Status {
+		case models.StatusOpened, models.StatusActionClicked:
+		default:
+			return nil
+		}
+		if event.Channel == "" {
+			return nil
+		}
+		if !s.engagementModel.Authentic(event.NotificationID, event.UserID, event.Token) {
+			log.Printf("Ignoring %s of notification %s reported for user %s without its engagement token", event.Status, event.NotificationID, event.UserID)
+			return nil
+		}
+		return s.engagementModel.RecordEngaged(ctx, event.UserID, event.NotificationID, event.Channel)
+	})
+}
+
+// setUpConsumer creates the consumer of the priority lanes, taking over
+// where the previous deployment or cluster stopped. active is the index of
+// the Kafka cluster the service runs on.
+func (s *service) setUpConsumer(ctx context.Context, active int) {
+	cfg := s.cfg
+
+	// Park notifications that fail for good, the canary only logs them
+	var deadLetters *kafka.DeadLetterProducer
+	if cfg.KafkaConsumer.DeadLetterTopic != "" {
+		var err error
+		deadLetters, err = kafka.NewDeadLetterProducer(cfg.KafkaProducer, cfg.KafkaConsumer.DeadLetterTopic)
+		if err != nil {
+			log.Fatalf("Failed to create dead-letter producer: %v", err)
+		}
+		s.flush = append(s.flush, shutdown.Close(deadLetters.Close))
+	}
+
+	delayer := s.setUpDelays(ctx)
+
+	// Retry notifications that still fail after their retries later instead of dead-lettering them
+	if cfg.KafkaConsumer.RetryDelay > 0 {
+		log.Printf("Retrying failing notifications up to %d times later, starting after %v", cfg.KafkaConsumer.RetryDelayMax, cfg.KafkaConsumer.RetryDelay)
+	}
+
+	// Continue where the previous deployment's consumer groups stopped, one group per lane
+	if cfg.KafkaConsumer.HandoverFrom != "" {
+		for _, level := range cfg.Priorities {
+			from := kafka.LaneGroupID(cfg.KafkaConsumer.HandoverFrom, level.Name)
+			to := kafka.LaneGroupID(cfg.KafkaConsumer.GroupID, level.Name)
+			if err := kafkaadmin.HandOver(ctx, cfg.KafkaConsumer.Brokers, from, to, []string{level.Topic}, cfg.KafkaConsumer.HandoverTimeout); err != nil {
+				log.Fatalf("Failed to hand over from consumer group %s: %v", from, err)
+			}
+		}
+	}
+
+	// Offsets don't carry over to a standby cluster, the lanes start shortly before the failover
+	if active > 0 && cfg.KafkaFailover.Rewind > 0 {
+		for _, level := range cfg.Priorities {
+			group := kafka.LaneGroupID(cfg.KafkaConsumer.GroupID, level.Name)
+			err := kafkaadmin.TranslateOffsets(cfg.KafkaConsumer.Brokers, group, []string{level.Topic}, time.Now().Add(-cfg.KafkaFailover.Rewind))
+			if err != nil {
+				log.Fatalf("Failed to translate offsets of consumer group %s: %v", group, err)
+			}
+		}
+	}
+
+	// Initialize Kafka consumer
+	var err error
+	s.consumer, err = kafka.NewPriorityConsumer(cfg.KafkaConsumer, cfg.Priorities, deadLetters, delayer)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka consumer: %v", err)
+	}
+	log.Println("Kafka priority consumer initialized")
+}
+
+// setUpDelays holds delayed messages on the delay topics until they are due,
+// nil when delays are disabled. The scheduler stops with ctx and is closed
+// before the delayer.
+func (s *service) setUpDelays(ctx context.Context) *kafka.Delayer {
+	cfg := s.cfg
+	if !cfg.Delay.Enabled {
+		return nil
+	}
+
+	delayer, err := kafka.NewDelayer(cfg.KafkaProducer, cfg.Delay)
+	if err != nil {
+		log.Fatalf("Failed to create delayer: %v", err)
+	}
+	decryptor, err := cfg.CreateDecryptor()
+	if err != nil {
+		log.Fatalf("Failed to create decryptor: %v", err)
+	}
+	s.scheduler, err = kafka.NewDelayScheduler(cfg.KafkaConsumer, cfg.Delay, delayer, decryptor)
+	if err != nil {
+		log.Fatalf("Failed to create delay scheduler: %v", err)
+	}
+	go func() {
+		if err := s.scheduler.Start(ctx); err != nil {
+			log.Printf("Delay scheduler stopped: %v", err)
+		}
+	}()
+	s.flush = append(s.flush, shutdown.Close(s.scheduler.Close), shutdown.Close(delayer.Close))
+	return delayer
+}
+
+// setUpMonitoring announces this instance on the ops topic, keeps the latest
+// heartbeat of every instance, pages on error budgets burning too fast and
+// runs the self-test
+func (s *service) setUpMonitoring(ctx context.Context) {
+	cfg := s.cfg
+
+	if cfg.Heartbeat.Interval > 0 {
+		heartbeater, err := heartbeat.New(cfg.KafkaProducer.Producer(), heartbeat.Config{
+			Service:    "rate-limiter-service",
+			InstanceID: cfg.Heartbeat.InstanceID,
+			Topic:      cfg.Heartbeat.Topic,
+			Interval:   cfg.Heartbeat.Interval,
+		}, func(h *heartbeat.Heartbeat) {
+			stats := s.consumer.Stats()
+			h.Processed = stats.Processed
+			h.Failed = make(map[string]int64)
+			for _, lane := range stats.Lanes {
+				h.Lag += lane.Lag
+				h.Failed[lane.Priority] = lane.Failed
+			}
+			h.Outcomes = s.processor.Outcomes()
+			h.Slow = s.slaTracker.Violations()
+		})
+		if err != nil {
+			log.Fatalf("Failed to create heartbeater: %v", err)
+		}
+		s.flush = append(s.flush, shutdown.Close(heartbeater.Close))
+
+		s.monitor, err = kafka.NewHeartbeatMonitor(cfg.KafkaConsumer.Brokers, cfg.Heartbeat.Topic)
+		if err != nil {
+			log.Fatalf("Failed to create heartbeat monitor: %v", err)
+		}
+		go func() {
+			if err := s.monitor.Start(ctx); err != nil {
+				log.Printf("Heartbeat monitor stopped: %v", err)
+			}
+		}()
+		log.Printf("Publishing heartbeats of instance %s every %v", cfg.Heartbeat.InstanceID, cfg.Heartbeat.Interval)
+	}
+
+	// Page on error budgets burning too fast, computed from the heartbeats of the whole pipeline
+	evaluator, err := cfg.CreateSLOEvaluator(func() map[string]slo.Rates {
+		return s.monitor.Indicators().Rates()
+	})
+	if err != nil {
+		log.Fatalf("Failed to create SLO evaluator: %v", err)
+	}
+	if evaluator != nil {
+		s.flush = append(s.flush, shutdown.Close(evaluator.Close))
+		log.Printf("SLO burn-rate alerts enabled, evaluated every %v", cfg.SLO.EvaluationInterval)
+	}
+
+	if cfg.SelfTest.Interval > 0 {
+		s.startSelfTest(ctx)
+	}
+}
+
+// startSelfTest exercises the processing path with synthetic checks,
+// dependency pings don't catch a component that is reachable but wedged
+func (s *service) startSelfTest(ctx context.Context) {
+	cfg := s.cfg
+
+	probe, err := kafka.NewSelfTestProbe(cfg.KafkaProducer, cfg.Heartbeat)
+	if err != nil {
+		log.Fatalf("Failed to create self-test probe: %v", err)
+	}
+	s.flush = append(s.flush, shutdown.Close(probe.Close))
+
+	// The synthetic user is only looked up, never counted against
+	synthetic := &models.PrioritizedNotification{
+		NotificationEvent: models.NotificationEvent{UserID: cfg.SelfTest.UserID, EventType: "self_test"},
+		Priority:          cfg.Priorities[len(cfg.Priorities)-1].Name,
+	}
+	s.selfTest = selftest.New(selftest.Config{
+		Interval: cfg.SelfTest.Interval,
+		Timeout:  cfg.SelfTest.Timeout,
+		Failures: cfg.SelfTest.Failures,
+		Checks: map[string]selftest.Check{
+			"rate_limiter": func(ctx context.Context) error {
+				_, err := s.rateLimiter.Quota(ctx, synthetic)
+				return err
+			},
+			"preferences": func(ctx context.Context) error {
+				// Skip the cache so the lookup reaches MySQL
+				if s.invalidator != nil {
+					s.invalidator.Invalidate(cfg.SelfTest.UserID)
+				}
+				_, err := s.preferences.GetUserPreferences(ctx, cfg.SelfTest.UserID)
+				return err
+			},
+			"produce": probe.Produce,
+			"processor": selftest.Progress(func() (int64, int) {
+				stats := s.consumer.Stats()
+				var buffered int
+				for _, lane := range stats.Lanes {
+					buffered += lane.Buffered
+				}
+				return stats.Processed, buffered
+			}),
+		},
+	})
+	go s.selfTest.Run(ctx)
+	log.Printf("Running the self-test every %v", cfg.SelfTest.Interval)
+}
+
+// setUpAudit keeps an append-only trail of admin actions, the rate
+// limiter's own and those the other services send to the audit topic
+func (s *service) setUpAudit(ctx context.Context) {
+	cfg := s.cfg
+
+	var err error
+	s.auditLog, err = startup.Retry(ctx, s.retry, "MySQL", cfg.CreateAuditLog)
+	if err != nil {
+		log.Fatalf("Failed to create audit log: %v", err)
+	}
+	if cfg.Audit.Topic != "" && !cfg.MockMode {
+		s.auditConsumer, err = kafka.NewAuditConsumer(cfg.KafkaProducer, cfg.Audit.Topic, cfg.Audit.GroupID)
+		if err != nil {
+			log.Fatalf("Failed to create audit consumer: %v", err)
+		}
+		go s.auditConsumer.Start(ctx, s.auditLog.Append)
+	}
+}
+
+// startAdmin starts the admin server with the endpoints of the enabled features
+func (s *service) startAdmin(ctx context.Context) {
+	cfg := s.cfg
+
+	adminCfg := admin.Config{
+		Port:         cfg.Admin.Port,
+		ReadTimeout:  cfg.Admin.ReadTimeout,
+		WriteTimeout: cfg.Admin.WriteTimeout,
+		Operators:    cfg.Admin.Operators,
+		Diagnostics: func() any {
+			diagnostics := map[string]any{"consumer": s.consumer.Stats()}
+			if s.scheduler != nil {
+				diagnostics["delayed_pending"] = s.scheduler.Pending()
+			}
+			return diagnostics
+		},
+		// The clients reconnect on their own, readiness reports while they can't
+		Readiness: map[string]func(ctx context.Context) error{
+			"redis": s.rateLimiter.Ping,
+			"mysql": s.preferences.Ping,
+		},
+		Quota:      s.rateLimiter.Quota,
+		Audit:      s.auditLog.Record,
+		AuditTrail: s.auditLog.Handler(),
+		Simulate: func(ctx context.Context, notification *models.PrioritizedNotification) (any, error) {
+			return s.processor.Simulate(ctx, notification)
+		},
+	}
+	if s.selfTest != nil {
+		adminCfg.Readiness["self_test"] = s.selfTest.Ready
+	}
+	if s.monitor != nil {
+		adminCfg.Instances = func() any { return s.monitor.Instances() }
+	}
+	if s.meter != nil {
+		adminCfg.Usage = s.meter.Usage
+	}
+	if s.deliveryStats != nil {
+		adminCfg.DeliveryStats = func(ctx context.Context, userID string) (any, error) {
+			return s.deliveryStats.Stats(ctx, userID)
+		}
+	}
+	adminCfg.Degraded = s.stages.Handler()
+	if s.profileStore != nil {
+		adminCfg.Profiles = s.profileStore.Handler()
+	}
+	if s.suppressionList != nil {
+		adminCfg.SuppressionList = s.suppressionList.Handler()
+	}
+	if s.warmUp != nil {
+		adminCfg.WarmUp = func(ctx context.Context) (any, error) {
+			return s.warmUp.Status(ctx)
+		}
+	}
+
+	// Aggregate the state of the whole pipeline for dashboards
+	if cfg.Overview.Enabled {
+		overviewCfg := overview.Config{
+			Brokers:           cfg.KafkaConsumer.Brokers,
+			Stages:            overviewStages(cfg),
+			DeadLetterTopics:  cfg.Overview.DeadLetterTopics,
+			RecentDeadLetters: cfg.Overview.RecentDeadLetters,
+			AllowedOrigins:    cfg.Overview.AllowedOrigins,
+		}
+		if s.monitor != nil {
+			overviewCfg.Instances = func() any { return s.monitor.Instances() }
+			overviewCfg.Throughput = func() any { return s.monitor.Throughput() }
+		}
+		var err error
+		s.pipeline, err = overview.New(overviewCfg)
+		if err != nil {
+			log.Fatalf("Failed to create pipeline overview: %v", err)
+		}
+		adminCfg.Overview = s.pipeline.Handler()
+	}
+
+	// Inspect, requeue and purge dead letters, audited in MySQL outside mock mode
+	if cfg.DeadLetterAdmin.Enabled {
+		managerCfg := deadletters.Config{
+			Brokers:      cfg.KafkaConsumer.Brokers,
+			Topics:       cfg.DeadLetterAdmin.Topics,
+			RequiredAcks: cfg.KafkaProducer.RequiredAcks,
+			RetryMax:     cfg.KafkaProducer.RetryMax,
+		}
+		if !cfg.MockMode {
+			managerCfg.Driver = cfg.Database.Driver
+			managerCfg.DSN = cfg.Database.DSN
+		}
+		var err error
+		s.deadLetters, err = startup.Retry(ctx, s.retry, "MySQL", func() (*deadletters.Manager, error) {
+			return deadletters.NewManager(managerCfg)
+		})
+		if err != nil {
+			log.Fatalf("Failed to create dead-letter manager: %v", err)
+		}
+		adminCfg.DeadLetters = s.deadLetters.Handler()
+	}
+	// Pick up the rules other instances sharing them changed
+	if s.wasmRules != nil {
+		adminCfg.WASMRules = s.wasmRules.Handler()
+		go s.wasmRules.Run(ctx, cfg.WASMRules.ReloadInterval)
+	}
+	if s.expressionRules != nil {
+		adminCfg.ExpressionRules = s.expressionRules.Handler()
+		go s.expressionRules.Run(ctx, cfg.ExpressionRules.ReloadInterval)
+	}
+	s.adminServer = admin.NewServer(adminCfg)
+	go func() {
+		if err := s.adminServer.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("Admin server listening on port %d", cfg.Admin.Port)
+}
+
+// shutDown stops taking notifications, drains the lanes, cancels ctx through
+// cancel, flushes the producers and closes the clients
+func (s *service) shutDown(cancel context.CancelFunc) {
+	cfg := s.cfg
+
+	// The processor keeps ctx while draining the lanes, their messages are already committed
+	var sequencer shutdown.Sequencer
+	sequencer.Stage("intake", cfg.Shutdown.Intake, s.consumer.StopIntake)
+	sequencer.Stage("drain", cfg.Shutdown.Drain, s.consumer.Drain, func(context.Context) error {
+		cancel()
+		return nil
+	})
+	sequencer.Stage("flush", cfg.Shutdown.Flush, s.flush...)
+	sequencer.Stage("close", cfg.Shutdown.Close, s.closers()...)
+	sequencer.Run()
+}
+
+// closers lists how the clients are closed once the producers are flushed,
+// the audit log last as the audit consumer appends to it
+func (s *service) closers() []func(ctx context.Context) error {
+	closers := []func(ctx context.Context) error{
+		shutdown.Close(s.consumer.Close),
+		shutdown.Close(s.changeConsumer.Close),
+		shutdown.Close(s.rateLimiter.Close),
+		shutdown.Close(s.preferences.Close),
+		s.adminServer.Shutdown,
+	}
+	if s.monitor != nil {
+		closers = append(closers, shutdown.Close(s.monitor.Close))
+	}
+	if s.statusConsumer != nil {
+		closers = append(closers, shutdown.Close(s.statusConsumer.Close))
+	}
+	if s.engagementModel != nil {
+		closers = append(closers, shutdown.Close(s.engagementModel.Close))
+	}
+	if s.deliveryStats != nil {
+		closers = append(closers, shutdown.Close(s.deliveryStats.Close))
+	}
+	if s.spacer != nil {
+		closers = append(closers, shutdown.Close(s.spacer.Close))
+	}
+	if s.deliveryLog != nil {
+		closers = append(closers, shutdown.Close(s.deliveryLog.Close))
+	}
+	if s.pipeline != nil {
+		closers = append(closers, shutdown.Close(s.pipeline.Close))
+	}
+	if s.deadLetters != nil {
+		closers = append(closers, shutdown.Close(s.deadLetters.Close))
+	}
+	if s.watch != nil {
+		closers = append(closers, shutdown.Close(s.watch.Close))
+	}
+	if s.profileStore != nil {
+		closers = append(closers, shutdown.Close(s.profileStore.Close))
+	}
+	if s.suppressionList != nil {
+		closers = append(closers, shutdown.Close(s.suppressionList.Close))
+	}
+	if s.warmUp != nil {
+		closers = append(closers, shutdown.Close(s.warmUp.Close))
+	}
+	if s.auditConsumer != nil {
+		closers = append(closers, shutdown.Close(s.auditConsumer.Close))
+	}
+	if s.wasmRules != nil {
+		closers = append(closers, s.wasmRules.Close)
+	}
+	return append(closers, shutdown.Close(s.auditLog.Close))
+}
@@ -0,0 +1,172 @@
+package sla
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+)
+
+// Tracker records end-to-end latency per priority and reports SLO violations
+type Tracker struct {
+	thresholds map[string]time.Duration // Latency objective per priority, zero disables
+	webhookURL string
+	client     *http.Client
+	alerts     chan Violation
+	countsMu   sync.Mutex
+	violations map[string]int64 // Violations since start per priority
+	mu         sync.RWMutex
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+// Config for the SLA tracker
+type Config struct {
+	Thresholds     map[string]time.Duration
+	WebhookURL     string // Optional endpoint notified of every violation
+	WebhookTimeout time.Duration
+}
+
+// Violation describes a notification that missed its latency objective
+type Violation struct {
+	NotificationID string `json:"notification_id"`
+	RequestID      string `json:"request_id,omitempty"`
+	UserID         string `json:"user_id"`
+	EventType      string `json:"event_type"`
+	Priority       string `json:"priority"`
+	CreatedAt      int64  `json:"created_at"`
+	DeliveredAt    int64  `json:"delivered_at"`
+	LatencyMs      int64  `json:"latency_ms"`
+	ThresholdMs    int64  `json:"threshold_ms"`
+}
+
+// Creates a new SLA tracker
+func NewTracker(cfg Config) *Tracker {
+	tracker := &Tracker{
+		thresholds: cfg.Thresholds,
+		webhookURL: cfg.WebhookURL,
+		client:     &http.Client{Timeout: cfg.WebhookTimeout},
+		violations: make(map[string]int64),
+	}
+
+	// Alerts are posted in the background so a slow webhook never blocks processing
+	if tracker.webhookURL != "" {
+		tracker.alerts = make(chan Violation, 100)
+		tracker.wg.Add(1)
+		go tracker.sendAlerts()
+	}
+
+	return tracker
+}
+
+// Observe records the latency of a notification produced for delivery at the given time
+func (t *Tracker) Observe(notification *models.PrioritizedNotification, deliveredAt time.Time) {
+	// CreatedAt has second precision, so latency is accurate to about a second
+	latency := deliveredAt.Sub(time.Unix(notification.CreatedAt, 0))
+	if latency < 0 {
+		latency = 0
+	}
+
+	metrics.EndToEndLatency.WithLabelValues(notification.Priority).Observe(latency.Seconds())
+
+	threshold := t.thresholds[notification.Priority]
+	if threshold <= 0 || latency <= threshold {
+		return
+	}
+
+	metrics.SLOViolations.WithLabelValues(notification.Priority).Inc()
+	t.countsMu.Lock()
+	t.violations[notification.Priority]++
+	t.countsMu.Unlock()
+	logger := logging.ForRequest(notification.RequestID)
+	logger.Printf("SLO violation: %s priority notification %s took %v (objective %v)",
+		notification.Priority, notification.ID, latency, threshold)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.alerts == nil || t.closed {
+		return
+	}
+
+	violation := Violation{
+		NotificationID: notification.ID,
+		RequestID:      notification.RequestID,
+		UserID:         notification.UserID,
+		EventType:      notification.EventType,
+		Priority:       notification.Priority,
+		CreatedAt:      notification.CreatedAt,
+		DeliveredAt:    deliveredAt.Unix(),
+		LatencyMs:      latency.Milliseconds(),
+		ThresholdMs:    threshold.Milliseconds(),
+	}
+
+	select {
+	case t.alerts <- violation:
+	default:
+		metrics.SLOWebhookFailures.Inc()
+		logger.Printf("SLO alert queue full, dropping alert for notification %s", notification.ID)
+	}
+}
+
+// Violations returns the notifications that missed their latency objective since start per priority
+func (t *Tracker) Violations() map[string]int64 {
+	t.countsMu.Lock()
+	defer t.countsMu.Unlock()
+
+	violations := make(map[string]int64, len(t.violations))
+	for priority, count := range t.violations {
+		violations[priority] = count
+	}
+	return violations
+}
+
+// sendAlerts posts queued violations to the webhook until the tracker is closed
+func (t *Tracker) sendAlerts() {
+	defer t.wg.Done()
+	for violation := range t.alerts {
+		if err := t.post(violation); err != nil {
+			metrics.SLOWebhookFailures.Inc()
+			logging.ForRequest(violation.RequestID).Printf("Failed to send SLO alert for notification %s: %v", violation.NotificationID, err)
+		}
+	}
+}
+
+// post sends a single violation to the webhook
+func (t *Tracker) post(violation Violation) error {
+	payload, err := json.Marshal(violation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal violation: %w", err)
+	}
+
+	resp, err := t.client.Post(t.webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close flushes pending alerts and stops the background sender
+func (t *Tracker) Close() error {
+	t.mu.Lock()
+	if t.alerts == nil || t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.alerts)
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	return nil
+}
@@ -0,0 +1,321 @@
+package slo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/metrics"
+)
+
+// Names of the objectives
+const (
+	EnqueueAvailability = "enqueue_availability" // Enqueue requests not answered with a server error
+	DeliverySuccess     = "delivery_success"     // Settled notifications that didn't fail for good
+)
+
+// Latency returns the name of the latency objective of a priority, met by
+// notifications delivered within the priority's SLO
+func Latency(priority string) string {
+	return "latency_" + priority
+}
+
+// Severities of burn-rate alerts
+const (
+	SeverityPage   = "page"
+	SeverityTicket = "ticket"
+)
+
+// States of a burn-rate alert
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Rates are the events of an objective per second
+type Rates struct {
+	Total float64
+	Bad   float64 // Events of the total that missed the objective
+}
+
+// Source reports the current rates of the objectives by name
+type Source func() map[string]Rates
+
+// Window is a burn-rate alert. It fires while the error budget burns at
+// least BurnRate times as fast as it may, both over Long and over a twelfth
+// of it, so it fires fast and resolves fast.
+type Window struct {
+	Severity string
+	Long     time.Duration
+	BurnRate float64
+}
+
+// Evaluator computes how fast the error budgets of the objectives burn and
+// alerts when they burn too fast. The rates of the objectives are sampled
+// every interval and kept for the longest window.
+type Evaluator struct {
+	source     Source
+	objectives map[string]float64 // Share of events that must be good per objective
+	interval   time.Duration
+	windows    []Window
+	retain     time.Duration
+	webhookURL string
+	client     *http.Client
+
+	mu      sync.Mutex
+	samples map[string][]sample
+	firing  map[alertKey]bool
+
+	alerts chan Alert
+	sender sync.WaitGroup
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Config for the evaluator
+type Config struct {
+	Objectives     map[string]float64 // Share of events that must be good per objective, e.g. 0.999
+	Interval       time.Duration      // How often rates are sampled and burn rates computed
+	Windows        []Window
+	WebhookURL     string // Optional endpoint notified when an alert fires or resolves
+	WebhookTimeout time.Duration
+}
+
+// Alert is posted to the webhook when a burn-rate alert fires or resolves
+type Alert struct {
+	SLO           string  `json:"slo"`
+	Severity      string  `json:"severity"`
+	State         string  `json:"state"`
+	Objective     float64 `json:"objective"`
+	BurnRate      float64 `json:"burn_rate"`       // Over the long window
+	ShortBurnRate float64 `json:"short_burn_rate"` // Over the short window
+	Threshold     float64 `json:"threshold"`
+	WindowSeconds float64 `json:"window_seconds"`
+	At            int64   `json:"at"`
+}
+
+// Events of an objective during one interval
+type sample struct {
+	at    time.Time
+	total float64
+	bad   float64
+}
+
+type alertKey struct {
+	objective string
+	severity  string
+}
+
+// NewEvaluator creates an evaluator and starts sampling in the background
+func NewEvaluator(source Source, cfg Config) (*Evaluator, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("SLO evaluation interval must be positive")
+	}
+	for name, objective := range cfg.Objectives {
+		if objective <= 0 || objective >= 1 {
+			return nil, fmt.Errorf("objective %s must be between 0 and 1", name)
+		}
+	}
+
+	var retain time.Duration
+	for _, window := range cfg.Windows {
+		if window.Long < 12*cfg.Interval || window.BurnRate <= 0 {
+			return nil, fmt.Errorf("%s window must span at least 12 evaluation intervals with a positive burn rate", window.Severity)
+		}
+		retain = max(retain, window.Long)
+	}
+
+	e := &Evaluator{
+		source:     source,
+		objectives: cfg.Objectives,
+		interval:   cfg.Interval,
+		windows:    cfg.Windows,
+		retain:     retain,
+		webhookURL: cfg.WebhookURL,
+		client:     &http.Client{Timeout: cfg.WebhookTimeout},
+		samples:    make(map[string][]sample, len(cfg.Objectives)),
+		firing:     make(map[alertKey]bool),
+		done:       make(chan struct{}),
+	}
+
+	// Alerts are posted in the background so a slow webhook never delays sampling
+	if e.webhookURL != "" {
+		e.alerts = make(chan Alert, 100)
+		e.sender.Add(1)
+		go e.sendAlerts()
+	}
+
+	e.wg.Add(1)
+	go e.run()
+
+	return e, nil
+}
+
+// run samples the rates every interval until the evaluator is closed
+func (e *Evaluator) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.evaluate(time.Now())
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// evaluate adds a sample of every objective and alerts on the windows whose
+// state changed
+func (e *Evaluator) evaluate(now time.Time) {
+	rates := e.source()
+
+	e.mu.Lock()
+	var alerts []Alert
+	for name, objective := range e.objectives {
+		r := rates[name]
+		samples := append(e.samples[name], sample{
+			at:    now,
+			total: r.Total * e.interval.Seconds(),
+			bad:   r.Bad * e.interval.Seconds(),
+		})
+		for len(samples) > 0 && now.Sub(samples[0].at) >= e.retain {
+			samples = samples[1:]
+		}
+		e.samples[name] = samples
+
+		budget := 1 - objective
+		for _, window := range e.windows {
+			short := window.Long / 12
+			longBurn := burnRate(samples, now, window.Long, budget)
+			shortBurn := burnRate(samples, now, short, budget)
+			metrics.SLOBurnRate.WithLabelValues(name, windowLabel(window.Long)).Set(longBurn)
+			metrics.SLOBurnRate.WithLabelValues(name, windowLabel(short)).Set(shortBurn)
+
+			key := alertKey{objective: name, severity: window.Severity}
+			firing := longBurn >= window.BurnRate && shortBurn >= window.BurnRate
+			if firing == e.firing[key] {
+				continue
+			}
+			e.firing[key] = firing
+
+			state := StateResolved
+			if firing {
+				state = StateFiring
+			}
+			alerts = append(alerts, Alert{
+				SLO:           name,
+				Severity:      window.Severity,
+				State:         state,
+				Objective:     objective,
+				BurnRate:      longBurn,
+				ShortBurnRate: shortBurn,
+				Threshold:     window.BurnRate,
+				WindowSeconds: window.Long.Seconds(),
+				At:            now.Unix(),
+			})
+		}
+	}
+	e.mu.Unlock()
+
+	for _, alert := range alerts {
+		e.report(alert)
+	}
+}
+
+// burnRate returns how many times faster than allowed the error budget
+// burned over the last window, zero without events
+func burnRate(samples []sample, now time.Time, window time.Duration, budget float64) float64 {
+	var total, bad float64
+	for i := len(samples) - 1; i >= 0 && now.Sub(samples[i].at) < window; i-- {
+		total += samples[i].total
+		bad += samples[i].bad
+	}
+	if total <= 0 {
+		return 0
+	}
+	return bad / total / budget
+}
+
+// windowLabel formats a window for metric labels, 1h rather than 1h0m0s
+func windowLabel(window time.Duration) string {
+	label := window.String()
+	if strings.HasSuffix(label, "m0s") {
+		label = strings.TrimSuffix(label, "0s")
+	}
+	if strings.HasSuffix(label, "h0m") {
+		label = strings.TrimSuffix(label, "0m")
+	}
+	return label
+}
+
+// report logs, counts and queues an alert for the webhook
+func (e *Evaluator) report(alert Alert) {
+	if alert.State == StateFiring {
+		metrics.SLOBurnAlerts.WithLabelValues(alert.SLO, alert.Severity).Inc()
+	}
+	log.Printf("SLO burn-rate %s alert %s for %s: burning %.1fx over %v, %.1fx over the short window (threshold %.1fx)",
+		alert.Severity, alert.State, alert.SLO, alert.BurnRate,
+		time.Duration(alert.WindowSeconds*float64(time.Second)), alert.ShortBurnRate, alert.Threshold)
+
+	if e.alerts == nil {
+		return
+	}
+	select {
+	case e.alerts <- alert:
+	default:
+		metrics.SLOBurnWebhookFailures.Inc()
+		log.Printf("SLO burn-rate alert queue full, dropping alert for %s", alert.SLO)
+	}
+}
+
+// sendAlerts posts queued alerts to the webhook until the evaluator is closed
+func (e *Evaluator) sendAlerts() {
+	defer e.sender.Done()
+	for alert := range e.alerts {
+		if err := e.post(alert); err != nil {
+			metrics.SLOBurnWebhookFailures.Inc()
+			log.Printf("Failed to send SLO burn-rate alert for %s: %v", alert.SLO, err)
+		}
+	}
+}
+
+// post sends a single alert to the webhook
+func (e *Evaluator) post(alert Alert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	resp, err := e.client.Post(e.webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close stops sampling and flushes pending alerts
+func (e *Evaluator) Close() error {
+	close(e.done)
+	e.wg.Wait()
+
+	// The sampling loop is the only one queueing alerts, and it stopped
+	if e.alerts != nil {
+		close(e.alerts)
+		e.sender.Wait()
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package suppression
+
+import (
+	"context"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/exprrules"
+)
+
+// ExpressionRule suppresses notifications the first suppressing expression
+// rule matches
+type ExpressionRule struct {
+	engine *exprrules.Engine
+}
+
+// NewExpressionRule creates a rule evaluating the engine's rules
+func NewExpressionRule(engine *exprrules.Engine) *ExpressionRule {
+	return &ExpressionRule{engine: engine}
+}
+
+// Suppress returns the name and reason of the matching rule
+func (r *ExpressionRule) Suppress(ctx context.Context, notification *models.PrioritizedNotification) (string, bool, error) {
+	rule, matched, err := r.engine.Match(notification, func(rule exprrules.Rule) bool {
+		return rule.Suppress
+	})
+	if err != nil || !matched {
+		return "", false, err
+	}
+	if rule.Reason == "" {
+		return rule.Name, true, nil
+	}
+	return rule.Name + ": " + rule.Reason, true, nil
+}
@@ -0,0 +1,120 @@
+// Package suppression lets teams ship their own rules for suppressing
+// notifications without forking the rate limiter. A package implementing a
+// rule registers it by name from an init function and is imported for that
+// side effect in main.go:
+//
+//	import _ "example.com/growth/notificationrules"
+//
+// SUPPRESSION_RULES then selects the registered rules to apply, in order,
+// along with their options:
+//
+//	[{"name": "trial-expired", "options": {"grace": "72h"}}]
+package suppression
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+)
+
+// Rule decides whether a notification is suppressed for its user
+type Rule interface {
+	// Suppress returns why the notification is suppressed, false when it isn't
+	Suppress(ctx context.Context, notification *models.PrioritizedNotification) (string, bool, error)
+}
+
+// Factory creates a rule from the options it was configured with, nil
+// when there are none
+type Factory func(options json.RawMessage) (Rule, error)
+
+// Config selects a registered rule
+type Config struct {
+	Name    string          `json:"name"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// Registered factories by name
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a rule available under a name. It panics when the name is
+// taken, like registering a database driver twice.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if factory == nil {
+		panic("suppression: Register of nil factory for rule " + name)
+	}
+	if _, taken := factories[name]; taken {
+		panic("suppression: Register called twice for rule " + name)
+	}
+	factories[name] = factory
+}
+
+// Registered returns the names of the registered rules, sorted
+func Registered() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Rules are applied one after another until one suppresses a notification
+type Rules []namedRule
+
+// namedRule is a rule along with the name it was selected by
+type namedRule struct {
+	name string
+	rule Rule
+}
+
+// New creates the selected rules, in order
+func New(configs []Config) (Rules, error) {
+	rules := make(Rules, 0, len(configs))
+	for _, config := range configs {
+		mu.RLock()
+		factory, ok := factories[config.Name]
+		mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown suppression rule %q, registered are %v", config.Name, Registered())
+		}
+		rule, err := factory(config.Options)
+		if err != nil {
+			return nil, fmt.Errorf("suppression rule %s: %w", config.Name, err)
+		}
+		rules = append(rules, namedRule{name: config.Name, rule: rule})
+	}
+	return rules, nil
+}
+
+// With returns the rules followed by a rule created outside the registry
+func (r Rules) With(name string, rule Rule) Rules {
+	return append(r, namedRule{name: name, rule: rule})
+}
+
+// Check returns the name of the first rule suppressing the notification and
+// why, an empty name when none does
+func (r Rules) Check(ctx context.Context, notification *models.PrioritizedNotification) (string, string, error) {
+	for _, named := range r {
+		reason, suppressed, err := named.rule.Suppress(ctx, notification)
+		if err != nil {
+			return "", "", fmt.Errorf("suppression rule %s: %w", named.name, err)
+		}
+		if suppressed {
+			return named.name, reason, nil
+		}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,40 @@
+package suppression
+
+import (
+	"context"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/rate-limiter-service/models"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/wasmrules"
+)
+
+// WASMRule suppresses notifications of tenants that uploaded a rule module
+// when that module says so. A module that fails lets the notification
+// through, so a broken upload can't hold up the tenant's notifications.
+type WASMRule struct {
+	engine *wasmrules.Engine
+}
+
+// NewWASMRule creates a rule running the engine's modules
+func NewWASMRule(engine *wasmrules.Engine) *WASMRule {
+	return &WASMRule{engine: engine}
+}
+
+// Suppress returns why the tenant's module suppresses the notification
+func (r *WASMRule) Suppress(ctx context.Context, notification *models.PrioritizedNotification) (string, bool, error) {
+	tenant, _ := notification.Metadata[models.MetadataTenant].(string)
+	if tenant == "" {
+		return "", false, nil
+	}
+
+	decision, ok, err := r.engine.Evaluate(ctx, tenant, notification)
+	if err != nil {
+		logging.ForRequest(notification.RequestID).Printf("Rules of tenant %s failed on notification %s: %v",
+			tenant, notification.ID, err)
+		return "", false, nil
+	}
+	if !ok || !decision.Suppress {
+		return "", false, nil
+	}
+	return decision.Reason, true, nil
+}
@@ -0,0 +1,142 @@
+package timingwheel
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Wheel is a hashed timing wheel: timers are hashed into slots by the tick
+// they expire on, so adding one is O(1) however many are pending. Timers
+// further out than one revolution wait the remaining rounds in their slot.
+// Timers fire up to one tick late, never early.
+type Wheel struct {
+	tick  time.Duration
+	slots []*timer
+
+	mu      sync.Mutex
+	current int // Slot of the tick being processed
+	pending int
+
+	expired chan *timer
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// timer is a scheduled callback, linked into its slot
+type timer struct {
+	at     time.Time
+	rounds int // Revolutions left before the timer's slot expires it
+	fn     func()
+	next   *timer
+}
+
+// New creates a wheel of the given number of slots advancing every tick and
+// starts it. Callbacks run one at a time on a goroutine of the wheel.
+func New(tick time.Duration, slots int) *Wheel {
+	w := &Wheel{
+		tick:    tick,
+		slots:   make([]*timer, slots),
+		expired: make(chan *timer, slots),
+		done:    make(chan struct{}),
+	}
+
+	w.wg.Add(2)
+	go w.run()
+	go w.fire()
+	return w
+}
+
+// AfterFunc calls fn once at is reached. Timers already due fire on the next tick.
+func (w *Wheel) AfterFunc(at time.Time, fn func()) {
+	ticks := int((time.Until(at) + w.tick - 1) / w.tick)
+	ticks = max(ticks, 1)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	slot := (w.current + ticks) % len(w.slots)
+	w.slots[slot] = &timer{
+		at:     at,
+		rounds: (ticks - 1) / len(w.slots),
+		fn:     fn,
+		next:   w.slots[slot],
+	}
+	w.pending++
+}
+
+// Pending returns the number of timers that haven't fired yet
+func (w *Wheel) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending
+}
+
+// run advances the wheel every tick, handing expired timers to fire
+func (w *Wheel) run() {
+	defer w.wg.Done()
+	defer close(w.expired)
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		for _, t := range w.advance() {
+			select {
+			case w.expired <- t:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// advance moves to the next slot and unlinks the timers it expires
+func (w *Wheel) advance() []*timer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.current = (w.current + 1) % len(w.slots)
+
+	var expired []*timer
+	var kept *timer
+	for t := w.slots[w.current]; t != nil; {
+		next := t.next
+		if t.rounds > 0 {
+			t.rounds--
+			t.next = kept
+			kept = t
+		} else {
+			expired = append(expired, t)
+			w.pending--
+		}
+		t = next
+	}
+	w.slots[w.current] = kept
+
+	// Timers of the same tick fire in the order they were due
+	slices.SortFunc(expired, func(a, b *timer) int {
+		return a.at.Compare(b.at)
+	})
+	return expired
+}
+
+// fire runs the callbacks of expired timers
+func (w *Wheel) fire() {
+	defer w.wg.Done()
+	for t := range w.expired {
+		t.fn()
+	}
+}
+
+// Stop stops the wheel. Timers that haven't expired yet are discarded.
+func (w *Wheel) Stop() {
+	close(w.done)
+	w.wg.Wait()
+}
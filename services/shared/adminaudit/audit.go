@@ -0,0 +1,93 @@
+// Package adminaudit records the admin requests that may change state
+package adminaudit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/adminauth"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
+// Largest request body kept in an audit record, longer ones are cut
+const maxAuditBody = 16 << 10
+
+// Auditor records an admin action, filling in its ID and where it was performed
+type Auditor func(ctx context.Context, action messages.AdminAction) error
+
+// Audited wraps the admin routes so every request that may change state is
+// recorded once answered: the operator who sent it, what it was sent to, its
+// parameters and how it was answered. Reads are not recorded.
+func Audited(next http.Handler, record Auditor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Keep the start of the body for the record and hand all of it on
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		actor := adminauth.Actor(r)
+		// The routes below set the pattern they matched on the request
+		action := r.Pattern
+		if action == "" {
+			action = r.Method + " " + r.URL.Path
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := record(ctx, messages.AdminAction{
+			Actor:       actor,
+			Action:      action,
+			Path:        r.URL.Path,
+			Query:       r.URL.RawQuery,
+			Parameters:  auditParameters(body),
+			Status:      recorder.status,
+			PerformedAt: time.Now().Unix(),
+		})
+		if err != nil {
+			log.Printf("Failed to audit %s by %s: %v", action, actor, err)
+		}
+	})
+}
+
+// auditParameters returns a request body as it is kept in the audit record:
+// as is when it is JSON, as a JSON string otherwise
+func auditParameters(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if len(body) <= maxAuditBody && json.Valid(body) {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, body); err == nil {
+			return compacted.Bytes()
+		}
+	}
+	if len(body) > maxAuditBody {
+		body = append(body[:maxAuditBody:maxAuditBody], "..."...)
+	}
+	parameters, _ := json.Marshal(string(body))
+	return parameters
+}
+
+// Remembers the status code a response was written with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,71 @@
+// Package adminauth identifies the operators calling the admin APIs
+package adminauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+)
+
+type actorKey struct{}
+
+// Authenticated wraps the admin routes so every request that may change state
+// needs the bearer token of an operator, given as operator name to token.
+// Reads stay open to probes and scrapers. Without operators nothing is
+// checked, and requests are attributed to their remote address.
+func Authenticated(next http.Handler, operators map[string]string) http.Handler {
+	return guarded(next, operators, func(r *http.Request) bool {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return false
+		}
+		return true
+	})
+}
+
+// Required is like Authenticated, but needs an operator's token for reads too,
+// for routes whose answers are sensitive
+func Required(next http.Handler, operators map[string]string) http.Handler {
+	return guarded(next, operators, func(*http.Request) bool { return true })
+}
+
+// guarded needs an operator's token for the requests it reports as guarded
+func guarded(next http.Handler, operators map[string]string, guard func(r *http.Request) bool) http.Handler {
+	if len(operators) == 0 {
+		return next
+	}
+
+	// Tokens are looked up by digest, so the lookup time says nothing of them
+	names := make(map[[sha256.Size]byte]string, len(operators))
+	for name, token := range operators {
+		if token == "" {
+			continue
+		}
+		names[sha256.Sum256([]byte(token))] = name
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		name := names[sha256.Sum256([]byte(token))]
+		if ok && name != "" {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, name)))
+			return
+		}
+		if !guard(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "operator token required", http.StatusUnauthorized)
+	})
+}
+
+// Actor returns the operator who sent a request, its remote address when no
+// operator authenticated it
+func Actor(r *http.Request) string {
+	if name, ok := r.Context().Value(actorKey{}).(string); ok {
+		return name
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,74 @@
+package adminauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticated(t *testing.T) {
+	var actor string
+	handler := Authenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = Actor(r)
+	}), map[string]string{"alice": "alice-token"})
+
+	serve := func(method, token string) int {
+		actor = ""
+		req := httptest.NewRequest(method, "/dead-letters/notifications.raw.dlq/purge", nil)
+		req.Header.Set("X-Requested-By", "mallory")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(http.MethodPost, "alice-token"); code != http.StatusOK || actor != "alice" {
+		t.Errorf("authenticated change = %d by %q", code, actor)
+	}
+	if code := serve(http.MethodPost, ""); code != http.StatusUnauthorized || actor != "" {
+		t.Errorf("change without a token = %d by %q", code, actor)
+	}
+	if code := serve(http.MethodDelete, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("change with an unknown token = %d", code)
+	}
+	if code := serve(http.MethodGet, ""); code != http.StatusOK || actor != "192.0.2.1:1234" {
+		t.Errorf("read without a token = %d by %q", code, actor)
+	}
+}
+
+func TestAuthenticatedWithoutOperators(t *testing.T) {
+	var actor string
+	handler := Authenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = Actor(r)
+	}), nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/degraded/enrichment", nil)
+	req.Header.Set("X-Requested-By", "mallory")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || actor != "192.0.2.1:1234" {
+		t.Errorf("change = %d by %q", rec.Code, actor)
+	}
+}
+
+func TestRequired(t *testing.T) {
+	handler := Required(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Actor(r)))
+	}), map[string]string{"alice": "alice-token"})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/user-001/export", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("read without a token = %d", rec.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer alice-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+		t.Errorf("read with a token = %d by %q", rec.Code, rec.Body.String())
+	}
+}
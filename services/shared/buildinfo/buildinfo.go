@@ -0,0 +1,64 @@
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo.Version=v1.4.0"
+//
+// The Dockerfile passes its VERSION, COMMIT and BUILD_DATE build args.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info identifies the code that is running
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info. Without ldflags, the commit and date come from
+// the VCS stamp go build adds when run inside the repository.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// String formats the info for logs, e.g. "v1.4.0 (commit 1a2b3c4, built 2025-01-02T15:04:05Z, go1.24.2)"
+func (i Info) String() string {
+	commit := i.Commit
+	if commit == "" {
+		commit = "unknown"
+	} else if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	date := i.Date
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", i.Version, commit, date, i.GoVersion)
+}
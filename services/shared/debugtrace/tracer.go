@@ -0,0 +1,87 @@
+// Package debugtrace publishes the traces every stage writes to the debug
+// topic for the notifications sampled for debugging.
+package debugtrace
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/logging"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
+// Tracer publishes debug traces of sampled notifications
+type Tracer interface {
+	// Trace publishes a trace, failures are only logged
+	Trace(ctx context.Context, trace *messages.DebugTrace)
+	Close() error
+}
+
+// Config of the traces of a service
+type Config struct {
+	Service     string // Name the service's traces carry
+	Topic       string
+	SendTimeout time.Duration
+}
+
+// KafkaTracer implements the Tracer interface on the debug topic
+type KafkaTracer struct {
+	producer    sarama.SyncProducer
+	service     string
+	topic       string
+	sendTimeout time.Duration
+}
+
+// New connects a producer to the debug topic, creating it when missing
+func New(producerCfg kafkaproducer.Config, cfg Config) (*KafkaTracer, error) {
+	producer, err := kafkaproducer.NewSyncProducer(producerCfg, cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return NewKafkaTracer(producer, cfg), nil
+}
+
+// NewKafkaTracer publishes traces with the producer, which closing the
+// tracer closes
+func NewKafkaTracer(producer sarama.SyncProducer, cfg Config) *KafkaTracer {
+	return &KafkaTracer{
+		producer:    producer,
+		service:     cfg.Service,
+		topic:       cfg.Topic,
+		sendTimeout: cfg.SendTimeout,
+	}
+}
+
+// Trace publishes a trace keyed by notification ID, so a notification's
+// traces stay in order
+func (t *KafkaTracer) Trace(ctx context.Context, trace *messages.DebugTrace) {
+	trace.Service = t.service
+
+	logger := logging.ForRequest(trace.RequestID)
+
+	payload, err := json.Marshal(trace)
+	if err != nil {
+		logger.Printf("Failed to marshal debug trace of notification %s: %v", trace.NotificationID, err)
+		return
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: t.topic,
+		Key:   sarama.StringEncoder(trace.NotificationID),
+		Value: sarama.ByteEncoder(payload),
+	}
+	if trace.RequestID != "" {
+		msg.Headers = []sarama.RecordHeader{{Key: []byte(logging.RequestIDHeader), Value: []byte(trace.RequestID)}}
+	}
+	if _, _, err := kafkaproducer.Send(ctx, t.producer, msg, t.sendTimeout); err != nil {
+		logger.Printf("Failed to send debug trace of notification %s: %v", trace.NotificationID, err)
+	}
+}
+
+// Close closes the tracer's producer
+func (t *KafkaTracer) Close() error {
+	return t.producer.Close()
+}
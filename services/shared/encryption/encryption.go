@@ -0,0 +1,320 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
+// Prefix of encrypted field values: enc:v1:<key id>:<base64 nonce+ciphertext>
+const valuePrefix = "enc:v1:"
+
+// ErrUnknownKey is returned when a value was encrypted with a key that is not available
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider supplies data keys. The env provider reads them directly;
+// a KMS-backed provider would unwrap encrypted data keys at startup.
+type KeyProvider interface {
+	// ActiveKey returns the key used for new values
+	ActiveKey() (keyID string, key []byte, err error)
+	// Key returns the key with the given ID, for decryption
+	Key(keyID string) ([]byte, error)
+}
+
+// StaticKeyProvider serves keys held in memory
+type StaticKeyProvider struct {
+	activeKeyID string
+	keys        map[string][]byte
+}
+
+// Creates a key provider from base64 encoded keys by ID
+func NewStaticKeyProvider(activeKeyID string, encodedKeys map[string]string) (*StaticKeyProvider, error) {
+	keys := make(map[string][]byte, len(encodedKeys))
+	for keyID, encoded := range encodedKeys {
+		if strings.Contains(keyID, ":") {
+			return nil, fmt.Errorf("key ID %q must not contain ':'", keyID)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %s: %w", keyID, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes for AES-256, got %d", keyID, len(key))
+		}
+		keys[keyID] = key
+	}
+
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("active key %q is not configured", activeKeyID)
+	}
+
+	return &StaticKeyProvider{activeKeyID: activeKeyID, keys: keys}, nil
+}
+
+// ActiveKey returns the key used for new values
+func (p *StaticKeyProvider) ActiveKey() (string, []byte, error) {
+	return p.activeKeyID, p.keys[p.activeKeyID], nil
+}
+
+// Key returns the key with the given ID
+func (p *StaticKeyProvider) Key(keyID string) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return key, nil
+}
+
+// FieldEncryptor encrypts the content, channel content and sensitive metadata
+// of notifications with AES-GCM. Every value is bound to the ID of the event
+// it was enqueued as and its field name, so ciphertexts cannot be swapped
+// between notifications or fields unnoticed. Copies expanded from an event
+// carry its ID and decrypt with it.
+type FieldEncryptor struct {
+	keys         KeyProvider
+	metadataKeys []string // Metadata entries considered sensitive
+}
+
+// Creates a new field encryptor
+func NewFieldEncryptor(keys KeyProvider, metadataKeys []string) *FieldEncryptor {
+	return &FieldEncryptor{
+		keys:         keys,
+		metadataKeys: metadataKeys,
+	}
+}
+
+// Encrypt returns a copy of the event with content, channel content and sensitive metadata encrypted
+func (e *FieldEncryptor) Encrypt(event *messages.NotificationEvent) (*messages.NotificationEvent, error) {
+	encrypted := *event
+
+	if event.Content != "" {
+		value, err := e.encryptValue(rootID(event), "content", []byte(event.Content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt content: %w", err)
+		}
+		encrypted.Content = value
+	}
+
+	if event.ChannelContent != nil {
+		encrypted.ChannelContent = copyChannelContent(event.ChannelContent)
+		for _, field := range channelContentFields(encrypted.ChannelContent) {
+			if *field.value == "" {
+				continue
+			}
+			value, err := e.encryptValue(rootID(event), field.name, []byte(*field.value))
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt %s: %w", field.name, err)
+			}
+			*field.value = value
+		}
+	}
+
+	if len(event.Metadata) > 0 {
+		encrypted.Metadata = make(map[string]any, len(event.Metadata))
+		for name, value := range event.Metadata {
+			encrypted.Metadata[name] = value
+		}
+
+		for _, name := range e.metadataKeys {
+			value, ok := event.Metadata[name]
+			if !ok {
+				continue
+			}
+
+			// Metadata values keep their JSON type once decrypted
+			plaintext, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode metadata %s: %w", name, err)
+			}
+			ciphertext, err := e.encryptValue(rootID(event), "metadata."+name, plaintext)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt metadata %s: %w", name, err)
+			}
+			encrypted.Metadata[name] = ciphertext
+		}
+	}
+
+	return &encrypted, nil
+}
+
+// Decrypt returns a copy of the event with every encrypted field restored.
+// Fields that are not encrypted are left as they are.
+func (e *FieldEncryptor) Decrypt(event *messages.NotificationEvent) (*messages.NotificationEvent, error) {
+	decrypted := *event
+
+	if IsEncrypted(event.Content) {
+		plaintext, err := e.decryptValue(rootID(event), "content", event.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt content: %w", err)
+		}
+		decrypted.Content = string(plaintext)
+	}
+
+	if event.ChannelContent != nil {
+		decrypted.ChannelContent = copyChannelContent(event.ChannelContent)
+		for _, field := range channelContentFields(decrypted.ChannelContent) {
+			if !IsEncrypted(*field.value) {
+				continue
+			}
+			plaintext, err := e.decryptValue(rootID(event), field.name, *field.value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s: %w", field.name, err)
+			}
+			*field.value = string(plaintext)
+		}
+	}
+
+	if len(event.Metadata) > 0 {
+		decrypted.Metadata = make(map[string]any, len(event.Metadata))
+		for name, value := range event.Metadata {
+			ciphertext, ok := value.(string)
+			if !ok || !IsEncrypted(ciphertext) {
+				decrypted.Metadata[name] = value
+				continue
+			}
+
+			plaintext, err := e.decryptValue(rootID(event), "metadata."+name, ciphertext)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt metadata %s: %w", name, err)
+			}
+			var original any
+			if err := json.Unmarshal(plaintext, &original); err != nil {
+				return nil, fmt.Errorf("failed to decode metadata %s: %w", name, err)
+			}
+			decrypted.Metadata[name] = original
+		}
+	}
+
+	return &decrypted, nil
+}
+
+// contentField is an encryptable string field of the channel content
+type contentField struct {
+	name  string
+	value *string
+}
+
+// channelContentFields lists the string fields of the channel content that are encrypted
+func channelContentFields(content *messages.ChannelContent) []contentField {
+	var fields []contentField
+	if content.Email != nil {
+		fields = append(fields,
+			contentField{"channel_content.email.subject", &content.Email.Subject},
+			contentField{"channel_content.email.html", &content.Email.HTML},
+			contentField{"channel_content.email.text", &content.Email.Text},
+		)
+	}
+	if content.Push != nil {
+		fields = append(fields,
+			contentField{"channel_content.push.title", &content.Push.Title},
+			contentField{"channel_content.push.body", &content.Push.Body},
+			contentField{"channel_content.push.deep_link", &content.Push.DeepLink},
+		)
+	}
+	if content.SMS != nil {
+		fields = append(fields, contentField{"channel_content.sms.text", &content.SMS.Text})
+	}
+	return fields
+}
+
+// copyChannelContent copies the channel content so the original can be left untouched
+func copyChannelContent(content *messages.ChannelContent) *messages.ChannelContent {
+	copied := &messages.ChannelContent{}
+	if content.Email != nil {
+		email := *content.Email
+		copied.Email = &email
+	}
+	if content.Push != nil {
+		push := *content.Push
+		copied.Push = &push
+	}
+	if content.SMS != nil {
+		sms := *content.SMS
+		copied.SMS = &sms
+	}
+	return copied
+}
+
+// IsEncrypted reports whether a field value was produced by a FieldEncryptor
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, valuePrefix)
+}
+
+// encryptValue seals a single field value with the active key
+func (e *FieldEncryptor) encryptValue(notificationID, field string, plaintext []byte) (string, error) {
+	keyID, key, err := e.keys.ActiveKey()
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, associatedData(notificationID, field))
+	return valuePrefix + keyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptValue opens a single field value with the key it was sealed with
+func (e *FieldEncryptor) decryptValue(notificationID, field, value string) ([]byte, error) {
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(value, valuePrefix), ":")
+	if !ok {
+		return nil, errors.New("malformed encrypted value")
+	}
+
+	key, err := e.keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted value: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, associatedData(notificationID, field))
+}
+
+// newGCM creates an AES-GCM cipher for the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// rootID returns the ID of the event the notification was enqueued as
+func rootID(event *messages.NotificationEvent) string {
+	if event.ExpandedFrom != "" {
+		return event.ExpandedFrom
+	}
+	return event.ID
+}
+
+// associatedData binds a ciphertext to its notification and field
+func associatedData(notificationID, field string) []byte {
+	return []byte(notificationID + "\x00" + field)
+}
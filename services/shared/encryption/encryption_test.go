@@ -0,0 +1,71 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
+func newTestEncryptor(t *testing.T) *FieldEncryptor {
+	t.Helper()
+	keys, err := NewStaticKeyProvider("k1", map[string]string{
+		"k1": base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFieldEncryptor(keys, []string{"order_total"})
+}
+
+func TestFieldEncryptorDecryptsExpandedCopies(t *testing.T) {
+	e := newTestEncryptor(t)
+	event := &messages.NotificationEvent{
+		ID:             "event-1",
+		Content:        "Your order shipped",
+		ChannelContent: &messages.ChannelContent{SMS: &messages.SMSContent{Text: "Shipped"}},
+		Metadata:       map[string]any{"order_total": 42.5, "tenant": "acme"},
+	}
+
+	encrypted, err := e.Encrypt(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted.Content) || !IsEncrypted(encrypted.ChannelContent.SMS.Text) || encrypted.Metadata["tenant"] != "acme" {
+		t.Fatalf("encrypted event = %+v", encrypted)
+	}
+
+	// A recipient's copy gets an ID of its own and keeps the event's
+	recipient := *encrypted
+	recipient.ID = "event-1:user-2"
+	recipient.ExpandedFrom = "event-1"
+
+	for _, copied := range []*messages.NotificationEvent{encrypted, &recipient} {
+		decrypted, err := e.Decrypt(copied)
+		if err != nil {
+			t.Fatalf("decrypting %s: %v", copied.ID, err)
+		}
+		if decrypted.Content != event.Content || decrypted.ChannelContent.SMS.Text != "Shipped" || decrypted.Metadata["order_total"] != 42.5 {
+			t.Errorf("decrypted %s = %+v", copied.ID, decrypted)
+		}
+	}
+}
+
+func TestFieldEncryptorRejectsSwappedValues(t *testing.T) {
+	e := newTestEncryptor(t)
+	first, err := e.Encrypt(&messages.NotificationEvent{ID: "event-1", Content: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	swapped := &messages.NotificationEvent{ID: "event-2", Content: first.Content}
+	if _, err := e.Decrypt(swapped); err == nil {
+		t.Error("content of another notification decrypted")
+	}
+
+	moved := &messages.NotificationEvent{ID: "event-1", ChannelContent: &messages.ChannelContent{SMS: &messages.SMSContent{Text: first.Content}}}
+	if _, err := e.Decrypt(moved); err == nil {
+		t.Error("content moved to another field decrypted")
+	}
+}
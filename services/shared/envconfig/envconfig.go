@@ -0,0 +1,105 @@
+// Package envconfig loads the services' configuration from environment variables
+package envconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/secrets"
+)
+
+// Loads an integer value from environment variable
+func LoadIntEnv(key string, target *int) {
+	if value := os.Getenv(key); value != "" {
+		fmt.Sscanf(value, "%d", target)
+	}
+}
+
+// Loads a string value from environment variable
+func LoadStringEnv(key string, target *string) {
+	if value := os.Getenv(key); value != "" {
+		*target = value
+	}
+}
+
+// Loads a duration value from environment variable
+func LoadDurationEnv(key string, target *time.Duration) {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			*target = duration
+		}
+	}
+}
+
+// Loads a boolean value from environment variable
+func LoadBoolEnv(key string, target *bool) {
+	if value := os.Getenv(key); value != "" {
+		*target = value == "true"
+	}
+}
+
+// Loads a JSON string array from environment variable
+func LoadJSONStringArrayEnv(key string, target *[]string) {
+	if value := os.Getenv(key); value != "" {
+		var result []string
+		if err := json.Unmarshal([]byte(value), &result); err == nil {
+			*target = result
+		}
+	}
+}
+
+// Loads a float value from environment variable
+func LoadFloatEnv(key string, target *float64) {
+	if value := os.Getenv(key); value != "" {
+		fmt.Sscanf(value, "%g", target)
+	}
+}
+
+// Loads a JSON map of numbers from environment variable
+func LoadJSONFloatMapEnv(key string, target *map[string]float64) {
+	if value := os.Getenv(key); value != "" {
+		var result map[string]float64
+		if err := json.Unmarshal([]byte(value), &result); err == nil {
+			*target = result
+		}
+	}
+}
+
+// Loads a JSON string map from environment variable
+func LoadJSONStringMapEnv(key string, target *map[string]string) {
+	if value := os.Getenv(key); value != "" {
+		var result map[string]string
+		if err := json.Unmarshal([]byte(value), &result); err == nil {
+			*target = result
+		}
+	}
+}
+
+// Loads a JSON string map from environment variable holding it or
+// referring to a secret holding it
+func LoadJSONStringMapSecretEnv(store *secrets.Store, key string, target *map[string]string) error {
+	var value string
+	if err := store.Load(key, &value); err != nil {
+		return err
+	}
+	if value != "" {
+		var result map[string]string
+		if err := json.Unmarshal([]byte(value), &result); err != nil {
+			return fmt.Errorf("%s is not a JSON object of strings: %w", key, err)
+		}
+		*target = result
+	}
+	return nil
+}
+
+// Loads a JSON map of integers from environment variable
+func LoadJSONIntMapEnv(key string, target *map[string]int) {
+	if value := os.Getenv(key); value != "" {
+		var result map[string]int
+		if err := json.Unmarshal([]byte(value), &result); err == nil {
+			*target = result
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package exprrules evaluates rules written as expressions, such as
+//
+//	event_type == "payment_failed" && metadata.amount > 500
+//
+// against notifications, so routing and suppression can be changed through
+// the admin API without a deployment. Expressions use the expr language
+// (https://expr-lang.org) and see the notification's JSON fields, e.g.
+// event_type, user_id, priority, channels and metadata. Each rule's program
+// is compiled once and reused until its expression changes.
+package exprrules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// ErrInvalid is returned for rules that can't be used
+var ErrInvalid = errors.New("invalid expression rules")
+
+// Rule applies its action to the notifications its expression matches. Each
+// service reads the actions it acts on.
+type Rule struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`         // Must evaluate to a boolean
+	Priority   string `json:"priority,omitempty"` // Priority the prioritizer gives matching notifications
+	Suppress   bool   `json:"suppress,omitempty"` // The rate limiter suppresses matching notifications
+	Reason     string `json:"reason,omitempty"`   // Why, for logs and debug traces
+}
+
+// compiledRule is a rule along with its program
+type compiledRule struct {
+	Rule
+	program *vm.Program
+}
+
+// Engine evaluates the rules stored in a file, in order
+type Engine struct {
+	file string
+
+	mu       sync.RWMutex
+	rules    []compiledRule
+	modified time.Time // Of the file the rules were loaded from
+}
+
+// New creates an engine and loads the rules in the file, none when it
+// doesn't exist yet
+func New(file string) (*Engine, error) {
+	e := &Engine{file: file}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Match returns the first rule that applies to the notification, as the
+// service decides, and whose expression matches it. A rule failing on the
+// notification is logged and skipped.
+func (e *Engine) Match(notification any, applies func(Rule) bool) (*Rule, bool, error) {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+	if len(rules) == 0 {
+		return nil, false, nil
+	}
+
+	env, err := environment(notification)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, rule := range rules {
+		if !applies(rule.Rule) {
+			continue
+		}
+		matched, err := expr.Run(rule.program, env)
+		if err != nil {
+			log.Printf("Expression rule %s failed: %v", rule.Name, err)
+			continue
+		}
+		if matched == true {
+			return &rule.Rule, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Rules returns the rules in use, in order
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	rules := make([]Rule, 0, len(e.rules))
+	for _, rule := range e.rules {
+		rules = append(rules, rule.Rule)
+	}
+	return rules
+}
+
+// Replace checks and compiles the rules, stores them in the file and uses
+// them from now on instead of the previous ones
+func (e *Engine) Replace(rules []Rule) error {
+	compiled, err := e.compile(rules)
+	if err != nil {
+		return err
+	}
+
+	// Write it next to the file and rename it, so readers never see half the rules
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rules: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(e.file), 0o755); err != nil {
+		return fmt.Errorf("failed to store rules: %w", err)
+	}
+	tmp := e.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to store rules: %w", err)
+	}
+	if err := os.Rename(tmp, e.file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to store rules: %w", err)
+	}
+	info, err := os.Stat(e.file)
+	if err != nil {
+		return fmt.Errorf("failed to store rules: %w", err)
+	}
+
+	e.mu.Lock()
+	e.rules, e.modified = compiled, info.ModTime()
+	e.mu.Unlock()
+	return nil
+}
+
+// Reload picks up the rules other instances sharing the file stored. Rules
+// that don't compile are logged and leave the previous ones in use.
+func (e *Engine) Reload() error {
+	info, err := os.Stat(e.file)
+	if os.IsNotExist(err) {
+		e.mu.Lock()
+		e.rules, e.modified = nil, time.Time{}
+		e.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read expression rules: %w", err)
+	}
+	e.mu.RLock()
+	unchanged := e.modified.Equal(info.ModTime())
+	e.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(e.file)
+	if err != nil {
+		return fmt.Errorf("failed to read expression rules: %w", err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("%w: %s is not a JSON array of rules: %v", ErrInvalid, e.file, err)
+	}
+	compiled, err := e.compile(rules)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules, e.modified = compiled, info.ModTime()
+	e.mu.Unlock()
+	log.Printf("Loaded %d expression rules", len(compiled))
+	return nil
+}
+
+// Run reloads the rules every interval until the context is done
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Reload(); err != nil {
+				log.Printf("Failed to reload expression rules: %v", err)
+			}
+		}
+	}
+}
+
+// compile compiles the rules, reusing the programs of unchanged expressions
+func (e *Engine) compile(rules []Rule) ([]compiledRule, error) {
+	e.mu.RLock()
+	cached := make(map[string]*vm.Program, len(e.rules))
+	for _, rule := range e.rules {
+		cached[rule.Expression] = rule.program
+	}
+	e.mu.RUnlock()
+
+	names := make(map[string]bool, len(rules))
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("%w: rule %d has no name", ErrInvalid, i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w: rule %s is defined twice", ErrInvalid, rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Priority == "" && !rule.Suppress {
+			return nil, fmt.Errorf("%w: rule %s neither sets a priority nor suppresses", ErrInvalid, rule.Name)
+		}
+
+		program, ok := cached[rule.Expression]
+		if !ok {
+			var err error
+			program, err = expr.Compile(rule.Expression, expr.AsBool(), expr.AllowUndefinedVariables())
+			if err != nil {
+				return nil, fmt.Errorf("%w: rule %s: %v", ErrInvalid, rule.Name, err)
+			}
+			cached[rule.Expression] = program
+		}
+		compiled = append(compiled, compiledRule{Rule: rule, program: program})
+	}
+	return compiled, nil
+}
+
+// environment returns the fields of a notification expressions see
+func environment(notification any) (map[string]any, error) {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	var env map[string]any
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notification: %w", err)
+	}
+	// Rules on metadata don't fail on notifications without any
+	if env["metadata"] == nil {
+		env["metadata"] = map[string]any{}
+	}
+	return env, nil
+}
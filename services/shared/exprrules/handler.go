@@ -0,0 +1,47 @@
+package exprrules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Handler serves the expression rule API:
+//
+//	GET /expression-rules  the rules in use, in order
+//	PUT /expression-rules  a JSON array of rules as the body, replacing them all
+//
+// Rules are compiled before they are stored, so rules that can't be used are
+// refused with 400 and the previous ones stay in use.
+func (e *Engine) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /expression-rules", e.handleRules)
+	mux.HandleFunc("PUT /expression-rules", e.handleReplace)
+	return mux
+}
+
+// Handles requests for the rules in use
+func (e *Engine) handleRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"rules": e.Rules(),
+	})
+}
+
+// Handles requests to replace the rules
+func (e *Engine) handleReplace(w http.ResponseWriter, r *http.Request) {
+	var rules []Rule
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, fmt.Sprintf("invalid rules: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := e.Replace(rules); errors.Is(err, ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.handleRules(w, r)
+}
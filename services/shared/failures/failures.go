@@ -0,0 +1,121 @@
+package failures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of failure processors wrap their errors in, so the consumer can
+// decide what happens to a message that failed
+var (
+	// The message is malformed or breaks the contract, retrying cannot help
+	ErrValidation = errors.New("validation failed")
+	// The message was deliberately suppressed by a rate limit
+	ErrRateLimited = errors.New("rate limited")
+	// The message was deliberately not processed, such as one for a deleted user
+	ErrSkipped = errors.New("skipped")
+	// A dependency failed in a way that may succeed when retried
+	ErrTransient = errors.New("transient failure")
+	// Processing failed in a way retrying cannot fix
+	ErrPermanent = errors.New("permanent failure")
+	// The message must not be processed before a later time, see Deferred
+	ErrDeferred = errors.New("deferred")
+)
+
+// What the consumer does with a failed message
+type Action int
+
+const (
+	// Process the message again after a backoff
+	ActionRetry Action = iota
+	// Park the message on the dead-letter topic for inspection
+	ActionDeadLetter
+	// Acknowledge the message without further handling
+	ActionDrop
+	// Process the message again once it is due, see DeferredUntil
+	ActionDefer
+)
+
+// Validation marks err as a validation failure
+func Validation(err error) error {
+	return fmt.Errorf("%w: %w", ErrValidation, err)
+}
+
+// Transient marks err as a failure worth retrying
+func Transient(err error) error {
+	return fmt.Errorf("%w: %w", ErrTransient, err)
+}
+
+// Permanent marks err as a failure retrying cannot fix
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+// Skipped marks err as a deliberate decision not to process the message
+func Skipped(err error) error {
+	return fmt.Errorf("%w: %w", ErrSkipped, err)
+}
+
+// deferral is a failure to process a message before it is due
+type deferral struct {
+	until time.Time
+	err   error
+}
+
+func (d *deferral) Error() string {
+	return fmt.Sprintf("%v until %s: %v", ErrDeferred, d.until.Format(time.RFC3339), d.err)
+}
+
+func (d *deferral) Unwrap() []error {
+	return []error{ErrDeferred, d.err}
+}
+
+// Deferred marks err as a reason to process the message again once until is reached
+func Deferred(until time.Time, err error) error {
+	return &deferral{until: until, err: err}
+}
+
+// DeferredUntil returns when a deferred message is due
+func DeferredUntil(err error) (time.Time, bool) {
+	var d *deferral
+	if errors.As(err, &d) {
+		return d.until, true
+	}
+	return time.Time{}, false
+}
+
+// ActionFor decides what the consumer does with a failed message. Failures
+// of unknown kind are dead-lettered, so nothing is lost silently.
+func ActionFor(err error) Action {
+	switch {
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrSkipped):
+		return ActionDrop
+	case errors.Is(err, ErrDeferred):
+		return ActionDefer
+	case errors.Is(err, ErrTransient):
+		return ActionRetry
+	default:
+		return ActionDeadLetter
+	}
+}
+
+// WithRetries calls fn until it succeeds or fails with something other than
+// a transient failure, at most retries+1 times, doubling backoff after each
+// attempt. It gives up early once the context is done.
+func WithRetries(ctx context.Context, retries int, backoff time.Duration, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || ActionFor(err) != ActionRetry || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
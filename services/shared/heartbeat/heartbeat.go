@@ -0,0 +1,160 @@
+// Package heartbeat publishes the heartbeats pipeline instances announce
+// themselves with on the ops topic, and defines their schema for the
+// rate limiter's monitor.
+package heartbeat
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+)
+
+// Heartbeat announces that a pipeline instance is alive and how it keeps up.
+// Every instance publishes one per interval on the ops topic, keyed by instance ID.
+type Heartbeat struct {
+	Service         string    `json:"service"`
+	InstanceID      string    `json:"instance_id"`
+	Version         string    `json:"version"`
+	Commit          string    `json:"commit"`
+	StartedAt       time.Time `json:"started_at"`
+	SentAt          time.Time `json:"sent_at"`
+	IntervalSeconds float64   `json:"interval_seconds"`   // Time until the next heartbeat
+	Processed       int64     `json:"processed"`          // Messages or requests handled since start
+	Lag             int64     `json:"lag"`                // Messages behind the input topics, zero for HTTP services
+	Errors          int64     `json:"errors"`             // Requests of those answered with a server error, HTTP services only
+	Stopping        bool      `json:"stopping,omitempty"` // Last heartbeat of a graceful shutdown
+
+	// Notifications settled since start by priority and outcome
+	Outcomes map[string]map[string]int64 `json:"outcomes,omitempty"`
+	// Notifications since start by priority that failed for good, dead-lettered or dropped
+	Failed map[string]int64 `json:"failed,omitempty"`
+	// Notifications since start by priority delivered later than the priority's objective
+	Slow map[string]int64 `json:"slow,omitempty"`
+}
+
+// Stats fills in the instance's progress for a heartbeat
+type Stats func(heartbeat *Heartbeat)
+
+// Config of the heartbeats of an instance
+type Config struct {
+	Service    string
+	InstanceID string
+	Topic      string
+	Interval   time.Duration
+}
+
+// Publisher publishes the heartbeats of this instance
+type Publisher struct {
+	producer  sarama.SyncProducer
+	topic     string
+	interval  time.Duration
+	heartbeat Heartbeat // Fields that don't change between heartbeats
+	stats     Stats
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New connects a producer to the ops topic, creating it when missing, and
+// starts publishing every interval
+func New(producerCfg kafkaproducer.Config, cfg Config, stats Stats) (*Publisher, error) {
+	producer, err := kafkaproducer.NewSyncProducer(producerCfg, cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return Start(producer, cfg, stats), nil
+}
+
+// Start publishes a heartbeat with the producer every interval until the
+// publisher is closed, which closes the producer
+func Start(producer sarama.SyncProducer, cfg Config, stats Stats) *Publisher {
+	build := buildinfo.Get()
+	p := &Publisher{
+		producer: producer,
+		topic:    cfg.Topic,
+		interval: cfg.Interval,
+		heartbeat: Heartbeat{
+			Service:         cfg.Service,
+			InstanceID:      cfg.InstanceID,
+			Version:         build.Version,
+			Commit:          build.Commit,
+			StartedAt:       time.Now(),
+			IntervalSeconds: cfg.Interval.Seconds(),
+		},
+		stats: stats,
+		done:  make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p
+}
+
+// run publishes a heartbeat every interval until the publisher is closed
+func (p *Publisher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.publish(false)
+
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish sends a heartbeat with the current stats, failures are only logged.
+// A send is given up on after an interval, it may still complete in the background.
+func (p *Publisher) publish(stopping bool) {
+	heartbeat := p.heartbeat
+	heartbeat.SentAt = time.Now()
+	p.stats(&heartbeat)
+	heartbeat.Stopping = stopping
+
+	payload, err := json.Marshal(heartbeat)
+	if err != nil {
+		log.Printf("Failed to marshal heartbeat: %v", err)
+		return
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: p.topic,
+		Key:   sarama.StringEncoder(heartbeat.InstanceID),
+		Value: sarama.ByteEncoder(payload),
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		_, _, err := p.producer.SendMessage(msg)
+		sent <- err
+	}()
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			log.Printf("Failed to publish heartbeat: %v", err)
+		}
+	case <-time.After(p.interval):
+		log.Printf("Failed to publish heartbeat: no answer within %v", p.interval)
+	}
+}
+
+// Close stops publishing, announces the instance is stopping and closes the producer
+func (p *Publisher) Close() error {
+	close(p.done)
+	p.wg.Wait()
+
+	p.publish(true)
+	return p.producer.Close()
+}
@@ -0,0 +1,53 @@
+// Package jsonpool encodes messages into pooled buffers, so the hot produce
+// paths don't allocate a fresh buffer and encoder for every message
+package jsonpool
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// Buffers that grew larger than this are dropped instead of pooled, so one
+// oversized message doesn't pin its memory for good
+const maxPooledBytes = 1 << 20
+
+// Buffer holds an encoded message
+type Buffer struct {
+	buf     bytes.Buffer
+	encoder *json.Encoder
+}
+
+var pool = sync.Pool{
+	New: func() any {
+		b := &Buffer{}
+		b.encoder = json.NewEncoder(&b.buf)
+		return b
+	},
+}
+
+// Marshal encodes v as json.Marshal does into a pooled buffer. Release the
+// buffer once nothing reads its bytes any more.
+func Marshal(v any) (*Buffer, error) {
+	b := pool.Get().(*Buffer)
+	if err := b.encoder.Encode(v); err != nil {
+		b.Release()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Bytes returns the encoded message, valid until the buffer is released
+func (b *Buffer) Bytes() []byte {
+	// The encoder ends every value with a newline json.Marshal leaves out
+	return bytes.TrimSuffix(b.buf.Bytes(), []byte("\n"))
+}
+
+// Release returns the buffer to the pool
+func (b *Buffer) Release() {
+	if b.buf.Cap() > maxPooledBytes {
+		return
+	}
+	b.buf.Reset()
+	pool.Put(b)
+}
@@ -0,0 +1,130 @@
+package jsonpool
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+)
+
+// testEvent is a notification of typical size, with metadata and channel content
+func testEvent() *messages.NotificationEvent {
+	return &messages.NotificationEvent{
+		SchemaVersion: messages.SchemaVersion,
+		ID:            "3f2b9c1e-8d4a-4b7e-9f6a-2c1d0e5f7a8b",
+		UserID:        "user-12345",
+		EventType:     "comment",
+		Content:       "Alice replied to your post: \"Sounds good, see you <there> & then\"",
+		Metadata: map[string]any{
+			"tenant":  "acme",
+			"post_id": 42,
+			"tags":    []any{"news", "sports"},
+			"author":  map[string]any{"id": "user-1", "name": "Alice"},
+		},
+		ChannelContent: &messages.ChannelContent{
+			Email: &messages.EmailContent{Subject: "New reply", HTML: "<p>Alice replied to your post</p>"},
+			SMS:   &messages.SMSContent{Text: "Alice replied to your post"},
+		},
+		CreatedAt: 1700000000,
+	}
+}
+
+func TestMarshalMatchesJSON(t *testing.T) {
+	values := []any{
+		testEvent(),
+		map[string]any{"html": "<b>&</b>", "n": 1.5, "none": nil},
+		"text with a newline\n",
+		[]int{1, 2, 3},
+	}
+
+	for _, v := range values {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal(%v): %v", v, err)
+		}
+		if got := buf.Bytes(); !bytes.Equal(got, want) {
+			t.Errorf("Marshal(%v) = %s, want %s", v, got, want)
+		}
+		buf.Release()
+	}
+
+	if _, err := Marshal(func() {}); err == nil {
+		t.Error("marshaling a function succeeded")
+	}
+}
+
+func TestReleasedBufferIsReset(t *testing.T) {
+	first, err := Marshal(strings.Repeat("a", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Release()
+
+	// A reused buffer holds only the new message
+	for i := 0; i < 10; i++ {
+		buf, err := Marshal("b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf.Bytes()); got != `"b"` {
+			t.Fatalf("Marshal(b) = %s", got)
+		}
+		buf.Release()
+	}
+
+	// Oversized buffers aren't kept
+	large, err := Marshal(strings.Repeat("a", maxPooledBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	large.Release()
+	buf, _ := Marshal("c")
+	defer buf.Release()
+	if buf.buf.Cap() > maxPooledBytes {
+		t.Errorf("got a pooled buffer of %d bytes", buf.buf.Cap())
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	event := testEvent()
+
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(event); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf, err := Marshal(event)
+			if err != nil {
+				b.Fatal(err)
+			}
+			buf.Release()
+		}
+	})
+
+	b.Run("pooled-parallel", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				buf, err := Marshal(event)
+				if err != nil {
+					b.Fatal(err)
+				}
+				buf.Release()
+			}
+		})
+	})
+}
@@ -0,0 +1,177 @@
+package kafkaadmin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// SelectCluster returns the index of the first cluster of the list, most
+// preferred first, whose brokers are reachable
+func SelectCluster(clusters [][]string, timeout time.Duration) (int, error) {
+	for i, brokers := range clusters {
+		err := probeCluster(brokers, timeout)
+		if err == nil {
+			return i, nil
+		}
+		log.Printf("Kafka cluster %v is unreachable: %v", brokers, err)
+	}
+	return -1, fmt.Errorf("none of the %d Kafka clusters is reachable", len(clusters))
+}
+
+// probeCluster checks that one of the brokers answers within the timeout
+func probeCluster(brokers []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return CheckTopics(ctx, brokers)
+}
+
+// TranslateOffsets starts group on a cluster it failed over to. Offsets
+// don't carry over between clusters, so on partitions without a committed
+// offset the group starts at the first message produced at or after since,
+// found by timestamp, which mirroring preserves. Nothing is committed once
+// the group has offsets on the cluster, synced by the mirroring or left by
+// an earlier failover.
+func TranslateOffsets(brokers []string, group string, topics []string, since time.Time) error {
+	client, err := sarama.NewClient(brokers, sarama.NewConfig())
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+	defer admin.Close() // Also closes the client
+
+	// Collect the partitions of every topic
+	partitions := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		if partitions[topic], err = client.Partitions(topic); err != nil {
+			return fmt.Errorf("failed to get partitions of %s: %w", topic, err)
+		}
+	}
+
+	current, err := admin.ListConsumerGroupOffsets(group, partitions)
+	if err != nil {
+		return fmt.Errorf("failed to get offsets of group %s: %w", group, err)
+	}
+	if HasOffsets(current, partitions) {
+		log.Printf("Consumer group %s already has offsets on this cluster, keeping them", group)
+		return nil
+	}
+
+	offsets := &sarama.OffsetFetchResponse{}
+	for topic, topicPartitions := range partitions {
+		for _, partition := range topicPartitions {
+			offset, err := client.GetOffset(topic, partition, since.UnixMilli())
+			if err != nil {
+				return fmt.Errorf("failed to get offset of %s/%d at %v: %w", topic, partition, since, err)
+			}
+
+			// Nothing was produced since, start after the last message
+			if offset < 0 {
+				if offset, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+					return fmt.Errorf("failed to get newest offset of %s/%d: %w", topic, partition, err)
+				}
+			}
+			offsets.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: offset})
+		}
+	}
+
+	return CommitOffsets(client, group, offsets, partitions)
+}
+
+// ClusterWatch probes the cluster the service runs on and fails over once
+// it has been unreachable for a while and another cluster of the list is
+// reachable. Offsets and sessions don't move between clusters, so failing
+// over is left to a restart: the failover function is expected to shut the
+// service down for its restart to select the next cluster.
+type ClusterWatch struct {
+	clusters [][]string
+	active   int
+	interval time.Duration
+	after    time.Duration
+	failover func(next int)
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// WatchCluster starts probing the active cluster of the list every interval
+func WatchCluster(clusters [][]string, active int, interval, after time.Duration, failover func(next int)) *ClusterWatch {
+	w := &ClusterWatch{
+		clusters: clusters,
+		active:   active,
+		interval: interval,
+		after:    after,
+		failover: failover,
+		done:     make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w
+}
+
+// run probes the active cluster until it fails over or the watch is closed
+func (w *ClusterWatch) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var downSince time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+
+		err := probeCluster(w.clusters[w.active], w.interval)
+		if err == nil {
+			downSince = time.Time{}
+			continue
+		}
+		if downSince.IsZero() {
+			downSince = time.Now()
+		}
+		log.Printf("Active Kafka cluster %v unreachable for %v: %v", w.clusters[w.active], time.Since(downSince).Round(time.Second), err)
+		if time.Since(downSince) < w.after {
+			continue
+		}
+
+		if next := w.standby(); next >= 0 {
+			log.Printf("Failing over from Kafka cluster %v to %v", w.clusters[w.active], w.clusters[next])
+			w.failover(next)
+			return
+		}
+	}
+}
+
+// standby returns the first reachable cluster other than the active one, -1 when there is none
+func (w *ClusterWatch) standby() int {
+	for i, brokers := range w.clusters {
+		if i == w.active {
+			continue
+		}
+		if err := probeCluster(brokers, w.interval); err == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// Close stops probing
+func (w *ClusterWatch) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package kafkaadmin holds the consumer group and cluster operations the
+// services run at startup, outside of their consumers
+package kafkaadmin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// How often the previous group is checked while waiting for it to drain
+const handoverPollInterval = 5 * time.Second
+
+// HandOver moves the committed position of consumer group from to consumer
+// group to, so a new deployment consuming as to continues exactly where the
+// old one stopped. It waits until from has no members left, meaning the old
+// deployment has stopped and committed its last offsets, then copies those
+// offsets. Nothing is copied once to has offsets of its own, which makes
+// restarts of the new deployment safe.
+func HandOver(ctx context.Context, brokers []string, from, to string, topics []string, timeout time.Duration) error {
+	client, err := sarama.NewClient(brokers, sarama.NewConfig())
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+	defer admin.Close() // Also closes the client
+
+	// Collect the partitions of every topic
+	partitions := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		if partitions[topic], err = client.Partitions(topic); err != nil {
+			return fmt.Errorf("failed to get partitions of %s: %w", topic, err)
+		}
+	}
+
+	// A group that already has offsets has taken over before
+	current, err := admin.ListConsumerGroupOffsets(to, partitions)
+	if err != nil {
+		return fmt.Errorf("failed to get offsets of group %s: %w", to, err)
+	}
+	if HasOffsets(current, partitions) {
+		log.Printf("Consumer group %s already has offsets, skipping handover from %s", to, from)
+		return nil
+	}
+
+	if err := waitForDrain(ctx, admin, from, timeout); err != nil {
+		return err
+	}
+
+	previous, err := admin.ListConsumerGroupOffsets(from, partitions)
+	if err != nil {
+		return fmt.Errorf("failed to get offsets of group %s: %w", from, err)
+	}
+
+	return CommitOffsets(client, to, previous, partitions)
+}
+
+// waitForDrain waits until the group has no active members
+func waitForDrain(ctx context.Context, admin sarama.ClusterAdmin, group string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		groups, err := admin.DescribeConsumerGroups([]string{group})
+		if err != nil {
+			return fmt.Errorf("failed to describe group %s: %w", group, err)
+		}
+		if len(groups) == 1 && (groups[0].State == "Empty" || groups[0].State == "Dead") {
+			log.Printf("Consumer group %s has drained", group)
+			return nil
+		}
+
+		log.Printf("Waiting for consumer group %s to drain", group)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("consumer group %s did not drain: %w", group, ctx.Err())
+		case <-time.After(handoverPollInterval):
+		}
+	}
+}
+
+// CommitOffsets commits the given offsets for group, which must have no
+// active members. It fails unless every partition's offset was committed.
+func CommitOffsets(client sarama.Client, group string, offsets *sarama.OffsetFetchResponse, partitions map[string][]int32) error {
+	request := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1, // The broker's retention
+	}
+	committed := 0
+	for topic, topicPartitions := range partitions {
+		for _, partition := range topicPartitions {
+			block := offsets.GetBlock(topic, partition)
+			if block == nil || block.Offset < 0 {
+				continue
+			}
+			request.AddBlock(topic, partition, block.Offset, 0, block.Metadata)
+			committed++
+			log.Printf("Committing %s/%d at offset %d for group %s", topic, partition, block.Offset, group)
+		}
+	}
+	if committed == 0 {
+		return nil
+	}
+
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return fmt.Errorf("failed to find coordinator of group %s: %w", group, err)
+	}
+	response, err := coordinator.CommitOffset(request)
+	if err != nil {
+		return fmt.Errorf("failed to commit offsets of group %s: %w", group, err)
+	}
+	for topic, errs := range response.Errors {
+		for partition, kerr := range errs {
+			if kerr != sarama.ErrNoError {
+				return fmt.Errorf("failed to commit offset of %s/%d for group %s: %w", topic, partition, group, kerr)
+			}
+		}
+	}
+	return nil
+}
+
+// HasOffsets reports whether any of the partitions has a committed offset
+func HasOffsets(offsets *sarama.OffsetFetchResponse, partitions map[string][]int32) bool {
+	for topic, topicPartitions := range partitions {
+		for _, partition := range topicPartitions {
+			if block := offsets.GetBlock(topic, partition); block != nil && block.Offset >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package kafkaadmin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// CheckTopics connects to the brokers and verifies the topics exist, for
+// preflight checks. Its errors name the unreachable brokers or the missing topics.
+func CheckTopics(ctx context.Context, brokers []string, topics ...string) error {
+	config := sarama.NewConfig()
+	config.Metadata.Retry.Max = 0
+	if deadline, ok := ctx.Deadline(); ok {
+		config.Net.DialTimeout = time.Until(deadline)
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return fmt.Errorf("none of the brokers %v is reachable: %w", brokers, err)
+	}
+	defer client.Close()
+
+	if len(topics) == 0 {
+		return nil
+	}
+
+	existing, err := client.Topics()
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %w", err)
+	}
+	found := make(map[string]bool, len(existing))
+	for _, topic := range existing {
+		found[topic] = true
+	}
+
+	var missing []string
+	for _, topic := range topics {
+		if !found[topic] {
+			missing = append(missing, topic)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("topics %v do not exist", missing)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package kafkaadmin
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/IBM/sarama"
+)
+
+// TopicManager creates the topics a service produces to
+type TopicManager struct {
+	admin  sarama.ClusterAdmin
+	topics map[string]bool
+}
+
+// NewTopicManager creates a topic manager for a cluster
+func NewTopicManager(brokers []string) (*TopicManager, error) {
+	config := sarama.NewConfig()
+	admin, err := sarama.NewClusterAdmin(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+
+	topicManager := TopicManager{
+		admin:  admin,
+		topics: make(map[string]bool),
+	}
+
+	return &topicManager, nil
+}
+
+// EnsureTopic creates a topic, or adds partitions to it, unless it was checked before
+func (tm *TopicManager) EnsureTopic(topic string, partitions, replicationFactor int) error {
+	// If we've already checked this topic, skip
+	if _, exists := tm.topics[topic]; exists {
+		return nil
+	}
+
+	// Check if topic exists in Kafka
+	topics, err := tm.admin.ListTopics()
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	// Log existing topics for debugging
+	log.Println("Checking for topic:", topic)
+
+	existingTopic, topicExists := topics[topic]
+
+	// Create new topic if it doesn't exist
+	if !topicExists {
+		return tm.createNewTopic(topic, partitions, replicationFactor)
+	}
+
+	// Otherwise, update existing topic if needed
+	return tm.updateExistingTopic(topic, partitions, replicationFactor, existingTopic)
+}
+
+// EnsureCompactedTopic creates a single-partition topic that keeps the last
+// message of every key, for state consumers read from the start
+func (tm *TopicManager) EnsureCompactedTopic(topic string, replicationFactor int) error {
+	if _, exists := tm.topics[topic]; exists {
+		return nil
+	}
+
+	topics, err := tm.admin.ListTopics()
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %w", err)
+	}
+	if _, exists := topics[topic]; !exists {
+		compact := "compact"
+		topicDetail := &sarama.TopicDetail{
+			NumPartitions:     1,
+			ReplicationFactor: int16(replicationFactor),
+			ConfigEntries:     map[string]*string{"cleanup.policy": &compact},
+		}
+
+		log.Printf("Creating new compacted topic %s", topic)
+		if err := tm.admin.CreateTopic(topic, topicDetail, false); err != nil {
+			return fmt.Errorf("failed to create topic %s: %w", topic, err)
+		}
+	}
+
+	tm.topics[topic] = true
+	return nil
+}
+
+// Creates a new Kafka topic
+func (tm *TopicManager) createNewTopic(topic string, partitions, replicationFactor int) error {
+	topicDetail := &sarama.TopicDetail{
+		NumPartitions:     int32(partitions),
+		ReplicationFactor: int16(replicationFactor),
+	}
+
+	log.Printf("Creating new topic %s", topic)
+	err := tm.admin.CreateTopic(topic, topicDetail, false)
+	if err != nil {
+		return fmt.Errorf("failed to create topic %s: %w", topic, err)
+	}
+
+	log.Printf("Created topic %s with %d partitions and replication factor %d",
+		topic, partitions, replicationFactor)
+
+	// Mark this topic as checked
+	tm.topics[topic] = true
+	return nil
+}
+
+// Updates an existing topic if configuration has changed
+func (tm *TopicManager) updateExistingTopic(topic string, partitions, replicationFactor int, existingTopic sarama.TopicDetail) error {
+	log.Printf("Topic %s already exists with %d partitions and replication factor %d",
+		topic, existingTopic.NumPartitions, existingTopic.ReplicationFactor)
+
+	// Check if partitions need to be increased
+	if existingTopic.NumPartitions < int32(partitions) {
+		log.Printf("Attempting to update topic %s to have %d partitions",
+			topic, partitions)
+
+		err := tm.admin.CreatePartitions(topic, int32(partitions), nil, false)
+		if err != nil {
+			return fmt.Errorf("failed to update partitions for topic %s: %w", topic, err)
+		}
+		log.Printf("Updated topic %s to %d partitions", topic, partitions)
+	}
+
+	// Warn if replication factor differs (can't be changed after creation)
+	if existingTopic.ReplicationFactor != int16(replicationFactor) {
+		log.Printf("Warning: Topic %s has replication factor %d but configuration specifies %d. "+
+			"Replication factor cannot be changed after topic creation.",
+			topic, existingTopic.ReplicationFactor, replicationFactor)
+	}
+
+	// Mark this topic as checked
+	tm.topics[topic] = true
+	return nil
+}
+
+// Close closes the cluster admin
+func (tm *TopicManager) Close() error {
+	if tm.admin != nil {
+		return tm.admin.Close()
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Package kafkaproducer connects the signed producers the services write to
+// Kafka with.
+package kafkaproducer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+)
+
+// Config of a producer, shared by the producers of a service
+type Config struct {
+	Brokers           []string
+	RequiredAcks      int
+	RetryMax          int
+	MaxMessageBytes   int // Zero keeps Sarama's default
+	Partitions        int // Of the topics created for the producer
+	ReplicationFactor int
+	Signing           signing.Config // Keys produced messages are signed with
+}
+
+// NewSyncProducer ensures the topics exist, makes the producer sign its
+// messages and connects it
+func NewSyncProducer(cfg Config, topics ...string) (sarama.SyncProducer, error) {
+	// Configure Sarama
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.RequiredAcks(cfg.RequiredAcks)
+	config.Producer.Retry.Max = cfg.RetryMax
+	config.Producer.Return.Successes = true
+	if cfg.MaxMessageBytes > 0 {
+		config.Producer.MaxMessageBytes = cfg.MaxMessageBytes
+	}
+
+	if len(topics) > 0 {
+		topicManager, err := kafkaadmin.NewTopicManager(cfg.Brokers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create topic manager: %w", err)
+		}
+		defer topicManager.Close()
+
+		for _, topic := range topics {
+			if err := topicManager.EnsureTopic(topic, cfg.Partitions, cfg.ReplicationFactor); err != nil {
+				return nil, fmt.Errorf("failed to ensure topic %s exists: %w", topic, err)
+			}
+		}
+	}
+
+	// Sign the messages for consumers to verify
+	if err := signing.SignMessages(config, cfg.Signing); err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create producer: %w", err)
+	}
+	return producer, nil
+}
+
+// Send sends the message, giving up after the timeout or when the context
+// ends. An abandoned send may still complete in the background.
+func Send(ctx context.Context, producer sarama.SyncProducer, msg *sarama.ProducerMessage, timeout time.Duration) (int32, int64, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	type sendResult struct {
+		partition int32
+		offset    int64
+		err       error
+	}
+
+	done := make(chan sendResult, 1)
+	go func() {
+		partition, offset, err := producer.SendMessage(msg)
+		done <- sendResult{partition, offset, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.partition, result.offset, result.err
+	case <-ctx.Done():
+		return 0, 0, fmt.Errorf("send aborted: %w", ctx.Err())
+	}
+}
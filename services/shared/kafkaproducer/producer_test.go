@@ -0,0 +1,51 @@
+package kafkaproducer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// stalledProducer sends once release is closed
+type stalledProducer struct {
+	sarama.SyncProducer
+	release chan struct{}
+}
+
+func (p *stalledProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	<-p.release
+	return 3, 42, nil
+}
+
+func TestSendReturnsTheOffset(t *testing.T) {
+	producer := &stalledProducer{release: make(chan struct{})}
+	close(producer.release)
+
+	partition, offset, err := Send(context.Background(), producer, &sarama.ProducerMessage{Topic: "t"}, time.Second)
+	if partition != 3 || offset != 42 || err != nil {
+		t.Errorf("Send = %d, %d, %v", partition, offset, err)
+	}
+}
+
+func TestSendGivesUpAfterTheTimeout(t *testing.T) {
+	producer := &stalledProducer{release: make(chan struct{})}
+	defer close(producer.release)
+
+	if _, _, err := Send(context.Background(), producer, &sarama.ProducerMessage{Topic: "t"}, time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send = %v, want the deadline exceeded", err)
+	}
+}
+
+func TestSendGivesUpWhenTheContextEnds(t *testing.T) {
+	producer := &stalledProducer{release: make(chan struct{})}
+	defer close(producer.release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := Send(ctx, producer, &sarama.ProducerMessage{Topic: "t"}, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Send = %v, want canceled", err)
+	}
+}
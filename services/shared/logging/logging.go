@@ -0,0 +1,17 @@
+// Package logging tags log lines with the request ID a notification was
+// accepted under, so its lines can be followed across services
+package logging
+
+import "log"
+
+// Header carrying the request ID, on HTTP requests and on Kafka messages
+const RequestIDHeader = "X-Request-ID"
+
+// ForRequest returns a logger whose lines carry the request ID, so a
+// notification's log lines can be correlated across services
+func ForRequest(requestID string) *log.Logger {
+	if requestID == "" {
+		return log.Default()
+	}
+	return log.New(log.Writer(), "request_id="+requestID+" ", log.Flags()|log.Lmsgprefix)
+}
@@ -0,0 +1,17 @@
+package messages
+
+// Action types
+const (
+	ActionOpenURL  = "open_url"  // Opens an https URL in the browser
+	ActionDeepLink = "deep_link" // Opens a screen in the app
+	ActionDismiss  = "dismiss"   // Dismisses the notification
+)
+
+// Action is a button attached to a notification, rendered as a push action
+// button, an email call to action or an in-app action
+type Action struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Type  string `json:"type"`          // ActionOpenURL, ActionDeepLink or ActionDismiss
+	URL   string `json:"url,omitempty"` // Target of open_url and deep_link actions
+}
@@ -0,0 +1,19 @@
+package messages
+
+import "encoding/json"
+
+// AdminAction records an operation performed through a service's admin API.
+// Services without an audit database of their own send it to the audit topic,
+// keyed by service, for the rate limiter to store.
+type AdminAction struct {
+	ID          string          `json:"id"` // Unique, so a redelivered record is stored once
+	Service     string          `json:"service"`
+	InstanceID  string          `json:"instance_id"`
+	Actor       string          `json:"actor"`                // Operator who sent it, the remote address without one
+	Action      string          `json:"action"`               // Route of the operation, e.g. "POST /degraded/{stage}/skip"
+	Path        string          `json:"path"`                 // Path it was performed on, e.g. "/degraded/preferences/skip"
+	Query       string          `json:"query,omitempty"`      // Raw query string
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON request body, a JSON string for other bodies
+	Status      int             `json:"status"`               // HTTP status the operation was answered with
+	PerformedAt int64           `json:"performed_at"`
+}
@@ -0,0 +1,36 @@
+package messages
+
+// ChannelContent overrides the generic Content per channel
+type ChannelContent struct {
+	Email *EmailContent `json:"email,omitempty"`
+	Push  *PushContent  `json:"push,omitempty"`
+	SMS   *SMSContent   `json:"sms,omitempty"`
+}
+
+// EmailContent is email specific content
+type EmailContent struct {
+	Subject     string       `json:"subject"`
+	HTML        string       `json:"html,omitempty"`
+	Text        string       `json:"text,omitempty"` // Plain text alternative
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is a file attached to an email, referenced by URL or by object storage key
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	URL         string `json:"url,omitempty"`
+	ObjectKey   string `json:"object_key,omitempty"`
+}
+
+// PushContent is push specific content
+type PushContent struct {
+	Title    string `json:"title,omitempty"`
+	Body     string `json:"body,omitempty"`
+	DeepLink string `json:"deep_link,omitempty"`
+}
+
+// SMSContent is SMS specific content
+type SMSContent struct {
+	Text string `json:"text"`
+}
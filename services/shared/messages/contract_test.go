@@ -0,0 +1,210 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Fixtures of the messages exchanged between the services, read by delivery
+// consumers outside the repository too
+const contractsDir = "../../../contracts"
+
+// TestContracts checks the message fixtures against the models the services
+// produce and consume them with. Consumers must decode every field of a
+// fixture, so a field a producer adds fails until the model knows it. The
+// producer must also find every field of the model in the fixture, so a
+// field added to the model fails until the fixture, and with it the
+// consumers outside the repository, have it.
+func TestContracts(t *testing.T) {
+	contracts := []struct {
+		fixture   string
+		producer  string
+		consumers []string
+		model     any      // Pointer to the model the message is encoded and decoded with
+		omit      []string // Fields the producer never sets on this message
+	}{
+		{
+			fixture:   "notification_event.json",
+			producer:  "enqueue",
+			consumers: []string{"prioritizer"},
+			model:     &NotificationEvent{},
+			// Only set by the prioritizer, on the canary copies it mirrors and
+			// the recipients' copies it expands
+			omit: []string{"canary_baseline", "expanded_from"},
+		},
+		{
+			fixture:   "prioritized_notification.json",
+			producer:  "prioritizer",
+			consumers: []string{"rate limiter"},
+			model:     &PrioritizedNotification{},
+			// Canary copies of the raw topic are consumed by the prioritizer
+			// itself, and delayed retries are counted and coalesced by the
+			// rate limiter
+			omit: []string{"canary_baseline", "delayed_retries", "coalesced_count"},
+		},
+		{
+			fixture:   "processed_notification.json",
+			producer:  "rate limiter",
+			consumers: []string{"delivery"},
+			model:     &ProcessedNotification{},
+			// Only set on canary copies of the priority topics, which the rate limiter consumes itself
+			omit: []string{"canary_baseline"},
+		},
+		{
+			fixture:   "status_event.json",
+			producer:  "enqueue",
+			consumers: []string{"rate limiter"},
+			model:     &StatusEvent{},
+			// Only set by the webhook service on the delivery statuses providers report
+			omit: []string{"provider", "provider_message_id", "reason"},
+		},
+		{
+			fixture:   "delivery_status_event.json",
+			producer:  "webhook",
+			consumers: []string{"rate limiter"},
+			model:     &StatusEvent{},
+			// Callbacks aren't requests of the API and never report action clicks
+			omit: []string{"request_id", "action_id", "token"},
+		},
+		{
+			fixture:   "invalid_contact_status_event.json",
+			producer:  "rate limiter",
+			consumers: []string{"status consumers"},
+			model:     &StatusEvent{},
+			// Set by enqueue and the webhook service on the other status events
+			omit: []string{"action_id", "provider", "provider_message_id", "token"},
+		},
+		{
+			fixture:   "admin_action.json",
+			producer:  "prioritizer",
+			consumers: []string{"rate limiter"},
+			model:     &AdminAction{},
+		},
+	}
+
+	for _, contract := range contracts {
+		t.Run(contract.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(contractsDir, contract.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(contract.model); err != nil {
+				t.Fatalf("%s can't decode the fixture: %v", strings.Join(contract.consumers, ", "), err)
+			}
+
+			var fixture any
+			if err := json.Unmarshal(data, &fixture); err != nil {
+				t.Fatal(err)
+			}
+			present := make(map[string]bool)
+			fixturePaths(fixture, reflect.TypeOf(contract.model), "", present)
+
+			fields := make(map[string]bool)
+			modelPaths(reflect.TypeOf(contract.model), "", fields)
+
+			var missing []string
+			for path := range fields {
+				if !present[path] && !omitted(path, contract.omit) {
+					missing = append(missing, path)
+				}
+			}
+			if len(missing) > 0 {
+				slices.Sort(missing)
+				t.Errorf("fields the %s produces are missing from the fixture: %s", contract.producer, strings.Join(missing, ", "))
+			}
+		})
+	}
+}
+
+// omitted reports whether a field or one of its parents is omitted
+func omitted(path string, omit []string) bool {
+	for _, field := range omit {
+		if path == field || strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// modelPaths collects the JSON paths of a model's fields, descending into
+// nested structs and the elements of slices but not into maps
+func modelPaths(t reflect.Type, prefix string, paths map[string]bool) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			modelPaths(field.Type, prefix, paths)
+			continue
+		}
+		paths[prefix+name] = true
+		modelPaths(field.Type, prefix+name+".", paths)
+	}
+}
+
+// fixturePaths collects the JSON paths set in a fixture, guided by the model
+func fixturePaths(value any, t reflect.Type, prefix string, paths map[string]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		elements, _ := value.([]any)
+		for _, element := range elements {
+			fixturePaths(element, t.Elem(), prefix, paths)
+		}
+	case reflect.Struct:
+		object, _ := value.(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := jsonName(field)
+			if !ok {
+				continue
+			}
+			if name == "" {
+				fixturePaths(value, field.Type, prefix, paths)
+				continue
+			}
+			if nested, exists := object[name]; exists {
+				paths[prefix+name] = true
+				fixturePaths(nested, field.Type, prefix+name+".", paths)
+			}
+		}
+	}
+}
+
+// jsonName returns the JSON name of a field, empty for embedded structs
+// whose fields are inlined, and false for fields that aren't encoded
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" || !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		if field.Anonymous {
+			return "", true
+		}
+		return field.Name, true
+	}
+	return name, true
+}
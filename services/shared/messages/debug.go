@@ -0,0 +1,14 @@
+package messages
+
+// DebugTrace is published to the debug topic by every stage a notification
+// sampled for debugging passes, keyed by notification ID
+type DebugTrace struct {
+	NotificationID string         `json:"notification_id"`
+	RequestID      string         `json:"request_id,omitempty"`
+	UserID         string         `json:"user_id"`
+	Service        string         `json:"service"`
+	Stage          string         `json:"stage"`
+	Details        map[string]any `json:"details,omitempty"` // Decision specific information
+	Payload        any            `json:"payload,omitempty"` // The notification as seen at this stage
+	Timestamp      int64          `json:"timestamp"`
+}
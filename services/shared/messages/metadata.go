@@ -0,0 +1,74 @@
+package messages
+
+import "encoding/json"
+
+// UnmarshalEvent decodes a notification event. Metadata values encoding to
+// more than passthroughBytes are kept undecoded as json.RawMessage, which
+// marshals back verbatim, so an oversized blob costs one copy rather than a
+// tree of maps; zero decodes every value.
+func UnmarshalEvent(data []byte, event *NotificationEvent, passthroughBytes int) error {
+	if passthroughBytes <= 0 {
+		return json.Unmarshal(data, event)
+	}
+
+	// The outer Metadata shadows the embedded one
+	payload := struct {
+		*NotificationEvent
+		Metadata map[string]json.RawMessage `json:"metadata,omitempty"`
+	}{NotificationEvent: event}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+
+	metadata, err := DecodeMetadata(payload.Metadata, passthroughBytes)
+	if err != nil {
+		return err
+	}
+	event.Metadata = metadata
+	return nil
+}
+
+// UnmarshalPrioritized decodes a prioritized notification, passing oversized
+// metadata values through as UnmarshalEvent does
+func UnmarshalPrioritized(data []byte, notification *PrioritizedNotification, passthroughBytes int) error {
+	if passthroughBytes <= 0 {
+		return json.Unmarshal(data, notification)
+	}
+
+	payload := struct {
+		*PrioritizedNotification
+		Metadata map[string]json.RawMessage `json:"metadata,omitempty"`
+	}{PrioritizedNotification: notification}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+
+	metadata, err := DecodeMetadata(payload.Metadata, passthroughBytes)
+	if err != nil {
+		return err
+	}
+	notification.Metadata = metadata
+	return nil
+}
+
+// DecodeMetadata decodes the metadata values up to passthroughBytes long,
+// keeping the longer ones as json.RawMessage; zero decodes them all
+func DecodeMetadata(raw map[string]json.RawMessage, passthroughBytes int) (map[string]any, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	metadata := make(map[string]any, len(raw))
+	for key, value := range raw {
+		if passthroughBytes > 0 && len(value) > passthroughBytes {
+			metadata[key] = value
+			continue
+		}
+		var decoded any
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return nil, err
+		}
+		metadata[key] = decoded
+	}
+	return metadata, nil
+}
@@ -0,0 +1,113 @@
+package messages
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Passthrough threshold the fuzz targets decode with, small enough for
+// short inputs to reach the undecoded path
+const fuzzPassthroughBytes = 8
+
+var notificationSeeds = []string{
+	`{"schema_version":1,"id":"n-1","user_id":"u-1","event_type":"comment","content":"hi","created_at":1700000000}`,
+	`{"id":"n-1","user_id":"u-1","event_type":"like","metadata":{"tenant":"acme","post":{"id":42,"tags":["a","b"]},"n":1.5,"ok":true,"none":null}}`,
+	`{"id":"n-1","event_type":"reply","entity":{"type":"thread","id":"t-1"},"recipients":[{"user_id":"u-2","priority":"high"}]}`,
+	`{"id":"n-1","user_id":"u-1","event_type":"x","priority":"high","delayed_retries":2,"deadline":1700000000000,"metadata":{"blob":"` + "0123456789abcdef" + `"}}`,
+	`{"id":"n-1","metadata":null}`,
+	`{"id":"n-1","metadata":"not an object"}`,
+	`{"id":"n-1","metadata":{"big":1e400}}`,
+	`{"id":"n-1","schema_version":"1"}`,
+	`{"id":"n-1"} trailing`,
+	`[]`,
+	``,
+}
+
+// sameJSON reports whether two values marshal to the same JSON document
+func sameJSON(t *testing.T, a, b any) bool {
+	t.Helper()
+
+	var decoded [2]any
+	for i, value := range []any{a, b} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatalf("marshaling %#v: %v", value, err)
+		}
+		if err := json.Unmarshal(data, &decoded[i]); err != nil {
+			return false
+		}
+	}
+	return reflect.DeepEqual(decoded[0], decoded[1])
+}
+
+func FuzzUnmarshalEvent(f *testing.F) {
+	for _, seed := range notificationSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var decoded, passedThrough NotificationEvent
+		decodeErr := UnmarshalEvent(data, &decoded, 0)
+		passthroughErr := UnmarshalEvent(data, &passedThrough, fuzzPassthroughBytes)
+
+		// Passing values through only skips decoding them, it never rejects
+		// an event that decodes in full
+		if decodeErr == nil && passthroughErr != nil {
+			t.Fatalf("decodes in full but not with passthrough: %v", passthroughErr)
+		}
+		if decodeErr != nil || passthroughErr != nil {
+			return
+		}
+
+		if !sameJSON(t, decoded, passedThrough) {
+			t.Fatalf("passthrough changed the event:\n%+v\n%+v", decoded, passedThrough)
+		}
+
+		// A decoded event survives being produced again
+		data, err := json.Marshal(passedThrough)
+		if err != nil {
+			t.Fatalf("marshaling: %v", err)
+		}
+		var again NotificationEvent
+		if err := UnmarshalEvent(data, &again, fuzzPassthroughBytes); err != nil {
+			t.Fatalf("decoding %s again: %v", data, err)
+		}
+		if again.SchemaVersion != decoded.SchemaVersion || again.ID != decoded.ID || !sameJSON(t, again, decoded) {
+			t.Fatalf("event changed producing it again:\n%+v\n%+v", decoded, again)
+		}
+	})
+}
+
+func FuzzUnmarshalPrioritized(f *testing.F) {
+	for _, seed := range notificationSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var decoded, passedThrough PrioritizedNotification
+		decodeErr := UnmarshalPrioritized(data, &decoded, 0)
+		passthroughErr := UnmarshalPrioritized(data, &passedThrough, fuzzPassthroughBytes)
+
+		if decodeErr == nil && passthroughErr != nil {
+			t.Fatalf("decodes in full but not with passthrough: %v", passthroughErr)
+		}
+		if decodeErr != nil || passthroughErr != nil {
+			return
+		}
+
+		if !sameJSON(t, decoded, passedThrough) {
+			t.Fatalf("passthrough changed the notification:\n%+v\n%+v", decoded, passedThrough)
+		}
+
+		// The embedded event decodes the same as on its own, so the metadata
+		// shadowing the embedded field doesn't lose anything
+		var event NotificationEvent
+		if err := UnmarshalEvent(data, &event, fuzzPassthroughBytes); err != nil {
+			t.Fatalf("decodes as a prioritized notification but not as an event: %v", err)
+		}
+		if !sameJSON(t, event, passedThrough.NotificationEvent) {
+			t.Fatalf("embedded event differs:\n%+v\n%+v", event, passedThrough.NotificationEvent)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+// Package messages holds the notification messages the services exchange
+// over Kafka. Each service aliases these types in its own models package.
+package messages
+
+// SchemaVersion of the messages, set on every notification event. Adding an
+// optional field keeps the version; renaming, removing or changing the type
+// of a field bumps it, and consumers must handle both versions until the
+// older messages are drained.
+const SchemaVersion = 1
+
+// NotificationEvent is a notification as enqueued, produced to the raw topic
+type NotificationEvent struct {
+	SchemaVersion  int             `json:"schema_version,omitempty"` // Zero for events enqueued before versioning
+	ID             string          `json:"id"`
+	RequestID      string          `json:"request_id,omitempty"` // Correlates log lines across services
+	UserID         string          `json:"user_id"`
+	EventType      string          `json:"event_type"`
+	Content        string          `json:"content,omitempty"`
+	ChannelContent *ChannelContent `json:"channel_content,omitempty"` // Per-channel overrides of Content
+	ContentRef     string          `json:"content_ref,omitempty"`     // Object storage reference when the content was offloaded
+	Metadata       map[string]any  `json:"metadata,omitempty"`
+	GroupKey       string          `json:"group_key,omitempty"`       // Related notifications clients may collapse together
+	ThreadID       string          `json:"thread_id,omitempty"`       // Conversation or object the notification belongs to
+	Entity         *EntityRef      `json:"entity,omitempty"`          // Entity the event is about; without a user, it goes to the entity's subscribers
+	Recipients     []Recipient     `json:"recipients,omitempty"`      // Users an event without a user goes to, ahead of the entity's subscribers
+	ExpandedFrom   string          `json:"expanded_from,omitempty"`   // ID of the event a recipient's copy was expanded from
+	PriorityHint   string          `json:"priority_hint,omitempty"`   // Priority the producer asks for, honoured up to the prioritizer's limit
+	Actions        []Action        `json:"actions,omitempty"`         // Buttons rendered on every channel that supports them
+	Test           bool            `json:"test,omitempty"`            // Sandbox mode
+	Debug          bool            `json:"debug,omitempty"`           // Sampled for debugging, every stage publishes a trace
+	CanaryBaseline *CanaryBaseline `json:"canary_baseline,omitempty"` // Primary's decision, only set on copies mirrored to a canary
+	APIKeyID       string          `json:"api_key_id,omitempty"`      // Fingerprint of the API key the notification was enqueued with
+	Bypass         *Bypass         `json:"bypass,omitempty"`          // Signed exemption from rate limits and preference suppression
+	CreatedAt      int64           `json:"created_at"`
+}
+
+// EntityRef names an entity users can subscribe to, such as a thread, a
+// project or an author
+type EntityRef struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Recipient is a user an event goes to, with the priority asked for them,
+// e.g. high for the users mentioned in a thread reply
+type Recipient struct {
+	UserID   string `json:"user_id"`
+	Priority string `json:"priority,omitempty"` // Becomes the priority hint of the user's copy
+}
+
+// Bypass exempts an emergency or legally required notification from rate
+// limiting and preference suppression. Enqueue signs it for the API keys
+// allowed to request one, the rate limiter verifies and audits it.
+type Bypass struct {
+	Reason    string `json:"reason"`
+	IssuedAt  int64  `json:"issued_at"`
+	Signature string `json:"signature"`
+}
+
+// PrioritizedNotification is a notification the prioritizer assigned a
+// priority, produced to the priority topics
+type PrioritizedNotification struct {
+	NotificationEvent
+	Priority       string `json:"priority"`
+	DelayedRetries int    `json:"delayed_retries,omitempty"` // Times the rate limiter put the notification back on the delay topics after failing
+	CoalescedCount int    `json:"coalesced_count,omitempty"` // Identical notifications this one stands for after they were coalesced while delayed, unset for one
+	Deadline       int64  `json:"deadline,omitempty"`        // Unix milliseconds the notification should reach the delivery topic by, unset without a processing budget
+}
+
+// ProcessedNotification is a notification after rate limiting and preference
+// checks, produced to the delivery topic
+type ProcessedNotification struct {
+	PrioritizedNotification
+	Channels         []string          `json:"channels"`                    // Delivery channels (email, in-app, whatsapp, etc.)
+	FallbackChannels []string          `json:"fallback_channels,omitempty"` // Tried one after another while delivery on the previous channel fails
+	CollapseKey      string            `json:"collapse_key,omitempty"`      // Push collapse key (FCM collapse_key, APNs apns-collapse-id)
+	RateLimit        *RateLimitResult  `json:"rate_limit,omitempty"`        // User's quota left after this notification, unset when exempt
+	Region           string            `json:"region,omitempty"`            // Region the user's data lives in, empty for the default region
+	Providers        map[string]string `json:"providers,omitempty"`         // Provider to deliver with per channel, chosen for the user's region
+	EngagementToken  string            `json:"engagement_token,omitempty"`  // Reported back with opens and action clicks, proving they come from the recipient
+}
+
+// RateLimitResult describes a user's quota as seen by a rate-limit check
+type RateLimitResult struct {
+	Limited   bool  `json:"limited"`
+	Exempt    bool  `json:"exempt,omitempty"` // Observed notifications are neither limited nor counted
+	Remaining int   `json:"remaining"`        // Notifications the user may still receive within the window
+	ResetAt   int64 `json:"reset_at"`         // Unix time at which the oldest counted notification leaves the window
+}
+
+// CanaryBaseline is the decision a primary made for a notification it
+// mirrored to a canary: the prioritizer sets Priority, the rate limiter Channels
+type CanaryBaseline struct {
+	Priority string   `json:"priority,omitempty"`
+	Channels []string `json:"channels,omitempty"` // Channels delivered to, empty when it was not delivered
+}
+
+// Built-in priority levels
+const (
+	PriorityCritical = "critical"
+	PriorityHigh     = "high"
+	PriorityMedium   = "medium"
+	PriorityLow      = "low"
+)
+
+// Delivery channels
+const (
+	ChannelEmail    = "email"
+	ChannelInApp    = "in-app"
+	ChannelPush     = "push"
+	ChannelWhatsApp = "whatsapp"
+	ChannelSMS      = "sms"
+)
+
+// Metadata entry naming the tenant a notification belongs to
+const MetadataTenant = "tenant"
@@ -0,0 +1,28 @@
+package messages
+
+// Status event types
+const (
+	StatusActionClicked  = "action_clicked"  // The user clicked one of the notification's actions
+	StatusOpened         = "opened"          // The user opened the notification
+	StatusDelivered      = "delivered"       // The provider delivered the notification
+	StatusFailed         = "failed"          // The provider gave up delivering the notification
+	StatusBounced        = "bounced"         // The recipient's mail server rejected the email
+	StatusComplained     = "complained"      // The recipient marked the email as spam
+	StatusInvalidContact = "invalid_contact" // The user's contact for the channel can't be delivered to, so it wasn't tried
+)
+
+// StatusEvent is sent to the status topic when something happens to a
+// delivered notification, keyed by notification ID
+type StatusEvent struct {
+	NotificationID    string `json:"notification_id"`
+	RequestID         string `json:"request_id,omitempty"` // Request that reported the status
+	UserID            string `json:"user_id"`
+	Status            string `json:"status"`
+	ActionID          string `json:"action_id,omitempty"` // Only set for StatusActionClicked
+	Channel           string `json:"channel,omitempty"`
+	Provider          string `json:"provider,omitempty"`            // Provider that reported a delivery status, e.g. ses
+	ProviderMessageID string `json:"provider_message_id,omitempty"` // ID the provider gave the message it sent
+	Reason            string `json:"reason,omitempty"`              // Why delivery failed, as the provider put it or the contact was refused
+	Token             string `json:"token,omitempty"`               // Engagement token of the notification, for opens and action clicks
+	OccurredAt        int64  `json:"occurred_at"`
+}
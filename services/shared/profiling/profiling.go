@@ -0,0 +1,45 @@
+// Package profiling serves net/http/pprof on the services' admin servers
+package profiling
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+)
+
+// Time a capture gets to be written after it was taken
+const writeSlack = 10 * time.Second
+
+// Register serves net/http/pprof under /debug/pprof/ on mux. CPU profiles
+// and traces run for as long as asked, past the server's write timeout.
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", capture(pprof.Profile, 30))
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", capture(pprof.Trace, 1))
+}
+
+// capture extends the write deadline of a request to the capture it asks
+// for, defaultSeconds long without ?seconds=
+func capture(handler http.HandlerFunc, defaultSeconds float64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		seconds, err := strconv.ParseFloat(r.FormValue("seconds"), 64)
+		if err != nil || seconds <= 0 {
+			seconds = defaultSeconds
+		}
+
+		deadline := time.Now().Add(time.Duration(seconds*float64(time.Second)) + writeSlack)
+		if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+			http.Error(w, "failed to extend the write deadline: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// pprof refuses captures longer than the server's write timeout,
+		// which no longer applies to this request
+		ctx := context.WithValue(r.Context(), http.ServerContextKey, nil)
+		handler(w, r.WithContext(ctx))
+	}
+}
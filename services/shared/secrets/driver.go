@@ -0,0 +1,60 @@
+package secrets
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+)
+
+// Drivers registered by Driver, by store and the name of the driver they wrap
+var (
+	driversMu sync.Mutex
+	drivers   = make(map[driverKey]string)
+)
+
+type driverKey struct {
+	store *Store
+	name  string
+}
+
+// resolvingDriver opens connections with the current value of the DSN
+// reference it is given, so new connections use a rotated DSN
+type resolvingDriver struct {
+	driver driver.Driver
+	store  *Store
+}
+
+// Open resolves the DSN and opens a connection with it
+func (d resolvingDriver) Open(dsn string) (driver.Conn, error) {
+	resolved, err := d.store.Resolve(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return d.driver.Open(resolved)
+}
+
+// Driver registers a database/sql driver wrapping the named one, which
+// takes references to DSNs in place of DSNs, and returns its name.
+// Connections already open keep the credentials they were opened with.
+func (s *Store) Driver(name string) (string, error) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	key := driverKey{store: s, name: name}
+	if wrapper, ok := drivers[key]; ok {
+		return wrapper, nil
+	}
+
+	// Opening a database doesn't connect, it only looks up the driver
+	db, err := sql.Open(name, "")
+	if err != nil {
+		return "", fmt.Errorf("unknown database driver %s: %w", name, err)
+	}
+	wrapped := db.Driver()
+	db.Close()
+
+	wrapper := fmt.Sprintf("%s+secrets-%d", name, len(drivers))
+	sql.Register(wrapper, resolvingDriver{driver: wrapped, store: s})
+	drivers[key] = wrapper
+	return wrapper, nil
+}
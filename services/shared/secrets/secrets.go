@@ -0,0 +1,237 @@
+// Package secrets resolves the secrets in the services' configuration from
+// where they are kept: the environment, files, HashiCorp Vault or AWS SSM
+// Parameter Store. A configuration value of the form "<provider>://<name>"
+// refers to a secret, any other value is taken literally:
+//
+//	env://MYSQL_DSN
+//	file:///run/secrets/mysql-dsn
+//	vault://secret/data/notifications/mysql#dsn
+//	ssm:///notifications/production/mysql-dsn
+//
+// Referenced secrets are fetched again on every refresh, so components that
+// read their current value pick up rotated secrets without a restart.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Separates the provider of a reference from the name of the secret
+const referenceSeparator = "://"
+
+// How long fetching a secret may take
+const fetchTimeout = 10 * time.Second
+
+// ErrNotFound is returned for secrets a provider doesn't have
+var ErrNotFound = errors.New("secret not found")
+
+// Provider fetches secrets by name
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// EnvProvider reads secrets from environment variables
+type EnvProvider struct{}
+
+// Get returns the value of the environment variable
+func (EnvProvider) Get(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%w: environment variable %s is not set", ErrNotFound, name)
+	}
+	return value, nil
+}
+
+// FileProvider reads secrets from files, such as mounted Kubernetes or
+// Docker secrets. A trailing newline is not part of the secret.
+type FileProvider struct{}
+
+// Get returns the contents of the file
+func (FileProvider) Get(ctx context.Context, name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+}
+
+// Config of a store: the providers other than the environment and files,
+// and how often referenced secrets are fetched again
+type Config struct {
+	Vault           VaultConfig
+	SSM             SSMConfig
+	RefreshInterval time.Duration // How often referenced secrets are fetched again, zero never
+}
+
+// New creates a store resolving "env", "file", "vault" and "ssm" references
+func New(config Config) *Store {
+	return NewStore(map[string]Provider{
+		"env":   EnvProvider{},
+		"file":  FileProvider{},
+		"vault": NewVaultProvider(config.Vault),
+		"ssm":   NewSSMProvider(config.SSM),
+	})
+}
+
+// Store resolves references through the providers and keeps the current
+// value of every reference it resolved
+type Store struct {
+	providers map[string]Provider
+
+	mu     sync.RWMutex
+	keys   map[string]string // Reference loaded from each environment variable
+	values map[string]string // Current value of each resolved reference
+}
+
+// NewStore creates a store resolving references through the providers, by
+// the name references use for them
+func NewStore(providers map[string]Provider) *Store {
+	return &Store{
+		providers: providers,
+		keys:      make(map[string]string),
+		values:    make(map[string]string),
+	}
+}
+
+// IsReference reports whether a value refers to a secret of a known provider
+func (s *Store) IsReference(value string) bool {
+	_, _, ok := s.parse(value)
+	return ok
+}
+
+// Resolve returns the value a configuration value stands for: the current
+// value of the secret it refers to, or the value itself
+func (s *Store) Resolve(value string) (string, error) {
+	provider, name, ok := s.parse(value)
+	if !ok {
+		return value, nil
+	}
+
+	s.mu.RLock()
+	current, resolved := s.values[value]
+	s.mu.RUnlock()
+	if resolved {
+		return current, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	current, err := s.providers[provider].Get(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch secret %s: %w", value, err)
+	}
+
+	s.mu.Lock()
+	s.values[value] = current
+	s.mu.Unlock()
+	return current, nil
+}
+
+// Load sets target to the secret an environment variable holds or refers
+// to, leaving it unchanged when the variable is unset
+func (s *Store) Load(key string, target *string) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	resolved, err := s.Resolve(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if s.IsReference(value) {
+		s.mu.Lock()
+		s.keys[key] = value
+		s.mu.Unlock()
+	}
+	*target = resolved
+	return nil
+}
+
+// Current returns a function reading the current value of the secret
+// loaded from an environment variable, nil when the variable held the
+// secret itself and it can't rotate, or there is no store
+func (s *Store) Current(key string) func() string {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	reference, ok := s.keys[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	return func() string {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.values[reference]
+	}
+}
+
+// Refresh fetches every resolved reference again. A secret that fails to
+// be fetched keeps its last value.
+func (s *Store) Refresh(ctx context.Context) {
+	s.mu.RLock()
+	references := make([]string, 0, len(s.values))
+	for reference := range s.values {
+		references = append(references, reference)
+	}
+	s.mu.RUnlock()
+
+	for _, reference := range references {
+		provider, name, _ := s.parse(reference)
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		value, err := s.providers[provider].Get(fetchCtx, name)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to refresh secret %s, keeping its last value: %v", reference, err)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.values[reference] != value {
+			log.Printf("Secret %s rotated", reference)
+			s.values[reference] = value
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Run refreshes the secrets every interval until the context is done
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Refresh(ctx)
+		}
+	}
+}
+
+// parse splits a reference into its provider and the secret's name
+func (s *Store) parse(value string) (provider, name string, ok bool) {
+	provider, name, found := strings.Cut(value, referenceSeparator)
+	if !found || name == "" {
+		return "", "", false
+	}
+	if _, known := s.providers[provider]; !known {
+		return "", "", false
+	}
+	return provider, name, true
+}
@@ -0,0 +1,196 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// SSMConfig for reading secrets from AWS SSM Parameter Store. Without
+// static keys, credentials come from the default AWS chain: the environment,
+// the shared credentials file, then web identity (IRSA), container or
+// instance roles.
+type SSMConfig struct {
+	Region          string
+	AccessKeyID     string // Optional, static credentials
+	SecretAccessKey string // Optional, static credentials
+	SessionToken    string // Optional, for temporary credentials
+	Endpoint        string // Optional, defaults to the region's endpoint
+}
+
+// SSMProvider reads parameters, decrypting SecureString ones. Names are
+// parameter names, e.g. "/notifications/production/mysql-dsn".
+type SSMProvider struct {
+	config      SSMConfig
+	client      *http.Client
+	credentials *credentials.Credentials
+}
+
+// NewSSMProvider creates a provider reading from the region's Parameter Store
+func NewSSMProvider(config SSMConfig) *SSMProvider {
+	if config.Endpoint == "" && config.Region != "" {
+		config.Endpoint = fmt.Sprintf("https://ssm.%s.amazonaws.com", config.Region)
+	}
+	client := &http.Client{Timeout: fetchTimeout}
+
+	var chain []credentials.Provider
+	if config.AccessKeyID != "" {
+		chain = append(chain, &credentials.Static{Value: credentials.Value{
+			AccessKeyID:     config.AccessKeyID,
+			SecretAccessKey: config.SecretAccessKey,
+			SessionToken:    config.SessionToken,
+			SignerType:      credentials.SignatureV4,
+		}})
+	}
+	chain = append(chain,
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: client, Region: config.Region},
+	)
+
+	return &SSMProvider{
+		config:      config,
+		client:      client,
+		credentials: credentials.NewChainCredentials(chain),
+	}
+}
+
+// Get returns the value of a parameter
+func (p *SSMProvider) Get(ctx context.Context, name string) (string, error) {
+	if p.config.Region == "" {
+		return "", fmt.Errorf("no AWS region configured")
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"Name":           name,
+		"WithDecryption": true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonSSM.GetParameter")
+
+	// Temporary credentials are fetched again shortly before they expire
+	creds, err := p.credentials.GetWithContext(&credentials.CredContext{Client: p.client})
+	if err != nil {
+		return "", fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	if creds.AccessKeyID == "" {
+		return "", fmt.Errorf("no AWS credentials found")
+	}
+	p.sign(req, body, creds, time.Now().UTC())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from SSM: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(data, &failure)
+		if strings.HasSuffix(failure.Type, "ParameterNotFound") {
+			return "", fmt.Errorf("%w: SSM has no parameter %s", ErrNotFound, name)
+		}
+		if strings.HasSuffix(failure.Type, "ExpiredTokenException") {
+			p.credentials.Expire()
+		}
+		return "", fmt.Errorf("SSM returned %s: %s %s", resp.Status, failure.Type, failure.Message)
+	}
+
+	var result struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode SSM parameter: %w", err)
+	}
+	return result.Parameter.Value, nil
+}
+
+// sign signs a request with AWS Signature Version 4
+func (p *SSMProvider) sign(req *http.Request, body []byte, creds credentials.Value, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// The signed headers, lowercased and sorted
+	headers := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if creds.SessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+		slices.Sort(headers)
+	}
+	var canonicalHeaders strings.Builder
+	for _, header := range headers {
+		value := req.Header.Get(header)
+		if header == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(header + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapedPath(req.URL),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + p.config.Region + "/ssm/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, p.config.Region)
+	key = hmacSHA256(key, "ssm")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// escapedPath returns the canonical path of a request, "/" when it has none
+func escapedPath(u *url.URL) string {
+	if path := u.EscapedPath(); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
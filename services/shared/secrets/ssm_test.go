@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withoutAWSCredentials clears the credentials the default chain would find
+func withoutAWSCredentials(t *testing.T) {
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY", "AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "AWS_REGION",
+	} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+}
+
+// ssmServer answers GetParameter with the access key that signed the request,
+// after rejecting the first expired token it sees
+func ssmServer(t *testing.T, expired string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=") || r.Header.Get("X-Amz-Target") != "AmazonSSM.GetParameter" {
+			t.Errorf("unsigned request: %v", r.Header)
+		}
+		key, _, _ := strings.Cut(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 Credential="), "/")
+
+		var input struct{ Name string }
+		json.NewDecoder(r.Body).Decode(&input)
+		switch {
+		case expired != "" && r.Header.Get("X-Amz-Security-Token") == expired:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ExpiredTokenException","message":"The security token included in the request is expired"}`)
+		case input.Name == "/missing":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ParameterNotFound"}`)
+		default:
+			fmt.Fprintf(w, `{"Parameter":{"Value":%q}}`, key)
+		}
+	}))
+}
+
+func TestSSMStaticCredentials(t *testing.T) {
+	withoutAWSCredentials(t)
+	server := ssmServer(t, "")
+	defer server.Close()
+
+	provider := NewSSMProvider(SSMConfig{Region: "eu-west-1", AccessKeyID: "static-key", SecretAccessKey: "secret", Endpoint: server.URL})
+	if value, err := provider.Get(context.Background(), "/notifications/mysql-dsn"); err != nil || value != "static-key" {
+		t.Errorf("Get = %q, %v", value, err)
+	}
+	if _, err := provider.Get(context.Background(), "/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing parameter = %v", err)
+	}
+}
+
+func TestSSMRefreshesRoleCredentials(t *testing.T) {
+	withoutAWSCredentials(t)
+
+	// A container credentials endpoint handing out a new session each time
+	var issued atomic.Int32
+	roles := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := issued.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"AccessKeyId":     fmt.Sprintf("role-key-%d", n),
+			"SecretAccessKey": "secret",
+			"Token":           fmt.Sprintf("token-%d", n),
+			"Expiration":      time.Now().Add(time.Hour),
+		})
+	}))
+	defer roles.Close()
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", roles.URL)
+
+	server := ssmServer(t, "token-2")
+	defer server.Close()
+	provider := NewSSMProvider(SSMConfig{Region: "eu-west-1", Endpoint: server.URL})
+
+	// Unexpired credentials are reused
+	for i := 0; i < 2; i++ {
+		if value, err := provider.Get(context.Background(), "/notifications/mysql-dsn"); err != nil || value != "role-key-1" {
+			t.Fatalf("Get = %q, %v", value, err)
+		}
+	}
+
+	// Revoked ones are fetched again on the next read
+	provider.credentials.Expire()
+	if _, err := provider.Get(context.Background(), "/notifications/mysql-dsn"); err == nil {
+		t.Fatal("Get with an expired token succeeded")
+	}
+	if value, err := provider.Get(context.Background(), "/notifications/mysql-dsn"); err != nil || value != "role-key-3" {
+		t.Errorf("Get after the token expired = %q, %v", value, err)
+	}
+}
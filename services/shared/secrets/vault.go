@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// VaultConfig for reading secrets from HashiCorp Vault
+type VaultConfig struct {
+	Addr      string // e.g. "https://vault.internal:8200"
+	Token     string
+	Namespace string // Optional, Vault Enterprise namespace
+}
+
+// VaultProvider reads fields of Vault secrets. Names are the secret's path
+// and the field, e.g. "secret/data/notifications/mysql#dsn"; both KV v1 and
+// v2 engines are read.
+type VaultProvider struct {
+	config VaultConfig
+	client *http.Client
+}
+
+// NewVaultProvider creates a provider reading from the Vault server
+func NewVaultProvider(config VaultConfig) *VaultProvider {
+	config.Addr = strings.TrimSuffix(config.Addr, "/")
+	return &VaultProvider{
+		config: config,
+		client: &http.Client{Timeout: fetchTimeout},
+	}
+}
+
+// Get returns a field of a Vault secret
+func (p *VaultProvider) Get(ctx context.Context, name string) (string, error) {
+	if p.config.Addr == "" {
+		return "", fmt.Errorf("no vault address configured")
+	}
+
+	path, field, ok := strings.Cut(name, "#")
+	if !ok || field == "" {
+		return "", fmt.Errorf("vault secret %s names no field, e.g. %s#password", name, name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.Addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.config.Token)
+	if p.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.config.Namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: vault has no secret at %s", ErrNotFound, path)
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("vault returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to decode vault secret: %w", err)
+	}
+
+	// KV v2 nests the fields under data.data, along with data.metadata
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, direct := data[field]; !direct {
+			data = nested
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("%w: vault secret %s has no string field %s", ErrNotFound, path, field)
+	}
+	return value, nil
+}
@@ -0,0 +1,60 @@
+package shutdown
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Sequencer runs the stages of a graceful shutdown in order, each within its
+// own timeout, so later stages never pull clients from under earlier ones
+type Sequencer struct {
+	stages []stage
+}
+
+type stage struct {
+	name    string
+	timeout time.Duration
+	steps   []func(ctx context.Context) error
+}
+
+// Stage appends a stage whose steps run one after another within timeout
+func (s *Sequencer) Stage(name string, timeout time.Duration, steps ...func(ctx context.Context) error) {
+	s.stages = append(s.stages, stage{name: name, timeout: timeout, steps: steps})
+}
+
+// Run runs the stages. A failing or overrunning stage is logged and the
+// following stages still run, so clients get closed either way. Steps see
+// their context done once the stage times out; a stage whose steps don't
+// return by then is abandoned, and its steps finish in the background.
+func (s *Sequencer) Run() {
+	for _, stage := range s.stages {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), stage.timeout)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for _, step := range stage.steps {
+				if err := step(ctx); err != nil {
+					log.Printf("Shutdown stage %s: %v", stage.name, err)
+				}
+			}
+		}()
+
+		select {
+		case <-done:
+			log.Printf("Shutdown stage %s finished in %v", stage.name, time.Since(start))
+		case <-ctx.Done():
+			log.Printf("Shutdown stage %s timed out after %v, moving on without it", stage.name, stage.timeout)
+		}
+		cancel()
+	}
+}
+
+// Close adapts a Close method to a stage step
+func Close(close func() error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return close()
+	}
+}
@@ -0,0 +1,46 @@
+package shutdown
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSequencerAbandonsStagesPastTheirTimeout(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	ran := make(chan string, 3)
+	var s Sequencer
+	s.Stage("drain", time.Hour, func(ctx context.Context) error {
+		ran <- "drain"
+		return nil
+	})
+	// Ignores its context, like a Close that hangs on a broken connection
+	s.Stage("flush", 10*time.Millisecond, func(ctx context.Context) error {
+		ran <- "flush"
+		<-stuck
+		return nil
+	})
+	s.Stage("close", time.Hour, func(ctx context.Context) error {
+		ran <- "close"
+		return nil
+	})
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		s.Run()
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run waited for a stage past its timeout")
+	}
+
+	for _, want := range []string{"drain", "flush", "close"} {
+		if got := <-ran; got != want {
+			t.Errorf("stage %s ran where %s was expected", got, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package signing
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Config holds the keys the messages exchanged between the services are
+// signed with, signing is disabled without keys
+type Config struct {
+	Keys        map[string]string // Base64 encoded keys of at least 32 bytes by ID, every key verifies
+	ActiveKeyID string            // Key produced messages are signed with
+	Required    bool              // Reject consumed messages that aren't signed, not only those signed wrongly
+	MaxAge      time.Duration     // Reject consumed messages signed longer ago, zero accepts any age
+}
+
+// CreateKeyring creates the keyring messages are signed and verified with,
+// nil when signing is disabled
+func (c Config) CreateKeyring() (*Keyring, error) {
+	if len(c.Keys) == 0 {
+		return nil, nil
+	}
+	return NewKeyring(c.ActiveKeyID, c.Keys)
+}
+
+// signer signs every message a producer sends. Sarama runs interceptors again
+// when it retries a message, and republished messages arrive with the
+// signature they were consumed with, so the signature headers are replaced
+// rather than added to.
+type signer struct {
+	keyring *Keyring
+}
+
+// SignMessages makes a producer sign its messages, unless signing is disabled
+func SignMessages(saramaCfg *sarama.Config, cfg Config) error {
+	keyring, err := cfg.CreateKeyring()
+	if err != nil {
+		return fmt.Errorf("invalid signing keys: %w", err)
+	}
+	if keyring != nil {
+		saramaCfg.Producer.Interceptors = append(saramaCfg.Producer.Interceptors, signer{keyring: keyring})
+	}
+	return nil
+}
+
+// OnSend signs a message before it is sent
+func (s signer) OnSend(msg *sarama.ProducerMessage) {
+	message := Message{
+		Topic:    msg.Topic,
+		Key:      encoded(msg.Key),
+		Value:    encoded(msg.Value),
+		SignedAt: time.Now(),
+	}
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+3)
+	for _, header := range msg.Headers {
+		if !isSignatureHeader(string(header.Key)) {
+			headers = append(headers, header)
+			message.Headers = append(message.Headers, Header{Name: string(header.Key), Value: string(header.Value)})
+		}
+	}
+
+	keyID, signature := s.keyring.Sign(message)
+	msg.Headers = append(headers,
+		sarama.RecordHeader{Key: []byte(KeyIDHeader), Value: []byte(keyID)},
+		sarama.RecordHeader{Key: []byte(SignatureHeader), Value: []byte(signature)},
+		sarama.RecordHeader{Key: []byte(SignedAtHeader), Value: []byte(strconv.FormatInt(message.SignedAt.UnixMilli(), 10))},
+	)
+}
+
+// Verifier checks the signatures of consumed messages. A nil verifier, used
+// while signing is disabled, accepts every message.
+type Verifier struct {
+	keyring  *Keyring
+	required bool
+	maxAge   time.Duration
+}
+
+// NewVerifier creates the verifier of a consumer, nil when signing is disabled
+func NewVerifier(cfg Config) (*Verifier, error) {
+	keyring, err := cfg.CreateKeyring()
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing keys: %w", err)
+	}
+	if keyring == nil {
+		return nil, nil
+	}
+	return &Verifier{keyring: keyring, required: cfg.Required, maxAge: cfg.MaxAge}, nil
+}
+
+// Verify returns why a message must be dropped for its signature, nil when it
+// is accepted. unsigned reports an unsigned message accepted because
+// signatures aren't required.
+func (v *Verifier) Verify(message *sarama.ConsumerMessage) (unsigned bool, err error) {
+	return v.VerifyDue(message, time.Time{})
+}
+
+// VerifyDue verifies a message held back until it is due, such as a delayed
+// one. Its age counts from when it was due if that is after it was signed.
+func (v *Verifier) VerifyDue(message *sarama.ConsumerMessage, due time.Time) (unsigned bool, err error) {
+	if v == nil {
+		return false, nil
+	}
+
+	var keyID, signature, signedAt string
+	msg := Message{Topic: message.Topic, Key: message.Key, Value: message.Value}
+	for _, header := range message.Headers {
+		if header == nil {
+			continue
+		}
+		switch name := string(header.Key); name {
+		case KeyIDHeader:
+			keyID = string(header.Value)
+		case SignatureHeader:
+			signature = string(header.Value)
+		case SignedAtHeader:
+			signedAt = string(header.Value)
+		default:
+			msg.Headers = append(msg.Headers, Header{Name: name, Value: string(header.Value)})
+		}
+	}
+
+	if keyID == "" {
+		if v.required {
+			return false, ErrUnsigned
+		}
+		return true, nil
+	}
+	millis, err := strconv.ParseInt(signedAt, 10, 64)
+	if err != nil {
+		return false, ErrInvalid
+	}
+	msg.SignedAt = time.UnixMilli(millis)
+	if err := v.keyring.Verify(keyID, signature, msg); err != nil {
+		return false, err
+	}
+
+	if v.maxAge > 0 {
+		since := msg.SignedAt
+		if due.After(since) {
+			since = due
+		}
+		if age := time.Since(since); age > v.maxAge {
+			return false, fmt.Errorf("%w: signed %v ago", ErrStale, age.Round(time.Second))
+		}
+	}
+	return false, nil
+}
+
+// isSignatureHeader reports whether a header carries the signature of a message
+func isSignatureHeader(name string) bool {
+	return name == KeyIDHeader || name == SignatureHeader || name == SignedAtHeader
+}
+
+// encoded returns the bytes of a message's key or value, nil when it has none.
+// A value failing to encode fails the send anyway.
+func encoded(encoder sarama.Encoder) []byte {
+	if encoder == nil {
+		return nil
+	}
+	data, err := encoder.Encode()
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,37 @@
+package startup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Check verifies a dependency before the service creates its clients
+type Check struct {
+	Name string                          // Dependency checked, e.g. "Kafka brokers"
+	Hint string                          // What to look at when the check fails
+	Run  func(ctx context.Context) error // Single attempt, bounded by the context
+}
+
+// Longest a single attempt of a check may take
+const checkTimeout = 5 * time.Second
+
+// Preflight runs the checks in order before the service starts, waiting for
+// each dependency as Retry does. The first check that keeps failing is
+// returned with its hint, so a wrong address or a missing topic stops the
+// service with one actionable error instead of a client error deep in startup.
+func Preflight(ctx context.Context, cfg Config, checks ...Check) error {
+	for _, check := range checks {
+		_, err := Retry(ctx, cfg, check.Name, func() (struct{}, error) {
+			attemptCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			return struct{}{}, check.Run(attemptCtx)
+		})
+		if err != nil {
+			return fmt.Errorf("preflight check failed: %w (%s)", err, check.Hint)
+		}
+		log.Printf("Preflight check passed: %s", check.Name)
+	}
+	return nil
+}
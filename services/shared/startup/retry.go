@@ -0,0 +1,45 @@
+package startup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Config bounds how long the service waits for a dependency at startup
+type Config struct {
+	Timeout        time.Duration // Give up after this long, zero tries once
+	InitialBackoff time.Duration // Wait after the first failed attempt
+	MaxBackoff     time.Duration // Upper bound of the wait, which doubles after every attempt
+}
+
+// Retry calls connect until it succeeds, waiting with exponential backoff
+// between attempts, so the service can start before its dependencies are
+// up. It returns the last error once the timeout or context runs out.
+func Retry[T any](ctx context.Context, cfg Config, name string, connect func() (T, error)) (T, error) {
+	deadline := time.Now().Add(cfg.Timeout)
+	backoff := cfg.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		client, err := connect()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Connected to %s after %d attempts", name, attempt)
+			}
+			return client, nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return client, fmt.Errorf("%s unavailable after %d attempts: %w", name, attempt, err)
+		}
+		log.Printf("%s unavailable, retrying in %v: %v", name, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return client, fmt.Errorf("%s unavailable: %w", name, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, cfg.MaxBackoff)
+	}
+}
@@ -0,0 +1,21 @@
+// Package tracking signs the tokens delivered notifications carry, so opens
+// and action clicks can only be reported by whoever received the notification
+package tracking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Token returns the HMAC-SHA256 binding a token to the notification and its recipient
+func Token(secret []byte, notificationID, userID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(notificationID + "\n" + userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Valid reports whether a token was issued for the notification and recipient
+func Valid(secret []byte, notificationID, userID, token string) bool {
+	return len(secret) > 0 && hmac.Equal([]byte(token), []byte(Token(secret, notificationID, userID)))
+}
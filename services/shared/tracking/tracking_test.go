@@ -0,0 +1,25 @@
+package tracking
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	secret := []byte("secret")
+	token := Token(secret, "n-1", "user-1")
+
+	if !Valid(secret, "n-1", "user-1", token) {
+		t.Error("token rejected")
+	}
+	for _, tt := range []struct{ notificationID, userID, token string }{
+		{"n-2", "user-1", token},
+		{"n-1", "user-2", token},
+		{"n-1", "user-1", ""},
+		{"n-1", "user-1", Token([]byte("other"), "n-1", "user-1")},
+	} {
+		if Valid(secret, tt.notificationID, tt.userID, tt.token) {
+			t.Errorf("accepted token %q for %s of %s", tt.token, tt.notificationID, tt.userID)
+		}
+	}
+	if Valid(nil, "n-1", "user-1", Token(nil, "n-1", "user-1")) {
+		t.Error("accepted a token without a secret")
+	}
+}
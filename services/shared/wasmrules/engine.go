@@ -0,0 +1,360 @@
+// Package wasmrules runs rules tenants upload as WebAssembly modules, for
+// teams that can't ship Go code with the services. A module gets a
+// notification as JSON and answers with a Decision as JSON, in a sandbox: it
+// can't import anything, so it has no I/O, its memory is capped, and every
+// evaluation runs in a fresh instance under a deadline.
+//
+// A module exports its memory and two functions:
+//
+//	alloc(size i32) i32             // Returns where to write an input of size bytes
+//	evaluate(ptr i32, len i32) i64  // Returns where its JSON answer is, ptr<<32 | len
+package wasmrules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Extension of module files, named after their tenant
+const moduleExtension = ".wasm"
+
+// Largest answer read from a module
+const maxOutput = 64 << 10
+
+// Size of a page of WebAssembly memory
+const pageSize = 64 << 10
+
+// ErrInvalid is returned for modules and tenants that can't be installed
+var ErrInvalid = errors.New("invalid rules")
+
+// Names tenants may have, so a tenant names a file in the directory and nothing else
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// Decision of a rule module for a notification. Every field is optional and
+// each service reads the ones it acts on.
+type Decision struct {
+	Priority string `json:"priority,omitempty"` // Priority the prioritizer gives the notification
+	Suppress bool   `json:"suppress,omitempty"` // The rate limiter suppresses the notification
+	Reason   string `json:"reason,omitempty"`   // Why, for logs and debug traces
+}
+
+// Config of the engine
+type Config struct {
+	Dir            string        // Holds the module of each tenant with rules, <tenant>.wasm
+	MemoryLimit    int           // Megabytes of memory a module may grow to
+	Timeout        time.Duration // Longest an evaluation may run
+	MaxModuleBytes int           // Largest module that can be uploaded
+}
+
+// Engine evaluates the rule modules of tenants
+type Engine struct {
+	runtime        wazero.Runtime
+	dir            string
+	timeout        time.Duration
+	maxModuleBytes int
+
+	mu      sync.RWMutex
+	modules map[string]*module // By tenant
+}
+
+// module is a compiled rule module
+type module struct {
+	compiled wazero.CompiledModule
+	size     int
+	modified time.Time // Of the file it was loaded from
+}
+
+// Module describes the rule module of a tenant
+type Module struct {
+	Tenant   string    `json:"tenant"`
+	Size     int       `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// New creates an engine and loads the modules in the directory
+func New(ctx context.Context, config Config) (*Engine, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create rules directory: %w", err)
+	}
+
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(config.MemoryLimit << 20 / pageSize)).
+		WithCloseOnContextDone(true)
+	e := &Engine{
+		runtime:        wazero.NewRuntimeWithConfig(ctx, runtimeConfig),
+		dir:            config.Dir,
+		timeout:        config.Timeout,
+		maxModuleBytes: config.MaxModuleBytes,
+		modules:        make(map[string]*module),
+	}
+	if err := e.Reload(ctx); err != nil {
+		e.Close(ctx)
+		return nil, err
+	}
+	return e, nil
+}
+
+// Evaluate runs the tenant's module on the input, encoded as JSON. It
+// reports false when the tenant has no module.
+func (e *Engine) Evaluate(ctx context.Context, tenant string, input any) (*Decision, bool, error) {
+	e.mu.RLock()
+	m, ok := e.modules[tenant]
+	e.mu.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to marshal input: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	// A fresh instance keeps evaluations from sharing state
+	instance, err := e.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to instantiate rules of tenant %s: %w", tenant, err)
+	}
+	defer instance.Close(context.WithoutCancel(ctx))
+
+	results, err := instance.ExportedFunction("alloc").Call(ctx, uint64(len(payload)))
+	if err != nil {
+		return nil, true, fmt.Errorf("alloc failed: %w", err)
+	}
+	ptr := uint32(results[0])
+	if !instance.Memory().Write(ptr, payload) {
+		return nil, true, fmt.Errorf("alloc returned memory out of range")
+	}
+
+	results, err = instance.ExportedFunction("evaluate").Call(ctx, uint64(ptr), uint64(len(payload)))
+	if err != nil {
+		return nil, true, fmt.Errorf("evaluate failed: %w", err)
+	}
+	outPtr, outLen := uint32(results[0]>>32), uint32(results[0])
+	if outLen > maxOutput {
+		return nil, true, fmt.Errorf("answer of %d bytes is too long", outLen)
+	}
+	output, ok := instance.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, true, fmt.Errorf("answer out of range")
+	}
+
+	var decision Decision
+	if err := json.Unmarshal(output, &decision); err != nil {
+		return nil, true, fmt.Errorf("invalid answer: %w", err)
+	}
+	return &decision, true, nil
+}
+
+// Install checks and compiles a tenant's module, stores it in the directory
+// and uses it from now on instead of the tenant's previous one
+func (e *Engine) Install(ctx context.Context, tenant string, wasm []byte) error {
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("%w: invalid tenant %q", ErrInvalid, tenant)
+	}
+	if len(wasm) > e.maxModuleBytes {
+		return fmt.Errorf("%w: module of %d bytes is larger than %d", ErrInvalid, len(wasm), e.maxModuleBytes)
+	}
+	compiled, err := e.compile(ctx, wasm)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	// Write it next to the others and rename it, so readers never see half a module
+	path := filepath.Join(e.dir, tenant+moduleExtension)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, wasm, 0o644); err != nil {
+		compiled.Close(ctx)
+		return fmt.Errorf("failed to store module: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		compiled.Close(ctx)
+		return fmt.Errorf("failed to store module: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		compiled.Close(ctx)
+		return fmt.Errorf("failed to store module: %w", err)
+	}
+
+	e.swap(ctx, tenant, &module{compiled: compiled, size: len(wasm), modified: info.ModTime()})
+	return nil
+}
+
+// Remove deletes a tenant's module, reporting false when it had none
+func (e *Engine) Remove(ctx context.Context, tenant string) (bool, error) {
+	if !tenantPattern.MatchString(tenant) {
+		return false, nil
+	}
+	err := os.Remove(filepath.Join(e.dir, tenant+moduleExtension))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to remove module: %w", err)
+	}
+	e.swap(ctx, tenant, nil)
+	return true, nil
+}
+
+// Modules returns the modules in use, by tenant
+func (e *Engine) Modules() []Module {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	modules := make([]Module, 0, len(e.modules))
+	for tenant, m := range e.modules {
+		modules = append(modules, Module{Tenant: tenant, Size: m.size, Modified: m.modified})
+	}
+	slices.SortFunc(modules, func(a, b Module) int { return strings.Compare(a.Tenant, b.Tenant) })
+	return modules
+}
+
+// Reload picks up the modules other instances sharing the directory
+// installed, changed or removed. A module that doesn't compile is logged
+// and leaves the tenant's previous one in use.
+func (e *Engine) Reload(ctx context.Context) error {
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list rule modules: %w", err)
+	}
+
+	found := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		tenant, ok := strings.CutSuffix(entry.Name(), moduleExtension)
+		if !ok || entry.IsDir() || !tenantPattern.MatchString(tenant) {
+			continue
+		}
+		found[tenant] = true
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		e.mu.RLock()
+		current, loaded := e.modules[tenant]
+		e.mu.RUnlock()
+		if loaded && current.modified.Equal(info.ModTime()) {
+			continue
+		}
+
+		wasm, err := os.ReadFile(filepath.Join(e.dir, entry.Name()))
+		if err != nil {
+			log.Printf("Failed to read rules of tenant %s: %v", tenant, err)
+			continue
+		}
+		compiled, err := e.compile(ctx, wasm)
+		if err != nil {
+			log.Printf("Ignoring rules of tenant %s: %v", tenant, err)
+			continue
+		}
+		e.swap(ctx, tenant, &module{compiled: compiled, size: len(wasm), modified: info.ModTime()})
+		log.Printf("Loaded rules of tenant %s", tenant)
+	}
+
+	for _, m := range e.Modules() {
+		if !found[m.Tenant] {
+			e.swap(ctx, m.Tenant, nil)
+			log.Printf("Unloaded rules of tenant %s", m.Tenant)
+		}
+	}
+	return nil
+}
+
+// Run reloads the modules every interval until the context is done
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Reload(ctx); err != nil {
+				log.Printf("Failed to reload rule modules: %v", err)
+			}
+		}
+	}
+}
+
+// Close releases the compiled modules
+func (e *Engine) Close(ctx context.Context) error {
+	return e.runtime.Close(ctx)
+}
+
+// compile compiles a module and checks it is a rule module
+func (e *Engine) compile(ctx context.Context, wasm []byte) (wazero.CompiledModule, error) {
+	compiled, err := e.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid module: %w", err)
+	}
+	if err := check(compiled); err != nil {
+		compiled.Close(ctx)
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// check verifies a module imports nothing and exports what rules need
+func check(compiled wazero.CompiledModule) error {
+	if imports := compiled.ImportedFunctions(); len(imports) > 0 {
+		module, name, _ := imports[0].Import()
+		return fmt.Errorf("rule modules can't import functions, found %s.%s", module, name)
+	}
+	if len(compiled.ImportedMemories()) > 0 {
+		return fmt.Errorf("rule modules can't import memory")
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return fmt.Errorf("rule modules must export their memory")
+	}
+
+	exports := compiled.ExportedFunctions()
+	signatures := map[string][2][]api.ValueType{
+		"alloc":    {{api.ValueTypeI32}, {api.ValueTypeI32}},
+		"evaluate": {{api.ValueTypeI32, api.ValueTypeI32}, {api.ValueTypeI64}},
+	}
+	for name, signature := range signatures {
+		function, ok := exports[name]
+		if !ok {
+			return fmt.Errorf("rule modules must export %s", name)
+		}
+		if !slices.Equal(function.ParamTypes(), signature[0]) || !slices.Equal(function.ResultTypes(), signature[1]) {
+			return fmt.Errorf("exported %s has the wrong signature", name)
+		}
+	}
+	return nil
+}
+
+// swap puts a tenant's module in use, none removing it, and releases the
+// previous one
+func (e *Engine) swap(ctx context.Context, tenant string, m *module) {
+	e.mu.Lock()
+	previous := e.modules[tenant]
+	if m != nil {
+		e.modules[tenant] = m
+	} else {
+		delete(e.modules, tenant)
+	}
+	e.mu.Unlock()
+
+	if previous != nil {
+		previous.compiled.Close(ctx)
+	}
+}
@@ -0,0 +1,65 @@
+package wasmrules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Handler serves the rule module API:
+//
+//	GET    /wasm-rules           the modules in use
+//	PUT    /wasm-rules/{tenant}  the module as the body, replacing the tenant's
+//	DELETE /wasm-rules/{tenant}
+//
+// Uploaded modules are checked and compiled before they are stored, so a
+// module that can't run is refused with 400.
+func (e *Engine) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /wasm-rules", e.handleModules)
+	mux.HandleFunc("PUT /wasm-rules/{tenant}", e.handleInstall)
+	mux.HandleFunc("DELETE /wasm-rules/{tenant}", e.handleRemove)
+	return mux
+}
+
+// Handles requests for the modules in use
+func (e *Engine) handleModules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"modules": e.Modules(),
+	})
+}
+
+// Handles requests to install a tenant's module
+func (e *Engine) handleInstall(w http.ResponseWriter, r *http.Request) {
+	wasm, err := io.ReadAll(io.LimitReader(r.Body, int64(e.maxModuleBytes)+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read module: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := e.Install(r.Context(), r.PathValue("tenant"), wasm); errors.Is(err, ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.handleModules(w, r)
+}
+
+// Handles requests to remove a tenant's module
+func (e *Engine) handleRemove(w http.ResponseWriter, r *http.Request) {
+	tenant := r.PathValue("tenant")
+	removed, err := e.Remove(r.Context(), tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, fmt.Sprintf("no rules for tenant %s", tenant), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/kafka"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/providers"
+)
+
+// HTTP server struct
+type Server struct {
+	server       *http.Server
+	producer     kafka.Producer
+	maxBodyBytes int64
+	handled      atomic.Int64 // Requests handled since start, health and version checks aside
+}
+
+// Creates a new HTTP server receiving the callbacks of the providers
+func NewServer(cfg config.ServerConfig, producer kafka.Producer, callbackProviders []providers.Provider) *Server {
+	mux := http.NewServeMux()
+
+	server := Server{
+		server: &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.Port),
+			Handler:      mux,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		},
+		producer:     producer,
+		maxBodyBytes: int64(cfg.MaxBodyBytes),
+	}
+
+	// Routes
+	for _, provider := range callbackProviders {
+		mux.HandleFunc("POST /webhooks/"+provider.Name(), server.handleCallback(provider))
+	}
+	mux.HandleFunc("/health", server.handleHealth)
+	mux.HandleFunc("GET /version", server.handleVersion)
+	server.server.Handler = server.counted(mux)
+
+	return &server
+}
+
+// Starts the HTTP server
+func (s *Server) Start() error {
+	return s.server.ListenAndServe()
+}
+
+// Gracefully shuts down the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Returns the number of requests handled since start, health and version checks aside
+func (s *Server) Handled() int64 {
+	return s.handled.Load()
+}
+
+// Counts the requests handled by next
+func (s *Server) counted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if r.URL.Path != "/health" && r.URL.Path != "/version" {
+			s.handled.Add(1)
+		}
+	})
+}
+
+// Handles the callbacks of a provider, publishing the statuses they report.
+// Callbacks fail with 500 until every status is published, so the provider
+// sends them again; callbacks it shouldn't send again fail with 4xx.
+func (s *Server) handleCallback(provider providers.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+
+		events, err := provider.Events(r)
+		if errors.Is(err, providers.ErrUnauthorized) {
+			log.Printf("Refused %s callback from %s: %v", provider.Name(), r.RemoteAddr, err)
+			http.Error(w, "Callback not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, providers.ErrInvalid) {
+			log.Printf("Invalid %s callback: %v", provider.Name(), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to handle %s callback: %v", provider.Name(), err)
+			http.Error(w, "Failed to handle callback", http.StatusInternalServerError)
+			return
+		}
+
+		for _, event := range events {
+			if err := s.producer.PublishStatus(r.Context(), event); err != nil {
+				log.Printf("Failed to record %s status of notification %s: %v", provider.Name(), event.NotificationID, err)
+				http.Error(w, "Failed to record status", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Handles requests for the version of the running code
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
+
+// Handles health check requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+		"time":   time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/envconfig"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/secrets"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/signing"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/providers"
+)
+
+// HTTP server config
+type ServerConfig struct {
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	MaxBodyBytes int // Largest accepted callback body
+}
+
+// Kafka config for the status events callbacks report
+type KafkaConfig struct {
+	Brokers           []string
+	Topic             string
+	RetryMax          int
+	RequiredAcks      int
+	Partitions        int
+	ReplicationFactor int
+	SendTimeout       time.Duration
+	Signing           signing.Config // Keys produced messages are signed with
+}
+
+// Producer returns the settings the producers of the service share
+func (c KafkaConfig) Producer() kafkaproducer.Config {
+	return kafkaproducer.Config{
+		Brokers:           c.Brokers,
+		RequiredAcks:      c.RequiredAcks,
+		RetryMax:          c.RetryMax,
+		Partitions:        c.Partitions,
+		ReplicationFactor: c.ReplicationFactor,
+		Signing:           c.Signing,
+	}
+}
+
+// Provider callback config, callbacks are only accepted from configured providers
+type ProvidersConfig struct {
+	SES    SESConfig
+	Twilio TwilioConfig
+	FCM    FCMConfig
+}
+
+// SES events published to SNS
+type SESConfig struct {
+	TopicARNs []string      // SNS topics of the configuration sets, callbacks from other topics are refused
+	Timeout   time.Duration // For fetching signing certificates and confirming subscriptions
+}
+
+// Twilio status callbacks
+type TwilioConfig struct {
+	AuthToken string // Or a reference to a secret holding it
+	BaseURL   string // Scheme and host Twilio calls the service at, when a proxy in front changes them
+}
+
+// FCM delivery receipts reported by the app
+type FCMConfig struct {
+	ReceiptToken string // Bearer token of the receipts, or a reference to a secret holding it
+}
+
+// Main config
+type Config struct {
+	Server    ServerConfig
+	Kafka     KafkaConfig
+	Providers ProvidersConfig
+	Startup   StartupConfig
+	Heartbeat HeartbeatConfig
+	Shutdown  ShutdownConfig
+	Secrets   secrets.Config
+
+	secretStore *secrets.Store // Resolves the secrets Load read, and keeps them current
+}
+
+// Returns the store the configuration's secrets were resolved from
+func (c *Config) SecretStore() *secrets.Store {
+	return c.secretStore
+}
+
+// Creates the providers callbacks are accepted from, leaving out the ones without config
+func (c *Config) CreateProviders() []providers.Provider {
+	var created []providers.Provider
+	if len(c.Providers.SES.TopicARNs) > 0 {
+		created = append(created, providers.NewSES(c.Providers.SES.TopicARNs, c.Providers.SES.Timeout))
+	}
+	if c.Providers.Twilio.AuthToken != "" {
+		authToken := c.current("TWILIO_AUTH_TOKEN", c.Providers.Twilio.AuthToken)
+		created = append(created, providers.NewTwilio(authToken, c.Providers.Twilio.BaseURL))
+	}
+	if c.Providers.FCM.ReceiptToken != "" {
+		created = append(created, providers.NewFCM(c.current("FCM_RECEIPT_TOKEN", c.Providers.FCM.ReceiptToken)))
+	}
+	return created
+}
+
+// Returns a function reading the current value of a secret, the value it
+// was loaded with when the variable held the secret itself
+func (c *Config) current(key, value string) func() string {
+	if current := c.secretStore.Current(key); current != nil {
+		return current
+	}
+	return func() string { return value }
+}
+
+// Holds how long the service waits for Kafka at startup
+type StartupConfig struct {
+	Preflight       bool          // Check the dependencies before creating any client
+	RetryTimeout    time.Duration // Give up after this long, zero tries once
+	RetryBackoff    time.Duration // Wait after the first failed attempt, doubled after every further one
+	RetryMaxBackoff time.Duration
+}
+
+// Holds where and how often the instance announces it is alive
+type HeartbeatConfig struct {
+	Topic      string        // Internal ops topic heartbeats are published to
+	Interval   time.Duration // Zero disables heartbeats
+	InstanceID string        // Defaults to the host name, the container ID under Docker
+}
+
+// Holds the timeouts of the shutdown stages, run in this order
+type ShutdownConfig struct {
+	Drain time.Duration // Stop accepting requests and finish the ones in flight
+	Flush time.Duration // Flush and close the producers
+}
+
+// DefaultConfig
+var DefaultConfig = Config{
+	Server: ServerConfig{
+		Port:         8083,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		MaxBodyBytes: 1 << 20,
+	},
+	Kafka: KafkaConfig{
+		Brokers:           []string{"localhost:9092"},
+		Topic:             "notifications.status",
+		RetryMax:          3,
+		RequiredAcks:      1,
+		Partitions:        3,
+		ReplicationFactor: 2,
+		SendTimeout:       5 * time.Second,
+	},
+	Providers: ProvidersConfig{
+		SES: SESConfig{
+			Timeout: 5 * time.Second,
+		},
+	},
+	Startup: StartupConfig{
+		Preflight:       true,
+		RetryTimeout:    2 * time.Minute,
+		RetryBackoff:    time.Second,
+		RetryMaxBackoff: 15 * time.Second,
+	},
+	Heartbeat: HeartbeatConfig{
+		Topic:    "notifications.ops",
+		Interval: 10 * time.Second,
+	},
+	Shutdown: ShutdownConfig{
+		Drain: 10 * time.Second,
+		Flush: 5 * time.Second,
+	},
+	Secrets: secrets.Config{
+		RefreshInterval: 5 * time.Minute,
+	},
+}
+
+// Loads config from environment variables
+func Load() (*Config, error) {
+	cfg := DefaultConfig
+
+	// Secrets config first, any secret below may refer to a provider
+	envconfig.LoadStringEnv("VAULT_ADDR", &cfg.Secrets.Vault.Addr)
+	envconfig.LoadStringEnv("VAULT_TOKEN", &cfg.Secrets.Vault.Token)
+	envconfig.LoadStringEnv("VAULT_NAMESPACE", &cfg.Secrets.Vault.Namespace)
+	envconfig.LoadStringEnv("AWS_REGION", &cfg.Secrets.SSM.Region)
+	envconfig.LoadStringEnv("AWS_ACCESS_KEY_ID", &cfg.Secrets.SSM.AccessKeyID)
+	envconfig.LoadStringEnv("AWS_SECRET_ACCESS_KEY", &cfg.Secrets.SSM.SecretAccessKey)
+	envconfig.LoadStringEnv("AWS_SESSION_TOKEN", &cfg.Secrets.SSM.SessionToken)
+	envconfig.LoadStringEnv("SSM_ENDPOINT", &cfg.Secrets.SSM.Endpoint)
+	envconfig.LoadDurationEnv("SECRETS_REFRESH_INTERVAL", &cfg.Secrets.RefreshInterval)
+	store := secrets.New(cfg.Secrets)
+	cfg.secretStore = store
+
+	// Server config
+	envconfig.LoadIntEnv("SERVER_PORT", &cfg.Server.Port)
+	envconfig.LoadDurationEnv("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	envconfig.LoadDurationEnv("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	envconfig.LoadDurationEnv("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	envconfig.LoadIntEnv("SERVER_MAX_BODY_BYTES", &cfg.Server.MaxBodyBytes)
+
+	// Kafka config
+	envconfig.LoadJSONStringArrayEnv("KAFKA_BROKERS", &cfg.Kafka.Brokers)
+	envconfig.LoadStringEnv("KAFKA_TOPIC", &cfg.Kafka.Topic)
+	envconfig.LoadIntEnv("KAFKA_RETRY_MAX", &cfg.Kafka.RetryMax)
+	envconfig.LoadIntEnv("KAFKA_REQUIRED_ACKS", &cfg.Kafka.RequiredAcks)
+	envconfig.LoadIntEnv("KAFKA_PARTITIONS", &cfg.Kafka.Partitions)
+	envconfig.LoadIntEnv("KAFKA_REPLICATION_FACTOR", &cfg.Kafka.ReplicationFactor)
+	envconfig.LoadDurationEnv("KAFKA_SEND_TIMEOUT", &cfg.Kafka.SendTimeout)
+	if err := envconfig.LoadJSONStringMapSecretEnv(store, "KAFKA_SIGNING_KEYS", &cfg.Kafka.Signing.Keys); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("KAFKA_SIGNING_ACTIVE_KEY_ID", &cfg.Kafka.Signing.ActiveKeyID)
+	if _, err := cfg.Kafka.Signing.CreateKeyring(); err != nil {
+		return nil, fmt.Errorf("KAFKA_SIGNING_KEYS are invalid: %w", err)
+	}
+
+	// Provider config
+	envconfig.LoadJSONStringArrayEnv("SES_TOPIC_ARNS", &cfg.Providers.SES.TopicARNs)
+	envconfig.LoadDurationEnv("SES_TIMEOUT", &cfg.Providers.SES.Timeout)
+	if err := store.Load("TWILIO_AUTH_TOKEN", &cfg.Providers.Twilio.AuthToken); err != nil {
+		return nil, err
+	}
+	envconfig.LoadStringEnv("TWILIO_BASE_URL", &cfg.Providers.Twilio.BaseURL)
+	if err := store.Load("FCM_RECEIPT_TOKEN", &cfg.Providers.FCM.ReceiptToken); err != nil {
+		return nil, err
+	}
+	if len(cfg.Providers.SES.TopicARNs) == 0 && cfg.Providers.Twilio.AuthToken == "" && cfg.Providers.FCM.ReceiptToken == "" {
+		return nil, fmt.Errorf("at least one of SES_TOPIC_ARNS, TWILIO_AUTH_TOKEN and FCM_RECEIPT_TOKEN must be set")
+	}
+
+	// Load heartbeat config
+	envconfig.LoadStringEnv("OPS_TOPIC", &cfg.Heartbeat.Topic)
+	envconfig.LoadDurationEnv("HEARTBEAT_INTERVAL", &cfg.Heartbeat.Interval)
+	envconfig.LoadStringEnv("INSTANCE_ID", &cfg.Heartbeat.InstanceID)
+	if cfg.Heartbeat.InstanceID == "" {
+		cfg.Heartbeat.InstanceID, _ = os.Hostname()
+	}
+
+	// General config
+	envconfig.LoadBoolEnv("STARTUP_PREFLIGHT", &cfg.Startup.Preflight)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_TIMEOUT", &cfg.Startup.RetryTimeout)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_BACKOFF", &cfg.Startup.RetryBackoff)
+	envconfig.LoadDurationEnv("STARTUP_RETRY_MAX_BACKOFF", &cfg.Startup.RetryMaxBackoff)
+	envconfig.LoadDurationEnv("SHUTDOWN_TIMEOUT", &cfg.Shutdown.Drain) // Legacy name of the drain timeout
+	envconfig.LoadDurationEnv("SHUTDOWN_DRAIN_TIMEOUT", &cfg.Shutdown.Drain)
+	envconfig.LoadDurationEnv("SHUTDOWN_FLUSH_TIMEOUT", &cfg.Shutdown.Flush)
+
+	return &cfg, nil
+}
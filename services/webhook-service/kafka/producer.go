@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaproducer"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/models"
+)
+
+// Interface for publishing the delivery statuses providers report
+type Producer interface {
+	PublishStatus(ctx context.Context, event *models.StatusEvent) error
+	Close() error
+}
+
+// Implements the Producer interface using Sarama
+type KafkaProducer struct {
+	producer    sarama.SyncProducer
+	topic       string
+	sendTimeout time.Duration // Upper bound for a single send, zero means no timeout
+}
+
+// Creates a new Kafka producer
+func NewProducer(cfg config.KafkaConfig) (Producer, error) {
+	// Ensure the topic exists and create the signed producer
+	sarama_producer, err := kafkaproducer.NewSyncProducer(cfg.Producer(), cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	kafkaProducer := KafkaProducer{
+		producer:    sarama_producer,
+		topic:       cfg.Topic,
+		sendTimeout: cfg.SendTimeout,
+	}
+
+	return &kafkaProducer, nil
+}
+
+// Publishes a status event
+func (p *KafkaProducer) PublishStatus(ctx context.Context, event *models.StatusEvent) error {
+	// Marshal event to JSON
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status event: %w", err)
+	}
+
+	// Create message
+	msg := &sarama.ProducerMessage{
+		Topic: p.topic,
+		Key:   sarama.StringEncoder(event.NotificationID), // Keep a notification's status events in order
+		Value: sarama.ByteEncoder(payload),
+	}
+
+	// Send message
+	partition, offset, err := p.send(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("failed to send status event: %w", err)
+	}
+
+	log.Printf("Status %s of notification %s from %s sent to partition %d at offset %d",
+		event.Status, event.NotificationID, event.Provider, partition, offset)
+	return nil
+}
+
+// Sends a message, giving up once the context is done or the send timeout elapses.
+// An abandoned send may still complete in the background.
+func (p *KafkaProducer) send(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	return kafkaproducer.Send(ctx, p.producer, msg, p.sendTimeout)
+}
+
+// Closes the Kafka producer
+func (p *KafkaProducer) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/buildinfo"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/heartbeat"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/kafkaadmin"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/shutdown"
+	"github.com/sahilsGit/scalable-notifications-service/services/shared/startup"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/api"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/config"
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/kafka"
+)
+
+func main() {
+	log.Printf("Starting Webhook Service %s", buildinfo.Get())
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Check every dependency up front, so a misconfiguration fails with what to fix
+	if cfg.Startup.Preflight {
+		retry := startup.Config{
+			Timeout:        cfg.Startup.RetryTimeout,
+			InitialBackoff: cfg.Startup.RetryBackoff,
+			MaxBackoff:     cfg.Startup.RetryMaxBackoff,
+		}
+		checks := []startup.Check{
+			{
+				Name: "Kafka brokers",
+				Hint: "check KAFKA_BROKERS and that the brokers are up",
+				Run: func(ctx context.Context) error {
+					return kafkaadmin.CheckTopics(ctx, cfg.Kafka.Brokers)
+				},
+			},
+		}
+		if err := startup.Preflight(context.Background(), retry, checks...); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Fetch the referenced secrets again, so rotated provider tokens verify callbacks
+	if cfg.Secrets.RefreshInterval > 0 {
+		go cfg.SecretStore().Run(context.Background(), cfg.Secrets.RefreshInterval)
+	}
+
+	// Initialize Kafka producer
+	producer, err := kafka.NewProducer(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Failed to create Kafka producer: %v", err)
+	}
+
+	// Initialize and start HTTP server
+	callbackProviders := cfg.CreateProviders()
+	server := api.NewServer(cfg.Server, producer, callbackProviders)
+	for _, provider := range callbackProviders {
+		log.Printf("Receiving %s callbacks on /webhooks/%s", provider.Name(), provider.Name())
+	}
+
+	// Announce this instance on the ops topic
+	flush := []func(ctx context.Context) error{shutdown.Close(producer.Close)}
+	if cfg.Heartbeat.Interval > 0 {
+		heartbeater, err := heartbeat.New(cfg.Kafka.Producer(), heartbeat.Config{
+			Service:    "webhook-service",
+			InstanceID: cfg.Heartbeat.InstanceID,
+			Topic:      cfg.Heartbeat.Topic,
+			Interval:   cfg.Heartbeat.Interval,
+		}, func(h *heartbeat.Heartbeat) {
+			h.Processed = server.Handled()
+		})
+		if err != nil {
+			log.Fatalf("Failed to create heartbeater: %v", err)
+		}
+		flush = append(flush, shutdown.Close(heartbeater.Close))
+		log.Printf("Publishing heartbeats of instance %s every %v", cfg.Heartbeat.InstanceID, cfg.Heartbeat.Interval)
+	}
+
+	go func() {
+		if err := server.Start(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	log.Println("Webhook Service started successfully")
+
+	// Wait for termination signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sigCh
+
+	log.Println("Shutdown signal received")
+
+	// Finish the callbacks in flight before the producer they publish with is closed
+	var sequencer shutdown.Sequencer
+	sequencer.Stage("drain", cfg.Shutdown.Drain, server.Shutdown)
+	sequencer.Stage("flush", cfg.Shutdown.Flush, flush...)
+	sequencer.Run()
+
+	log.Println("Server gracefully stopped")
+}
@@ -0,0 +1,23 @@
+package models
+
+import "github.com/sahilsGit/scalable-notifications-service/services/shared/messages"
+
+// Status event types
+const (
+	StatusOpened     = messages.StatusOpened
+	StatusDelivered  = messages.StatusDelivered
+	StatusFailed     = messages.StatusFailed
+	StatusBounced    = messages.StatusBounced
+	StatusComplained = messages.StatusComplained
+)
+
+// Channels providers report on
+const (
+	ChannelEmail    = messages.ChannelEmail
+	ChannelPush     = messages.ChannelPush
+	ChannelWhatsApp = messages.ChannelWhatsApp
+	ChannelSMS      = messages.ChannelSMS
+)
+
+// Event sent to the status topic when a provider reports what happened to a notification it sent
+type StatusEvent = messages.StatusEvent
@@ -0,0 +1,80 @@
+package providers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/models"
+)
+
+// Most receipts accepted in one callback
+const maxFCMReceipts = 500
+
+// FCM reads push delivery receipts. FCM has no delivery callbacks of its
+// own, so the app reports them, through its backend or straight from the
+// devices, as a JSON array of receipts with the shared bearer token.
+type FCM struct {
+	token func() string // Current bearer token
+}
+
+// fcmReceipt reports what happened to one push notification
+type fcmReceipt struct {
+	NotificationID string `json:"notification_id"`
+	UserID         string `json:"user_id"`
+	MessageID      string `json:"message_id"` // FCM message ID the send returned
+	Status         string `json:"status"`     // delivered or failed
+	Reason         string `json:"reason"`     // Why it failed, e.g. the FCM error code
+	OccurredAt     int64  `json:"occurred_at"`
+}
+
+// NewFCM creates the FCM provider accepting receipts with the token
+func NewFCM(token func() string) *FCM {
+	return &FCM{token: token}
+}
+
+// Name of the provider
+func (f *FCM) Name() string {
+	return "fcm"
+}
+
+// Events returns the statuses the receipts report
+func (f *FCM) Events(r *http.Request) ([]*models.StatusEvent, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(f.token())) != 1 {
+		return nil, fmt.Errorf("%w: missing or wrong bearer token", ErrUnauthorized)
+	}
+
+	var receipts []fcmReceipt
+	if err := json.NewDecoder(r.Body).Decode(&receipts); err != nil {
+		return nil, fmt.Errorf("%w: not a JSON array of receipts: %v", ErrInvalid, err)
+	}
+	if len(receipts) > maxFCMReceipts {
+		return nil, fmt.Errorf("%w: more than %d receipts", ErrInvalid, maxFCMReceipts)
+	}
+
+	events := make([]*models.StatusEvent, 0, len(receipts))
+	for i, receipt := range receipts {
+		if receipt.NotificationID == "" || receipt.UserID == "" || receipt.OccurredAt == 0 {
+			return nil, fmt.Errorf("%w: receipt %d misses notification_id, user_id or occurred_at", ErrInvalid, i)
+		}
+		switch receipt.Status {
+		case models.StatusDelivered, models.StatusFailed:
+		default:
+			return nil, fmt.Errorf("%w: receipt %d has status %q, not delivered or failed", ErrInvalid, i, receipt.Status)
+		}
+		events = append(events, &models.StatusEvent{
+			NotificationID:    receipt.NotificationID,
+			UserID:            receipt.UserID,
+			Status:            receipt.Status,
+			Channel:           models.ChannelPush,
+			Provider:          f.Name(),
+			ProviderMessageID: receipt.MessageID,
+			Reason:            receipt.Reason,
+			OccurredAt:        receipt.OccurredAt,
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,31 @@
+// Package providers turns the callbacks of the providers delivery workers
+// send through into status events. Each provider checks that a callback
+// really comes from it before reading anything from it, and finds the
+// notification it is about in the data delivery workers attach to every
+// message they send, e.g. SES message tags or Twilio status callback URLs.
+package providers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/models"
+)
+
+var (
+	// ErrUnauthorized is returned for callbacks that don't come from the provider
+	ErrUnauthorized = errors.New("callback not authenticated")
+	// ErrInvalid is returned for callbacks that can't be read
+	ErrInvalid = errors.New("invalid callback")
+)
+
+// Provider reads the callbacks of one provider
+type Provider interface {
+	// Name of the provider, callbacks are received on /webhooks/{name}
+	Name() string
+	// Events returns the status events a callback reports, none for
+	// callbacks about statuses nobody tracks or messages the pipeline
+	// didn't send. Its errors wrap ErrUnauthorized or ErrInvalid when the
+	// provider shouldn't send the callback again.
+	Events(r *http.Request) ([]*models.StatusEvent, error)
+}
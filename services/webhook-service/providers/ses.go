@@ -0,0 +1,168 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/models"
+)
+
+// Message tags delivery workers set on every email they send through SES
+const (
+	sesTagNotificationID = "notification_id"
+	sesTagUserID         = "user_id"
+)
+
+// SES reads the events SES publishes to SNS topics through a configuration
+// set, confirming the subscriptions of the topics it accepts. Only topics
+// named in the config are accepted, since anyone can have SNS sign messages
+// of their own topics.
+type SES struct {
+	topics   map[string]bool
+	verifier *snsVerifier
+	client   *http.Client
+}
+
+// sesEvent is an SES event, as published by a configuration set
+type sesEvent struct {
+	EventType string `json:"eventType"`
+	Mail      struct {
+		MessageID string              `json:"messageId"`
+		Timestamp time.Time           `json:"timestamp"`
+		Tags      map[string][]string `json:"tags"`
+	} `json:"mail"`
+	Delivery *struct {
+		Timestamp time.Time `json:"timestamp"`
+	} `json:"delivery"`
+	Bounce *struct {
+		BounceType    string    `json:"bounceType"`
+		BounceSubType string    `json:"bounceSubType"`
+		Timestamp     time.Time `json:"timestamp"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplaintFeedbackType string    `json:"complaintFeedbackType"`
+		Timestamp             time.Time `json:"timestamp"`
+	} `json:"complaint"`
+	Reject *struct {
+		Reason string `json:"reason"`
+	} `json:"reject"`
+}
+
+// NewSES creates the SES provider accepting events from the topics
+func NewSES(topicARNs []string, timeout time.Duration) *SES {
+	topics := make(map[string]bool, len(topicARNs))
+	for _, arn := range topicARNs {
+		topics[arn] = true
+	}
+	client := &http.Client{Timeout: timeout}
+	return &SES{
+		topics:   topics,
+		verifier: newSNSVerifier(client),
+		client:   client,
+	}
+}
+
+// Name of the provider
+func (s *SES) Name() string {
+	return "ses"
+}
+
+// Events returns the status an SES event reports
+func (s *SES) Events(r *http.Request) ([]*models.StatusEvent, error) {
+	var msg snsMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("%w: not an SNS message: %v", ErrInvalid, err)
+	}
+	if !s.topics[msg.TopicArn] {
+		return nil, fmt.Errorf("%w: SNS topic %s is not accepted", ErrUnauthorized, msg.TopicArn)
+	}
+	if err := s.verifier.verify(r.Context(), &msg); err != nil {
+		return nil, err
+	}
+
+	switch msg.Type {
+	case snsNotification:
+	case snsSubscriptionConfirmation:
+		return nil, s.confirm(r.Context(), &msg)
+	case snsUnsubscribeConfirmation:
+		log.Printf("Unsubscribed from SNS topic %s", msg.TopicArn)
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown SNS message type %q", ErrInvalid, msg.Type)
+	}
+
+	var event sesEvent
+	if err := json.Unmarshal([]byte(msg.Message), &event); err != nil {
+		return nil, fmt.Errorf("%w: not an SES event: %v", ErrInvalid, err)
+	}
+	status := &models.StatusEvent{
+		NotificationID:    sesTag(event.Mail.Tags, sesTagNotificationID),
+		UserID:            sesTag(event.Mail.Tags, sesTagUserID),
+		Channel:           models.ChannelEmail,
+		Provider:          s.Name(),
+		ProviderMessageID: event.Mail.MessageID,
+	}
+	occurredAt := event.Mail.Timestamp
+	switch {
+	case event.EventType == "Delivery" && event.Delivery != nil:
+		status.Status = models.StatusDelivered
+		occurredAt = event.Delivery.Timestamp
+	case event.EventType == "Bounce" && event.Bounce != nil:
+		status.Status = models.StatusBounced
+		status.Reason = event.Bounce.BounceType + "/" + event.Bounce.BounceSubType
+		occurredAt = event.Bounce.Timestamp
+	case event.EventType == "Complaint" && event.Complaint != nil:
+		status.Status = models.StatusComplained
+		status.Reason = event.Complaint.ComplaintFeedbackType
+		occurredAt = event.Complaint.Timestamp
+	case event.EventType == "Reject" && event.Reject != nil:
+		status.Status = models.StatusFailed
+		status.Reason = event.Reject.Reason
+	default:
+		// Sends, opens, clicks and delays aren't delivery outcomes
+		return nil, nil
+	}
+	status.OccurredAt = occurredAt.Unix()
+
+	if status.NotificationID == "" || status.UserID == "" {
+		log.Printf("Ignoring SES %s event of message %s without notification tags", event.EventType, event.Mail.MessageID)
+		return nil, nil
+	}
+	return []*models.StatusEvent{status}, nil
+}
+
+// confirm confirms the subscription of an accepted topic, so SNS starts
+// sending it events
+func (s *SES) confirm(ctx context.Context, msg *snsMessage) error {
+	if err := checkSNSURL(msg.SubscribeURL); err != nil {
+		return fmt.Errorf("%w: subscribe URL: %v", ErrInvalid, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, msg.SubscribeURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to confirm subscription: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to confirm subscription: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to confirm subscription: status %d", resp.StatusCode)
+	}
+
+	log.Printf("Confirmed subscription to SNS topic %s", msg.TopicArn)
+	return nil
+}
+
+// sesTag returns the first value of a message tag, empty when it isn't set
+func sesTag(tags map[string][]string, name string) string {
+	if values := tags[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
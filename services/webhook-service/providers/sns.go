@@ -0,0 +1,172 @@
+package providers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNS message types
+const (
+	snsNotification             = "Notification"
+	snsSubscriptionConfirmation = "SubscriptionConfirmation"
+	snsUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// Largest signing certificate fetched
+const maxCertificateBytes = 64 << 10
+
+// Hosts SNS signing certificates and subscription confirmations are served from
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsMessage is the envelope SNS posts every message in
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// stringToSign returns the fields of the message SNS signed, in the order
+// and format it signs them
+func (m *snsMessage) stringToSign() []byte {
+	var fields []string
+	if m.Type == snsNotification {
+		fields = []string{"Message", m.Message, "MessageId", m.MessageID}
+		if m.Subject != "" {
+			fields = append(fields, "Subject", m.Subject)
+		}
+		fields = append(fields, "Timestamp", m.Timestamp, "TopicArn", m.TopicArn, "Type", m.Type)
+	} else {
+		fields = []string{
+			"Message", m.Message,
+			"MessageId", m.MessageID,
+			"SubscribeURL", m.SubscribeURL,
+			"Timestamp", m.Timestamp,
+			"Token", m.Token,
+			"TopicArn", m.TopicArn,
+			"Type", m.Type,
+		}
+	}
+	return []byte(strings.Join(fields, "\n") + "\n")
+}
+
+// snsVerifier checks the signatures of SNS messages against the
+// certificates SNS signs them with, fetching each certificate once
+type snsVerifier struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate // By URL, SNS serves a rotated certificate at a new one
+}
+
+// newSNSVerifier creates a verifier fetching certificates with the client
+func newSNSVerifier(client *http.Client) *snsVerifier {
+	return &snsVerifier{
+		client: client,
+		certs:  make(map[string]*x509.Certificate),
+	}
+}
+
+// verify checks that SNS signed the message
+func (v *snsVerifier) verify(ctx context.Context, msg *snsMessage) error {
+	var algorithm x509.SignatureAlgorithm
+	switch msg.SignatureVersion {
+	case "1":
+		algorithm = x509.SHA1WithRSA
+	case "2":
+		algorithm = x509.SHA256WithRSA
+	default:
+		return fmt.Errorf("%w: unknown SNS signature version %q", ErrUnauthorized, msg.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: SNS signature is not base64: %v", ErrUnauthorized, err)
+	}
+	cert, err := v.certificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignature(algorithm, msg.stringToSign(), signature); err != nil {
+		return fmt.Errorf("%w: SNS signature doesn't match: %v", ErrUnauthorized, err)
+	}
+	return nil
+}
+
+// certificate returns the signing certificate at the URL, which must be served by SNS
+func (v *snsVerifier) certificate(ctx context.Context, rawURL string) (*x509.Certificate, error) {
+	if err := checkSNSURL(rawURL); err != nil {
+		return nil, fmt.Errorf("%w: signing certificate: %v", ErrUnauthorized, err)
+	}
+
+	v.mu.Lock()
+	cert, ok := v.certs[rawURL]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cert.NotAfter) {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing certificate: %w", err)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing certificate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch signing certificate: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCertificateBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: signing certificate %s is not PEM encoded", ErrUnauthorized, rawURL)
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signing certificate %s: %v", ErrUnauthorized, rawURL, err)
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("%w: signing certificate %s is not valid now", ErrUnauthorized, rawURL)
+	}
+
+	v.mu.Lock()
+	v.certs[rawURL] = cert
+	v.mu.Unlock()
+	return cert, nil
+}
+
+// checkSNSURL checks that a URL in a message points at SNS, so a forged
+// message can't make the service fetch anything else
+func checkSNSURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || !snsHost.MatchString(u.Host) {
+		return fmt.Errorf("%s is not an SNS URL", rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sahilsGit/scalable-notifications-service/services/webhook-service/models"
+)
+
+// Header Twilio signs its callbacks in
+const twilioSignatureHeader = "X-Twilio-Signature"
+
+// Twilio reads the status callbacks of SMS and WhatsApp messages. Delivery
+// workers pass the notification in the query of the status callback URL,
+// e.g. /webhooks/twilio?notification_id=...&user_id=..., which Twilio signs
+// along with the callback.
+type Twilio struct {
+	authToken func() string // Current auth token, callbacks are signed with it
+	baseURL   string        // Scheme and host Twilio calls the service at, empty for the request's host over https
+}
+
+// NewTwilio creates the Twilio provider verifying callbacks with the auth token
+func NewTwilio(authToken func() string, baseURL string) *Twilio {
+	return &Twilio{
+		authToken: authToken,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Name of the provider
+func (t *Twilio) Name() string {
+	return "twilio"
+}
+
+// Events returns the status a callback reports
+func (t *Twilio) Events(r *http.Request) ([]*models.StatusEvent, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if !hmac.Equal([]byte(r.Header.Get(twilioSignatureHeader)), []byte(t.signature(r))) {
+		return nil, fmt.Errorf("%w: %s doesn't match", ErrUnauthorized, twilioSignatureHeader)
+	}
+
+	status := &models.StatusEvent{
+		NotificationID:    r.URL.Query().Get("notification_id"),
+		UserID:            r.URL.Query().Get("user_id"),
+		Channel:           models.ChannelSMS,
+		Provider:          t.Name(),
+		ProviderMessageID: r.PostForm.Get("MessageSid"),
+		OccurredAt:        time.Now().Unix(),
+	}
+	if strings.HasPrefix(r.PostForm.Get("From"), "whatsapp:") {
+		status.Channel = models.ChannelWhatsApp
+	}
+	switch r.PostForm.Get("MessageStatus") {
+	case "delivered":
+		status.Status = models.StatusDelivered
+	case "undelivered", "failed":
+		status.Status = models.StatusFailed
+		if code := r.PostForm.Get("ErrorCode"); code != "" {
+			status.Reason = "Twilio error " + code
+		}
+	case "read":
+		// WhatsApp read receipts
+		status.Status = models.StatusOpened
+	default:
+		// Queued, sending and sent aren't delivery outcomes
+		return nil, nil
+	}
+
+	if status.NotificationID == "" || status.UserID == "" {
+		return nil, fmt.Errorf("%w: status callback URL of message %s doesn't name the notification",
+			ErrInvalid, status.ProviderMessageID)
+	}
+	return []*models.StatusEvent{status}, nil
+}
+
+// signature returns the signature Twilio computes for the callback: the
+// HMAC-SHA1 of the full URL followed by every POST parameter's name and
+// value, sorted by name
+func (t *Twilio) signature(r *http.Request) string {
+	baseURL := t.baseURL
+	if baseURL == "" {
+		baseURL = "https://" + r.Host
+	}
+
+	var data strings.Builder
+	data.WriteString(baseURL + r.URL.RequestURI())
+	names := make([]string, 0, len(r.PostForm))
+	for name := range r.PostForm {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range r.PostForm[name] {
+			data.WriteString(name + value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(t.authToken()))
+	mac.Write([]byte(data.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}